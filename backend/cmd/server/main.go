@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/cmdb/backend/internal/alerting"
 	"github.com/cmdb/backend/internal/api"
 	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/certscan"
 	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/jobs"
+	"github.com/cmdb/backend/internal/ldapauth"
+	"github.com/cmdb/backend/internal/mailer"
+	"github.com/cmdb/backend/internal/notify"
+	"github.com/cmdb/backend/internal/oidc"
+	"github.com/cmdb/backend/internal/passkey"
+	"github.com/cmdb/backend/internal/prober"
+	"github.com/cmdb/backend/internal/recording"
+	"github.com/cmdb/backend/internal/renewal"
+	"github.com/cmdb/backend/internal/secrets"
+	"github.com/cmdb/backend/internal/sshclient"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -50,12 +64,32 @@ func main() {
 
 	// Initialize stores
 	stores := &database.Stores{
-		Users:       database.NewUserStore(db),
-		Servers:     database.NewServerStore(db),
-		Groups:      database.NewGroupStore(db),
-		Permissions: database.NewPermissionStore(db),
-		SSL:         database.NewSSLStore(db),
-		APIKeys:     database.NewAPIKeyStore(db),
+		Users:          database.NewUserStore(db),
+		Servers:        database.NewServerStore(db),
+		Groups:         database.NewGroupStore(db),
+		Permissions:    database.NewPermissionStore(db),
+		SSL:            database.NewSSLStore(db),
+		Ports:          database.NewPortStore(db),
+		Probes:         database.NewProbeResultStore(db),
+		Renewals:       database.NewRenewalStore(db),
+		Alerts:         database.NewAlertStore(db),
+		Policies:       database.NewPolicyStore(db),
+		Notifications:  database.NewNotificationStore(db),
+		Credentials:    database.NewCredentialStore(db),
+		HostKeys:       database.NewHostKeyStore(db),
+		Audit:          database.NewAuditStore(db),
+		Recordings:     database.NewRecordingStore(db),
+		JumpHosts:      database.NewJumpHostStore(db),
+		CommandJobs:    database.NewCommandJobStore(db),
+		Sessions:       database.NewSessionStore(db),
+		GroupMappings:  database.NewGroupMappingStore(db),
+		LoginStates:    database.NewLoginStateStore(db),
+		MFA:            database.NewMFAStore(db),
+		WebAuthn:       database.NewWebAuthnStore(db),
+		LoginAttempts:  database.NewLoginAttemptStore(db),
+		Invites:        database.NewInviteStore(db),
+		PasswordResets: database.NewPasswordResetStore(db),
+		APIKeys:        database.NewAPIKeyStore(db),
 	}
 
 	// Initialize JWT manager
@@ -64,10 +98,149 @@ func main() {
 		jwtSecret = "your-secret-key-change-in-production"
 		log.Println("Warning: Using default JWT secret. Set JWT_SECRET environment variable in production.")
 	}
-	jwtManager := auth.NewJWTManager(jwtSecret)
+	// Access tokens are short-lived and rejected once their session is
+	// revoked; the revocation list is synced in the background below
+	authConfig := auth.ConfigFromEnv()
+	revoked := auth.NewRevocationList(stores.Sessions, authConfig.AccessTTL)
+	jwtManager := auth.NewJWTManager(jwtSecret, authConfig.AccessTTL, revoked)
+
+	// Single sign-on through an OpenID Connect provider (optional)
+	var oidcProvider *oidc.Provider
+	oidcConfig := oidc.ConfigFromEnv()
+	if oidcConfig.Enabled {
+		oidcProvider, err = oidc.New(oidcConfig)
+		if err != nil {
+			log.Fatal("Invalid OIDC configuration:", err)
+		}
+	}
+
+	// Login passwords are checked locally, then against LDAP (optional)
+	authenticators := []auth.Authenticator{auth.NewLocalAuthenticator(stores.Users)}
+	var ldapAuth *ldapauth.Authenticator
+	ldapConfig := ldapauth.ConfigFromEnv()
+	if ldapConfig.Enabled {
+		ldapAuth, err = ldapauth.New(stores, ldapConfig, revoked)
+		if err != nil {
+			log.Fatal("Invalid LDAP configuration:", err)
+		}
+		authenticators = append(authenticators, ldapAuth)
+	}
+
+	// Security keys and passkeys (optional)
+	var passkeys *passkey.Service
+	passkeyConfig := passkey.ConfigFromEnv()
+	if passkeyConfig.Enabled {
+		passkeys, err = passkey.New(passkeyConfig)
+		if err != nil {
+			log.Fatal("Invalid WebAuthn configuration:", err)
+		}
+	}
+
+	// Login and sign-up throttling, shared between replicas through the
+	// database if configured
+	var throttleStore auth.ThrottleStore = auth.NewMemoryThrottleStore()
+	if authConfig.ThrottleStore == auth.ThrottleStorePostgres {
+		throttleStore = stores.LoginAttempts
+	}
+	throttle := auth.NewThrottle(throttleStore, authConfig)
+
+	// Mail for invitations and password resets, logged unless SMTP is
+	// configured
+	mail, err := mailer.New(mailer.ConfigFromEnv())
+	if err != nil {
+		log.Fatal("Invalid mail configuration:", err)
+	}
+	if authConfig.AppURL == "" {
+		log.Println("AUTH_APP_URL is not set, links in emails will be relative")
+	}
+
+	// Encryption for secrets stored at rest (optional)
+	sealer, err := secrets.SealerFromEnv()
+	if err != nil {
+		log.Fatal("Invalid secrets configuration:", err)
+	}
+	if sealer == nil {
+		log.Println("SECRETS_MASTER_KEY not set, private keys cannot be stored")
+	}
+
+	// SSH connections for the web terminal, certificate delivery and probes
+	// of servers behind jump hosts
+	dialer := sshclient.New(stores, sealer, sshclient.ConfigFromEnv())
+
+	// Start background reachability prober
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go revoked.Run(ctx, authConfig.RevocationSync)
+	go throttle.Run(ctx)
+	if ldapAuth != nil {
+		go ldapAuth.Run(ctx)
+	}
+
+	probeConfig := prober.ConfigFromEnv()
+	if probeConfig.Enabled {
+		go prober.New(stores, probeConfig, dialer).Run(ctx)
+	} else {
+		log.Println("Prober disabled via PROBE_ENABLED")
+	}
+
+	// Start live TLS certificate scanner
+	certScanConfig := certscan.ConfigFromEnv()
+	certScanner := certscan.New(stores, certScanConfig)
+	if certScanConfig.Enabled {
+		go certScanner.Run(ctx)
+	} else {
+		log.Println("Certificate scanner disabled via CERT_SCAN_ENABLED")
+	}
+
+	// Start ACME renewal of auto_renew certificates
+	var renewer *renewal.Renewer
+	acmeConfig := renewal.ConfigFromEnv()
+	if acmeConfig.Enabled {
+		renewer, err = renewal.New(stores, acmeConfig, sealer, dialer)
+		if err != nil {
+			log.Fatal("Failed to start ACME renewer:", err)
+		}
+		go renewer.Run(ctx)
+	}
+
+	// Start alert evaluation and Alertmanager push
+	alertConfig := alerting.ConfigFromEnv()
+	alertEngine := alerting.New(stores, alertConfig, sealer)
+
+	// Notification channels are fed by the alert engine
+	notifyConfig := notify.ConfigFromEnv()
+	notifyConfig.ExternalURL = alertConfig.ExternalURL
+	notifier := notify.New(stores, notifyConfig, sealer)
+	alertEngine.AddSink(notifier)
+	dialer.OnHostKeyChange(alertEngine.Trigger)
+	go notifier.Run(ctx)
+
+	if alertConfig.Enabled {
+		go alertEngine.Run(ctx)
+	} else {
+		log.Println("Alert engine disabled via ALERTS_ENABLED")
+	}
+
+	// Record web terminal sessions
+	var recorder *recording.Manager
+	recordingConfig := recording.ConfigFromEnv()
+	if recordingConfig.Enabled {
+		recorder, err = recording.New(stores, recordingConfig)
+		if err != nil {
+			log.Fatal("Failed to start session recording:", err)
+		}
+		go recorder.Run(ctx)
+	} else {
+		log.Println("SSH session recording disabled via RECORDING_ENABLED")
+	}
+
+	// Ad-hoc command jobs
+	jobRunner := jobs.New(stores, dialer, jobs.ConfigFromEnv())
+	go jobRunner.Run(ctx)
 
 	// Initialize API handlers
-	handlers := api.NewHandlers(stores, jwtManager)
+	handlers := api.NewHandlers(stores, jwtManager, certScanner, sealer, renewer, alertEngine, notifier, dialer, recorder, jobRunner, authConfig, revoked, oidcProvider, authenticators, passkeys, throttle, mail)
 
 	// Set up router
 	router := mux.NewRouter()
@@ -75,6 +248,17 @@ func main() {
 	// Public routes
 	router.HandleFunc("/api/auth/signup", handlers.SignUp).Methods("POST")
 	router.HandleFunc("/api/auth/login", handlers.Login).Methods("POST")
+	router.HandleFunc("/api/auth/refresh", handlers.RefreshSession).Methods("POST")
+	router.HandleFunc("/api/auth/oidc/login", handlers.OIDCLogin).Methods("GET")
+	router.HandleFunc("/api/auth/oidc/callback", handlers.OIDCCallback).Methods("GET")
+	router.HandleFunc("/api/auth/mfa/verify", handlers.VerifyMFA).Methods("POST")
+	router.HandleFunc("/api/auth/mfa/enroll", handlers.StartMFAEnrollment).Methods("POST")
+	router.HandleFunc("/api/auth/mfa/enroll/confirm", handlers.ConfirmMFAEnrollment).Methods("POST")
+	router.HandleFunc("/api/auth/webauthn/login/begin", handlers.BeginWebAuthnLogin).Methods("POST")
+	router.HandleFunc("/api/auth/webauthn/login/finish", handlers.FinishWebAuthnLogin).Methods("POST")
+	router.HandleFunc("/api/auth/invite", handlers.GetInvite).Methods("GET")
+	router.HandleFunc("/api/auth/password/forgot", handlers.ForgotPassword).Methods("POST")
+	router.HandleFunc("/api/auth/password/reset", handlers.ResetPassword).Methods("POST")
 	router.HandleFunc("/api/health", handlers.Health).Methods("GET")
 	router.HandleFunc("/metrics", handlers.PrometheusMetrics).Methods("GET") // Prometheus metrics endpoint
 	// Ingest endpoint with API key header
@@ -86,6 +270,29 @@ func main() {
 
 	apiRouter.HandleFunc("/auth/me", handlers.GetCurrentUser).Methods("GET")
 	apiRouter.HandleFunc("/auth/logout", handlers.Logout).Methods("POST")
+	apiRouter.HandleFunc("/auth/logout-all", handlers.LogoutAll).Methods("POST")
+	apiRouter.HandleFunc("/auth/sessions", handlers.ListMySessions).Methods("GET")
+	apiRouter.HandleFunc("/auth/sessions/{id}", handlers.RevokeMySession).Methods("DELETE")
+	apiRouter.HandleFunc("/auth/group-mappings", handlers.ListGroupMappings).Methods("GET")
+	apiRouter.HandleFunc("/auth/group-mappings", handlers.CreateGroupMapping).Methods("POST")
+	apiRouter.HandleFunc("/auth/group-mappings/{id}", handlers.DeleteGroupMapping).Methods("DELETE")
+	apiRouter.HandleFunc("/auth/mfa", handlers.GetMFAStatus).Methods("GET")
+	apiRouter.HandleFunc("/auth/mfa/totp", handlers.EnrollTOTP).Methods("POST")
+	apiRouter.HandleFunc("/auth/mfa/totp/confirm", handlers.ConfirmTOTP).Methods("POST")
+	apiRouter.HandleFunc("/auth/mfa/totp", handlers.DisableTOTP).Methods("DELETE")
+	apiRouter.HandleFunc("/auth/mfa/recovery-codes", handlers.RegenerateRecoveryCodes).Methods("POST")
+	apiRouter.HandleFunc("/auth/mfa/step-up", handlers.StepUpMFA).Methods("POST")
+	apiRouter.HandleFunc("/auth/mfa/policy", handlers.GetMFAPolicy).Methods("GET")
+	apiRouter.HandleFunc("/auth/mfa/policy", handlers.UpdateMFAPolicy).Methods("PUT")
+	apiRouter.HandleFunc("/auth/webauthn/credentials", handlers.ListWebAuthnCredentials).Methods("GET")
+	apiRouter.HandleFunc("/auth/webauthn/credentials/{id}", handlers.DeleteWebAuthnCredential).Methods("DELETE")
+	apiRouter.HandleFunc("/auth/webauthn/register/begin", handlers.BeginWebAuthnRegistration).Methods("POST")
+	apiRouter.HandleFunc("/auth/webauthn/register/finish", handlers.FinishWebAuthnRegistration).Methods("POST")
+	apiRouter.HandleFunc("/auth/webauthn/step-up/begin", handlers.BeginWebAuthnStepUp).Methods("POST")
+	apiRouter.HandleFunc("/auth/webauthn/step-up/finish", handlers.FinishWebAuthnStepUp).Methods("POST")
+	apiRouter.HandleFunc("/auth/lockouts", handlers.ListLockouts).Methods("GET")
+	apiRouter.HandleFunc("/auth/lockouts/{scope}/{subject}", handlers.DeleteLockout).Methods("DELETE")
+	apiRouter.HandleFunc("/auth/password", handlers.ChangePassword).Methods("PUT")
 
 	// User routes
 	apiRouter.HandleFunc("/users", handlers.ListUsers).Methods("GET")
@@ -93,6 +300,17 @@ func main() {
 	apiRouter.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT")
 	apiRouter.HandleFunc("/users/{id}/approve", handlers.ApproveUser).Methods("POST")
 	apiRouter.HandleFunc("/users/{id}/roles", handlers.UpdateUserRoles).Methods("POST")
+	apiRouter.HandleFunc("/users/{id}/sessions", handlers.ListUserSessions).Methods("GET")
+	apiRouter.HandleFunc("/users/{id}/sessions", handlers.TerminateUserSessions).Methods("DELETE")
+	apiRouter.HandleFunc("/users/{id}/sessions/{sessionId}", handlers.TerminateUserSession).Methods("DELETE")
+	apiRouter.HandleFunc("/users/{id}/mfa", handlers.ResetUserMFA).Methods("DELETE")
+	apiRouter.HandleFunc("/users/{id}/unlock", handlers.UnlockUser).Methods("POST")
+	apiRouter.HandleFunc("/users/{id}/password-reset", handlers.SendPasswordReset).Methods("POST")
+
+	// Invite routes
+	apiRouter.HandleFunc("/invites", handlers.ListInvites).Methods("GET")
+	apiRouter.HandleFunc("/invites", handlers.CreateInvite).Methods("POST")
+	apiRouter.HandleFunc("/invites/{id}", handlers.RevokeInvite).Methods("DELETE")
 
 	// Server routes
 	apiRouter.HandleFunc("/servers", handlers.ListServers).Methods("GET")
@@ -100,6 +318,16 @@ func main() {
 	apiRouter.HandleFunc("/servers/{id}", handlers.GetServer).Methods("GET")
 	apiRouter.HandleFunc("/servers/{id}", handlers.UpdateServer).Methods("PUT")
 	apiRouter.HandleFunc("/servers/{id}", handlers.DeleteServer).Methods("DELETE")
+	apiRouter.HandleFunc("/servers/{id}/status-events", handlers.ListServerStatusEvents).Methods("GET")
+	apiRouter.HandleFunc("/servers/{id}/uptime", handlers.GetServerUptime).Methods("GET")
+	apiRouter.HandleFunc("/servers/{id}/discover-certificates", handlers.DiscoverServerCertificates).Methods("POST")
+
+	// Server port routes
+	apiRouter.HandleFunc("/servers/{id}/ports", handlers.ListServerPorts).Methods("GET")
+	apiRouter.HandleFunc("/servers/{id}/ports", handlers.CreateServerPort).Methods("POST")
+	apiRouter.HandleFunc("/servers/{id}/ports/{portId}", handlers.UpdateServerPort).Methods("PUT")
+	apiRouter.HandleFunc("/servers/{id}/ports/{portId}", handlers.DeleteServerPort).Methods("DELETE")
+	apiRouter.HandleFunc("/ports/drift", handlers.ListPortDrift).Methods("GET")
 
 	// Group routes
 	apiRouter.HandleFunc("/groups", handlers.ListGroups).Methods("GET")
@@ -107,6 +335,56 @@ func main() {
 	apiRouter.HandleFunc("/groups/{id}", handlers.GetGroup).Methods("GET")
 	apiRouter.HandleFunc("/groups/{id}", handlers.UpdateGroup).Methods("PUT")
 	apiRouter.HandleFunc("/groups/{id}", handlers.DeleteGroup).Methods("DELETE")
+	apiRouter.HandleFunc("/groups/{id}/uptime", handlers.GetGroupUptime).Methods("GET")
+	apiRouter.HandleFunc("/groups/{id}/alert-policy", handlers.UpdateGroupAlertPolicy).Methods("PUT")
+	apiRouter.HandleFunc("/groups/{id}/alert-policy", handlers.DeleteGroupAlertPolicy).Methods("DELETE")
+
+	// SSH host key routes
+	apiRouter.HandleFunc("/servers/{id}/host-keys", handlers.ListServerHostKeys).Methods("GET")
+	apiRouter.HandleFunc("/servers/{id}/host-keys/scan", handlers.ScanServerHostKey).Methods("POST")
+	apiRouter.HandleFunc("/servers/{id}/host-keys/{keyId}/approve", handlers.ApproveHostKey).Methods("POST")
+	apiRouter.HandleFunc("/servers/{id}/host-keys/{keyId}/reject", handlers.RejectHostKey).Methods("POST")
+	apiRouter.HandleFunc("/servers/{id}/host-keys/{keyId}", handlers.DeleteHostKey).Methods("DELETE")
+	apiRouter.HandleFunc("/host-keys", handlers.ListHostKeys).Methods("GET")
+	apiRouter.HandleFunc("/host-keys/import", handlers.ImportKnownHosts).Methods("POST")
+
+	// SSH jump host chains
+	apiRouter.HandleFunc("/servers/{id}/jump-hosts", handlers.GetServerJumpHosts).Methods("GET")
+	apiRouter.HandleFunc("/servers/{id}/jump-hosts", handlers.UpdateServerJumpHosts).Methods("PUT")
+	apiRouter.HandleFunc("/groups/{id}/jump-hosts", handlers.GetGroupJumpHosts).Methods("GET")
+	apiRouter.HandleFunc("/groups/{id}/jump-hosts", handlers.UpdateGroupJumpHosts).Methods("PUT")
+
+	// SFTP file browser
+	apiRouter.HandleFunc("/servers/{id}/files", handlers.ListFiles).Methods("GET")
+	apiRouter.HandleFunc("/servers/{id}/files", handlers.DeleteFile).Methods("DELETE")
+	apiRouter.HandleFunc("/servers/{id}/files/stat", handlers.StatFile).Methods("GET")
+	apiRouter.HandleFunc("/servers/{id}/files/download", handlers.DownloadFile).Methods("GET")
+	apiRouter.HandleFunc("/servers/{id}/files/upload", handlers.UploadFile).Methods("PUT")
+	apiRouter.HandleFunc("/servers/{id}/files/mkdir", handlers.MakeDirectory).Methods("POST")
+	apiRouter.HandleFunc("/servers/{id}/files/rename", handlers.RenameFile).Methods("POST")
+
+	// Ad-hoc command jobs
+	apiRouter.HandleFunc("/jobs", handlers.ListJobs).Methods("GET")
+	apiRouter.HandleFunc("/jobs", handlers.CreateJob).Methods("POST")
+	apiRouter.HandleFunc("/jobs/{id}", handlers.GetJob).Methods("GET")
+	apiRouter.HandleFunc("/jobs/{id}/cancel", handlers.CancelJob).Methods("POST")
+	apiRouter.HandleFunc("/jobs/{id}/events", handlers.StreamJobEvents).Methods("GET")
+
+	// Audit log (admin only)
+	apiRouter.HandleFunc("/audit-events", handlers.ListAuditEvents).Methods("GET")
+
+	// SSH session recordings (admin only)
+	apiRouter.HandleFunc("/recordings", handlers.ListRecordings).Methods("GET")
+	apiRouter.HandleFunc("/recordings/{id}", handlers.GetRecording).Methods("GET")
+	apiRouter.HandleFunc("/recordings/{id}/download", handlers.DownloadRecording).Methods("GET")
+	apiRouter.HandleFunc("/recordings/{id}/stream", handlers.StreamRecording).Methods("GET")
+
+	// SSH credential vault routes (admin only)
+	apiRouter.HandleFunc("/credentials", handlers.ListCredentials).Methods("GET")
+	apiRouter.HandleFunc("/credentials", handlers.CreateCredential).Methods("POST")
+	apiRouter.HandleFunc("/credentials/{id}", handlers.GetCredential).Methods("GET")
+	apiRouter.HandleFunc("/credentials/{id}", handlers.UpdateCredential).Methods("PUT")
+	apiRouter.HandleFunc("/credentials/{id}", handlers.DeleteCredential).Methods("DELETE")
 
 	// Permission routes
 	apiRouter.HandleFunc("/permissions", handlers.ListPermissions).Methods("GET")
@@ -125,10 +403,38 @@ func main() {
 	apiRouter.HandleFunc("/ssl-certificates/{id}", handlers.GetSSLCertificate).Methods("GET")
 	apiRouter.HandleFunc("/ssl-certificates/{id}", handlers.UpdateSSLCertificate).Methods("PUT")
 	apiRouter.HandleFunc("/ssl-certificates/{id}", handlers.DeleteSSLCertificate).Methods("DELETE")
+	apiRouter.HandleFunc("/ssl-certificates/upload", handlers.UploadSSLCertificate).Methods("POST")
 	apiRouter.HandleFunc("/ssl-certificates/send-alerts", handlers.SendAlertsToAlertmanager).Methods("POST")
+	apiRouter.HandleFunc("/ssl-certificates/scan", handlers.ScanAllSSLCertificates).Methods("POST")
+	apiRouter.HandleFunc("/ssl-certificates/{id}/scan", handlers.ScanSSLCertificate).Methods("POST")
+	apiRouter.HandleFunc("/ssl-certificates/{id}/renewal", handlers.GetSSLRenewal).Methods("GET")
+	apiRouter.HandleFunc("/ssl-certificates/{id}/renewal", handlers.UpdateSSLRenewal).Methods("PUT")
+	apiRouter.HandleFunc("/ssl-certificates/{id}/renewal", handlers.DeleteSSLRenewal).Methods("DELETE")
+	apiRouter.HandleFunc("/ssl-certificates/{id}/renew", handlers.RenewSSLCertificate).Methods("POST")
+	apiRouter.HandleFunc("/ssl-certificates/{id}/alert-policy", handlers.UpdateSSLAlertPolicy).Methods("PUT")
+	apiRouter.HandleFunc("/ssl-certificates/{id}/alert-policy", handlers.DeleteSSLAlertPolicy).Methods("DELETE")
+
+	// Alerting routes (admin only)
+	apiRouter.HandleFunc("/alerts", handlers.ListAlerts).Methods("GET")
+	apiRouter.HandleFunc("/alert-policies", handlers.ListAlertPolicies).Methods("GET")
+	apiRouter.HandleFunc("/alert-policies/global", handlers.UpdateGlobalAlertPolicy).Methods("PUT")
+
+	// Notification routes (admin only)
+	apiRouter.HandleFunc("/notifications/channels", handlers.ListNotificationChannels).Methods("GET")
+	apiRouter.HandleFunc("/notifications/channels", handlers.CreateNotificationChannel).Methods("POST")
+	apiRouter.HandleFunc("/notifications/channels/{id}", handlers.UpdateNotificationChannel).Methods("PUT")
+	apiRouter.HandleFunc("/notifications/channels/{id}", handlers.DeleteNotificationChannel).Methods("DELETE")
+	apiRouter.HandleFunc("/notifications/channels/{id}/test", handlers.TestNotificationChannel).Methods("POST")
+	apiRouter.HandleFunc("/notifications/deliveries", handlers.ListNotificationDeliveries).Methods("GET")
+	apiRouter.HandleFunc("/notifications/deliveries/{id}/retry", handlers.RetryNotificationDelivery).Methods("POST")
+	apiRouter.HandleFunc("/alertmanager/receivers", handlers.ListAlertmanagerReceivers).Methods("GET")
+	apiRouter.HandleFunc("/alertmanager/receivers", handlers.CreateAlertmanagerReceiver).Methods("POST")
+	apiRouter.HandleFunc("/alertmanager/receivers/{id}", handlers.UpdateAlertmanagerReceiver).Methods("PUT")
+	apiRouter.HandleFunc("/alertmanager/receivers/{id}", handlers.DeleteAlertmanagerReceiver).Methods("DELETE")
 
-	// WebSocket route for SSH
+	// WebSocket routes for the SSH terminal and command job output
 	router.HandleFunc("/ws/ssh/{serverId}", handlers.HandleSSH)
+	router.HandleFunc("/ws/jobs/{id}", handlers.HandleJobWebSocket)
 
 	// CORS configuration
 	c := cors.New(cors.Options{
@@ -12,4 +12,20 @@ require (
 	golang.org/x/crypto v0.18.0
 	golang.org/x/net v0.20.0
 	github.com/rs/cors v1.10.1
+	github.com/pkg/sftp v1.13.6
+	github.com/go-ldap/ldap/v3 v3.4.6
+	github.com/go-asn1-ber/asn1-ber v1.5.5
+	github.com/go-webauthn/webauthn v0.10.0
+	software.sslmate.com/src/go-pkcs12 v0.4.0
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/fxamacker/cbor/v2 v2.5.0 // indirect
+	github.com/go-webauthn/x v0.1.6 // indirect
+	github.com/google/go-tpm v0.9.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/mitchellh/mapstructure v1.5.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.16.0 // indirect
 )
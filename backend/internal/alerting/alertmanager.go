@@ -0,0 +1,174 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/secrets"
+)
+
+// Alert is an alert in the Alertmanager API v2 format.
+type Alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       *time.Time        `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Receiver secret fields, used as additional data when sealing.
+const (
+	SecretPassword     = "password"
+	SecretBearerToken  = "bearer_token"
+	SecretTLSClientKey = "tls_client_key"
+)
+
+// SealReceiverSecret encrypts a receiver secret bound to the receiver and field.
+func SealReceiverSecret(sealer *secrets.Sealer, receiverID, field, value string) ([]byte, error) {
+	if sealer == nil {
+		return nil, secrets.ErrNotConfigured
+	}
+	return sealer.Seal([]byte(value), []byte(receiverID+":"+field))
+}
+
+func openReceiverSecret(sealer *secrets.Sealer, receiverID, field string, sealed []byte) (string, error) {
+	if len(sealed) == 0 {
+		return "", nil
+	}
+	if sealer == nil {
+		return "", secrets.ErrNotConfigured
+	}
+	plain, err := sealer.Open(sealed, []byte(receiverID+":"+field))
+	if err != nil {
+		return "", fmt.Errorf("decrypting %s: %w", field, err)
+	}
+	return string(plain), nil
+}
+
+// ToAlertmanager converts alert states for posting. Firing alerts get an
+// EndsAt of now+validFor so Alertmanager resolves them on its own if the
+// dashboard stops sending; resolved alerts carry their real EndsAt.
+func ToAlertmanager(states []*database.AlertState, now time.Time, validFor time.Duration, generatorURL string) []Alert {
+	alerts := make([]Alert, 0, len(states))
+	for _, s := range states {
+		endsAt := now.Add(validFor)
+		if s.EndsAt != nil {
+			endsAt = *s.EndsAt
+		}
+		alerts = append(alerts, Alert{
+			Labels:       s.Labels,
+			Annotations:  s.Annotations,
+			StartsAt:     s.StartsAt,
+			EndsAt:       &endsAt,
+			GeneratorURL: generatorURL,
+		})
+	}
+	return alerts
+}
+
+// alertsURL appends the v2 alerts path unless base already points at it.
+func alertsURL(base string) string {
+	base = strings.TrimRight(base, "/")
+	if strings.HasSuffix(base, "/api/v2/alerts") {
+		return base
+	}
+	return base + "/api/v2/alerts"
+}
+
+// Post sends alerts to an Alertmanager base URL. authorize may add
+// credentials to the request.
+func Post(ctx context.Context, client *http.Client, baseURL string, alerts []Alert, authorize func(*http.Request)) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, alertsURL(baseURL), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if authorize != nil {
+		authorize(req)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("alertmanager returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// sendToReceiver posts alerts using the receiver's TLS and auth settings.
+func sendToReceiver(ctx context.Context, sealer *secrets.Sealer, r *database.AlertmanagerReceiver, alerts []Alert) error {
+	tlsConfig := &tls.Config{InsecureSkipVerify: r.TLSInsecureSkipVerify}
+	if r.TLSCACert != nil && *r.TLSCACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(*r.TLSCACert)) {
+			return errors.New("tls_ca_cert contains no certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if r.TLSClientCert != nil && *r.TLSClientCert != "" {
+		key, err := openReceiverSecret(sealer, r.ID, SecretTLSClientKey, r.EncryptedTLSClientKey)
+		if err != nil {
+			return err
+		}
+		pair, err := tls.X509KeyPair([]byte(*r.TLSClientCert), []byte(key))
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+
+	var authorize func(*http.Request)
+	switch r.AuthType {
+	case "basic":
+		password, err := openReceiverSecret(sealer, r.ID, SecretPassword, r.EncryptedPassword)
+		if err != nil {
+			return err
+		}
+		username := ""
+		if r.Username != nil {
+			username = *r.Username
+		}
+		authorize = func(req *http.Request) { req.SetBasicAuth(username, password) }
+	case "bearer":
+		token, err := openReceiverSecret(sealer, r.ID, SecretBearerToken, r.EncryptedBearerToken)
+		if err != nil {
+			return err
+		}
+		authorize = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	timeout := time.Duration(r.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	// Each push builds its own transport for the receiver's TLS settings, so
+	// keep-alives would leave its idle connections open after it returns.
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true,
+		},
+	}
+	return Post(ctx, client, r.URL, alerts, authorize)
+}
@@ -0,0 +1,203 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/secrets"
+)
+
+func TestToAlertmanager(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	startsAt := now.Add(-time.Hour)
+	endedAt := now.Add(-time.Minute)
+	labels := map[string]string{"alertname": "ServerDown", "severity": SeverityCritical, "server": "web-1"}
+	annotations := map[string]string{"summary": "web-1 is offline"}
+
+	states := []*database.AlertState{
+		newAlert(labels, annotations, startsAt),
+		{Labels: labels, Annotations: annotations, StartsAt: startsAt, EndsAt: &endedAt},
+	}
+	alerts := ToAlertmanager(states, now, 5*time.Minute, "https://cmdb.example.org/alerts")
+
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{
+			"labels":       map[string]interface{}{"alertname": "ServerDown", "severity": "critical", "server": "web-1"},
+			"annotations":  map[string]interface{}{"summary": "web-1 is offline"},
+			"startsAt":     "2026-03-01T11:00:00Z",
+			"endsAt":       "2026-03-01T12:05:00Z",
+			"generatorURL": "https://cmdb.example.org/alerts",
+		},
+		{
+			"labels":       map[string]interface{}{"alertname": "ServerDown", "severity": "critical", "server": "web-1"},
+			"annotations":  map[string]interface{}{"summary": "web-1 is offline"},
+			"startsAt":     "2026-03-01T11:00:00Z",
+			"endsAt":       "2026-03-01T11:59:00Z",
+			"generatorURL": "https://cmdb.example.org/alerts",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("payload = %s\nwant %v", body, want)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint(map[string]string{"alertname": "ServerDown", "server": "web-1"})
+	b := Fingerprint(map[string]string{"server": "web-1", "alertname": "ServerDown"})
+	c := Fingerprint(map[string]string{"alertname": "ServerDown", "server": "web-2"})
+	// Label separators keep "a"+"bc" and "ab"+"c" apart
+	d := Fingerprint(map[string]string{"a": "bc"})
+	e := Fingerprint(map[string]string{"ab": "c"})
+	if a != b {
+		t.Errorf("fingerprint depends on label order: %s != %s", a, b)
+	}
+	if a == c || d == e {
+		t.Error("different label sets share a fingerprint")
+	}
+
+	// Escalating starts a new alert, resolving the warning.
+	warning := Fingerprint(map[string]string{"alertname": AlertSSLExpiring, "severity": SeverityWarning, "certificate_id": "cert-1"})
+	critical := Fingerprint(map[string]string{"alertname": AlertSSLExpiring, "severity": SeverityCritical, "certificate_id": "cert-1"})
+	if warning == critical {
+		t.Error("an escalated alert keeps the fingerprint of its warning")
+	}
+}
+
+func TestPost(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		authorize func(*http.Request)
+		status    int
+		wantAuth  string
+		wantErr   bool
+	}{
+		{name: "base URL", base: "", status: http.StatusOK},
+		{name: "base URL with trailing slash", base: "/", status: http.StatusOK},
+		{name: "full alerts URL", base: "/api/v2/alerts", status: http.StatusOK},
+		{name: "behind a path prefix", base: "/alertmanager", status: http.StatusOK},
+		{
+			name:      "bearer token",
+			authorize: func(r *http.Request) { r.Header.Set("Authorization", "Bearer t0ken") },
+			status:    http.StatusOK,
+			wantAuth:  "Bearer t0ken",
+		},
+		{name: "rejected", status: http.StatusBadRequest, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath, gotAuth, gotType string
+			var gotBody []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath, gotAuth, gotType = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+				gotBody, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			alerts := []Alert{{Labels: map[string]string{"alertname": "Test"}, StartsAt: time.Now()}}
+			err := Post(context.Background(), srv.Client(), srv.URL+tt.base, alerts, tt.authorize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Post() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			wantPath := "/api/v2/alerts"
+			if tt.base == "/alertmanager" {
+				wantPath = "/alertmanager/api/v2/alerts"
+			}
+			if gotPath != wantPath {
+				t.Errorf("path = %s, want %s", gotPath, wantPath)
+			}
+			if gotType != "application/json" {
+				t.Errorf("Content-Type = %s, want application/json", gotType)
+			}
+			if gotAuth != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", gotAuth, tt.wantAuth)
+			}
+			var posted []Alert
+			if err := json.Unmarshal(gotBody, &posted); err != nil || len(posted) != 1 {
+				t.Errorf("body = %s, want a list of one alert", gotBody)
+			}
+		})
+	}
+}
+
+func TestSendToReceiverAuth(t *testing.T) {
+	sealer, err := secrets.NewSealer(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seal := func(id, field, value string) []byte {
+		sealed, err := SealReceiverSecret(sealer, id, field, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	username := "cmdb"
+
+	tests := []struct {
+		name     string
+		receiver database.AlertmanagerReceiver
+		wantAuth string
+		wantErr  bool
+	}{
+		{name: "no auth", receiver: database.AlertmanagerReceiver{ID: "r1", AuthType: "none"}},
+		{
+			name: "basic",
+			receiver: database.AlertmanagerReceiver{ID: "r1", AuthType: "basic", Username: &username,
+				EncryptedPassword: seal("r1", SecretPassword, "s3cret")},
+			wantAuth: "Basic Y21kYjpzM2NyZXQ=",
+		},
+		{
+			name: "bearer",
+			receiver: database.AlertmanagerReceiver{ID: "r1", AuthType: "bearer",
+				EncryptedBearerToken: seal("r1", SecretBearerToken, "t0ken")},
+			wantAuth: "Bearer t0ken",
+		},
+		{
+			// Secrets are bound to their receiver, so a copied one does not decrypt
+			name: "secret sealed for another receiver",
+			receiver: database.AlertmanagerReceiver{ID: "r1", AuthType: "bearer",
+				EncryptedBearerToken: seal("r2", SecretBearerToken, "t0ken")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAuth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAuth = r.Header.Get("Authorization")
+			}))
+			defer srv.Close()
+
+			r := tt.receiver
+			r.URL = srv.URL
+			err := sendToReceiver(context.Background(), sealer, &r, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendToReceiver() error = %v, want error %v", err, tt.wantErr)
+			}
+			if gotAuth != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", gotAuth, tt.wantAuth)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Package alerting evaluates alert rules against the dashboard's state, keeps
+// track of when each alert started and ended, and pushes them to Alertmanager.
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/envconfig"
+	"github.com/cmdb/backend/internal/secrets"
+)
+
+type Config struct {
+	Enabled  bool
+	Interval time.Duration
+	// ResolvedRetention is how long delivered resolved alerts are kept.
+	ResolvedRetention time.Duration
+	// ExternalURL is sent as generatorURL, e.g. the dashboard's address.
+	ExternalURL string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:           true,
+		Interval:          time.Minute,
+		ResolvedRetention: 7 * 24 * time.Hour,
+	}
+}
+
+// ConfigFromEnv reads ALERTS_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Bool("ALERTS_ENABLED", &cfg.Enabled)
+	envconfig.Duration("ALERTS_INTERVAL", &cfg.Interval)
+	cfg.ExternalURL = os.Getenv("ALERTS_EXTERNAL_URL")
+	return cfg
+}
+
+// Sink receives the firing alerts and the resolved alerts not yet delivered
+// after every evaluation, e.g. to send notifications.
+type Sink interface {
+	Notify(ctx context.Context, alerts []*database.AlertState) error
+}
+
+type Engine struct {
+	stores  *database.Stores
+	cfg     Config
+	sealer  *secrets.Sealer
+	sinks   []Sink
+	trigger chan struct{}
+	// mu serialises evaluations so state transitions are not interleaved.
+	mu sync.Mutex
+}
+
+func New(stores *database.Stores, cfg Config, sealer *secrets.Sealer) *Engine {
+	return &Engine{
+		stores:  stores,
+		cfg:     cfg,
+		sealer:  sealer,
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// AddSink registers a sink. It must be called before Run.
+func (e *Engine) AddSink(s Sink) {
+	e.sinks = append(e.sinks, s)
+}
+
+// Run evaluates and pushes alerts on each interval, or sooner when
+// triggered, until ctx is cancelled.
+func (e *Engine) Run(ctx context.Context) {
+	log.Printf("Alert engine started (interval=%s)", e.cfg.Interval)
+
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.Evaluate(ctx); err != nil {
+			log.Println("Alert engine:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Alert engine stopped")
+			return
+		case <-ticker.C:
+		case <-e.trigger:
+		}
+	}
+}
+
+// Trigger requests an evaluation as soon as possible, e.g. after a
+// certificate was deleted, so resolved alerts go out without waiting.
+func (e *Engine) Trigger() {
+	select {
+	case e.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Refresh evaluates the rules and updates the stored alert states, without
+// sending anything. It returns the firing alerts.
+func (e *Engine) Refresh(now time.Time) ([]*database.AlertState, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.refresh(now)
+}
+
+func (e *Engine) refresh(now time.Time) ([]*database.AlertState, error) {
+	firing, err := Collect(e.stores, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rules may produce the same alert twice, e.g. two findings on one chain
+	// certificate; keep the first.
+	seen := make(map[string]bool, len(firing))
+	unique := firing[:0]
+	for _, a := range firing {
+		if !seen[a.Fingerprint] {
+			seen[a.Fingerprint] = true
+			unique = append(unique, a)
+		}
+	}
+	firing = unique
+
+	if err := e.stores.Alerts.Fire(firing, now); err != nil {
+		return nil, fmt.Errorf("storing firing alerts: %w", err)
+	}
+	fingerprints := make([]string, len(firing))
+	for i, a := range firing {
+		fingerprints[i] = a.Fingerprint
+	}
+	if err := e.stores.Alerts.ResolveMissing(fingerprints, now); err != nil {
+		return nil, fmt.Errorf("resolving alerts: %w", err)
+	}
+	return firing, nil
+}
+
+// Evaluate refreshes alert states and pushes firing and newly resolved
+// alerts to every enabled receiver. Resolved alerts are retried until all
+// receivers accepted them.
+func (e *Engine) Evaluate(ctx context.Context) (sent int, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if _, err := e.refresh(now); err != nil {
+		return 0, err
+	}
+
+	pending, err := e.stores.Alerts.ListPending()
+	if err != nil {
+		return 0, err
+	}
+	for _, sink := range e.sinks {
+		if err := sink.Notify(ctx, pending); err != nil {
+			log.Println("Alert engine: sink failed:", err)
+		}
+	}
+
+	receivers, err := e.stores.Alerts.ListReceivers()
+	if err != nil {
+		return 0, err
+	}
+
+	alerts := ToAlertmanager(pending, now, e.ValidFor(), e.cfg.ExternalURL)
+	delivered := true
+	if len(alerts) > 0 {
+		for _, r := range receivers {
+			if !r.Enabled {
+				continue
+			}
+			sendErr := sendToReceiver(ctx, e.sealer, r, alerts)
+			if sendErr != nil {
+				delivered = false
+				log.Printf("Alert engine: sending to %s failed: %v", r.Name, sendErr)
+			}
+			if err := e.stores.Alerts.RecordReceiverResult(r.ID, now, sendErr); err != nil {
+				log.Printf("Alert engine: failed to record result for %s: %v", r.Name, err)
+			}
+		}
+	}
+
+	if delivered && len(pending) > 0 {
+		fingerprints := make([]string, len(pending))
+		for i, a := range pending {
+			fingerprints[i] = a.Fingerprint
+		}
+		if err := e.stores.Alerts.MarkSent(fingerprints, now); err != nil {
+			return 0, err
+		}
+	}
+	if err := e.stores.Alerts.PruneResolved(now.Add(-e.cfg.ResolvedRetention)); err != nil {
+		log.Println("Alert engine: failed to prune resolved alerts:", err)
+	}
+	return len(alerts), nil
+}
+
+// ValidFor is how far in the future EndsAt is set on firing alerts: long
+// enough to survive a few missed pushes.
+func (e *Engine) ValidFor() time.Duration {
+	return 4 * e.cfg.Interval
+}
+
+// ExternalURL is the generatorURL attached to alerts.
+func (e *Engine) ExternalURL() string {
+	return e.cfg.ExternalURL
+}
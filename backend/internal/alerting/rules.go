@@ -0,0 +1,290 @@
+package alerting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+// Alert names raised by the dashboard.
+const (
+	AlertSSLExpiring = "SSLCertificateExpiring"
+	AlertSSLFinding  = "SSLCertificateFinding"
+	AlertServerDown  = "ServerDown"
+	AlertPortDrift   = "PortDrift"
+	// AlertHostKeyChanged fires while a changed SSH host key awaits a decision.
+	AlertHostKeyChanged = "SSHHostKeyChanged"
+)
+
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+// Fingerprint identifies an alert by its labels, like Alertmanager does.
+// Severity is one of them, so an alert that escalates is a new alert: the
+// warning resolves and a critical alert starts, which also reaches channels
+// that only take critical alerts.
+func Fingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\xff%s\xff", k, labels[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+func newAlert(labels, annotations map[string]string, startsAt time.Time) *database.AlertState {
+	return &database.AlertState{
+		Fingerprint: Fingerprint(labels),
+		Alertname:   labels["alertname"],
+		Severity:    labels["severity"],
+		Labels:      labels,
+		Annotations: annotations,
+		StartsAt:    startsAt,
+	}
+}
+
+// Collect evaluates every rule against the current state of the database and
+// returns the alerts that should be firing.
+func Collect(stores *database.Stores, now time.Time) ([]*database.AlertState, error) {
+	var alerts []*database.AlertState
+
+	ssl, err := sslAlerts(stores, now)
+	if err != nil {
+		return nil, fmt.Errorf("ssl rules: %w", err)
+	}
+	alerts = append(alerts, ssl...)
+
+	servers, err := serverAlerts(stores)
+	if err != nil {
+		return nil, fmt.Errorf("server rules: %w", err)
+	}
+	alerts = append(alerts, servers...)
+
+	ports, err := portAlerts(stores)
+	if err != nil {
+		return nil, fmt.Errorf("port rules: %w", err)
+	}
+	alerts = append(alerts, ports...)
+
+	hostKeys, err := hostKeyAlerts(stores)
+	if err != nil {
+		return nil, fmt.Errorf("host key rules: %w", err)
+	}
+	alerts = append(alerts, hostKeys...)
+
+	return alerts, nil
+}
+
+func sslAlerts(stores *database.Stores, now time.Time) ([]*database.AlertState, error) {
+	certificates, err := stores.SSL.List("", true)
+	if err != nil {
+		return nil, err
+	}
+	findings, err := stores.SSL.FindingsByCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := stores.Policies.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []*database.AlertState
+	for _, cert := range certificates {
+		issuer := ""
+		if cert.Issuer != nil {
+			issuer = *cert.Issuer
+		}
+
+		policy := policies.For(cert)
+		daysUntilExpiry := int(cert.ExpiresAt.Sub(now).Hours() / 24)
+
+		var severity, message string
+		switch policy.ExpirySeverity(cert.ExpiresAt, now) {
+		case database.ExpiryExpired:
+			severity = SeverityCritical
+			message = fmt.Sprintf("SSL certificate for %s has EXPIRED", cert.Domain)
+		case database.ExpiryCritical:
+			severity = SeverityCritical
+			message = fmt.Sprintf("SSL certificate for %s expires in %d days", cert.Domain, daysUntilExpiry)
+		case database.ExpiryWarning:
+			severity = SeverityWarning
+			message = fmt.Sprintf("SSL certificate for %s expires in %d days", cert.Domain, daysUntilExpiry)
+		}
+
+		// An expiry turning critical resolves the warning alert and fires
+		// a new one, see Fingerprint.
+		if severity != "" {
+			alerts = append(alerts, newAlert(map[string]string{
+				"alertname":      AlertSSLExpiring,
+				"severity":       severity,
+				"domain":         cert.Domain,
+				"issuer":         issuer,
+				"server_id":      cert.ServerID,
+				"certificate_id": cert.ID,
+			}, map[string]string{
+				"summary":     message,
+				"description": fmt.Sprintf("Certificate issued by %s expires at %s (%s policy: warning at %d days, critical at %d days)", issuer, cert.ExpiresAt.Format("2006-01-02 15:04:05"), policy.Scope, policy.WarningDays, policy.CriticalDays),
+			}, time.Time{}))
+		}
+
+		for _, f := range findings[cert.ID] {
+			alerts = append(alerts, newAlert(map[string]string{
+				"alertname":      AlertSSLFinding,
+				"severity":       f.Severity,
+				"finding":        f.Type,
+				"subject":        f.Subject,
+				"domain":         cert.Domain,
+				"server_id":      cert.ServerID,
+				"certificate_id": cert.ID,
+			}, map[string]string{
+				"summary":     fmt.Sprintf("SSL certificate for %s: %s", cert.Domain, f.Message),
+				"description": fmt.Sprintf("Detected %s on %s, last seen %s", f.Type, cert.Domain, f.LastSeenAt.Format("2006-01-02 15:04:05")),
+			}, f.FirstSeenAt))
+		}
+	}
+	return alerts, nil
+}
+
+func serverAlerts(stores *database.Stores) ([]*database.AlertState, error) {
+	servers, err := stores.Servers.List("", true)
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []*database.AlertState
+	for _, server := range servers {
+		if server.Status != "offline" {
+			continue
+		}
+
+		// The latest status event is when the server went down.
+		var startsAt time.Time
+		if events, err := stores.Servers.ListStatusEvents(server.ID, 1); err == nil && len(events) > 0 && events[0].Status == "offline" {
+			startsAt = events[0].CreatedAt
+		}
+
+		description := "No response to reachability probes"
+		if server.LastSeenAt != nil {
+			description = fmt.Sprintf("Last seen at %s", server.LastSeenAt.Format("2006-01-02 15:04:05"))
+		}
+		alerts = append(alerts, newAlert(map[string]string{
+			"alertname": AlertServerDown,
+			"severity":  SeverityCritical,
+			"server_id": server.ID,
+			"hostname":  server.Hostname,
+			"instance":  server.IPAddress,
+		}, map[string]string{
+			"summary":     fmt.Sprintf("Server %s is down", server.Hostname),
+			"description": description,
+		}, startsAt))
+	}
+	return alerts, nil
+}
+
+func portAlerts(stores *database.Stores) ([]*database.AlertState, error) {
+	ports, err := stores.Ports.ListDrifted("", true)
+	if err != nil {
+		return nil, err
+	}
+	if len(ports) == 0 {
+		return nil, nil
+	}
+
+	servers, err := stores.Servers.ListProbeTargets()
+	if err != nil {
+		return nil, err
+	}
+	hostnames := make(map[string]string, len(servers))
+	for _, s := range servers {
+		hostnames[s.ID] = s.Hostname
+	}
+
+	var alerts []*database.AlertState
+	for _, p := range ports {
+		state := "unknown"
+		if p.LastState != nil {
+			state = *p.LastState
+		}
+		var startsAt time.Time
+		if p.DriftSince != nil {
+			startsAt = *p.DriftSince
+		}
+
+		description := fmt.Sprintf("Expected %s, observed %s", p.ExpectedState, state)
+		if p.LastError != nil && *p.LastError != "" {
+			description += ": " + strings.TrimSpace(*p.LastError)
+		}
+		alerts = append(alerts, newAlert(map[string]string{
+			"alertname":      AlertPortDrift,
+			"severity":       SeverityWarning,
+			"server_id":      p.ServerID,
+			"hostname":       hostnames[p.ServerID],
+			"port":           strconv.Itoa(p.Port),
+			"transport":      p.Transport,
+			"expected_state": p.ExpectedState,
+		}, map[string]string{
+			"summary":     fmt.Sprintf("Port %d/%s on %s is %s", p.Port, p.Transport, hostnames[p.ServerID], state),
+			"description": description,
+		}, startsAt))
+	}
+	return alerts, nil
+}
+
+func hostKeyAlerts(stores *database.Stores) ([]*database.AlertState, error) {
+	keys, err := stores.HostKeys.ListChanged()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	servers, err := stores.Servers.ListProbeTargets()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*database.Server, len(servers))
+	for _, s := range servers {
+		byID[s.ID] = s
+	}
+
+	var alerts []*database.AlertState
+	for _, k := range keys {
+		server, ok := byID[k.ServerID]
+		if !ok {
+			continue
+		}
+		var startsAt time.Time
+		if k.FirstSeenAt != nil {
+			startsAt = *k.FirstSeenAt
+		}
+		alerts = append(alerts, newAlert(map[string]string{
+			"alertname":   AlertHostKeyChanged,
+			"severity":    SeverityCritical,
+			"server_id":   server.ID,
+			"hostname":    server.Hostname,
+			"instance":    server.IPAddress,
+			"fingerprint": k.Fingerprint,
+		}, map[string]string{
+			"summary": fmt.Sprintf("SSH host key of %s changed", server.Hostname),
+			"description": fmt.Sprintf("%s presented %s key %s instead of its trusted key; connections are refused "+
+				"until an admin approves or rejects it", server.Hostname, k.KeyType, k.Fingerprint),
+		}, startsAt))
+	}
+	return alerts, nil
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/cmdb/backend/internal/alerting"
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// alertmanagerReceiverRequest carries secrets in plain text; omitted secrets
+// keep their stored value on update and an empty string clears them.
+type alertmanagerReceiverRequest struct {
+	Name                  string  `json:"name"`
+	URL                   string  `json:"url"`
+	Enabled               *bool   `json:"enabled"`
+	AuthType              string  `json:"auth_type"`
+	Username              *string `json:"username"`
+	Password              *string `json:"password"`
+	BearerToken           *string `json:"bearer_token"`
+	TLSCACert             *string `json:"tls_ca_cert"`
+	TLSClientCert         *string `json:"tls_client_cert"`
+	TLSClientKey          *string `json:"tls_client_key"`
+	TLSInsecureSkipVerify bool    `json:"tls_insecure_skip_verify"`
+	TimeoutSeconds        int     `json:"timeout_seconds"`
+}
+
+// applyReceiverRequest validates the request and copies it onto rcv, sealing secrets
+func (h *Handlers) applyReceiverRequest(req *alertmanagerReceiverRequest, rcv *database.AlertmanagerReceiver) (int, string) {
+	if req.Name == "" {
+		return http.StatusBadRequest, "name is required"
+	}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return http.StatusBadRequest, "url must be an http or https URL"
+	}
+	if req.AuthType == "" {
+		req.AuthType = "none"
+	}
+	if req.AuthType != "none" && req.AuthType != "basic" && req.AuthType != "bearer" {
+		return http.StatusBadRequest, "auth_type must be none, basic or bearer"
+	}
+	if req.TimeoutSeconds <= 0 {
+		req.TimeoutSeconds = 10
+	}
+
+	rcv.Name = req.Name
+	rcv.URL = req.URL
+	rcv.Enabled = req.Enabled == nil || *req.Enabled
+	rcv.AuthType = req.AuthType
+	rcv.Username = req.Username
+	rcv.TLSCACert = req.TLSCACert
+	rcv.TLSClientCert = req.TLSClientCert
+	rcv.TLSInsecureSkipVerify = req.TLSInsecureSkipVerify
+	rcv.TimeoutSeconds = req.TimeoutSeconds
+
+	fields := []struct {
+		value  *string
+		field  string
+		target *[]byte
+	}{
+		{req.Password, alerting.SecretPassword, &rcv.EncryptedPassword},
+		{req.BearerToken, alerting.SecretBearerToken, &rcv.EncryptedBearerToken},
+		{req.TLSClientKey, alerting.SecretTLSClientKey, &rcv.EncryptedTLSClientKey},
+	}
+	for _, s := range fields {
+		if s.value == nil {
+			continue
+		}
+		if *s.value == "" {
+			*s.target = nil
+			continue
+		}
+		if h.sealer == nil {
+			return http.StatusBadRequest, "Encrypted secret store is not configured (set SECRETS_MASTER_KEY)"
+		}
+		sealed, err := alerting.SealReceiverSecret(h.sealer, rcv.ID, s.field, *s.value)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to encrypt " + s.field
+		}
+		*s.target = sealed
+	}
+
+	if rcv.AuthType == "basic" && len(rcv.EncryptedPassword) == 0 {
+		return http.StatusBadRequest, "password is required for basic auth"
+	}
+	if rcv.AuthType == "bearer" && len(rcv.EncryptedBearerToken) == 0 {
+		return http.StatusBadRequest, "bearer_token is required for bearer auth"
+	}
+	if (rcv.TLSClientCert != nil && *rcv.TLSClientCert != "") != (len(rcv.EncryptedTLSClientKey) > 0) {
+		return http.StatusBadRequest, "tls_client_cert and tls_client_key must be set together"
+	}
+	return 0, ""
+}
+
+// ListAlerts returns the currently firing alerts
+func (h *Handlers) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	alerts, err := h.stores.Alerts.ListFiring()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch alerts")
+		return
+	}
+	if alerts == nil {
+		alerts = []*database.AlertState{}
+	}
+
+	respondJSON(w, http.StatusOK, alerts)
+}
+
+func (h *Handlers) ListAlertmanagerReceivers(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	receivers, err := h.stores.Alerts.ListReceivers()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch receivers")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, receivers)
+}
+
+func (h *Handlers) CreateAlertmanagerReceiver(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	var req alertmanagerReceiverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	rcv := &database.AlertmanagerReceiver{ID: uuid.New().String()}
+	if status, msg := h.applyReceiverRequest(&req, rcv); status != 0 {
+		respondError(w, status, msg)
+		return
+	}
+
+	created, err := h.stores.Alerts.CreateReceiver(rcv)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create receiver")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handlers) UpdateAlertmanagerReceiver(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	rcv, err := h.stores.Alerts.GetReceiver(vars["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Receiver not found")
+		return
+	}
+
+	var req alertmanagerReceiverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if status, msg := h.applyReceiverRequest(&req, rcv); status != 0 {
+		respondError(w, status, msg)
+		return
+	}
+
+	if err := h.stores.Alerts.UpdateReceiver(rcv); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update receiver")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Receiver updated successfully"})
+}
+
+func (h *Handlers) DeleteAlertmanagerReceiver(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	if err := h.stores.Alerts.DeleteReceiver(vars["id"]); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete receiver")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Receiver deleted successfully"})
+}
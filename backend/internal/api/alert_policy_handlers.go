@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/gorilla/mux"
+)
+
+type alertPolicyRequest struct {
+	WarningDays  int `json:"warning_days"`
+	CriticalDays int `json:"critical_days"`
+}
+
+// decodeAlertPolicy reads and validates thresholds, responding on failure
+func decodeAlertPolicy(w http.ResponseWriter, r *http.Request) (*alertPolicyRequest, bool) {
+	var req alertPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	if req.CriticalDays < 0 || req.WarningDays < req.CriticalDays {
+		respondError(w, http.StatusBadRequest, "critical_days must be at least 0 and warning_days at least critical_days")
+		return nil, false
+	}
+	return &req, true
+}
+
+// ListAlertPolicies returns the global, group and certificate alert policies
+func (h *Handlers) ListAlertPolicies(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	policies, err := h.stores.Policies.List()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch alert policies")
+		return
+	}
+	if policies == nil {
+		policies = []*database.AlertPolicy{}
+	}
+
+	respondJSON(w, http.StatusOK, policies)
+}
+
+// UpdateGlobalAlertPolicy sets the default expiry thresholds
+func (h *Handlers) UpdateGlobalAlertPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	req, ok := decodeAlertPolicy(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.stores.Policies.SetGlobal(req.WarningDays, req.CriticalDays)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save alert policy")
+		return
+	}
+	h.alerts.Trigger()
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// UpdateGroupAlertPolicy sets the expiry thresholds for certificates of a group's servers
+func (h *Handlers) UpdateGroupAlertPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := h.stores.Groups.GetByID(id); err != nil {
+		respondError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+
+	req, ok := decodeAlertPolicy(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.stores.Policies.SetForGroup(id, req.WarningDays, req.CriticalDays)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save alert policy")
+		return
+	}
+	h.alerts.Trigger()
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// DeleteGroupAlertPolicy reverts a group to the global policy
+func (h *Handlers) DeleteGroupAlertPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	if err := h.stores.Policies.DeleteForGroup(mux.Vars(r)["id"]); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete alert policy")
+		return
+	}
+	h.alerts.Trigger()
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Alert policy deleted successfully"})
+}
+
+// UpdateSSLAlertPolicy overrides the expiry thresholds of one certificate
+func (h *Handlers) UpdateSSLAlertPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := h.stores.SSL.GetByID(id); err != nil {
+		respondError(w, http.StatusNotFound, "Certificate not found")
+		return
+	}
+
+	req, ok := decodeAlertPolicy(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := h.stores.Policies.SetForCertificate(id, req.WarningDays, req.CriticalDays)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save alert policy")
+		return
+	}
+	h.alerts.Trigger()
+
+	respondJSON(w, http.StatusOK, policy)
+}
+
+// DeleteSSLAlertPolicy removes a certificate's override
+func (h *Handlers) DeleteSSLAlertPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := h.stores.Policies.DeleteForCertificate(mux.Vars(r)["id"]); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete alert policy")
+		return
+	}
+	h.alerts.Trigger()
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Alert policy deleted successfully"})
+}
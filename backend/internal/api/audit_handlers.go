@@ -0,0 +1,64 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+)
+
+// recordAudit appends an audit event; failures are logged, not returned, so
+// they never undo the action being audited.
+func (h *Handlers) recordAudit(actorID, action, targetType, targetID string, details map[string]interface{}) {
+	if err := h.stores.Audit.Record(actorID, action, targetType, targetID, details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// ListAuditEvents returns audit events, newest first, filtered by actor_id,
+// action, target_type, target_id and since (RFC 3339)
+func (h *Handlers) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := database.AuditFilter{
+		ActorID:    q.Get("actor_id"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		Limit:      100,
+	}
+	if v := q.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+			return
+		}
+		filter.Since = &since
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			respondError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = n
+	}
+
+	events, err := h.stores.Audit.ListEvents(filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch audit events")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// credentialRequest carries secrets in plain text; omitted secrets keep their
+// stored value on update. Secrets are never included in responses.
+type credentialRequest struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Kind        string  `json:"kind"`
+	Username    *string `json:"username"`
+	PrivateKey  *string `json:"private_key"`
+	Passphrase  *string `json:"passphrase"`
+	Password    *string `json:"password"`
+}
+
+// applyCredentialRequest validates the request and copies it onto c, sealing the secret
+func (h *Handlers) applyCredentialRequest(req *credentialRequest, c *database.Credential) (int, string) {
+	if h.sealer == nil {
+		return http.StatusBadRequest, "Encrypted secret store is not configured (set SECRETS_MASTER_KEY)"
+	}
+	if req.Name == "" {
+		return http.StatusBadRequest, "name is required"
+	}
+
+	var m sshclient.Material
+	if len(c.EncryptedSecret) > 0 {
+		var err error
+		if m, err = sshclient.OpenMaterial(h.sealer, c); err != nil {
+			return http.StatusInternalServerError, "Failed to decrypt credential"
+		}
+	}
+	if req.Kind != c.Kind {
+		// Switching kinds drops the secrets of the old one.
+		m = sshclient.Material{}
+	}
+	for _, s := range []struct {
+		value  *string
+		target *string
+	}{
+		{req.PrivateKey, &m.PrivateKey},
+		{req.Passphrase, &m.Passphrase},
+		{req.Password, &m.Password},
+	} {
+		if s.value != nil {
+			*s.target = *s.value
+		}
+	}
+
+	c.Name = req.Name
+	c.Description = req.Description
+	c.Kind = req.Kind
+	c.Username = req.Username
+	if err := sshclient.Describe(c, m); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+
+	sealed, err := sshclient.SealMaterial(h.sealer, c.ID, m)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to encrypt credential"
+	}
+	c.EncryptedSecret = sealed
+	return 0, ""
+}
+
+func (h *Handlers) ListCredentials(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	credentials, err := h.stores.Credentials.List()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch credentials")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, credentials)
+}
+
+func (h *Handlers) GetCredential(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	c, err := h.stores.Credentials.Get(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Credential not found")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, c)
+}
+
+func (h *Handlers) CreateCredential(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	var req credentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	c := &database.Credential{ID: uuid.New().String(), CreatedBy: &userID}
+	if status, msg := h.applyCredentialRequest(&req, c); status != 0 {
+		respondError(w, status, msg)
+		return
+	}
+
+	created, err := h.stores.Credentials.Create(c)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create credential")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handlers) UpdateCredential(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	c, err := h.stores.Credentials.Get(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Credential not found")
+		return
+	}
+
+	var req credentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if status, msg := h.applyCredentialRequest(&req, c); status != 0 {
+		respondError(w, status, msg)
+		return
+	}
+
+	if err := h.stores.Credentials.Update(c); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Credential not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to update credential")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, c)
+}
+
+func (h *Handlers) DeleteCredential(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	if err := h.stores.Credentials.Delete(mux.Vars(r)["id"]); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete credential")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Credential deleted successfully"})
+}
@@ -72,13 +72,19 @@ func (h *Handlers) UpdateGroup(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var group database.ServerGroup
-	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+	// Decode onto the stored group so fields the client does not send, such
+	// as credential_id, are kept.
+	group, err := h.stores.Groups.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(group); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	err := h.stores.Groups.Update(id, &group)
+	err = h.stores.Groups.Update(id, group)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update group")
 		return
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/passkey"
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditGroupMappingCreated = "auth.group_mapping.created"
+	auditGroupMappingDeleted = "auth.group_mapping.deleted"
+)
+
+// ListGroupMappings returns the identity provider group mappings, optionally
+// filtered by provider
+func (h *Handlers) ListGroupMappings(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	mappings, err := h.stores.GroupMappings.List(r.URL.Query().Get("provider"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch group mappings")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, mappings)
+}
+
+// CreateGroupMapping maps an identity provider group to a role, a server or
+// a server group. Role mappings may need a fresh security key assertion
+func (h *Handlers) CreateGroupMapping(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	var m database.GroupMapping
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	m.ExternalGroup = strings.TrimSpace(m.ExternalGroup)
+	if m.ExternalGroup == "" {
+		respondError(w, http.StatusBadRequest, "external_group is required")
+		return
+	}
+	if m.Provider != database.AuthProviderOIDC && m.Provider != database.AuthProviderLDAP {
+		respondError(w, http.StatusBadRequest, "Unknown provider")
+		return
+	}
+	if m.Role == nil && m.ServerID == nil && m.ServerGroupID == nil {
+		respondError(w, http.StatusBadRequest, "One of role, server_id or server_group_id is required")
+		return
+	}
+	if m.Role != nil && *m.Role != "admin" && *m.Role != "user" {
+		respondError(w, http.StatusBadRequest, "role must be admin or user")
+		return
+	}
+	// Mapping a group to a role changes the roles of its members, and to
+	// admin grants them everything, so it is guarded like UpdateUserRoles
+	if m.Role != nil && !h.requireFreshWebAuthn(w, r, passkey.OpUpdateUserRoles) {
+		return
+	}
+	if m.ServerID != nil {
+		if _, err := h.stores.Servers.GetByID(*m.ServerID); err != nil {
+			respondError(w, http.StatusBadRequest, "Server not found")
+			return
+		}
+	}
+	if m.ServerGroupID != nil {
+		if _, err := h.stores.Groups.GetByID(*m.ServerGroupID); err != nil {
+			respondError(w, http.StatusBadRequest, "Server group not found")
+			return
+		}
+	}
+
+	if err := h.stores.GroupMappings.Create(&m); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create group mapping")
+		return
+	}
+	h.recordAudit(userID, auditGroupMappingCreated, "group_mapping", m.ID, map[string]interface{}{
+		"provider":        m.Provider,
+		"external_group":  m.ExternalGroup,
+		"role":            m.Role,
+		"server_id":       m.ServerID,
+		"server_group_id": m.ServerGroupID,
+	})
+
+	respondJSON(w, http.StatusCreated, m)
+}
+
+// DeleteGroupMapping removes a mapping. Removing a role mapping may need a
+// fresh security key assertion, as it changes the roles of the group's
+// members
+func (h *Handlers) DeleteGroupMapping(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	m, err := h.stores.GroupMappings.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusNotFound, "Group mapping not found")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch group mapping")
+		return
+	}
+	if m.Role != nil && !h.requireFreshWebAuthn(w, r, passkey.OpUpdateUserRoles) {
+		return
+	}
+
+	deleted, err := h.stores.GroupMappings.Delete(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete group mapping")
+		return
+	}
+	if !deleted {
+		respondError(w, http.StatusNotFound, "Group mapping not found")
+		return
+	}
+	h.recordAudit(userID, auditGroupMappingDeleted, "group_mapping", id, map[string]interface{}{
+		"provider":       m.Provider,
+		"external_group": m.ExternalGroup,
+		"role":           m.Role,
+	})
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Group mapping deleted successfully"})
+}
@@ -1,23 +1,74 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
 
+	"github.com/cmdb/backend/internal/alerting"
 	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/certscan"
 	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/jobs"
+	"github.com/cmdb/backend/internal/mailer"
+	"github.com/cmdb/backend/internal/notify"
+	"github.com/cmdb/backend/internal/oidc"
+	"github.com/cmdb/backend/internal/passkey"
+	"github.com/cmdb/backend/internal/recording"
+	"github.com/cmdb/backend/internal/renewal"
+	"github.com/cmdb/backend/internal/secrets"
+	"github.com/cmdb/backend/internal/sshclient"
 	"github.com/gorilla/mux"
 )
 
 type Handlers struct {
-	stores     *database.Stores
-	jwtManager *auth.JWTManager
+	stores      *database.Stores
+	jwtManager  *auth.JWTManager
+	certScanner *certscan.Scanner
+	// sealer encrypts secrets at rest; nil when SECRETS_MASTER_KEY is unset.
+	sealer *secrets.Sealer
+	// renewer is nil unless ACME renewal is enabled.
+	renewer *renewal.Renewer
+	alerts  *alerting.Engine
+	notify  *notify.Dispatcher
+	ssh     *sshclient.Dialer
+	// recordings is nil when session recording is disabled.
+	recordings *recording.Manager
+	jobs       *jobs.Runner
+	authCfg    auth.Config
+	revoked    *auth.RevocationList
+	// oidc is nil unless OIDC login is enabled.
+	oidc *oidc.Provider
+	// authenticators check login passwords, tried in order.
+	authenticators []auth.Authenticator
+	// passkeys is nil unless WebAuthn is enabled.
+	passkeys *passkey.Service
+	throttle *auth.Throttle
+	mailer   mailer.Mailer
 }
 
-func NewHandlers(stores *database.Stores, jwtManager *auth.JWTManager) *Handlers {
+func NewHandlers(stores *database.Stores, jwtManager *auth.JWTManager, certScanner *certscan.Scanner, sealer *secrets.Sealer, renewer *renewal.Renewer, alerts *alerting.Engine, notifier *notify.Dispatcher, dialer *sshclient.Dialer, recordings *recording.Manager, jobRunner *jobs.Runner, authConfig auth.Config, revoked *auth.RevocationList, oidcProvider *oidc.Provider, authenticators []auth.Authenticator, passkeys *passkey.Service, throttle *auth.Throttle, mail mailer.Mailer) *Handlers {
 	return &Handlers{
-		stores:     stores,
-		jwtManager: jwtManager,
+		stores:         stores,
+		jwtManager:     jwtManager,
+		certScanner:    certScanner,
+		sealer:         sealer,
+		renewer:        renewer,
+		alerts:         alerts,
+		notify:         notifier,
+		ssh:            dialer,
+		recordings:     recordings,
+		jobs:           jobRunner,
+		authCfg:        authConfig,
+		revoked:        revoked,
+		oidc:           oidcProvider,
+		authenticators: authenticators,
+		passkeys:       passkeys,
+		throttle:       throttle,
+		mailer:         mail,
 	}
 }
 
@@ -42,6 +93,9 @@ func (h *Handlers) SignUp(w http.ResponseWriter, r *http.Request) {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 		Username string `json:"username"`
+		// InviteToken signs up with an invitation, whatever the sign-up
+		// policy, using the invite's email.
+		InviteToken string `json:"invite_token"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -49,16 +103,41 @@ func (h *Handlers) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := h.clientIP(r)
+	if err := h.throttle.CheckSignUp(ip); err != nil {
+		respondThrottled(w, err)
+		return
+	}
+	if req.InviteToken != "" {
+		h.acceptInvite(w, r, req.InviteToken, req.Username, req.Password)
+		return
+	}
+
 	// Validate input
 	if req.Email == "" || req.Password == "" {
 		respondError(w, http.StatusBadRequest, "Email and password are required")
 		return
 	}
 
+	if !strings.Contains(req.Email, "@") {
+		respondError(w, http.StatusBadRequest, "Invalid email address")
+		return
+	}
+
+	approved, refusal := h.signUpApproval(req.Email)
+	if refusal != "" {
+		respondError(w, http.StatusForbidden, refusal)
+		return
+	}
+
 	username := req.Username
 	if username == "" {
 		username = req.Email
 	}
+	if err := h.authCfg.CheckPassword(req.Password, req.Email, username); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	user, err := h.stores.Users.Create(username, req.Email, req.Password)
 	if err != nil {
@@ -66,24 +145,41 @@ func (h *Handlers) SignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Auto-approve new users
-	approved := true
-	err = h.stores.Users.Update(user.ID, nil, &approved)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to approve user")
+	if approved {
+		err = h.stores.Users.Update(user.ID, nil, &approved)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to approve user")
+			return
+		}
+		user.Approved = true
+	}
+	h.recordAudit(user.ID, auditUserSignedUp, "user", user.ID, map[string]interface{}{
+		"policy":   h.authCfg.SignUpPolicy,
+		"approved": approved,
+		"ip":       ip,
+	})
+
+	// Users awaiting approval cannot log in yet
+	if !approved {
+		respondJSON(w, http.StatusCreated, map[string]interface{}{
+			"user":             user,
+			"pending_approval": true,
+		})
 		return
 	}
-	user.Approved = true
 
-	token, err := h.jwtManager.Generate(user.ID, user.Email)
+	tokens, err := h.startSession(r, user)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
 	respondJSON(w, http.StatusCreated, map[string]interface{}{
-		"user":  user,
-		"token": token,
+		"user":          user,
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+		"session_id":    tokens.SessionID,
 	})
 }
 
@@ -98,36 +194,94 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := h.stores.Users.GetByEmail(req.Email)
-	if err != nil {
-		respondError(w, http.StatusUnauthorized, "Invalid credentials")
+	ip := h.clientIP(r)
+	if err := h.throttle.CheckLogin(ip, req.Email); err != nil {
+		respondThrottled(w, err)
 		return
 	}
 
-	if !h.stores.Users.VerifyPassword(user.Password, req.Password) {
+	user, err := h.authenticate(r.Context(), req.Email, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		h.loginFailed(ip, req.Email)
 		respondError(w, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
+	if err != nil {
+		respondError(w, http.StatusServiceUnavailable, "Authentication service unavailable")
+		return
+	}
+	if err := h.throttle.LoginSucceeded(req.Email); err != nil {
+		log.Printf("Failed to clear failed logins of %s: %v", user.ID, err)
+	}
 
 	if !user.Approved {
 		respondError(w, http.StatusForbidden, "Your account is pending approval")
 		return
 	}
 
-	token, err := h.jwtManager.Generate(user.ID, user.Email)
+	// Users with a second factor, or whose role requires one, get a
+	// challenge to complete at /auth/mfa instead of a session
+	challenge, err := h.mfaChallenge(user)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to check MFA")
+		return
+	}
+	if challenge != nil {
+		respondJSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	resp, err := h.loginResponse(r, user, false)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// loginResponse starts a session for user and returns the tokens with the
+// user and their roles. stepUp marks the session as having proven a second
+// factor.
+func (h *Handlers) loginResponse(r *http.Request, user *database.User, stepUp bool) (map[string]interface{}, error) {
+	tokens, err := h.startSession(r, user)
+	if err != nil {
+		return nil, err
+	}
+	if stepUp {
+		if err := h.stores.Sessions.MarkStepUp(tokens.SessionID); err != nil {
+			return nil, err
+		}
+	}
+
 	// Get user roles
 	roles, _ := h.stores.Users.GetRoles(user.ID)
 
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"user":  user,
-		"token": token,
-		"roles": roles,
-	})
+	return map[string]interface{}{
+		"user":          user,
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_at":    tokens.ExpiresAt,
+		"session_id":    tokens.SessionID,
+		"roles":         roles,
+	}, nil
+}
+
+// authenticate tries each authenticator in turn. It reports a failure to
+// reach one only if no other accepts the login.
+func (h *Handlers) authenticate(ctx context.Context, login, password string) (*database.User, error) {
+	err := auth.ErrInvalidCredentials
+	for _, a := range h.authenticators {
+		user, aerr := a.Authenticate(ctx, login, password)
+		if aerr == nil {
+			return user, nil
+		}
+		if !errors.Is(aerr, auth.ErrInvalidCredentials) {
+			log.Printf("%s authentication failed: %v", a.Name(), aerr)
+			err = aerr
+		}
+	}
+	return nil, err
 }
 
 func (h *Handlers) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
@@ -146,10 +300,6 @@ func (h *Handlers) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
-}
-
 // User handlers
 func (h *Handlers) ListUsers(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserID(r.Context())
@@ -237,6 +387,14 @@ func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Unapproved users are logged out everywhere
+	if req.Approved != nil && !*req.Approved {
+		if _, err := h.revokeUserSessions(id, database.SessionUserDisabled); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+	}
+
 	respondJSON(w, http.StatusOK, map[string]string{"message": "User updated successfully"})
 }
 
@@ -270,6 +428,9 @@ func (h *Handlers) UpdateUserRoles(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusForbidden, "Admin access required")
 		return
 	}
+	if !h.requireFreshWebAuthn(w, r, passkey.OpUpdateUserRoles) {
+		return
+	}
 
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ssh"
+)
+
+const maxKnownHostsSize = 1 << 20
+
+// validHostKeyMode checks a server's host_key_mode, nil meaning the default.
+func validHostKeyMode(mode *string) bool {
+	return mode == nil || *mode == "" || *mode == database.HostKeyModeTOFU || *mode == database.HostKeyModeStrict
+}
+
+// ListServerHostKeys returns the known host keys of a server with their fingerprints
+func (h *Handlers) ListServerHostKeys(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	serverID := mux.Vars(r)["id"]
+	if !isAdmin {
+		hasAccess, _ := h.stores.Permissions.HasAccess(userID, serverID)
+		if !hasAccess {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+	}
+
+	keys, err := h.stores.HostKeys.ListForServer(serverID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch host keys")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// ScanServerHostKey connects to a server to fetch its host key, trusting it on
+// first use or recording it for approval
+func (h *Handlers) ScanServerHostKey(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	server, err := h.stores.Servers.GetByID(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	scanErr := h.ssh.ScanHostKey(ctx, server)
+	var hkErr *sshclient.HostKeyError
+	if scanErr != nil && !errors.As(scanErr, &hkErr) {
+		respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch host key: %v", scanErr))
+		return
+	}
+
+	keys, err := h.stores.HostKeys.ListForServer(server.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch host keys")
+		return
+	}
+
+	resp := map[string]interface{}{"trusted": scanErr == nil, "host_keys": keys}
+	if scanErr != nil {
+		resp["error"] = scanErr.Error()
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// ListHostKeys returns host keys across servers by status, pending by default
+func (h *Handlers) ListHostKeys(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = database.HostKeyPending
+	}
+
+	keys, err := h.stores.HostKeys.ListByStatus(status)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch host keys")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// serverHostKey loads a host key and checks that it belongs to the server in the path
+func (h *Handlers) serverHostKey(w http.ResponseWriter, r *http.Request) (*database.HostKey, bool) {
+	vars := mux.Vars(r)
+	key, err := h.stores.HostKeys.Get(vars["keyId"])
+	if err != nil || key.ServerID != vars["id"] {
+		respondError(w, http.StatusNotFound, "Host key not found")
+		return nil, false
+	}
+	return key, true
+}
+
+// ApproveHostKey trusts a pending or rejected key. replace, which defaults to
+// true for changed keys, removes the trusted keys of the same type.
+func (h *Handlers) ApproveHostKey(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	key, ok := h.serverHostKey(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Replace *bool `json:"replace"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	replace := key.Changed
+	if req.Replace != nil {
+		replace = *req.Replace
+	}
+
+	if err := h.stores.HostKeys.Approve(key.ID, userID, replace, time.Now()); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to approve host key")
+		return
+	}
+	h.recordAudit(userID, sshclient.AuditHostKeyApproved, "server", key.ServerID, map[string]interface{}{
+		"fingerprint": key.Fingerprint, "key_type": key.KeyType, "replace": replace,
+	})
+	h.alerts.Trigger()
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Host key approved"})
+}
+
+// RejectHostKey keeps refusing a key without alerting on it again
+func (h *Handlers) RejectHostKey(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	key, ok := h.serverHostKey(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.stores.HostKeys.Reject(key.ID, userID, time.Now()); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Host key not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to reject host key")
+		return
+	}
+	h.recordAudit(userID, sshclient.AuditHostKeyRejected, "server", key.ServerID, map[string]interface{}{
+		"fingerprint": key.Fingerprint, "key_type": key.KeyType,
+	})
+	h.alerts.Trigger()
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Host key rejected"})
+}
+
+func (h *Handlers) DeleteHostKey(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	key, ok := h.serverHostKey(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.stores.HostKeys.Delete(key.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete host key")
+		return
+	}
+	h.recordAudit(userID, sshclient.AuditHostKeyDeleted, "server", key.ServerID, map[string]interface{}{
+		"fingerprint": key.Fingerprint, "key_type": key.KeyType, "status": key.Status,
+	})
+	h.alerts.Trigger()
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Host key deleted successfully"})
+}
+
+// ImportKnownHosts trusts the keys of an OpenSSH known_hosts file, sent as the
+// request body, for every server whose IP address or hostname (with its SSH
+// port) it lists. server_id restricts the import to one server.
+func (h *Handlers) ImportKnownHosts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxKnownHostsSize))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "known_hosts file is too large")
+		return
+	}
+
+	var servers []*database.Server
+	if serverID := r.URL.Query().Get("server_id"); serverID != "" {
+		server, err := h.stores.Servers.GetByID(serverID)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "Server not found")
+			return
+		}
+		servers = []*database.Server{server}
+	} else if servers, err = h.stores.Servers.ListProbeTargets(); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch servers")
+		return
+	}
+
+	entries, skipped := sshclient.ParseKnownHosts(data)
+	now := time.Now()
+	imported := 0
+	matchedServers := map[string]bool{}
+	var unmatched []string
+	for _, entry := range entries {
+		matched := false
+		for _, server := range servers {
+			if !entry.Matches(server) {
+				continue
+			}
+			matched = true
+			key := &database.HostKey{
+				ServerID:    server.ID,
+				KeyType:     entry.Key.Type(),
+				PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(entry.Key))),
+				Fingerprint: ssh.FingerprintSHA256(entry.Key),
+				Source:      database.HostKeySourceImport,
+			}
+			if err := h.stores.HostKeys.Trust(key, userID, now); err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to store host key")
+				return
+			}
+			h.recordAudit(userID, sshclient.AuditHostKeyImported, "server", server.ID, map[string]interface{}{
+				"fingerprint": key.Fingerprint, "key_type": key.KeyType,
+			})
+			matchedServers[server.ID] = true
+			imported++
+		}
+		if !matched {
+			unmatched = append(unmatched, "line "+strconv.Itoa(entry.Line))
+		}
+	}
+	if imported > 0 {
+		h.alerts.Trigger()
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"imported":  imported,
+		"servers":   len(matchedServers),
+		"unmatched": nonNil(unmatched),
+		"skipped":   nonNil(skipped),
+	})
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/mailer"
+	"github.com/cmdb/backend/internal/passkey"
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditInviteCreated  = "user.invite.created"
+	auditInviteRevoked  = "user.invite.revoked"
+	auditInviteAccepted = "user.invite.accepted"
+)
+
+// Frontend pages that emailed links open.
+const (
+	invitePath        = "/auth/invite"
+	passwordResetPath = "/auth/reset-password"
+)
+
+// appLink returns the frontend link for a one-time token.
+func (h *Handlers) appLink(path, token string) string {
+	return h.authCfg.AppURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendMail delivers a message within the mailer's timeout, logging
+// failures.
+func (h *Handlers) sendMail(ctx context.Context, msg *mailer.Message) error {
+	err := h.mailer.Send(ctx, msg)
+	if err != nil {
+		log.Printf("Failed to send %q to %s: %v", msg.Subject, msg.To, err)
+	}
+	return err
+}
+
+type inviteResponse struct {
+	*database.Invite
+	// URL is returned once, when the invite is created, so that it can be
+	// passed on if the email does not arrive.
+	URL       string `json:"invite_url"`
+	EmailSent bool   `json:"email_sent"`
+}
+
+// CreateInvite invites someone to sign up with roles and access to servers
+// and emails them a link. Inviting an admin is a role change and may need
+// a fresh security key assertion.
+func (h *Handlers) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	var req struct {
+		Email     string   `json:"email"`
+		Roles     []string `json:"roles"`
+		ServerIDs []string `json:"server_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if !strings.Contains(req.Email, "@") {
+		respondError(w, http.StatusBadRequest, "A valid email is required")
+		return
+	}
+	for _, role := range req.Roles {
+		if role != "admin" && role != "user" {
+			respondError(w, http.StatusBadRequest, "Roles must be admin or user")
+			return
+		}
+		if role == "admin" && !h.requireFreshWebAuthn(w, r, passkey.OpUpdateUserRoles) {
+			return
+		}
+	}
+	for _, id := range req.ServerIDs {
+		if _, err := h.stores.Servers.GetByID(id); err != nil {
+			respondError(w, http.StatusBadRequest, "Unknown server "+id)
+			return
+		}
+	}
+	if _, err := h.stores.Users.GetByEmail(req.Email); err == nil {
+		respondError(w, http.StatusConflict, "A user with this email already exists")
+		return
+	}
+
+	inv := &database.Invite{
+		Email:     req.Email,
+		Roles:     req.Roles,
+		ServerIDs: req.ServerIDs,
+		InvitedBy: &userID,
+		ExpiresAt: time.Now().Add(h.authCfg.InviteTTL),
+	}
+	if inv.Roles == nil {
+		inv.Roles = []string{}
+	}
+	if inv.ServerIDs == nil {
+		inv.ServerIDs = []string{}
+	}
+	if err := h.stores.Invites.Create(inv); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create invite")
+		return
+	}
+	token, err := h.jwtManager.GenerateOneTimeToken(auth.InviteAudience, inv.ID, inv.ExpiresAt)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate invite token")
+		return
+	}
+	link := h.appLink(invitePath, token)
+
+	sent := h.sendMail(r.Context(), &mailer.Message{
+		To:      inv.Email,
+		Subject: "You have been invited to CMDB",
+		Body: fmt.Sprintf("You have been invited to the CMDB dashboard. Open this link to choose a username and password:\n\n%s\n\nThe link can be used once and expires on %s.\n",
+			link, inv.ExpiresAt.Format("2006-01-02 15:04 MST")),
+	}) == nil
+	h.recordAudit(userID, auditInviteCreated, "invite", inv.ID, map[string]interface{}{
+		"email":      inv.Email,
+		"roles":      inv.Roles,
+		"server_ids": inv.ServerIDs,
+		"email_sent": sent,
+	})
+
+	respondJSON(w, http.StatusCreated, inviteResponse{Invite: inv, URL: link, EmailSent: sent})
+}
+
+// ListInvites returns all invites, newest first
+func (h *Handlers) ListInvites(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	invites, err := h.stores.Invites.List()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch invites")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, invites)
+}
+
+// RevokeInvite withdraws a pending invite
+func (h *Handlers) RevokeInvite(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	revoked, err := h.stores.Invites.Revoke(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to revoke invite")
+		return
+	}
+	if !revoked {
+		respondError(w, http.StatusNotFound, "No pending invite found")
+		return
+	}
+	h.recordAudit(userID, auditInviteRevoked, "invite", id, nil)
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Invite revoked"})
+}
+
+// pendingInvite returns the pending invite a token was issued for.
+func (h *Handlers) pendingInvite(w http.ResponseWriter, token string) *database.Invite {
+	id, err := h.jwtManager.VerifyOneTimeToken(token, auth.InviteAudience)
+	if err == nil {
+		var inv *database.Invite
+		if inv, err = h.stores.Invites.Get(id); err == nil && inv.Pending(time.Now()) {
+			return inv
+		}
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to look up invite: %v", err)
+	}
+	respondError(w, http.StatusBadRequest, "Invalid or expired invitation")
+	return nil
+}
+
+// GetInvite returns who an invite token is for, so the sign-up page can
+// show it
+func (h *Handlers) GetInvite(w http.ResponseWriter, r *http.Request) {
+	inv := h.pendingInvite(w, r.URL.Query().Get("token"))
+	if inv == nil {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"email":      inv.Email,
+		"roles":      inv.Roles,
+		"expires_at": inv.ExpiresAt,
+	})
+}
+
+// acceptInvite signs up the invited user of token, approved and with the
+// invite's roles and server permissions, and logs them in.
+func (h *Handlers) acceptInvite(w http.ResponseWriter, r *http.Request, token, username, password string) {
+	inv := h.pendingInvite(w, token)
+	if inv == nil {
+		return
+	}
+	if username == "" {
+		username = inv.Email
+	}
+	if err := h.authCfg.CheckPassword(password, inv.Email, username); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := h.stores.Users.GetByEmail(inv.Email); err == nil {
+		respondError(w, http.StatusConflict, "A user with this email already exists")
+		return
+	}
+
+	user, err := h.stores.Invites.Accept(inv.ID, username, password)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusBadRequest, "Invalid or expired invitation")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+	h.recordAudit(user.ID, auditInviteAccepted, "invite", inv.ID, map[string]interface{}{
+		"roles":      inv.Roles,
+		"server_ids": inv.ServerIDs,
+		"ip":         h.clientIP(r),
+	})
+
+	// An invited admin whose role requires MFA enrolls before getting a
+	// session
+	challenge, err := h.mfaChallenge(user)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to check MFA")
+		return
+	}
+	if challenge != nil {
+		respondJSON(w, http.StatusCreated, challenge)
+		return
+	}
+
+	resp, err := h.loginResponse(r, user, false)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, resp)
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/jobs"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	auditJobCreated   = "command_job.created"
+	auditJobCancelled = "command_job.cancelled"
+
+	maxJobCommand = 16 << 10
+	// jobHeartbeat keeps idle event streams open through proxies.
+	jobHeartbeat = 30 * time.Second
+)
+
+type jobRequest struct {
+	Command        string   `json:"command"`
+	ServerIDs      []string `json:"server_ids"`
+	GroupIDs       []string `json:"group_ids"`
+	Tags           []string `json:"tags"`
+	Concurrency    int      `json:"concurrency"`
+	TimeoutSeconds int      `json:"timeout_seconds"`
+}
+
+type jobResponse struct {
+	*database.CommandJob
+	Hosts []*database.CommandJobHost `json:"hosts"`
+}
+
+// jobForUser loads the job named in the URL if userID started it or is an
+// admin, writing the error response and returning nil otherwise.
+func (h *Handlers) jobForUser(w http.ResponseWriter, userID, jobID string) *database.CommandJob {
+	job, err := h.stores.CommandJobs.GetJob(jobID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "Job not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to fetch job")
+		}
+		return nil
+	}
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+	if !isAdmin && (job.CreatedBy == nil || *job.CreatedBy != userID) {
+		respondError(w, http.StatusForbidden, "Access denied")
+		return nil
+	}
+	return job
+}
+
+// CreateJob runs a command on the servers matching server_ids, group_ids and
+// tags. Non-admins can only target servers they have access to.
+func (h *Handlers) CreateJob(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	var req jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(req.Command) == "" || len(req.Command) > maxJobCommand {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("command is required and may be at most %d bytes", maxJobCommand))
+		return
+	}
+	if len(req.ServerIDs)+len(req.GroupIDs)+len(req.Tags) == 0 {
+		respondError(w, http.StatusBadRequest, "At least one of server_ids, group_ids or tags is required")
+		return
+	}
+
+	concurrency, maxConcurrency, timeout, maxTimeout := h.jobs.Limits()
+	if req.Concurrency == 0 {
+		req.Concurrency = concurrency
+	}
+	if req.TimeoutSeconds == 0 {
+		req.TimeoutSeconds = int(timeout / time.Second)
+	}
+	if req.Concurrency < 1 || req.Concurrency > maxConcurrency {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("concurrency must be between 1 and %d", maxConcurrency))
+		return
+	}
+	if req.TimeoutSeconds < 1 || time.Duration(req.TimeoutSeconds)*time.Second > maxTimeout {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("timeout_seconds must be between 1 and %d", int(maxTimeout/time.Second)))
+		return
+	}
+
+	selector := database.JobSelector{
+		ServerIDs: nonNil(req.ServerIDs),
+		GroupIDs:  nonNil(req.GroupIDs),
+		Tags:      nonNil(req.Tags),
+	}
+	servers, err := h.stores.Servers.ListSelected(selector, userID, isAdmin)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to resolve servers")
+		return
+	}
+	// Servers named explicitly must all be reachable to the caller; groups
+	// and tags silently match only the servers they may use.
+	selected := map[string]bool{}
+	for _, s := range servers {
+		selected[s.ID] = true
+	}
+	for _, id := range selector.ServerIDs {
+		if !selected[id] {
+			respondError(w, http.StatusForbidden, fmt.Sprintf("Access denied to server %s", id))
+			return
+		}
+	}
+	if len(servers) == 0 {
+		respondError(w, http.StatusBadRequest, "No servers match the selector")
+		return
+	}
+	// Jobs run commands over SSH, under the same step-up policy as the
+	// terminal
+	if !h.requireSSHStepUp(w, r) {
+		return
+	}
+
+	job := &database.CommandJob{
+		Command:        req.Command,
+		Selector:       selector,
+		CreatedBy:      &userID,
+		Concurrency:    req.Concurrency,
+		TimeoutSeconds: req.TimeoutSeconds,
+	}
+	if err := h.jobs.Start(job, servers); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start job")
+		return
+	}
+	h.recordAudit(userID, auditJobCreated, "command_job", job.ID, map[string]interface{}{
+		"command": job.Command,
+		"hosts":   job.TotalHosts,
+	})
+
+	respondJSON(w, http.StatusAccepted, job)
+}
+
+// ListJobs returns command jobs, newest first: all of them for admins, the
+// caller's own otherwise
+func (h *Handlers) ListJobs(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	filter := database.CommandJobFilter{CreatedBy: userID, Limit: 100}
+	if isAdmin {
+		filter.CreatedBy = r.URL.Query().Get("created_by")
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			respondError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = n
+	}
+
+	list, err := h.stores.CommandJobs.ListJobs(filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch jobs")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, list)
+}
+
+// GetJob returns a job with the exit code and output of every host
+func (h *Handlers) GetJob(w http.ResponseWriter, r *http.Request) {
+	job := h.jobForUser(w, auth.GetUserID(r.Context()), mux.Vars(r)["id"])
+	if job == nil {
+		return
+	}
+
+	hosts, err := h.stores.CommandJobs.ListHosts(job.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch job hosts")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, jobResponse{CommandJob: job, Hosts: hosts})
+}
+
+// CancelJob stops a running job
+func (h *Handlers) CancelJob(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	job := h.jobForUser(w, userID, mux.Vars(r)["id"])
+	if job == nil {
+		return
+	}
+
+	if !h.jobs.Cancel(job.ID) {
+		respondError(w, http.StatusConflict, "Job is not running")
+		return
+	}
+	h.recordAudit(userID, auditJobCancelled, "command_job", job.ID, nil)
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Job cancelled"})
+}
+
+// StreamJobEvents sends a job's progress as server-sent events: the output so
+// far, then live output until the job finishes
+func (h *Handlers) StreamJobEvents(w http.ResponseWriter, r *http.Request) {
+	job := h.jobForUser(w, auth.GetUserID(r.Context()), mux.Vars(r)["id"])
+	if job == nil {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	send := func(e jobs.Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+			return err
+		}
+		flush()
+		return nil
+	}
+	heartbeat := func() error {
+		if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+			return err
+		}
+		flush()
+		return nil
+	}
+	h.streamJob(r.Context(), job, send, heartbeat)
+}
+
+// HandleJobWebSocket sends a job's progress as JSON WebSocket messages, like
+// StreamJobEvents. Browsers cannot set headers on WebSockets, so the token
+// is a query parameter as for the SSH terminal.
+func (h *Handlers) HandleJobWebSocket(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.jwtManager.Verify(r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	job := h.jobForUser(w, claims.UserID, mux.Vars(r)["id"])
+	if job == nil {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade error:", err)
+		return
+	}
+	tc := newTerminalConn(conn, false)
+	defer tc.close()
+
+	// The client only ever closes the connection; reading notices that.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(e jobs.Event) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return tc.write(websocket.TextMessage, data)
+	}
+	heartbeat := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+	}
+	h.streamJob(ctx, job, send, heartbeat)
+}
+
+// streamJob sends the events of job until it has finished, ctx is done or
+// sending fails. Jobs that are not running here are replayed from the store.
+func (h *Handlers) streamJob(ctx context.Context, job *database.CommandJob, send func(jobs.Event) error, heartbeat func() error) {
+	snapshot, events, cancel, ok := h.jobs.Subscribe(job.ID)
+	if !ok {
+		if job.Status == database.JobRunning {
+			// It may have finished since it was loaded.
+			if j, err := h.stores.CommandJobs.GetJob(job.ID); err == nil {
+				job = j
+			}
+		}
+		hosts, err := h.stores.CommandJobs.ListHosts(job.ID)
+		if err != nil {
+			log.Printf("Failed to fetch hosts of job %s: %v", job.ID, err)
+			return
+		}
+		for _, host := range hosts {
+			for _, e := range jobs.HostEvents(host) {
+				if send(e) != nil {
+					return
+				}
+			}
+		}
+		if job.FinishedAt != nil {
+			send(jobs.JobFinishedEvent(job))
+		}
+		return
+	}
+	defer cancel()
+
+	for _, e := range snapshot {
+		if send(e) != nil {
+			return
+		}
+	}
+	ticker := time.NewTicker(jobHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if heartbeat() != nil {
+				return
+			}
+		case e, open := <-events:
+			if !open {
+				return
+			}
+			if send(e) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient"
+	"github.com/gorilla/mux"
+)
+
+const auditJumpHostsUpdated = "ssh.jump_hosts.updated"
+
+type jumpHostsRequest struct {
+	JumpHostIDs []string `json:"jump_host_ids"`
+}
+
+// jumpHop is a jump host as shown in a chain. Only the ID is filled in for
+// hops the caller has no access to.
+type jumpHop struct {
+	ID        string `json:"id"`
+	Hostname  string `json:"hostname,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+	SSHPort   int    `json:"ssh_port,omitempty"`
+}
+
+func newJumpHop(s *database.Server) jumpHop {
+	return jumpHop{ID: s.ID, Hostname: s.Hostname, IPAddress: s.IPAddress, SSHPort: s.SSHPort}
+}
+
+// decodeJumpHosts reads and validates a chain, responding on failure. self is
+// the server the chain is for, if any, which cannot be its own jump host.
+func (h *Handlers) decodeJumpHosts(w http.ResponseWriter, r *http.Request, self string) ([]string, bool) {
+	var req jumpHostsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return nil, false
+	}
+	switch err := sshclient.ValidateChain(self, req.JumpHostIDs); {
+	case errors.Is(err, sshclient.ErrChainTooLong):
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("A chain has at most %d jump hosts", sshclient.MaxJumpHosts))
+		return nil, false
+	case errors.Is(err, sshclient.ErrSelfJumpHost):
+		respondError(w, http.StatusBadRequest, "A server cannot be its own jump host")
+		return nil, false
+	case errors.Is(err, sshclient.ErrRepeatedJumpHost):
+		respondError(w, http.StatusBadRequest, "A jump host can appear only once in a chain")
+		return nil, false
+	}
+	for _, id := range req.JumpHostIDs {
+		if _, err := h.stores.Servers.GetByID(id); err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Jump host %s not found", id))
+			return nil, false
+		}
+	}
+	return nonNil(req.JumpHostIDs), true
+}
+
+// GetServerJumpHosts returns the server's own jump hosts and the chain used to
+// reach it, which is its group's when it has none
+func (h *Handlers) GetServerJumpHosts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	id := mux.Vars(r)["id"]
+	if !isAdmin {
+		hasAccess, _ := h.stores.Permissions.HasAccess(userID, id)
+		if !hasAccess {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+	}
+
+	server, err := h.stores.Servers.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+	own, err := h.stores.JumpHosts.ForServer(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch jump hosts")
+		return
+	}
+	chain, err := h.ssh.JumpChain(server)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch jump hosts")
+		return
+	}
+
+	hops := []jumpHop{}
+	for _, hop := range chain {
+		if !isAdmin {
+			if hasAccess, _ := h.stores.Permissions.HasAccess(userID, hop.ID); !hasAccess {
+				hops = append(hops, jumpHop{ID: hop.ID})
+				continue
+			}
+		}
+		hops = append(hops, newJumpHop(hop))
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"jump_host_ids":        own,
+		"chain":                hops,
+		"inherited_from_group": len(own) == 0 && len(hops) > 0,
+	})
+}
+
+// UpdateServerJumpHosts sets the server's jump hosts; an empty list falls back
+// to its group's
+func (h *Handlers) UpdateServerJumpHosts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := h.stores.Servers.GetByID(id); err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+	ids, ok := h.decodeJumpHosts(w, r, id)
+	if !ok {
+		return
+	}
+
+	if err := h.stores.JumpHosts.SetForServer(id, ids); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save jump hosts")
+		return
+	}
+	h.recordAudit(userID, auditJumpHostsUpdated, "server", id, map[string]interface{}{"jump_host_ids": ids})
+
+	respondJSON(w, http.StatusOK, jumpHostsRequest{JumpHostIDs: ids})
+}
+
+// GetGroupJumpHosts returns the jump hosts of a group's servers
+func (h *Handlers) GetGroupJumpHosts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := h.stores.Groups.GetByID(id); err != nil {
+		respondError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+
+	ids, err := h.stores.JumpHosts.ForGroup(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch jump hosts")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, jumpHostsRequest{JumpHostIDs: ids})
+}
+
+// UpdateGroupJumpHosts sets the jump hosts used by the group's servers that
+// have none of their own
+func (h *Handlers) UpdateGroupJumpHosts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := h.stores.Groups.GetByID(id); err != nil {
+		respondError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+	ids, ok := h.decodeJumpHosts(w, r, "")
+	if !ok {
+		return
+	}
+
+	if err := h.stores.JumpHosts.SetForGroup(id, ids); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save jump hosts")
+		return
+	}
+	h.recordAudit(userID, auditJumpHostsUpdated, "group", id, map[string]interface{}{"jump_host_ids": ids})
+
+	respondJSON(w, http.StatusOK, jumpHostsRequest{JumpHostIDs: ids})
+}
@@ -0,0 +1,714 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/mfa"
+	"github.com/cmdb/backend/internal/passkey"
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditMFAEnabled               = "auth.mfa.enabled"
+	auditMFADisabled              = "auth.mfa.disabled"
+	auditMFAReset                 = "auth.mfa.reset"
+	auditMFAFailed                = "auth.mfa.failed"
+	auditRecoveryCodeUsed         = "auth.mfa.recovery_code_used"
+	auditRecoveryCodesRegenerated = "auth.mfa.recovery_codes_regenerated"
+	auditMFAPolicyUpdated         = "auth.mfa.policy_updated"
+)
+
+// Second factor methods, as recorded in audit events.
+const (
+	mfaMethodTOTP         = "totp"
+	mfaMethodRecoveryCode = "recovery_code"
+)
+
+var (
+	errMFAInvalid = errors.New("invalid code")
+	errMFALocked  = errors.New("second factor locked")
+)
+
+// Second factor methods offered in an MFA challenge.
+const (
+	mfaChallengeTOTP     = "totp"
+	mfaChallengeWebAuthn = "webauthn"
+)
+
+// mfaChallengeResponse is returned by Login in place of a session when a
+// second factor is needed. Enrolled is false when the user's role requires
+// MFA but they have not set it up yet.
+type mfaChallengeResponse struct {
+	MFARequired bool      `json:"mfa_required"`
+	MFAToken    string    `json:"mfa_token"`
+	Enrolled    bool      `json:"mfa_enrolled"`
+	Methods     []string  `json:"methods"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type mfaStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at"`
+	Pending           bool       `json:"pending"`
+	Required          bool       `json:"required"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	// StepUpUntil is when the current session's last second factor stops
+	// counting for step-up checks.
+	StepUpUntil *time.Time `json:"step_up_until"`
+}
+
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+func totpAD(userID string) []byte {
+	return []byte("totp:" + userID)
+}
+
+// mfaEnabled reports whether the user has confirmed a TOTP enrollment.
+func (h *Handlers) mfaEnabled(userID string) (bool, error) {
+	t, err := h.stores.MFA.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return t.EnabledAt != nil, nil
+}
+
+// mfaMethods returns the second factors the user has set up.
+func (h *Handlers) mfaMethods(userID string) ([]string, error) {
+	methods := []string{}
+	totp, err := h.mfaEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp {
+		methods = append(methods, mfaChallengeTOTP)
+	}
+	if h.passkeys != nil {
+		webauthn, err := h.stores.WebAuthn.HasCredentials(userID)
+		if err != nil {
+			return nil, err
+		}
+		if webauthn {
+			methods = append(methods, mfaChallengeWebAuthn)
+		}
+	}
+	return methods, nil
+}
+
+// mfaChallenge returns the challenge a user must complete after their
+// password, or nil if they need no second factor.
+func (h *Handlers) mfaChallenge(user *database.User) (*mfaChallengeResponse, error) {
+	methods, err := h.mfaMethods(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	required, err := h.stores.MFA.IsRequired(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	enabled := len(methods) > 0
+	if !enabled && !required {
+		return nil, nil
+	}
+
+	token, err := h.jwtManager.GenerateChallenge(user.ID, h.authCfg.MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &mfaChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		Enrolled:    enabled,
+		Methods:     methods,
+		ExpiresAt:   time.Now().Add(h.authCfg.MFAChallengeTTL),
+	}, nil
+}
+
+// checkSecondFactor verifies a TOTP code or, failing that, uses up a
+// recovery code, and returns which it was. Wrong codes count towards
+// locking the user's second factor.
+func (h *Handlers) checkSecondFactor(userID, code string) (string, error) {
+	t, err := h.stores.MFA.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && t.EnabledAt == nil) {
+		return "", errMFAInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	if t.LockedUntil != nil && time.Now().Before(*t.LockedUntil) {
+		return "", errMFALocked
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == mfa.Digits {
+		if h.sealer == nil {
+			return "", errors.New("secrets master key is not configured")
+		}
+		secret, err := h.sealer.Open(t.EncryptedSecret, totpAD(userID))
+		if err != nil {
+			return "", err
+		}
+		if step, ok := mfa.Verify(secret, code, time.Now(), t.LastUsedStep); ok {
+			// Losing the race against a concurrent use of the same code
+			// counts as a replay
+			if used, err := h.stores.MFA.UseTOTPStep(userID, step); err != nil {
+				return "", err
+			} else if used {
+				return mfaMethodTOTP, nil
+			}
+		}
+	} else if code != "" {
+		used, err := h.stores.MFA.UseRecoveryCode(userID, mfa.HashRecoveryCode(code))
+		if err != nil {
+			return "", err
+		}
+		if used {
+			h.recordAudit(userID, auditRecoveryCodeUsed, "user", userID, nil)
+			return mfaMethodRecoveryCode, nil
+		}
+	}
+
+	if err := h.stores.MFA.RecordFailure(userID, h.authCfg.MFAMaxAttempts, time.Now().Add(h.authCfg.MFALockout)); err != nil {
+		log.Printf("Failed to record MFA failure of %s: %v", userID, err)
+	}
+	h.recordAudit(userID, auditMFAFailed, "user", userID, nil)
+	return "", errMFAInvalid
+}
+
+// respondSecondFactorError maps errors of checkSecondFactor to responses.
+func respondSecondFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errMFAInvalid):
+		respondError(w, http.StatusUnauthorized, "Invalid verification code")
+	case errors.Is(err, errMFALocked):
+		respondError(w, http.StatusTooManyRequests, "Too many invalid codes, try again later")
+	default:
+		respondError(w, http.StatusInternalServerError, "Failed to verify code")
+	}
+}
+
+// startTOTP generates and stores an unconfirmed TOTP secret for user.
+func (h *Handlers) startTOTP(w http.ResponseWriter, user *database.User) {
+	if h.sealer == nil {
+		respondError(w, http.StatusBadRequest, "Encrypted secret store is not configured (set SECRETS_MASTER_KEY)")
+		return
+	}
+
+	secret, err := mfa.NewSecret()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate secret")
+		return
+	}
+	sealed, err := h.sealer.Seal(secret, totpAD(user.ID))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to encrypt secret")
+		return
+	}
+	started, err := h.stores.MFA.StartTOTP(user.ID, sealed)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start enrollment")
+		return
+	}
+	if !started {
+		respondError(w, http.StatusConflict, "MFA is already enabled")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, totpEnrollment{
+		Secret: mfa.EncodeSecret(secret),
+		URI:    mfa.KeyURI(h.authCfg.MFAIssuer, user.Email, secret),
+	})
+}
+
+// confirmTOTP enables a pending TOTP enrollment with a code from the app
+// and returns the user's new recovery codes. It writes the error response
+// and returns nil on failure.
+func (h *Handlers) confirmTOTP(w http.ResponseWriter, userID, code string) []string {
+	t, err := h.stores.MFA.GetTOTP(userID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && t.EnabledAt != nil) {
+		respondError(w, http.StatusConflict, "No MFA enrollment is pending")
+		return nil
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load enrollment")
+		return nil
+	}
+	if h.sealer == nil {
+		respondError(w, http.StatusBadRequest, "Encrypted secret store is not configured (set SECRETS_MASTER_KEY)")
+		return nil
+	}
+	secret, err := h.sealer.Open(t.EncryptedSecret, totpAD(userID))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to decrypt secret")
+		return nil
+	}
+	step, ok := mfa.Verify(secret, code, time.Now(), 0)
+	if !ok {
+		respondError(w, http.StatusUnauthorized, "Invalid verification code")
+		return nil
+	}
+
+	codes, hashes, err := mfa.NewRecoveryCodes()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return nil
+	}
+	if err := h.stores.MFA.EnableTOTP(userID, step, hashes); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to enable MFA")
+		return nil
+	}
+	h.recordAudit(userID, auditMFAEnabled, "user", userID, map[string]interface{}{"method": mfaMethodTOTP})
+	return codes
+}
+
+// challengeUser returns the approved user of an MFA challenge token.
+func (h *Handlers) challengeUser(w http.ResponseWriter, token string) *database.User {
+	userID, err := h.jwtManager.VerifyChallenge(token)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "Invalid or expired MFA token")
+		return nil
+	}
+	user, err := h.stores.Users.GetByID(userID)
+	if err != nil || !user.Approved {
+		respondError(w, http.StatusUnauthorized, "Account is not active")
+		return nil
+	}
+	return user
+}
+
+// allowLoginEnrollment checks that a user holding only a challenge token,
+// that is a password, may set up a second factor: their role requires one
+// and they have none yet. Anyone with a factor must sign in with it and add
+// more from a stepped-up session.
+func (h *Handlers) allowLoginEnrollment(w http.ResponseWriter, user *database.User) bool {
+	methods, err := h.mfaMethods(user.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to check MFA")
+		return false
+	}
+	if len(methods) > 0 {
+		respondError(w, http.StatusConflict, "MFA is already enabled, sign in with your second factor")
+		return false
+	}
+	required, err := h.stores.MFA.IsRequired(user.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to check MFA policy")
+		return false
+	}
+	if !required {
+		respondError(w, http.StatusForbidden, "Set up MFA after signing in")
+		return false
+	}
+	return true
+}
+
+// requireStepUpForNewFactor checks that a user who already has a second
+// factor proved one in this session within StepUpTTL before adding
+// another, so that a stolen password or session cannot add its own. It
+// writes a 403 naming the missing step-up otherwise.
+func (h *Handlers) requireStepUpForNewFactor(w http.ResponseWriter, r *http.Request) bool {
+	methods, err := h.mfaMethods(auth.GetUserID(r.Context()))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to check MFA")
+		return false
+	}
+	if len(methods) == 0 || h.hasStepUp(auth.GetSessionID(r.Context())) {
+		return true
+	}
+	respondJSON(w, http.StatusForbidden, map[string]interface{}{
+		"error":   "Verify your existing second factor before adding another",
+		"step_up": "mfa",
+		"methods": methods,
+	})
+	return false
+}
+
+// VerifyMFA completes a login with the challenge token from Login and a
+// TOTP or recovery code
+func (h *Handlers) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user := h.challengeUser(w, req.MFAToken)
+	if user == nil {
+		return
+	}
+	if _, err := h.checkSecondFactor(user.ID, req.Code); err != nil {
+		respondSecondFactorError(w, err)
+		return
+	}
+
+	resp, err := h.loginResponse(r, user, true)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+	left, _ := h.stores.MFA.RecoveryCodesLeft(user.ID)
+	resp["recovery_codes_left"] = left
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// StartMFAEnrollment starts TOTP enrollment for a user whose role requires
+// MFA and who has no second factor yet, authenticated by the challenge
+// token from Login
+func (h *Handlers) StartMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user := h.challengeUser(w, req.MFAToken)
+	if user == nil || !h.allowLoginEnrollment(w, user) {
+		return
+	}
+	h.startTOTP(w, user)
+}
+
+// ConfirmMFAEnrollment enables TOTP for a user enrolling during login and
+// completes the login
+func (h *Handlers) ConfirmMFAEnrollment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user := h.challengeUser(w, req.MFAToken)
+	if user == nil || !h.allowLoginEnrollment(w, user) {
+		return
+	}
+	codes := h.confirmTOTP(w, user.ID, req.Code)
+	if codes == nil {
+		return
+	}
+
+	resp, err := h.loginResponse(r, user, true)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+	resp["recovery_codes"] = codes
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// GetMFAStatus returns the caller's second factor setup
+func (h *Handlers) GetMFAStatus(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	status := mfaStatus{}
+	t, err := h.stores.MFA.GetTOTP(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch MFA status")
+		return
+	}
+	if err == nil {
+		status.Enabled = t.EnabledAt != nil
+		status.EnabledAt = t.EnabledAt
+		status.Pending = t.EnabledAt == nil
+	}
+	if status.Required, err = h.stores.MFA.IsRequired(userID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch MFA status")
+		return
+	}
+	if status.RecoveryCodesLeft, err = h.stores.MFA.RecoveryCodesLeft(userID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch MFA status")
+		return
+	}
+	if at, err := h.stores.Sessions.StepUpAt(auth.GetSessionID(r.Context())); err == nil && at != nil {
+		until := at.Add(h.authCfg.StepUpTTL)
+		if time.Now().Before(until) {
+			status.StepUpUntil = &until
+		}
+	}
+
+	respondJSON(w, http.StatusOK, status)
+}
+
+// EnrollTOTP starts TOTP enrollment and returns the secret for the
+// authenticator app. Users with a security key must have verified it
+// recently
+func (h *Handlers) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	if !h.requireStepUpForNewFactor(w, r) {
+		return
+	}
+	user, err := h.stores.Users.GetByID(auth.GetUserID(r.Context()))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	h.startTOTP(w, user)
+}
+
+// ConfirmTOTP enables TOTP with a code from the app and returns recovery
+// codes, which are shown only once
+func (h *Handlers) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !h.requireStepUpForNewFactor(w, r) {
+		return
+	}
+
+	codes := h.confirmTOTP(w, auth.GetUserID(r.Context()), req.Code)
+	if codes == nil {
+		return
+	}
+	if err := h.stores.Sessions.MarkStepUp(auth.GetSessionID(r.Context())); err != nil {
+		log.Printf("Failed to mark step-up: %v", err)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// DisableTOTP removes the caller's second factor after checking a current
+// code, unless their role requires MFA
+func (h *Handlers) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// A registered security key keeps the user's role requirement met
+	required, err := h.stores.MFA.IsRequired(userID)
+	if err == nil && required && h.passkeys != nil {
+		var webauthn bool
+		webauthn, err = h.stores.WebAuthn.HasCredentials(userID)
+		required = !webauthn
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to check MFA policy")
+		return
+	}
+	if required {
+		respondError(w, http.StatusForbidden, "MFA is required for your role")
+		return
+	}
+	if _, err := h.checkSecondFactor(userID, req.Code); err != nil {
+		respondSecondFactorError(w, err)
+		return
+	}
+	if _, err := h.stores.MFA.DeleteTOTP(userID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to disable MFA")
+		return
+	}
+	h.recordAudit(userID, auditMFADisabled, "user", userID, nil)
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "MFA disabled"})
+}
+
+// RegenerateRecoveryCodes replaces the caller's recovery codes after
+// checking a current code
+func (h *Handlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if _, err := h.checkSecondFactor(userID, req.Code); err != nil {
+		respondSecondFactorError(w, err)
+		return
+	}
+	codes, hashes, err := mfa.NewRecoveryCodes()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+	if err := h.stores.MFA.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to store recovery codes")
+		return
+	}
+	h.recordAudit(userID, auditRecoveryCodesRegenerated, "user", userID, nil)
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// StepUpMFA proves a second factor for the current session, as required
+// before sensitive operations
+func (h *Handlers) StepUpMFA(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if _, err := h.checkSecondFactor(userID, req.Code); err != nil {
+		respondSecondFactorError(w, err)
+		return
+	}
+	if err := h.stores.Sessions.MarkStepUp(auth.GetSessionID(r.Context())); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to record step-up")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"step_up_until": time.Now().Add(h.authCfg.StepUpTTL)})
+}
+
+// hasStepUp reports whether the session proved a second factor within
+// StepUpTTL.
+func (h *Handlers) hasStepUp(sessionID string) bool {
+	at, err := h.stores.Sessions.StepUpAt(sessionID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to check step-up of session %s: %v", sessionID, err)
+		}
+		return false
+	}
+	return at != nil && time.Since(*at) < h.authCfg.StepUpTTL
+}
+
+// sshStepUp returns the step-up the session still has to make before using
+// SSH on servers, whether through the terminal, command jobs or file
+// transfers, with its message, or "" if it needs none.
+func (h *Handlers) sshStepUp(sessionID string) (kind, msg string) {
+	if h.authCfg.StepUpSSH && !h.hasStepUp(sessionID) {
+		return "mfa", "Step-up authentication required"
+	}
+	if !h.hasFreshWebAuthn(sessionID, passkey.OpSSH) {
+		return "webauthn", "A fresh security key or passkey verification is required"
+	}
+	return "", ""
+}
+
+// requireSSHStepUp checks sshStepUp for the request's session. It writes a
+// 403 naming the missing step-up otherwise.
+func (h *Handlers) requireSSHStepUp(w http.ResponseWriter, r *http.Request) bool {
+	kind, msg := h.sshStepUp(auth.GetSessionID(r.Context()))
+	if kind == "" {
+		return true
+	}
+	respondJSON(w, http.StatusForbidden, map[string]string{"error": msg, "step_up": kind})
+	return false
+}
+
+// GetMFAPolicy returns the roles whose members must use MFA
+func (h *Handlers) GetMFAPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	roles, err := h.stores.MFA.RequiredRoles()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch MFA policy")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"required_roles": roles})
+}
+
+// UpdateMFAPolicy sets the roles whose members must use MFA. Members
+// without a second factor must enroll at their next login.
+func (h *Handlers) UpdateMFAPolicy(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	var req struct {
+		RequiredRoles []string `json:"required_roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	for _, role := range req.RequiredRoles {
+		if role != "admin" && role != "user" {
+			respondError(w, http.StatusBadRequest, "Roles must be admin or user")
+			return
+		}
+	}
+
+	if err := h.stores.MFA.SetRequiredRoles(req.RequiredRoles); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update MFA policy")
+		return
+	}
+	h.recordAudit(userID, auditMFAPolicyUpdated, "", "", map[string]interface{}{"required_roles": req.RequiredRoles})
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "MFA policy updated"})
+}
+
+// ResetUserMFA removes a user's second factors, their authenticator app and
+// security keys, e.g. after they lost their device; they enroll again at
+// their next login if their role requires it
+func (h *Handlers) ResetUserMFA(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	deleted, err := h.stores.MFA.DeleteTOTP(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to reset MFA")
+		return
+	}
+	keys, err := h.stores.WebAuthn.DeleteByUser(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to reset MFA")
+		return
+	}
+	if !deleted && keys == 0 {
+		respondError(w, http.StatusNotFound, "User has no MFA enrollment")
+		return
+	}
+	h.recordAudit(userID, auditMFAReset, "user", id, map[string]interface{}{
+		"totp":          deleted,
+		"webauthn_keys": keys,
+	})
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "MFA reset"})
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/notify"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// notificationChannelRequest carries secrets in plain text; omitted secrets
+// keep their stored value on update and an empty string clears them.
+type notificationChannelRequest struct {
+	Name          string                        `json:"name"`
+	Type          string                        `json:"type"`
+	Enabled       *bool                         `json:"enabled"`
+	Settings      database.NotificationSettings `json:"settings"`
+	Password      *string                       `json:"password"`
+	SigningSecret *string                       `json:"signing_secret"`
+	WebhookURL    *string                       `json:"webhook_url"`
+	Severities    []string                      `json:"severities"`
+	GroupIDs      []string                      `json:"group_ids"`
+	Tags          []string                      `json:"tags"`
+	SendResolved  *bool                         `json:"send_resolved"`
+	TitleTemplate *string                       `json:"title_template"`
+	BodyTemplate  *string                       `json:"body_template"`
+}
+
+// applyChannelRequest validates the request and copies it onto ch, sealing secrets
+func (h *Handlers) applyChannelRequest(req *notificationChannelRequest, ch *database.NotificationChannel) (int, string) {
+	if req.Name == "" {
+		return http.StatusBadRequest, "name is required"
+	}
+
+	current, err := notify.OpenSecrets(h.sealer, ch)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to decrypt channel secrets"
+	}
+	for _, s := range []struct {
+		value  *string
+		target *string
+	}{
+		{req.Password, &current.Password},
+		{req.SigningSecret, &current.SigningSecret},
+		{req.WebhookURL, &current.WebhookURL},
+	} {
+		if s.value != nil {
+			*s.target = *s.value
+		}
+	}
+
+	ch.Name = req.Name
+	ch.Type = req.Type
+	ch.Enabled = req.Enabled == nil || *req.Enabled
+	ch.Settings = req.Settings
+	ch.Severities = nonNil(req.Severities)
+	ch.GroupIDs = nonNil(req.GroupIDs)
+	ch.Tags = nonNil(req.Tags)
+	ch.SendResolved = req.SendResolved == nil || *req.SendResolved
+	ch.TitleTemplate = req.TitleTemplate
+	ch.BodyTemplate = req.BodyTemplate
+
+	if err := notify.Validate(ch, current); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+
+	sealed, err := notify.SealSecrets(h.sealer, ch.ID, current)
+	if err != nil {
+		if h.sealer == nil {
+			return http.StatusBadRequest, "Encrypted secret store is not configured (set SECRETS_MASTER_KEY)"
+		}
+		return http.StatusInternalServerError, "Failed to encrypt channel secrets"
+	}
+	ch.EncryptedSecrets = sealed
+	return 0, ""
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+func (h *Handlers) ListNotificationChannels(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	channels, err := h.stores.Notifications.ListChannels()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch notification channels")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, channels)
+}
+
+func (h *Handlers) CreateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	var req notificationChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	ch := &database.NotificationChannel{ID: uuid.New().String()}
+	if status, msg := h.applyChannelRequest(&req, ch); status != 0 {
+		respondError(w, status, msg)
+		return
+	}
+
+	created, err := h.stores.Notifications.CreateChannel(ch)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create notification channel")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handlers) UpdateNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	ch, err := h.stores.Notifications.GetChannel(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Notification channel not found")
+		return
+	}
+
+	var req notificationChannelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if status, msg := h.applyChannelRequest(&req, ch); status != 0 {
+		respondError(w, status, msg)
+		return
+	}
+
+	if err := h.stores.Notifications.UpdateChannel(ch); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update notification channel")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Notification channel updated successfully"})
+}
+
+func (h *Handlers) DeleteNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	if err := h.stores.Notifications.DeleteChannel(mux.Vars(r)["id"]); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete notification channel")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Notification channel deleted successfully"})
+}
+
+// TestNotificationChannel sends a sample notification through a channel
+func (h *Handlers) TestNotificationChannel(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	ch, err := h.stores.Notifications.GetChannel(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Notification channel not found")
+		return
+	}
+
+	if err := h.notify.Test(r.Context(), ch); err != nil {
+		respondError(w, http.StatusBadGateway, fmt.Sprintf("Test notification failed: %v", err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Test notification sent"})
+}
+
+// ListNotificationDeliveries returns the delivery log, optionally filtered by channel_id and state
+func (h *Handlers) ListNotificationDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	q := r.URL.Query()
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			respondError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := h.stores.Notifications.ListDeliveries(q.Get("channel_id"), q.Get("state"), limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch notification deliveries")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, deliveries)
+}
+
+// RetryNotificationDelivery requeues a sent or failed delivery
+func (h *Handlers) RetryNotificationDelivery(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	if err := h.stores.Notifications.Retry(mux.Vars(r)["id"], time.Now()); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "Delivery not found or already pending")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to requeue delivery")
+		return
+	}
+	h.notify.Trigger()
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Delivery requeued"})
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/oidc"
+)
+
+const (
+	auditOIDCLogin       = "auth.oidc.login"
+	auditUserProvisioned = "user.provisioned"
+	auditUserLinked      = "user.linked"
+)
+
+// oidcLoginTTL bounds how long a user may take to sign in at the provider.
+const oidcLoginTTL = 10 * time.Minute
+
+// Error codes passed to the frontend in the fragment of the success URL.
+const (
+	oidcErrInvalidRequest  = "invalid_request"
+	oidcErrLoginExpired    = "login_expired"
+	oidcErrLoginFailed     = "login_failed"
+	oidcErrEmailTaken      = "email_taken"
+	oidcErrPendingApproval = "pending_approval"
+	oidcErrServer          = "server_error"
+)
+
+var (
+	errOIDCNoEmail    = errors.New("identity has no email address")
+	errOIDCEmailTaken = errors.New("email address belongs to another account")
+)
+
+// localPath reports whether p is a path on this site, so that redirecting
+// to it after login cannot send the user elsewhere.
+func localPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
+}
+
+// OIDCLogin redirects the browser to the identity provider. The optional
+// redirect parameter is the local path to return to after login.
+func (h *Handlers) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		respondError(w, http.StatusNotFound, "OIDC login is not enabled")
+		return
+	}
+
+	st := &database.LoginState{ExpiresAt: time.Now().Add(oidcLoginTTL)}
+	if redirect := r.URL.Query().Get("redirect"); redirect != "" {
+		if !localPath(redirect) {
+			respondError(w, http.StatusBadRequest, "redirect must be a local path")
+			return
+		}
+		st.RedirectTo = &redirect
+	}
+
+	state, err := oidc.RandomString()
+	if err == nil {
+		st.Nonce, err = oidc.RandomString()
+	}
+	if err == nil {
+		st.CodeVerifier, err = oidc.RandomString()
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	authURL, err := h.oidc.AuthCodeURL(r.Context(), state, st.Nonce, st.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC login: %v", err)
+		respondError(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+	if err := h.stores.LoginStates.Create(auth.HashToken(state), st); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a login at the identity provider. The browser is
+// sent to the success URL with the session tokens, an MFA challenge or an
+// error code in the URL fragment.
+func (h *Handlers) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		respondError(w, http.StatusNotFound, "OIDC login is not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	state, code := q.Get("state"), q.Get("code")
+	if state == "" {
+		h.oidcFail(w, r, oidcErrInvalidRequest)
+		return
+	}
+	st, err := h.stores.LoginStates.Consume(auth.HashToken(state))
+	if errors.Is(err, sql.ErrNoRows) {
+		h.oidcFail(w, r, oidcErrLoginExpired)
+		return
+	}
+	if err != nil {
+		h.oidcFail(w, r, oidcErrServer)
+		return
+	}
+	// The provider reports errors such as access_denied in place of a code
+	if e := q.Get("error"); e != "" || code == "" {
+		log.Printf("OIDC login: provider returned %q: %s", e, q.Get("error_description"))
+		h.oidcFail(w, r, oidcErrLoginFailed)
+		return
+	}
+
+	identity, err := h.oidc.Exchange(r.Context(), code, st.CodeVerifier, st.Nonce)
+	if err != nil {
+		log.Printf("OIDC login: %v", err)
+		h.oidcFail(w, r, oidcErrLoginFailed)
+		return
+	}
+
+	user, err := h.oidcUser(identity)
+	if errors.Is(err, errOIDCNoEmail) || errors.Is(err, errOIDCEmailTaken) {
+		log.Printf("OIDC login of %q: %v", identity.Subject, err)
+		h.oidcFail(w, r, oidcErrEmailTaken)
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC login of %q: %v", identity.Subject, err)
+		h.oidcFail(w, r, oidcErrServer)
+		return
+	}
+
+	if _, _, err := h.stores.GroupMappings.Apply(user.ID, database.AuthProviderOIDC, identity.Groups); err != nil {
+		log.Printf("OIDC login of %q: applying group mappings: %v", identity.Subject, err)
+		h.oidcFail(w, r, oidcErrServer)
+		return
+	}
+
+	if !user.Approved {
+		h.oidcFail(w, r, oidcErrPendingApproval)
+		return
+	}
+
+	// The MFA policy applies as for password logins, unless the provider is
+	// trusted to have checked a second factor itself. The challenge is
+	// completed on the login page.
+	idpMFA := h.oidc.MFAAsserted(identity)
+	if !idpMFA {
+		challenge, err := h.mfaChallenge(user)
+		if err != nil {
+			log.Printf("OIDC login of %q: checking MFA: %v", identity.Subject, err)
+			h.oidcFail(w, r, oidcErrServer)
+			return
+		}
+		if challenge != nil {
+			v := url.Values{
+				"mfa_token":    {challenge.MFAToken},
+				"mfa_enrolled": {strconv.FormatBool(challenge.Enrolled)},
+				"methods":      {strings.Join(challenge.Methods, ",")},
+			}
+			http.Redirect(w, r, h.oidc.SuccessURL()+"#"+v.Encode(), http.StatusFound)
+			return
+		}
+	}
+
+	tokens, err := h.startSession(r, user)
+	if err != nil {
+		h.oidcFail(w, r, oidcErrServer)
+		return
+	}
+	if idpMFA {
+		if err := h.stores.Sessions.MarkStepUp(tokens.SessionID); err != nil {
+			log.Printf("Failed to mark step-up: %v", err)
+		}
+	}
+	h.recordAudit(user.ID, auditOIDCLogin, "user", user.ID, map[string]interface{}{
+		"subject": identity.Subject,
+		"groups":  identity.Groups,
+		"amr":     identity.AMR,
+		"idp_mfa": idpMFA,
+	})
+
+	v := url.Values{
+		"token":         {tokens.Token},
+		"refresh_token": {tokens.RefreshToken},
+		"expires_at":    {tokens.ExpiresAt.Format(time.RFC3339)},
+		"session_id":    {tokens.SessionID},
+	}
+	if st.RedirectTo != nil {
+		v.Set("redirect", *st.RedirectTo)
+	}
+	http.Redirect(w, r, h.oidc.SuccessURL()+"#"+v.Encode(), http.StatusFound)
+}
+
+func (h *Handlers) oidcFail(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, h.oidc.SuccessURL()+"#"+url.Values{"error": {code}}.Encode(), http.StatusFound)
+}
+
+// oidcUser returns the user an identity belongs to. Unknown identities are
+// linked to the local account with the same verified email address, if
+// allowed, or provisioned as new users.
+func (h *Handlers) oidcUser(identity *oidc.Identity) (*database.User, error) {
+	user, err := h.stores.Users.GetByExternalID(database.AuthProviderOIDC, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if identity.Email == "" {
+		return nil, errOIDCNoEmail
+	}
+
+	if existing, err := h.stores.Users.GetByEmail(identity.Email); err == nil {
+		if !h.oidc.LinkByEmail() || !identity.EmailVerified || existing.AuthProvider != database.AuthProviderLocal {
+			return nil, errOIDCEmailTaken
+		}
+		if err := h.stores.Users.LinkExternal(existing.ID, database.AuthProviderOIDC, identity.Subject); err != nil {
+			return nil, err
+		}
+		existing.AuthProvider = database.AuthProviderOIDC
+		h.recordAudit(existing.ID, auditUserLinked, "user", existing.ID, map[string]interface{}{
+			"provider": database.AuthProviderOIDC,
+			"subject":  identity.Subject,
+		})
+		return existing, nil
+	}
+
+	username := identity.PreferredUsername
+	if username == "" {
+		username = identity.Email
+	}
+	var displayName *string
+	if identity.Name != "" {
+		displayName = &identity.Name
+	}
+	user, err = h.stores.Users.CreateExternal(database.AuthProviderOIDC, identity.Subject, username, identity.Email,
+		displayName, h.oidc.AutoApprove())
+	if err != nil {
+		return nil, err
+	}
+	h.recordAudit(user.ID, auditUserProvisioned, "user", user.ID, map[string]interface{}{
+		"provider": database.AuthProviderOIDC,
+		"subject":  identity.Subject,
+		"email":    user.Email,
+		"approved": user.Approved,
+	})
+	return user, nil
+}
@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/mailer"
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditPasswordResetRequested = "auth.password.reset_requested"
+	auditPasswordReset          = "auth.password.reset"
+	auditPasswordChanged        = "auth.password.changed"
+)
+
+// sendPasswordReset starts a password reset for a local user and emails
+// them the link. requestedBy is the admin who asked for it, if any.
+func (h *Handlers) sendPasswordReset(ctx context.Context, user *database.User, requestedBy *string) error {
+	expiresAt := time.Now().Add(h.authCfg.PasswordResetTTL)
+	id, err := h.stores.PasswordResets.Create(user.ID, requestedBy, expiresAt)
+	if err != nil {
+		return err
+	}
+	token, err := h.jwtManager.GenerateOneTimeToken(auth.PasswordResetAudience, id, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	actor := user.ID
+	if requestedBy != nil {
+		actor = *requestedBy
+	}
+	h.recordAudit(actor, auditPasswordResetRequested, "user", user.ID, nil)
+
+	return h.sendMail(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your CMDB password",
+		Body: fmt.Sprintf("A password reset was requested for your CMDB account %s. Open this link to choose a new password:\n\n%s\n\nThe link can be used once and expires on %s. If you did not ask for this, you can ignore this email.\n",
+			user.Username, h.appLink(passwordResetPath, token), expiresAt.Format("2006-01-02 15:04 MST")),
+	})
+}
+
+// ForgotPassword emails a password reset link if a local user has the
+// given email. The response is the same either way, so it does not reveal
+// who has an account
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		respondError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+	if err := h.throttle.CheckPasswordReset(h.clientIP(r), email); err != nil {
+		respondThrottled(w, err)
+		return
+	}
+
+	// Sent in the background so that the response time does not reveal
+	// whether the account exists either
+	go func() {
+		user, err := h.stores.Users.GetByEmail(email)
+		if err != nil || user.AuthProvider != database.AuthProviderLocal {
+			return
+		}
+		if err := h.sendPasswordReset(context.Background(), user, nil); err != nil {
+			log.Printf("Failed to send password reset to %s: %v", user.ID, err)
+		}
+	}()
+
+	respondJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If an account exists for this email, a password reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password with the token from a reset email and
+// ends all of the user's sessions
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	id, err := h.jwtManager.VerifyOneTimeToken(req.Token, auth.PasswordResetAudience)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid or expired reset link")
+		return
+	}
+	userID, err := h.stores.PasswordResets.UserID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusBadRequest, "Invalid or expired reset link")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to check reset link")
+		return
+	}
+	user, err := h.stores.Users.GetByID(userID)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid or expired reset link")
+		return
+	}
+	if err := h.authCfg.CheckPassword(req.Password, user.Email, user.Username); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := h.stores.PasswordResets.Use(id, req.Password); errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusBadRequest, "Invalid or expired reset link")
+		return
+	} else if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+	ids, err := h.revokeUserSessions(user.ID, database.SessionPasswordReset)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of %s after password reset: %v", user.ID, err)
+	}
+	// Proving access to the mailbox lifts a lockout of the account
+	for _, login := range []string{user.Email, user.Username} {
+		if _, err := h.throttle.Unlock(auth.ScopeAccount, login); err != nil {
+			log.Printf("Failed to unlock %s after password reset: %v", user.ID, err)
+		}
+	}
+	h.recordAudit(user.ID, auditPasswordReset, "user", user.ID, map[string]interface{}{
+		"sessions": len(ids),
+		"ip":       h.clientIP(r),
+	})
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Password reset, sign in with your new password"})
+}
+
+// ChangePassword replaces the caller's password after checking the
+// current one and ends their other sessions
+func (h *Handlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, err := h.stores.Users.GetByID(userID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if user.AuthProvider != database.AuthProviderLocal {
+		respondError(w, http.StatusBadRequest, "Your password is managed by your identity provider")
+		return
+	}
+
+	// Wrong current passwords count as failed logins
+	ip := h.clientIP(r)
+	if err := h.throttle.CheckLogin(ip, user.Email); err != nil {
+		respondThrottled(w, err)
+		return
+	}
+	withHash, err := h.stores.Users.GetByEmail(user.Email)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to check password")
+		return
+	}
+	if !h.stores.Users.VerifyPassword(withHash.Password, req.CurrentPassword) {
+		h.loginFailed(ip, user.Email)
+		respondError(w, http.StatusUnauthorized, "Current password is incorrect")
+		return
+	}
+	if err := h.authCfg.CheckPassword(req.NewPassword, user.Email, user.Username); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.stores.Users.SetPassword(userID, req.NewPassword); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+	ids, err := h.stores.Sessions.RevokeOthersForUser(userID, auth.GetSessionID(r.Context()), database.SessionPasswordChanged)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of %s after password change: %v", userID, err)
+	}
+	h.revoked.Add(ids...)
+	h.recordAudit(userID, auditPasswordChanged, "user", userID, map[string]interface{}{"sessions": len(ids)})
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message":          "Password changed",
+		"sessions_revoked": len(ids),
+	})
+}
+
+// SendPasswordReset emails a user a password reset link
+func (h *Handlers) SendPasswordReset(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	user, err := h.stores.Users.GetByID(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	if user.AuthProvider != database.AuthProviderLocal {
+		respondError(w, http.StatusBadRequest, "The user's password is managed by their identity provider")
+		return
+	}
+
+	if err := h.sendPasswordReset(r.Context(), user, &userID); err != nil {
+		respondError(w, http.StatusBadGateway, "Failed to send password reset email")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Password reset email sent"})
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/prober"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+type serverPortRequest struct {
+	Port          int             `json:"port"`
+	Transport     string          `json:"transport"`
+	Protocol      *string         `json:"protocol"`
+	Description   *string         `json:"description"`
+	ExpectedState string          `json:"expected_state"`
+	Enabled       *bool           `json:"enabled"`
+	CheckType     string          `json:"check_type"`
+	CheckConfig   json.RawMessage `json:"check_config"`
+}
+
+// toServerPort validates the request and applies defaults
+func (req *serverPortRequest) toServerPort() (*database.ServerPort, string) {
+	if req.Port < 1 || req.Port > 65535 {
+		return nil, "port must be between 1 and 65535"
+	}
+	if req.Transport == "" {
+		req.Transport = "tcp"
+	}
+	if req.Transport != "tcp" && req.Transport != "udp" {
+		return nil, "transport must be tcp or udp"
+	}
+	if req.ExpectedState == "" {
+		req.ExpectedState = "open"
+	}
+	if req.ExpectedState != "open" && req.ExpectedState != "closed" {
+		return nil, "expected_state must be open or closed"
+	}
+
+	if req.CheckType == "" {
+		req.CheckType = prober.CheckTCP
+	}
+	if _, err := prober.ParseCheckConfig(req.CheckType, req.CheckConfig); err != nil {
+		return nil, err.Error()
+	}
+	if req.CheckType != prober.CheckTCP && req.Transport != "tcp" {
+		return nil, "application checks require transport tcp"
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	return &database.ServerPort{
+		Port:          req.Port,
+		Transport:     req.Transport,
+		Protocol:      req.Protocol,
+		Description:   req.Description,
+		ExpectedState: req.ExpectedState,
+		Enabled:       enabled,
+		CheckType:     req.CheckType,
+		CheckConfig:   req.CheckConfig,
+	}, ""
+}
+
+func (h *Handlers) ListServerPorts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if !isAdmin {
+		hasAccess, _ := h.stores.Permissions.HasAccess(userID, serverID)
+		if !hasAccess {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+	}
+
+	ports, err := h.stores.Ports.ListByServer(serverID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch ports")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ports)
+}
+
+func (h *Handlers) CreateServerPort(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	serverID := vars["id"]
+
+	if _, err := h.stores.Servers.GetByID(serverID); err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	var req serverPortRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	port, msg := req.toServerPort()
+	if port == nil {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+	port.ServerID = serverID
+
+	created, err := h.stores.Ports.Create(port)
+	if isUniqueViolation(err) {
+		respondError(w, http.StatusConflict, "Port is already defined for this server")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create port")
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handlers) UpdateServerPort(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	existing, err := h.stores.Ports.GetByID(vars["portId"])
+	if err != nil || existing.ServerID != vars["id"] {
+		respondError(w, http.StatusNotFound, "Port not found")
+		return
+	}
+
+	var req serverPortRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	port, msg := req.toServerPort()
+	if port == nil {
+		respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	err = h.stores.Ports.Update(existing.ID, port)
+	if isUniqueViolation(err) {
+		respondError(w, http.StatusConflict, "Port is already defined for this server")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update port")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Port updated successfully"})
+}
+
+func (h *Handlers) DeleteServerPort(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	existing, err := h.stores.Ports.GetByID(vars["portId"])
+	if err != nil || existing.ServerID != vars["id"] {
+		respondError(w, http.StatusNotFound, "Port not found")
+		return
+	}
+
+	if err := h.stores.Ports.Delete(existing.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete port")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Port deleted successfully"})
+}
+
+// ListPortDrift returns every port whose observed state differs from the expected one
+func (h *Handlers) ListPortDrift(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	ports, err := h.stores.Ports.ListDrifted(userID, isAdmin)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch port drift")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ports)
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate,
+// here a second port with the same number and transport on one server.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -1,12 +1,14 @@
 package api
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/cmdb/backend/internal/alerting"
 	"github.com/cmdb/backend/internal/auth"
 )
 
@@ -17,6 +19,10 @@ func strPtr(s *string) string {
 	return *s
 }
 
+// labelEscaper escapes label values as the Prometheus text format requires.
+// Go's %q escapes more than that and Prometheus would keep the escapes.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
 // PrometheusMetrics returns SSL certificate metrics in Prometheus format
 func (h *Handlers) PrometheusMetrics(w http.ResponseWriter, r *http.Request) {
 	certificates, err := h.stores.SSL.List("", true)
@@ -35,7 +41,7 @@ func (h *Handlers) PrometheusMetrics(w http.ResponseWriter, r *http.Request) {
 		daysUntilExpiry := cert.ExpiresAt.Sub(now).Hours() / 24
 		
 		fmt.Fprintf(w, "ssl_certificate_expiry_days{domain=\"%s\",issuer=\"%s\",server_id=\"%s\"} %.2f\n",
-			cert.Domain, strPtr(cert.Issuer), cert.ServerID, daysUntilExpiry)
+			cert.Domain, labelEscaper.Replace(strPtr(cert.Issuer)), cert.ServerID, daysUntilExpiry)
 	}
 
 	fmt.Fprintf(w, "\n# HELP ssl_certificate_auto_renew SSL certificate auto-renew status (1=enabled, 0=disabled)\n")
@@ -49,16 +55,49 @@ func (h *Handlers) PrometheusMetrics(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "ssl_certificate_auto_renew{domain=\"%s\",server_id=\"%s\"} %d\n",
 			cert.Domain, cert.ServerID, autoRenew)
 	}
-}
 
-// AlertmanagerWebhook sends SSL certificate alerts to Alertmanager
-type AlertmanagerAlert struct {
-	Labels      map[string]string `json:"labels"`
-	Annotations map[string]string `json:"annotations"`
-	StartsAt    time.Time         `json:"startsAt"`
-	EndsAt      time.Time         `json:"endsAt,omitempty"`
+	policies, err := h.stores.Policies.Load()
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "\n# HELP ssl_certificate_warning_threshold_days Days before expiry at which the certificate's alert policy warns\n")
+	fmt.Fprintf(w, "# TYPE ssl_certificate_warning_threshold_days gauge\n")
+
+	for _, cert := range certificates {
+		policy := policies.For(cert)
+		fmt.Fprintf(w, "ssl_certificate_warning_threshold_days{domain=\"%s\",server_id=\"%s\",policy=\"%s\"} %d\n",
+			cert.Domain, cert.ServerID, policy.Scope, policy.WarningDays)
+	}
+
+	fmt.Fprintf(w, "\n# HELP ssl_certificate_critical_threshold_days Days before expiry at which the certificate's alert policy is critical\n")
+	fmt.Fprintf(w, "# TYPE ssl_certificate_critical_threshold_days gauge\n")
+
+	for _, cert := range certificates {
+		policy := policies.For(cert)
+		fmt.Fprintf(w, "ssl_certificate_critical_threshold_days{domain=\"%s\",server_id=\"%s\",policy=\"%s\"} %d\n",
+			cert.Domain, cert.ServerID, policy.Scope, policy.CriticalDays)
+	}
+
+	findings, err := h.stores.SSL.FindingsByCertificate()
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "\n# HELP ssl_certificate_finding Chain, hostname or key-strength problem found on the last scan (1=present)\n")
+	fmt.Fprintf(w, "# TYPE ssl_certificate_finding gauge\n")
+
+	for _, cert := range certificates {
+		for _, f := range findings[cert.ID] {
+			fmt.Fprintf(w, "ssl_certificate_finding{domain=\"%s\",server_id=\"%s\",type=\"%s\",severity=\"%s\",subject=\"%s\"} 1\n",
+				cert.Domain, cert.ServerID, f.Type, f.Severity, labelEscaper.Replace(f.Subject))
+		}
+	}
 }
 
+// SendAlertsToAlertmanager evaluates alerts immediately. With alertmanager_url
+// the firing alerts are pushed to that URL only; otherwise firing and resolved
+// alerts go to every configured receiver.
 func (h *Handlers) SendAlertsToAlertmanager(w http.ResponseWriter, r *http.Request) {
 	userID := auth.GetUserID(r.Context())
 	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
@@ -72,87 +111,43 @@ func (h *Handlers) SendAlertsToAlertmanager(w http.ResponseWriter, r *http.Reque
 		AlertmanagerURL string `json:"alertmanager_url"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
 		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.AlertmanagerURL == "" {
-		respondError(w, http.StatusBadRequest, "alertmanager_url is required")
-		return
-	}
-
-	certificates, err := h.stores.SSL.List(userID, isAdmin)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to fetch certificates")
-		return
-	}
-
-	now := time.Now()
-	var alerts []AlertmanagerAlert
-
-	for _, cert := range certificates {
-		daysUntilExpiry := int(cert.ExpiresAt.Sub(now).Hours() / 24)
-		
-		var severity string
-		var message string
-		
-		if daysUntilExpiry < 0 {
-			severity = "critical"
-			message = fmt.Sprintf("SSL certificate for %s has EXPIRED", cert.Domain)
-		} else if daysUntilExpiry <= 7 {
-			severity = "critical"
-			message = fmt.Sprintf("SSL certificate for %s expires in %d days", cert.Domain, daysUntilExpiry)
-		} else if daysUntilExpiry <= 30 {
-			severity = "warning"
-			message = fmt.Sprintf("SSL certificate for %s expires in %d days", cert.Domain, daysUntilExpiry)
-		} else {
-			continue // Skip valid certificates
-		}
-
-		alert := AlertmanagerAlert{
-			Labels: map[string]string{
-				"alertname": "SSLCertificateExpiring",
-				"severity":  severity,
-				"domain":    cert.Domain,
-				"issuer":    strPtr(cert.Issuer),
-				"server_id": cert.ServerID,
-			},
-			Annotations: map[string]string{
-				"summary":     message,
-				"description": fmt.Sprintf("Certificate issued by %s expires at %s", strPtr(cert.Issuer), cert.ExpiresAt.Format("2006-01-02 15:04:05")),
-			},
-			StartsAt: now,
+		sent, err := h.alerts.Evaluate(r.Context())
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to evaluate alerts: %v", err))
+			return
 		}
-
-		alerts = append(alerts, alert)
-	}
-
-	if len(alerts) == 0 {
-		respondJSON(w, http.StatusOK, map[string]string{
-			"message": "No expiring certificates found",
-			"sent":    "0",
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"message": "Alerts sent to configured receivers",
+			"sent":    sent,
 		})
 		return
 	}
 
-	// Send to Alertmanager
-	alertsJSON, err := json.Marshal(alerts)
+	now := time.Now()
+	firing, err := h.alerts.Refresh(now)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to marshal alerts")
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to evaluate alerts: %v", err))
 		return
 	}
 
-	alertmanagerURL := req.AlertmanagerURL + "/api/v1/alerts"
-	resp, err := http.Post(alertmanagerURL, "application/json", bytes.NewBuffer(alertsJSON))
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to send alerts: %v", err))
+	if len(firing) == 0 {
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"message": "No firing alerts",
+			"sent":    0,
+		})
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Alertmanager returned status: %d", resp.StatusCode))
+	alerts := alerting.ToAlertmanager(firing, now, h.alerts.ValidFor(), h.alerts.ExternalURL())
+	client := &http.Client{Timeout: 10 * time.Second}
+	if err := alerting.Post(r.Context(), client, req.AlertmanagerURL, alerts, nil); err != nil {
+		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to send alerts: %v", err))
 		return
 	}
 
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/recording"
+	"github.com/gorilla/mux"
+)
+
+// Audit actions for session recordings.
+const (
+	auditRecordingDownloaded = "ssh.recording.downloaded"
+	auditRecordingStreamed   = "ssh.recording.streamed"
+)
+
+// recordingForAdmin loads the recording named in the URL, writing the error
+// response and returning nil when the caller is not an admin or it does not
+// exist.
+func (h *Handlers) recordingForAdmin(w http.ResponseWriter, r *http.Request) *database.Recording {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return nil
+	}
+
+	rec, err := h.stores.Recordings.Get(mux.Vars(r)["id"])
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "Recording not found")
+		} else {
+			respondError(w, http.StatusInternalServerError, "Failed to fetch recording")
+		}
+		return nil
+	}
+	return rec
+}
+
+// ListRecordings returns session recordings, newest first, filtered by
+// user_id, server_id, from and to (RFC 3339)
+func (h *Handlers) ListRecordings(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := database.RecordingFilter{
+		UserID:   q.Get("user_id"),
+		ServerID: q.Get("server_id"),
+		Limit:    100,
+	}
+	for name, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+				return
+			}
+			*target = &t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			respondError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = n
+	}
+
+	recordings, err := h.stores.Recordings.List(filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch recordings")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, recordings)
+}
+
+func (h *Handlers) GetRecording(w http.ResponseWriter, r *http.Request) {
+	rec := h.recordingForAdmin(w, r)
+	if rec == nil {
+		return
+	}
+	respondJSON(w, http.StatusOK, rec)
+}
+
+// DownloadRecording returns a finished recording as an asciicast file.
+func (h *Handlers) DownloadRecording(w http.ResponseWriter, r *http.Request) {
+	rec := h.recordingForAdmin(w, r)
+	if rec == nil {
+		return
+	}
+	if rec.Status == database.RecordingActive {
+		respondError(w, http.StatusConflict, "Recording is still in progress, use the stream endpoint")
+		return
+	}
+	if h.serveRecording(w, r, rec, "attachment") {
+		h.recordAudit(auth.GetUserID(r.Context()), auditRecordingDownloaded, "recording", rec.ID, nil)
+	}
+}
+
+// StreamRecording returns a recording inline for a player. While the session
+// is running it sends what has been recorded so far and then follows new
+// events until the session ends.
+func (h *Handlers) StreamRecording(w http.ResponseWriter, r *http.Request) {
+	rec := h.recordingForAdmin(w, r)
+	if rec == nil {
+		return
+	}
+	actorID := auth.GetUserID(r.Context())
+
+	var live *recording.Recorder
+	if h.recordings != nil {
+		live = h.recordings.Live(rec.ID)
+	}
+	if live == nil {
+		if rec.Status == database.RecordingActive {
+			respondError(w, http.StatusConflict, "Recording is in progress on another instance")
+			return
+		}
+		if h.serveRecording(w, r, rec, "inline") {
+			h.recordAudit(actorID, auditRecordingStreamed, "recording", rec.ID, nil)
+		}
+		return
+	}
+
+	snapshot, lines, cancel, err := live.Subscribe()
+	if err != nil {
+		// The session ended in between; serve the stored file.
+		if rec, err = h.stores.Recordings.Get(rec.ID); err == nil && h.serveRecording(w, r, rec, "inline") {
+			h.recordAudit(actorID, auditRecordingStreamed, "recording", rec.ID, nil)
+		}
+		return
+	}
+	defer cancel()
+	h.recordAudit(actorID, auditRecordingStreamed, "recording", rec.ID, map[string]interface{}{"live": true})
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(snapshot); err != nil {
+		return
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// serveRecording copies a stored recording to the response, reporting
+// whether it was sent.
+func (h *Handlers) serveRecording(w http.ResponseWriter, r *http.Request, rec *database.Recording, disposition string) bool {
+	if rec.Status == database.RecordingFailed {
+		respondError(w, http.StatusNotFound, "Recording is not available")
+		return false
+	}
+	if h.recordings == nil {
+		respondError(w, http.StatusServiceUnavailable, "Session recording is disabled")
+		return false
+	}
+	body, err := h.recordings.Open(r.Context(), rec)
+	if err != nil {
+		log.Printf("Failed to open recording %s: %v", rec.ID, err)
+		respondError(w, http.StatusBadGateway, "Failed to read recording from storage")
+		return false
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, rec.ID+".cast"))
+	if rec.SizeBytes > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(rec.SizeBytes, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Failed to send recording %s: %v", rec.ID, err)
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/renewal"
+	"github.com/gorilla/mux"
+)
+
+type renewalSettingsRequest struct {
+	Solver        string  `json:"solver"`
+	Webroot       *string `json:"webroot"`
+	CertPath      string  `json:"cert_path"`
+	KeyPath       string  `json:"key_path"`
+	ChainPath     *string `json:"chain_path"`
+	ReloadCommand *string `json:"reload_command"`
+}
+
+// GetSSLRenewal returns the ACME renewal settings and last outcome of a certificate
+func (h *Handlers) GetSSLRenewal(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	settings, err := h.stores.Renewals.Get(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch renewal settings")
+		return
+	}
+	if settings == nil {
+		respondError(w, http.StatusNotFound, "Renewal is not configured for this certificate")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, settings)
+}
+
+// UpdateSSLRenewal creates or replaces the ACME renewal settings of a certificate
+func (h *Handlers) UpdateSSLRenewal(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := h.stores.SSL.GetByID(id); err != nil {
+		respondError(w, http.StatusNotFound, "Certificate not found")
+		return
+	}
+
+	var req renewalSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Solver == "" {
+		req.Solver = renewal.SolverHTTP01
+	}
+	if h.renewer != nil && h.renewer.Solver(req.Solver) == nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("solver must be one of %v", h.renewer.Solvers()))
+		return
+	}
+	if req.Solver == renewal.SolverHTTP01Webroot && (req.Webroot == nil || !path.IsAbs(*req.Webroot)) {
+		respondError(w, http.StatusBadRequest, "webroot must be an absolute path for the http-01-webroot solver")
+		return
+	}
+	if !path.IsAbs(req.CertPath) || !path.IsAbs(req.KeyPath) {
+		respondError(w, http.StatusBadRequest, "cert_path and key_path must be absolute paths")
+		return
+	}
+	if req.ChainPath != nil && *req.ChainPath != "" && !path.IsAbs(*req.ChainPath) {
+		respondError(w, http.StatusBadRequest, "chain_path must be an absolute path")
+		return
+	}
+
+	settings := &database.RenewalSettings{
+		CertificateID: id,
+		Solver:        req.Solver,
+		Webroot:       req.Webroot,
+		CertPath:      req.CertPath,
+		KeyPath:       req.KeyPath,
+		ChainPath:     req.ChainPath,
+		ReloadCommand: req.ReloadCommand,
+	}
+	if err := h.stores.Renewals.Upsert(settings); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save renewal settings")
+		return
+	}
+
+	saved, err := h.stores.Renewals.Get(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch renewal settings")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, saved)
+}
+
+// DeleteSSLRenewal removes the ACME renewal settings of a certificate
+func (h *Handlers) DeleteSSLRenewal(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := h.stores.Renewals.Delete(mux.Vars(r)["id"]); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete renewal settings")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Renewal settings deleted successfully"})
+}
+
+// RenewSSLCertificate renews a certificate through ACME immediately
+func (h *Handlers) RenewSSLCertificate(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if h.renewer == nil {
+		respondError(w, http.StatusServiceUnavailable, "ACME renewal is not enabled")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := h.stores.SSL.GetByID(id); err != nil {
+		respondError(w, http.StatusNotFound, "Certificate not found")
+		return
+	}
+
+	if _, err := h.renewer.Renew(r.Context(), id); err != nil {
+		if errors.Is(err, renewal.ErrNotConfigured) {
+			respondError(w, http.StatusBadRequest, "Renewal is not configured for this certificate")
+			return
+		}
+		respondError(w, http.StatusBadGateway, fmt.Sprintf("Renewal failed: %v", err))
+		return
+	}
+	h.alerts.Trigger()
+
+	updated, err := h.stores.SSL.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, updated)
+}
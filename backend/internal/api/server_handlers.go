@@ -2,10 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/cmdb/backend/internal/auth"
 	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/passkey"
 	"github.com/gorilla/mux"
 )
 
@@ -37,6 +40,10 @@ func (h *Handlers) CreateServer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !validHostKeyMode(server.HostKeyMode) {
+		respondError(w, http.StatusBadRequest, "host_key_mode must be tofu or strict")
+		return
+	}
 	if server.SSHPort == 0 {
 		server.SSHPort = 22
 	}
@@ -90,13 +97,23 @@ func (h *Handlers) UpdateServer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	var server database.Server
-	if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
+	// Decode onto the stored server so fields the client does not send, such
+	// as credential_id, are kept.
+	server, err := h.stores.Servers.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(server); err != nil {
 		respondError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if !validHostKeyMode(server.HostKeyMode) {
+		respondError(w, http.StatusBadRequest, "host_key_mode must be tofu or strict")
+		return
+	}
 
-	err := h.stores.Servers.Update(id, &server)
+	err = h.stores.Servers.Update(id, server)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update server")
 		return
@@ -113,15 +130,64 @@ func (h *Handlers) DeleteServer(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusForbidden, "Admin access required")
 		return
 	}
+	if !h.requireFreshWebAuthn(w, r, passkey.OpDeleteServer) {
+		return
+	}
 
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	err := h.stores.Servers.Delete(id)
+	servers, groups, err := h.stores.JumpHosts.UsedBy(id)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to delete server")
 		return
 	}
+	if len(servers)+len(groups) > 0 {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Server is a jump host for %d servers and %d groups; remove it from their chains first", len(servers), len(groups)))
+		return
+	}
+
+	err = h.stores.Servers.Delete(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete server")
+		return
+	}
+	h.alerts.Trigger()
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Server deleted successfully"})
 }
+
+// ListServerStatusEvents returns the most recent status transitions recorded by the prober
+func (h *Handlers) ListServerStatusEvents(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if !isAdmin {
+		hasAccess, _ := h.stores.Permissions.HasAccess(userID, id)
+		if !hasAccess {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			respondError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.stores.Servers.ListStatusEvents(id, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch status events")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, events)
+}
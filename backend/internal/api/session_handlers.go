@@ -0,0 +1,289 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditLogoutAll          = "auth.logout_all"
+	auditSessionTerminated  = "auth.session.terminated"
+	auditRefreshTokenReused = "auth.refresh_token_reused"
+)
+
+// sessionTokens are issued on login and on every refresh.
+type sessionTokens struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	SessionID    string    `json:"session_id"`
+}
+
+// sessionResponse is a session as listed to users and admins.
+type sessionResponse struct {
+	*database.Session
+	Current bool `json:"current"`
+}
+
+func optionalHeader(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+// startSession opens a login session for user and issues its tokens.
+func (h *Handlers) startSession(r *http.Request, user *database.User) (*sessionTokens, error) {
+	refresh, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	sess := &database.Session{
+		UserID:     user.ID,
+		UserAgent:  optionalHeader(r.UserAgent()),
+		RemoteAddr: optionalHeader(r.RemoteAddr),
+		ExpiresAt:  time.Now().Add(h.authCfg.RefreshTTL),
+	}
+	if err := h.stores.Sessions.Create(sess, hash); err != nil {
+		return nil, err
+	}
+	return h.sessionTokens(user, sess.ID, refresh)
+}
+
+func (h *Handlers) sessionTokens(user *database.User, sessionID, refresh string) (*sessionTokens, error) {
+	token, err := h.jwtManager.Generate(user.ID, user.Email, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionTokens{
+		Token:        token,
+		RefreshToken: refresh,
+		ExpiresAt:    time.Now().Add(h.jwtManager.AccessTTL()),
+		SessionID:    sessionID,
+	}, nil
+}
+
+// revokeSession ends one session; its access tokens are rejected right away
+// on this replica and after the next revocation sync on the others.
+func (h *Handlers) revokeSession(id, reason string) (bool, error) {
+	revoked, err := h.stores.Sessions.Revoke(id, reason)
+	if err != nil {
+		return false, err
+	}
+	h.revoked.Add(id)
+	return revoked, nil
+}
+
+// revokeUserSessions ends every session of a user.
+func (h *Handlers) revokeUserSessions(userID, reason string) ([]string, error) {
+	ids, err := h.stores.Sessions.RevokeAllForUser(userID, reason)
+	if err != nil {
+		return nil, err
+	}
+	h.revoked.Add(ids...)
+	return ids, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new
+// refresh token. Presenting a refresh token that has already been rotated
+// revokes the session, since either it or its successor has been stolen.
+func (h *Handlers) RefreshSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		respondError(w, http.StatusBadRequest, "refresh_token is required")
+		return
+	}
+
+	oldHash := auth.HashToken(req.RefreshToken)
+	refresh, newHash, err := auth.NewRefreshToken()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+	sess, err := h.stores.Sessions.Rotate(oldHash, newHash, time.Now().Add(h.authCfg.RefreshTTL),
+		optionalHeader(r.UserAgent()), optionalHeader(r.RemoteAddr))
+	if errors.Is(err, sql.ErrNoRows) {
+		h.checkRefreshReuse(r, oldHash)
+		respondError(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to refresh session")
+		return
+	}
+
+	user, err := h.stores.Users.GetByID(sess.UserID)
+	if err != nil || !user.Approved {
+		if _, err := h.revokeSession(sess.ID, database.SessionUserDisabled); err != nil {
+			log.Printf("Failed to revoke session %s: %v", sess.ID, err)
+		}
+		respondError(w, http.StatusUnauthorized, "Account is not active")
+		return
+	}
+
+	tokens, err := h.sessionTokens(user, sess.ID, refresh)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, tokens)
+}
+
+// checkRefreshReuse revokes the session whose previous refresh token hashes
+// to hash, unless it was rotated within the reuse grace period.
+func (h *Handlers) checkRefreshReuse(r *http.Request, hash string) {
+	sess, rotatedAt, err := h.stores.Sessions.FindByPreviousHash(hash)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to check refresh token reuse: %v", err)
+		}
+		return
+	}
+	if sess.RevokedAt != nil || time.Since(rotatedAt) < h.authCfg.RefreshReuseGrace {
+		return
+	}
+	if _, err := h.revokeSession(sess.ID, database.SessionRefreshReused); err != nil {
+		log.Printf("Failed to revoke session %s: %v", sess.ID, err)
+		return
+	}
+	h.recordAudit(sess.UserID, auditRefreshTokenReused, "session", sess.ID, map[string]interface{}{
+		"remote_addr": r.RemoteAddr,
+		"user_agent":  r.UserAgent(),
+	})
+}
+
+// Logout ends the caller's current session
+func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.revokeSession(auth.GetSessionID(r.Context()), database.SessionLoggedOut); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+// LogoutAll ends every session of the caller, including the current one
+func (h *Handlers) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	ids, err := h.revokeUserSessions(userID, database.SessionLoggedOutAll)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+	h.recordAudit(userID, auditLogoutAll, "user", userID, map[string]interface{}{"sessions": len(ids)})
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"message": "Logged out everywhere", "sessions": len(ids)})
+}
+
+func (h *Handlers) listSessions(w http.ResponseWriter, r *http.Request, userID string) {
+	sessions, err := h.stores.Sessions.ListActive(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	current := auth.GetSessionID(r.Context())
+	list := []sessionResponse{}
+	for _, s := range sessions {
+		list = append(list, sessionResponse{Session: s, Current: s.ID == current})
+	}
+	respondJSON(w, http.StatusOK, list)
+}
+
+// ListMySessions returns the caller's active sessions
+func (h *Handlers) ListMySessions(w http.ResponseWriter, r *http.Request) {
+	h.listSessions(w, r, auth.GetUserID(r.Context()))
+}
+
+// RevokeMySession ends one of the caller's sessions
+func (h *Handlers) RevokeMySession(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+
+	sess, err := h.stores.Sessions.Get(mux.Vars(r)["id"])
+	if err != nil || sess.UserID != userID {
+		respondError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	if _, err := h.revokeSession(sess.ID, database.SessionLoggedOut); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Session revoked"})
+}
+
+// ListUserSessions returns a user's active sessions
+func (h *Handlers) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	h.listSessions(w, r, mux.Vars(r)["id"])
+}
+
+// TerminateUserSessions ends every session of a user
+func (h *Handlers) TerminateUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	ids, err := h.revokeUserSessions(id, database.SessionTerminated)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to terminate sessions")
+		return
+	}
+	h.recordAudit(userID, auditSessionTerminated, "user", id, map[string]interface{}{"session_ids": ids})
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"message": "Sessions terminated", "sessions": len(ids)})
+}
+
+// TerminateUserSession ends one session of a user
+func (h *Handlers) TerminateUserSession(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	sess, err := h.stores.Sessions.Get(vars["sessionId"])
+	if err != nil || sess.UserID != vars["id"] {
+		respondError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+	revoked, err := h.revokeSession(sess.ID, database.SessionTerminated)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to terminate session")
+		return
+	}
+	if !revoked {
+		respondError(w, http.StatusConflict, "Session has already ended")
+		return
+	}
+	h.recordAudit(userID, auditSessionTerminated, "user", sess.UserID, map[string]interface{}{"session_ids": []string{sess.ID}})
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Session terminated"})
+}
@@ -0,0 +1,453 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/pkg/sftp"
+)
+
+const (
+	auditSFTPList     = "sftp.list"
+	auditSFTPStat     = "sftp.stat"
+	auditSFTPDownload = "sftp.download"
+	auditSFTPUpload   = "sftp.upload"
+	auditSFTPMkdir    = "sftp.mkdir"
+	auditSFTPRename   = "sftp.rename"
+	auditSFTPDelete   = "sftp.delete"
+)
+
+// fileInfo describes a remote file or directory.
+type fileInfo struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	Type        string    `json:"type"`
+	Size        int64     `json:"size"`
+	Mode        string    `json:"mode"`
+	Permissions string    `json:"permissions"`
+	ModTime     time.Time `json:"mod_time"`
+	UID         *uint32   `json:"uid,omitempty"`
+	GID         *uint32   `json:"gid,omitempty"`
+	// LinkTarget is set for symbolic links.
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+func newFileInfo(dir string, fi os.FileInfo) fileInfo {
+	info := fileInfo{
+		Name:        fi.Name(),
+		Path:        path.Join(dir, fi.Name()),
+		Size:        fi.Size(),
+		Mode:        fi.Mode().String(),
+		Permissions: fmt.Sprintf("%04o", fi.Mode().Perm()),
+		ModTime:     fi.ModTime().UTC(),
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		info.Type = "symlink"
+	case fi.IsDir():
+		info.Type = "directory"
+	case fi.Mode().IsRegular():
+		info.Type = "file"
+	default:
+		info.Type = "other"
+	}
+	if st, ok := fi.Sys().(*sftp.FileStat); ok {
+		info.UID, info.GID = &st.UID, &st.GID
+	}
+	return info
+}
+
+// remotePath cleans a path from the request; relative paths are relative to
+// the login directory on the server.
+func remotePath(p string) string {
+	if p == "" {
+		return "."
+	}
+	return path.Clean(p)
+}
+
+// sftpStatus maps an SFTP error to an HTTP status.
+func sftpStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, os.ErrExist):
+		return http.StatusConflict
+	case errors.As(err, &maxErr):
+		return http.StatusRequestEntityTooLarge
+	}
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) {
+		return http.StatusConflict
+	}
+	return http.StatusBadGateway
+}
+
+func respondSFTPError(w http.ResponseWriter, op string, err error) {
+	status := sftpStatus(err)
+	msg := err.Error()
+	switch status {
+	case http.StatusNotFound:
+		msg = "No such file or directory"
+	case http.StatusForbidden:
+		msg = "Permission denied on the server"
+	}
+	respondError(w, status, fmt.Sprintf("Failed to %s: %s", op, msg))
+}
+
+// openSFTP checks that the caller may use the server in the URL, with the
+// same permission and step-up checks as the web terminal, and opens an SFTP
+// session to it. It writes the error response and returns nil on failure.
+func (h *Handlers) openSFTP(w http.ResponseWriter, r *http.Request) (*database.Server, *sshclient.SFTP) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	serverID := mux.Vars(r)["id"]
+	server, err := h.stores.Servers.GetByID(serverID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return nil, nil
+	}
+	if !isAdmin {
+		hasAccess, _ := h.stores.Permissions.HasAccess(userID, serverID)
+		if !hasAccess {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return nil, nil
+		}
+	}
+	if !h.requireSSHStepUp(w, r) {
+		return nil, nil
+	}
+
+	client, err := h.ssh.OpenSFTP(r.Context(), server)
+	if err != nil {
+		respondError(w, http.StatusBadGateway, fmt.Sprintf("Failed to open SFTP session: %v", err))
+		return nil, nil
+	}
+	return server, client
+}
+
+// auditSFTP records a file operation and its outcome.
+func (h *Handlers) auditSFTP(r *http.Request, action string, server *database.Server, details map[string]interface{}, err error) {
+	details["hostname"] = server.Hostname
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	h.recordAudit(auth.GetUserID(r.Context()), action, "server", server.ID, details)
+}
+
+// ListFiles returns the entries of the directory at ?path=, directories first
+func (h *Handlers) ListFiles(w http.ResponseWriter, r *http.Request) {
+	server, client := h.openSFTP(w, r)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	dir, err := client.RealPath(remotePath(r.URL.Query().Get("path")))
+	var entries []os.FileInfo
+	if err == nil {
+		entries, err = client.ReadDir(dir)
+	}
+	h.auditSFTP(r, auditSFTPList, server, map[string]interface{}{"path": dir}, err)
+	if err != nil {
+		respondSFTPError(w, "list directory", err)
+		return
+	}
+
+	files := []fileInfo{}
+	for _, fi := range entries {
+		info := newFileInfo(dir, fi)
+		if info.Type == "symlink" {
+			info.LinkTarget, _ = client.ReadLink(info.Path)
+		}
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if (files[i].Type == "directory") != (files[j].Type == "directory") {
+			return files[i].Type == "directory"
+		}
+		return files[i].Name < files[j].Name
+	})
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"path": dir, "entries": files})
+}
+
+// StatFile describes the file at ?path= without following a final symbolic
+// link
+func (h *Handlers) StatFile(w http.ResponseWriter, r *http.Request) {
+	server, client := h.openSFTP(w, r)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	p := remotePath(r.URL.Query().Get("path"))
+	fi, err := client.Lstat(p)
+	h.auditSFTP(r, auditSFTPStat, server, map[string]interface{}{"path": p}, err)
+	if err != nil {
+		respondSFTPError(w, "stat", err)
+		return
+	}
+
+	info := newFileInfo(path.Dir(p), fi)
+	info.Name, info.Path = path.Base(p), p
+	if info.Type == "symlink" {
+		info.LinkTarget, _ = client.ReadLink(p)
+	}
+	respondJSON(w, http.StatusOK, info)
+}
+
+// DownloadFile streams the regular file at ?path= as an attachment. Range
+// requests are supported so interrupted downloads can be resumed.
+func (h *Handlers) DownloadFile(w http.ResponseWriter, r *http.Request) {
+	server, client := h.openSFTP(w, r)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	p := remotePath(r.URL.Query().Get("path"))
+	f, err := client.Open(p)
+	var fi os.FileInfo
+	if err == nil {
+		defer f.Close()
+		fi, err = f.Stat()
+		if err == nil && !fi.Mode().IsRegular() {
+			err = fmt.Errorf("%s is not a regular file", p)
+		}
+	}
+	details := map[string]interface{}{"path": p}
+	if fi != nil {
+		details["size"] = fi.Size()
+	}
+	h.auditSFTP(r, auditSFTPDownload, server, details, err)
+	if err != nil {
+		if fi != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+		} else {
+			respondSFTPError(w, "download", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(p)))
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// UploadFile writes the request body to ?path=. The file is written under a
+// temporary name and renamed into place once complete, so a failed upload
+// leaves nothing behind; existing files are only replaced with
+// ?overwrite=true.
+func (h *Handlers) UploadFile(w http.ResponseWriter, r *http.Request) {
+	maxUpload := h.ssh.MaxUpload()
+	if r.ContentLength > maxUpload {
+		respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Uploads are limited to %d bytes", maxUpload))
+		return
+	}
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		respondError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	p = path.Clean(p)
+	overwrite, _ := strconv.ParseBool(r.URL.Query().Get("overwrite"))
+
+	server, client := h.openSFTP(w, r)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	written, err := uploadFile(client, p, http.MaxBytesReader(w, r.Body, maxUpload), overwrite)
+	h.auditSFTP(r, auditSFTPUpload, server, map[string]interface{}{"path": p, "size": written, "overwrite": overwrite}, err)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			respondError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Uploads are limited to %d bytes", maxUpload))
+			return
+		}
+		respondSFTPError(w, "upload", err)
+		return
+	}
+
+	fi, err := client.Stat(p)
+	if err != nil {
+		respondJSON(w, http.StatusCreated, map[string]interface{}{"path": p, "size": written})
+		return
+	}
+	info := newFileInfo(path.Dir(p), fi)
+	info.Path = p
+	respondJSON(w, http.StatusCreated, info)
+}
+
+func uploadFile(client *sshclient.SFTP, p string, body io.Reader, overwrite bool) (int64, error) {
+	if fi, err := client.Lstat(p); err == nil {
+		if !overwrite {
+			return 0, fmt.Errorf("%s: %w", p, os.ErrExist)
+		}
+		if fi.IsDir() {
+			return 0, fmt.Errorf("%s is a directory", p)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	tmp := path.Join(path.Dir(p), fmt.Sprintf(".%s.upload-%s", path.Base(p), uuid.New().String()[:8]))
+	f, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return 0, err
+	}
+	written, err := f.ReadFrom(body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && overwrite {
+		err = client.PosixRename(tmp, p)
+	} else if err == nil {
+		// A plain SFTP rename fails when the target exists, so a file
+		// created since the check above is not replaced.
+		if err = client.Rename(tmp, p); err != nil {
+			if _, serr := client.Lstat(p); serr == nil {
+				err = fmt.Errorf("%s: %w", p, os.ErrExist)
+			}
+		}
+	}
+	if err != nil {
+		if rerr := client.Remove(tmp); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			log.Printf("Failed to remove partial upload %s: %v", tmp, rerr)
+		}
+		return written, err
+	}
+	return written, nil
+}
+
+type mkdirRequest struct {
+	Path    string `json:"path"`
+	Parents bool   `json:"parents"`
+}
+
+// MakeDirectory creates a directory, with any missing parents if requested
+func (h *Handlers) MakeDirectory(w http.ResponseWriter, r *http.Request) {
+	var req mkdirRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		respondError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	p := path.Clean(req.Path)
+
+	server, client := h.openSFTP(w, r)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	var err error
+	if req.Parents {
+		err = client.MkdirAll(p)
+	} else if _, serr := client.Lstat(p); serr == nil {
+		err = fmt.Errorf("%s: %w", p, os.ErrExist)
+	} else {
+		err = client.Mkdir(p)
+	}
+	h.auditSFTP(r, auditSFTPMkdir, server, map[string]interface{}{"path": p, "parents": req.Parents}, err)
+	if err != nil {
+		respondSFTPError(w, "create directory", err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]string{"path": p})
+}
+
+type renameRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RenameFile moves a file or directory; an existing target is never replaced
+func (h *Handlers) RenameFile(w http.ResponseWriter, r *http.Request) {
+	var req renameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" || req.To == "" {
+		respondError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+	from, to := path.Clean(req.From), path.Clean(req.To)
+
+	server, client := h.openSFTP(w, r)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	var err error
+	if _, serr := client.Lstat(to); serr == nil {
+		err = fmt.Errorf("%s: %w", to, os.ErrExist)
+	} else {
+		err = client.Rename(from, to)
+	}
+	h.auditSFTP(r, auditSFTPRename, server, map[string]interface{}{"from": from, "to": to}, err)
+	if err != nil {
+		respondSFTPError(w, "rename", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"from": from, "to": to})
+}
+
+// DeleteFile removes the file or empty directory at ?path=, or a directory
+// and its contents with ?recursive=true
+func (h *Handlers) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		respondError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	p = path.Clean(p)
+	if p == "/" || p == "." {
+		respondError(w, http.StatusBadRequest, "Refusing to delete the root or login directory")
+		return
+	}
+	recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive"))
+
+	server, client := h.openSFTP(w, r)
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	var err error
+	if recursive {
+		err = client.RemoveAll(p)
+	} else if fi, serr := client.Lstat(p); serr != nil {
+		err = serr
+	} else if fi.IsDir() {
+		err = client.RemoveDirectory(p)
+	} else {
+		err = client.Remove(p)
+	}
+	h.auditSFTP(r, auditSFTPDelete, server, map[string]interface{}{"path": p, "recursive": recursive}, err)
+	if err != nil {
+		respondSFTPError(w, "delete", err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Deleted"})
+}
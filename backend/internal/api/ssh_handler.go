@@ -1,11 +1,16 @@
 package api
 
 import (
-	"fmt"
+	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/recording"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/ssh"
@@ -15,6 +20,7 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for development
 	},
+	Subprotocols: []string{sshSubprotocol},
 }
 
 func (h *Handlers) HandleSSH(w http.ResponseWriter, r *http.Request) {
@@ -53,6 +59,14 @@ func (h *Handlers) HandleSSH(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	// Opening a shell may require a recent second factor or security key
+	// assertion on top of the login
+	if kind, msg := h.sshStepUp(claims.SessionID); kind != "" {
+		http.Error(w, msg, http.StatusForbidden)
+		return
+	}
+
+	cols, rows := terminalSize(r.URL.Query().Get("cols"), 80), terminalSize(r.URL.Query().Get("rows"), 24)
 
 	// Upgrade to WebSocket
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -60,33 +74,21 @@ func (h *Handlers) HandleSSH(w http.ResponseWriter, r *http.Request) {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
-	defer conn.Close()
+	tc := newTerminalConn(conn, conn.Subprotocol() == sshSubprotocol || r.URL.Query().Get("protocol") == "v1")
+	defer tc.close()
 
-	// SSH connection
-	if server.SSHUsername == nil || *server.SSHUsername == "" {
-		conn.WriteMessage(websocket.TextMessage, []byte("Error: SSH username not configured for this server"))
-		return
-	}
-
-	config := &ssh.ClientConfig{
-		User: *server.SSHUsername,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(""), // You'll need to implement proper auth
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // WARNING: Use proper host key verification in production
-	}
-
-	sshAddr := fmt.Sprintf("%s:%d", server.IPAddress, server.SSHPort)
-	client, err := ssh.Dial("tcp", sshAddr, config)
+	// SSH connection, authenticated from the vault, the server's key file or
+	// ssh-agent; anything else the server asks for is prompted in the terminal
+	client, err := h.ssh.DialInteractive(r.Context(), server, tc.prompter())
 	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: Failed to connect to SSH server: %v", err)))
+		tc.sendError("Failed to connect to SSH server: %v", err)
 		return
 	}
 	defer client.Close()
 
 	session, err := client.NewSession()
 	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: Failed to create SSH session: %v", err)))
+		tc.sendError("Failed to create SSH session: %v", err)
 		return
 	}
 	defer session.Close()
@@ -98,77 +100,156 @@ func (h *Handlers) HandleSSH(w http.ResponseWriter, r *http.Request) {
 		ssh.TTY_OP_OSPEED: 14400,
 	}
 
-	if err := session.RequestPty("xterm-256color", 40, 80, modes); err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: Failed to request PTY: %v", err)))
+	if err := session.RequestPty("xterm-256color", rows, cols, modes); err != nil {
+		tc.sendError("Failed to request PTY: %v", err)
 		return
 	}
 
 	stdin, err := session.StdinPipe()
 	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: Failed to get stdin: %v", err)))
+		tc.sendError("Failed to get stdin: %v", err)
 		return
 	}
 
-	stdout, err := session.StdoutPipe()
-	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: Failed to get stdout: %v", err)))
-		return
+	// Sessions are recorded from before the shell starts; a session that
+	// cannot be recorded is not opened.
+	var rec *recording.Recorder
+	output := io.Writer(tc)
+	if h.recordings != nil {
+		rec, err = h.startRecording(r, userID, server, cols, rows)
+		if err != nil {
+			log.Printf("Failed to start recording for server %s: %v", serverID, err)
+			tc.sendError("Failed to start session recording")
+			return
+		}
+		output = io.MultiWriter(rec, tc)
 	}
 
-	stderr, err := session.StderrPipe()
-	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: Failed to get stderr: %v", err)))
-		return
-	}
+	// Wait returns once all output has been copied to the WebSocket.
+	session.Stdout = output
+	session.Stderr = output
 
 	if err := session.Shell(); err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Error: Failed to start shell: %v", err)))
+		if rec != nil {
+			rec.Close(nil)
+		}
+		tc.sendError("Failed to start shell: %v", err)
 		return
 	}
+	tc.send(terminalMessage{Type: msgReady})
 
-	// Copy SSH output to WebSocket
+	done := make(chan struct{})
+	defer close(done)
+	go tc.keepalive(done)
+	go sshKeepalive(client, done)
+
+	// Report the exit status and close the WebSocket, which ends the read loop
 	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := stdout.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Println("Stdout read error:", err)
-				}
-				return
-			}
-			if err := conn.WriteMessage(websocket.TextMessage, buf[:n]); err != nil {
-				return
+		exit := exitMessage(session.Wait())
+		if rec != nil {
+			if err := rec.Close(exit.Code); err != nil {
+				log.Printf("Failed to finish recording %s: %v", rec.ID(), err)
 			}
 		}
+		tc.send(exit)
+		tc.close()
 	}()
 
-	go func() {
-		buf := make([]byte, 4096)
-		for {
-			n, err := stderr.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Println("Stderr read error:", err)
-				}
-				return
+	// Copy WebSocket input to SSH
+	for {
+		msg, err := tc.read()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && !errors.Is(err, net.ErrClosed) {
+				log.Println("SSH WebSocket read error:", err)
 			}
-			if err := conn.WriteMessage(websocket.TextMessage, buf[:n]); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case msgInput:
+			if rec != nil && h.recordings.RecordInput() {
+				rec.Input([]byte(msg.Data))
+			}
+			if _, err := io.WriteString(stdin, msg.Data); err != nil {
 				return
 			}
+		case msgResize:
+			if msg.Cols < 1 || msg.Rows < 1 || msg.Cols > maxTerminalSize || msg.Rows > maxTerminalSize {
+				tc.sendError("Invalid terminal size %dx%d", msg.Cols, msg.Rows)
+				continue
+			}
+			if err := session.WindowChange(msg.Rows, msg.Cols); err != nil {
+				log.Println("SSH window change error:", err)
+			}
+			if rec != nil {
+				rec.Resize(msg.Cols, msg.Rows)
+			}
+		case msgPing:
+			tc.send(terminalMessage{Type: msgPong, Data: msg.Data})
+		case msgAuthResponse:
+			// Late answer to a prompt that has been satisfied already
+		default:
+			tc.sendError("Unknown message type %q", msg.Type)
 		}
-	}()
+	}
+}
 
-	// Copy WebSocket input to SSH
+// startRecording opens the recording of a terminal session.
+func (h *Handlers) startRecording(r *http.Request, userID string, server *database.Server, cols, rows int) (*recording.Recorder, error) {
+	s := recording.Session{
+		UserID:         userID,
+		ServerID:       server.ID,
+		ServerHostname: server.Hostname,
+		RemoteAddr:     r.RemoteAddr,
+		Cols:           cols,
+		Rows:           rows,
+	}
+	if user, err := h.stores.Users.GetByID(userID); err == nil {
+		s.UserEmail = user.Email
+	}
+	return h.recordings.Start(s)
+}
+
+// terminalSize parses an initial cols or rows query parameter.
+func terminalSize(v string, def int) int {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxTerminalSize {
+		return def
+	}
+	return n
+}
+
+// sshKeepalive detects dead SSH connections through NAT and firewalls, like
+// OpenSSH's ServerAliveInterval, closing the client when a keepalive fails.
+func sshKeepalive(client *ssh.Client, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
 	for {
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			break
-		}
-		if _, err := stdin.Write(message); err != nil {
-			break
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				client.Close()
+				return
+			}
 		}
 	}
+}
 
-	session.Wait()
+// exitMessage describes how the shell ended.
+func exitMessage(err error) terminalMessage {
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		code := 0
+		return terminalMessage{Type: msgExit, Code: &code}
+	case errors.As(err, &exitErr):
+		if exitErr.Signal() != "" {
+			return terminalMessage{Type: msgExit, Signal: exitErr.Signal(), Message: exitErr.Msg()}
+		}
+		code := exitErr.ExitStatus()
+		return terminalMessage{Type: msgExit, Code: &code}
+	}
+	return terminalMessage{Type: msgExit, Message: err.Error()}
 }
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/sshclient"
+	"github.com/gorilla/websocket"
+)
+
+// sshSubprotocol selects the framed terminal protocol, as does ?protocol=v1.
+// Every other client gets the raw compatibility mode, where every message is
+// terminal input or output text.
+//
+// In the framed protocol the client sends terminal input as binary messages
+// and control messages as JSON text messages:
+//
+//	{"type":"input","data":"ls\r"}
+//	{"type":"resize","cols":120,"rows":40}
+//	{"type":"ping","data":"<echoed>"}
+//	{"type":"auth_response","answers":["..."]}
+//
+// The server sends terminal output as binary messages, so non-UTF-8 output
+// survives, and control messages as JSON text messages:
+//
+//	{"type":"auth_prompt","name":"","instruction":"","prompts":[{"prompt":"Password: ","echo":false}]}
+//	{"type":"ready"}
+//	{"type":"pong","data":"<echoed>"}
+//	{"type":"exit","code":0}  (or "signal" when the shell was killed)
+//	{"type":"error","message":"..."}
+const sshSubprotocol = "ssh.v1"
+
+const (
+	maxTerminalMessage = 64 << 10
+	maxTerminalSize    = 1000
+	// wsPingInterval is how often the server pings the browser; a connection
+	// without a pong for wsPongWait is closed.
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 75 * time.Second
+)
+
+// Terminal message types.
+const (
+	msgInput        = "input"
+	msgResize       = "resize"
+	msgPing         = "ping"
+	msgPong         = "pong"
+	msgAuthPrompt   = "auth_prompt"
+	msgAuthResponse = "auth_response"
+	msgReady        = "ready"
+	msgExit         = "exit"
+	msgError        = "error"
+)
+
+type authPrompt struct {
+	Prompt string `json:"prompt"`
+	Echo   bool   `json:"echo"`
+}
+
+// terminalMessage is a JSON control message in either direction.
+type terminalMessage struct {
+	Type        string       `json:"type"`
+	Data        string       `json:"data,omitempty"`
+	Cols        int          `json:"cols,omitempty"`
+	Rows        int          `json:"rows,omitempty"`
+	Answers     []string     `json:"answers,omitempty"`
+	Name        string       `json:"name,omitempty"`
+	Instruction string       `json:"instruction,omitempty"`
+	Prompts     []authPrompt `json:"prompts,omitempty"`
+	Code        *int         `json:"code,omitempty"`
+	Signal      string       `json:"signal,omitempty"`
+	Message     string       `json:"message,omitempty"`
+}
+
+// terminalConn speaks either protocol over a WebSocket and serializes writes,
+// which gorilla/websocket requires.
+type terminalConn struct {
+	ws     *websocket.Conn
+	framed bool
+	mu     sync.Mutex
+}
+
+func newTerminalConn(ws *websocket.Conn, framed bool) *terminalConn {
+	ws.SetReadLimit(maxTerminalMessage)
+	return &terminalConn{ws: ws, framed: framed}
+}
+
+func (c *terminalConn) write(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteMessage(messageType, data)
+}
+
+// Write sends terminal output; it is the session's stdout and stderr.
+func (c *terminalConn) Write(p []byte) (int, error) {
+	messageType := websocket.TextMessage
+	if c.framed {
+		messageType = websocket.BinaryMessage
+	}
+	if err := c.write(messageType, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// send writes a control message. Compatibility mode only has room for
+// errors, which are written as terminal text.
+func (c *terminalConn) send(msg terminalMessage) error {
+	if !c.framed {
+		if msg.Type == msgError {
+			return c.write(websocket.TextMessage, []byte("Error: "+msg.Message))
+		}
+		return nil
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.write(websocket.TextMessage, data)
+}
+
+func (c *terminalConn) sendError(format string, args ...interface{}) {
+	c.send(terminalMessage{Type: msgError, Message: fmt.Sprintf(format, args...)})
+}
+
+// read returns the next client message. Binary messages, text in
+// compatibility mode and input control messages are all returned as input.
+func (c *terminalConn) read() (terminalMessage, error) {
+	messageType, data, err := c.ws.ReadMessage()
+	if err != nil {
+		return terminalMessage{}, err
+	}
+	if !c.framed || messageType == websocket.BinaryMessage {
+		return terminalMessage{Type: msgInput, Data: string(data)}, nil
+	}
+	var msg terminalMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return terminalMessage{}, fmt.Errorf("invalid control message: %w", err)
+	}
+	return msg, nil
+}
+
+// keepalive pings the browser until done is closed; a missing pong makes
+// the next read fail.
+func (c *terminalConn) keepalive(done <-chan struct{}) {
+	c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close sends a normal closure and closes the connection.
+func (c *terminalConn) close() {
+	c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	c.ws.Close()
+}
+
+// promptTimeout bounds how long the terminal waits for an answer to an
+// authentication prompt.
+const promptTimeout = 2 * time.Minute
+
+// prompter asks keyboard-interactive questions before the shell starts: as
+// an auth_prompt message in the framed protocol, and in compatibility mode by
+// writing each question to the terminal and reading the answer up to the
+// first Enter.
+func (c *terminalConn) prompter() sshclient.Prompter {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		c.ws.SetReadDeadline(time.Now().Add(promptTimeout))
+		defer c.ws.SetReadDeadline(time.Time{})
+
+		if c.framed {
+			return c.promptFramed(name, instruction, questions, echos)
+		}
+		return c.promptText(name, instruction, questions, echos)
+	}
+}
+
+func (c *terminalConn) promptFramed(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	prompts := make([]authPrompt, len(questions))
+	for i, q := range questions {
+		prompts[i] = authPrompt{Prompt: q, Echo: echos[i]}
+	}
+	if err := c.send(terminalMessage{Type: msgAuthPrompt, Name: name, Instruction: instruction, Prompts: prompts}); err != nil {
+		return nil, err
+	}
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if msg.Type != msgAuthResponse {
+			continue
+		}
+		if len(msg.Answers) != len(questions) {
+			return nil, errors.New("wrong number of answers")
+		}
+		return msg.Answers, nil
+	}
+}
+
+func (c *terminalConn) promptText(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	for _, text := range []string{name, instruction} {
+		if text != "" {
+			c.write(websocket.TextMessage, []byte(text+"\r\n"))
+		}
+	}
+
+	answers := make([]string, len(questions))
+	for i, q := range questions {
+		if err := c.write(websocket.TextMessage, []byte(q)); err != nil {
+			return nil, err
+		}
+		var line []rune
+	read:
+		for {
+			msg, err := c.read()
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range msg.Data {
+				switch r {
+				case '\r', '\n':
+					break read
+				case 0x7f, '\b':
+					if len(line) > 0 {
+						line = line[:len(line)-1]
+						if echos[i] {
+							c.write(websocket.TextMessage, []byte("\b \b"))
+						}
+					}
+				case 0x03:
+					return nil, errors.New("authentication cancelled")
+				default:
+					line = append(line, r)
+					if echos[i] {
+						c.write(websocket.TextMessage, []byte(string(r)))
+					}
+				}
+			}
+		}
+		c.write(websocket.TextMessage, []byte("\r\n"))
+		answers[i] = string(line)
+	}
+	return answers, nil
+}
@@ -2,7 +2,9 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/cmdb/backend/internal/auth"
 	"github.com/cmdb/backend/internal/database"
@@ -20,6 +22,17 @@ func (h *Handlers) ListSSLCertificates(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policies, err := h.stores.Policies.Load()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	for _, cert := range certs {
+		cert.AlertPolicy = policies.For(cert)
+		cert.Severity = cert.AlertPolicy.ExpirySeverity(cert.ExpiresAt, now)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(certs)
 }
@@ -78,6 +91,21 @@ func (h *Handlers) GetSSLCertificate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	findings, err := h.stores.SSL.ListFindings(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch certificate findings")
+		return
+	}
+	cert.Findings = findings
+
+	policies, err := h.stores.Policies.Load()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch alert policies")
+		return
+	}
+	cert.AlertPolicy = policies.For(cert)
+	cert.Severity = cert.AlertPolicy.ExpirySeverity(cert.ExpiresAt, time.Now())
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(cert)
 }
@@ -126,6 +154,95 @@ func (h *Handlers) DeleteSSLCertificate(w http.ResponseWriter, r *http.Request)
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	// Resolve the certificate's alerts right away
+	h.alerts.Trigger()
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Certificate deleted successfully"})
 }
+
+// ScanSSLCertificate performs a live TLS handshake for one certificate and updates its details
+func (h *Handlers) ScanSSLCertificate(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	cert, err := h.stores.SSL.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Certificate not found")
+		return
+	}
+
+	if _, err := h.certScanner.ScanCertificate(r.Context(), cert); err != nil {
+		respondError(w, http.StatusBadGateway, fmt.Sprintf("Scan failed: %v", err))
+		return
+	}
+
+	updated, err := h.stores.SSL.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, updated)
+}
+
+// ScanAllSSLCertificates rescans every certificate immediately
+func (h *Handlers) ScanAllSSLCertificates(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	scanned, failed := h.certScanner.ScanAll(r.Context())
+
+	respondJSON(w, http.StatusOK, map[string]int{
+		"scanned": scanned,
+		"failed":  failed,
+	})
+}
+
+// DiscoverServerCertificates scans a server's ports and records every TLS certificate found
+func (h *Handlers) DiscoverServerCertificates(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	server, err := h.stores.Servers.GetByID(vars["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	var req struct {
+		Ports []int `json:"ports"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	ports, err := h.certScanner.DiscoveryPorts(server, req.Ports)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load server ports")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, h.certScanner.Discover(r.Context(), server, ports))
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"crypto/x509"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/certscan"
+	"github.com/cmdb/backend/internal/database"
+)
+
+const maxCertificateUploadSize = 1 << 20
+
+// UploadSSLCertificate registers certificates from an uploaded PEM, DER or
+// PKCS#12 file. It expects multipart/form-data with the fields file,
+// server_id and optionally password, domain, auto_renew and store_private_key.
+func (h *Handlers) UploadSSLCertificate(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCertificateUploadSize+64<<10)
+	if err := r.ParseMultipartForm(maxCertificateUploadSize); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid multipart form or file too large")
+		return
+	}
+
+	serverID := r.FormValue("server_id")
+	if serverID == "" {
+		respondError(w, http.StatusBadRequest, "server_id is required")
+		return
+	}
+	if _, err := h.stores.Servers.GetByID(serverID); err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	autoRenew, _ := strconv.ParseBool(r.FormValue("auto_renew"))
+	storeKey, _ := strconv.ParseBool(r.FormValue("store_private_key"))
+	if storeKey && h.sealer == nil {
+		respondError(w, http.StatusBadRequest, "Encrypted key store is not configured (set SECRETS_MASTER_KEY)")
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Failed to read file")
+		return
+	}
+
+	bundle, err := certscan.ParseBundle(data, r.FormValue("password"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chains := bundle.Chains()
+	domainOverride := r.FormValue("domain")
+	if domainOverride != "" && len(chains) > 1 {
+		respondError(w, http.StatusBadRequest, "domain can only be set when the file contains a single certificate chain")
+		return
+	}
+	if storeKey {
+		matched := false
+		for _, chain := range chains {
+			if _, _, ok := bundle.KeyFor(chain[0]); ok {
+				matched = true
+			}
+		}
+		if !matched {
+			respondError(w, http.StatusBadRequest, "store_private_key was requested but the file contains no private key for its certificates")
+			return
+		}
+	}
+
+	// Every chain is checked before anything is written, so a bad chain
+	// later in the file cannot leave the earlier ones half registered.
+	type upload struct {
+		chain  []*x509.Certificate
+		domain string
+		result *database.CertScanResult
+	}
+	now := time.Now()
+	uploads := make([]upload, 0, len(chains))
+	for _, chain := range chains {
+		domain := domainOverride
+		if domain == "" {
+			domain = certscan.PrimaryDomain(chain[0])
+		}
+		if domain == "" {
+			respondError(w, http.StatusBadRequest, "Certificate has no subject name, set domain explicitly")
+			return
+		}
+		result := certscan.ParseChain(chain)
+		result.Findings = certscan.Analyze(domain, chain, nil, now)
+		uploads = append(uploads, upload{chain: chain, domain: domain, result: result})
+	}
+
+	keyStored := false
+	var saved []*database.SSLCertificate
+	for _, u := range uploads {
+		leaf, domain, result := u.chain[0], u.domain, u.result
+
+		cert, err := h.stores.SSL.FindUploaded(serverID, domain, result.FingerprintSHA256)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to look up certificate")
+			return
+		}
+		if cert == nil {
+			issuer := result.Issuer
+			cert, err = h.stores.SSL.Create(&database.SSLCertificate{
+				ServerID:  serverID,
+				Domain:    domain,
+				Issuer:    &issuer,
+				IssuedAt:  result.IssuedAt,
+				ExpiresAt: result.ExpiresAt,
+				Status:    "active",
+				AutoRenew: autoRenew,
+				Source:    "upload",
+			})
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to create certificate")
+				return
+			}
+		}
+
+		if err := h.stores.SSL.UpdateScanResult(cert.ID, result); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to store certificate details")
+			return
+		}
+
+		if storeKey {
+			if der, keyType, ok := bundle.KeyFor(leaf); ok {
+				sealed, err := h.sealer.Seal(der, []byte(cert.ID))
+				if err != nil {
+					respondError(w, http.StatusInternalServerError, "Failed to encrypt private key")
+					return
+				}
+				if err := h.stores.SSL.StorePrivateKey(cert.ID, keyType, sealed); err != nil {
+					respondError(w, http.StatusInternalServerError, "Failed to store private key")
+					return
+				}
+				keyStored = true
+			}
+		}
+
+		updated, err := h.stores.SSL.GetByID(cert.ID)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to fetch certificate")
+			return
+		}
+		updated.Findings = result.Findings
+		saved = append(saved, updated)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"certificates":       saved,
+		"private_key_stored": keyStored,
+	})
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditLoginFailed    = "auth.login.failed"
+	auditLockedOut      = "auth.lockout.created"
+	auditLockoutCleared = "auth.lockout.cleared"
+	auditUserSignedUp   = "user.signed_up"
+)
+
+// clientIP returns the address a request is throttled by: the last
+// X-Forwarded-For entry when a trusted proxy adds one, else the peer.
+func (h *Handlers) clientIP(r *http.Request) string {
+	if h.authCfg.TrustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			entries := strings.Split(values[len(values)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// respondThrottled maps errors of the throttle to responses. Refused
+// attempts get 429 with Retry-After.
+func respondThrottled(w http.ResponseWriter, err error) {
+	var throttled *auth.ThrottledError
+	if !errors.As(err, &throttled) {
+		log.Printf("Failed to check login throttle: %v", err)
+		respondError(w, http.StatusServiceUnavailable, "Authentication service unavailable")
+		return
+	}
+
+	seconds := int(math.Ceil(throttled.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	message := "Too many attempts, try again later"
+	if throttled.Locked {
+		message = "Too many failed logins, try again later"
+	}
+	respondJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// loginFailed counts and audits a failed login, and audits the lockouts
+// it caused.
+func (h *Handlers) loginFailed(ip, login string) {
+	subject := auth.AccountSubject(login)
+	h.recordAudit("", auditLoginFailed, auth.ScopeAccount, subject, map[string]interface{}{"ip": ip})
+
+	lockouts, err := h.throttle.LoginFailed(ip, login)
+	if err != nil {
+		log.Printf("Failed to record failed login of %s: %v", subject, err)
+	}
+	for _, l := range lockouts {
+		h.recordAudit("", auditLockedOut, l.Scope, l.Subject, map[string]interface{}{
+			"failures":     l.Failures,
+			"locked_until": l.LockedUntil,
+			"ip":           ip,
+		})
+	}
+}
+
+// signUpApproval applies the sign-up policy to an email address. It
+// returns whether the new user is approved right away, or why the sign-up
+// is refused.
+func (h *Handlers) signUpApproval(email string) (approved bool, refusal string) {
+	switch h.authCfg.SignUpPolicy {
+	case auth.SignUpOpen:
+		return true, ""
+	case auth.SignUpDomain:
+		domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+		for _, d := range h.authCfg.SignUpDomains {
+			if domain == d {
+				return true, ""
+			}
+		}
+		return false, "Sign-up is limited to approved email domains"
+	case auth.SignUpInvite:
+		return false, "Sign-up requires an invitation"
+	default:
+		return false, ""
+	}
+}
+
+// ListLockouts returns the accounts and addresses locked out after too
+// many failed logins
+func (h *Handlers) ListLockouts(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	lockouts, err := h.throttle.Lockouts()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch lockouts")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, lockouts)
+}
+
+// DeleteLockout unlocks an account (scope account, subject the login name)
+// or an address (scope ip)
+func (h *Handlers) DeleteLockout(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	vars := mux.Vars(r)
+	scope, subject := vars["scope"], vars["subject"]
+	if scope != auth.ScopeAccount && scope != auth.ScopeIP {
+		respondError(w, http.StatusBadRequest, "Scope must be account or ip")
+		return
+	}
+
+	unlocked, err := h.throttle.Unlock(scope, subject)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to unlock")
+		return
+	}
+	if !unlocked {
+		respondError(w, http.StatusNotFound, "Not locked out")
+		return
+	}
+	h.recordAudit(userID, auditLockoutCleared, scope, subject, nil)
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Unlocked"})
+}
+
+// UnlockUser unlocks a user's account under each name they can log in
+// with
+func (h *Handlers) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	if !isAdmin {
+		respondError(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	user, err := h.stores.Users.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	var unlockedAny bool
+	for _, login := range []string{user.Email, user.Username} {
+		unlocked, err := h.throttle.Unlock(auth.ScopeAccount, login)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to unlock user")
+			return
+		}
+		if unlocked {
+			unlockedAny = true
+			h.recordAudit(userID, auditLockoutCleared, auth.ScopeAccount, auth.AccountSubject(login), map[string]interface{}{"user_id": id})
+		}
+	}
+	if !unlockedAny {
+		respondError(w, http.StatusNotFound, "User is not locked out")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "User unlocked"})
+}
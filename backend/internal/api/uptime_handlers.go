@@ -0,0 +1,184 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/uptime"
+	"github.com/gorilla/mux"
+)
+
+const defaultUptimeRange = 30 * 24 * time.Hour
+
+type portUptime struct {
+	PortID        string        `json:"port_id"`
+	Port          int           `json:"port"`
+	Transport     string        `json:"transport"`
+	Protocol      *string       `json:"protocol"`
+	ExpectedState string        `json:"expected_state"`
+	Report        uptime.Report `json:"report"`
+}
+
+type serverUptime struct {
+	ServerID string        `json:"server_id"`
+	Hostname string        `json:"hostname"`
+	Report   uptime.Report `json:"report"`
+	Ports    []portUptime  `json:"ports,omitempty"`
+}
+
+// parseUptimeRange reads the from/to query parameters (RFC 3339). The range
+// defaults to the last 30 days.
+func parseUptimeRange(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be an RFC 3339 timestamp")
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultUptimeRange)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be an RFC 3339 timestamp")
+		}
+		from = t
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
+
+func (h *Handlers) serverUptime(server *database.Server, from, to time.Time, withPorts bool) (*serverUptime, error) {
+	samples, err := h.stores.Probes.Samples(server.ID, nil, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &serverUptime{
+		ServerID: server.ID,
+		Hostname: server.Hostname,
+		Report:   uptime.Compute(samples, from, to),
+	}
+	if !withPorts {
+		return result, nil
+	}
+
+	ports, err := h.stores.Ports.ListByServer(server.ID)
+	if err != nil {
+		return nil, err
+	}
+	result.Ports = []portUptime{}
+	for _, port := range ports {
+		portID := port.ID
+		samples, err := h.stores.Probes.Samples(server.ID, &portID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		result.Ports = append(result.Ports, portUptime{
+			PortID:        port.ID,
+			Port:          port.Port,
+			Transport:     port.Transport,
+			Protocol:      port.Protocol,
+			ExpectedState: port.ExpectedState,
+			Report:        uptime.Compute(samples, from, to),
+		})
+	}
+	return result, nil
+}
+
+// GetServerUptime returns uptime, outages and MTTR for a server and each of its ports
+func (h *Handlers) GetServerUptime(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if !isAdmin {
+		hasAccess, _ := h.stores.Permissions.HasAccess(userID, id)
+		if !hasAccess {
+			respondError(w, http.StatusForbidden, "Access denied")
+			return
+		}
+	}
+
+	from, to, err := parseUptimeRange(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	server, err := h.stores.Servers.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+
+	result, err := h.serverUptime(server, from, to, true)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to compute uptime")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// GetGroupUptime returns a per-server uptime report for a group plus a group-level rollup
+func (h *Handlers) GetGroupUptime(w http.ResponseWriter, r *http.Request) {
+	userID := auth.GetUserID(r.Context())
+	isAdmin, _ := h.stores.Users.HasRole(userID, "admin")
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	from, to, err := parseUptimeRange(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	group, err := h.stores.Groups.GetByID(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Group not found")
+		return
+	}
+
+	servers, err := h.stores.Servers.ListByGroup(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch servers")
+		return
+	}
+
+	results := []*serverUptime{}
+	var reports []uptime.Report
+	for _, server := range servers {
+		if !isAdmin {
+			hasAccess, _ := h.stores.Permissions.HasAccess(userID, server.ID)
+			if !hasAccess {
+				continue
+			}
+		}
+		result, err := h.serverUptime(server, from, to, false)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to compute uptime")
+			return
+		}
+		results = append(results, result)
+		reports = append(reports, result.Report)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"group_id":   group.ID,
+		"group_name": group.Name,
+		"report":     uptime.Merge(reports, from, to),
+		"servers":    results,
+	})
+}
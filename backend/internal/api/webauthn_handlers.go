@@ -0,0 +1,468 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/oidc"
+	"github.com/cmdb/backend/internal/passkey"
+	"github.com/gorilla/mux"
+)
+
+const (
+	auditWebAuthnRegistered = "auth.webauthn.registered"
+	auditWebAuthnRemoved    = "auth.webauthn.removed"
+	auditWebAuthnLogin      = "auth.webauthn.login"
+	auditWebAuthnFailed     = "auth.webauthn.failed"
+	auditWebAuthnCloned     = "auth.webauthn.clone_detected"
+)
+
+// Purposes of WebAuthn ceremonies; a ceremony can only be finished by the
+// endpoint it was begun for.
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+	ceremonyMFA      = "mfa"
+	ceremonyStepUp   = "step_up"
+)
+
+// ceremonyResponse carries the options for navigator.credentials.create or
+// .get, and the id to finish the ceremony with.
+type ceremonyResponse struct {
+	CeremonyID string      `json:"ceremony_id"`
+	Options    interface{} `json:"options"`
+}
+
+type ceremonyRequest struct {
+	CeremonyID string          `json:"ceremony_id"`
+	Credential json.RawMessage `json:"credential"`
+	// Name labels a new credential; only used at registration.
+	Name string `json:"name"`
+}
+
+func (h *Handlers) requirePasskeys(w http.ResponseWriter) bool {
+	if h.passkeys == nil {
+		respondError(w, http.StatusNotFound, "WebAuthn is not enabled")
+		return false
+	}
+	return true
+}
+
+// beginCeremony stores the state of a ceremony and responds with its
+// options.
+func (h *Handlers) beginCeremony(w http.ResponseWriter, purpose string, userID *string, options interface{}, state []byte) {
+	id, err := oidc.RandomString()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start ceremony")
+		return
+	}
+	err = h.stores.WebAuthn.CreateCeremony(auth.HashToken(id), &database.WebAuthnCeremony{
+		Purpose:     purpose,
+		UserID:      userID,
+		SessionData: state,
+		ExpiresAt:   time.Now().Add(h.passkeys.Timeout()),
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start ceremony")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, ceremonyResponse{CeremonyID: id, Options: options})
+}
+
+// finishCeremony decodes the browser's response and consumes the ceremony
+// it answers. It writes the error response and returns nil on failure.
+func (h *Handlers) finishCeremony(w http.ResponseWriter, r *http.Request, purpose string) (*database.WebAuthnCeremony, *ceremonyRequest) {
+	var req ceremonyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CeremonyID == "" || len(req.Credential) == 0 {
+		respondError(w, http.StatusBadRequest, "ceremony_id and credential are required")
+		return nil, nil
+	}
+	c, err := h.stores.WebAuthn.ConsumeCeremony(auth.HashToken(req.CeremonyID), purpose)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusBadRequest, "Ceremony expired or unknown, start again")
+		return nil, nil
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load ceremony")
+		return nil, nil
+	}
+	return c, &req
+}
+
+// userCredentials returns a user and their WebAuthn credentials.
+func (h *Handlers) userCredentials(userID string) (*database.User, []*database.WebAuthnCredential, error) {
+	user, err := h.stores.Users.GetByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	creds, err := h.stores.WebAuthn.ListByUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, creds, nil
+}
+
+// recordAssertion stores the counter of the credential used, or audits a
+// failed or cloned assertion. It reports whether the assertion is valid.
+func (h *Handlers) recordAssertion(w http.ResponseWriter, userID string, cred *database.WebAuthnCredential, err error) bool {
+	if errors.Is(err, passkey.ErrClonedAuthenticator) {
+		h.recordAudit(userID, auditWebAuthnCloned, "user", userID, nil)
+		respondError(w, http.StatusUnauthorized, "Security key rejected, it may have been cloned")
+		return false
+	}
+	if err != nil {
+		log.Printf("WebAuthn assertion of %s: %v", userID, err)
+		if userID != "" {
+			h.recordAudit(userID, auditWebAuthnFailed, "user", userID, nil)
+		}
+		respondError(w, http.StatusUnauthorized, "WebAuthn verification failed")
+		return false
+	}
+	if err := h.stores.WebAuthn.RecordUse(cred.CredentialID, cred.SignCount, cred.BackupState); err != nil {
+		log.Printf("Failed to record use of WebAuthn credential %s: %v", cred.ID, err)
+	}
+	return true
+}
+
+// requireFreshWebAuthn checks that the request's session made a WebAuthn
+// assertion within the step-up TTL if op requires one. It writes a 403
+// naming the missing step-up otherwise.
+func (h *Handlers) requireFreshWebAuthn(w http.ResponseWriter, r *http.Request, op string) bool {
+	if h.hasFreshWebAuthn(auth.GetSessionID(r.Context()), op) {
+		return true
+	}
+	respondJSON(w, http.StatusForbidden, map[string]string{
+		"error":   "A fresh security key or passkey verification is required",
+		"step_up": "webauthn",
+	})
+	return false
+}
+
+func (h *Handlers) hasFreshWebAuthn(sessionID, op string) bool {
+	if h.passkeys == nil || !h.passkeys.RequiresStepUp(op) {
+		return true
+	}
+	at, err := h.stores.Sessions.WebAuthnAt(sessionID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to check WebAuthn step-up of session %s: %v", sessionID, err)
+		}
+		return false
+	}
+	return at != nil && time.Since(*at) < h.passkeys.StepUpTTL()
+}
+
+// ListWebAuthnCredentials returns the caller's security keys and passkeys
+func (h *Handlers) ListWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasskeys(w) {
+		return
+	}
+
+	creds, err := h.stores.WebAuthn.ListByUser(auth.GetUserID(r.Context()))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch credentials")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, creds)
+}
+
+// BeginWebAuthnRegistration starts registering a security key or passkey
+// for the caller. Users with a second factor must have verified it recently
+func (h *Handlers) BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasskeys(w) || !h.requireStepUpForNewFactor(w, r) {
+		return
+	}
+
+	userID := auth.GetUserID(r.Context())
+	user, creds, err := h.userCredentials(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+	options, state, err := h.passkeys.BeginRegistration(user, creds)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start registration")
+		return
+	}
+
+	h.beginCeremony(w, ceremonyRegister, &userID, options, state)
+}
+
+// FinishWebAuthnRegistration stores the credential created by the browser.
+// The optional name tells the user's credentials apart
+func (h *Handlers) FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasskeys(w) || !h.requireStepUpForNewFactor(w, r) {
+		return
+	}
+
+	c, req := h.finishCeremony(w, r, ceremonyRegister)
+	if c == nil {
+		return
+	}
+	userID := auth.GetUserID(r.Context())
+	if c.UserID == nil || *c.UserID != userID {
+		respondError(w, http.StatusBadRequest, "Ceremony expired or unknown, start again")
+		return
+	}
+	user, creds, err := h.userCredentials(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+	cred, err := h.passkeys.FinishRegistration(user, creds, c.SessionData, req.Credential)
+	if err != nil {
+		log.Printf("WebAuthn registration of %s: %v", userID, err)
+		respondError(w, http.StatusBadRequest, "WebAuthn registration failed")
+		return
+	}
+	cred.Name = strings.TrimSpace(req.Name)
+	if cred.Name == "" {
+		cred.Name = "Security key " + time.Now().Format("2006-01-02")
+	}
+	if err := h.stores.WebAuthn.Create(cred); err != nil {
+		respondError(w, http.StatusConflict, "Credential is already registered")
+		return
+	}
+	h.recordAudit(userID, auditWebAuthnRegistered, "user", userID, map[string]interface{}{
+		"credential": cred.ID,
+		"name":       cred.Name,
+	})
+
+	respondJSON(w, http.StatusCreated, cred)
+}
+
+// DeleteWebAuthnCredential removes one of the caller's credentials. The
+// last second factor of a user whose role requires MFA cannot be removed
+func (h *Handlers) DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasskeys(w) {
+		return
+	}
+
+	userID := auth.GetUserID(r.Context())
+	id := mux.Vars(r)["id"]
+
+	creds, err := h.stores.WebAuthn.ListByUser(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch credentials")
+		return
+	}
+	if len(creds) == 1 && creds[0].ID == id {
+		required, err := h.stores.MFA.IsRequired(userID)
+		if err == nil && required {
+			var totp bool
+			totp, err = h.mfaEnabled(userID)
+			required = !totp
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to check MFA policy")
+			return
+		}
+		if required {
+			respondError(w, http.StatusForbidden, "MFA is required for your role")
+			return
+		}
+	}
+
+	deleted, err := h.stores.WebAuthn.Delete(userID, id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete credential")
+		return
+	}
+	if !deleted {
+		respondError(w, http.StatusNotFound, "Credential not found")
+		return
+	}
+	h.recordAudit(userID, auditWebAuthnRemoved, "user", userID, map[string]interface{}{"credential": id})
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Credential deleted"})
+}
+
+// BeginWebAuthnLogin starts an assertion at login. With an mfa_token from
+// Login it is the second factor for that user; without, it is a passkey
+// login that needs neither username nor password
+func (h *Handlers) BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasskeys(w) {
+		return
+	}
+
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	if req.MFAToken == "" {
+		options, state, err := h.passkeys.BeginPasskeyLogin()
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to start login")
+			return
+		}
+		h.beginCeremony(w, ceremonyLogin, nil, options, state)
+		return
+	}
+
+	user := h.challengeUser(w, req.MFAToken)
+	if user == nil {
+		return
+	}
+	creds, err := h.stores.WebAuthn.ListByUser(user.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch credentials")
+		return
+	}
+	if len(creds) == 0 {
+		respondError(w, http.StatusBadRequest, "No security key or passkey is registered")
+		return
+	}
+	options, state, err := h.passkeys.BeginLogin(user, creds)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	h.beginCeremony(w, ceremonyMFA, &user.ID, options, state)
+}
+
+// FinishWebAuthnLogin verifies the assertion begun by BeginWebAuthnLogin
+// and starts a session
+func (h *Handlers) FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasskeys(w) {
+		return
+	}
+
+	var req ceremonyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CeremonyID == "" || len(req.Credential) == 0 {
+		respondError(w, http.StatusBadRequest, "ceremony_id and credential are required")
+		return
+	}
+	c, err := h.stores.WebAuthn.ConsumeCeremony(auth.HashToken(req.CeremonyID), ceremonyMFA, ceremonyLogin)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(w, http.StatusBadRequest, "Ceremony expired or unknown, start again")
+		return
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load ceremony")
+		return
+	}
+
+	var user *database.User
+	var cred *database.WebAuthnCredential
+	if c.Purpose == ceremonyMFA {
+		var creds []*database.WebAuthnCredential
+		user, creds, err = h.userCredentials(*c.UserID)
+		if err != nil {
+			respondError(w, http.StatusUnauthorized, "Account is not active")
+			return
+		}
+		cred, err = h.passkeys.FinishLogin(user, creds, c.SessionData, req.Credential)
+	} else {
+		// Users of an identity provider or directory must sign in there,
+		// so that disabling them at the source locks them out right away
+		// rather than at the next directory sync
+		user, cred, err = h.passkeys.FinishPasskeyLogin(func(userID string) (*database.User, []*database.WebAuthnCredential, error) {
+			u, creds, err := h.userCredentials(userID)
+			if err == nil && u.AuthProvider != database.AuthProviderLocal {
+				err = errors.New("user signs in through " + u.AuthProvider)
+			}
+			return u, creds, err
+		}, c.SessionData, req.Credential)
+	}
+	var userID string
+	if user != nil {
+		userID = user.ID
+	}
+	if !h.recordAssertion(w, userID, cred, err) {
+		return
+	}
+	if !user.Approved {
+		respondError(w, http.StatusForbidden, "Account pending approval")
+		return
+	}
+
+	resp, err := h.loginResponse(r, user, true)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+	if err := h.stores.Sessions.MarkWebAuthn(resp["session_id"].(string)); err != nil {
+		log.Printf("Failed to mark WebAuthn assertion: %v", err)
+	}
+	h.recordAudit(user.ID, auditWebAuthnLogin, "user", user.ID, map[string]interface{}{
+		"credential": cred.ID,
+		"passkey":    c.Purpose == ceremonyLogin,
+	})
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// BeginWebAuthnStepUp starts an assertion proving the caller is present,
+// as required before privileged operations
+func (h *Handlers) BeginWebAuthnStepUp(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasskeys(w) {
+		return
+	}
+
+	userID := auth.GetUserID(r.Context())
+	user, creds, err := h.userCredentials(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+	if len(creds) == 0 {
+		respondError(w, http.StatusBadRequest, "No security key or passkey is registered")
+		return
+	}
+	options, state, err := h.passkeys.BeginLogin(user, creds)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to start verification")
+		return
+	}
+
+	h.beginCeremony(w, ceremonyStepUp, &userID, options, state)
+}
+
+// FinishWebAuthnStepUp verifies the assertion and marks the current session
+// as freshly verified
+func (h *Handlers) FinishWebAuthnStepUp(w http.ResponseWriter, r *http.Request) {
+	if !h.requirePasskeys(w) {
+		return
+	}
+
+	c, req := h.finishCeremony(w, r, ceremonyStepUp)
+	if c == nil {
+		return
+	}
+	userID := auth.GetUserID(r.Context())
+	if c.UserID == nil || *c.UserID != userID {
+		respondError(w, http.StatusBadRequest, "Ceremony expired or unknown, start again")
+		return
+	}
+
+	user, creds, err := h.userCredentials(userID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+	cred, err := h.passkeys.FinishLogin(user, creds, c.SessionData, req.Credential)
+	if !h.recordAssertion(w, userID, cred, err) {
+		return
+	}
+	if err := h.stores.Sessions.MarkWebAuthn(auth.GetSessionID(r.Context())); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to record verification")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"step_up_until": time.Now().Add(h.passkeys.StepUpTTL())})
+}
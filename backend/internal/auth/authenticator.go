@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+// ErrInvalidCredentials is returned by an Authenticator that does not accept
+// a login, so that the next one can be tried.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator checks a login name and password against one source of
+// users. Any error other than ErrInvalidCredentials means the source could
+// not be asked.
+type Authenticator interface {
+	// Name identifies the source, e.g. local or ldap.
+	Name() string
+	Authenticate(ctx context.Context, login, password string) (*database.User, error)
+}
+
+// LocalAuthenticator checks the bcrypt passwords of users who signed up
+// locally.
+type LocalAuthenticator struct {
+	users *database.UserStore
+}
+
+func NewLocalAuthenticator(users *database.UserStore) *LocalAuthenticator {
+	return &LocalAuthenticator{users: users}
+}
+
+func (a *LocalAuthenticator) Name() string {
+	return database.AuthProviderLocal
+}
+
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, login, password string) (*database.User, error) {
+	user, err := a.users.GetByEmail(login)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	// Users of an identity provider must sign in there
+	if user.AuthProvider != database.AuthProviderLocal || !a.users.VerifyPassword(user.Password, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+type Config struct {
+	// AccessTTL is how long an access token is valid. Revoked sessions are
+	// rejected within RevocationSync on every replica, so this mostly bounds
+	// how long a token taken from a client stays useful after logout.
+	AccessTTL time.Duration
+	// RefreshTTL is how long a session lasts without being refreshed.
+	RefreshTTL time.Duration
+	// RefreshReuseGrace tolerates a refresh token replayed this soon after
+	// it was rotated, as happens when two tabs refresh at once; later reuse
+	// revokes the session.
+	RefreshReuseGrace time.Duration
+	// RevocationSync is how often revoked sessions are reloaded from the
+	// database.
+	RevocationSync time.Duration
+	// MFAIssuer names this service in authenticator apps.
+	MFAIssuer string
+	// MFAChallengeTTL is how long a user has to enter the second factor
+	// after their password.
+	MFAChallengeTTL time.Duration
+	// MFAMaxAttempts wrong codes in a row lock the user's second factor for
+	// MFALockout.
+	MFAMaxAttempts int
+	MFALockout     time.Duration
+	// StepUpSSH requires a second factor proven within StepUpTTL before
+	// opening SSH sessions.
+	StepUpSSH bool
+	StepUpTTL time.Duration
+	// ThrottleStore is where login attempts are counted: memory, per
+	// replica, or postgres to share the counts and lockouts between them.
+	ThrottleStore string
+	// LoginIPLimit logins per LoginIPWindow and SignUpIPLimit sign-ups per
+	// SignUpIPWindow are allowed from one address.
+	LoginIPLimit   int
+	LoginIPWindow  time.Duration
+	SignUpIPLimit  int
+	SignUpIPWindow time.Duration
+	// Failed logins are counted over FailureWindow. After
+	// FailureDelayAfter of them for an account, each attempt waits twice as
+	// long as the last, from FailureDelay up to FailureMaxDelay.
+	FailureWindow     time.Duration
+	FailureDelayAfter int
+	FailureDelay      time.Duration
+	FailureMaxDelay   time.Duration
+	// LockoutThreshold failures lock an account out, and IPLockoutThreshold
+	// an address, for LockoutDuration.
+	LockoutThreshold   int
+	IPLockoutThreshold int
+	LockoutDuration    time.Duration
+	// TrustProxy takes the client address from the last X-Forwarded-For
+	// entry, as added by a reverse proxy in front of the server.
+	TrustProxy bool
+	// SignUpPolicy decides who may sign up and whether they are approved
+	// right away; SignUpDomains are the email domains of SignUpDomain.
+	SignUpPolicy  string
+	SignUpDomains []string
+	// New passwords need PasswordMinLength characters from at least
+	// PasswordMinClasses of lowercase, uppercase, digits and symbols.
+	PasswordMinLength  int
+	PasswordMinClasses int
+	// InviteTTL and PasswordResetTTL are how long emailed links are valid.
+	InviteTTL        time.Duration
+	PasswordResetTTL time.Duration
+	// AppURL is the frontend's address, which emailed links point to.
+	AppURL string
+}
+
+// Sign-up policies.
+const (
+	// SignUpOpen approves everyone who signs up.
+	SignUpOpen = "open"
+	// SignUpDomain approves users with an email address in one of
+	// SignUpDomains and refuses others.
+	SignUpDomain = "domain"
+	// SignUpApproval leaves new users pending until an admin approves them.
+	SignUpApproval = "approval"
+	// SignUpInvite refuses sign-ups without an invitation.
+	SignUpInvite = "invite"
+)
+
+func DefaultConfig() Config {
+	return Config{
+		AccessTTL:          15 * time.Minute,
+		RefreshTTL:         30 * 24 * time.Hour,
+		RefreshReuseGrace:  30 * time.Second,
+		RevocationSync:     15 * time.Second,
+		MFAIssuer:          "CMDB",
+		MFAChallengeTTL:    5 * time.Minute,
+		MFAMaxAttempts:     5,
+		MFALockout:         15 * time.Minute,
+		StepUpSSH:          false,
+		StepUpTTL:          10 * time.Minute,
+		ThrottleStore:      ThrottleStoreMemory,
+		LoginIPLimit:       30,
+		LoginIPWindow:      time.Minute,
+		SignUpIPLimit:      5,
+		SignUpIPWindow:     time.Hour,
+		FailureWindow:      15 * time.Minute,
+		FailureDelayAfter:  3,
+		FailureDelay:       time.Second,
+		FailureMaxDelay:    30 * time.Second,
+		LockoutThreshold:   10,
+		IPLockoutThreshold: 50,
+		LockoutDuration:    15 * time.Minute,
+		TrustProxy:         false,
+		SignUpPolicy:       SignUpApproval,
+		PasswordMinLength:  8,
+		PasswordMinClasses: 1,
+		InviteTTL:          7 * 24 * time.Hour,
+		PasswordResetTTL:   time.Hour,
+	}
+}
+
+// ConfigFromEnv reads AUTH_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Duration("AUTH_ACCESS_TTL", &cfg.AccessTTL)
+	envconfig.Duration("AUTH_REFRESH_TTL", &cfg.RefreshTTL)
+	envconfig.Duration("AUTH_REFRESH_REUSE_GRACE", &cfg.RefreshReuseGrace)
+	envconfig.Duration("AUTH_REVOCATION_SYNC", &cfg.RevocationSync)
+	envconfig.String("AUTH_MFA_ISSUER", &cfg.MFAIssuer)
+	envconfig.Duration("AUTH_MFA_CHALLENGE_TTL", &cfg.MFAChallengeTTL)
+	envconfig.Int("AUTH_MFA_MAX_ATTEMPTS", &cfg.MFAMaxAttempts, 1)
+	envconfig.Duration("AUTH_MFA_LOCKOUT", &cfg.MFALockout)
+	envconfig.Bool("AUTH_STEP_UP_SSH", &cfg.StepUpSSH)
+	envconfig.Duration("AUTH_STEP_UP_TTL", &cfg.StepUpTTL)
+	switch v := os.Getenv("AUTH_THROTTLE_STORE"); v {
+	case "":
+	case ThrottleStoreMemory, ThrottleStorePostgres:
+		cfg.ThrottleStore = v
+	default:
+		log.Printf("Invalid AUTH_THROTTLE_STORE %q, keeping default", v)
+	}
+	envconfig.Int("AUTH_LOGIN_IP_LIMIT", &cfg.LoginIPLimit, 1)
+	envconfig.Duration("AUTH_LOGIN_IP_WINDOW", &cfg.LoginIPWindow)
+	envconfig.Int("AUTH_SIGNUP_IP_LIMIT", &cfg.SignUpIPLimit, 1)
+	envconfig.Duration("AUTH_SIGNUP_IP_WINDOW", &cfg.SignUpIPWindow)
+	envconfig.Duration("AUTH_FAILURE_WINDOW", &cfg.FailureWindow)
+	envconfig.Int("AUTH_FAILURE_DELAY_AFTER", &cfg.FailureDelayAfter, 1)
+	envconfig.Duration("AUTH_FAILURE_DELAY", &cfg.FailureDelay)
+	envconfig.Duration("AUTH_FAILURE_MAX_DELAY", &cfg.FailureMaxDelay)
+	envconfig.Int("AUTH_LOCKOUT_THRESHOLD", &cfg.LockoutThreshold, 1)
+	envconfig.Int("AUTH_IP_LOCKOUT_THRESHOLD", &cfg.IPLockoutThreshold, 1)
+	envconfig.Duration("AUTH_LOCKOUT_DURATION", &cfg.LockoutDuration)
+	envconfig.Bool("AUTH_TRUST_PROXY", &cfg.TrustProxy)
+	switch v := os.Getenv("AUTH_SIGNUP_POLICY"); v {
+	case "":
+	case SignUpOpen, SignUpDomain, SignUpApproval, SignUpInvite:
+		cfg.SignUpPolicy = v
+	default:
+		log.Printf("Invalid AUTH_SIGNUP_POLICY %q, keeping default", v)
+	}
+	for _, d := range strings.Split(os.Getenv("AUTH_SIGNUP_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(d), "@"))); d != "" {
+			cfg.SignUpDomains = append(cfg.SignUpDomains, d)
+		}
+	}
+	if cfg.SignUpPolicy == SignUpDomain && len(cfg.SignUpDomains) == 0 {
+		log.Println("AUTH_SIGNUP_POLICY is domain but AUTH_SIGNUP_DOMAINS is empty, nobody can sign up")
+	}
+	envconfig.Int("AUTH_PASSWORD_MIN_LENGTH", &cfg.PasswordMinLength, 1)
+	envconfig.Int("AUTH_PASSWORD_MIN_CLASSES", &cfg.PasswordMinClasses, 1)
+	if cfg.PasswordMinClasses > 4 {
+		log.Printf("Invalid AUTH_PASSWORD_MIN_CLASSES %d, using 4", cfg.PasswordMinClasses)
+		cfg.PasswordMinClasses = 4
+	}
+	envconfig.Duration("AUTH_INVITE_TTL", &cfg.InviteTTL)
+	envconfig.Duration("AUTH_PASSWORD_RESET_TTL", &cfg.PasswordResetTTL)
+	cfg.AppURL = strings.TrimSuffix(os.Getenv("AUTH_APP_URL"), "/")
+	return cfg
+}
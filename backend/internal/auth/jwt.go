@@ -9,27 +9,39 @@ import (
     "github.com/golang-jwt/jwt/v5"
 )
 
+// JWTManager issues and verifies short-lived access tokens. Every token
+// belongs to a login session and is rejected once the session is revoked.
 type JWTManager struct {
 	secretKey string
+	accessTTL time.Duration
+	revoked   *RevocationList
 }
 
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-func NewJWTManager(secretKey string) *JWTManager {
-	return &JWTManager{secretKey: secretKey}
+func NewJWTManager(secretKey string, accessTTL time.Duration, revoked *RevocationList) *JWTManager {
+	return &JWTManager{secretKey: secretKey, accessTTL: accessTTL, revoked: revoked}
 }
 
-func (m *JWTManager) Generate(userID, email string) (string, error) {
+// AccessTTL is how long tokens from Generate are valid.
+func (m *JWTManager) AccessTTL() time.Duration {
+	return m.accessTTL
+}
+
+func (m *JWTManager) Generate(userID, email, sessionID string) (string, error) {
+	now := time.Now()
 	claims := Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.accessTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
@@ -53,10 +65,87 @@ func (m *JWTManager) Verify(tokenString string) (*Claims, error) {
 	if !ok || !token.Valid {
 		return nil, fmt.Errorf("invalid token")
 	}
+	// Tokens from before sessions existed cannot be revoked.
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("token has no session")
+	}
+	if m.revoked != nil && m.revoked.IsRevoked(claims.SessionID) {
+		return nil, fmt.Errorf("session revoked")
+	}
 
 	return claims, nil
 }
 
+// challengeAudience marks MFA challenge tokens, which prove only the first
+// login step and are never accepted as access tokens.
+const challengeAudience = "mfa-challenge"
+
+// GenerateChallenge issues a token for a user who gave a valid password and
+// must still prove a second factor within ttl.
+func (m *JWTManager) GenerateChallenge(userID string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Subject:   userID,
+		Audience:  jwt.ClaimStrings{challengeAudience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(m.secretKey))
+}
+
+// VerifyChallenge returns the user ID of a valid challenge token.
+func (m *JWTManager) VerifyChallenge(tokenString string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(m.secretKey), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience(challengeAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("challenge has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// Audiences of one-time tokens.
+const (
+	InviteAudience        = "invite"
+	PasswordResetAudience = "password-reset"
+)
+
+// GenerateOneTimeToken signs a token for the invite or password reset id,
+// valid until expiresAt. The signature keeps forged ids from reaching the
+// database; the caller's store makes sure the id is used once.
+func (m *JWTManager) GenerateOneTimeToken(audience, id string, expiresAt time.Time) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:   id,
+		Audience:  jwt.ClaimStrings{audience},
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(m.secretKey))
+}
+
+// VerifyOneTimeToken returns the id of a valid token for audience.
+func (m *JWTManager) VerifyOneTimeToken(tokenString, audience string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(m.secretKey), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience(audience), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("token has no subject")
+	}
+	return claims.Subject, nil
+}
+
 // GenerateSecureAPIKey creates a URL-safe, high-entropy API key
 func GenerateSecureAPIKey() string {
     b := make([]byte, 48)
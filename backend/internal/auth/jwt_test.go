@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyOneTimeToken(t *testing.T) {
+	m := NewJWTManager("secret", time.Minute, nil)
+	other := NewJWTManager("other-secret", time.Minute, nil)
+	token := func(m *JWTManager, audience, id string, expiresIn time.Duration) string {
+		raw, err := m.GenerateOneTimeToken(audience, id, time.Now().Add(expiresIn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	invite := token(m, InviteAudience, "invite-1", time.Hour)
+
+	tests := []struct {
+		name     string
+		token    string
+		audience string
+		wantID   string
+		wantErr  bool
+	}{
+		{"invite", invite, InviteAudience, "invite-1", false},
+		{"password reset", token(m, PasswordResetAudience, "reset-1", time.Hour), PasswordResetAudience, "reset-1", false},
+		{"invite used as a password reset", invite, PasswordResetAudience, "", true},
+		{"password reset used as an invite", token(m, PasswordResetAudience, "reset-1", time.Hour), InviteAudience, "", true},
+		{"expired", token(m, InviteAudience, "invite-1", -time.Minute), InviteAudience, "", true},
+		{"signed with another secret", token(other, InviteAudience, "invite-1", time.Hour), InviteAudience, "", true},
+		{"tampered", invite[:len(invite)-2] + "xx", InviteAudience, "", true},
+		{"no subject", token(m, InviteAudience, "", time.Hour), InviteAudience, "", true},
+		{"MFA challenge", mustChallenge(t, m), InviteAudience, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := m.VerifyOneTimeToken(tt.token, tt.audience)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyOneTimeToken() error = %v, want error %v", err, tt.wantErr)
+			}
+			if id != tt.wantID {
+				t.Errorf("VerifyOneTimeToken() = %q, want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestOneTimeTokenIsNotAnAccessToken(t *testing.T) {
+	m := NewJWTManager("secret", time.Minute, nil)
+	for _, audience := range []string{InviteAudience, PasswordResetAudience} {
+		raw, err := m.GenerateOneTimeToken(audience, "id-1", time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Verify(raw); err == nil {
+			t.Errorf("Verify() accepted a %s token", audience)
+		}
+	}
+}
+
+func mustChallenge(t *testing.T, m *JWTManager) string {
+	t.Helper()
+	raw, err := m.GenerateChallenge("user-1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
@@ -8,7 +8,10 @@ import (
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey    contextKey = "userID"
+	SessionIDKey contextKey = "sessionID"
+)
 
 func AuthMiddleware(jwtManager *JWTManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -31,7 +34,9 @@ func AuthMiddleware(jwtManager *JWTManager) func(http.Handler) http.Handler {
 				return
 			}
 
+			// Verify rejects tokens of revoked sessions.
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -41,3 +46,9 @@ func GetUserID(ctx context.Context) string {
 	userID, _ := ctx.Value(UserIDKey).(string)
 	return userID
 }
+
+// GetSessionID returns the login session of the request's access token.
+func GetSessionID(ctx context.Context) string {
+	sessionID, _ := ctx.Value(SessionIDKey).(string)
+	return sessionID
+}
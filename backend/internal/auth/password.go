@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPasswordBytes is what bcrypt hashes; longer passwords are refused
+// rather than silently truncated.
+const maxPasswordBytes = 72
+
+// commonPasswords are refused whatever the policy, compared ignoring case.
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "passw0rd": true,
+	"12345678": true, "123456789": true, "1234567890": true, "87654321": true,
+	"qwerty123": true, "qwertyuiop": true, "1q2w3e4r": true, "1qaz2wsx": true,
+	"letmein1": true, "iloveyou": true, "sunshine": true, "princess": true,
+	"football": true, "baseball": true, "welcome1": true, "welcome123": true,
+	"admin123": true, "administrator": true, "changeme": true, "changeme123": true,
+	"trustno1": true, "superman": true, "starwars": true, "abc12345": true,
+	"11111111": true, "00000000": true, "cmdb1234": true,
+}
+
+// CheckPassword returns an error describing why password does not meet
+// the policy, or nil. identities, the user's email and username, must not
+// appear in it.
+func (c Config) CheckPassword(password string, identities ...string) error {
+	if utf8.RuneCountInString(password) < c.PasswordMinLength {
+		return fmt.Errorf("Password must be at least %d characters", c.PasswordMinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("Password must be at most %d bytes", maxPasswordBytes)
+	}
+
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			classes++
+		}
+	}
+	if classes < c.PasswordMinClasses {
+		return fmt.Errorf("Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", c.PasswordMinClasses)
+	}
+
+	folded := strings.ToLower(password)
+	if commonPasswords[folded] {
+		return fmt.Errorf("Password is too common")
+	}
+	for _, id := range identities {
+		id = strings.ToLower(id)
+		if i := strings.Index(id, "@"); i >= 0 {
+			id = id[:i]
+		}
+		if len(id) >= 3 && strings.Contains(folded, id) {
+			return fmt.Errorf("Password must not contain your username or email address")
+		}
+	}
+	return nil
+}
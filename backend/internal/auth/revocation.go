@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// RevocationStore lists sessions revoked after a point in time and deletes
+// sessions that have ended.
+type RevocationStore interface {
+	RevokedSince(t time.Time) ([]string, error)
+	DeleteEndedBefore(t time.Time) (int64, error)
+}
+
+// endedSessionRetention is how long expired and revoked sessions are kept.
+const endedSessionRetention = 30 * 24 * time.Hour
+
+// RevocationList holds the sessions revoked recently enough that access
+// tokens issued for them may not have expired yet. It is reloaded from the
+// store periodically so revocations made by other replicas take effect.
+type RevocationList struct {
+	store     RevocationStore
+	accessTTL time.Duration
+
+	mu       sync.RWMutex
+	sessions map[string]time.Time
+}
+
+func NewRevocationList(store RevocationStore, accessTTL time.Duration) *RevocationList {
+	return &RevocationList{store: store, accessTTL: accessTTL, sessions: make(map[string]time.Time)}
+}
+
+// Add marks sessions as revoked on this replica right away.
+func (l *RevocationList) Add(sessionIDs ...string) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range sessionIDs {
+		l.sessions[id] = now
+	}
+}
+
+// IsRevoked reports whether access tokens of the session must be rejected.
+func (l *RevocationList) IsRevoked(sessionID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.sessions[sessionID]
+	return ok
+}
+
+// Sync reloads the list from the store. Entries added locally in the meantime
+// are kept until their tokens have expired.
+func (l *RevocationList) Sync() error {
+	return l.sync(time.Now())
+}
+
+func (l *RevocationList) sync(now time.Time) error {
+	// Allow for clock skew between replicas.
+	since := now.Add(-l.accessTTL - time.Minute)
+	ids, err := l.store.RevokedSince(since)
+	if err != nil {
+		return err
+	}
+
+	sessions := make(map[string]time.Time, len(ids))
+	for _, id := range ids {
+		sessions[id] = now
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, at := range l.sessions {
+		if _, ok := sessions[id]; !ok && at.After(since) {
+			sessions[id] = at
+		}
+	}
+	l.sessions = sessions
+	return nil
+}
+
+// Run syncs the list every interval and deletes old sessions hourly until
+// ctx is cancelled.
+func (l *RevocationList) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var pruned time.Time
+	for {
+		if err := l.Sync(); err != nil {
+			log.Printf("Failed to load revoked sessions: %v", err)
+		}
+		if time.Since(pruned) >= time.Hour {
+			pruned = time.Now()
+			if _, err := l.store.DeleteEndedBefore(pruned.Add(-endedSessionRetention)); err != nil {
+				log.Printf("Failed to delete ended sessions: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
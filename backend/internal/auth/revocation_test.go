@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeRevocationStore returns the sessions revoked after the time it is
+// asked about.
+type fakeRevocationStore struct {
+	revoked map[string]time.Time
+	err     error
+	since   time.Time
+}
+
+func (s *fakeRevocationStore) RevokedSince(t time.Time) ([]string, error) {
+	s.since = t
+	if s.err != nil {
+		return nil, s.err
+	}
+	ids := []string{}
+	for id, at := range s.revoked {
+		if at.After(t) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *fakeRevocationStore) DeleteEndedBefore(time.Time) (int64, error) { return 0, nil }
+
+func TestRevocationListSync(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	const ttl = 15 * time.Minute
+	// Entries are kept for the access token TTL plus a minute of clock skew.
+	cutoff := now.Add(-ttl - time.Minute)
+
+	tests := []struct {
+		name   string
+		local  map[string]time.Time
+		stored map[string]time.Time
+		want   []string
+	}{
+		{"empty", nil, nil, nil},
+		{"store entries loaded", nil, map[string]time.Time{"s1": now.Add(-time.Minute), "s2": now.Add(-10 * time.Minute)}, []string{"s1", "s2"}},
+		{"old store entries dropped", nil, map[string]time.Time{"s1": cutoff.Add(-time.Second)}, nil},
+		{"local entry kept until its tokens expire", map[string]time.Time{"s1": cutoff.Add(time.Second)}, nil, []string{"s1"}},
+		{"local entry dropped once its tokens expired", map[string]time.Time{"s1": cutoff}, nil, nil},
+		{"local and store entries merged", map[string]time.Time{"s1": now}, map[string]time.Time{"s2": now}, []string{"s1", "s2"}},
+		{"entry in both", map[string]time.Time{"s1": cutoff.Add(-time.Hour)}, map[string]time.Time{"s1": now}, []string{"s1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeRevocationStore{revoked: tt.stored}
+			l := NewRevocationList(store, ttl)
+			for id, at := range tt.local {
+				l.sessions[id] = at
+			}
+
+			if err := l.sync(now); err != nil {
+				t.Fatal(err)
+			}
+			if !store.since.Equal(cutoff) {
+				t.Errorf("RevokedSince(%s), want %s", store.since, cutoff)
+			}
+			var got []string
+			for id := range l.sessions {
+				got = append(got, id)
+				if !l.IsRevoked(id) {
+					t.Errorf("IsRevoked(%s) = false", id)
+				}
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("revoked sessions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRevocationListSyncError(t *testing.T) {
+	store := &fakeRevocationStore{err: errors.New("connection refused")}
+	l := NewRevocationList(store, time.Minute)
+	l.Add("s1")
+
+	if err := l.Sync(); err == nil {
+		t.Fatal("Sync() succeeded with a failing store")
+	}
+	if !l.IsRevoked("s1") {
+		t.Error("a failed sync dropped local revocations")
+	}
+}
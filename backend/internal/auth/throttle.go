@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+// Throttle scopes: attempts are counted per client address and per login
+// name.
+const (
+	ScopeIP      = "ip"
+	ScopeAccount = "account"
+)
+
+// Kinds of attempts counted.
+const (
+	attemptLogin   = "attempt"
+	attemptFailure = "failure"
+	attemptSignUp  = "signup"
+	attemptReset   = "reset"
+)
+
+// Throttle stores: MemoryThrottleStore counts on one replica, the
+// database's LoginAttemptStore shares counts between them.
+const (
+	ThrottleStoreMemory   = "memory"
+	ThrottleStorePostgres = "postgres"
+)
+
+// ThrottleStore counts attempts and holds lockouts.
+type ThrottleStore interface {
+	AddAttempt(scope, subject, kind string, at time.Time) error
+	CountAttempts(scope, subject, kind string, since time.Time) (database.AttemptCount, error)
+	ClearAttempts(scope, subject, kind string) error
+	Lock(l *database.Lockout) error
+	LockedUntil(scope, subject string, now time.Time) (*time.Time, error)
+	Unlock(scope, subject string, now time.Time) (bool, error)
+	ListLockouts(now time.Time) ([]*database.Lockout, error)
+	Prune(before time.Time) error
+}
+
+// ThrottledError refuses an attempt until RetryAfter has passed. Locked
+// is set when Scope is locked out rather than just rate limited.
+type ThrottledError struct {
+	Scope      string
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("%s locked out for %s", e.Scope, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s rate limited for %s", e.Scope, e.RetryAfter)
+}
+
+// Throttle limits the rate of logins and sign-ups per client address over
+// sliding windows, slows down repeated failed logins and locks out
+// accounts and addresses with too many of them.
+type Throttle struct {
+	store ThrottleStore
+	cfg   Config
+}
+
+func NewThrottle(store ThrottleStore, cfg Config) *Throttle {
+	return &Throttle{store: store, cfg: cfg}
+}
+
+// AccountSubject normalizes a login name so that variants of it share
+// their counts.
+func AccountSubject(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
+
+// CheckLogin returns a *ThrottledError if a login for account from ip
+// must be refused now, and otherwise counts it towards ip's rate limit.
+func (t *Throttle) CheckLogin(ip, account string) error {
+	return t.checkLogin(ip, account, time.Now())
+}
+
+func (t *Throttle) checkLogin(ip, account string, now time.Time) error {
+	subjects := [][2]string{{ScopeIP, ip}, {ScopeAccount, AccountSubject(account)}}
+
+	for _, s := range subjects {
+		until, err := t.store.LockedUntil(s[0], s[1], now)
+		if err != nil {
+			return err
+		}
+		if until != nil {
+			return &ThrottledError{Scope: s[0], Locked: true, RetryAfter: until.Sub(now)}
+		}
+	}
+	if err := t.checkRate(ScopeIP, ip, attemptLogin, t.cfg.LoginIPLimit, t.cfg.LoginIPWindow, now); err != nil {
+		return err
+	}
+	// Each failure for the account past FailureDelayAfter doubles the wait
+	// for the next attempt. Addresses are not slowed down, as many users
+	// may share one behind NAT.
+	failures, err := t.store.CountAttempts(ScopeAccount, subjects[1][1], attemptFailure, now.Add(-t.cfg.FailureWindow))
+	if err != nil {
+		return err
+	}
+	if failures.Count >= t.cfg.FailureDelayAfter {
+		if wait := failures.Last.Add(t.failureDelay(failures.Count)).Sub(now); wait > 0 {
+			return &ThrottledError{Scope: ScopeAccount, RetryAfter: wait}
+		}
+	}
+	return t.store.AddAttempt(ScopeIP, ip, attemptLogin, now)
+}
+
+func (t *Throttle) failureDelay(failures int) time.Duration {
+	delay := t.cfg.FailureDelay
+	for i := t.cfg.FailureDelayAfter; i < failures && delay < t.cfg.FailureMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.cfg.FailureMaxDelay {
+		delay = t.cfg.FailureMaxDelay
+	}
+	return delay
+}
+
+// CheckSignUp returns a *ThrottledError if ip has signed up too often, and
+// otherwise counts the sign-up.
+func (t *Throttle) CheckSignUp(ip string) error {
+	now := time.Now()
+	if err := t.checkRate(ScopeIP, ip, attemptSignUp, t.cfg.SignUpIPLimit, t.cfg.SignUpIPWindow, now); err != nil {
+		return err
+	}
+	return t.store.AddAttempt(ScopeIP, ip, attemptSignUp, now)
+}
+
+// CheckPasswordReset returns a *ThrottledError if ip, or anyone for the
+// account, has asked for password resets too often, and otherwise counts
+// the request. Resets share the sign-up limit.
+func (t *Throttle) CheckPasswordReset(ip, account string) error {
+	now := time.Now()
+	account = AccountSubject(account)
+	if err := t.checkRate(ScopeIP, ip, attemptReset, t.cfg.SignUpIPLimit, t.cfg.SignUpIPWindow, now); err != nil {
+		return err
+	}
+	if err := t.checkRate(ScopeAccount, account, attemptReset, t.cfg.SignUpIPLimit, t.cfg.SignUpIPWindow, now); err != nil {
+		return err
+	}
+	if err := t.store.AddAttempt(ScopeIP, ip, attemptReset, now); err != nil {
+		return err
+	}
+	return t.store.AddAttempt(ScopeAccount, account, attemptReset, now)
+}
+
+func (t *Throttle) checkRate(scope, subject, kind string, limit int, window time.Duration, now time.Time) error {
+	attempts, err := t.store.CountAttempts(scope, subject, kind, now.Add(-window))
+	if err != nil {
+		return err
+	}
+	if attempts.Count < limit {
+		return nil
+	}
+	// The window slides past its oldest attempt
+	return &ThrottledError{Scope: scope, RetryAfter: attempts.First.Add(window).Sub(now)}
+}
+
+// LoginFailed counts a failed login for account from ip and returns the
+// lockouts it caused.
+func (t *Throttle) LoginFailed(ip, account string) ([]*database.Lockout, error) {
+	return t.loginFailed(ip, account, time.Now())
+}
+
+func (t *Throttle) loginFailed(ip, account string, now time.Time) ([]*database.Lockout, error) {
+	var lockouts []*database.Lockout
+	for _, s := range []struct {
+		scope, subject string
+		threshold      int
+	}{
+		{ScopeIP, ip, t.cfg.IPLockoutThreshold},
+		{ScopeAccount, AccountSubject(account), t.cfg.LockoutThreshold},
+	} {
+		if err := t.store.AddAttempt(s.scope, s.subject, attemptFailure, now); err != nil {
+			return lockouts, err
+		}
+		failures, err := t.store.CountAttempts(s.scope, s.subject, attemptFailure, now.Add(-t.cfg.FailureWindow))
+		if err != nil {
+			return lockouts, err
+		}
+		if failures.Count < s.threshold {
+			continue
+		}
+
+		// The count starts over once the lockout ends
+		l := &database.Lockout{
+			Scope:       s.scope,
+			Subject:     s.subject,
+			Failures:    failures.Count,
+			LockedAt:    now,
+			LockedUntil: now.Add(t.cfg.LockoutDuration),
+		}
+		if err := t.store.Lock(l); err != nil {
+			return lockouts, err
+		}
+		if err := t.store.ClearAttempts(s.scope, s.subject, attemptFailure); err != nil {
+			return lockouts, err
+		}
+		lockouts = append(lockouts, l)
+	}
+	return lockouts, nil
+}
+
+// LoginSucceeded forgets the account's failed logins. Those of the address
+// are kept, as one valid account must not let it guess others.
+func (t *Throttle) LoginSucceeded(account string) error {
+	return t.store.ClearAttempts(ScopeAccount, AccountSubject(account), attemptFailure)
+}
+
+// Lockouts returns the lockouts in force.
+func (t *Throttle) Lockouts() ([]*database.Lockout, error) {
+	return t.store.ListLockouts(time.Now())
+}
+
+// Unlock lifts a lockout and forgets the subject's failed logins,
+// reporting whether it was locked out.
+func (t *Throttle) Unlock(scope, subject string) (bool, error) {
+	if scope == ScopeAccount {
+		subject = AccountSubject(subject)
+	}
+	return t.store.Unlock(scope, subject, time.Now())
+}
+
+// Run deletes attempts that fell out of every window, and ended lockouts,
+// every minute until ctx is cancelled.
+func (t *Throttle) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := t.store.Prune(time.Now().Add(-t.retention())); err != nil {
+			log.Printf("Failed to prune login attempts: %v", err)
+		}
+	}
+}
+
+func (t *Throttle) retention() time.Duration {
+	r := t.cfg.FailureWindow
+	for _, d := range []time.Duration{t.cfg.LoginIPWindow, t.cfg.SignUpIPWindow} {
+		if d > r {
+			r = d
+		}
+	}
+	return r
+}
+
+// MemoryThrottleStore is a ThrottleStore for a single replica.
+type MemoryThrottleStore struct {
+	mu       sync.Mutex
+	attempts map[[3]string][]time.Time
+	lockouts map[[2]string]*database.Lockout
+}
+
+func NewMemoryThrottleStore() *MemoryThrottleStore {
+	return &MemoryThrottleStore{
+		attempts: make(map[[3]string][]time.Time),
+		lockouts: make(map[[2]string]*database.Lockout),
+	}
+}
+
+func (s *MemoryThrottleStore) AddAttempt(scope, subject, kind string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [3]string{scope, subject, kind}
+	s.attempts[key] = append(s.attempts[key], at)
+	return nil
+}
+
+func (s *MemoryThrottleStore) CountAttempts(scope, subject, kind string, since time.Time) (database.AttemptCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var c database.AttemptCount
+	for _, at := range s.attempts[[3]string{scope, subject, kind}] {
+		if at.Before(since) {
+			continue
+		}
+		if c.Count == 0 || at.Before(c.First) {
+			c.First = at
+		}
+		if at.After(c.Last) {
+			c.Last = at
+		}
+		c.Count++
+	}
+	return c, nil
+}
+
+func (s *MemoryThrottleStore) ClearAttempts(scope, subject, kind string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, [3]string{scope, subject, kind})
+	return nil
+}
+
+func (s *MemoryThrottleStore) Lock(l *database.Lockout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *l
+	s.lockouts[[2]string{l.Scope, l.Subject}] = &copied
+	return nil
+}
+
+func (s *MemoryThrottleStore) LockedUntil(scope, subject string, now time.Time) (*time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lockouts[[2]string{scope, subject}]
+	if !ok || !l.LockedUntil.After(now) {
+		return nil, nil
+	}
+	until := l.LockedUntil
+	return &until, nil
+}
+
+func (s *MemoryThrottleStore) Unlock(scope, subject string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [2]string{scope, subject}
+	l, ok := s.lockouts[key]
+	delete(s.lockouts, key)
+	delete(s.attempts, [3]string{scope, subject, attemptFailure})
+	return ok && l.LockedUntil.After(now), nil
+}
+
+func (s *MemoryThrottleStore) ListLockouts(now time.Time) ([]*database.Lockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockouts := []*database.Lockout{}
+	for _, l := range s.lockouts {
+		if l.LockedUntil.After(now) {
+			copied := *l
+			lockouts = append(lockouts, &copied)
+		}
+	}
+	sort.Slice(lockouts, func(i, j int) bool { return lockouts[i].LockedUntil.Before(lockouts[j].LockedUntil) })
+	return lockouts, nil
+}
+
+func (s *MemoryThrottleStore) Prune(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, times := range s.attempts {
+		kept := times[:0]
+		for _, at := range times {
+			if !at.Before(before) {
+				kept = append(kept, at)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.attempts, key)
+		} else {
+			s.attempts[key] = kept
+		}
+	}
+	for key, l := range s.lockouts {
+		if l.LockedUntil.Before(before) {
+			delete(s.lockouts, key)
+		}
+	}
+	return nil
+}
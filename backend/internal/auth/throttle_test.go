@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+var throttleNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testThrottleConfig() Config {
+	cfg := DefaultConfig()
+	cfg.LoginIPLimit = 3
+	cfg.LoginIPWindow = time.Minute
+	cfg.FailureWindow = 15 * time.Minute
+	cfg.FailureDelayAfter = 2
+	cfg.FailureDelay = time.Second
+	cfg.FailureMaxDelay = 8 * time.Second
+	cfg.LockoutThreshold = 5
+	cfg.IPLockoutThreshold = 8
+	cfg.LockoutDuration = 15 * time.Minute
+	return cfg
+}
+
+// attempts records n attempts of kind for a subject, the last one at
+// last and each earlier one a second before the next.
+func attempts(s *MemoryThrottleStore, scope, subject, kind string, n int, last time.Duration) {
+	for i := n - 1; i >= 0; i-- {
+		s.AddAttempt(scope, subject, kind, throttleNow.Add(last-time.Duration(i)*time.Second))
+	}
+}
+
+func TestCheckLogin(t *testing.T) {
+	const ip = "192.0.2.1"
+	tests := []struct {
+		name  string
+		setup func(s *MemoryThrottleStore)
+		login string
+		want  *ThrottledError
+	}{
+		{"first attempt", nil, "alice", nil},
+		{
+			"address limit reached",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeIP, ip, attemptLogin, 3, -30*time.Second) },
+			"alice", &ThrottledError{Scope: ScopeIP, RetryAfter: 28 * time.Second},
+		},
+		{
+			"address window slid past old attempts",
+			func(s *MemoryThrottleStore) {
+				s.AddAttempt(ScopeIP, ip, attemptLogin, throttleNow.Add(-61*time.Second))
+				attempts(s, ScopeIP, ip, attemptLogin, 2, -time.Second)
+			},
+			"alice", nil,
+		},
+		{
+			"other address at its limit",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeIP, "192.0.2.2", attemptLogin, 3, -time.Second) },
+			"alice", nil,
+		},
+		{
+			"failures below the delay threshold",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeAccount, "alice", attemptFailure, 1, 0) },
+			"alice", nil,
+		},
+		{
+			"failure delay",
+			func(s *MemoryThrottleStore) {
+				attempts(s, ScopeAccount, "alice", attemptFailure, 2, -200*time.Millisecond)
+			},
+			"alice", &ThrottledError{Scope: ScopeAccount, RetryAfter: 800 * time.Millisecond},
+		},
+		{
+			"failure delay over",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeAccount, "alice", attemptFailure, 2, -time.Second) },
+			"alice", nil,
+		},
+		{
+			"failure delay doubles",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeAccount, "alice", attemptFailure, 4, -time.Second) },
+			"alice", &ThrottledError{Scope: ScopeAccount, RetryAfter: 3 * time.Second},
+		},
+		{
+			"failure delay capped",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeAccount, "alice", attemptFailure, 9, -time.Second) },
+			"alice", &ThrottledError{Scope: ScopeAccount, RetryAfter: 7 * time.Second},
+		},
+		{
+			"failures out of the window",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeAccount, "alice", attemptFailure, 4, -16*time.Minute) },
+			"alice", nil,
+		},
+		{
+			"failures of another account",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeAccount, "bob", attemptFailure, 4, 0) },
+			"alice", nil,
+		},
+		{
+			"account name normalized",
+			func(s *MemoryThrottleStore) { attempts(s, ScopeAccount, "alice", attemptFailure, 2, 0) },
+			"  Alice ", &ThrottledError{Scope: ScopeAccount, RetryAfter: time.Second},
+		},
+		{
+			"account locked out",
+			func(s *MemoryThrottleStore) {
+				s.Lock(&database.Lockout{Scope: ScopeAccount, Subject: "alice", LockedUntil: throttleNow.Add(5 * time.Minute)})
+			},
+			"alice", &ThrottledError{Scope: ScopeAccount, Locked: true, RetryAfter: 5 * time.Minute},
+		},
+		{
+			"address locked out",
+			func(s *MemoryThrottleStore) {
+				s.Lock(&database.Lockout{Scope: ScopeIP, Subject: ip, LockedUntil: throttleNow.Add(time.Minute)})
+			},
+			"alice", &ThrottledError{Scope: ScopeIP, Locked: true, RetryAfter: time.Minute},
+		},
+		{
+			"lockout over",
+			func(s *MemoryThrottleStore) {
+				s.Lock(&database.Lockout{Scope: ScopeAccount, Subject: "alice", LockedUntil: throttleNow})
+			},
+			"alice", nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryThrottleStore()
+			if tt.setup != nil {
+				tt.setup(store)
+			}
+			before, _ := store.CountAttempts(ScopeIP, ip, attemptLogin, time.Time{})
+
+			err := NewThrottle(store, testThrottleConfig()).checkLogin(ip, tt.login, throttleNow)
+
+			var got *ThrottledError
+			if err != nil && !errors.As(err, &got) {
+				t.Fatalf("checkLogin() error = %v, want a *ThrottledError", err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("checkLogin() = %+v, want %+v", got, tt.want)
+			}
+			// Only allowed attempts count towards the address's limit
+			after, _ := store.CountAttempts(ScopeIP, ip, attemptLogin, time.Time{})
+			wantCount := before.Count
+			if tt.want == nil {
+				wantCount++
+			}
+			if after.Count != wantCount {
+				t.Errorf("%d login attempts counted, want %d", after.Count, wantCount)
+			}
+		})
+	}
+}
+
+func TestFailureDelay(t *testing.T) {
+	th := NewThrottle(NewMemoryThrottleStore(), testThrottleConfig())
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 4 * time.Second},
+		{5, 8 * time.Second},
+		{6, 8 * time.Second},
+		{100, 8 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := th.failureDelay(tt.failures); got != tt.want {
+			t.Errorf("failureDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginFailed(t *testing.T) {
+	const ip = "192.0.2.1"
+	store := NewMemoryThrottleStore()
+	th := NewThrottle(store, testThrottleConfig())
+	fail := func(ip, account string, at time.Duration) []*database.Lockout {
+		t.Helper()
+		lockouts, err := th.loginFailed(ip, account, throttleNow.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lockouts
+	}
+	scopes := func(lockouts []*database.Lockout) []string {
+		var s []string
+		for _, l := range lockouts {
+			s = append(s, l.Scope+":"+l.Subject)
+		}
+		return s
+	}
+
+	// A failure that fell out of the window does not count.
+	fail(ip, "alice", -16*time.Minute)
+	for i := 1; i < 5; i++ {
+		if got := fail(ip, "Alice", time.Duration(i)*time.Second); len(got) != 0 {
+			t.Fatalf("failure %d locked out %v", i, scopes(got))
+		}
+	}
+	got := fail(ip, "alice", 5*time.Second)
+	if len(got) != 1 || got[0].Scope != ScopeAccount || got[0].Subject != "alice" || got[0].Failures != 5 ||
+		!got[0].LockedUntil.Equal(throttleNow.Add(5*time.Second+15*time.Minute)) {
+		t.Fatalf("fifth failure locked out %+v, want alice until 15m later", got)
+	}
+	if until, _ := store.LockedUntil(ScopeAccount, "alice", throttleNow.Add(5*time.Second)); until == nil {
+		t.Error("alice is not locked out")
+	}
+
+	// The account's count starts over; the address keeps counting across
+	// accounts up to its own threshold.
+	if got := fail(ip, "alice", 6*time.Second); len(got) != 0 {
+		t.Errorf("failure after the lockout locked out %v", scopes(got))
+	}
+	fail(ip, "bob", 7*time.Second)
+	got = fail(ip, "carol", 8*time.Second)
+	if s := scopes(got); len(s) != 1 || s[0] != ScopeIP+":"+ip {
+		t.Errorf("eighth failure from %s locked out %v, want the address", ip, s)
+	}
+	if got := fail("192.0.2.2", "dave", 9*time.Second); len(got) != 0 {
+		t.Errorf("failure from another address locked out %v", scopes(got))
+	}
+}
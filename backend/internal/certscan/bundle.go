@@ -0,0 +1,200 @@
+package certscan
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Bundle is the content of an uploaded certificate file.
+type Bundle struct {
+	Certificates []*x509.Certificate
+	// PrivateKey is set when the file carried one. Callers must not persist
+	// it unless it is sealed first.
+	PrivateKey crypto.PrivateKey
+}
+
+// ParseBundle reads a PEM bundle, one or more concatenated DER certificates,
+// or a PKCS#12 archive protected by password.
+func ParseBundle(data []byte, password string) (*Bundle, error) {
+	var (
+		bundle *Bundle
+		err    error
+	)
+	switch {
+	case bytes.Contains(data, []byte("-----BEGIN")):
+		bundle, err = parsePEM(data)
+	default:
+		if certs, derErr := x509.ParseCertificates(data); derErr == nil {
+			bundle = &Bundle{Certificates: certs}
+		} else {
+			bundle, err = parsePKCS12(data, password)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bundle.Certificates) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	bundle.Certificates = dedupe(bundle.Certificates)
+	return bundle, nil
+}
+
+func parsePEM(data []byte) (*Bundle, error) {
+	bundle := &Bundle{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch {
+		case block.Type == "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate: %w", err)
+			}
+			bundle.Certificates = append(bundle.Certificates, cert)
+		case block.Type == "ENCRYPTED PRIVATE KEY" || block.Headers["Proc-Type"] != "":
+			return nil, errors.New("encrypted PEM private keys are not supported, upload a PKCS#12 file instead")
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			if bundle.PrivateKey != nil {
+				return nil, errors.New("bundle contains more than one private key")
+			}
+			key, err := parsePrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			bundle.PrivateKey = key
+		}
+	}
+	return bundle, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+func parsePKCS12(data []byte, password string) (*Bundle, error) {
+	key, leaf, cas, err := pkcs12.DecodeChain(data, password)
+	if err == nil {
+		return &Bundle{Certificates: append([]*x509.Certificate{leaf}, cas...), PrivateKey: key}, nil
+	}
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		return nil, errors.New("incorrect PKCS#12 password")
+	}
+
+	// Archives without a key are trust stores.
+	certs, tsErr := pkcs12.DecodeTrustStore(data, password)
+	if tsErr == nil {
+		return &Bundle{Certificates: certs}, nil
+	}
+	return nil, errors.New("file is not a PEM, DER or PKCS#12 certificate")
+}
+
+func dedupe(certs []*x509.Certificate) []*x509.Certificate {
+	seen := make(map[string]bool)
+	var out []*x509.Certificate
+	for _, c := range certs {
+		fp := Fingerprint(c)
+		if !seen[fp] {
+			seen[fp] = true
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Chains splits a bundle into one chain per leaf, leaf first, followed by
+// whatever issuers the bundle contains. A leaf is any certificate that did not
+// issue another certificate in the bundle.
+func (b *Bundle) Chains() [][]*x509.Certificate {
+	issued := make(map[*x509.Certificate]bool)
+	for _, c := range b.Certificates {
+		for _, other := range b.Certificates {
+			if other != c && bytes.Equal(other.RawIssuer, c.RawSubject) && other.CheckSignatureFrom(c) == nil {
+				issued[c] = true
+			}
+		}
+	}
+
+	var chains [][]*x509.Certificate
+	for _, leaf := range b.Certificates {
+		if issued[leaf] {
+			continue
+		}
+		chain := []*x509.Certificate{leaf}
+		for cur := leaf; !isSelfSigned(cur); {
+			next := b.issuerOf(cur, chain)
+			if next == nil {
+				break
+			}
+			chain = append(chain, next)
+			cur = next
+		}
+		chains = append(chains, chain)
+	}
+	return chains
+}
+
+func (b *Bundle) issuerOf(cert *x509.Certificate, exclude []*x509.Certificate) *x509.Certificate {
+outer:
+	for _, c := range b.Certificates {
+		for _, e := range exclude {
+			if c == e {
+				continue outer
+			}
+		}
+		if bytes.Equal(cert.RawIssuer, c.RawSubject) && cert.CheckSignatureFrom(c) == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// KeyFor returns the bundle's private key in PKCS#8 form if it belongs to
+// cert, along with its algorithm.
+func (b *Bundle) KeyFor(cert *x509.Certificate) (der []byte, keyType string, ok bool) {
+	signer, isSigner := b.PrivateKey.(crypto.Signer)
+	if !isSigner {
+		return nil, "", false
+	}
+	pub, isEqualer := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !isEqualer || !pub.Equal(cert.PublicKey) {
+		return nil, "", false
+	}
+
+	switch b.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		keyType = "RSA"
+	case *ecdsa.PrivateKey:
+		keyType = "ECDSA"
+	case ed25519.PrivateKey:
+		keyType = "Ed25519"
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(b.PrivateKey)
+	if err != nil {
+		return nil, "", false
+	}
+	return der, keyType, true
+}
@@ -0,0 +1,170 @@
+package certscan
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func pemCerts(certs ...*testCert) []byte {
+	var buf bytes.Buffer
+	for _, c := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+func pemKey(t *testing.T, c *testCert) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// subjects lists the common names of a chain.
+func subjects(chain []*x509.Certificate) string {
+	var names []string
+	for _, c := range chain {
+		names = append(names, c.Subject.CommonName)
+	}
+	return strings.Join(names, " > ")
+}
+
+func TestParseBundle(t *testing.T) {
+	root, intermediate, leaf := testChain(t)
+	pfx, err := pkcs12.Modern.Encode(leaf.key, leaf.cert, []*x509.Certificate{intermediate.cert}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustStore, err := pkcs12.Modern.EncodeTrustStore([]*x509.Certificate{root.cert}, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	der := append(append([]byte{}, leaf.cert.Raw...), intermediate.cert.Raw...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		password string
+		wantCN   []string
+		wantKey  bool
+		wantErr  string
+	}{
+		{name: "PEM chain", data: pemCerts(leaf, intermediate), wantCN: []string{"example.org", "Test Intermediate"}},
+		{name: "PEM with key", data: append(pemCerts(leaf), pemKey(t, leaf)...), wantCN: []string{"example.org"}, wantKey: true},
+		{name: "PEM duplicates", data: pemCerts(leaf, leaf, intermediate), wantCN: []string{"example.org", "Test Intermediate"}},
+		{name: "DER", data: der, wantCN: []string{"example.org", "Test Intermediate"}},
+		{name: "PKCS#12", data: pfx, password: "s3cret", wantCN: []string{"example.org", "Test Intermediate"}, wantKey: true},
+		{name: "PKCS#12 trust store", data: trustStore, password: "s3cret", wantCN: []string{"Test Root"}},
+		{name: "PKCS#12 wrong password", data: pfx, password: "wrong", wantErr: "incorrect PKCS#12 password"},
+		{name: "two keys", data: append(append(pemCerts(leaf), pemKey(t, leaf)...), pemKey(t, intermediate)...), wantErr: "more than one private key"},
+		{name: "encrypted key", data: append(pemCerts(leaf), pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte{1}})...), wantErr: "encrypted PEM private keys"},
+		{name: "key only", data: pemKey(t, leaf), wantErr: "no certificates found"},
+		{name: "garbage", data: []byte("not a certificate"), wantErr: "not a PEM, DER or PKCS#12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ParseBundle(tt.data, tt.password)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseBundle() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := subjects(b.Certificates); got != strings.Join(tt.wantCN, " > ") {
+				t.Errorf("certificates = %s, want %v", got, tt.wantCN)
+			}
+			if (b.PrivateKey != nil) != tt.wantKey {
+				t.Errorf("private key present = %v, want %v", b.PrivateKey != nil, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestChains(t *testing.T) {
+	root, intermediate, leaf := testChain(t)
+	other := newCert(t, certOptions{cn: "other.example.org", parent: intermediate})
+	unrelated := newCert(t, certOptions{cn: "unrelated.example.org", parent: newCert(t, certOptions{cn: "Other CA", ca: true})})
+
+	tests := []struct {
+		name  string
+		certs []*testCert
+		want  []string
+	}{
+		{"ordered", []*testCert{leaf, intermediate, root}, []string{"example.org > Test Intermediate > Test Root"}},
+		{"reversed", []*testCert{root, intermediate, leaf}, []string{"example.org > Test Intermediate > Test Root"}},
+		{"without root", []*testCert{intermediate, leaf}, []string{"example.org > Test Intermediate"}},
+		{"leaf only", []*testCert{leaf}, []string{"example.org"}},
+		{"shared intermediate", []*testCert{leaf, other, intermediate}, []string{"example.org > Test Intermediate", "other.example.org > Test Intermediate"}},
+		{"issuer missing", []*testCert{leaf, unrelated, intermediate}, []string{"example.org > Test Intermediate", "unrelated.example.org"}},
+		{"self-signed root alone", []*testCert{root}, []string{"Test Root"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bundle{}
+			for _, c := range tt.certs {
+				b.Certificates = append(b.Certificates, c.cert)
+			}
+			var got []string
+			for _, chain := range b.Chains() {
+				got = append(got, subjects(chain))
+			}
+			if strings.Join(got, "; ") != strings.Join(tt.want, "; ") {
+				t.Errorf("Chains() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyFor(t *testing.T) {
+	_, intermediate, leaf := testChain(t)
+	rsaLeaf := newCert(t, certOptions{cn: "rsa.example.org", parent: intermediate, rsaBits: 2048})
+
+	tests := []struct {
+		name     string
+		key      *testCert
+		cert     *testCert
+		wantType string
+		wantOK   bool
+	}{
+		{"matching ECDSA key", leaf, leaf, "ECDSA", true},
+		{"matching RSA key", rsaLeaf, rsaLeaf, "RSA", true},
+		{"key of another certificate", intermediate, leaf, "", false},
+		{"no key", nil, leaf, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Bundle{Certificates: []*x509.Certificate{tt.cert.cert}}
+			if tt.key != nil {
+				b.PrivateKey = tt.key.key
+			}
+			der, keyType, ok := b.KeyFor(tt.cert.cert)
+			if ok != tt.wantOK || keyType != tt.wantType {
+				t.Fatalf("KeyFor() = %q, %v, want %q, %v", keyType, ok, tt.wantType, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			parsed, err := x509.ParsePKCS8PrivateKey(der)
+			if err != nil {
+				t.Fatalf("KeyFor() returned invalid PKCS#8: %v", err)
+			}
+			if !parsed.(crypto.Signer).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.key.key.Public()) {
+				t.Error("KeyFor() returned a different key")
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package certscan
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate and its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// certOptions describes a certificate to generate. The zero value is an
+// ECDSA leaf valid for a year around testNow.
+type certOptions struct {
+	cn       string
+	dnsNames []string
+	ca       bool
+	// parent signs the certificate; nil makes it self-signed.
+	parent   *testCert
+	rsaBits  int
+	sigAlg   x509.SignatureAlgorithm
+	notAfter time.Time
+}
+
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+var serial int64
+
+func newCert(t *testing.T, o certOptions) *testCert {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	if o.rsaBits > 0 {
+		key, err = rsa.GenerateKey(rand.Reader, o.rsaBits)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notAfter := o.notAfter
+	if notAfter.IsZero() {
+		notAfter = testNow.Add(180 * 24 * time.Hour)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: o.cn},
+		DNSNames:              o.dnsNames,
+		NotBefore:             testNow.Add(-180 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		SignatureAlgorithm:    o.sigAlg,
+		BasicConstraintsValid: true,
+		IsCA:                  o.ca,
+	}
+	if o.ca {
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	parent, signer := tmpl, key
+	if o.parent != nil {
+		parent, signer = o.parent.cert, o.parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// testChain returns a root, an intermediate it issued and a leaf for
+// example.org issued by the intermediate.
+func testChain(t *testing.T) (root, intermediate, leaf *testCert) {
+	t.Helper()
+	root = newCert(t, certOptions{cn: "Test Root", ca: true})
+	intermediate = newCert(t, certOptions{cn: "Test Intermediate", ca: true, parent: root})
+	leaf = newCert(t, certOptions{cn: "example.org", dnsNames: []string{"example.org", "www.example.org"}, parent: intermediate})
+	return root, intermediate, leaf
+}
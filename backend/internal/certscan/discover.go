@@ -0,0 +1,133 @@
+package certscan
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+// DefaultDiscoveryPorts are tried on every server in addition to its declared
+// TCP ports.
+var DefaultDiscoveryPorts = []int{443, 465, 636, 993, 995, 6443, 8443}
+
+// Discovery is the outcome of looking for a certificate on one port.
+type Discovery struct {
+	Port        int                      `json:"port"`
+	Certificate *database.SSLCertificate `json:"certificate,omitempty"`
+	Created     bool                     `json:"created"`
+	Error       string                   `json:"error,omitempty"`
+}
+
+// DiscoveryPorts returns the ports to scan on a server: the given ones, or
+// the defaults plus its declared TCP ports. The SSH port is never scanned.
+func (s *Scanner) DiscoveryPorts(server *database.Server, requested []int) ([]int, error) {
+	candidates := requested
+	if len(candidates) == 0 {
+		candidates = append([]int{}, DefaultDiscoveryPorts...)
+		declared, err := s.stores.Ports.ListByServer(server.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range declared {
+			if p.Transport == "tcp" && p.ExpectedState == "open" {
+				candidates = append(candidates, p.Port)
+			}
+		}
+	}
+
+	seen := map[int]bool{}
+	var ports []int
+	for _, port := range candidates {
+		if port < 1 || port > 65535 || port == server.SSHPort || seen[port] {
+			continue
+		}
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// Discover scans ports on a server and creates or refreshes a certificate
+// record for every TLS endpoint found.
+func (s *Scanner) Discover(ctx context.Context, server *database.Server, ports []int) []Discovery {
+	results := make([]Discovery, len(ports))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s.cfg.Concurrency)
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i, port int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = s.discoverPort(ctx, server, port)
+		}(i, port)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (s *Scanner) discoverPort(ctx context.Context, server *database.Server, port int) Discovery {
+	d := Discovery{Port: port}
+
+	certs, err := s.handshake(ctx, server.IPAddress, port, server.Hostname)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	result := ParseChain(certs)
+
+	cert, err := s.stores.SSL.FindByEndpoint(server.ID, server.IPAddress, port)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	domain := PrimaryDomain(certs[0])
+	if domain == "" {
+		domain = server.Hostname
+	}
+	if cert != nil {
+		domain = cert.Domain
+	}
+	result.Findings = Analyze(domain, certs, nil, time.Now())
+
+	if cert == nil {
+		host := server.IPAddress
+		sni := server.Hostname
+		issuer := result.Issuer
+		cert, err = s.stores.SSL.Create(&database.SSLCertificate{
+			ServerID:  server.ID,
+			Domain:    domain,
+			Issuer:    &issuer,
+			IssuedAt:  result.IssuedAt,
+			ExpiresAt: result.ExpiresAt,
+			Status:    "active",
+			ScanHost:  &host,
+			ScanPort:  &port,
+			SNI:       &sni,
+			Source:    "discovered",
+		})
+		if err != nil {
+			d.Error = err.Error()
+			return d
+		}
+		d.Created = true
+	}
+
+	if err := s.stores.SSL.UpdateScanResult(cert.ID, result); err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	if refreshed, err := s.stores.SSL.GetByID(cert.ID); err == nil {
+		cert = refreshed
+	}
+	d.Certificate = cert
+	return d
+}
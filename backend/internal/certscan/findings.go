@@ -0,0 +1,187 @@
+package certscan
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+// Finding types.
+const (
+	FindingHostnameMismatch     = "hostname_mismatch"
+	FindingChainIncomplete      = "chain_incomplete"
+	FindingChainMisordered      = "chain_misordered"
+	FindingSelfSigned           = "self_signed"
+	FindingWeakKey              = "weak_key"
+	FindingSHA1Signature        = "sha1_signature"
+	FindingIntermediateExpired  = "intermediate_expired"
+	FindingIntermediateExpiring = "intermediate_expiring"
+)
+
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+const (
+	minRSABits = 2048
+	// IntermediateExpiryWarning is how close to expiry an intermediate must be
+	// before it is reported.
+	IntermediateExpiryWarning = 30 * 24 * time.Hour
+)
+
+// Analyze validates a chain (leaf first, as presented) against domain and
+// returns every problem found. roots may be nil to use the system pool.
+func Analyze(domain string, certs []*x509.Certificate, roots *x509.CertPool, now time.Time) []database.CertFinding {
+	if len(certs) == 0 {
+		return nil
+	}
+	leaf := certs[0]
+	var findings []database.CertFinding
+	add := func(typ, severity string, cert *x509.Certificate, format string, args ...interface{}) {
+		subject := ""
+		if cert != nil && cert != leaf {
+			subject = cert.Subject.String()
+		}
+		findings = append(findings, database.CertFinding{
+			Type:     typ,
+			Severity: severity,
+			Subject:  subject,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if domain != "" {
+		if err := leaf.VerifyHostname(hostnameToVerify(domain)); err != nil {
+			add(FindingHostnameMismatch, SeverityCritical, leaf,
+				"certificate is not valid for %s (covers %s)", domain, strings.Join(SANs(leaf), ", "))
+		}
+	}
+
+	selfSigned := isSelfSigned(leaf)
+	if selfSigned {
+		add(FindingSelfSigned, SeverityWarning, leaf, "leaf certificate is self-signed")
+	}
+
+	if misordered(certs) {
+		add(FindingChainMisordered, SeverityWarning, nil, "chain certificates are not in issuing order")
+	}
+
+	if !selfSigned {
+		intermediates := x509.NewCertPool()
+		for _, c := range certs[1:] {
+			intermediates.AddCert(c)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   now,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		// Go does not verify SHA-1 signatures, so such chains fail as if an
+		// issuer were missing; the SHA-1 finding below covers them.
+		var unknown x509.UnknownAuthorityError
+		if errors.As(err, &unknown) && !hasSHA1Signature(certs) {
+			add(FindingChainIncomplete, SeverityWarning, nil,
+				"chain does not lead to a trusted root (missing intermediate for %q?)", lastIssuer(certs))
+		}
+	}
+
+	for i, c := range certs {
+		// Root certificates sent by the server are not relied on, so their
+		// own signature algorithm does not matter.
+		root := i > 0 && isSelfSigned(c)
+
+		if rsaKey, ok := c.PublicKey.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSABits {
+			add(FindingWeakKey, SeverityCritical, c, "RSA key is %d bits, minimum is %d", rsaKey.N.BitLen(), minRSABits)
+		}
+		if !root && !(i == 0 && selfSigned) && isSHA1(c.SignatureAlgorithm) {
+			add(FindingSHA1Signature, SeverityWarning, c, "%s is signed with %s", describe(c, i), c.SignatureAlgorithm)
+		}
+		if i == 0 || root {
+			continue
+		}
+		switch {
+		case now.After(c.NotAfter):
+			add(FindingIntermediateExpired, SeverityCritical, c,
+				"%s expired on %s", describe(c, i), c.NotAfter.Format("2006-01-02"))
+		case c.NotAfter.Sub(now) < IntermediateExpiryWarning:
+			add(FindingIntermediateExpiring, SeverityWarning, c,
+				"%s expires on %s", describe(c, i), c.NotAfter.Format("2006-01-02"))
+		}
+	}
+
+	return findings
+}
+
+// hostnameToVerify turns a wildcard domain into a concrete name it must cover.
+func hostnameToVerify(domain string) string {
+	if strings.HasPrefix(domain, "*.") {
+		return "wildcard-check" + domain[1:]
+	}
+	return domain
+}
+
+// isSelfSigned reports whether c is signed by its own key, whether or not it
+// is a CA. Signatures Go no longer verifies, such as SHA-1, are taken as
+// self-signed when issuer and subject match.
+func isSelfSigned(c *x509.Certificate) bool {
+	if !bytes.Equal(c.RawIssuer, c.RawSubject) {
+		return false
+	}
+	err := c.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature)
+	var insecure x509.InsecureAlgorithmError
+	return err == nil || errors.As(err, &insecure)
+}
+
+// hasSHA1Signature reports whether a certificate of the chain other than a
+// self-signed one is signed with SHA-1.
+func hasSHA1Signature(certs []*x509.Certificate) bool {
+	for _, c := range certs {
+		if isSHA1(c.SignatureAlgorithm) && !isSelfSigned(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// misordered reports whether any certificate is not followed by its issuer
+// even though the issuer is present elsewhere in the chain.
+func misordered(certs []*x509.Certificate) bool {
+	for i := 0; i < len(certs)-1; i++ {
+		if bytes.Equal(certs[i].RawIssuer, certs[i+1].RawSubject) {
+			continue
+		}
+		for j, other := range certs {
+			if j != i+1 && j != i && bytes.Equal(certs[i].RawIssuer, other.RawSubject) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func lastIssuer(certs []*x509.Certificate) string {
+	return certs[len(certs)-1].Issuer.String()
+}
+
+func isSHA1(alg x509.SignatureAlgorithm) bool {
+	switch alg {
+	case x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1, x509.MD5WithRSA, x509.MD2WithRSA:
+		return true
+	}
+	return false
+}
+
+func describe(c *x509.Certificate, index int) string {
+	if index == 0 {
+		return "leaf certificate"
+	}
+	return fmt.Sprintf("intermediate %q", c.Subject.CommonName)
+}
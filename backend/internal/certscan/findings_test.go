@@ -0,0 +1,105 @@
+package certscan
+
+import (
+	"crypto/x509"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAnalyze(t *testing.T) {
+	root, intermediate, leaf := testChain(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	wildcard := newCert(t, certOptions{cn: "*.example.org", dnsNames: []string{"*.example.org"}, parent: intermediate})
+	selfSigned := newCert(t, certOptions{cn: "example.org", dnsNames: []string{"example.org"}})
+	weak := newCert(t, certOptions{cn: "example.org", dnsNames: []string{"example.org"}, rsaBits: 1024, parent: intermediate})
+	rsaIntermediate := newCert(t, certOptions{cn: "RSA Intermediate", ca: true, rsaBits: 2048, parent: root})
+	sha1Leaf := newCert(t, certOptions{cn: "example.org", dnsNames: []string{"example.org"}, parent: rsaIntermediate, sigAlg: x509.SHA1WithRSA})
+	sha1Root := newCert(t, certOptions{cn: "SHA-1 Root", ca: true, rsaBits: 2048, sigAlg: x509.SHA1WithRSA})
+	sha1RootIntermediate := newCert(t, certOptions{cn: "Under SHA-1 Root", ca: true, parent: sha1Root})
+	sha1RootLeaf := newCert(t, certOptions{cn: "example.org", dnsNames: []string{"example.org"}, parent: sha1RootIntermediate})
+	expired := newCert(t, certOptions{cn: "Expired Intermediate", ca: true, parent: root, notAfter: testNow.Add(-24 * time.Hour)})
+	expiredLeaf := newCert(t, certOptions{cn: "example.org", dnsNames: []string{"example.org"}, parent: expired})
+	expiring := newCert(t, certOptions{cn: "Expiring Intermediate", ca: true, parent: root, notAfter: testNow.Add(10 * 24 * time.Hour)})
+	expiringLeaf := newCert(t, certOptions{cn: "example.org", dnsNames: []string{"example.org"}, parent: expiring})
+
+	shaRoots := x509.NewCertPool()
+	shaRoots.AddCert(sha1Root.cert)
+
+	tests := []struct {
+		name   string
+		domain string
+		chain  []*testCert
+		roots  *x509.CertPool
+		want   []string
+	}{
+		{"valid chain", "example.org", []*testCert{leaf, intermediate}, roots, nil},
+		{"valid chain with root", "example.org", []*testCert{leaf, intermediate, root}, roots, nil},
+		{"other name on the certificate", "www.example.org", []*testCert{leaf, intermediate}, roots, nil},
+		{"no domain to check", "", []*testCert{leaf, intermediate}, roots, nil},
+		{"hostname mismatch", "example.com", []*testCert{leaf, intermediate}, roots,
+			[]string{"hostname_mismatch/critical"}},
+		{"wildcard domain on a plain certificate", "*.example.org", []*testCert{leaf, intermediate}, roots,
+			[]string{"hostname_mismatch/critical"}},
+		{"wildcard certificate", "*.example.org", []*testCert{wildcard, intermediate}, roots, nil},
+		{"wildcard certificate for a subdomain", "api.example.org", []*testCert{wildcard, intermediate}, roots, nil},
+		{"wildcard certificate for the apex", "example.org", []*testCert{wildcard, intermediate}, roots,
+			[]string{"hostname_mismatch/critical"}},
+		{"incomplete chain", "example.org", []*testCert{leaf}, roots,
+			[]string{"chain_incomplete/warning"}},
+		{"misordered chain", "example.org", []*testCert{leaf, root, intermediate}, roots,
+			[]string{"chain_misordered/warning"}},
+		{"self-signed", "example.org", []*testCert{selfSigned}, roots,
+			[]string{"self_signed/warning"}},
+		{"weak key", "example.org", []*testCert{weak, intermediate}, roots,
+			[]string{"weak_key/critical"}},
+		{"SHA-1 signature", "example.org", []*testCert{sha1Leaf, rsaIntermediate}, roots,
+			[]string{"sha1_signature/warning"}},
+		{"SHA-1 signed root", "example.org", []*testCert{sha1RootLeaf, sha1RootIntermediate, sha1Root}, shaRoots, nil},
+		{"intermediate expired", "example.org", []*testCert{expiredLeaf, expired}, roots,
+			[]string{"intermediate_expired/critical"}},
+		{"intermediate expiring", "example.org", []*testCert{expiringLeaf, expiring}, roots,
+			[]string{"intermediate_expiring/warning"}},
+		{"several problems", "example.com", []*testCert{weak}, roots,
+			[]string{"hostname_mismatch/critical", "chain_incomplete/warning", "weak_key/critical"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chain []*x509.Certificate
+			for _, c := range tt.chain {
+				chain = append(chain, c.cert)
+			}
+			var got []string
+			for _, f := range Analyze(tt.domain, chain, tt.roots, testNow) {
+				got = append(got, f.Type+"/"+f.Severity)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Analyze() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeSubjects(t *testing.T) {
+	root, _, _ := testChain(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	expiring := newCert(t, certOptions{cn: "Expiring Intermediate", ca: true, parent: root, notAfter: testNow.Add(24 * time.Hour)})
+	leaf := newCert(t, certOptions{cn: "example.org", dnsNames: []string{"example.org"}, parent: expiring})
+
+	findings := Analyze("example.com", []*x509.Certificate{leaf.cert, expiring.cert}, roots, testNow)
+	if len(findings) != 2 {
+		t.Fatalf("Analyze() = %+v, want two findings", findings)
+	}
+	// Findings on the leaf have no subject; those on other certificates
+	// name it.
+	if findings[0].Type != FindingHostnameMismatch || findings[0].Subject != "" {
+		t.Errorf("leaf finding = %+v, want no subject", findings[0])
+	}
+	if findings[1].Type != FindingIntermediateExpiring || findings[1].Subject != "CN=Expiring Intermediate" {
+		t.Errorf("intermediate finding = %+v, want subject CN=Expiring Intermediate", findings[1])
+	}
+}
@@ -0,0 +1,301 @@
+// Package certscan connects to TLS endpoints, parses the certificates they
+// present and keeps ssl_certificates in sync with what is actually deployed.
+package certscan
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+const defaultTLSPort = 443
+
+type Config struct {
+	Enabled     bool
+	Interval    time.Duration
+	Timeout     time.Duration
+	Concurrency int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:     true,
+		Interval:    6 * time.Hour,
+		Timeout:     10 * time.Second,
+		Concurrency: 10,
+	}
+}
+
+// ConfigFromEnv reads CERT_SCAN_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Bool("CERT_SCAN_ENABLED", &cfg.Enabled)
+	envconfig.Duration("CERT_SCAN_INTERVAL", &cfg.Interval)
+	envconfig.Duration("CERT_SCAN_TIMEOUT", &cfg.Timeout)
+	envconfig.Int("CERT_SCAN_CONCURRENCY", &cfg.Concurrency, 1)
+	return cfg
+}
+
+type Scanner struct {
+	stores *database.Stores
+	cfg    Config
+}
+
+func New(stores *database.Stores, cfg Config) *Scanner {
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	return &Scanner{stores: stores, cfg: cfg}
+}
+
+// Run rescans every certificate on each interval until ctx is cancelled.
+func (s *Scanner) Run(ctx context.Context) {
+	log.Printf("Certificate scanner started (interval=%s)", s.cfg.Interval)
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.ScanAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("Certificate scanner stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ScanAll rescans every certificate record and returns how many succeeded and failed.
+func (s *Scanner) ScanAll(ctx context.Context) (scanned, failed int) {
+	certs, err := s.stores.SSL.List("", true)
+	if err != nil {
+		log.Println("Certificate scanner: failed to list certificates:", err)
+		return 0, 0
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, s.cfg.Concurrency)
+	)
+	for _, cert := range certs {
+		// Uploaded certificates are usually not reachable from here; only
+		// scan them when an endpoint was set explicitly.
+		if cert.Source == "upload" && cert.ScanHost == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return scanned, failed
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(cert *database.SSLCertificate) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			_, err := s.ScanCertificate(ctx, cert)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				log.Printf("Certificate scanner: %s: %v", cert.Domain, err)
+			} else {
+				scanned++
+			}
+		}(cert)
+	}
+	wg.Wait()
+	return scanned, failed
+}
+
+// Endpoint returns the host, port and SNI name used to scan a certificate.
+func Endpoint(cert *database.SSLCertificate) (host string, port int, serverName string) {
+	host, port, serverName = cert.Domain, defaultTLSPort, cert.Domain
+	if cert.ScanHost != nil && *cert.ScanHost != "" {
+		host = *cert.ScanHost
+	}
+	if cert.ScanPort != nil && *cert.ScanPort > 0 {
+		port = *cert.ScanPort
+	}
+	if cert.SNI != nil && *cert.SNI != "" {
+		serverName = *cert.SNI
+	}
+	// Wildcard domains cannot be dialled or sent as SNI as-is.
+	host = strings.TrimPrefix(host, "*.")
+	serverName = strings.TrimPrefix(serverName, "*.")
+	return host, port, serverName
+}
+
+// ScanCertificate performs a handshake against the certificate's endpoint and
+// stores what was found. Failures are recorded on the certificate as well.
+func (s *Scanner) ScanCertificate(ctx context.Context, cert *database.SSLCertificate) (*database.CertScanResult, error) {
+	host, port, serverName := Endpoint(cert)
+
+	result, err := s.Fetch(ctx, host, port, serverName, cert.Domain)
+	if err != nil {
+		if recErr := s.stores.SSL.RecordScanError(cert.ID, err.Error()); recErr != nil {
+			log.Printf("Certificate scanner: failed to record error for %s: %v", cert.Domain, recErr)
+		}
+		return nil, err
+	}
+
+	if err := s.stores.SSL.UpdateScanResult(cert.ID, result); err != nil {
+		return nil, fmt.Errorf("storing scan result: %w", err)
+	}
+	return result, nil
+}
+
+// Fetch performs a TLS handshake with host:port, parses the presented chain
+// and validates it against domain.
+func (s *Scanner) Fetch(ctx context.Context, host string, port int, serverName, domain string) (*database.CertScanResult, error) {
+	certs, err := s.handshake(ctx, host, port, serverName)
+	if err != nil {
+		return nil, err
+	}
+	result := ParseChain(certs)
+	result.Findings = Analyze(domain, certs, nil, time.Now())
+	return result, nil
+}
+
+// handshake returns the certificates presented by host:port. Verification is
+// skipped on purpose: expired, self-signed and mismatched certificates are
+// exactly what the scanner needs to see.
+func (s *Scanner) handshake(ctx context.Context, host string, port int, serverName string) ([]*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	if net.ParseIP(serverName) != nil {
+		// SNI must not carry IP addresses.
+		serverName = ""
+	}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: s.cfg.Timeout},
+		Config: &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("tls handshake with %s:%d: %w", host, port, err)
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("server presented no certificate")
+	}
+	return certs, nil
+}
+
+// ParseChain describes a certificate chain, leaf first.
+func ParseChain(certs []*x509.Certificate) *database.CertScanResult {
+	leaf := certs[0]
+	keyType, keyBits := KeyInfo(leaf)
+
+	result := &database.CertScanResult{
+		Subject:            leaf.Subject.String(),
+		Issuer:             IssuerName(leaf),
+		SerialNumber:       SerialHex(leaf),
+		IssuedAt:           leaf.NotBefore,
+		ExpiresAt:          leaf.NotAfter,
+		SANs:               SANs(leaf),
+		KeyType:            keyType,
+		KeyBits:            keyBits,
+		SignatureAlgorithm: leaf.SignatureAlgorithm.String(),
+		FingerprintSHA256:  Fingerprint(leaf),
+	}
+	for _, c := range certs {
+		kt, kb := KeyInfo(c)
+		result.Chain = append(result.Chain, database.CertChainEntry{
+			Subject:            c.Subject.String(),
+			Issuer:             c.Issuer.String(),
+			SerialNumber:       SerialHex(c),
+			NotBefore:          c.NotBefore,
+			NotAfter:           c.NotAfter,
+			KeyType:            kt,
+			KeyBits:            kb,
+			SignatureAlgorithm: c.SignatureAlgorithm.String(),
+			IsCA:               c.IsCA,
+			FingerprintSHA256:  Fingerprint(c),
+		})
+	}
+	return result
+}
+
+// IssuerName prefers the issuer's organisation, like "Let's Encrypt", and
+// falls back to the common name or full DN.
+func IssuerName(cert *x509.Certificate) string {
+	if len(cert.Issuer.Organization) > 0 {
+		return cert.Issuer.Organization[0]
+	}
+	if cert.Issuer.CommonName != "" {
+		return cert.Issuer.CommonName
+	}
+	return cert.Issuer.String()
+}
+
+func SerialHex(cert *x509.Certificate) string {
+	return strings.ToUpper(cert.SerialNumber.Text(16))
+}
+
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// SANs lists DNS names and IP addresses the certificate is valid for.
+func SANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// KeyInfo returns the public key algorithm and size in bits.
+func KeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+// PrimaryDomain picks a name to record a discovered certificate under.
+func PrimaryDomain(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
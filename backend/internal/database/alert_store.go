@@ -0,0 +1,251 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// AlertState is a firing or resolved alert as last evaluated.
+type AlertState struct {
+	Fingerprint  string            `json:"fingerprint"`
+	Alertname    string            `json:"alertname"`
+	Severity     string            `json:"severity"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"starts_at"`
+	EndsAt       *time.Time        `json:"ends_at"`
+	LastSentAt   *time.Time        `json:"last_sent_at"`
+	ResolvedSent bool              `json:"-"`
+}
+
+// AlertmanagerReceiver is an Alertmanager instance alerts are pushed to.
+// Secrets are sealed and never serialised.
+type AlertmanagerReceiver struct {
+	ID                    string     `json:"id"`
+	Name                  string     `json:"name"`
+	URL                   string     `json:"url"`
+	Enabled               bool       `json:"enabled"`
+	AuthType              string     `json:"auth_type"`
+	Username              *string    `json:"username"`
+	EncryptedPassword     []byte     `json:"-"`
+	EncryptedBearerToken  []byte     `json:"-"`
+	TLSCACert             *string    `json:"tls_ca_cert"`
+	TLSClientCert         *string    `json:"tls_client_cert"`
+	EncryptedTLSClientKey []byte     `json:"-"`
+	TLSInsecureSkipVerify bool       `json:"tls_insecure_skip_verify"`
+	TimeoutSeconds        int        `json:"timeout_seconds"`
+	LastSentAt            *time.Time `json:"last_sent_at"`
+	LastError             *string    `json:"last_error"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+
+	HasPassword     bool `json:"has_password"`
+	HasBearerToken  bool `json:"has_bearer_token"`
+	HasTLSClientKey bool `json:"has_tls_client_key"`
+}
+
+type AlertStore struct {
+	db *sql.DB
+}
+
+func NewAlertStore(db *sql.DB) *AlertStore {
+	return &AlertStore{db: db}
+}
+
+const alertStateColumns = `fingerprint, alertname, severity, labels, annotations, starts_at, ends_at, last_sent_at, resolved_sent`
+
+func (s *AlertStore) queryStates(query string, args ...interface{}) ([]*AlertState, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []*AlertState
+	for rows.Next() {
+		a := &AlertState{}
+		var labels, annotations []byte
+		err := rows.Scan(&a.Fingerprint, &a.Alertname, &a.Severity, &labels, &annotations,
+			&a.StartsAt, &a.EndsAt, &a.LastSentAt, &a.ResolvedSent)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(labels, &a.Labels); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(annotations, &a.Annotations); err != nil {
+			return nil, err
+		}
+		states = append(states, a)
+	}
+	return states, rows.Err()
+}
+
+// Fire records the given alerts as firing. Alerts that are already firing
+// keep their starts_at; new or re-fired alerts start at their StartsAt, or
+// now if it is zero. StartsAt is updated on each state to the stored value.
+func (s *AlertStore) Fire(states []*AlertState, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, a := range states {
+		labels, err := json.Marshal(a.Labels)
+		if err != nil {
+			return err
+		}
+		annotations, err := json.Marshal(a.Annotations)
+		if err != nil {
+			return err
+		}
+		startsAt := a.StartsAt
+		if startsAt.IsZero() {
+			startsAt = now
+		}
+
+		err = tx.QueryRow(`
+			INSERT INTO alerts (fingerprint, alertname, severity, labels, annotations, starts_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (fingerprint) DO UPDATE
+			SET annotations = EXCLUDED.annotations, updated_at = EXCLUDED.updated_at,
+			    starts_at = CASE WHEN alerts.ends_at IS NULL THEN alerts.starts_at ELSE EXCLUDED.starts_at END,
+			    ends_at = NULL, resolved_sent = FALSE
+			RETURNING starts_at
+		`, a.Fingerprint, a.Alertname, a.Severity, string(labels), string(annotations), startsAt, now).Scan(&a.StartsAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ResolveMissing marks every firing alert not in fingerprints as resolved at now.
+func (s *AlertStore) ResolveMissing(fingerprints []string, now time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE alerts SET ends_at = $2, updated_at = $2
+		WHERE ends_at IS NULL AND NOT (fingerprint = ANY($1))
+	`, pq.Array(fingerprints), now)
+	return err
+}
+
+// ListFiring returns all firing alerts.
+func (s *AlertStore) ListFiring() ([]*AlertState, error) {
+	return s.queryStates(`SELECT ` + alertStateColumns + ` FROM alerts WHERE ends_at IS NULL ORDER BY starts_at`)
+}
+
+// ListPending returns firing alerts and resolved alerts that have not been
+// delivered as resolved yet.
+func (s *AlertStore) ListPending() ([]*AlertState, error) {
+	return s.queryStates(`SELECT ` + alertStateColumns + ` FROM alerts WHERE ends_at IS NULL OR NOT resolved_sent ORDER BY starts_at`)
+}
+
+// MarkSent records a successful delivery of the given alerts.
+func (s *AlertStore) MarkSent(fingerprints []string, now time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE alerts SET last_sent_at = $2, resolved_sent = (ends_at IS NOT NULL)
+		WHERE fingerprint = ANY($1)
+	`, pq.Array(fingerprints), now)
+	return err
+}
+
+// PruneResolved deletes delivered resolved alerts that ended before cutoff.
+func (s *AlertStore) PruneResolved(cutoff time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM alerts WHERE resolved_sent AND ends_at < $1`, cutoff)
+	return err
+}
+
+const alertmanagerReceiverColumns = `id, name, url, enabled, auth_type, username, encrypted_password, encrypted_bearer_token,
+	tls_ca_cert, tls_client_cert, encrypted_tls_client_key, tls_insecure_skip_verify, timeout_seconds,
+	last_sent_at, last_error, created_at, updated_at`
+
+func scanAlertmanagerReceiver(row interface{ Scan(...interface{}) error }) (*AlertmanagerReceiver, error) {
+	r := &AlertmanagerReceiver{}
+	err := row.Scan(&r.ID, &r.Name, &r.URL, &r.Enabled, &r.AuthType, &r.Username, &r.EncryptedPassword,
+		&r.EncryptedBearerToken, &r.TLSCACert, &r.TLSClientCert, &r.EncryptedTLSClientKey, &r.TLSInsecureSkipVerify,
+		&r.TimeoutSeconds, &r.LastSentAt, &r.LastError, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.HasPassword = len(r.EncryptedPassword) > 0
+	r.HasBearerToken = len(r.EncryptedBearerToken) > 0
+	r.HasTLSClientKey = len(r.EncryptedTLSClientKey) > 0
+	return r, nil
+}
+
+func (s *AlertStore) ListReceivers() ([]*AlertmanagerReceiver, error) {
+	rows, err := s.db.Query(`SELECT ` + alertmanagerReceiverColumns + ` FROM alertmanager_receivers ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receivers := []*AlertmanagerReceiver{}
+	for rows.Next() {
+		r, err := scanAlertmanagerReceiver(rows)
+		if err != nil {
+			return nil, err
+		}
+		receivers = append(receivers, r)
+	}
+	return receivers, rows.Err()
+}
+
+func (s *AlertStore) GetReceiver(id string) (*AlertmanagerReceiver, error) {
+	return scanAlertmanagerReceiver(s.db.QueryRow(`SELECT `+alertmanagerReceiverColumns+` FROM alertmanager_receivers WHERE id = $1`, id))
+}
+
+// CreateReceiver inserts a receiver. The ID is generated by the caller when
+// secrets have to be sealed against it, otherwise here.
+func (s *AlertStore) CreateReceiver(r *AlertmanagerReceiver) (*AlertmanagerReceiver, error) {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO alertmanager_receivers (id, name, url, enabled, auth_type, username, encrypted_password,
+		    encrypted_bearer_token, tls_ca_cert, tls_client_cert, encrypted_tls_client_key, tls_insecure_skip_verify, timeout_seconds)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, r.ID, r.Name, r.URL, r.Enabled, r.AuthType, r.Username, r.EncryptedPassword, r.EncryptedBearerToken,
+		r.TLSCACert, r.TLSClientCert, r.EncryptedTLSClientKey, r.TLSInsecureSkipVerify, r.TimeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetReceiver(r.ID)
+}
+
+func (s *AlertStore) UpdateReceiver(r *AlertmanagerReceiver) error {
+	_, err := s.db.Exec(`
+		UPDATE alertmanager_receivers
+		SET name = $2, url = $3, enabled = $4, auth_type = $5, username = $6, encrypted_password = $7,
+		    encrypted_bearer_token = $8, tls_ca_cert = $9, tls_client_cert = $10, encrypted_tls_client_key = $11,
+		    tls_insecure_skip_verify = $12, timeout_seconds = $13, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, r.ID, r.Name, r.URL, r.Enabled, r.AuthType, r.Username, r.EncryptedPassword, r.EncryptedBearerToken,
+		r.TLSCACert, r.TLSClientCert, r.EncryptedTLSClientKey, r.TLSInsecureSkipVerify, r.TimeoutSeconds)
+	return err
+}
+
+func (s *AlertStore) DeleteReceiver(id string) error {
+	_, err := s.db.Exec(`DELETE FROM alertmanager_receivers WHERE id = $1`, id)
+	return err
+}
+
+// RecordReceiverResult stores the outcome of the last push to a receiver.
+func (s *AlertStore) RecordReceiverResult(id string, at time.Time, sendErr error) error {
+	var msg *string
+	if sendErr != nil {
+		m := sendErr.Error()
+		msg = &m
+	}
+	_, err := s.db.Exec(`
+		UPDATE alertmanager_receivers
+		SET last_sent_at = CASE WHEN $3::text IS NULL THEN $2 ELSE last_sent_at END, last_error = $3
+		WHERE id = $1
+	`, id, at, msg)
+	return err
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditEvent records a security relevant action. ActorID is nil for events
+// raised by the backend itself.
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	ActorID    *string                `json:"actor_id"`
+	Action     string                 `json:"action"`
+	TargetType *string                `json:"target_type"`
+	TargetID   *string                `json:"target_id"`
+	Details    map[string]interface{} `json:"details"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// AuditFilter narrows ListEvents; empty fields match everything.
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      *time.Time
+	Limit      int
+}
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+func NewAuditStore(db *sql.DB) *AuditStore {
+	return &AuditStore{db: db}
+}
+
+// Record appends an event. actorID may be empty for system events.
+func (s *AuditStore) Record(actorID, action, targetType, targetID string, details map[string]interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, details)
+		VALUES ($1, $2, $3, $4, $5)
+	`, nullString(actorID), action, nullString(targetType), nullString(targetID), data)
+	return err
+}
+
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// ListEvents returns the newest events first.
+func (s *AuditStore) ListEvents(f AuditFilter) ([]*AuditEvent, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ActorID != "" {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.Since != nil {
+		add("created_at >= $%d", *f.Since)
+	}
+
+	query := `SELECT id, actor_id, action, target_type, target_id, details, created_at FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	for rows.Next() {
+		e := &AuditEvent{}
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(details, &e.Details); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
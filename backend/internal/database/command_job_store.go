@@ -0,0 +1,251 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Command job statuses.
+const (
+	JobRunning   = "running"
+	JobComplete  = "complete"
+	JobCancelled = "cancelled"
+	// JobInterrupted marks a job that was running when the backend stopped.
+	JobInterrupted = "interrupted"
+)
+
+// Command job host statuses. A host succeeds when the command exits 0 and
+// fails on any other exit status; error means it could not be run at all.
+const (
+	HostPending     = "pending"
+	HostRunning     = "running"
+	HostSucceeded   = "succeeded"
+	HostFailed      = "failed"
+	HostTimedOut    = "timeout"
+	HostError       = "error"
+	HostCancelled   = "cancelled"
+	HostInterrupted = "interrupted"
+)
+
+// JobSelector picks the servers a job runs on; a server matches if it is
+// listed, in a listed group or has any of the tags.
+type JobSelector struct {
+	ServerIDs []string `json:"server_ids"`
+	GroupIDs  []string `json:"group_ids"`
+	Tags      []string `json:"tags"`
+}
+
+type CommandJob struct {
+	ID             string      `json:"id"`
+	Command        string      `json:"command"`
+	Selector       JobSelector `json:"selector"`
+	CreatedBy      *string     `json:"created_by"`
+	Status         string      `json:"status"`
+	Concurrency    int         `json:"concurrency"`
+	TimeoutSeconds int         `json:"timeout_seconds"`
+	TotalHosts     int         `json:"total_hosts"`
+	Succeeded      int         `json:"succeeded"`
+	Failed         int         `json:"failed"`
+	CreatedAt      time.Time   `json:"created_at"`
+	FinishedAt     *time.Time  `json:"finished_at"`
+}
+
+type CommandJobHost struct {
+	ID              string     `json:"id"`
+	JobID           string     `json:"job_id"`
+	ServerID        *string    `json:"server_id"`
+	Hostname        string     `json:"hostname"`
+	Status          string     `json:"status"`
+	ExitCode        *int       `json:"exit_code"`
+	Output          string     `json:"output"`
+	OutputTruncated bool       `json:"output_truncated"`
+	Error           *string    `json:"error"`
+	StartedAt       *time.Time `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"`
+}
+
+// CommandJobFilter narrows ListJobs; empty fields match everything.
+type CommandJobFilter struct {
+	CreatedBy string
+	Limit     int
+}
+
+const commandJobColumns = `id, command, selector, created_by, status, concurrency, timeout_seconds,
+	total_hosts, succeeded, failed, created_at, finished_at`
+
+const commandJobHostColumns = `id, job_id, server_id, hostname, status, exit_code, output, output_truncated,
+	error, started_at, finished_at`
+
+type CommandJobStore struct {
+	db *sql.DB
+}
+
+func NewCommandJobStore(db *sql.DB) *CommandJobStore {
+	return &CommandJobStore{db: db}
+}
+
+func scanCommandJob(row interface{ Scan(...interface{}) error }) (*CommandJob, error) {
+	j := &CommandJob{}
+	var selector []byte
+	err := row.Scan(&j.ID, &j.Command, &selector, &j.CreatedBy, &j.Status, &j.Concurrency, &j.TimeoutSeconds,
+		&j.TotalHosts, &j.Succeeded, &j.Failed, &j.CreatedAt, &j.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(selector, &j.Selector); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func scanCommandJobHost(row interface{ Scan(...interface{}) error }) (*CommandJobHost, error) {
+	h := &CommandJobHost{}
+	err := row.Scan(&h.ID, &h.JobID, &h.ServerID, &h.Hostname, &h.Status, &h.ExitCode, &h.Output,
+		&h.OutputTruncated, &h.Error, &h.StartedAt, &h.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// CreateJob inserts a job and its hosts, assigning IDs.
+func (s *CommandJobStore) CreateJob(job *CommandJob, hosts []*CommandJobHost) error {
+	selector, err := json.Marshal(job.Selector)
+	if err != nil {
+		return err
+	}
+	job.ID = uuid.New().String()
+	job.TotalHosts = len(hosts)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO command_jobs (id, command, selector, created_by, status, concurrency, timeout_seconds, total_hosts, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, job.ID, job.Command, selector, job.CreatedBy, job.Status, job.Concurrency, job.TimeoutSeconds, job.TotalHosts, job.CreatedAt)
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		h.ID = uuid.New().String()
+		h.JobID = job.ID
+		_, err := tx.Exec(`
+			INSERT INTO command_job_hosts (id, job_id, server_id, hostname, status)
+			VALUES ($1, $2, $3, $4, $5)
+		`, h.ID, h.JobID, h.ServerID, h.Hostname, h.Status)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListJobs returns jobs, newest first.
+func (s *CommandJobStore) ListJobs(f CommandJobFilter) ([]*CommandJob, error) {
+	query := `SELECT ` + commandJobColumns + ` FROM command_jobs`
+	var args []interface{}
+	if f.CreatedBy != "" {
+		args = append(args, f.CreatedBy)
+		query += " WHERE created_by = $1"
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*CommandJob{}
+	for rows.Next() {
+		j, err := scanCommandJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *CommandJobStore) GetJob(id string) (*CommandJob, error) {
+	return scanCommandJob(s.db.QueryRow(`SELECT `+commandJobColumns+` FROM command_jobs WHERE id = $1`, id))
+}
+
+// ListHosts returns a job's hosts ordered by hostname.
+func (s *CommandJobStore) ListHosts(jobID string) ([]*CommandJobHost, error) {
+	rows, err := s.db.Query(`SELECT `+commandJobHostColumns+` FROM command_job_hosts WHERE job_id = $1 ORDER BY hostname`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hosts := []*CommandJobHost{}
+	for rows.Next() {
+		h, err := scanCommandJobHost(rows)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+func (s *CommandJobStore) StartHost(id string, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE command_job_hosts SET status = $2, started_at = $3 WHERE id = $1`, id, HostRunning, at)
+	return err
+}
+
+// FinishHost records the outcome of a host.
+func (s *CommandJobStore) FinishHost(h *CommandJobHost) error {
+	_, err := s.db.Exec(`
+		UPDATE command_job_hosts
+		SET status = $2, exit_code = $3, output = $4, output_truncated = $5, error = $6, finished_at = $7
+		WHERE id = $1
+	`, h.ID, h.Status, h.ExitCode, h.Output, h.OutputTruncated, h.Error, h.FinishedAt)
+	return err
+}
+
+// FinishJob records the outcome of a job.
+func (s *CommandJobStore) FinishJob(j *CommandJob) error {
+	_, err := s.db.Exec(`
+		UPDATE command_jobs SET status = $2, succeeded = $3, failed = $4, finished_at = $5 WHERE id = $1
+	`, j.ID, j.Status, j.Succeeded, j.Failed, j.FinishedAt)
+	return err
+}
+
+// InterruptRunning marks jobs and hosts that were still running as
+// interrupted, for use at startup.
+func (s *CommandJobStore) InterruptRunning(at time.Time) (int64, error) {
+	_, err := s.db.Exec(`
+		UPDATE command_job_hosts SET status = $1, finished_at = $2
+		WHERE status IN ($3, $4)
+	`, HostInterrupted, at, HostPending, HostRunning)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.db.Exec(`
+		UPDATE command_jobs SET status = $1, finished_at = $2 WHERE status = $3
+	`, JobInterrupted, at, JobRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteFinishedBefore removes jobs that finished before t, with their hosts.
+func (s *CommandJobStore) DeleteFinishedBefore(t time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM command_jobs WHERE finished_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
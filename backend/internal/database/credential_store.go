@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Credential kinds.
+const (
+	CredentialPrivateKey = "private_key"
+	CredentialPassword   = "password"
+)
+
+// Credential is an SSH login held in the vault. EncryptedSecret is never
+// serialized; the API only exposes the public half of keys.
+type Credential struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Kind        string  `json:"kind"`
+	// Username is used for servers that do not set their own SSH username.
+	Username      *string `json:"username"`
+	PublicKey     *string `json:"public_key"`
+	Fingerprint   *string `json:"fingerprint"`
+	HasPassphrase bool    `json:"has_passphrase"`
+
+	EncryptedSecret []byte `json:"-"`
+
+	CreatedBy  *string    `json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+const credentialColumns = `id, name, description, kind, username, public_key, fingerprint, has_passphrase,
+	encrypted_secret, created_by, last_used_at, created_at, updated_at`
+
+type CredentialStore struct {
+	db *sql.DB
+}
+
+func NewCredentialStore(db *sql.DB) *CredentialStore {
+	return &CredentialStore{db: db}
+}
+
+func scanCredential(row interface{ Scan(...interface{}) error }) (*Credential, error) {
+	c := &Credential{}
+	err := row.Scan(&c.ID, &c.Name, &c.Description, &c.Kind, &c.Username, &c.PublicKey, &c.Fingerprint,
+		&c.HasPassphrase, &c.EncryptedSecret, &c.CreatedBy, &c.LastUsedAt, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *CredentialStore) List() ([]*Credential, error) {
+	rows, err := s.db.Query(`SELECT ` + credentialColumns + ` FROM credentials ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []*Credential{}
+	for rows.Next() {
+		c, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, rows.Err()
+}
+
+func (s *CredentialStore) Get(id string) (*Credential, error) {
+	return scanCredential(s.db.QueryRow(`SELECT `+credentialColumns+` FROM credentials WHERE id = $1`, id))
+}
+
+// ForServer returns the credential a server authenticates with: its own, else
+// its group's. It returns nil if neither is set.
+func (s *CredentialStore) ForServer(serverID string) (*Credential, error) {
+	c, err := scanCredential(s.db.QueryRow(`
+		SELECT `+credentialColumns+` FROM credentials
+		WHERE id = (
+			SELECT COALESCE(s.credential_id, g.credential_id)
+			FROM servers s LEFT JOIN server_groups g ON g.id = s.group_id
+			WHERE s.id = $1
+		)`, serverID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// Create inserts a credential whose ID and secret the caller has already set,
+// since the secret is sealed against the ID.
+func (s *CredentialStore) Create(c *Credential) (*Credential, error) {
+	return scanCredential(s.db.QueryRow(`
+		INSERT INTO credentials (id, name, description, kind, username, public_key, fingerprint, has_passphrase,
+			encrypted_secret, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+credentialColumns,
+		c.ID, c.Name, c.Description, c.Kind, c.Username, c.PublicKey, c.Fingerprint, c.HasPassphrase,
+		c.EncryptedSecret, c.CreatedBy))
+}
+
+func (s *CredentialStore) Update(c *Credential) error {
+	res, err := s.db.Exec(`
+		UPDATE credentials
+		SET name = $2, description = $3, kind = $4, username = $5, public_key = $6, fingerprint = $7,
+		    has_passphrase = $8, encrypted_secret = $9, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, c.ID, c.Name, c.Description, c.Kind, c.Username, c.PublicKey, c.Fingerprint, c.HasPassphrase, c.EncryptedSecret)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete removes a credential; servers and groups using it fall back to
+// their other authentication methods.
+func (s *CredentialStore) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM credentials WHERE id = $1`, id)
+	return err
+}
+
+func (s *CredentialStore) MarkUsed(id string, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE credentials SET last_used_at = $2 WHERE id = $1`, id, at)
+	return err
+}
@@ -0,0 +1,96 @@
+// Package dbtest gives tests a PostgreSQL database with the schema of
+// migrations/ applied. Each test gets a schema of its own, dropped when the
+// test ends. Tests using it are skipped unless TEST_DATABASE_URL is set to a
+// database the tests may create schemas in.
+package dbtest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	_ "github.com/lib/pq"
+)
+
+// Open returns a connection to a new schema with all migrations applied.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(suffix)
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("dropping schema %s: %v", schema, err)
+		}
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, file := range migrations(t) {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(body)); err != nil {
+			t.Fatalf("applying %s: %v", filepath.Base(file), err)
+		}
+	}
+	return db
+}
+
+// withSearchPath points the connections of dsn, a URL or key=value
+// connection string, at schema. lib/pq sends unknown parameters to the
+// server as run-time settings.
+func withSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+// migrations returns the files in migrations/ ordered by their number.
+func migrations(t testing.TB) []string {
+	_, self, _, _ := runtime.Caller(0)
+	dir := filepath.Join(filepath.Dir(self), "..", "..", "..", "migrations")
+	files, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations in %s", dir)
+	}
+	number := func(file string) int {
+		n, _ := strconv.Atoi(strings.SplitN(filepath.Base(file), "_", 2)[0])
+		return n
+	}
+	sort.Slice(files, func(i, j int) bool { return number(files[i]) < number(files[j]) })
+	return files
+}
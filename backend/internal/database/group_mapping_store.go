@@ -0,0 +1,176 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Auth providers users sign in with.
+const (
+	AuthProviderLocal = "local"
+	AuthProviderOIDC  = "oidc"
+	AuthProviderLDAP  = "ldap"
+)
+
+// GroupMapping grants the members of an identity provider group a role,
+// access to a server or access to every server of a server group.
+type GroupMapping struct {
+	ID            string    `json:"id"`
+	Provider      string    `json:"provider"`
+	ExternalGroup string    `json:"external_group"`
+	Role          *string   `json:"role"`
+	ServerID      *string   `json:"server_id"`
+	ServerGroupID *string   `json:"server_group_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+const groupMappingColumns = `id, provider, external_group, role, server_id, server_group_id, created_at`
+
+type GroupMappingStore struct {
+	db *sql.DB
+}
+
+func NewGroupMappingStore(db *sql.DB) *GroupMappingStore {
+	return &GroupMappingStore{db: db}
+}
+
+func scanGroupMapping(row interface{ Scan(...interface{}) error }) (*GroupMapping, error) {
+	m := &GroupMapping{}
+	err := row.Scan(&m.ID, &m.Provider, &m.ExternalGroup, &m.Role, &m.ServerID, &m.ServerGroupID, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// List returns the mappings of a provider, or of all providers if provider
+// is empty.
+func (s *GroupMappingStore) List(provider string) ([]*GroupMapping, error) {
+	rows, err := s.db.Query(`
+		SELECT `+groupMappingColumns+` FROM auth_group_mappings
+		WHERE $1 = '' OR provider = $1
+		ORDER BY provider, external_group, created_at
+	`, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mappings := []*GroupMapping{}
+	for rows.Next() {
+		m, err := scanGroupMapping(rows)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+func (s *GroupMappingStore) Get(id string) (*GroupMapping, error) {
+	return scanGroupMapping(s.db.QueryRow(`
+		SELECT `+groupMappingColumns+` FROM auth_group_mappings WHERE id = $1
+	`, id))
+}
+
+func (s *GroupMappingStore) Create(m *GroupMapping) error {
+	m.ID = uuid.New().String()
+	m.CreatedAt = time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO auth_group_mappings (id, provider, external_group, role, server_id, server_group_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, m.ID, m.Provider, m.ExternalGroup, m.Role, m.ServerID, m.ServerGroupID, m.CreatedAt)
+	return err
+}
+
+// Delete removes a mapping, reporting whether it existed.
+func (s *GroupMappingStore) Delete(id string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM auth_group_mappings WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Apply sets the roles and provider-granted server permissions of a user from
+// the groups the provider reports. Providers without any mappings leave the
+// user unchanged; otherwise the user gets the mapped roles, or the user role
+// if none match, and permissions the provider granted before but no longer
+// maps are removed. Permissions granted manually are kept. It reports
+// whether mappings were applied and the resulting roles.
+func (s *GroupMappingStore) Apply(userID, provider string, groups []string) (bool, []string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, nil, err
+	}
+	defer tx.Rollback()
+
+	var configured bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM auth_group_mappings WHERE provider = $1)`, provider).Scan(&configured); err != nil {
+		return false, nil, err
+	}
+	if !configured {
+		return false, nil, nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT DISTINCT role::text FROM auth_group_mappings
+		WHERE provider = $1 AND external_group = ANY($2) AND role IS NOT NULL
+		ORDER BY 1
+	`, provider, pq.Array(groups))
+	if err != nil {
+		return false, nil, err
+	}
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			rows.Close()
+			return false, nil, err
+		}
+		roles = append(roles, role)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, nil, err
+	}
+	if len(roles) == 0 {
+		roles = []string{"user"}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = $1`, userID); err != nil {
+		return false, nil, err
+	}
+	for _, role := range roles {
+		_, err := tx.Exec(`INSERT INTO user_roles (id, user_id, role, created_at) VALUES ($1, $2, $3, $4)`,
+			uuid.New().String(), userID, role, time.Now())
+		if err != nil {
+			return false, nil, err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_server_permissions WHERE user_id = $1 AND source = $2`, userID, provider); err != nil {
+		return false, nil, err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO user_server_permissions (id, user_id, server_id, source, created_at)
+		SELECT gen_random_uuid()::text, $1, server_id, $2, $4
+		FROM (
+			SELECT m.server_id FROM auth_group_mappings m
+			WHERE m.provider = $2 AND m.external_group = ANY($3) AND m.server_id IS NOT NULL
+			UNION
+			SELECT sv.id FROM auth_group_mappings m JOIN servers sv ON sv.group_id = m.server_group_id
+			WHERE m.provider = $2 AND m.external_group = ANY($3)
+		) mapped
+		ON CONFLICT (user_id, server_id) DO NOTHING
+	`, userID, provider, pq.Array(groups), time.Now())
+	if err != nil {
+		return false, nil, err
+	}
+
+	return true, roles, tx.Commit()
+}
@@ -21,13 +21,13 @@ func (s *GroupStore) Create(group *ServerGroup) (*ServerGroup, error) {
 	group.UpdatedAt = time.Now()
 
 	query := `
-		INSERT INTO server_groups (id, name, description, color, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, name, description, color, created_at, updated_at
+		INSERT INTO server_groups (id, name, description, color, credential_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, name, description, color, credential_id, created_at, updated_at
 	`
 
-	err := s.db.QueryRow(query, group.ID, group.Name, group.Description, group.Color, group.CreatedAt, group.UpdatedAt).
-		Scan(&group.ID, &group.Name, &group.Description, &group.Color, &group.CreatedAt, &group.UpdatedAt)
+	err := s.db.QueryRow(query, group.ID, group.Name, group.Description, group.Color, group.CredentialID, group.CreatedAt, group.UpdatedAt).
+		Scan(&group.ID, &group.Name, &group.Description, &group.Color, &group.CredentialID, &group.CreatedAt, &group.UpdatedAt)
 
 	return group, err
 }
@@ -35,18 +35,18 @@ func (s *GroupStore) Create(group *ServerGroup) (*ServerGroup, error) {
 func (s *GroupStore) GetByID(id string) (*ServerGroup, error) {
 	group := &ServerGroup{}
 	query := `
-		SELECT id, name, description, color, created_at, updated_at
+		SELECT id, name, description, color, credential_id, created_at, updated_at
 		FROM server_groups
 		WHERE id = $1
 	`
 
-	err := s.db.QueryRow(query, id).Scan(&group.ID, &group.Name, &group.Description, &group.Color, &group.CreatedAt, &group.UpdatedAt)
+	err := s.db.QueryRow(query, id).Scan(&group.ID, &group.Name, &group.Description, &group.Color, &group.CredentialID, &group.CreatedAt, &group.UpdatedAt)
 	return group, err
 }
 
 func (s *GroupStore) List() ([]*ServerGroup, error) {
 	query := `
-		SELECT id, name, description, color, created_at, updated_at
+		SELECT id, name, description, color, credential_id, created_at, updated_at
 		FROM server_groups
 		ORDER BY name
 	`
@@ -60,7 +60,7 @@ func (s *GroupStore) List() ([]*ServerGroup, error) {
 	var groups []*ServerGroup
 	for rows.Next() {
 		group := &ServerGroup{}
-		err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.Color, &group.CreatedAt, &group.UpdatedAt)
+		err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.Color, &group.CredentialID, &group.CreatedAt, &group.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -75,11 +75,11 @@ func (s *GroupStore) Update(id string, group *ServerGroup) error {
 
 	query := `
 		UPDATE server_groups
-		SET name = $2, description = $3, color = $4, updated_at = $5
+		SET name = $2, description = $3, color = $4, credential_id = $5, updated_at = $6
 		WHERE id = $1
 	`
 
-	_, err := s.db.Exec(query, id, group.Name, group.Description, group.Color, group.UpdatedAt)
+	_, err := s.db.Exec(query, id, group.Name, group.Description, group.Color, group.CredentialID, group.UpdatedAt)
 	return err
 }
 
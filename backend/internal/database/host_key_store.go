@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Host key verification modes of a server.
+const (
+	// HostKeyModeTOFU trusts the first key a server presents.
+	HostKeyModeTOFU = "tofu"
+	// HostKeyModeStrict only accepts keys approved by an admin or imported.
+	HostKeyModeStrict = "strict"
+)
+
+// Host key statuses.
+const (
+	HostKeyTrusted  = "trusted"
+	HostKeyPending  = "pending"
+	HostKeyRejected = "rejected"
+)
+
+// Host key sources.
+const (
+	HostKeySourceTOFU     = "tofu"
+	HostKeySourceImport   = "import"
+	HostKeySourceObserved = "observed"
+)
+
+// HostKey is an SSH host key known for a server. PublicKey is in
+// authorized_keys format.
+type HostKey struct {
+	ID          string     `json:"id"`
+	ServerID    string     `json:"server_id"`
+	KeyType     string     `json:"key_type"`
+	PublicKey   string     `json:"public_key"`
+	Fingerprint string     `json:"fingerprint"`
+	Status      string     `json:"status"`
+	Source      string     `json:"source"`
+	Changed     bool       `json:"changed"`
+	FirstSeenAt *time.Time `json:"first_seen_at"`
+	LastSeenAt  *time.Time `json:"last_seen_at"`
+	DecidedBy   *string    `json:"decided_by"`
+	DecidedAt   *time.Time `json:"decided_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+const hostKeyColumns = `id, server_id, key_type, public_key, fingerprint, status, source, changed,
+	first_seen_at, last_seen_at, decided_by, decided_at, created_at`
+
+type HostKeyStore struct {
+	db *sql.DB
+}
+
+func NewHostKeyStore(db *sql.DB) *HostKeyStore {
+	return &HostKeyStore{db: db}
+}
+
+func scanHostKey(row interface{ Scan(...interface{}) error }) (*HostKey, error) {
+	k := &HostKey{}
+	err := row.Scan(&k.ID, &k.ServerID, &k.KeyType, &k.PublicKey, &k.Fingerprint, &k.Status, &k.Source,
+		&k.Changed, &k.FirstSeenAt, &k.LastSeenAt, &k.DecidedBy, &k.DecidedAt, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (s *HostKeyStore) list(query string, args ...interface{}) ([]*HostKey, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*HostKey{}
+	for rows.Next() {
+		k, err := scanHostKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// ListForServer returns a server's keys, trusted ones first.
+func (s *HostKeyStore) ListForServer(serverID string) ([]*HostKey, error) {
+	return s.list(`SELECT `+hostKeyColumns+` FROM ssh_host_keys WHERE server_id = $1
+		ORDER BY status = 'trusted' DESC, created_at`, serverID)
+}
+
+// ListByStatus returns the keys with a status across all servers, newest first.
+func (s *HostKeyStore) ListByStatus(status string) ([]*HostKey, error) {
+	return s.list(`SELECT `+hostKeyColumns+` FROM ssh_host_keys WHERE status = $1 ORDER BY created_at DESC`, status)
+}
+
+// ListChanged returns pending keys that were presented instead of a trusted one.
+func (s *HostKeyStore) ListChanged() ([]*HostKey, error) {
+	return s.list(`SELECT ` + hostKeyColumns + ` FROM ssh_host_keys WHERE status = 'pending' AND changed ORDER BY created_at`)
+}
+
+func (s *HostKeyStore) Get(id string) (*HostKey, error) {
+	return scanHostKey(s.db.QueryRow(`SELECT `+hostKeyColumns+` FROM ssh_host_keys WHERE id = $1`, id))
+}
+
+// Trust stores a key as trusted, or marks an existing one trusted. decidedBy
+// is empty when the key is trusted on first use.
+func (s *HostKeyStore) Trust(k *HostKey, decidedBy string, at time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO ssh_host_keys (id, server_id, key_type, public_key, fingerprint, status, source,
+			first_seen_at, last_seen_at, decided_by, decided_at)
+		VALUES ($1, $2, $3, $4, $5, 'trusted', $6, $7, $7, $8, $9)
+		ON CONFLICT (server_id, fingerprint) DO UPDATE
+		SET status = 'trusted', changed = FALSE, decided_by = EXCLUDED.decided_by, decided_at = EXCLUDED.decided_at
+	`, uuid.New().String(), k.ServerID, k.KeyType, k.PublicKey, k.Fingerprint, k.Source,
+		k.FirstSeenAt, nullString(decidedBy), at)
+	return err
+}
+
+// RecordPending stores a key the server presented but that is not trusted.
+// It reports whether the key was seen for the first time.
+func (s *HostKeyStore) RecordPending(k *HostKey, at time.Time) (bool, error) {
+	var inserted bool
+	err := s.db.QueryRow(`
+		INSERT INTO ssh_host_keys (id, server_id, key_type, public_key, fingerprint, status, source, changed,
+			first_seen_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, 'pending', 'observed', $6, $7, $7)
+		ON CONFLICT (server_id, fingerprint) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at
+		RETURNING (xmax = 0)
+	`, uuid.New().String(), k.ServerID, k.KeyType, k.PublicKey, k.Fingerprint, k.Changed, at).Scan(&inserted)
+	return inserted, err
+}
+
+// Touch updates when a key was last presented.
+func (s *HostKeyStore) Touch(id string, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE ssh_host_keys SET last_seen_at = $2 WHERE id = $1`, id, at)
+	return err
+}
+
+// Approve trusts a key. With replace, the server's other trusted keys of the
+// same type are removed, as after a legitimate key rotation.
+func (s *HostKeyStore) Approve(id, decidedBy string, replace bool, at time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var serverID, keyType string
+	err = tx.QueryRow(`
+		UPDATE ssh_host_keys SET status = 'trusted', changed = FALSE, decided_by = $2, decided_at = $3
+		WHERE id = $1
+		RETURNING server_id, key_type
+	`, id, decidedBy, at).Scan(&serverID, &keyType)
+	if err != nil {
+		return err
+	}
+	if replace {
+		_, err = tx.Exec(`DELETE FROM ssh_host_keys WHERE server_id = $1 AND key_type = $2 AND status = 'trusted' AND id <> $3`,
+			serverID, keyType, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Reject marks a key as rejected so connections presenting it keep failing
+// without raising new alerts.
+func (s *HostKeyStore) Reject(id, decidedBy string, at time.Time) error {
+	res, err := s.db.Exec(`
+		UPDATE ssh_host_keys SET status = 'rejected', changed = FALSE, decided_by = $2, decided_at = $3
+		WHERE id = $1
+	`, id, decidedBy, at)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *HostKeyStore) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM ssh_host_keys WHERE id = $1`, id)
+	return err
+}
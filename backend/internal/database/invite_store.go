@@ -0,0 +1,170 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Invite asks someone to sign up with the given roles and access to the
+// given servers.
+type Invite struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	Roles      []string   `json:"roles"`
+	ServerIDs  []string   `json:"server_ids"`
+	InvitedBy  *string    `json:"invited_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	AcceptedBy *string    `json:"accepted_by"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// Pending reports whether the invite can still be accepted at now.
+func (i *Invite) Pending(now time.Time) bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && now.Before(i.ExpiresAt)
+}
+
+const inviteColumns = `id, email, roles, server_ids, invited_by, created_at, expires_at, accepted_at, accepted_by, revoked_at`
+
+type InviteStore struct {
+	db *sql.DB
+}
+
+func NewInviteStore(db *sql.DB) *InviteStore {
+	return &InviteStore{db: db}
+}
+
+func scanInvite(row interface{ Scan(...interface{}) error }) (*Invite, error) {
+	i := &Invite{}
+	err := row.Scan(&i.ID, &i.Email, pq.Array(&i.Roles), pq.Array(&i.ServerIDs), &i.InvitedBy, &i.CreatedAt,
+		&i.ExpiresAt, &i.AcceptedAt, &i.AcceptedBy, &i.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if i.Roles == nil {
+		i.Roles = []string{}
+	}
+	if i.ServerIDs == nil {
+		i.ServerIDs = []string{}
+	}
+	return i, nil
+}
+
+// Create stores an invite, assigning its ID.
+func (s *InviteStore) Create(i *Invite) error {
+	i.ID = uuid.New().String()
+	i.CreatedAt = time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO user_invites (id, email, roles, server_ids, invited_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, i.ID, i.Email, pq.Array(i.Roles), pq.Array(i.ServerIDs), i.InvitedBy, i.CreatedAt, i.ExpiresAt)
+	return err
+}
+
+// Get returns an invite, or sql.ErrNoRows.
+func (s *InviteStore) Get(id string) (*Invite, error) {
+	return scanInvite(s.db.QueryRow(`SELECT `+inviteColumns+` FROM user_invites WHERE id = $1`, id))
+}
+
+// List returns all invites, newest first.
+func (s *InviteStore) List() ([]*Invite, error) {
+	rows, err := s.db.Query(`SELECT ` + inviteColumns + ` FROM user_invites ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*Invite{}
+	for rows.Next() {
+		i, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, i)
+	}
+	return invites, rows.Err()
+}
+
+// Revoke withdraws a pending invite, reporting whether it was pending.
+func (s *InviteStore) Revoke(id string) (bool, error) {
+	now := time.Now()
+	res, err := s.db.Exec(`
+		UPDATE user_invites SET revoked_at = $2
+		WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $2
+	`, id, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Accept creates the approved local user of a pending invite with its
+// roles and server permissions, and marks the invite accepted. Servers
+// deleted since the invite was made are skipped. It returns sql.ErrNoRows
+// if the invite is not pending.
+func (s *InviteStore) Accept(id, username, password string) (*User, error) {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	inv, err := scanInvite(tx.QueryRow(`
+		SELECT `+inviteColumns+` FROM user_invites
+		WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $2
+		FOR UPDATE
+	`, id, now))
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		ID:           uuid.New().String(),
+		Username:     username,
+		Email:        inv.Email,
+		Approved:     true,
+		AuthProvider: AuthProviderLocal,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	_, err = tx.Exec(`
+		INSERT INTO users (id, username, email, password, approved, auth_provider, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, user.ID, user.Username, user.Email, hashedPassword, user.Approved, user.AuthProvider, now, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range inv.Roles {
+		_, err := tx.Exec(`INSERT INTO user_roles (id, user_id, role, created_at) VALUES ($1, $2, $3, $4)`,
+			uuid.New().String(), user.ID, role, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, serverID := range inv.ServerIDs {
+		_, err := tx.Exec(`
+			INSERT INTO user_server_permissions (id, user_id, server_id, created_at)
+			SELECT $1, $2, id, $4 FROM servers WHERE id = $3
+		`, uuid.New().String(), user.ID, serverID, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`
+		UPDATE user_invites SET accepted_at = $2, accepted_by = $3 WHERE id = $1
+	`, id, now, user.ID); err != nil {
+		return nil, err
+	}
+	return user, tx.Commit()
+}
@@ -0,0 +1,147 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/database/dbtest"
+)
+
+func TestInviteAccept(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		revoke    bool
+		accepted  bool
+		wantErr   error
+	}{
+		{name: "pending invite", expiresIn: time.Hour},
+		{name: "already accepted", expiresIn: time.Hour, accepted: true, wantErr: sql.ErrNoRows},
+		{name: "revoked", expiresIn: time.Hour, revoke: true, wantErr: sql.ErrNoRows},
+		{name: "expired", expiresIn: -time.Minute, wantErr: sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := openInviteStores(t)
+			kept, err := stores.Servers.Create(&database.Server{Hostname: "web-1", IPAddress: "10.0.0.1", SSHPort: 22, Status: "unknown"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gone, err := stores.Servers.Create(&database.Server{Hostname: "web-2", IPAddress: "10.0.0.2", SSHPort: 22, Status: "unknown"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			inv := &database.Invite{
+				Email:     "alice@example.org",
+				Roles:     []string{"admin", "user"},
+				ServerIDs: []string{kept.ID, gone.ID},
+				ExpiresAt: time.Now().Add(tt.expiresIn),
+			}
+			if err := stores.Invites.Create(inv); err != nil {
+				t.Fatal(err)
+			}
+			if err := stores.Servers.Delete(gone.ID); err != nil {
+				t.Fatal(err)
+			}
+			if tt.revoke {
+				if _, err := stores.Invites.Revoke(inv.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.accepted {
+				if _, err := stores.Invites.Accept(inv.ID, "first", "first-password"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			user, err := stores.Invites.Accept(inv.ID, "alice", "correct horse battery")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Accept() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, err := stores.Users.GetByEmail(inv.Email); !tt.accepted && !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("user created for an invite that is not pending: %v", err)
+				}
+				return
+			}
+
+			if user.Email != inv.Email || !user.Approved || user.AuthProvider != database.AuthProviderLocal {
+				t.Errorf("Accept() = %+v, want an approved local user with the invited email", user)
+			}
+			roles, err := stores.Users.GetRoles(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(roles)
+			if !reflect.DeepEqual(roles, inv.Roles) {
+				t.Errorf("roles = %v, want %v", roles, inv.Roles)
+			}
+			if ok, err := stores.Permissions.HasAccess(user.ID, kept.ID); err != nil || !ok {
+				t.Errorf("HasAccess(%s) = %v, %v, want true", kept.Hostname, ok, err)
+			}
+			got, err := stores.Invites.Get(inv.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.AcceptedAt == nil || got.AcceptedBy == nil || *got.AcceptedBy != user.ID || got.Pending(time.Now()) {
+				t.Errorf("invite after Accept() = %+v, want accepted by %s", got, user.ID)
+			}
+
+			if _, err := stores.Invites.Accept(inv.ID, "alice2", "correct horse battery"); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("second Accept() error = %v, want %v", err, sql.ErrNoRows)
+			}
+		})
+	}
+}
+
+func TestInviteAcceptConcurrent(t *testing.T) {
+	stores := openInviteStores(t)
+	inv := &database.Invite{Email: "bob@example.org", Roles: []string{"user"}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := stores.Invites.Create(inv); err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 5
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = stores.Invites.Accept(inv.ID, "bob", "correct horse battery")
+		}(i)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			accepted++
+		case !errors.Is(err, sql.ErrNoRows):
+			t.Errorf("Accept() error = %v, want nil or %v", err, sql.ErrNoRows)
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("%d of %d concurrent Accept() calls succeeded, want 1", accepted, attempts)
+	}
+}
+
+// openInviteStores returns the stores the invite tests touch, backed by a
+// fresh test schema.
+func openInviteStores(t *testing.T) *database.Stores {
+	db := dbtest.Open(t)
+	return &database.Stores{
+		Users:       database.NewUserStore(db),
+		Servers:     database.NewServerStore(db),
+		Permissions: database.NewPermissionStore(db),
+		Invites:     database.NewInviteStore(db),
+	}
+}
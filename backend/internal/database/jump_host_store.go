@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// JumpHostStore keeps the jump host chains of servers and groups as ordered
+// lists of server IDs.
+type JumpHostStore struct {
+	db *sql.DB
+}
+
+func NewJumpHostStore(db *sql.DB) *JumpHostStore {
+	return &JumpHostStore{db: db}
+}
+
+func (s *JumpHostStore) ids(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ForServer returns the server's own chain, without its group's.
+func (s *JumpHostStore) ForServer(serverID string) ([]string, error) {
+	return s.ids(`SELECT jump_server_id FROM ssh_jump_hosts WHERE server_id = $1 ORDER BY position`, serverID)
+}
+
+func (s *JumpHostStore) ForGroup(groupID string) ([]string, error) {
+	return s.ids(`SELECT jump_server_id FROM ssh_jump_hosts WHERE group_id = $1 ORDER BY position`, groupID)
+}
+
+// Chain returns the chain used to reach a server: its own, else its group's.
+func (s *JumpHostStore) Chain(serverID string) ([]string, error) {
+	return s.ids(`
+		SELECT j.jump_server_id
+		FROM ssh_jump_hosts j
+		JOIN servers s ON j.server_id = s.id OR (j.group_id = s.group_id AND NOT EXISTS (
+			SELECT 1 FROM ssh_jump_hosts own WHERE own.server_id = s.id))
+		WHERE s.id = $1
+		ORDER BY j.position
+	`, serverID)
+}
+
+// UsedBy returns the servers and groups whose chain goes through a server.
+func (s *JumpHostStore) UsedBy(jumpServerID string) (serverIDs, groupIDs []string, err error) {
+	serverIDs, err = s.ids(`SELECT DISTINCT server_id FROM ssh_jump_hosts WHERE jump_server_id = $1 AND server_id IS NOT NULL`, jumpServerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	groupIDs, err = s.ids(`SELECT DISTINCT group_id FROM ssh_jump_hosts WHERE jump_server_id = $1 AND group_id IS NOT NULL`, jumpServerID)
+	return serverIDs, groupIDs, err
+}
+
+// SetForServer replaces a server's chain; an empty chain falls back to the
+// group's.
+func (s *JumpHostStore) SetForServer(serverID string, jumpServerIDs []string) error {
+	return s.set("server_id", serverID, jumpServerIDs)
+}
+
+func (s *JumpHostStore) SetForGroup(groupID string, jumpServerIDs []string) error {
+	return s.set("group_id", groupID, jumpServerIDs)
+}
+
+// set replaces the chain owned by column = id.
+func (s *JumpHostStore) set(column, id string, jumpServerIDs []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM ssh_jump_hosts WHERE `+column+` = $1`, id); err != nil {
+		return err
+	}
+	for i, jumpID := range jumpServerIDs {
+		_, err := tx.Exec(`INSERT INTO ssh_jump_hosts (`+column+`, position, jump_server_id) VALUES ($1, $2, $3)`,
+			id, i, jumpID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Lockout keeps logins from an address or for a login name out until
+// LockedUntil. Scope is ip or account.
+type Lockout struct {
+	Scope       string    `json:"scope"`
+	Subject     string    `json:"subject"`
+	Failures    int       `json:"failures"`
+	LockedAt    time.Time `json:"locked_at"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// AttemptCount summarizes the attempts of one kind within a window.
+type AttemptCount struct {
+	Count int
+	// First and Last are zero when Count is.
+	First time.Time
+	Last  time.Time
+}
+
+// LoginAttemptStore counts login and sign-up attempts and holds lockouts
+// so that throttling is shared between replicas.
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptStore(db *sql.DB) *LoginAttemptStore {
+	return &LoginAttemptStore{db: db}
+}
+
+func (s *LoginAttemptStore) AddAttempt(scope, subject, kind string, at time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO auth_attempts (scope, subject, kind, attempted_at) VALUES ($1, $2, $3, $4)
+	`, scope, subject, kind, at)
+	return err
+}
+
+// CountAttempts counts the attempts of kind made since since.
+func (s *LoginAttemptStore) CountAttempts(scope, subject, kind string, since time.Time) (AttemptCount, error) {
+	var c AttemptCount
+	var first, last sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), MIN(attempted_at), MAX(attempted_at) FROM auth_attempts
+		WHERE scope = $1 AND subject = $2 AND kind = $3 AND attempted_at >= $4
+	`, scope, subject, kind, since).Scan(&c.Count, &first, &last)
+	c.First, c.Last = first.Time, last.Time
+	return c, err
+}
+
+func (s *LoginAttemptStore) ClearAttempts(scope, subject, kind string) error {
+	_, err := s.db.Exec(`
+		DELETE FROM auth_attempts WHERE scope = $1 AND subject = $2 AND kind = $3
+	`, scope, subject, kind)
+	return err
+}
+
+// Lock stores a lockout, replacing an earlier one of the same subject.
+func (s *LoginAttemptStore) Lock(l *Lockout) error {
+	_, err := s.db.Exec(`
+		INSERT INTO auth_lockouts (scope, subject, failures, locked_at, locked_until) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scope, subject) DO UPDATE
+		SET failures = EXCLUDED.failures, locked_at = EXCLUDED.locked_at, locked_until = EXCLUDED.locked_until
+	`, l.Scope, l.Subject, l.Failures, l.LockedAt, l.LockedUntil)
+	return err
+}
+
+// LockedUntil returns when the subject's lockout ends, or nil if it is not
+// locked out at now.
+func (s *LoginAttemptStore) LockedUntil(scope, subject string, now time.Time) (*time.Time, error) {
+	var until time.Time
+	err := s.db.QueryRow(`
+		SELECT locked_until FROM auth_lockouts WHERE scope = $1 AND subject = $2 AND locked_until > $3
+	`, scope, subject, now).Scan(&until)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &until, nil
+}
+
+// Unlock lifts the subject's lockout and forgets its failed attempts,
+// reporting whether it was locked out.
+func (s *LoginAttemptStore) Unlock(scope, subject string, now time.Time) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		DELETE FROM auth_lockouts WHERE scope = $1 AND subject = $2 AND locked_until > $3
+	`, scope, subject, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM auth_attempts WHERE scope = $1 AND subject = $2 AND kind = 'failure'
+	`, scope, subject); err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+// ListLockouts returns the lockouts in force at now, ending soonest first.
+func (s *LoginAttemptStore) ListLockouts(now time.Time) ([]*Lockout, error) {
+	rows, err := s.db.Query(`
+		SELECT scope, subject, failures, locked_at, locked_until FROM auth_lockouts
+		WHERE locked_until > $1 ORDER BY locked_until
+	`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []*Lockout{}
+	for rows.Next() {
+		l := &Lockout{}
+		if err := rows.Scan(&l.Scope, &l.Subject, &l.Failures, &l.LockedAt, &l.LockedUntil); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, l)
+	}
+	return lockouts, rows.Err()
+}
+
+// Prune deletes attempts made and lockouts ended before before.
+func (s *LoginAttemptStore) Prune(before time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM auth_attempts WHERE attempted_at < $1`, before); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM auth_lockouts WHERE locked_until < $1`, before)
+	return err
+}
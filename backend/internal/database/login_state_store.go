@@ -0,0 +1,53 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LoginState is a pending OIDC authorization code login.
+type LoginState struct {
+	Nonce        string
+	CodeVerifier string
+	RedirectTo   *string
+	ExpiresAt    time.Time
+}
+
+// LoginStateStore keeps pending OIDC logins keyed by the hash of their state
+// parameter.
+type LoginStateStore struct {
+	db *sql.DB
+}
+
+func NewLoginStateStore(db *sql.DB) *LoginStateStore {
+	return &LoginStateStore{db: db}
+}
+
+// Create stores a pending login and drops expired ones.
+func (s *LoginStateStore) Create(stateHash string, st *LoginState) error {
+	if _, err := s.db.Exec(`DELETE FROM oidc_login_states WHERE expires_at < $1`, time.Now()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, redirect_to, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, stateHash, st.Nonce, st.CodeVerifier, st.RedirectTo, st.ExpiresAt)
+	return err
+}
+
+// Consume removes and returns the pending login for stateHash. It returns
+// sql.ErrNoRows if there is none or it has expired.
+func (s *LoginStateStore) Consume(stateHash string) (*LoginState, error) {
+	st := &LoginState{}
+	err := s.db.QueryRow(`
+		DELETE FROM oidc_login_states WHERE state_hash = $1
+		RETURNING nonce, code_verifier, redirect_to, expires_at
+	`, stateHash).Scan(&st.Nonce, &st.CodeVerifier, &st.RedirectTo, &st.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(st.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	return st, nil
+}
@@ -0,0 +1,49 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/database/dbtest"
+)
+
+func TestLoginStateConsume(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		consume   string
+		wantErr   error
+	}{
+		{"pending login", time.Minute, "state-hash", nil},
+		{"unknown state", time.Minute, "other-hash", sql.ErrNoRows},
+		{"expired login", -time.Minute, "state-hash", sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewLoginStateStore(dbtest.Open(t))
+			st := &database.LoginState{Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: time.Now().Add(tt.expiresIn)}
+			if err := store.Create("state-hash", st); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := store.Consume(tt.consume)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Consume() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (got.Nonce != st.Nonce || got.CodeVerifier != st.CodeVerifier) {
+				t.Errorf("Consume() = %+v, want %+v", got, st)
+			}
+
+			// A state can only be used once, whatever the first attempt returned
+			if tt.consume == "state-hash" {
+				if _, err := store.Consume("state-hash"); !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("second Consume() error = %v, want %v", err, sql.ErrNoRows)
+				}
+			}
+		})
+	}
+}
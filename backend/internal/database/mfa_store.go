@@ -0,0 +1,238 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// TOTP is a user's authenticator app enrollment.
+type TOTP struct {
+	UserID          string
+	EncryptedSecret []byte
+	// EnabledAt is nil while enrollment is unconfirmed.
+	EnabledAt      *time.Time
+	LastUsedStep   int64
+	FailedAttempts int
+	LockedUntil    *time.Time
+	CreatedAt      time.Time
+}
+
+type MFAStore struct {
+	db *sql.DB
+}
+
+func NewMFAStore(db *sql.DB) *MFAStore {
+	return &MFAStore{db: db}
+}
+
+// GetTOTP returns the user's TOTP enrollment, or sql.ErrNoRows.
+func (s *MFAStore) GetTOTP(userID string) (*TOTP, error) {
+	t := &TOTP{}
+	err := s.db.QueryRow(`
+		SELECT user_id, encrypted_secret, enabled_at, last_used_step, failed_attempts, locked_until, created_at
+		FROM user_totp WHERE user_id = $1
+	`, userID).Scan(&t.UserID, &t.EncryptedSecret, &t.EnabledAt, &t.LastUsedStep, &t.FailedAttempts, &t.LockedUntil, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// StartTOTP stores a new unconfirmed secret, replacing an earlier
+// unconfirmed one. It reports false if TOTP is already enabled.
+func (s *MFAStore) StartTOTP(userID string, encryptedSecret []byte) (bool, error) {
+	res, err := s.db.Exec(`
+		INSERT INTO user_totp (user_id, encrypted_secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET encrypted_secret = EXCLUDED.encrypted_secret, created_at = EXCLUDED.created_at,
+			last_used_step = 0, failed_attempts = 0, locked_until = NULL
+		WHERE user_totp.enabled_at IS NULL
+	`, userID, encryptedSecret, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EnableTOTP confirms enrollment with the code of step and replaces the
+// user's recovery codes.
+func (s *MFAStore) EnableTOTP(userID string, step int64, recoveryHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE user_totp SET enabled_at = $2, last_used_step = $3, failed_attempts = 0
+		WHERE user_id = $1 AND enabled_at IS NULL
+	`, userID, time.Now(), step)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code of step was used, reporting false if
+// it or a later one was used before.
+func (s *MFAStore) UseTOTPStep(userID string, step int64) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE user_totp SET last_used_step = $2, failed_attempts = 0, locked_until = NULL
+		WHERE user_id = $1 AND last_used_step < $2
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RecordFailure counts a wrong code. After maxAttempts in a row the user's
+// second factor is locked until lockUntil.
+func (s *MFAStore) RecordFailure(userID string, maxAttempts int, lockUntil time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE user_totp
+		SET failed_attempts = CASE WHEN failed_attempts + 1 >= $2 THEN 0 ELSE failed_attempts + 1 END,
+			locked_until = CASE WHEN failed_attempts + 1 >= $2 THEN $3 ELSE locked_until END
+		WHERE user_id = $1
+	`, userID, maxAttempts, lockUntil)
+	return err
+}
+
+// DeleteTOTP removes the user's TOTP enrollment and recovery codes,
+// reporting whether there was an enrollment.
+func (s *MFAStore) DeleteTOTP(userID string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+// ReplaceRecoveryCodes discards the user's recovery codes for new ones.
+func (s *MFAStore) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID string, hashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err := tx.Exec(`
+			INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at) VALUES ($1, $2, $3, $4)
+		`, uuid.New().String(), userID, hash, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code hashing to hash as used,
+// reporting false if the user has no such unused code.
+func (s *MFAStore) UseRecoveryCode(userID, hash string) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE mfa_recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hash, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RecoveryCodesLeft counts the user's unused recovery codes.
+func (s *MFAStore) RecoveryCodesLeft(userID string) (int, error) {
+	var n int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`, userID).Scan(&n)
+	return n, err
+}
+
+// RequiredRoles returns the roles whose members must use a second factor.
+func (s *MFAStore) RequiredRoles() ([]string, error) {
+	rows, err := s.db.Query(`SELECT role::text FROM mfa_required_roles ORDER BY role`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (s *MFAStore) SetRequiredRoles(roles []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM mfa_required_roles`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO mfa_required_roles (role, created_at)
+		SELECT DISTINCT r::app_role, $2 FROM unnest($1::text[]) AS r
+	`, pq.Array(roles), time.Now())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsRequired reports whether one of the user's roles requires a second
+// factor.
+func (s *MFAStore) IsRequired(userID string) (bool, error) {
+	var required bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM user_roles ur JOIN mfa_required_roles m ON m.role = ur.role
+			WHERE ur.user_id = $1
+		)
+	`, userID).Scan(&required)
+	return required, err
+}
@@ -1,18 +1,23 @@
 package database
 
 import (
+	"encoding/json"
 	"time"
 )
 
 type User struct {
-	ID          string    `json:"id"`
-	Username    string    `json:"username"`
-	Email       string    `json:"email"`
-	Password    string    `json:"-"`
-	DisplayName *string   `json:"display_name"`
-	Approved    bool      `json:"approved"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          string  `json:"id"`
+	Username    string  `json:"username"`
+	Email       string  `json:"email"`
+	Password    string  `json:"-"`
+	DisplayName *string `json:"display_name"`
+	Approved    bool    `json:"approved"`
+	// AuthProvider is local for password logins, else the identity
+	// provider the user signs in with.
+	AuthProvider string    `json:"auth_provider"`
+	ExternalID   *string   `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 type UserRole struct {
@@ -33,17 +38,77 @@ type Server struct {
 	Status        string     `json:"status"`
 	GroupID       *string    `json:"group_id"`
 	Tags          []string   `json:"tags"`
+	CredentialID  *string    `json:"credential_id"`
+	HostKeyMode   *string    `json:"host_key_mode"`
+	LastCheckedAt *time.Time `json:"last_checked_at"`
+	LastSeenAt    *time.Time `json:"last_seen_at"`
+	LatencyMs     *float64   `json:"latency_ms"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
+type ServerStatusEvent struct {
+	ID             string    `json:"id"`
+	ServerID       string    `json:"server_id"`
+	PreviousStatus *string   `json:"previous_status"`
+	Status         string    `json:"status"`
+	LatencyMs      *float64  `json:"latency_ms"`
+	Error          *string   `json:"error"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ProbeResult is a single raw probe outcome. PortID is nil for the
+// server-level result.
+type ProbeResult struct {
+	ServerID  string    `json:"server_id"`
+	PortID    *string   `json:"port_id"`
+	CheckedAt time.Time `json:"checked_at"`
+	State     string    `json:"state"`
+	Up        bool      `json:"up"`
+	LatencyMs *float64  `json:"latency_ms"`
+	Error     *string   `json:"error"`
+}
+
+// ProbeSample is a point in a probe history. Raw results have Total 1; hourly
+// rollups carry the sample counts for the whole bucket.
+type ProbeSample struct {
+	At        time.Time
+	Span      time.Duration
+	Total     int
+	Up        int
+	LatencyMs *float64
+}
+
+type ServerPort struct {
+	ID            string          `json:"id"`
+	ServerID      string          `json:"server_id"`
+	Port          int             `json:"port"`
+	Transport     string          `json:"transport"`
+	Protocol      *string         `json:"protocol"`
+	Description   *string         `json:"description"`
+	ExpectedState string          `json:"expected_state"`
+	Enabled       bool            `json:"enabled"`
+	CheckType     string          `json:"check_type"`
+	CheckConfig   json.RawMessage `json:"check_config"`
+	LastState     *string         `json:"last_state"`
+	LastError     *string         `json:"last_error"`
+	LastCheckedAt *time.Time      `json:"last_checked_at"`
+	LatencyMs     *float64        `json:"latency_ms"`
+	Drift         bool            `json:"drift"`
+	DriftSince    *time.Time      `json:"drift_since"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
 type ServerGroup struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description *string   `json:"description"`
-	Color       string    `json:"color"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Color       string  `json:"color"`
+	// CredentialID is the SSH credential of servers without their own.
+	CredentialID *string   `json:"credential_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 type UserServerPermission struct {
@@ -54,12 +119,32 @@ type UserServerPermission struct {
 }
 
 type Stores struct {
-	Users       *UserStore
-	Servers     *ServerStore
-	Groups      *GroupStore
-	Permissions *PermissionStore
-	SSL         *SSLStore
-    APIKeys     *APIKeyStore
+	Users          *UserStore
+	Servers        *ServerStore
+	Groups         *GroupStore
+	Permissions    *PermissionStore
+	SSL            *SSLStore
+	Ports          *PortStore
+	Probes         *ProbeResultStore
+	Renewals       *RenewalStore
+	Alerts         *AlertStore
+	Policies       *PolicyStore
+	Notifications  *NotificationStore
+	Credentials    *CredentialStore
+	HostKeys       *HostKeyStore
+	Audit          *AuditStore
+	Recordings     *RecordingStore
+	JumpHosts      *JumpHostStore
+	CommandJobs    *CommandJobStore
+	Sessions       *SessionStore
+	GroupMappings  *GroupMappingStore
+	LoginStates    *LoginStateStore
+	MFA            *MFAStore
+	WebAuthn       *WebAuthnStore
+	LoginAttempts  *LoginAttemptStore
+	Invites        *InviteStore
+	PasswordResets *PasswordResetStore
+	APIKeys        *APIKeyStore
 }
 
 type APIKey struct {
@@ -0,0 +1,310 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Notification channel types.
+const (
+	ChannelSMTP    = "smtp"
+	ChannelWebhook = "webhook"
+	ChannelSlack   = "slack"
+	ChannelTeams   = "teams"
+)
+
+// Delivery states.
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+)
+
+// NotificationChannel is a destination for alert notifications. Secrets are
+// sealed and never serialised.
+type NotificationChannel struct {
+	ID               string               `json:"id"`
+	Name             string               `json:"name"`
+	Type             string               `json:"type"`
+	Enabled          bool                 `json:"enabled"`
+	Settings         NotificationSettings `json:"settings"`
+	EncryptedSecrets []byte               `json:"-"`
+	HasSecrets       bool                 `json:"has_secrets"`
+	// Routing filters; an empty list matches every alert.
+	Severities    []string  `json:"severities"`
+	GroupIDs      []string  `json:"group_ids"`
+	Tags          []string  `json:"tags"`
+	SendResolved  bool      `json:"send_resolved"`
+	TitleTemplate *string   `json:"title_template"`
+	BodyTemplate  *string   `json:"body_template"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// NotificationSettings is the non-secret configuration of a channel; which
+// fields apply depends on the channel type.
+type NotificationSettings struct {
+	// smtp
+	SMTPHost     string   `json:"smtp_host,omitempty"`
+	SMTPPort     int      `json:"smtp_port,omitempty"`
+	SMTPUsername string   `json:"smtp_username,omitempty"`
+	SMTPTLS      string   `json:"smtp_tls,omitempty"` // "starttls" (default), "tls" or "none"
+	From         string   `json:"from,omitempty"`
+	To           []string `json:"to,omitempty"`
+	// webhook
+	URL string `json:"url,omitempty"`
+	// slack
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// NotificationDelivery is one notification of an alert to a channel.
+type NotificationDelivery struct {
+	ID               string     `json:"id"`
+	ChannelID        string     `json:"channel_id"`
+	AlertFingerprint string     `json:"alert_fingerprint"`
+	AlertStartsAt    time.Time  `json:"alert_starts_at"`
+	Alertname        string     `json:"alertname"`
+	Severity         string     `json:"severity"`
+	Status           string     `json:"status"`
+	Alert            AlertState `json:"alert"`
+	State            string     `json:"state"`
+	Attempts         int        `json:"attempts"`
+	NextAttemptAt    time.Time  `json:"next_attempt_at"`
+	LastError        *string    `json:"last_error"`
+	SentAt           *time.Time `json:"sent_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+type NotificationStore struct {
+	db *sql.DB
+}
+
+func NewNotificationStore(db *sql.DB) *NotificationStore {
+	return &NotificationStore{db: db}
+}
+
+const notificationChannelColumns = `id, name, type, enabled, settings, encrypted_secrets, severities, group_ids, tags,
+	send_resolved, title_template, body_template, created_at, updated_at`
+
+func scanNotificationChannel(row interface{ Scan(...interface{}) error }) (*NotificationChannel, error) {
+	c := &NotificationChannel{}
+	var settings []byte
+	err := row.Scan(&c.ID, &c.Name, &c.Type, &c.Enabled, &settings, &c.EncryptedSecrets, pq.Array(&c.Severities),
+		pq.Array(&c.GroupIDs), pq.Array(&c.Tags), &c.SendResolved, &c.TitleTemplate, &c.BodyTemplate,
+		&c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(settings, &c.Settings); err != nil {
+		return nil, err
+	}
+	c.HasSecrets = len(c.EncryptedSecrets) > 0
+	return c, nil
+}
+
+func (s *NotificationStore) ListChannels() ([]*NotificationChannel, error) {
+	rows, err := s.db.Query(`SELECT ` + notificationChannelColumns + ` FROM notification_channels ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []*NotificationChannel{}
+	for rows.Next() {
+		c, err := scanNotificationChannel(rows)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+func (s *NotificationStore) GetChannel(id string) (*NotificationChannel, error) {
+	return scanNotificationChannel(s.db.QueryRow(`SELECT `+notificationChannelColumns+` FROM notification_channels WHERE id = $1`, id))
+}
+
+// CreateChannel inserts a channel. The ID is generated by the caller when
+// secrets have to be sealed against it, otherwise here.
+func (s *NotificationStore) CreateChannel(c *NotificationChannel) (*NotificationChannel, error) {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	settings, err := json.Marshal(c.Settings)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO notification_channels (id, name, type, enabled, settings, encrypted_secrets, severities, group_ids,
+		    tags, send_resolved, title_template, body_template)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, c.ID, c.Name, c.Type, c.Enabled, string(settings), c.EncryptedSecrets, pq.Array(c.Severities),
+		pq.Array(c.GroupIDs), pq.Array(c.Tags), c.SendResolved, c.TitleTemplate, c.BodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetChannel(c.ID)
+}
+
+func (s *NotificationStore) UpdateChannel(c *NotificationChannel) error {
+	settings, err := json.Marshal(c.Settings)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		UPDATE notification_channels
+		SET name = $2, type = $3, enabled = $4, settings = $5, encrypted_secrets = $6, severities = $7,
+		    group_ids = $8, tags = $9, send_resolved = $10, title_template = $11, body_template = $12,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, c.ID, c.Name, c.Type, c.Enabled, string(settings), c.EncryptedSecrets, pq.Array(c.Severities),
+		pq.Array(c.GroupIDs), pq.Array(c.Tags), c.SendResolved, c.TitleTemplate, c.BodyTemplate)
+	return err
+}
+
+func (s *NotificationStore) DeleteChannel(id string) error {
+	_, err := s.db.Exec(`DELETE FROM notification_channels WHERE id = $1`, id)
+	return err
+}
+
+// Enqueue schedules a notification of an alert to a channel. It is a no-op
+// if the same notification was already queued, and resolved notifications are
+// only queued for channels that were notified of the alert firing.
+func (s *NotificationStore) Enqueue(channelID, status string, a *AlertState, now time.Time) (bool, error) {
+	alert, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	result, err := s.db.Exec(`
+		INSERT INTO notification_deliveries (id, channel_id, alert_fingerprint, alert_starts_at, alertname, severity,
+		    status, alert, next_attempt_at, created_at)
+		SELECT $1, $2, $3, $4::timestamp, $5, $6, $7, $8::jsonb, $9::timestamp, $9::timestamp
+		WHERE $10::boolean OR EXISTS (
+			SELECT 1 FROM notification_deliveries
+			WHERE channel_id = $2 AND alert_fingerprint = $3 AND alert_starts_at = $4 AND status = 'firing'
+		)
+		ON CONFLICT (channel_id, alert_fingerprint, status, alert_starts_at) DO NOTHING
+	`, uuid.New().String(), channelID, a.Fingerprint, a.StartsAt, a.Alertname, a.Severity, status, string(alert), now,
+		status == "firing")
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+const notificationDeliveryColumns = `id, channel_id, alert_fingerprint, alert_starts_at, alertname, severity, status, alert,
+	state, attempts, next_attempt_at, last_error, sent_at, created_at`
+
+func (s *NotificationStore) queryDeliveries(query string, args ...interface{}) ([]*NotificationDelivery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*NotificationDelivery{}
+	for rows.Next() {
+		d := &NotificationDelivery{}
+		var alert []byte
+		err := rows.Scan(&d.ID, &d.ChannelID, &d.AlertFingerprint, &d.AlertStartsAt, &d.Alertname, &d.Severity,
+			&d.Status, &alert, &d.State, &d.Attempts, &d.NextAttemptAt, &d.LastError, &d.SentAt, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(alert, &d.Alert); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ClaimDue returns up to limit pending deliveries that are due and pushes
+// their next attempt back by lease, so a concurrent worker skips them.
+func (s *NotificationStore) ClaimDue(now time.Time, lease time.Duration, limit int) ([]*NotificationDelivery, error) {
+	return s.queryDeliveries(`
+		UPDATE notification_deliveries SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM notification_deliveries
+			WHERE state = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+notificationDeliveryColumns, now, now.Add(lease), limit)
+}
+
+// RecordAttempt stores the outcome of a delivery attempt. A failed attempt is
+// retried at next, or marked failed for good when next is nil.
+func (s *NotificationStore) RecordAttempt(id string, at time.Time, sendErr error, next *time.Time) error {
+	if sendErr == nil {
+		_, err := s.db.Exec(`
+			UPDATE notification_deliveries
+			SET state = 'sent', attempts = attempts + 1, sent_at = $2, last_error = NULL
+			WHERE id = $1
+		`, id, at)
+		return err
+	}
+
+	state, nextAttempt := DeliveryFailed, at
+	if next != nil {
+		state, nextAttempt = DeliveryPending, *next
+	}
+	_, err := s.db.Exec(`
+		UPDATE notification_deliveries
+		SET state = $2, attempts = attempts + 1, next_attempt_at = $3, last_error = $4
+		WHERE id = $1
+	`, id, state, nextAttempt, sendErr.Error())
+	return err
+}
+
+// Retry requeues a delivery for immediate sending.
+func (s *NotificationStore) Retry(id string, now time.Time) error {
+	result, err := s.db.Exec(`
+		UPDATE notification_deliveries SET state = 'pending', next_attempt_at = $2
+		WHERE id = $1 AND state <> 'pending'
+	`, id, now)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListDeliveries returns the delivery log, newest first, optionally for one
+// channel or state.
+func (s *NotificationStore) ListDeliveries(channelID, state string, limit int) ([]*NotificationDelivery, error) {
+	query := `SELECT ` + notificationDeliveryColumns + ` FROM notification_deliveries WHERE TRUE`
+	var args []interface{}
+	if channelID != "" {
+		args = append(args, channelID)
+		query += fmt.Sprintf(" AND channel_id = $%d", len(args))
+	}
+	if state != "" {
+		args = append(args, state)
+		query += fmt.Sprintf(" AND state = $%d", len(args))
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args))
+	return s.queryDeliveries(query, args...)
+}
+
+// PruneDeliveries deletes finished deliveries created before cutoff, except
+// those of alerts still tracked, which would otherwise be notified again.
+func (s *NotificationStore) PruneDeliveries(cutoff time.Time) error {
+	_, err := s.db.Exec(`
+		DELETE FROM notification_deliveries d
+		WHERE d.state <> 'pending' AND d.created_at < $1
+		  AND NOT EXISTS (SELECT 1 FROM alerts a WHERE a.fingerprint = d.alert_fingerprint AND a.starts_at = d.alert_starts_at)
+	`, cutoff)
+	return err
+}
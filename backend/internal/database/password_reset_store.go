@@ -0,0 +1,88 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PasswordResetStore struct {
+	db *sql.DB
+}
+
+func NewPasswordResetStore(db *sql.DB) *PasswordResetStore {
+	return &PasswordResetStore{db: db}
+}
+
+// Create starts a password reset for a user, discarding their unused ones
+// and expired ones of everyone, and returns its ID. requestedBy is nil when
+// users ask for their own reset.
+func (s *PasswordResetStore) Create(userID string, requestedBy *string, expiresAt time.Time) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`
+		DELETE FROM password_resets WHERE (user_id = $1 AND used_at IS NULL) OR expires_at < $2
+	`, userID, now); err != nil {
+		return "", err
+	}
+	id := uuid.New().String()
+	if _, err := tx.Exec(`
+		INSERT INTO password_resets (id, user_id, requested_by, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)
+	`, id, userID, requestedBy, now, expiresAt); err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// UserID returns the user of an unused, unexpired reset, or sql.ErrNoRows.
+func (s *PasswordResetStore) UserID(id string) (string, error) {
+	var userID string
+	err := s.db.QueryRow(`
+		SELECT user_id FROM password_resets WHERE id = $1 AND used_at IS NULL AND expires_at > $2
+	`, id, time.Now()).Scan(&userID)
+	return userID, err
+}
+
+// Use sets the password of the reset's user and marks the reset used. It
+// returns sql.ErrNoRows if the reset was used or has expired.
+func (s *PasswordResetStore) Use(id, password string) (string, error) {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID string
+	err = tx.QueryRow(`
+		UPDATE password_resets SET used_at = $2
+		WHERE id = $1 AND used_at IS NULL AND expires_at > $2
+		RETURNING user_id
+	`, id, now).Scan(&userID)
+	if err != nil {
+		return "", err
+	}
+	res, err := tx.Exec(`
+		UPDATE users SET password = $2, updated_at = $3 WHERE id = $1 AND auth_provider = $4
+	`, userID, hashedPassword, now, AuthProviderLocal)
+	if err != nil {
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return "", err
+	} else if n == 0 {
+		return "", sql.ErrNoRows
+	}
+	return userID, tx.Commit()
+}
@@ -0,0 +1,142 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/database/dbtest"
+)
+
+func TestPasswordResetUse(t *testing.T) {
+	tests := []struct {
+		name       string
+		external   bool
+		expiresIn  time.Duration
+		used       bool
+		superseded bool
+		wantErr    error
+	}{
+		{name: "unused reset", expiresIn: time.Hour},
+		{name: "already used", expiresIn: time.Hour, used: true, wantErr: sql.ErrNoRows},
+		{name: "expired", expiresIn: -time.Minute, wantErr: sql.ErrNoRows},
+		{name: "replaced by a newer reset", expiresIn: time.Hour, superseded: true, wantErr: sql.ErrNoRows},
+		{name: "user signs in through a provider", external: true, expiresIn: time.Hour, wantErr: sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := openResetStores(t)
+			var user *database.User
+			var err error
+			if tt.external {
+				user, err = stores.Users.CreateExternal(database.AuthProviderLDAP, "alice", "alice", "alice@example.org", nil, true)
+			} else {
+				user, err = stores.Users.Create("alice", "alice@example.org", "old-password")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := stores.PasswordResets.Create(user.ID, nil, time.Now().Add(tt.expiresIn))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.used {
+				if _, err := stores.PasswordResets.Use(id, "first-new-password"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.superseded {
+				if _, err := stores.PasswordResets.Create(user.ID, nil, time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			userID, err := stores.PasswordResets.Use(id, "new-password")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Use() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !tt.external && !tt.used && !passwordIs(t, stores, user.Email, "old-password") {
+					t.Error("password changed by a reset that is not usable")
+				}
+				return
+			}
+
+			if userID != user.ID {
+				t.Errorf("Use() = %s, want %s", userID, user.ID)
+			}
+			if !passwordIs(t, stores, user.Email, "new-password") {
+				t.Error("password not changed by Use()")
+			}
+			if _, err := stores.PasswordResets.UserID(id); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("UserID() of a used reset error = %v, want %v", err, sql.ErrNoRows)
+			}
+			if _, err := stores.PasswordResets.Use(id, "another-password"); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("second Use() error = %v, want %v", err, sql.ErrNoRows)
+			}
+			if !passwordIs(t, stores, user.Email, "new-password") {
+				t.Error("password changed by a second Use()")
+			}
+		})
+	}
+}
+
+func TestPasswordResetUseConcurrent(t *testing.T) {
+	stores := openResetStores(t)
+	user, err := stores.Users.Create("bob", "bob@example.org", "old-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := stores.PasswordResets.Create(user.ID, nil, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const attempts = 5
+	errs := make([]error, attempts)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = stores.PasswordResets.Use(id, "new-password")
+		}(i)
+	}
+	wg.Wait()
+
+	used := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			used++
+		case !errors.Is(err, sql.ErrNoRows):
+			t.Errorf("Use() error = %v, want nil or %v", err, sql.ErrNoRows)
+		}
+	}
+	if used != 1 {
+		t.Errorf("%d of %d concurrent Use() calls succeeded, want 1", used, attempts)
+	}
+}
+
+func passwordIs(t *testing.T, stores *database.Stores, email, password string) bool {
+	t.Helper()
+	user, err := stores.Users.GetByEmail(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stores.Users.VerifyPassword(user.Password, password)
+}
+
+// openResetStores returns the stores the password reset tests touch, backed
+// by a fresh test schema.
+func openResetStores(t *testing.T) *database.Stores {
+	db := dbtest.Open(t)
+	return &database.Stores{
+		Users:          database.NewUserStore(db),
+		PasswordResets: database.NewPasswordResetStore(db),
+	}
+}
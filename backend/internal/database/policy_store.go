@@ -0,0 +1,270 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Alert policy scopes, from least to most specific.
+const (
+	PolicyScopeGlobal      = "global"
+	PolicyScopeGroup       = "group"
+	PolicyScopeCertificate = "certificate"
+)
+
+// AlertPolicy holds certificate expiry thresholds in days. A certificate is
+// critical once it expires within CriticalDays and a warning within
+// WarningDays.
+type AlertPolicy struct {
+	ID            string    `json:"id"`
+	Scope         string    `json:"scope"`
+	GroupID       *string   `json:"group_id,omitempty"`
+	CertificateID *string   `json:"certificate_id,omitempty"`
+	WarningDays   int       `json:"warning_days"`
+	CriticalDays  int       `json:"critical_days"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Expiry severities computed from a policy.
+const (
+	ExpiryOK       = "ok"
+	ExpiryWarning  = "warning"
+	ExpiryCritical = "critical"
+	ExpiryExpired  = "expired"
+)
+
+// ExpirySeverity classifies a certificate expiring at expiresAt. Days are
+// counted in whole days remaining, so a certificate with 7.5 days left is
+// within a 7 day threshold.
+func (p *AlertPolicy) ExpirySeverity(expiresAt, now time.Time) string {
+	if expiresAt.Before(now) {
+		return ExpiryExpired
+	}
+	days := int(expiresAt.Sub(now).Hours() / 24)
+	switch {
+	case days <= p.CriticalDays:
+		return ExpiryCritical
+	case days <= p.WarningDays:
+		return ExpiryWarning
+	}
+	return ExpiryOK
+}
+
+// PolicySet resolves the effective policy of certificates without further
+// queries.
+type PolicySet struct {
+	Global        *AlertPolicy
+	byGroup       map[string]*AlertPolicy
+	byCertificate map[string]*AlertPolicy
+	serverGroups  map[string]string
+}
+
+// defaultAlertPolicy applies when the global row is missing.
+var defaultAlertPolicy = AlertPolicy{ID: "global", Scope: PolicyScopeGlobal, WarningDays: 30, CriticalDays: 7}
+
+// For returns the certificate override, else the policy of the server's
+// group, else the global policy.
+func (ps *PolicySet) For(cert *SSLCertificate) *AlertPolicy {
+	if p, ok := ps.byCertificate[cert.ID]; ok {
+		return p
+	}
+	if groupID, ok := ps.serverGroups[cert.ServerID]; ok {
+		if p, ok := ps.byGroup[groupID]; ok {
+			return p
+		}
+	}
+	return ps.Global
+}
+
+const alertPolicyColumns = `id, group_id, certificate_id, warning_days, critical_days, created_at, updated_at`
+
+type PolicyStore struct {
+	db *sql.DB
+}
+
+func NewPolicyStore(db *sql.DB) *PolicyStore {
+	return &PolicyStore{db: db}
+}
+
+func scanAlertPolicy(row interface{ Scan(...interface{}) error }) (*AlertPolicy, error) {
+	p := &AlertPolicy{}
+	err := row.Scan(&p.ID, &p.GroupID, &p.CertificateID, &p.WarningDays, &p.CriticalDays, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case p.CertificateID != nil:
+		p.Scope = PolicyScopeCertificate
+	case p.GroupID != nil:
+		p.Scope = PolicyScopeGroup
+	default:
+		p.Scope = PolicyScopeGlobal
+	}
+	return p, nil
+}
+
+// List returns all policies, global first.
+func (s *PolicyStore) List() ([]*AlertPolicy, error) {
+	rows, err := s.db.Query(`
+		SELECT ` + alertPolicyColumns + `
+		FROM alert_policies
+		ORDER BY (group_id IS NOT NULL), (certificate_id IS NOT NULL), created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []*AlertPolicy
+	for rows.Next() {
+		p, err := scanAlertPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// Load reads every policy and the server to group mapping into a PolicySet.
+func (s *PolicyStore) Load() (*PolicySet, error) {
+	policies, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	global := defaultAlertPolicy
+	ps := &PolicySet{
+		Global:        &global,
+		byGroup:       make(map[string]*AlertPolicy),
+		byCertificate: make(map[string]*AlertPolicy),
+		serverGroups:  make(map[string]string),
+	}
+	for _, p := range policies {
+		switch p.Scope {
+		case PolicyScopeCertificate:
+			ps.byCertificate[*p.CertificateID] = p
+		case PolicyScopeGroup:
+			ps.byGroup[*p.GroupID] = p
+		default:
+			ps.Global = p
+		}
+	}
+
+	if len(ps.byGroup) == 0 {
+		return ps, nil
+	}
+	rows, err := s.db.Query(`SELECT id, group_id FROM servers WHERE group_id IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var serverID, groupID string
+		if err := rows.Scan(&serverID, &groupID); err != nil {
+			return nil, err
+		}
+		ps.serverGroups[serverID] = groupID
+	}
+	return ps, rows.Err()
+}
+
+// GetGlobal returns the global policy, or the built-in 30/7 day default.
+func (s *PolicyStore) GetGlobal() (*AlertPolicy, error) {
+	p, err := scanAlertPolicy(s.db.QueryRow(`
+		SELECT ` + alertPolicyColumns + `
+		FROM alert_policies
+		WHERE group_id IS NULL AND certificate_id IS NULL
+	`))
+	if err == sql.ErrNoRows {
+		global := defaultAlertPolicy
+		return &global, nil
+	}
+	return p, err
+}
+
+// GetForGroup returns the group's policy, or nil if it has none.
+func (s *PolicyStore) GetForGroup(groupID string) (*AlertPolicy, error) {
+	return s.getOne(`group_id = $1`, groupID)
+}
+
+// GetForCertificate returns the certificate's override, or nil if it has none.
+func (s *PolicyStore) GetForCertificate(certificateID string) (*AlertPolicy, error) {
+	return s.getOne(`certificate_id = $1`, certificateID)
+}
+
+func (s *PolicyStore) getOne(where string, arg string) (*AlertPolicy, error) {
+	p, err := scanAlertPolicy(s.db.QueryRow(`
+		SELECT `+alertPolicyColumns+`
+		FROM alert_policies
+		WHERE `+where, arg))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// SetGlobal updates the global thresholds.
+func (s *PolicyStore) SetGlobal(warningDays, criticalDays int) (*AlertPolicy, error) {
+	now := time.Now()
+	result, err := s.db.Exec(`
+		UPDATE alert_policies
+		SET warning_days = $1, critical_days = $2, updated_at = $3
+		WHERE group_id IS NULL AND certificate_id IS NULL
+	`, warningDays, criticalDays, now)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := s.db.Exec(`
+			INSERT INTO alert_policies (id, warning_days, critical_days, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $4)
+		`, uuid.New().String(), warningDays, criticalDays, now); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetGlobal()
+}
+
+// SetForGroup creates or replaces the policy of a group.
+func (s *PolicyStore) SetForGroup(groupID string, warningDays, criticalDays int) (*AlertPolicy, error) {
+	now := time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO alert_policies (id, group_id, warning_days, critical_days, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (group_id) DO UPDATE
+		SET warning_days = EXCLUDED.warning_days, critical_days = EXCLUDED.critical_days, updated_at = EXCLUDED.updated_at
+	`, uuid.New().String(), groupID, warningDays, criticalDays, now)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetForGroup(groupID)
+}
+
+// SetForCertificate creates or replaces the override of a certificate.
+func (s *PolicyStore) SetForCertificate(certificateID string, warningDays, criticalDays int) (*AlertPolicy, error) {
+	now := time.Now()
+	_, err := s.db.Exec(`
+		INSERT INTO alert_policies (id, certificate_id, warning_days, critical_days, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (certificate_id) DO UPDATE
+		SET warning_days = EXCLUDED.warning_days, critical_days = EXCLUDED.critical_days, updated_at = EXCLUDED.updated_at
+	`, uuid.New().String(), certificateID, warningDays, criticalDays, now)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetForCertificate(certificateID)
+}
+
+func (s *PolicyStore) DeleteForGroup(groupID string) error {
+	_, err := s.db.Exec(`DELETE FROM alert_policies WHERE group_id = $1`, groupID)
+	return err
+}
+
+func (s *PolicyStore) DeleteForCertificate(certificateID string) error {
+	_, err := s.db.Exec(`DELETE FROM alert_policies WHERE certificate_id = $1`, certificateID)
+	return err
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const serverPortColumns = `id, server_id, port, transport, protocol, description, expected_state, enabled,
+	check_type, check_config, last_state, last_error, last_checked_at, latency_ms, drift, drift_since, created_at, updated_at`
+
+type PortStore struct {
+	db *sql.DB
+}
+
+func NewPortStore(db *sql.DB) *PortStore {
+	return &PortStore{db: db}
+}
+
+func scanServerPort(row interface{ Scan(...interface{}) error }) (*ServerPort, error) {
+	p := &ServerPort{}
+	var config []byte
+	err := row.Scan(&p.ID, &p.ServerID, &p.Port, &p.Transport, &p.Protocol, &p.Description, &p.ExpectedState, &p.Enabled,
+		&p.CheckType, &config, &p.LastState, &p.LastError, &p.LastCheckedAt, &p.LatencyMs, &p.Drift, &p.DriftSince,
+		&p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.CheckConfig = json.RawMessage(config)
+	return p, nil
+}
+
+func (s *PortStore) queryPorts(query string, args ...interface{}) ([]*ServerPort, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ports := []*ServerPort{}
+	for rows.Next() {
+		p, err := scanServerPort(rows)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, p)
+	}
+	return ports, rows.Err()
+}
+
+func (s *PortStore) Create(port *ServerPort) (*ServerPort, error) {
+	port.ID = uuid.New().String()
+	port.CreatedAt = time.Now()
+	port.UpdatedAt = port.CreatedAt
+
+	query := `
+		INSERT INTO server_ports (id, server_id, port, transport, protocol, description, expected_state, enabled,
+		                          check_type, check_config, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING ` + serverPortColumns
+
+	return scanServerPort(s.db.QueryRow(query, port.ID, port.ServerID, port.Port, port.Transport, port.Protocol,
+		port.Description, port.ExpectedState, port.Enabled, port.CheckType, checkConfigValue(port.CheckConfig),
+		port.CreatedAt, port.UpdatedAt))
+}
+
+func (s *PortStore) GetByID(id string) (*ServerPort, error) {
+	return scanServerPort(s.db.QueryRow(`SELECT `+serverPortColumns+` FROM server_ports WHERE id = $1`, id))
+}
+
+func (s *PortStore) ListByServer(serverID string) ([]*ServerPort, error) {
+	return s.queryPorts(`SELECT `+serverPortColumns+` FROM server_ports WHERE server_id = $1 ORDER BY port, transport`, serverID)
+}
+
+// ListEnabled returns every port the prober should check, across all servers.
+func (s *PortStore) ListEnabled() ([]*ServerPort, error) {
+	return s.queryPorts(`SELECT ` + serverPortColumns + ` FROM server_ports WHERE enabled ORDER BY server_id, port`)
+}
+
+// ListDrifted returns ports whose observed state differs from the expected one.
+// Non-admins only see ports on servers they have permissions for.
+func (s *PortStore) ListDrifted(userID string, isAdmin bool) ([]*ServerPort, error) {
+	if isAdmin {
+		return s.queryPorts(`SELECT ` + serverPortColumns + ` FROM server_ports WHERE drift ORDER BY drift_since`)
+	}
+	return s.queryPorts(`
+		SELECT `+serverPortColumns+`
+		FROM server_ports
+		WHERE drift AND server_id IN (SELECT server_id FROM user_server_permissions WHERE user_id = $1)
+		ORDER BY drift_since
+	`, userID)
+}
+
+func (s *PortStore) Update(id string, port *ServerPort) error {
+	query := `
+		UPDATE server_ports
+		SET port = $2, transport = $3, protocol = $4, description = $5, expected_state = $6, enabled = $7,
+		    check_type = $8, check_config = $9,
+		    drift = CASE WHEN expected_state = $6 AND port = $2 AND transport = $3 THEN drift ELSE FALSE END,
+		    drift_since = CASE WHEN expected_state = $6 AND port = $2 AND transport = $3 THEN drift_since ELSE NULL END
+		WHERE id = $1
+	`
+
+	_, err := s.db.Exec(query, id, port.Port, port.Transport, port.Protocol, port.Description, port.ExpectedState, port.Enabled,
+		port.CheckType, checkConfigValue(port.CheckConfig))
+	return err
+}
+
+func (s *PortStore) Delete(id string) error {
+	_, err := s.db.Exec("DELETE FROM server_ports WHERE id = $1", id)
+	return err
+}
+
+// UpdateProbeResult stores the observed state of a port. drift_since keeps the
+// time the drift was first seen until the port is back in its expected state.
+func (s *PortStore) UpdateProbeResult(id, state string, latencyMs *float64, probeErr *string, drift bool, checkedAt time.Time) error {
+	query := `
+		UPDATE server_ports
+		SET last_state = $2, latency_ms = $3, last_error = $4, last_checked_at = $6, drift = $5,
+		    drift_since = CASE WHEN NOT $5 THEN NULL WHEN drift THEN drift_since ELSE $6 END
+		WHERE id = $1
+	`
+
+	_, err := s.db.Exec(query, id, state, latencyMs, probeErr, drift, checkedAt)
+	return err
+}
+
+func checkConfigValue(config json.RawMessage) string {
+	if len(config) == 0 {
+		return "{}"
+	}
+	return string(config)
+}
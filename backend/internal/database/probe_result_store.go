@@ -0,0 +1,225 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ProbeResultStore keeps raw probe results in monthly partitions of
+// probe_results and hourly rollups in probe_results_hourly.
+type ProbeResultStore struct {
+	db *sql.DB
+}
+
+func NewProbeResultStore(db *sql.DB) *ProbeResultStore {
+	return &ProbeResultStore{db: db}
+}
+
+func partitionName(month time.Time) string {
+	return fmt.Sprintf("probe_results_y%04dm%02d", month.Year(), int(month.Month()))
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// EnsurePartitions creates the partitions for the month containing t and the
+// following `ahead` months.
+func (s *ProbeResultStore) EnsurePartitions(t time.Time, ahead int) error {
+	month := monthStart(t.UTC())
+	for i := 0; i <= ahead; i++ {
+		from := month.AddDate(0, i, 0)
+		to := from.AddDate(0, 1, 0)
+		query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF probe_results FOR VALUES FROM ('%s') TO ('%s')`,
+			partitionName(from), from.Format("2006-01-02"), to.Format("2006-01-02"))
+		if _, err := s.db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropPartitionsBefore drops raw partitions that only hold data older than
+// cutoff and returns their names.
+func (s *ProbeResultStore) DropPartitionsBefore(cutoff time.Time) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'probe_results'
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var dropped []string
+	for _, name := range names {
+		var year, month int
+		if _, err := fmt.Sscanf(name, "probe_results_y%04dm%02d", &year, &month); err != nil {
+			continue
+		}
+		end := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+		if !end.After(cutoff) {
+			if _, err := s.db.Exec("DROP TABLE IF EXISTS " + name); err != nil {
+				return dropped, err
+			}
+			dropped = append(dropped, name)
+		}
+	}
+	return dropped, nil
+}
+
+// Record inserts a batch of raw probe results.
+func (s *ProbeResultStore) Record(results []*ProbeResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	var (
+		placeholders []string
+		args         []interface{}
+	)
+	for i, r := range results {
+		n := i * 7
+		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		args = append(args, r.ServerID, r.PortID, r.CheckedAt, r.State, r.Up, r.LatencyMs, r.Error)
+	}
+
+	query := `INSERT INTO probe_results (server_id, port_id, checked_at, state, up, latency_ms, error) VALUES ` +
+		strings.Join(placeholders, ", ")
+	_, err := s.db.Exec(query, args...)
+	return err
+}
+
+// LastRollupBucket returns the most recent hourly bucket, or nil if nothing
+// has been rolled up yet.
+func (s *ProbeResultStore) LastRollupBucket() (*time.Time, error) {
+	var bucket *time.Time
+	err := s.db.QueryRow(`SELECT MAX(bucket) FROM probe_results_hourly`).Scan(&bucket)
+	return bucket, err
+}
+
+// Downsample (re)computes hourly rollups for every full hour in [from, to).
+// It is idempotent, so overlapping ranges are safe.
+func (s *ProbeResultStore) Downsample(from, to time.Time) error {
+	query := `
+		INSERT INTO probe_results_hourly (server_id, port_id, bucket, samples, up_samples, avg_latency_ms, max_latency_ms)
+		SELECT r.server_id, r.port_id, date_trunc('hour', r.checked_at), COUNT(*), COUNT(*) FILTER (WHERE r.up),
+		       AVG(r.latency_ms), MAX(r.latency_ms)
+		FROM probe_results r
+		WHERE r.checked_at >= $1 AND r.checked_at < $2
+		  AND EXISTS (SELECT 1 FROM servers s WHERE s.id = r.server_id)
+		  AND (r.port_id IS NULL OR EXISTS (SELECT 1 FROM server_ports p WHERE p.id = r.port_id))
+		GROUP BY r.server_id, r.port_id, date_trunc('hour', r.checked_at)
+		ON CONFLICT (server_id, (COALESCE(port_id, '')), bucket) DO UPDATE
+		SET samples = EXCLUDED.samples, up_samples = EXCLUDED.up_samples,
+		    avg_latency_ms = EXCLUDED.avg_latency_ms, max_latency_ms = EXCLUDED.max_latency_ms
+	`
+	_, err := s.db.Exec(query, from, to)
+	return err
+}
+
+// DeleteRollupsBefore removes hourly rollups older than cutoff.
+func (s *ProbeResultStore) DeleteRollupsBefore(cutoff time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM probe_results_hourly WHERE bucket < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Samples returns the probe history of a server (portID nil) or one of its
+// ports between from and to, in chronological order. Raw results are used
+// where they still exist and hourly rollups fill in the older part.
+func (s *ProbeResultStore) Samples(serverID string, portID *string, from, to time.Time) ([]*ProbeSample, error) {
+	var rawStart *time.Time
+	err := s.db.QueryRow(`
+		SELECT MIN(checked_at) FROM probe_results
+		WHERE server_id = $1 AND port_id IS NOT DISTINCT FROM $2
+	`, serverID, portID).Scan(&rawStart)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rollups only cover the hours before the oldest raw result; raw data is
+	// preferred wherever it still exists.
+	rollupEnd := to
+	if rawStart != nil && rawStart.Truncate(time.Hour).Before(to) {
+		rollupEnd = rawStart.Truncate(time.Hour)
+	}
+
+	var samples []*ProbeSample
+	if from.Before(rollupEnd) {
+		rows, err := s.db.Query(`
+			SELECT bucket, samples, up_samples, avg_latency_ms
+			FROM probe_results_hourly
+			WHERE server_id = $1 AND port_id IS NOT DISTINCT FROM $2 AND bucket >= $3 AND bucket < $4
+			ORDER BY bucket
+		`, serverID, portID, from.Truncate(time.Hour), rollupEnd)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			sample := &ProbeSample{Span: time.Hour}
+			if err := rows.Scan(&sample.At, &sample.Total, &sample.Up, &sample.LatencyMs); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			samples = append(samples, sample)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	rawFrom := from
+	if rollupEnd.After(rawFrom) {
+		rawFrom = rollupEnd
+	}
+	if rawStart == nil || !rawFrom.Before(to) {
+		return samples, nil
+	}
+
+	rows, err := s.db.Query(`
+		SELECT checked_at, up, latency_ms
+		FROM probe_results
+		WHERE server_id = $1 AND port_id IS NOT DISTINCT FROM $2 AND checked_at >= $3 AND checked_at < $4
+		ORDER BY checked_at
+	`, serverID, portID, rawFrom, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var up bool
+		sample := &ProbeSample{Total: 1}
+		if err := rows.Scan(&sample.At, &up, &sample.LatencyMs); err != nil {
+			return nil, err
+		}
+		if up {
+			sample.Up = 1
+		}
+		samples = append(samples, sample)
+	}
+
+	return samples, rows.Err()
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Recording statuses.
+const (
+	RecordingActive   = "recording"
+	RecordingComplete = "complete"
+	// RecordingInterrupted marks a recording recovered after a restart.
+	RecordingInterrupted = "interrupted"
+	RecordingFailed      = "failed"
+)
+
+// Recording is a recorded web terminal session.
+type Recording struct {
+	ID             string     `json:"id"`
+	UserID         *string    `json:"user_id"`
+	ServerID       *string    `json:"server_id"`
+	UserEmail      string     `json:"user_email"`
+	ServerHostname string     `json:"server_hostname"`
+	Storage        string     `json:"storage"`
+	StorageKey     string     `json:"-"`
+	Status         string     `json:"status"`
+	Cols           int        `json:"cols"`
+	Rows           int        `json:"rows"`
+	InputRecorded  bool       `json:"input_recorded"`
+	RemoteAddr     *string    `json:"remote_addr"`
+	ExitCode       *int       `json:"exit_code"`
+	SizeBytes      int64      `json:"size_bytes"`
+	Error          *string    `json:"error"`
+	StartedAt      time.Time  `json:"started_at"`
+	EndedAt        *time.Time `json:"ended_at"`
+}
+
+// RecordingFilter narrows List; empty fields match everything.
+type RecordingFilter struct {
+	UserID   string
+	ServerID string
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+}
+
+const recordingColumns = `id, user_id, server_id, user_email, server_hostname, storage, storage_key, status,
+	cols, rows, input_recorded, remote_addr, exit_code, size_bytes, error, started_at, ended_at`
+
+type RecordingStore struct {
+	db *sql.DB
+}
+
+func NewRecordingStore(db *sql.DB) *RecordingStore {
+	return &RecordingStore{db: db}
+}
+
+func scanRecording(row interface{ Scan(...interface{}) error }) (*Recording, error) {
+	r := &Recording{}
+	err := row.Scan(&r.ID, &r.UserID, &r.ServerID, &r.UserEmail, &r.ServerHostname, &r.Storage, &r.StorageKey,
+		&r.Status, &r.Cols, &r.Rows, &r.InputRecorded, &r.RemoteAddr, &r.ExitCode, &r.SizeBytes, &r.Error,
+		&r.StartedAt, &r.EndedAt)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *RecordingStore) list(query string, args ...interface{}) ([]*Recording, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recordings := []*Recording{}
+	for rows.Next() {
+		r, err := scanRecording(rows)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, r)
+	}
+	return recordings, rows.Err()
+}
+
+// List returns recordings, newest first.
+func (s *RecordingStore) List(f RecordingFilter) ([]*Recording, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.UserID != "" {
+		add("user_id = $%d", f.UserID)
+	}
+	if f.ServerID != "" {
+		add("server_id = $%d", f.ServerID)
+	}
+	if f.From != nil {
+		add("started_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("started_at < $%d", *f.To)
+	}
+
+	query := `SELECT ` + recordingColumns + ` FROM ssh_recordings`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY started_at DESC LIMIT $%d", len(args))
+	return s.list(query, args...)
+}
+
+func (s *RecordingStore) Get(id string) (*Recording, error) {
+	return scanRecording(s.db.QueryRow(`SELECT `+recordingColumns+` FROM ssh_recordings WHERE id = $1`, id))
+}
+
+// ListActive returns recordings that were never finished, e.g. because the
+// backend stopped while they were running.
+func (s *RecordingStore) ListActive() ([]*Recording, error) {
+	return s.list(`SELECT `+recordingColumns+` FROM ssh_recordings WHERE status = $1`, RecordingActive)
+}
+
+// ListEndedBefore returns recordings that ended before t, oldest first.
+func (s *RecordingStore) ListEndedBefore(t time.Time, limit int) ([]*Recording, error) {
+	return s.list(`SELECT `+recordingColumns+` FROM ssh_recordings
+		WHERE status <> $1 AND COALESCE(ended_at, started_at) < $2
+		ORDER BY started_at LIMIT $3`, RecordingActive, t, limit)
+}
+
+func (s *RecordingStore) Create(r *Recording) error {
+	_, err := s.db.Exec(`
+		INSERT INTO ssh_recordings (id, user_id, server_id, user_email, server_hostname, storage, storage_key,
+			status, cols, rows, input_recorded, remote_addr, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, r.ID, r.UserID, r.ServerID, r.UserEmail, r.ServerHostname, r.Storage, r.StorageKey, r.Status,
+		r.Cols, r.Rows, r.InputRecorded, r.RemoteAddr, r.StartedAt)
+	return err
+}
+
+// Finish records the outcome of a recording.
+func (s *RecordingStore) Finish(r *Recording) error {
+	_, err := s.db.Exec(`
+		UPDATE ssh_recordings
+		SET status = $2, exit_code = $3, size_bytes = $4, error = $5, ended_at = $6
+		WHERE id = $1
+	`, r.ID, r.Status, r.ExitCode, r.SizeBytes, r.Error, r.EndedAt)
+	return err
+}
+
+func (s *RecordingStore) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM ssh_recordings WHERE id = $1`, id)
+	return err
+}
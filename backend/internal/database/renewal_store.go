@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RenewalSettings configures ACME renewal and delivery for one certificate.
+type RenewalSettings struct {
+	CertificateID string `json:"certificate_id"`
+	// Solver names the challenge solver, e.g. "http-01" or "dns-01-hook".
+	Solver string `json:"solver"`
+	// Webroot is the document root on the server for the http-01-webroot solver.
+	Webroot *string `json:"webroot"`
+	// CertPath receives the full chain unless ChainPath is set, in which case
+	// it only receives the leaf.
+	CertPath      string  `json:"cert_path"`
+	KeyPath       string  `json:"key_path"`
+	ChainPath     *string `json:"chain_path"`
+	ReloadCommand *string `json:"reload_command"`
+
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	LastRenewedAt *time.Time `json:"last_renewed_at"`
+	LastError     *string    `json:"last_error"`
+	Failures      int        `json:"failures"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// ACMEAccount is a registered account with an ACME directory.
+type ACMEAccount struct {
+	DirectoryURL string
+	Email        *string
+	AccountURL   *string
+	EncryptedKey []byte
+}
+
+const renewalSettingsColumns = `certificate_id, solver, webroot, cert_path, key_path, chain_path, reload_command,
+	last_attempt_at, last_renewed_at, last_error, failures, next_attempt_at, created_at, updated_at`
+
+type RenewalStore struct {
+	db *sql.DB
+}
+
+func NewRenewalStore(db *sql.DB) *RenewalStore {
+	return &RenewalStore{db: db}
+}
+
+func scanRenewalSettings(row interface{ Scan(...interface{}) error }) (*RenewalSettings, error) {
+	rs := &RenewalSettings{}
+	err := row.Scan(&rs.CertificateID, &rs.Solver, &rs.Webroot, &rs.CertPath, &rs.KeyPath, &rs.ChainPath,
+		&rs.ReloadCommand, &rs.LastAttemptAt, &rs.LastRenewedAt, &rs.LastError, &rs.Failures,
+		&rs.NextAttemptAt, &rs.CreatedAt, &rs.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Get returns the renewal settings for a certificate, or nil if none exist.
+func (s *RenewalStore) Get(certID string) (*RenewalSettings, error) {
+	rs, err := scanRenewalSettings(s.db.QueryRow(`SELECT `+renewalSettingsColumns+` FROM ssl_certificate_renewals WHERE certificate_id = $1`, certID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rs, err
+}
+
+// Upsert creates or replaces the settings of a certificate. Changing the
+// settings clears the failure backoff so the next run retries immediately.
+func (s *RenewalStore) Upsert(rs *RenewalSettings) error {
+	_, err := s.db.Exec(`
+		INSERT INTO ssl_certificate_renewals (certificate_id, solver, webroot, cert_path, key_path, chain_path, reload_command)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (certificate_id) DO UPDATE
+		SET solver = EXCLUDED.solver, webroot = EXCLUDED.webroot, cert_path = EXCLUDED.cert_path,
+		    key_path = EXCLUDED.key_path, chain_path = EXCLUDED.chain_path, reload_command = EXCLUDED.reload_command,
+		    failures = 0, next_attempt_at = NULL, updated_at = CURRENT_TIMESTAMP
+	`, rs.CertificateID, rs.Solver, rs.Webroot, rs.CertPath, rs.KeyPath, rs.ChainPath, rs.ReloadCommand)
+	return err
+}
+
+func (s *RenewalStore) Delete(certID string) error {
+	_, err := s.db.Exec(`DELETE FROM ssl_certificate_renewals WHERE certificate_id = $1`, certID)
+	return err
+}
+
+// ListDue returns the IDs of auto_renew certificates with renewal settings
+// that expire before the cutoff and are not backing off after a failure.
+func (s *RenewalStore) ListDue(cutoff, now time.Time) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT c.id
+		FROM ssl_certificates c
+		INNER JOIN ssl_certificate_renewals r ON r.certificate_id = c.id
+		WHERE c.auto_renew AND c.expires_at < $1 AND (r.next_attempt_at IS NULL OR r.next_attempt_at <= $2)
+		ORDER BY c.expires_at
+	`, cutoff, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *RenewalStore) RecordSuccess(certID string, at time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE ssl_certificate_renewals
+		SET last_attempt_at = $2, last_renewed_at = $2, last_error = NULL, failures = 0, next_attempt_at = NULL
+		WHERE certificate_id = $1
+	`, certID, at)
+	return err
+}
+
+// RecordFailure stores the error and when to try again.
+func (s *RenewalStore) RecordFailure(certID, message string, at, next time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE ssl_certificate_renewals
+		SET last_attempt_at = $2, last_error = $3, failures = failures + 1, next_attempt_at = $4
+		WHERE certificate_id = $1
+	`, certID, at, message, next)
+	return err
+}
+
+// GetAccount returns the ACME account for a directory, or nil if none is registered.
+func (s *RenewalStore) GetAccount(directoryURL string) (*ACMEAccount, error) {
+	a := &ACMEAccount{}
+	err := s.db.QueryRow(`SELECT directory_url, email, account_url, encrypted_key FROM acme_accounts WHERE directory_url = $1`, directoryURL).
+		Scan(&a.DirectoryURL, &a.Email, &a.AccountURL, &a.EncryptedKey)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return a, err
+}
+
+func (s *RenewalStore) SaveAccount(a *ACMEAccount) error {
+	_, err := s.db.Exec(`
+		INSERT INTO acme_accounts (directory_url, email, account_url, encrypted_key)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (directory_url) DO UPDATE
+		SET email = EXCLUDED.email, account_url = EXCLUDED.account_url, encrypted_key = EXCLUDED.encrypted_key
+	`, a.DirectoryURL, a.Email, a.AccountURL, a.EncryptedKey)
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type ServerStore struct {
@@ -25,15 +26,15 @@ func (s *ServerStore) Create(server *Server) (*Server, error) {
 	}
 
 	query := `
-		INSERT INTO servers (id, hostname, ip_address, ssh_port, ssh_username, ssh_key_path, prometheus_url, status, group_id, tags, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
-		RETURNING id, hostname, ip_address, ssh_port, ssh_username, ssh_key_path, prometheus_url, status, group_id, tags, created_at, updated_at
+		INSERT INTO servers (id, hostname, ip_address, ssh_port, ssh_username, ssh_key_path, prometheus_url, status, group_id, tags, credential_id, host_key_mode, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id, hostname, ip_address, ssh_port, ssh_username, ssh_key_path, prometheus_url, status, group_id, tags, credential_id, host_key_mode, created_at, updated_at
 	`
 
 	err := s.db.QueryRow(query, server.ID, server.Hostname, server.IPAddress, server.SSHPort, server.SSHUsername,
-		server.SSHKeyPath, server.PrometheusURL, server.Status, server.GroupID, server.Tags, server.CreatedAt, server.UpdatedAt).
+		server.SSHKeyPath, server.PrometheusURL, server.Status, server.GroupID, pq.Array(server.Tags), server.CredentialID, server.HostKeyMode, server.CreatedAt, server.UpdatedAt).
 		Scan(&server.ID, &server.Hostname, &server.IPAddress, &server.SSHPort, &server.SSHUsername,
-			&server.SSHKeyPath, &server.PrometheusURL, &server.Status, &server.GroupID, &server.Tags, &server.CreatedAt, &server.UpdatedAt)
+			&server.SSHKeyPath, &server.PrometheusURL, &server.Status, &server.GroupID, pq.Array(&server.Tags), &server.CredentialID, &server.HostKeyMode, &server.CreatedAt, &server.UpdatedAt)
 
 	return server, err
 }
@@ -41,14 +42,16 @@ func (s *ServerStore) Create(server *Server) (*Server, error) {
 func (s *ServerStore) GetByID(id string) (*Server, error) {
 	server := &Server{}
 	query := `
-		SELECT id, hostname, ip_address, ssh_port, ssh_username, ssh_key_path, prometheus_url, status, group_id, tags, created_at, updated_at
+		SELECT id, hostname, ip_address, ssh_port, ssh_username, ssh_key_path, prometheus_url, status, group_id, tags, credential_id, host_key_mode,
+		       last_checked_at, last_seen_at, latency_ms, created_at, updated_at
 		FROM servers
 		WHERE id = $1
 	`
 
 	err := s.db.QueryRow(query, id).Scan(
 		&server.ID, &server.Hostname, &server.IPAddress, &server.SSHPort, &server.SSHUsername,
-		&server.SSHKeyPath, &server.PrometheusURL, &server.Status, &server.GroupID, &server.Tags, &server.CreatedAt, &server.UpdatedAt,
+		&server.SSHKeyPath, &server.PrometheusURL, &server.Status, &server.GroupID, pq.Array(&server.Tags), &server.CredentialID, &server.HostKeyMode,
+		&server.LastCheckedAt, &server.LastSeenAt, &server.LatencyMs, &server.CreatedAt, &server.UpdatedAt,
 	)
 
 	return server, err
@@ -61,14 +64,16 @@ func (s *ServerStore) List(userID string, isAdmin bool) ([]*Server, error) {
 
 	if isAdmin {
 		query = `
-			SELECT id, hostname, ip_address, ssh_port, ssh_username, ssh_key_path, prometheus_url, status, group_id, tags, created_at, updated_at
+			SELECT id, hostname, ip_address, ssh_port, ssh_username, ssh_key_path, prometheus_url, status, group_id, tags, credential_id, host_key_mode,
+			       last_checked_at, last_seen_at, latency_ms, created_at, updated_at
 			FROM servers
 			ORDER BY hostname
 		`
 		rows, err = s.db.Query(query)
 	} else {
 		query = `
-			SELECT s.id, s.hostname, s.ip_address, s.ssh_port, s.ssh_username, s.ssh_key_path, s.prometheus_url, s.status, s.group_id, s.tags, s.created_at, s.updated_at
+			SELECT s.id, s.hostname, s.ip_address, s.ssh_port, s.ssh_username, s.ssh_key_path, s.prometheus_url, s.status, s.group_id, s.tags, s.credential_id, s.host_key_mode,
+			       s.last_checked_at, s.last_seen_at, s.latency_ms, s.created_at, s.updated_at
 			FROM servers s
 			INNER JOIN user_server_permissions p ON s.id = p.server_id
 			WHERE p.user_id = $1
@@ -86,7 +91,8 @@ func (s *ServerStore) List(userID string, isAdmin bool) ([]*Server, error) {
 	for rows.Next() {
 		server := &Server{}
 		err := rows.Scan(&server.ID, &server.Hostname, &server.IPAddress, &server.SSHPort, &server.SSHUsername,
-			&server.SSHKeyPath, &server.PrometheusURL, &server.Status, &server.GroupID, &server.Tags, &server.CreatedAt, &server.UpdatedAt)
+			&server.SSHKeyPath, &server.PrometheusURL, &server.Status, &server.GroupID, pq.Array(&server.Tags), &server.CredentialID, &server.HostKeyMode,
+			&server.LastCheckedAt, &server.LastSeenAt, &server.LatencyMs, &server.CreatedAt, &server.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -106,12 +112,12 @@ func (s *ServerStore) Update(id string, server *Server) error {
 	query := `
 		UPDATE servers
 		SET hostname = $2, ip_address = $3, ssh_port = $4, ssh_username = $5, ssh_key_path = $6,
-		    prometheus_url = $7, status = $8, group_id = $9, tags = $10, updated_at = $11
+		    prometheus_url = $7, status = $8, group_id = $9, tags = $10, credential_id = $11, host_key_mode = $12, updated_at = $13
 		WHERE id = $1
 	`
 
 	_, err := s.db.Exec(query, id, server.Hostname, server.IPAddress, server.SSHPort, server.SSHUsername,
-		server.SSHKeyPath, server.PrometheusURL, server.Status, server.GroupID, server.Tags, server.UpdatedAt)
+		server.SSHKeyPath, server.PrometheusURL, server.Status, server.GroupID, pq.Array(server.Tags), server.CredentialID, server.HostKeyMode, server.UpdatedAt)
 
 	return err
 }
@@ -120,3 +126,148 @@ func (s *ServerStore) Delete(id string) error {
 	_, err := s.db.Exec("DELETE FROM servers WHERE id = $1", id)
 	return err
 }
+
+// UpdateProbeResult stores the outcome of a reachability probe. When the status
+// differs from the stored one a row is added to server_status_events so outages
+// can be reconstructed later. It reports whether the status changed. The
+// updated_at trigger ignores the probe columns, so updated_at keeps telling
+// when the server was last edited.
+func (s *ServerStore) UpdateProbeResult(id, status string, latencyMs *float64, probeErr *string, checkedAt time.Time) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow("SELECT COALESCE(status, '') FROM servers WHERE id = $1 FOR UPDATE", id).Scan(&previous)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		UPDATE servers
+		SET status = $2, latency_ms = $3, last_checked_at = $4,
+		    last_seen_at = CASE WHEN $3::DOUBLE PRECISION IS NOT NULL THEN $4 ELSE last_seen_at END
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(query, id, status, latencyMs, checkedAt); err != nil {
+		return false, err
+	}
+
+	changed := previous != status
+	if changed {
+		var prev *string
+		if previous != "" {
+			prev = &previous
+		}
+		_, err = tx.Exec(`
+			INSERT INTO server_status_events (id, server_id, previous_status, status, latency_ms, error, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, uuid.New().String(), id, prev, status, latencyMs, probeErr, checkedAt)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return changed, tx.Commit()
+}
+
+func (s *ServerStore) ListStatusEvents(serverID string, limit int) ([]*ServerStatusEvent, error) {
+	query := `
+		SELECT id, server_id, previous_status, status, latency_ms, error, created_at
+		FROM server_status_events
+		WHERE server_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.Query(query, serverID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*ServerStatusEvent{}
+	for rows.Next() {
+		event := &ServerStatusEvent{}
+		err := rows.Scan(&event.ID, &event.ServerID, &event.PreviousStatus, &event.Status, &event.LatencyMs, &event.Error, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// ListProbeTargets returns every server with just the fields the prober needs.
+func (s *ServerStore) ListProbeTargets() ([]*Server, error) {
+	return s.listSummaries(`SELECT id, hostname, ip_address, COALESCE(ssh_port, 22), COALESCE(status, 'unknown') FROM servers ORDER BY hostname`)
+}
+
+// ListByGroup returns the servers in a group with the same fields as ListProbeTargets.
+func (s *ServerStore) ListByGroup(groupID string) ([]*Server, error) {
+	return s.listSummaries(`
+		SELECT id, hostname, ip_address, COALESCE(ssh_port, 22), COALESCE(status, 'unknown')
+		FROM servers
+		WHERE group_id = $1
+		ORDER BY hostname
+	`, groupID)
+}
+
+func (s *ServerStore) listSummaries(query string, args ...interface{}) ([]*Server, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var servers []*Server
+	for rows.Next() {
+		server := &Server{}
+		if err := rows.Scan(&server.ID, &server.Hostname, &server.IPAddress, &server.SSHPort, &server.Status); err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, rows.Err()
+}
+
+// ListSelected returns the servers matching any part of sel. Non-admins only
+// get servers they have been granted access to.
+func (s *ServerStore) ListSelected(sel JobSelector, userID string, isAdmin bool) ([]*Server, error) {
+	query := `
+		SELECT s.id, s.hostname, s.ip_address, s.ssh_port, s.ssh_username, s.ssh_key_path, s.prometheus_url, s.status, s.group_id, s.tags, s.credential_id, s.host_key_mode,
+		       s.last_checked_at, s.last_seen_at, s.latency_ms, s.created_at, s.updated_at
+		FROM servers s
+		WHERE (s.id = ANY($1) OR s.group_id = ANY($2) OR s.tags && $3)
+	`
+	args := []interface{}{pq.Array(sel.ServerIDs), pq.Array(sel.GroupIDs), pq.Array(sel.Tags)}
+	if !isAdmin {
+		query += ` AND EXISTS (SELECT 1 FROM user_server_permissions p WHERE p.server_id = s.id AND p.user_id = $4)`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY s.hostname`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var servers []*Server
+	for rows.Next() {
+		server := &Server{}
+		err := rows.Scan(&server.ID, &server.Hostname, &server.IPAddress, &server.SSHPort, &server.SSHUsername,
+			&server.SSHKeyPath, &server.PrometheusURL, &server.Status, &server.GroupID, pq.Array(&server.Tags), &server.CredentialID, &server.HostKeyMode,
+			&server.LastCheckedAt, &server.LastSeenAt, &server.LatencyMs, &server.CreatedAt, &server.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, rows.Err()
+}
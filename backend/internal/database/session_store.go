@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a session was revoked.
+const (
+	SessionLoggedOut       = "logout"
+	SessionLoggedOutAll    = "logout_all"
+	SessionTerminated      = "terminated"
+	SessionRefreshReused   = "refresh_token_reuse"
+	SessionUserDisabled    = "user_disabled"
+	SessionPasswordChanged = "password_changed"
+	SessionPasswordReset   = "password_reset"
+)
+
+// Session is a login of a user from one client.
+type Session struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	UserAgent     *string    `json:"user_agent"`
+	RemoteAddr    *string    `json:"remote_addr"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason *string    `json:"revoked_reason,omitempty"`
+}
+
+const sessionColumns = `id, user_id, user_agent, remote_addr, created_at, last_used_at, expires_at,
+	revoked_at, revoked_reason`
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func NewSessionStore(db *sql.DB) *SessionStore {
+	return &SessionStore{db: db}
+}
+
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
+	s := &Session{}
+	err := row.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.RemoteAddr, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt,
+		&s.RevokedAt, &s.RevokedReason)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Create starts a session whose current refresh token hashes to
+// refreshHash, assigning its ID.
+func (s *SessionStore) Create(sess *Session, refreshHash string) error {
+	sess.ID = uuid.New().String()
+	now := time.Now()
+	sess.CreatedAt, sess.LastUsedAt = now, now
+	_, err := s.db.Exec(`
+		INSERT INTO user_sessions (id, user_id, refresh_token_hash, user_agent, remote_addr, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, sess.ID, sess.UserID, refreshHash, sess.UserAgent, sess.RemoteAddr, now, now, sess.ExpiresAt)
+	return err
+}
+
+// Rotate replaces the refresh token hashing to oldHash with newHash if its
+// session is live, extending it to expiresAt. It returns sql.ErrNoRows if no
+// live session has that token.
+func (s *SessionStore) Rotate(oldHash, newHash string, expiresAt time.Time, userAgent, remoteAddr *string) (*Session, error) {
+	now := time.Now()
+	return scanSession(s.db.QueryRow(`
+		UPDATE user_sessions
+		SET refresh_token_hash = $2, previous_token_hash = $1, rotated_at = $3, last_used_at = $3,
+			expires_at = $4, user_agent = COALESCE($5, user_agent), remote_addr = COALESCE($6, remote_addr)
+		WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > $3
+		RETURNING `+sessionColumns,
+		oldHash, newHash, now, expiresAt, userAgent, remoteAddr))
+}
+
+// FindByPreviousHash returns the session whose refresh token replaced the one
+// hashing to hash, and when it was replaced.
+func (s *SessionStore) FindByPreviousHash(hash string) (*Session, time.Time, error) {
+	var rotatedAt time.Time
+	sess := &Session{}
+	err := s.db.QueryRow(`
+		SELECT `+sessionColumns+`, rotated_at FROM user_sessions WHERE previous_token_hash = $1
+	`, hash).Scan(&sess.ID, &sess.UserID, &sess.UserAgent, &sess.RemoteAddr, &sess.CreatedAt, &sess.LastUsedAt,
+		&sess.ExpiresAt, &sess.RevokedAt, &sess.RevokedReason, &rotatedAt)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return sess, rotatedAt, nil
+}
+
+func (s *SessionStore) Get(id string) (*Session, error) {
+	return scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM user_sessions WHERE id = $1`, id))
+}
+
+// ListActive returns the user's sessions that are neither revoked nor
+// expired, newest first.
+func (s *SessionStore) ListActive(userID string) ([]*Session, error) {
+	rows, err := s.db.Query(`
+		SELECT `+sessionColumns+` FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC
+	`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke ends a session, reporting whether it was live.
+func (s *SessionStore) Revoke(id, reason string) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = $2, revoked_reason = $3 WHERE id = $1 AND revoked_at IS NULL
+	`, id, time.Now(), reason)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeAllForUser ends every live session of a user and returns their IDs.
+func (s *SessionStore) RevokeAllForUser(userID, reason string) ([]string, error) {
+	rows, err := s.db.Query(`
+		UPDATE user_sessions SET revoked_at = $2, revoked_reason = $3
+		WHERE user_id = $1 AND revoked_at IS NULL
+		RETURNING id
+	`, userID, time.Now(), reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RevokeOthersForUser ends every live session of a user but keepID and
+// returns their IDs.
+func (s *SessionStore) RevokeOthersForUser(userID, keepID, reason string) ([]string, error) {
+	rows, err := s.db.Query(`
+		UPDATE user_sessions SET revoked_at = $3, revoked_reason = $4
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL
+		RETURNING id
+	`, userID, keepID, time.Now(), reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RevokedSince returns the IDs of sessions revoked after t, whose access
+// tokens may still be in use.
+func (s *SessionStore) RevokedSince(t time.Time) ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM user_sessions WHERE revoked_at > $1`, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// MarkStepUp records that the session just proved a second factor.
+func (s *SessionStore) MarkStepUp(id string) error {
+	_, err := s.db.Exec(`UPDATE user_sessions SET step_up_at = $2 WHERE id = $1`, id, time.Now())
+	return err
+}
+
+// StepUpAt returns when the session last proved a second factor, or nil.
+func (s *SessionStore) StepUpAt(id string) (*time.Time, error) {
+	var at *time.Time
+	err := s.db.QueryRow(`SELECT step_up_at FROM user_sessions WHERE id = $1`, id).Scan(&at)
+	return at, err
+}
+
+// MarkWebAuthn records that the session just made a WebAuthn assertion,
+// which also counts as proving a second factor.
+func (s *SessionStore) MarkWebAuthn(id string) error {
+	now := time.Now()
+	_, err := s.db.Exec(`UPDATE user_sessions SET webauthn_at = $2, step_up_at = $2 WHERE id = $1`, id, now)
+	return err
+}
+
+// WebAuthnAt returns when the session last made a WebAuthn assertion, or
+// nil.
+func (s *SessionStore) WebAuthnAt(id string) (*time.Time, error) {
+	var at *time.Time
+	err := s.db.QueryRow(`SELECT webauthn_at FROM user_sessions WHERE id = $1`, id).Scan(&at)
+	return at, err
+}
+
+// DeleteEndedBefore removes sessions that expired or were revoked before t.
+func (s *SessionStore) DeleteEndedBefore(t time.Time) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM user_sessions WHERE expires_at < $1 OR revoked_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
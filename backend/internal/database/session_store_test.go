@@ -0,0 +1,111 @@
+package database_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/database/dbtest"
+)
+
+func openSessionStores(t *testing.T) (*database.Stores, *database.User) {
+	t.Helper()
+	db := dbtest.Open(t)
+	stores := &database.Stores{
+		Users:    database.NewUserStore(db),
+		Sessions: database.NewSessionStore(db),
+	}
+	user, err := stores.Users.Create("alice", "alice@example.org", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stores, user
+}
+
+func TestSessionRotate(t *testing.T) {
+	tests := []struct {
+		name    string
+		expires time.Duration
+		revoked bool
+		hash    string
+		wantErr error
+	}{
+		{name: "live session", expires: time.Hour, hash: "hash-1"},
+		{name: "unknown token", expires: time.Hour, hash: "hash-x", wantErr: sql.ErrNoRows},
+		{name: "expired session", expires: -time.Minute, hash: "hash-1", wantErr: sql.ErrNoRows},
+		{name: "revoked session", expires: time.Hour, revoked: true, hash: "hash-1", wantErr: sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores, user := openSessionStores(t)
+			sess := &database.Session{UserID: user.ID, ExpiresAt: time.Now().Add(tt.expires)}
+			if err := stores.Sessions.Create(sess, "hash-1"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.revoked {
+				if _, err := stores.Sessions.Revoke(sess.ID, database.SessionLoggedOut); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			created, err := stores.Sessions.Get(sess.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expires := time.Now().Add(2 * time.Hour)
+			agent := "curl/8.0"
+			rotated, err := stores.Sessions.Rotate(tt.hash, "hash-2", expires, &agent, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rotate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if rotated.ID != sess.ID || !rotated.ExpiresAt.After(created.ExpiresAt) {
+				t.Errorf("Rotate() = %+v, want session %s extended", rotated, sess.ID)
+			}
+			if rotated.UserAgent == nil || *rotated.UserAgent != agent {
+				t.Errorf("user agent = %v, want %s", rotated.UserAgent, agent)
+			}
+		})
+	}
+}
+
+func TestSessionRefreshReuse(t *testing.T) {
+	stores, user := openSessionStores(t)
+	sess := &database.Session{UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := stores.Sessions.Create(sess, "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := stores.Sessions.FindByPreviousHash("hash-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("FindByPreviousHash() before rotation error = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	if _, err := stores.Sessions.Rotate("hash-1", "hash-2", time.Now().Add(time.Hour), nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Presenting the replaced token again cannot rotate it a second time, but
+	// leads back to its session.
+	if _, err := stores.Sessions.Rotate("hash-1", "hash-3", time.Now().Add(time.Hour), nil, nil); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("second Rotate() of the same token error = %v, want %v", err, sql.ErrNoRows)
+	}
+	found, rotatedAt, err := stores.Sessions.FindByPreviousHash("hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != sess.ID || rotatedAt.IsZero() {
+		t.Errorf("FindByPreviousHash() = %s rotated at %s, want %s", found.ID, rotatedAt, sess.ID)
+	}
+	if _, _, err := stores.Sessions.FindByPreviousHash("hash-2"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("FindByPreviousHash() of the current token error = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	// The current token still works.
+	if _, err := stores.Sessions.Rotate("hash-2", "hash-3", time.Now().Add(time.Hour), nil, nil); err != nil {
+		t.Fatalf("Rotate() of the current token: %v", err)
+	}
+}
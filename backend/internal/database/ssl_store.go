@@ -2,7 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type SSLCertificate struct {
@@ -15,10 +19,82 @@ type SSLCertificate struct {
 	Status        string     `json:"status"`
 	AutoRenew     bool       `json:"auto_renew"`
 	LastCheckedAt *time.Time `json:"last_checked_at"`
-	CreatedAt     time.Time  `json:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at"`
+
+	// Optional endpoint override for the scanner; defaults to Domain:443.
+	ScanHost *string `json:"scan_host"`
+	ScanPort *int    `json:"scan_port"`
+	SNI      *string `json:"sni"`
+	// Source is "manual", "scan", "discovered" or "upload".
+	Source string `json:"source"`
+
+	Subject            *string          `json:"subject"`
+	SerialNumber       *string          `json:"serial_number"`
+	SANs               []string         `json:"sans"`
+	KeyType            *string          `json:"key_type"`
+	KeyBits            *int             `json:"key_bits"`
+	SignatureAlgorithm *string          `json:"signature_algorithm"`
+	FingerprintSHA256  *string          `json:"fingerprint_sha256"`
+	Chain              []CertChainEntry `json:"chain"`
+	LastScanError      *string          `json:"last_scan_error"`
+	Findings           []CertFinding    `json:"findings,omitempty"`
+
+	// Severity and AlertPolicy are computed from the effective alert policy
+	// when listing; Severity is "ok", "warning", "critical" or "expired".
+	Severity    string       `json:"severity,omitempty"`
+	AlertPolicy *AlertPolicy `json:"alert_policy,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CertChainEntry describes one certificate of the chain presented by a server,
+// leaf first, in the order it was sent.
+type CertChainEntry struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	KeyType            string    `json:"key_type"`
+	KeyBits            int       `json:"key_bits"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	IsCA               bool      `json:"is_ca"`
+	FingerprintSHA256  string    `json:"fingerprint_sha256"`
 }
 
+// CertFinding is a validation problem found on a certificate or its chain.
+// Subject identifies the chain certificate concerned, empty for the leaf.
+type CertFinding struct {
+	ID            string    `json:"id,omitempty"`
+	CertificateID string    `json:"certificate_id,omitempty"`
+	Type          string    `json:"type"`
+	Severity      string    `json:"severity"`
+	Subject       string    `json:"subject"`
+	Message       string    `json:"message"`
+	FirstSeenAt   time.Time `json:"first_seen_at"`
+	LastSeenAt    time.Time `json:"last_seen_at"`
+}
+
+// CertScanResult holds the parsed details of a live or uploaded certificate.
+type CertScanResult struct {
+	Subject            string
+	Issuer             string
+	SerialNumber       string
+	IssuedAt           time.Time
+	ExpiresAt          time.Time
+	SANs               []string
+	KeyType            string
+	KeyBits            int
+	SignatureAlgorithm string
+	FingerprintSHA256  string
+	Chain              []CertChainEntry
+	Findings           []CertFinding
+}
+
+const sslCertificateColumns = `id, server_id, domain, issuer, issued_at, expires_at, status, auto_renew, last_checked_at,
+	scan_host, scan_port, sni, source, subject, serial_number, sans, key_type, key_bits, signature_algorithm,
+	fingerprint_sha256, chain, last_scan_error, created_at, updated_at`
+
 type SSLStore struct {
 	db *sql.DB
 }
@@ -27,56 +103,78 @@ func NewSSLStore(db *sql.DB) *SSLStore {
 	return &SSLStore{db: db}
 }
 
-func (s *SSLStore) Create(cert *SSLCertificate) (*SSLCertificate, error) {
-	now := time.Now()
-	err := s.db.QueryRow(`
-		INSERT INTO ssl_certificates (server_id, domain, issuer, issued_at, expires_at, status, auto_renew, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, created_at, updated_at
-	`, cert.ServerID, cert.Domain, cert.Issuer, cert.IssuedAt, cert.ExpiresAt, cert.Status, cert.AutoRenew, now, now).Scan(
-		&cert.ID, &cert.CreatedAt, &cert.UpdatedAt,
+func scanSSLCertificate(row interface{ Scan(...interface{}) error }) (*SSLCertificate, error) {
+	cert := &SSLCertificate{}
+	var chain []byte
+	err := row.Scan(
+		&cert.ID, &cert.ServerID, &cert.Domain, &cert.Issuer, &cert.IssuedAt, &cert.ExpiresAt,
+		&cert.Status, &cert.AutoRenew, &cert.LastCheckedAt,
+		&cert.ScanHost, &cert.ScanPort, &cert.SNI, &cert.Source, &cert.Subject, &cert.SerialNumber,
+		pq.Array(&cert.SANs), &cert.KeyType, &cert.KeyBits, &cert.SignatureAlgorithm,
+		&cert.FingerprintSHA256, &chain, &cert.LastScanError, &cert.CreatedAt, &cert.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if cert.SANs == nil {
+		cert.SANs = []string{}
+	}
+	cert.Chain = []CertChainEntry{}
+	if len(chain) > 0 {
+		if err := json.Unmarshal(chain, &cert.Chain); err != nil {
+			return nil, err
+		}
+	}
 	return cert, nil
 }
 
-func (s *SSLStore) GetByID(id string) (*SSLCertificate, error) {
-	cert := &SSLCertificate{}
+func (s *SSLStore) Create(cert *SSLCertificate) (*SSLCertificate, error) {
+	if cert.Source == "" {
+		cert.Source = "manual"
+	}
+
+	now := time.Now()
 	err := s.db.QueryRow(`
-		SELECT id, server_id, domain, issuer, issued_at, expires_at, status, auto_renew, last_checked_at, created_at, updated_at
-		FROM ssl_certificates
-		WHERE id = $1
-	`, id).Scan(
-		&cert.ID, &cert.ServerID, &cert.Domain, &cert.Issuer, &cert.IssuedAt, &cert.ExpiresAt,
-		&cert.Status, &cert.AutoRenew, &cert.LastCheckedAt, &cert.CreatedAt, &cert.UpdatedAt,
+		INSERT INTO ssl_certificates (server_id, domain, issuer, issued_at, expires_at, status, auto_renew,
+		                              scan_host, scan_port, sni, source, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at, updated_at
+	`, cert.ServerID, cert.Domain, cert.Issuer, cert.IssuedAt, cert.ExpiresAt, cert.Status, cert.AutoRenew,
+		cert.ScanHost, cert.ScanPort, cert.SNI, cert.Source, now, now).Scan(
+		&cert.ID, &cert.CreatedAt, &cert.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if cert.SANs == nil {
+		cert.SANs = []string{}
+	}
+	if cert.Chain == nil {
+		cert.Chain = []CertChainEntry{}
+	}
 	return cert, nil
 }
 
+func (s *SSLStore) GetByID(id string) (*SSLCertificate, error) {
+	return scanSSLCertificate(s.db.QueryRow(`SELECT `+sslCertificateColumns+` FROM ssl_certificates WHERE id = $1`, id))
+}
+
 func (s *SSLStore) List(userID string, isAdmin bool) ([]*SSLCertificate, error) {
 	var rows *sql.Rows
 	var err error
 
 	if isAdmin {
 		rows, err = s.db.Query(`
-			SELECT c.id, c.server_id, c.domain, c.issuer, c.issued_at, c.expires_at, 
-			       c.status, c.auto_renew, c.last_checked_at, c.created_at, c.updated_at
-			FROM ssl_certificates c
-			ORDER BY c.expires_at ASC
+			SELECT ` + sslCertificateColumns + `
+			FROM ssl_certificates
+			ORDER BY expires_at ASC
 		`)
 	} else {
 		rows, err = s.db.Query(`
-			SELECT c.id, c.server_id, c.domain, c.issuer, c.issued_at, c.expires_at,
-			       c.status, c.auto_renew, c.last_checked_at, c.created_at, c.updated_at
-			FROM ssl_certificates c
-			INNER JOIN user_server_permissions p ON c.server_id = p.server_id
-			WHERE p.user_id = $1
-			ORDER BY c.expires_at ASC
+			SELECT `+sslCertificateColumns+`
+			FROM ssl_certificates
+			WHERE server_id IN (SELECT server_id FROM user_server_permissions WHERE user_id = $1)
+			ORDER BY expires_at ASC
 		`, userID)
 	}
 
@@ -87,11 +185,7 @@ func (s *SSLStore) List(userID string, isAdmin bool) ([]*SSLCertificate, error)
 
 	var certs []*SSLCertificate
 	for rows.Next() {
-		cert := &SSLCertificate{}
-		err := rows.Scan(
-			&cert.ID, &cert.ServerID, &cert.Domain, &cert.Issuer, &cert.IssuedAt, &cert.ExpiresAt,
-			&cert.Status, &cert.AutoRenew, &cert.LastCheckedAt, &cert.CreatedAt, &cert.UpdatedAt,
-		)
+		cert, err := scanSSLCertificate(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -101,13 +195,153 @@ func (s *SSLStore) List(userID string, isAdmin bool) ([]*SSLCertificate, error)
 	return certs, nil
 }
 
+// FindByEndpoint returns the certificate recorded for a server endpoint, or
+// nil if there is none.
+func (s *SSLStore) FindByEndpoint(serverID, host string, port int) (*SSLCertificate, error) {
+	cert, err := scanSSLCertificate(s.db.QueryRow(`
+		SELECT `+sslCertificateColumns+`
+		FROM ssl_certificates
+		WHERE server_id = $1 AND scan_host = $2 AND scan_port = $3
+		ORDER BY created_at
+		LIMIT 1
+	`, serverID, host, port))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return cert, err
+}
+
 func (s *SSLStore) Update(id string, cert *SSLCertificate) error {
 	_, err := s.db.Exec(`
 		UPDATE ssl_certificates
 		SET domain = $1, issuer = $2, issued_at = $3, expires_at = $4,
-		    status = $5, auto_renew = $6, updated_at = NOW()
-		WHERE id = $7
-	`, cert.Domain, cert.Issuer, cert.IssuedAt, cert.ExpiresAt, cert.Status, cert.AutoRenew, id)
+		    status = $5, auto_renew = $6, scan_host = $7, scan_port = $8, sni = $9, updated_at = NOW()
+		WHERE id = $10
+	`, cert.Domain, cert.Issuer, cert.IssuedAt, cert.ExpiresAt, cert.Status, cert.AutoRenew,
+		cert.ScanHost, cert.ScanPort, cert.SNI, id)
+	return err
+}
+
+// FindUploaded returns the certificate on a server matching an uploaded
+// certificate, preferring the same fingerprint over the same domain. It
+// returns nil if there is none.
+func (s *SSLStore) FindUploaded(serverID, domain, fingerprint string) (*SSLCertificate, error) {
+	cert, err := scanSSLCertificate(s.db.QueryRow(`
+		SELECT `+sslCertificateColumns+`
+		FROM ssl_certificates
+		WHERE server_id = $1 AND (fingerprint_sha256 = $3 OR (domain = $2 AND source IN ('manual', 'upload')))
+		ORDER BY (fingerprint_sha256 = $3) DESC NULLS LAST, created_at
+		LIMIT 1
+	`, serverID, domain, fingerprint))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return cert, err
+}
+
+// UpdateScanResult overwrites the certificate details and findings with what
+// was observed and clears any previous scan error.
+func (s *SSLStore) UpdateScanResult(id string, result *CertScanResult) error {
+	chain, err := json.Marshal(result.Chain)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE ssl_certificates
+		SET issuer = $2, issued_at = $3, expires_at = $4, subject = $5, serial_number = $6, sans = $7,
+		    key_type = $8, key_bits = $9, signature_algorithm = $10, fingerprint_sha256 = $11, chain = $12,
+		    last_scan_error = NULL, last_checked_at = NOW(), updated_at = NOW()
+		WHERE id = $1
+	`, id, result.Issuer, result.IssuedAt, result.ExpiresAt, result.Subject, result.SerialNumber, pq.Array(result.SANs),
+		result.KeyType, result.KeyBits, result.SignatureAlgorithm, result.FingerprintSHA256, string(chain))
+	if err != nil {
+		return err
+	}
+
+	if err := replaceFindings(tx, id, result.Findings); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceFindings upserts the current findings, keeping first_seen_at for
+// findings that were already present, and removes the ones that went away.
+func replaceFindings(tx *sql.Tx, certID string, findings []CertFinding) error {
+	now := time.Now()
+	keep := []string{}
+	for _, f := range findings {
+		var id string
+		err := tx.QueryRow(`
+			INSERT INTO ssl_certificate_findings (id, certificate_id, type, severity, subject, message, first_seen_at, last_seen_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+			ON CONFLICT (certificate_id, type, subject) DO UPDATE
+			SET severity = EXCLUDED.severity, message = EXCLUDED.message, last_seen_at = EXCLUDED.last_seen_at
+			RETURNING id
+		`, uuid.New().String(), certID, f.Type, f.Severity, f.Subject, f.Message, now).Scan(&id)
+		if err != nil {
+			return err
+		}
+		keep = append(keep, id)
+	}
+
+	_, err := tx.Exec(`DELETE FROM ssl_certificate_findings WHERE certificate_id = $1 AND NOT (id = ANY($2))`,
+		certID, pq.Array(keep))
+	return err
+}
+
+const certFindingColumns = `id, certificate_id, type, severity, subject, message, first_seen_at, last_seen_at`
+
+func (s *SSLStore) queryFindings(query string, args ...interface{}) ([]CertFinding, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	findings := []CertFinding{}
+	for rows.Next() {
+		var f CertFinding
+		err := rows.Scan(&f.ID, &f.CertificateID, &f.Type, &f.Severity, &f.Subject, &f.Message, &f.FirstSeenAt, &f.LastSeenAt)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, f)
+	}
+	return findings, rows.Err()
+}
+
+// ListFindings returns the current findings for one certificate.
+func (s *SSLStore) ListFindings(certID string) ([]CertFinding, error) {
+	return s.queryFindings(`SELECT `+certFindingColumns+` FROM ssl_certificate_findings WHERE certificate_id = $1 ORDER BY severity, type`, certID)
+}
+
+// FindingsByCertificate returns all current findings keyed by certificate ID.
+func (s *SSLStore) FindingsByCertificate() (map[string][]CertFinding, error) {
+	findings, err := s.queryFindings(`SELECT ` + certFindingColumns + ` FROM ssl_certificate_findings ORDER BY certificate_id, severity, type`)
+	if err != nil {
+		return nil, err
+	}
+	grouped := make(map[string][]CertFinding)
+	for _, f := range findings {
+		grouped[f.CertificateID] = append(grouped[f.CertificateID], f)
+	}
+	return grouped, nil
+}
+
+// RecordScanError notes a failed scan without touching the last known details.
+func (s *SSLStore) RecordScanError(id, message string) error {
+	_, err := s.db.Exec(`
+		UPDATE ssl_certificates
+		SET last_scan_error = $2, last_checked_at = NOW()
+		WHERE id = $1
+	`, id, message)
 	return err
 }
 
@@ -115,3 +349,26 @@ func (s *SSLStore) Delete(id string) error {
 	_, err := s.db.Exec("DELETE FROM ssl_certificates WHERE id = $1", id)
 	return err
 }
+
+// StorePrivateKey saves an already encrypted private key for a certificate,
+// replacing any previous one.
+func (s *SSLStore) StorePrivateKey(certID, keyType string, encryptedKey []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO ssl_certificate_keys (certificate_id, key_type, encrypted_key)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (certificate_id) DO UPDATE
+		SET key_type = EXCLUDED.key_type, encrypted_key = EXCLUDED.encrypted_key, updated_at = CURRENT_TIMESTAMP
+	`, certID, keyType, encryptedKey)
+	return err
+}
+
+// GetPrivateKey returns the encrypted private key for a certificate, or nil
+// if none is stored.
+func (s *SSLStore) GetPrivateKey(certID string) (keyType string, encryptedKey []byte, err error) {
+	err = s.db.QueryRow(`SELECT key_type, encrypted_key FROM ssl_certificate_keys WHERE certificate_id = $1`, certID).
+		Scan(&keyType, &encryptedKey)
+	if err == sql.ErrNoRows {
+		return "", nil, nil
+	}
+	return keyType, encryptedKey, err
+}
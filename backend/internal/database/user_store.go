@@ -17,8 +17,13 @@ func NewUserStore(db *sql.DB) *UserStore {
 	return &UserStore{db: db}
 }
 
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hashed), err
+}
+
 func (s *UserStore) Create(username, email, password string) (*User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(password)
 	if err != nil {
 		return nil, err
 	}
@@ -27,11 +32,12 @@ func (s *UserStore) Create(username, email, password string) (*User, error) {
 		ID:        uuid.New().String(),
 		Username:  username,
 		Email:     email,
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		Approved:  false,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
+	user.AuthProvider = AuthProviderLocal
 
 	query := `
 		INSERT INTO users (id, username, email, password, approved, created_at, updated_at)
@@ -52,14 +58,14 @@ func (s *UserStore) Create(username, email, password string) (*User, error) {
 func (s *UserStore) GetByEmail(email string) (*User, error) {
 	user := &User{}
 	query := `
-		SELECT id, username, email, password, display_name, approved, created_at, updated_at
+		SELECT id, username, email, password, display_name, approved, auth_provider, created_at, updated_at
 		FROM users
 		WHERE email = $1
 	`
 
 	err := s.db.QueryRow(query, email).Scan(
 		&user.ID, &user.Username, &user.Email, &user.Password, &user.DisplayName,
-		&user.Approved, &user.CreatedAt, &user.UpdatedAt,
+		&user.Approved, &user.AuthProvider, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -75,14 +81,14 @@ func (s *UserStore) GetByEmail(email string) (*User, error) {
 func (s *UserStore) GetByID(id string) (*User, error) {
 	user := &User{}
 	query := `
-		SELECT id, username, email, display_name, approved, created_at, updated_at
+		SELECT id, username, email, display_name, approved, auth_provider, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
 
 	err := s.db.QueryRow(query, id).Scan(
 		&user.ID, &user.Username, &user.Email, &user.DisplayName,
-		&user.Approved, &user.CreatedAt, &user.UpdatedAt,
+		&user.Approved, &user.AuthProvider, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err == sql.ErrNoRows {
@@ -97,7 +103,7 @@ func (s *UserStore) GetByID(id string) (*User, error) {
 
 func (s *UserStore) List() ([]*User, error) {
 	query := `
-		SELECT id, username, email, display_name, approved, created_at, updated_at
+		SELECT id, username, email, display_name, approved, auth_provider, created_at, updated_at
 		FROM users
 		ORDER BY created_at DESC
 	`
@@ -111,7 +117,7 @@ func (s *UserStore) List() ([]*User, error) {
 	var users []*User
 	for rows.Next() {
 		user := &User{}
-		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.Approved, &user.CreatedAt, &user.UpdatedAt)
+		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.Approved, &user.AuthProvider, &user.CreatedAt, &user.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -121,6 +127,88 @@ func (s *UserStore) List() ([]*User, error) {
 	return users, nil
 }
 
+// GetByExternalID finds the user an identity provider knows as externalID.
+func (s *UserStore) GetByExternalID(provider, externalID string) (*User, error) {
+	user := &User{}
+	query := `
+		SELECT id, username, email, display_name, approved, auth_provider, external_id, created_at, updated_at
+		FROM users
+		WHERE auth_provider = $1 AND external_id = $2
+	`
+
+	err := s.db.QueryRow(query, provider, externalID).Scan(
+		&user.ID, &user.Username, &user.Email, &user.DisplayName,
+		&user.Approved, &user.AuthProvider, &user.ExternalID, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// CreateExternal adds a user who signs in through an identity provider and
+// has no usable password.
+func (s *UserStore) CreateExternal(provider, externalID, username, email string, displayName *string, approved bool) (*User, error) {
+	user := &User{
+		ID:           uuid.New().String(),
+		Username:     username,
+		Email:        email,
+		DisplayName:  displayName,
+		Approved:     approved,
+		AuthProvider: provider,
+		ExternalID:   &externalID,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+
+	// No bcrypt hash starts with "!", so password logins always fail.
+	_, err := s.db.Exec(`
+		INSERT INTO users (id, username, email, password, display_name, approved, auth_provider, external_id, created_at, updated_at)
+		VALUES ($1, $2, $3, '!', $4, $5, $6, $7, $8, $9)
+	`, user.ID, user.Username, user.Email, user.DisplayName, user.Approved, provider, externalID, user.CreatedAt, user.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ListByProvider returns the users who sign in through an identity provider.
+func (s *UserStore) ListByProvider(provider string) ([]*User, error) {
+	rows, err := s.db.Query(`
+		SELECT id, username, email, display_name, approved, auth_provider, external_id, created_at, updated_at
+		FROM users
+		WHERE auth_provider = $1 AND external_id IS NOT NULL
+		ORDER BY created_at
+	`, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.Approved, &user.AuthProvider,
+			&user.ExternalID, &user.CreatedAt, &user.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// LinkExternal switches an existing user to signing in through an identity
+// provider.
+func (s *UserStore) LinkExternal(id, provider, externalID string) error {
+	_, err := s.db.Exec(`
+		UPDATE users SET auth_provider = $2, external_id = $3, updated_at = $4 WHERE id = $1
+	`, id, provider, externalID, time.Now())
+	return err
+}
+
 func (s *UserStore) Update(id string, displayName *string, approved *bool) error {
 	query := `
 		UPDATE users
@@ -134,6 +222,26 @@ func (s *UserStore) Update(id string, displayName *string, approved *bool) error
 	return err
 }
 
+// SetPassword replaces the password of a local user.
+func (s *UserStore) SetPassword(id, password string) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`
+		UPDATE users SET password = $2, updated_at = $3 WHERE id = $1 AND auth_provider = $4
+	`, id, hashedPassword, time.Now(), AuthProviderLocal)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (s *UserStore) VerifyPassword(hashedPassword, password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	return err == nil
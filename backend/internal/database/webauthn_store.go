@@ -0,0 +1,161 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// WebAuthnCredential is a security key or passkey registered by a user.
+type WebAuthnCredential struct {
+	ID              string     `json:"id"`
+	UserID          string     `json:"user_id"`
+	Name            string     `json:"name"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"-"`
+	Transports      []string   `json:"transports"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+}
+
+// WebAuthnCeremony is a pending registration or assertion.
+type WebAuthnCeremony struct {
+	Purpose string
+	// UserID is nil for passkey logins, where the user is not known until
+	// the assertion names them.
+	UserID      *string
+	SessionData []byte
+	ExpiresAt   time.Time
+}
+
+type WebAuthnStore struct {
+	db *sql.DB
+}
+
+func NewWebAuthnStore(db *sql.DB) *WebAuthnStore {
+	return &WebAuthnStore{db: db}
+}
+
+const webauthnCredentialColumns = `id, user_id, name, credential_id, public_key, attestation_type, transports, aaguid,
+	sign_count, backup_eligible, backup_state, created_at, last_used_at`
+
+func scanWebAuthnCredential(row interface{ Scan(...interface{}) error }) (*WebAuthnCredential, error) {
+	c := &WebAuthnCredential{}
+	var signCount int64
+	err := row.Scan(&c.ID, &c.UserID, &c.Name, &c.CredentialID, &c.PublicKey, &c.AttestationType, pq.Array(&c.Transports),
+		&c.AAGUID, &signCount, &c.BackupEligible, &c.BackupState, &c.CreatedAt, &c.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.SignCount = uint32(signCount)
+	return c, nil
+}
+
+func (s *WebAuthnStore) ListByUser(userID string) ([]*WebAuthnCredential, error) {
+	rows, err := s.db.Query(`
+		SELECT `+webauthnCredentialColumns+` FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creds := []*WebAuthnCredential{}
+	for rows.Next() {
+		c, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+// HasCredentials reports whether the user registered any credential.
+func (s *WebAuthnStore) HasCredentials(userID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM webauthn_credentials WHERE user_id = $1)`, userID).Scan(&exists)
+	return exists, err
+}
+
+func (s *WebAuthnStore) Create(c *WebAuthnCredential) error {
+	c.ID = uuid.New().String()
+	c.CreatedAt = time.Now()
+	if c.Transports == nil {
+		c.Transports = []string{}
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO webauthn_credentials (id, user_id, name, credential_id, public_key, attestation_type, transports, aaguid,
+			sign_count, backup_eligible, backup_state, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, c.ID, c.UserID, c.Name, c.CredentialID, c.PublicKey, c.AttestationType, pq.Array(c.Transports), c.AAGUID,
+		int64(c.SignCount), c.BackupEligible, c.BackupState, c.CreatedAt)
+	return err
+}
+
+// RecordUse stores the signature counter and backup state reported by an
+// assertion with the credential.
+func (s *WebAuthnStore) RecordUse(credentialID []byte, signCount uint32, backupState bool) error {
+	_, err := s.db.Exec(`
+		UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = $4 WHERE credential_id = $1
+	`, credentialID, int64(signCount), backupState, time.Now())
+	return err
+}
+
+// Delete removes one of the user's credentials, reporting whether it
+// existed.
+func (s *WebAuthnStore) Delete(userID, id string) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteByUser removes all of the user's credentials and returns how many
+// there were.
+func (s *WebAuthnStore) DeleteByUser(userID string) (int64, error) {
+	res, err := s.db.Exec(`DELETE FROM webauthn_credentials WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CreateCeremony stores a pending ceremony and drops expired ones.
+func (s *WebAuthnStore) CreateCeremony(idHash string, c *WebAuthnCeremony) error {
+	if _, err := s.db.Exec(`DELETE FROM webauthn_ceremonies WHERE expires_at < $1`, time.Now()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO webauthn_ceremonies (id_hash, purpose, user_id, session_data, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, idHash, c.Purpose, c.UserID, c.SessionData, c.ExpiresAt)
+	return err
+}
+
+// ConsumeCeremony removes and returns the pending ceremony for idHash begun
+// for one of purposes. It returns sql.ErrNoRows if there is none or it has
+// expired.
+func (s *WebAuthnStore) ConsumeCeremony(idHash string, purposes ...string) (*WebAuthnCeremony, error) {
+	c := &WebAuthnCeremony{}
+	err := s.db.QueryRow(`
+		DELETE FROM webauthn_ceremonies WHERE id_hash = $1 AND purpose = ANY($2)
+		RETURNING purpose, user_id, session_data, expires_at
+	`, idHash, pq.Array(purposes)).Scan(&c.Purpose, &c.UserID, &c.SessionData, &c.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(c.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+	return c, nil
+}
@@ -0,0 +1,97 @@
+// Package envconfig reads settings from environment variables on top of a
+// package's defaults. Every helper leaves its target alone when the variable
+// is unset, and logs and keeps the default when the value is invalid.
+package envconfig
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// String sets target to the variable's value unless it is empty.
+func String(name string, target *string) {
+	if v := os.Getenv(name); v != "" {
+		*target = v
+	}
+}
+
+func Bool(name string, target *bool) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	if b, err := strconv.ParseBool(v); err == nil {
+		*target = b
+	} else {
+		invalid(name, v)
+	}
+}
+
+// Int accepts integers of at least min.
+func Int(name string, target *int, min int) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= min {
+		*target = n
+	} else {
+		invalid(name, v)
+	}
+}
+
+// Int64 accepts integers of at least min, e.g. sizes in bytes.
+func Int64(name string, target *int64, min int64) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= min {
+		*target = n
+	} else {
+		invalid(name, v)
+	}
+}
+
+// Duration accepts positive durations only, so the result is always safe to
+// hand to time.NewTicker or context.WithTimeout.
+func Duration(name string, target *time.Duration) {
+	duration(name, target, false)
+}
+
+// DurationOrZero also accepts zero, for settings where zero turns something
+// off.
+func DurationOrZero(name string, target *time.Duration) {
+	duration(name, target, true)
+}
+
+// Days reads a whole number of days of at least min.
+func Days(name string, target *time.Duration, min int) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= min {
+		*target = time.Duration(n) * 24 * time.Hour
+	} else {
+		invalid(name, v)
+	}
+}
+
+func duration(name string, target *time.Duration, allowZero bool) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	if d, err := time.ParseDuration(v); err == nil && (d > 0 || allowZero && d == 0) {
+		*target = d
+	} else {
+		invalid(name, v)
+	}
+}
+
+func invalid(name, value string) {
+	log.Printf("Invalid %s %q, keeping default", name, value)
+}
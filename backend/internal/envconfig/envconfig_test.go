@@ -0,0 +1,98 @@
+package envconfig
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	cases := []struct {
+		value     string
+		allowZero bool
+		want      time.Duration
+	}{
+		{"", false, time.Minute},
+		{"30s", false, 30 * time.Second},
+		{"0", false, time.Minute},
+		{"0s", true, 0},
+		{"-1s", false, time.Minute},
+		{"-1s", true, time.Minute},
+		{"soon", true, time.Minute},
+	}
+	for _, c := range cases {
+		t.Setenv("TEST_DURATION", c.value)
+		got := time.Minute
+		if c.allowZero {
+			DurationOrZero("TEST_DURATION", &got)
+		} else {
+			Duration("TEST_DURATION", &got)
+		}
+		if got != c.want {
+			t.Errorf("%q (allowZero=%v): got %s, want %s", c.value, c.allowZero, got, c.want)
+		}
+	}
+}
+
+func TestInt(t *testing.T) {
+	cases := []struct {
+		value string
+		min   int
+		want  int
+	}{
+		{"", 1, 5},
+		{"7", 1, 7},
+		{"0", 1, 5},
+		{"0", 0, 0},
+		{"x", 0, 5},
+	}
+	for _, c := range cases {
+		t.Setenv("TEST_INT", c.value)
+		got := 5
+		Int("TEST_INT", &got, c.min)
+		if got != c.want {
+			t.Errorf("%q (min=%d): got %d, want %d", c.value, c.min, got, c.want)
+		}
+	}
+}
+
+func TestInt64(t *testing.T) {
+	t.Setenv("TEST_INT64", "8589934592")
+	got := int64(5)
+	Int64("TEST_INT64", &got, 1)
+	if got != 8<<30 {
+		t.Errorf("got %d, want %d", got, int64(8<<30))
+	}
+	t.Setenv("TEST_INT64", "-1")
+	Int64("TEST_INT64", &got, 1)
+	if got != 8<<30 {
+		t.Errorf("a value below min changed the value to %d", got)
+	}
+}
+
+func TestDays(t *testing.T) {
+	t.Setenv("TEST_DAYS", "2")
+	got := time.Hour
+	Days("TEST_DAYS", &got, 1)
+	if got != 48*time.Hour {
+		t.Errorf("got %s, want 48h", got)
+	}
+	t.Setenv("TEST_DAYS", "0")
+	Days("TEST_DAYS", &got, 1)
+	if got != 48*time.Hour {
+		t.Errorf("zero days with min 1 changed the value to %s", got)
+	}
+}
+
+func TestBool(t *testing.T) {
+	t.Setenv("TEST_BOOL", "maybe")
+	got := true
+	Bool("TEST_BOOL", &got)
+	if !got {
+		t.Error("invalid value changed the default")
+	}
+	t.Setenv("TEST_BOOL", "false")
+	Bool("TEST_BOOL", &got)
+	if got {
+		t.Error("false was not applied")
+	}
+}
@@ -0,0 +1,51 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+type Config struct {
+	// Concurrency is how many hosts a job runs on at once unless the job
+	// asks for another value, which may not exceed MaxConcurrency.
+	Concurrency    int
+	MaxConcurrency int
+	// HostTimeout bounds connecting and running the command on one host
+	// unless the job asks for another value up to MaxHostTimeout.
+	HostTimeout    time.Duration
+	MaxHostTimeout time.Duration
+	// MaxOutput is how many bytes of output are kept per host.
+	MaxOutput int
+	// Retention is how long finished jobs are kept; zero keeps them forever.
+	Retention time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Concurrency:    10,
+		MaxConcurrency: 50,
+		HostTimeout:    time.Minute,
+		MaxHostTimeout: time.Hour,
+		MaxOutput:      256 << 10,
+		Retention:      30 * 24 * time.Hour,
+	}
+}
+
+// ConfigFromEnv reads JOBS_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Int("JOBS_CONCURRENCY", &cfg.Concurrency, 1)
+	envconfig.Int("JOBS_MAX_CONCURRENCY", &cfg.MaxConcurrency, 1)
+	envconfig.Duration("JOBS_HOST_TIMEOUT", &cfg.HostTimeout)
+	envconfig.Duration("JOBS_MAX_HOST_TIMEOUT", &cfg.MaxHostTimeout)
+	envconfig.Int("JOBS_MAX_OUTPUT", &cfg.MaxOutput, 0)
+	envconfig.Days("JOBS_RETENTION_DAYS", &cfg.Retention, 0)
+	if cfg.Concurrency > cfg.MaxConcurrency {
+		cfg.Concurrency = cfg.MaxConcurrency
+	}
+	if cfg.HostTimeout > cfg.MaxHostTimeout {
+		cfg.HostTimeout = cfg.MaxHostTimeout
+	}
+	return cfg
+}
@@ -0,0 +1,450 @@
+// Package jobs runs ad-hoc commands over SSH on many servers at once and
+// streams their output to subscribers while storing the results.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient"
+	"golang.org/x/crypto/ssh"
+)
+
+// Event types sent to subscribers.
+const (
+	EventHostStarted  = "host_started"
+	EventOutput       = "output"
+	EventHostFinished = "host_finished"
+	EventJobFinished  = "job_finished"
+)
+
+// Event is a progress update of a running job. Output events carry stdout
+// and stderr interleaved as the host produced them.
+type Event struct {
+	Type      string `json:"type"`
+	HostID    string `json:"host_id,omitempty"`
+	ServerID  string `json:"server_id,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Status    string `json:"status,omitempty"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Succeeded int    `json:"succeeded,omitempty"`
+	Failed    int    `json:"failed,omitempty"`
+}
+
+// HostEvents describes a stored host result as the events a subscriber of
+// the running job would have seen.
+func HostEvents(h *database.CommandJobHost) []Event {
+	var events []Event
+	serverID := ""
+	if h.ServerID != nil {
+		serverID = *h.ServerID
+	}
+	base := Event{HostID: h.ID, ServerID: serverID, Hostname: h.Hostname}
+	if h.StartedAt != nil {
+		e := base
+		e.Type = EventHostStarted
+		events = append(events, e)
+	}
+	if h.Output != "" {
+		e := base
+		e.Type, e.Data = EventOutput, h.Output
+		events = append(events, e)
+	}
+	if h.FinishedAt != nil {
+		e := base
+		e.Type, e.Status, e.ExitCode, e.Truncated = EventHostFinished, h.Status, h.ExitCode, h.OutputTruncated
+		if h.Error != nil {
+			e.Error = *h.Error
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// JobFinishedEvent describes the outcome of a finished job.
+func JobFinishedEvent(j *database.CommandJob) Event {
+	return Event{Type: EventJobFinished, Status: j.Status, Succeeded: j.Succeeded, Failed: j.Failed}
+}
+
+// Runner executes command jobs.
+type Runner struct {
+	stores *database.Stores
+	// dial connects to a host, through the sshclient.Dialer outside tests.
+	dial func(ctx context.Context, server *database.Server) (*ssh.Client, error)
+	cfg  Config
+
+	mu   sync.Mutex
+	live map[string]*liveJob
+}
+
+func New(stores *database.Stores, dialer *sshclient.Dialer, cfg Config) *Runner {
+	return &Runner{stores: stores, dial: dialer.Dial, cfg: cfg, live: make(map[string]*liveJob)}
+}
+
+// Limits returns the default and maximum concurrency and host timeout.
+func (r *Runner) Limits() (concurrency, maxConcurrency int, timeout, maxTimeout time.Duration) {
+	return r.cfg.Concurrency, r.cfg.MaxConcurrency, r.cfg.HostTimeout, r.cfg.MaxHostTimeout
+}
+
+// liveJob is the in-memory state of a running job; mu guards the host
+// outputs and subscribers.
+type liveJob struct {
+	job    *database.CommandJob
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	hosts []*hostRun
+	subs  map[chan Event]struct{}
+	done  bool
+}
+
+type hostRun struct {
+	host   *database.CommandJobHost
+	server *database.Server
+	output strings.Builder
+	// partial holds a trailing incomplete UTF-8 sequence until the rest
+	// arrives.
+	partial []byte
+}
+
+// Start stores job with one host per server and runs it in the background.
+// job.Command, Selector, CreatedBy, Concurrency and TimeoutSeconds must be
+// set.
+func (r *Runner) Start(job *database.CommandJob, servers []*database.Server) error {
+	job.Status = database.JobRunning
+	job.CreatedAt = time.Now()
+
+	lj := &liveJob{job: job}
+	hosts := make([]*database.CommandJobHost, len(servers))
+	for i, s := range servers {
+		id := s.ID
+		hosts[i] = &database.CommandJobHost{ServerID: &id, Hostname: s.Hostname, Status: database.HostPending}
+		lj.hosts = append(lj.hosts, &hostRun{host: hosts[i], server: s})
+	}
+	if err := r.stores.CommandJobs.CreateJob(job, hosts); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lj.cancel = cancel
+	r.mu.Lock()
+	r.live[job.ID] = lj
+	r.mu.Unlock()
+
+	go r.run(ctx, lj)
+	return nil
+}
+
+// Cancel stops a running job; hosts that have not finished are cancelled.
+// It reports whether the job was running.
+func (r *Runner) Cancel(jobID string) bool {
+	r.mu.Lock()
+	lj := r.live[jobID]
+	r.mu.Unlock()
+	if lj == nil {
+		return false
+	}
+	lj.cancel()
+	return true
+}
+
+// Subscribe returns the events of a running job so far and a channel of the
+// events that follow, which is closed when the job finishes or the
+// subscriber falls behind. ok is false if the job is not running.
+func (r *Runner) Subscribe(jobID string) (snapshot []Event, events <-chan Event, cancel func(), ok bool) {
+	r.mu.Lock()
+	lj := r.live[jobID]
+	r.mu.Unlock()
+	if lj == nil {
+		return nil, nil, nil, false
+	}
+
+	lj.mu.Lock()
+	defer lj.mu.Unlock()
+	if lj.done {
+		return nil, nil, nil, false
+	}
+	for _, h := range lj.hosts {
+		host := *h.host
+		host.Output = h.output.String()
+		snapshot = append(snapshot, HostEvents(&host)...)
+	}
+
+	ch := make(chan Event, 256)
+	if lj.subs == nil {
+		lj.subs = make(map[chan Event]struct{})
+	}
+	lj.subs[ch] = struct{}{}
+	cancel = func() {
+		lj.mu.Lock()
+		defer lj.mu.Unlock()
+		if _, ok := lj.subs[ch]; ok {
+			delete(lj.subs, ch)
+			close(ch)
+		}
+	}
+	return snapshot, ch, cancel, true
+}
+
+// publish sends e to every subscriber; callers hold lj.mu. Subscribers that
+// cannot keep up are dropped rather than stalling the job.
+func (lj *liveJob) publish(e Event) {
+	for ch := range lj.subs {
+		select {
+		case ch <- e:
+		default:
+			delete(lj.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (r *Runner) run(ctx context.Context, lj *liveJob) {
+	job := lj.job
+	sem := make(chan struct{}, job.Concurrency)
+	var wg sync.WaitGroup
+	for _, h := range lj.hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(h *hostRun) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r.runHost(ctx, lj, h)
+		}(h)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.Status = database.JobComplete
+	if ctx.Err() != nil {
+		job.Status = database.JobCancelled
+	}
+	for _, h := range lj.hosts {
+		if h.host.Status == database.HostSucceeded {
+			job.Succeeded++
+		} else {
+			job.Failed++
+		}
+	}
+	if err := r.stores.CommandJobs.FinishJob(job); err != nil {
+		log.Printf("Failed to store result of job %s: %v", job.ID, err)
+	}
+
+	r.mu.Lock()
+	delete(r.live, job.ID)
+	r.mu.Unlock()
+
+	lj.mu.Lock()
+	lj.publish(JobFinishedEvent(job))
+	for ch := range lj.subs {
+		close(ch)
+	}
+	lj.subs = nil
+	lj.done = true
+	lj.mu.Unlock()
+	lj.cancel()
+}
+
+func (r *Runner) runHost(ctx context.Context, lj *liveJob, h *hostRun) {
+	if ctx.Err() == nil {
+		now := time.Now()
+		if err := r.stores.CommandJobs.StartHost(h.host.ID, now); err != nil {
+			log.Printf("Failed to store start of job %s on %s: %v", lj.job.ID, h.host.Hostname, err)
+		}
+		lj.mu.Lock()
+		h.host.Status = database.HostRunning
+		h.host.StartedAt = &now
+		lj.publish(Event{Type: EventHostStarted, HostID: h.host.ID, ServerID: h.server.ID, Hostname: h.host.Hostname})
+		lj.mu.Unlock()
+	}
+
+	status, exitCode, err := r.execute(ctx, lj, h)
+
+	now := time.Now()
+	lj.mu.Lock()
+	h.flush(r.cfg.MaxOutput)
+	h.host.Status = status
+	h.host.ExitCode = exitCode
+	h.host.Output = h.output.String()
+	h.host.FinishedAt = &now
+	if err != nil {
+		msg := err.Error()
+		h.host.Error = &msg
+	}
+	host := *h.host
+	lj.mu.Unlock()
+
+	if err := r.stores.CommandJobs.FinishHost(&host); err != nil {
+		log.Printf("Failed to store result of job %s on %s: %v", lj.job.ID, host.Hostname, err)
+	}
+
+	lj.mu.Lock()
+	host.Output = ""
+	for _, e := range HostEvents(&host) {
+		if e.Type == EventHostFinished {
+			lj.publish(e)
+		}
+	}
+	lj.mu.Unlock()
+}
+
+// execute runs the job's command on one host within the job's host timeout.
+func (r *Runner) execute(ctx context.Context, lj *liveJob, h *hostRun) (string, *int, error) {
+	if ctx.Err() != nil {
+		return database.HostCancelled, nil, nil
+	}
+	hctx, cancel := context.WithTimeout(ctx, time.Duration(lj.job.TimeoutSeconds)*time.Second)
+	defer cancel()
+	stopped := func(err error) (string, *int, error) {
+		if ctx.Err() != nil {
+			return database.HostCancelled, nil, nil
+		}
+		if hctx.Err() != nil {
+			return database.HostTimedOut, nil, fmt.Errorf("timed out after %ds", lj.job.TimeoutSeconds)
+		}
+		return database.HostError, nil, err
+	}
+
+	client, err := r.dial(hctx, h.server)
+	if err != nil {
+		return stopped(err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return stopped(err)
+	}
+	defer session.Close()
+
+	out := &hostWriter{lj: lj, h: h, max: r.cfg.MaxOutput}
+	session.Stdout = out
+	session.Stderr = out
+	if err := session.Start(lj.job.Command); err != nil {
+		return stopped(err)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- session.Wait() }()
+	select {
+	case err = <-waited:
+	case <-hctx.Done():
+		session.Signal(ssh.SIGKILL)
+		client.Close()
+		<-waited
+		return stopped(hctx.Err())
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		code := 0
+		return database.HostSucceeded, &code, nil
+	case errors.As(err, &exitErr):
+		if exitErr.Signal() != "" {
+			return database.HostFailed, nil, fmt.Errorf("killed by signal %s", exitErr.Signal())
+		}
+		code := exitErr.ExitStatus()
+		return database.HostFailed, &code, nil
+	}
+	return stopped(err)
+}
+
+// hostWriter collects a host's output and streams it to subscribers.
+type hostWriter struct {
+	lj  *liveJob
+	h   *hostRun
+	max int
+}
+
+func (w *hostWriter) Write(p []byte) (int, error) {
+	w.lj.mu.Lock()
+	defer w.lj.mu.Unlock()
+
+	data := append(w.h.partial, p...)
+	cut := len(data)
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				cut = len(data) - i
+			}
+			break
+		}
+	}
+	w.h.partial = append([]byte(nil), data[cut:]...)
+	w.h.append(w.lj, data[:cut], w.max)
+	return len(p), nil
+}
+
+// append keeps as much of data as fits in max bytes and streams it; callers
+// hold lj.mu.
+func (h *hostRun) append(lj *liveJob, data []byte, max int) {
+	if len(data) == 0 || h.host.OutputTruncated {
+		return
+	}
+	// Postgres text columns take neither NUL bytes nor invalid UTF-8.
+	text := strings.ToValidUTF8(strings.ReplaceAll(string(data), "\x00", ""), "�")
+	if room := max - h.output.Len(); len(text) > room {
+		if room < 0 {
+			room = 0
+		}
+		for room > 0 && !utf8.RuneStart(text[room]) {
+			room--
+		}
+		text = text[:room]
+		h.host.OutputTruncated = true
+	}
+	if text == "" {
+		return
+	}
+	h.output.WriteString(text)
+	lj.publish(Event{Type: EventOutput, HostID: h.host.ID, ServerID: h.server.ID, Hostname: h.host.Hostname, Data: text})
+}
+
+// flush writes out a trailing partial rune once the command has ended.
+func (h *hostRun) flush(max int) {
+	if len(h.partial) > 0 && !h.host.OutputTruncated {
+		if h.output.Len()+len(h.partial) <= max {
+			h.output.WriteString(strings.ToValidUTF8(string(h.partial), "�"))
+		} else {
+			h.host.OutputTruncated = true
+		}
+	}
+	h.partial = nil
+}
+
+// Run marks jobs left running by a previous run as interrupted and then
+// deletes expired jobs hourly until ctx is cancelled.
+func (r *Runner) Run(ctx context.Context) {
+	if n, err := r.stores.CommandJobs.InterruptRunning(time.Now()); err != nil {
+		log.Printf("Failed to mark interrupted jobs: %v", err)
+	} else if n > 0 {
+		log.Printf("Marked %d unfinished command jobs as interrupted", n)
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if r.cfg.Retention > 0 {
+			if _, err := r.stores.CommandJobs.DeleteFinishedBefore(time.Now().Add(-r.cfg.Retention)); err != nil {
+				log.Printf("Failed to delete expired command jobs: %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
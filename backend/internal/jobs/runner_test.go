@@ -0,0 +1,223 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient/sshtest"
+	"golang.org/x/crypto/ssh"
+)
+
+func testJob(command string, timeout int) (*liveJob, *hostRun) {
+	h := &hostRun{
+		host:   &database.CommandJobHost{ID: "host-1", Hostname: "web-1", Status: database.HostPending},
+		server: &database.Server{ID: "server-1", Hostname: "web-1"},
+	}
+	lj := &liveJob{
+		job:    &database.CommandJob{ID: "job-1", Command: command, Concurrency: 1, TimeoutSeconds: timeout},
+		cancel: func() {},
+		hosts:  []*hostRun{h},
+	}
+	return lj, h
+}
+
+func TestHostWriter(t *testing.T) {
+	tests := []struct {
+		name          string
+		max           int
+		writes        []string
+		wantOutput    string
+		wantTruncated bool
+	}{
+		{"plain", 100, []string{"hello ", "world"}, "hello world", false},
+		{"rune split across writes", 100, []string{"caf\xc3", "\xa9!"}, "café!", false},
+		{"cut at the cap", 5, []string{"abcdef"}, "abcde", true},
+		{"cap inside a rune", 4, []string{"abcé"}, "abc", true},
+		{"nothing kept after truncation", 3, []string{"abcd", "e"}, "abc", true},
+		{"NUL and invalid bytes", 100, []string{"a\x00b\xffc"}, "ab�c", false},
+		{"partial rune at the end", 100, []string{"ab\xe2\x82"}, "ab�", false},
+		{"partial rune beyond the cap", 3, []string{"ab\xe2\x82"}, "ab", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lj, h := testJob("true", 1)
+			events := make(chan Event, 16)
+			lj.subs = map[chan Event]struct{}{events: {}}
+
+			w := &hostWriter{lj: lj, h: h, max: tt.max}
+			for _, p := range tt.writes {
+				if n, err := w.Write([]byte(p)); n != len(p) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", p, n, err)
+				}
+			}
+			h.flush(tt.max)
+
+			if got := h.output.String(); got != tt.wantOutput {
+				t.Errorf("output = %q, want %q", got, tt.wantOutput)
+			}
+			if h.host.OutputTruncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", h.host.OutputTruncated, tt.wantTruncated)
+			}
+			close(events)
+			var streamed strings.Builder
+			for e := range events {
+				if !utf8.ValidString(e.Data) {
+					t.Errorf("streamed invalid UTF-8 %q", e.Data)
+				}
+				streamed.WriteString(e.Data)
+			}
+			if !strings.HasPrefix(tt.wantOutput, streamed.String()) {
+				t.Errorf("streamed %q, want a prefix of %q", streamed.String(), tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	lj, h := testJob("uptime", 1)
+	r := &Runner{live: map[string]*liveJob{lj.job.ID: lj}}
+	now := time.Now()
+	h.host.Status, h.host.StartedAt = database.HostRunning, &now
+	h.output.WriteString("up 3 days")
+
+	snapshot, events, cancel, ok := r.Subscribe(lj.job.ID)
+	if !ok {
+		t.Fatal("Subscribe() of a running job = not ok")
+	}
+	if len(snapshot) != 2 || snapshot[0].Type != EventHostStarted || snapshot[1].Data != "up 3 days" {
+		t.Errorf("snapshot = %+v, want the host start and its output so far", snapshot)
+	}
+	cancel()
+	if _, open := <-events; open {
+		t.Error("events still open after cancel")
+	}
+	cancel()
+
+	if _, _, _, ok := r.Subscribe("job-2"); ok {
+		t.Error("Subscribe() of an unknown job = ok")
+	}
+	lj.done = true
+	if _, _, _, ok := r.Subscribe(lj.job.ID); ok {
+		t.Error("Subscribe() of a finished job = ok")
+	}
+}
+
+func TestPublishDropsSlowSubscriber(t *testing.T) {
+	lj, _ := testJob("yes", 1)
+	r := &Runner{live: map[string]*liveJob{lj.job.ID: lj}}
+	_, slow, _, _ := r.Subscribe(lj.job.ID)
+	_, fast, _, _ := r.Subscribe(lj.job.ID)
+
+	const sent = 300
+	for i := 0; i < sent; i++ {
+		lj.mu.Lock()
+		lj.publish(Event{Type: EventOutput, Data: "y\n"})
+		lj.mu.Unlock()
+		if e := <-fast; e.Data != "y\n" {
+			t.Fatalf("fast subscriber got %+v", e)
+		}
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received == 0 || received >= sent {
+		t.Errorf("slow subscriber got %d of %d events before being dropped", received, sent)
+	}
+	lj.mu.Lock()
+	defer lj.mu.Unlock()
+	if len(lj.subs) != 1 {
+		t.Errorf("subscribers = %d, want only the fast one", len(lj.subs))
+	}
+}
+
+// testHost starts an SSH server whose commands behave as their names say.
+func testHost(t *testing.T) (*sshtest.Server, func(context.Context, *database.Server) (*ssh.Client, error)) {
+	signer, _ := sshtest.ClientKey(t)
+	srv := sshtest.NewServer(t, "web-1", signer.PublicKey())
+	srv.SetExec(func(command string, ch ssh.Channel, closed <-chan struct{}) uint32 {
+		switch command {
+		case "hello":
+			io.WriteString(ch, "hello\n")
+		case "fail":
+			io.WriteString(ch.Stderr(), "no such file\n")
+			return 2
+		case "hang":
+			io.WriteString(ch, "waiting\n")
+			<-closed
+		}
+		return 0
+	})
+	return srv, func(context.Context, *database.Server) (*ssh.Client, error) {
+		return ssh.Dial("tcp", srv.Addr, srv.ClientConfig(signer))
+	}
+}
+
+func TestExecute(t *testing.T) {
+	srv, dial := testHost(t)
+	code := func(c int) *int { return &c }
+
+	tests := []struct {
+		name       string
+		command    string
+		timeout    int
+		cancel     time.Duration // <0 cancels before starting
+		dialErr    error
+		wantStatus string
+		wantCode   *int
+		wantErr    string
+		wantOutput string
+	}{
+		{name: "success", command: "hello", timeout: 5, wantStatus: database.HostSucceeded, wantCode: code(0), wantOutput: "hello\n"},
+		{name: "exit status", command: "fail", timeout: 5, wantStatus: database.HostFailed, wantCode: code(2), wantOutput: "no such file\n"},
+		{name: "timeout", command: "hang", timeout: 1, wantStatus: database.HostTimedOut, wantErr: "timed out after 1s", wantOutput: "waiting\n"},
+		{name: "cancelled while running", command: "hang", timeout: 5, cancel: 200 * time.Millisecond, wantStatus: database.HostCancelled, wantOutput: "waiting\n"},
+		{name: "cancelled before starting", command: "hello", timeout: 5, cancel: -1, wantStatus: database.HostCancelled},
+		{name: "unreachable", command: "hello", timeout: 5, dialErr: errors.New("connection refused"), wantStatus: database.HostError, wantErr: "connection refused"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Runner{dial: dial, cfg: Config{MaxOutput: 1024}}
+			if tt.dialErr != nil {
+				r.dial = func(context.Context, *database.Server) (*ssh.Client, error) { return nil, tt.dialErr }
+			}
+			lj, h := testJob(tt.command, tt.timeout)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			switch {
+			case tt.cancel < 0:
+				cancel()
+			case tt.cancel > 0:
+				time.AfterFunc(tt.cancel, cancel)
+			}
+
+			status, exitCode, err := r.execute(ctx, lj, h)
+			if status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", status, tt.wantStatus)
+			}
+			if (exitCode == nil) != (tt.wantCode == nil) || exitCode != nil && *exitCode != *tt.wantCode {
+				t.Errorf("exit code = %v, want %v", exitCode, tt.wantCode)
+			}
+			gotErr := ""
+			if err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != tt.wantErr {
+				t.Errorf("error = %q, want %q", gotErr, tt.wantErr)
+			}
+			if got := h.output.String(); got != tt.wantOutput {
+				t.Errorf("output = %q, want %q", got, tt.wantOutput)
+			}
+			srv.WaitIdle(t)
+		})
+	}
+}
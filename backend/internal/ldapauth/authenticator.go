@@ -0,0 +1,265 @@
+// Package ldapauth authenticates users against an LDAP directory such as
+// OpenLDAP or Active Directory.
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	auditUserProvisioned = "user.provisioned"
+	auditUserLinked      = "user.linked"
+	auditUserDisabled    = "user.disabled"
+)
+
+// errNotFound means no directory entry matched a filter.
+var errNotFound = errors.New("no matching entry")
+
+// Authenticator binds as users in the directory, provisions them on their
+// first login and maps their groups to roles and server access.
+type Authenticator struct {
+	stores  *database.Stores
+	cfg     Config
+	tls     *tls.Config
+	revoked *auth.RevocationList
+}
+
+func New(stores *database.Stores, cfg Config, revoked *auth.RevocationList) (*Authenticator, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("LDAP_URL %q must be an ldap:// or ldaps:// URL", cfg.URL)
+	}
+	if cfg.BaseDN == "" {
+		return nil, errors.New("LDAP_BASE_DN is required")
+	}
+	if !strings.Contains(cfg.UserFilter, "{login}") {
+		return nil, errors.New("LDAP_USER_FILTER must contain {login}")
+	}
+	if cfg.GroupFilter != "" && !strings.Contains(cfg.GroupFilter, "{dn}") {
+		return nil, errors.New("LDAP_GROUP_FILTER must contain {dn}")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if cfg.CACertFile != "" {
+		pem, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &Authenticator{stores: stores, cfg: cfg, tls: tlsConfig, revoked: revoked}, nil
+}
+
+func (a *Authenticator) Name() string {
+	return database.AuthProviderLDAP
+}
+
+// Authenticate looks the login name up with the service account, checks
+// the password by binding as the user's entry and returns the matching
+// local user with roles updated from the user's groups.
+func (a *Authenticator) Authenticate(ctx context.Context, login, password string) (*database.User, error) {
+	// An empty password would be an unauthenticated bind, which succeeds
+	if login == "" || password == "" {
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	conn, closeConn, err := a.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeConn()
+
+	filter := strings.ReplaceAll(a.cfg.UserFilter, "{login}", ldap.EscapeFilter(login))
+	entry, err := a.findUser(conn, filter)
+	if errors.Is(err, errNotFound) {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	groups, err := a.groups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, auth.ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	user, err := a.user(entry)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := a.stores.GroupMappings.Apply(user.ID, database.AuthProviderLDAP, groups); err != nil {
+		return nil, fmt.Errorf("applying group mappings: %w", err)
+	}
+	return user, nil
+}
+
+// dial connects and binds as the service account. The connection is also
+// closed when ctx is done, interrupting a slow directory.
+func (a *Authenticator) dial(ctx context.Context) (*ldap.Conn, func(), error) {
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.cfg.Timeout}),
+		ldap.DialWithTLSConfig(a.tls))
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	closeConn := func() {
+		stop()
+		conn.Close()
+	}
+
+	if a.cfg.StartTLS && strings.HasPrefix(a.cfg.URL, "ldap://") {
+		if err := conn.StartTLS(a.tls); err != nil {
+			closeConn()
+			return nil, nil, fmt.Errorf("StartTLS: %w", err)
+		}
+	}
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			closeConn()
+			return nil, nil, fmt.Errorf("service account bind: %w", err)
+		}
+	}
+	return conn, closeConn, nil
+}
+
+// findUser returns the single user entry matching filter.
+func (a *Authenticator) findUser(conn *ldap.Conn, filter string) (*ldap.Entry, error) {
+	attrs := []string{a.cfg.IDAttribute, a.cfg.UsernameAttribute, a.cfg.EmailAttribute, a.cfg.NameAttribute}
+	if a.cfg.GroupFilter == "" {
+		attrs = append(attrs, a.cfg.GroupAttribute)
+	}
+	res, err := conn.Search(ldap.NewSearchRequest(a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, 0, false, filter, attrs, nil))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	switch {
+	case res == nil || len(res.Entries) == 0:
+		return nil, errNotFound
+	case len(res.Entries) > 1:
+		// An ambiguous login must not pick one of the accounts
+		log.Printf("LDAP filter %s matches more than one entry", filter)
+		return nil, errNotFound
+	}
+	return res.Entries[0], nil
+}
+
+// groups returns the DNs of the groups a user is a member of.
+func (a *Authenticator) groups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	if a.cfg.GroupFilter == "" {
+		groups := entry.GetAttributeValues(a.cfg.GroupAttribute)
+		if groups == nil {
+			groups = []string{}
+		}
+		return groups, nil
+	}
+
+	filter := strings.ReplaceAll(a.cfg.GroupFilter, "{dn}", ldap.EscapeFilter(entry.DN))
+	res, err := conn.Search(ldap.NewSearchRequest(a.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, filter, []string{"1.1"}, nil))
+	if err != nil {
+		return nil, fmt.Errorf("group search: %w", err)
+	}
+	groups := []string{}
+	for _, g := range res.Entries {
+		groups = append(groups, g.DN)
+	}
+	return groups, nil
+}
+
+// user returns the local user of a directory entry, linking or creating it
+// on first login.
+func (a *Authenticator) user(entry *ldap.Entry) (*database.User, error) {
+	id := entry.GetAttributeValue(a.cfg.IDAttribute)
+	if id == "" {
+		return nil, fmt.Errorf("entry %s has no %s attribute", entry.DN, a.cfg.IDAttribute)
+	}
+	user, err := a.stores.Users.GetByExternalID(database.AuthProviderLDAP, id)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	email := entry.GetAttributeValue(a.cfg.EmailAttribute)
+	if email == "" {
+		log.Printf("LDAP login of %s: entry has no %s attribute", entry.DN, a.cfg.EmailAttribute)
+		return nil, auth.ErrInvalidCredentials
+	}
+	if existing, err := a.stores.Users.GetByEmail(email); err == nil {
+		if !a.cfg.LinkByEmail || existing.AuthProvider != database.AuthProviderLocal {
+			log.Printf("LDAP login of %s: email %s belongs to another account", entry.DN, email)
+			return nil, auth.ErrInvalidCredentials
+		}
+		if err := a.stores.Users.LinkExternal(existing.ID, database.AuthProviderLDAP, id); err != nil {
+			return nil, err
+		}
+		existing.AuthProvider = database.AuthProviderLDAP
+		a.audit(existing.ID, auditUserLinked, existing.ID, map[string]interface{}{
+			"provider": database.AuthProviderLDAP,
+			"dn":       entry.DN,
+		})
+		return existing, nil
+	}
+
+	username := entry.GetAttributeValue(a.cfg.UsernameAttribute)
+	if username == "" {
+		username = email
+	}
+	var displayName *string
+	if name := entry.GetAttributeValue(a.cfg.NameAttribute); name != "" {
+		displayName = &name
+	}
+	user, err = a.stores.Users.CreateExternal(database.AuthProviderLDAP, id, username, email, displayName, a.cfg.AutoApprove)
+	if err != nil {
+		return nil, err
+	}
+	a.audit(user.ID, auditUserProvisioned, user.ID, map[string]interface{}{
+		"provider": database.AuthProviderLDAP,
+		"dn":       entry.DN,
+		"email":    user.Email,
+		"approved": user.Approved,
+	})
+	return user, nil
+}
+
+func (a *Authenticator) audit(actorID, action, userID string, details map[string]interface{}) {
+	if err := a.stores.Audit.Record(actorID, action, "user", userID, details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
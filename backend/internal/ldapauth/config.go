@@ -0,0 +1,100 @@
+package ldapauth
+
+import (
+	"os"
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+type Config struct {
+	Enabled bool
+	// URL is ldap://host:389, or ldaps://host:636 for TLS from the start.
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS before binding.
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// CACertFile is a PEM bundle trusted for the directory's certificate in
+	// addition to the system roots.
+	CACertFile string
+	// BindDN and BindPassword are the service account used to look up users;
+	// empty binds anonymously.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the entry of a login name; {login} is replaced by the
+	// escaped name.
+	UserFilter string
+	// IDAttribute holds a stable identifier of a user, such as uid,
+	// sAMAccountName or entryUUID.
+	IDAttribute       string
+	UsernameAttribute string
+	EmailAttribute    string
+	NameAttribute     string
+	// GroupAttribute lists the DNs of a user's groups, as memberOf does in
+	// Active Directory and OpenLDAP with the memberof overlay.
+	GroupAttribute string
+	// GroupFilter, if set, finds a user's groups under GroupBaseDN instead;
+	// {dn} is replaced by the escaped DN of the user.
+	GroupFilter string
+	GroupBaseDN string
+	// SyncFilter is ANDed with the ID lookup when syncing, so that entries
+	// that still exist but are disabled, e.g. in Active Directory, count as
+	// removed.
+	SyncFilter string
+	// SyncInterval is how often users are checked against the directory;
+	// zero disables the sync.
+	SyncInterval time.Duration
+	// AutoApprove approves users provisioned on their first login.
+	AutoApprove bool
+	// LinkByEmail lets an existing local account with the same email
+	// address sign in through the directory.
+	LinkByEmail bool
+	Timeout     time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:           false,
+		UserFilter:        "(&(objectClass=person)(|(uid={login})(mail={login})))",
+		IDAttribute:       "uid",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		NameAttribute:     "cn",
+		GroupAttribute:    "memberOf",
+		SyncInterval:      time.Hour,
+		AutoApprove:       true,
+		LinkByEmail:       true,
+		Timeout:           10 * time.Second,
+	}
+}
+
+// ConfigFromEnv reads LDAP_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Bool("LDAP_ENABLED", &cfg.Enabled)
+	cfg.URL = os.Getenv("LDAP_URL")
+	envconfig.Bool("LDAP_START_TLS", &cfg.StartTLS)
+	envconfig.Bool("LDAP_INSECURE_SKIP_VERIFY", &cfg.InsecureSkipVerify)
+	cfg.CACertFile = os.Getenv("LDAP_CA_CERT_FILE")
+	cfg.BindDN = os.Getenv("LDAP_BIND_DN")
+	cfg.BindPassword = os.Getenv("LDAP_BIND_PASSWORD")
+	cfg.BaseDN = os.Getenv("LDAP_BASE_DN")
+	envconfig.String("LDAP_USER_FILTER", &cfg.UserFilter)
+	envconfig.String("LDAP_ID_ATTRIBUTE", &cfg.IDAttribute)
+	envconfig.String("LDAP_USERNAME_ATTRIBUTE", &cfg.UsernameAttribute)
+	envconfig.String("LDAP_EMAIL_ATTRIBUTE", &cfg.EmailAttribute)
+	envconfig.String("LDAP_NAME_ATTRIBUTE", &cfg.NameAttribute)
+	envconfig.String("LDAP_GROUP_ATTRIBUTE", &cfg.GroupAttribute)
+	cfg.GroupFilter = os.Getenv("LDAP_GROUP_FILTER")
+	cfg.GroupBaseDN = os.Getenv("LDAP_GROUP_BASE_DN")
+	if cfg.GroupBaseDN == "" {
+		cfg.GroupBaseDN = cfg.BaseDN
+	}
+	cfg.SyncFilter = os.Getenv("LDAP_SYNC_FILTER")
+	envconfig.DurationOrZero("LDAP_SYNC_INTERVAL", &cfg.SyncInterval)
+	envconfig.Bool("LDAP_AUTO_APPROVE", &cfg.AutoApprove)
+	envconfig.Bool("LDAP_LINK_BY_EMAIL", &cfg.LinkByEmail)
+	envconfig.Duration("LDAP_TIMEOUT", &cfg.Timeout)
+	return cfg
+}
@@ -0,0 +1,209 @@
+package ldapauth
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// directory is an in-process LDAP server holding a few entries. It answers
+// simple binds and subtree searches with equality, presence, and, or and
+// not filters, which is all the authenticator uses.
+type directory struct {
+	listener net.Listener
+	// passwords of the DNs that can bind.
+	passwords map[string]string
+
+	mu      sync.Mutex
+	entries []*ldap.Entry
+}
+
+func newDirectory(t *testing.T) *directory {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &directory{listener: l, passwords: map[string]string{}}
+	t.Cleanup(func() { l.Close() })
+	go d.serve()
+	return d
+}
+
+func (d *directory) url() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+// add stores an entry. attrs alternates attribute names and values; a name
+// given more than once gets several values.
+func (d *directory) add(dn string, attrs ...string) {
+	values := map[string][]string{}
+	var names []string
+	for i := 0; i+1 < len(attrs); i += 2 {
+		if _, ok := values[attrs[i]]; !ok {
+			names = append(names, attrs[i])
+		}
+		values[attrs[i]] = append(values[attrs[i]], attrs[i+1])
+	}
+	entry := &ldap.Entry{DN: dn}
+	for _, name := range names {
+		entry.Attributes = append(entry.Attributes, ldap.NewEntryAttribute(name, values[name]))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = append(d.entries, entry)
+}
+
+// remove deletes the entry with dn.
+func (d *directory) remove(dn string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, e := range d.entries {
+		if e.DN == dn {
+			d.entries = append(d.entries[:i], d.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *directory) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *directory) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultSuccess)
+			if want, ok := d.passwords[dn]; dn != "" && (!ok || want != password) {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			if !write(conn, id, ldap.ApplicationBindResponse, code) {
+				return
+			}
+		case ldap.ApplicationSearchRequest:
+			base := strings.ToLower(op.Children[0].Value.(string))
+			filter := op.Children[6]
+			for _, entry := range d.search(base, filter) {
+				if _, err := conn.Write(entryPacket(id, entry).Bytes()); err != nil {
+					return
+				}
+			}
+			if !write(conn, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess) {
+				return
+			}
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (d *directory) search(base string, filter *ber.Packet) []*ldap.Entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var found []*ldap.Entry
+	for _, e := range d.entries {
+		dn := strings.ToLower(e.DN)
+		if (dn == base || strings.HasSuffix(dn, ","+base)) && matches(e, filter) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// matches evaluates a filter against an entry. Values compare
+// case-insensitively, as with most directory attributes.
+func matches(e *ldap.Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, f := range filter.Children {
+			if !matches(e, f) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, f := range filter.Children {
+			if matches(e, f) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matches(e, filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		name := filter.Children[0].Data.String()
+		value := filter.Children[1].Data.String()
+		for _, v := range e.GetEqualFoldAttributeValues(name) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(e.GetEqualFoldAttributeValues(filter.Data.String())) > 0
+	}
+	return false
+}
+
+func envelope(id int64) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	return p
+}
+
+func write(w io.Writer, id int64, tag ber.Tag, code uint16) bool {
+	p := envelope(id)
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(code), "Result Code"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	p.AppendChild(res)
+	_, err := w.Write(p.Bytes())
+	return err == nil
+}
+
+func entryPacket(id int64, e *ldap.Entry) *ber.Packet {
+	p := envelope(id)
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, a := range e.Attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.Name, "Type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range a.Values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	res.AppendChild(attrs)
+	p.AppendChild(res)
+	return p
+}
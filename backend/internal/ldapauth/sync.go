@@ -0,0 +1,116 @@
+package ldapauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Run syncs users with the directory every SyncInterval until ctx is
+// cancelled.
+func (a *Authenticator) Run(ctx context.Context) {
+	if a.cfg.SyncInterval <= 0 {
+		log.Println("LDAP user sync disabled via LDAP_SYNC_INTERVAL")
+		return
+	}
+	ticker := time.NewTicker(a.cfg.SyncInterval)
+	defer ticker.Stop()
+	for {
+		if err := a.Sync(ctx); err != nil {
+			log.Printf("LDAP user sync failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync disables users whose entry is gone from the directory, or no longer
+// matches SyncFilter, and ends their sessions. The roles and server access
+// of the remaining users are updated from their groups. Directory errors
+// abort the sync rather than disabling anyone.
+func (a *Authenticator) Sync(ctx context.Context) error {
+	users, err := a.stores.Users.ListByProvider(database.AuthProviderLDAP)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	conn, closeConn, err := a.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	var missing []*database.User
+	approved := 0
+	for _, user := range users {
+		if user.Approved {
+			approved++
+		}
+		filter := fmt.Sprintf("(&(%s=%s)%s)", a.cfg.IDAttribute, ldap.EscapeFilter(*user.ExternalID), a.cfg.SyncFilter)
+		entry, err := a.findUser(conn, filter)
+		if errors.Is(err, errNotFound) {
+			if user.Approved {
+				missing = append(missing, user)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !user.Approved {
+			continue
+		}
+
+		groups, err := a.groups(conn, entry)
+		if err != nil {
+			return err
+		}
+		if _, _, err := a.stores.GroupMappings.Apply(user.ID, database.AuthProviderLDAP, groups); err != nil {
+			return err
+		}
+	}
+
+	// Losing every user at once points at a wrong base DN or filter. Users
+	// disabled earlier are not looked for, so they don't count.
+	if len(missing) > 1 && len(missing) == approved {
+		return fmt.Errorf("none of the %d approved users were found, not disabling them", approved)
+	}
+	for _, user := range missing {
+		if err := a.disable(user); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("LDAP user sync disabled %d users removed from the directory", len(missing))
+	}
+	return nil
+}
+
+func (a *Authenticator) disable(user *database.User) error {
+	approved := false
+	if err := a.stores.Users.Update(user.ID, nil, &approved); err != nil {
+		return err
+	}
+	ids, err := a.stores.Sessions.RevokeAllForUser(user.ID, database.SessionUserDisabled)
+	if err != nil {
+		return err
+	}
+	a.revoked.Add(ids...)
+	a.audit("", auditUserDisabled, user.ID, map[string]interface{}{
+		"provider": database.AuthProviderLDAP,
+		"reason":   "removed from directory",
+		"sessions": len(ids),
+	})
+	return nil
+}
@@ -0,0 +1,289 @@
+package ldapauth
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/auth"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/database/dbtest"
+)
+
+const (
+	baseDN     = "dc=example,dc=org"
+	adminsDN   = "cn=admins,ou=groups,dc=example,dc=org"
+	staffDN    = "cn=staff,ou=groups,dc=example,dc=org"
+	aliceDN    = "uid=alice,ou=people,dc=example,dc=org"
+	bobDN      = "uid=bob,ou=people,dc=example,dc=org"
+	serviceDN  = "cn=cmdb,dc=example,dc=org"
+	servicePwd = "service-secret"
+)
+
+func testConfig(d *directory) Config {
+	cfg := DefaultConfig()
+	cfg.Enabled = true
+	cfg.URL = d.url()
+	cfg.BindDN = serviceDN
+	cfg.BindPassword = servicePwd
+	cfg.BaseDN = baseDN
+	cfg.GroupBaseDN = baseDN
+	cfg.Timeout = 5 * time.Second
+	return cfg
+}
+
+func newTestDirectory(t *testing.T) *directory {
+	d := newDirectory(t)
+	d.passwords[serviceDN] = servicePwd
+	d.add(aliceDN, "objectClass", "person", "uid", "alice", "mail", "alice@example.org", "memberOf", adminsDN)
+	d.add(bobDN, "objectClass", "person", "uid", "bob", "mail", "bob@example.org", "memberOf", staffDN)
+	d.add(adminsDN, "objectClass", "groupOfNames", "member", aliceDN)
+	d.add(staffDN, "objectClass", "groupOfNames", "member", bobDN)
+	return d
+}
+
+func TestGroups(t *testing.T) {
+	d := newTestDirectory(t)
+	d.add("uid=carol,ou=people,dc=example,dc=org", "objectClass", "person", "uid", "carol")
+
+	tests := []struct {
+		name        string
+		groupFilter string
+		login       string
+		want        []string
+	}{
+		{"memberOf attribute", "", "alice", []string{adminsDN}},
+		{"memberOf attribute, no groups", "", "carol", []string{}},
+		{"group search", "(&(objectClass=groupOfNames)(member={dn}))", "bob", []string{staffDN}},
+		{"group search, no groups", "(&(objectClass=groupOfNames)(member={dn}))", "carol", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(d)
+			cfg.GroupFilter = tt.groupFilter
+			a, err := New(nil, cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, closeConn, err := a.dial(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closeConn()
+
+			entry, err := a.findUser(conn, "(uid="+tt.login+")")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := a.groups(conn, entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groups of %s = %#v, want %#v", tt.login, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindUser(t *testing.T) {
+	d := newTestDirectory(t)
+	d.add("uid=alice2,ou=people,dc=example,dc=org", "objectClass", "person", "uid", "alice2", "mail", "alice@example.org")
+
+	tests := []struct {
+		name    string
+		filter  string
+		wantDN  string
+		wantErr error
+	}{
+		{"single match", "(uid=alice)", aliceDN, nil},
+		{"no match", "(uid=mallory)", "", errNotFound},
+		{"ambiguous", "(mail=alice@example.org)", "", errNotFound},
+	}
+
+	a, err := New(nil, testConfig(d), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, closeConn, err := a.dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeConn()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := a.findUser(conn, tt.filter)
+			if err != tt.wantErr {
+				t.Fatalf("findUser(%s) error = %v, want %v", tt.filter, err, tt.wantErr)
+			}
+			if err == nil && entry.DN != tt.wantDN {
+				t.Errorf("findUser(%s) = %s, want %s", tt.filter, entry.DN, tt.wantDN)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name         string
+		syncFilter   string
+		disabled     []string
+		change       func(d *directory)
+		wantErr      bool
+		wantApproved map[string]bool
+		wantRoles    map[string][]string
+	}{
+		{
+			name:         "roles follow groups",
+			change:       func(d *directory) {},
+			wantApproved: map[string]bool{"alice": true, "bob": true},
+			wantRoles:    map[string][]string{"alice": {"admin"}, "bob": {"user"}},
+		},
+		{
+			name: "removed from the admin group",
+			change: func(d *directory) {
+				d.remove(aliceDN)
+				d.add(aliceDN, "objectClass", "person", "uid", "alice", "mail", "alice@example.org", "memberOf", staffDN)
+			},
+			wantApproved: map[string]bool{"alice": true, "bob": true},
+			wantRoles:    map[string][]string{"alice": {"user"}, "bob": {"user"}},
+		},
+		{
+			name: "added to the admin group",
+			change: func(d *directory) {
+				d.remove(bobDN)
+				d.add(bobDN, "objectClass", "person", "uid", "bob", "mail", "bob@example.org",
+					"memberOf", staffDN, "memberOf", adminsDN)
+			},
+			wantApproved: map[string]bool{"alice": true, "bob": true},
+			wantRoles:    map[string][]string{"alice": {"admin"}, "bob": {"admin"}},
+		},
+		{
+			name:         "removed from the directory",
+			change:       func(d *directory) { d.remove(bobDN) },
+			wantApproved: map[string]bool{"alice": true, "bob": false},
+			wantRoles:    map[string][]string{"alice": {"admin"}},
+		},
+		{
+			name:       "excluded by the sync filter",
+			syncFilter: "(!(accountDisabled=TRUE))",
+			change: func(d *directory) {
+				d.remove(bobDN)
+				d.add(bobDN, "objectClass", "person", "uid", "bob", "mail", "bob@example.org",
+					"memberOf", staffDN, "accountDisabled", "TRUE")
+			},
+			wantApproved: map[string]bool{"alice": true, "bob": false},
+			wantRoles:    map[string][]string{"alice": {"admin"}},
+		},
+		{
+			name: "everyone missing",
+			change: func(d *directory) {
+				d.remove(aliceDN)
+				d.remove(bobDN)
+			},
+			wantErr:      true,
+			wantApproved: map[string]bool{"alice": true, "bob": true},
+		},
+		{
+			name:     "everyone missing, one disabled before",
+			disabled: []string{"carol"},
+			change: func(d *directory) {
+				d.remove(aliceDN)
+				d.remove(bobDN)
+			},
+			wantErr:      true,
+			wantApproved: map[string]bool{"alice": true, "bob": true, "carol": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := dbtest.Open(t)
+			stores := &database.Stores{
+				Users:         database.NewUserStore(db),
+				Audit:         database.NewAuditStore(db),
+				Sessions:      database.NewSessionStore(db),
+				GroupMappings: database.NewGroupMappingStore(db),
+			}
+			d := newTestDirectory(t)
+			cfg := testConfig(d)
+			cfg.SyncFilter = tt.syncFilter
+			revoked := auth.NewRevocationList(stores.Sessions, time.Minute)
+			a, err := New(stores, cfg, revoked)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			admin := "admin"
+			if err := stores.GroupMappings.Create(&database.GroupMapping{
+				Provider: database.AuthProviderLDAP, ExternalGroup: adminsDN, Role: &admin,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			users := map[string]*database.User{}
+			sessions := map[string]string{}
+			for _, uid := range []string{"alice", "bob"} {
+				user, err := stores.Users.CreateExternal(database.AuthProviderLDAP, uid, uid, uid+"@example.org", nil, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				users[uid] = user
+				sess := &database.Session{UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+				if err := stores.Sessions.Create(sess, "refresh-"+uid); err != nil {
+					t.Fatal(err)
+				}
+				sessions[uid] = sess.ID
+			}
+			for _, uid := range tt.disabled {
+				user, err := stores.Users.CreateExternal(database.AuthProviderLDAP, uid, uid, uid+"@example.org", nil, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				users[uid] = user
+			}
+
+			tt.change(d)
+			err = a.Sync(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Sync() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			for uid, want := range tt.wantApproved {
+				user, err := stores.Users.GetByID(users[uid].ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if user.Approved != want {
+					t.Errorf("%s approved = %v, want %v", uid, user.Approved, want)
+				}
+				if sessions[uid] == "" {
+					continue
+				}
+				sess, err := stores.Sessions.Get(sessions[uid])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (sess.RevokedAt == nil) != want {
+					t.Errorf("%s session revoked at %v, want revoked %v", uid, sess.RevokedAt, !want)
+				}
+				if got := revoked.IsRevoked(sessions[uid]); got == want {
+					t.Errorf("%s session on the revocation list = %v, want %v", uid, got, !want)
+				}
+			}
+			for uid, want := range tt.wantRoles {
+				roles, err := stores.Users.GetRoles(users[uid].ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sort.Strings(roles)
+				if !reflect.DeepEqual(roles, want) {
+					t.Errorf("%s roles = %v, want %v", uid, roles, want)
+				}
+			}
+		})
+	}
+}
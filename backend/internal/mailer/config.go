@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"os"
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+// Drivers.
+const (
+	// DriverLog writes messages to the server log instead of sending them,
+	// for development.
+	DriverLog  = "log"
+	DriverSMTP = "smtp"
+)
+
+type Config struct {
+	Driver string
+	// From is the sender, e.g. "CMDB <cmdb@example.com>".
+	From     string
+	SMTPHost string
+	// SMTPPort defaults to 587, or 465 with SMTPTLS tls.
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// SMTPTLS is starttls, tls (implicit TLS) or none.
+	SMTPTLS string
+	// Timeout bounds sending one message.
+	Timeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Driver:  DriverLog,
+		From:    "CMDB <cmdb@localhost>",
+		SMTPTLS: "starttls",
+		Timeout: 15 * time.Second,
+	}
+}
+
+// ConfigFromEnv reads MAIL_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.String("MAIL_DRIVER", &cfg.Driver)
+	envconfig.String("MAIL_FROM", &cfg.From)
+	cfg.SMTPHost = os.Getenv("MAIL_SMTP_HOST")
+	envconfig.Int("MAIL_SMTP_PORT", &cfg.SMTPPort, 1)
+	cfg.SMTPUsername = os.Getenv("MAIL_SMTP_USERNAME")
+	cfg.SMTPPassword = os.Getenv("MAIL_SMTP_PASSWORD")
+	envconfig.String("MAIL_SMTP_TLS", &cfg.SMTPTLS)
+	envconfig.Duration("MAIL_TIMEOUT", &cfg.Timeout)
+	return cfg
+}
@@ -0,0 +1,167 @@
+// Package mailer sends account emails such as invitations and password
+// resets.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email to one recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New returns the mailer of cfg.Driver.
+func New(cfg Config) (Mailer, error) {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("MAIL_FROM: %w", err)
+	}
+	switch cfg.Driver {
+	case DriverLog:
+		return logMailer{}, nil
+	case DriverSMTP:
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("MAIL_SMTP_HOST is required")
+		}
+		switch cfg.SMTPTLS {
+		case "starttls", "tls", "none":
+		default:
+			return nil, fmt.Errorf("MAIL_SMTP_TLS must be starttls, tls or none")
+		}
+		return &smtpMailer{cfg: cfg, from: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", cfg.Driver)
+	}
+}
+
+// logMailer writes messages, including their links, to the server log.
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, msg *Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+type smtpMailer struct {
+	cfg  Config
+	from *mail.Address
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	rcpt, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("recipient %s: %w", msg.To, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+
+	port := m.cfg.SMTPPort
+	if port == 0 {
+		port = 587
+		if m.cfg.SMTPTLS == "tls" {
+			port = 465
+		}
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.cfg.SMTPHost, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: m.cfg.SMTPHost}
+	if m.cfg.SMTPTLS == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, m.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if m.cfg.SMTPTLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS (set MAIL_SMTP_TLS to none to send in clear text)")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if m.cfg.SMTPUsername != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.SMTPUsername, m.cfg.SMTPPassword, m.cfg.SMTPHost)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(rcpt.Address); err != nil {
+		return fmt.Errorf("recipient %s: %w", msg.To, err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.build(rcpt, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// build formats a plain text, quoted-printable encoded message.
+func (m *smtpMailer) build(rcpt *mail.Address, msg *Message) []byte {
+	id := make([]byte, 16)
+	rand.Read(id)
+	domain := "localhost"
+	if at := strings.LastIndex(m.from.Address, "@"); at >= 0 {
+		domain = m.from.Address[at+1:]
+	}
+
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", m.from.String())
+	header("To", rcpt.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(msg.Body))
+	qp.Close()
+	return buf.Bytes()
+}
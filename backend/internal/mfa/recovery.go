@@ -0,0 +1,45 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time.
+const RecoveryCodeCount = 10
+
+// recoveryAlphabet leaves out characters that are easily confused.
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// NewRecoveryCodes returns fresh one-time recovery codes, formatted as
+// xxxxx-xxxxx-xxxxx, and the hashes to store for them.
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 15)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		var sb strings.Builder
+		for j, c := range b {
+			if j > 0 && j%5 == 0 {
+				sb.WriteByte('-')
+			}
+			// The slight bias of the modulo is irrelevant at over 70 bits
+			sb.WriteByte(recoveryAlphabet[int(c)%len(recoveryAlphabet)])
+		}
+		code := sb.String()
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode normalizes a recovery code as typed and hashes it. The
+// codes have enough entropy that a fast hash is sufficient.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,55 @@
+package mfa
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := HashRecoveryCode("abcde-fghjk-mnpqr")
+	tests := []struct {
+		name  string
+		typed string
+		same  bool
+	}{
+		{"as issued", "abcde-fghjk-mnpqr", true},
+		{"without dashes", "abcdefghjkmnpqr", true},
+		{"upper case", "ABCDE-FGHJK-MNPQR", true},
+		{"spaces instead of dashes", "abcde fghjk mnpqr", true},
+		{"mixed separators", " Abcde - fghjk mnpqr ", true},
+		{"other code", "abcde-fghjk-mnpqs", false},
+		{"truncated", "abcde-fghjk-mnpq", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HashRecoveryCode(tt.typed) == want; got != tt.same {
+				t.Errorf("HashRecoveryCode(%q) matches = %v, want %v", tt.typed, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodeCount)
+	}
+	format := regexp.MustCompile(`^[` + recoveryAlphabet + `]{5}(-[` + recoveryAlphabet + `]{5}){2}$`)
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx-xxxxx", code)
+		}
+		if hashes[i] != HashRecoveryCode(code) {
+			t.Errorf("hash of %q does not match HashRecoveryCode", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q issued twice", code)
+		}
+		seen[code] = true
+	}
+}
@@ -0,0 +1,75 @@
+package mfa
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// RFC 6238 Appendix B, SHA-1. The RFC lists 8-digit codes; ours are
+	// their last six digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(rfcSecret, Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("Code() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(offset int64) string { return Code(rfcSecret, step+offset) }
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(0), 0, step, true},
+		{"previous step", code(-1), 0, step - 1, true},
+		{"next step", code(1), 0, step + 1, true},
+		{"two steps behind", code(-2), 0, 0, false},
+		{"two steps ahead", code(2), 0, 0, false},
+		{"surrounding spaces", " " + code(0) + " ", 0, step, true},
+		{"too short", code(0)[:5], 0, 0, false},
+		{"too long", code(0) + "0", 0, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		{"replayed", code(0), step, 0, false},
+		{"older than last use", code(-1), step - 1, 0, false},
+		{"newer than last use", code(1), step, step + 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Verify(rfcSecret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || got != tt.wantStep {
+				t.Errorf("Verify(%q, lastStep %d) = %d, %v, want %d, %v", tt.code, tt.lastStep, got, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestKeyURI(t *testing.T) {
+	got := KeyURI("CMDB", "alice@example.org", rfcSecret)
+	want := "otpauth://totp/CMDB:alice@example.org?algorithm=SHA1&digits=6&issuer=CMDB&period=30&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if got != want {
+		t.Errorf("KeyURI() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,311 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/secrets"
+)
+
+// Signature headers of generic webhooks. The signature is the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the channel's signing secret.
+const (
+	HeaderSignature      = "X-Signature"
+	HeaderTimestamp      = "X-Signature-Timestamp"
+	HeaderNotificationID = "X-Notification-ID"
+)
+
+// ChannelSecrets are sealed as one JSON object per channel.
+type ChannelSecrets struct {
+	// Password authenticates with the SMTP server.
+	Password string `json:"password,omitempty"`
+	// SigningSecret signs generic webhook requests.
+	SigningSecret string `json:"signing_secret,omitempty"`
+	// WebhookURL is the Slack or Teams incoming webhook, which embeds a token.
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+func channelAD(channelID string) []byte {
+	return []byte("notification_channel:" + channelID)
+}
+
+// SealSecrets encrypts a channel's secrets bound to its ID.
+func SealSecrets(sealer *secrets.Sealer, channelID string, s ChannelSecrets) ([]byte, error) {
+	if s == (ChannelSecrets{}) {
+		return nil, nil
+	}
+	if sealer == nil {
+		return nil, secrets.ErrNotConfigured
+	}
+	plain, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return sealer.Seal(plain, channelAD(channelID))
+}
+
+// OpenSecrets decrypts a channel's secrets.
+func OpenSecrets(sealer *secrets.Sealer, ch *database.NotificationChannel) (ChannelSecrets, error) {
+	var s ChannelSecrets
+	if len(ch.EncryptedSecrets) == 0 {
+		return s, nil
+	}
+	if sealer == nil {
+		return s, secrets.ErrNotConfigured
+	}
+	plain, err := sealer.Open(ch.EncryptedSecrets, channelAD(ch.ID))
+	if err != nil {
+		return s, fmt.Errorf("decrypting channel secrets: %w", err)
+	}
+	err = json.Unmarshal(plain, &s)
+	return s, err
+}
+
+// Validate checks that a channel has what its type needs.
+func Validate(ch *database.NotificationChannel, s ChannelSecrets) error {
+	switch ch.Type {
+	case database.ChannelSMTP:
+		if ch.Settings.SMTPHost == "" || ch.Settings.From == "" || len(ch.Settings.To) == 0 {
+			return errors.New("smtp channels need smtp_host, from and to")
+		}
+		switch ch.Settings.SMTPTLS {
+		case "", "starttls", "tls", "none":
+		default:
+			return errors.New("smtp_tls must be starttls, tls or none")
+		}
+		for _, addr := range append([]string{ch.Settings.From}, ch.Settings.To...) {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				return fmt.Errorf("invalid address %q", addr)
+			}
+		}
+		if ch.Settings.SMTPUsername != "" && s.Password == "" {
+			return errors.New("password is required with smtp_username")
+		}
+	case database.ChannelWebhook:
+		if err := checkURL(ch.Settings.URL); err != nil {
+			return fmt.Errorf("url: %w", err)
+		}
+	case database.ChannelSlack, database.ChannelTeams:
+		if err := checkURL(s.WebhookURL); err != nil {
+			return fmt.Errorf("webhook_url: %w", err)
+		}
+	default:
+		return fmt.Errorf("type must be %s, %s, %s or %s", database.ChannelSMTP, database.ChannelWebhook,
+			database.ChannelSlack, database.ChannelTeams)
+	}
+	return ValidateTemplates(ch.TitleTemplate, ch.BodyTemplate)
+}
+
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
+
+// Message is a rendered notification ready to be sent.
+type Message struct {
+	// ID identifies the delivery, so receivers can drop duplicates of retries.
+	ID    string
+	Title string
+	Body  string
+	Data  *TemplateData
+	Alert *database.AlertState
+}
+
+func postJSON(ctx context.Context, client *http.Client, target string, payload interface{}, header http.Header) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(ctx, client, target, body, header, nil)
+}
+
+func post(ctx context.Context, client *http.Client, target string, body []byte, header http.Header, sign func(*http.Request, []byte)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if sign != nil {
+		sign(req, body)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("receiver returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// webhookPayload is the body of generic webhook notifications.
+type webhookPayload struct {
+	Version string       `json:"version"`
+	ID      string       `json:"id"`
+	Status  string       `json:"status"`
+	Title   string       `json:"title"`
+	Text    string       `json:"text"`
+	Alert   webhookAlert `json:"alert"`
+	URL     string       `json:"url,omitempty"`
+}
+
+type webhookAlert struct {
+	Fingerprint string            `json:"fingerprint"`
+	Alertname   string            `json:"alertname"`
+	Severity    string            `json:"severity"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"`
+}
+
+// Sign returns the signature header value for a webhook body.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendWebhook(ctx context.Context, client *http.Client, ch *database.NotificationChannel, s ChannelSecrets, msg *Message) error {
+	body, err := json.Marshal(webhookPayload{
+		Version: "1",
+		ID:      msg.ID,
+		Status:  msg.Data.Status,
+		Title:   msg.Title,
+		Text:    msg.Body,
+		URL:     msg.Data.URL,
+		Alert: webhookAlert{
+			Fingerprint: msg.Alert.Fingerprint,
+			Alertname:   msg.Alert.Alertname,
+			Severity:    msg.Alert.Severity,
+			Labels:      msg.Alert.Labels,
+			Annotations: msg.Alert.Annotations,
+			StartsAt:    msg.Alert.StartsAt,
+			EndsAt:      msg.Alert.EndsAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set(HeaderNotificationID, msg.ID)
+	var sign func(*http.Request, []byte)
+	if s.SigningSecret != "" {
+		sign = func(req *http.Request, body []byte) {
+			ts := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set(HeaderTimestamp, ts)
+			req.Header.Set(HeaderSignature, Sign(s.SigningSecret, ts, body))
+		}
+	}
+	return post(ctx, client, ch.Settings.URL, body, header, sign)
+}
+
+// color picks the attachment colour from the status and severity.
+func color(data *TemplateData) string {
+	switch {
+	case data.Status == StatusResolved:
+		return "#2E7D32"
+	case data.Severity == "critical":
+		return "#D32F2F"
+	case data.Severity == "warning":
+		return "#F9A825"
+	}
+	return "#1976D2"
+}
+
+// sendSlack posts a Slack-compatible incoming webhook message, which
+// Mattermost and Rocket.Chat accept as well.
+func sendSlack(ctx context.Context, client *http.Client, ch *database.NotificationChannel, s ChannelSecrets, msg *Message) error {
+	attachment := map[string]interface{}{
+		"fallback": msg.Title,
+		"color":    color(msg.Data),
+		"title":    msg.Title,
+		"text":     msg.Body,
+		"ts":       msg.Data.StartsAt.Unix(),
+	}
+	if msg.Data.URL != "" {
+		attachment["title_link"] = msg.Data.URL
+	}
+	payload := map[string]interface{}{
+		"text":        msg.Title,
+		"attachments": []interface{}{attachment},
+	}
+	if ch.Settings.Channel != "" {
+		payload["channel"] = ch.Settings.Channel
+	}
+	if ch.Settings.Username != "" {
+		payload["username"] = ch.Settings.Username
+	}
+	return postJSON(ctx, client, s.WebhookURL, payload, nil)
+}
+
+// sendTeams posts an Adaptive Card, accepted by Teams incoming webhooks and
+// workflow webhooks.
+func sendTeams(ctx context.Context, client *http.Client, ch *database.NotificationChannel, s ChannelSecrets, msg *Message) error {
+	titleColor := "Attention"
+	switch {
+	case msg.Data.Status == StatusResolved:
+		titleColor = "Good"
+	case msg.Data.Severity == "warning":
+		titleColor = "Warning"
+	}
+
+	facts := []map[string]string{
+		{"title": "Alert", "value": msg.Data.Alertname},
+		{"title": "Severity", "value": msg.Data.Severity},
+		{"title": "Started", "value": msg.Data.StartsAt.Format(time.RFC1123)},
+	}
+	if msg.Data.EndsAt != nil {
+		facts = append(facts, map[string]string{"title": "Resolved", "value": msg.Data.EndsAt.Format(time.RFC1123)})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []interface{}{
+			map[string]interface{}{"type": "TextBlock", "text": msg.Title, "weight": "Bolder", "size": "Medium", "wrap": true, "color": titleColor},
+			map[string]interface{}{"type": "TextBlock", "text": msg.Body, "wrap": true},
+			map[string]interface{}{"type": "FactSet", "facts": facts},
+		},
+	}
+	if msg.Data.URL != "" {
+		card["actions"] = []interface{}{
+			map[string]interface{}{"type": "Action.OpenUrl", "title": "Open dashboard", "url": msg.Data.URL},
+		}
+	}
+	payload := map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+		},
+	}
+	return postJSON(ctx, client, s.WebhookURL, payload, nil)
+}
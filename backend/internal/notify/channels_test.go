@@ -0,0 +1,272 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+func TestSign(t *testing.T) {
+	// Expected values computed with Python's hmac module
+	tests := []struct {
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{"s3cret", "1700000000", `{"id":"n1"}`, "sha256=4b377b7a518669efb6bf1ba6e6cff846a7650eaa240a003adbdafe4a2395f721"},
+		{"s3cret", "1700000001", `{"id":"n1"}`, "sha256=8081a21828a162cdbacff034c0531befdd0208af575757748566d60818f4eef6"},
+		{"other", "1700000000", `{"id":"n1"}`, "sha256=5c6898d7f90cc912b2d454ec1b8b2674fcbbe0d88cd234e6b0e3a9a2693dea25"},
+		{"s3cret", "1700000000", "", "sha256=21948100f1d7a89f3338f6b1106fc4f7a702fbe1493b833a3382f80193bde3fe"},
+	}
+
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	endedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	alert := &database.AlertState{
+		Fingerprint: "abc123",
+		Alertname:   "ServerDown",
+		Severity:    "critical",
+		Labels:      map[string]string{"alertname": "ServerDown", "severity": "critical", "server": "web-1"},
+		Annotations: map[string]string{"summary": "web-1 is offline"},
+		StartsAt:    endedAt.Add(-time.Hour),
+	}
+	resolved := *alert
+	resolved.EndsAt = &endedAt
+
+	tests := []struct {
+		name       string
+		secret     string
+		alert      *database.AlertState
+		wantStatus string
+	}{
+		{"signed", "s3cret", alert, StatusFiring},
+		{"unsigned", "", alert, StatusFiring},
+		{"resolved", "s3cret", &resolved, StatusResolved},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header http.Header
+			var body []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				body, _ = io.ReadAll(r.Body)
+			}))
+			defer srv.Close()
+
+			ch := &database.NotificationChannel{Type: database.ChannelWebhook, Settings: database.NotificationSettings{URL: srv.URL}}
+			data := newTemplateData(tt.alert, "https://cmdb.example.org")
+			msg := &Message{ID: "delivery-1", Title: "title", Body: "body", Data: data, Alert: tt.alert}
+			before := time.Now().Unix()
+			if err := sendWebhook(context.Background(), srv.Client(), ch, ChannelSecrets{SigningSecret: tt.secret}, msg); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := header.Get(HeaderNotificationID); got != "delivery-1" {
+				t.Errorf("%s = %q, want delivery-1", HeaderNotificationID, got)
+			}
+			ts, sig := header.Get(HeaderTimestamp), header.Get(HeaderSignature)
+			if tt.secret == "" {
+				if ts != "" || sig != "" {
+					t.Errorf("unsigned request has %s %q and %s %q", HeaderTimestamp, ts, HeaderSignature, sig)
+				}
+			} else {
+				if n, err := strconv.ParseInt(ts, 10, 64); err != nil || n < before || n > time.Now().Unix() {
+					t.Errorf("%s = %q, want the current Unix time", HeaderTimestamp, ts)
+				}
+				if want := Sign(tt.secret, ts, body); sig != want {
+					t.Errorf("%s = %s, want %s", HeaderSignature, sig, want)
+				}
+				if Sign("wrong", ts, body) == sig {
+					t.Error("signature verifies with another secret")
+				}
+			}
+
+			var payload struct {
+				Version string `json:"version"`
+				ID      string `json:"id"`
+				Status  string `json:"status"`
+				Title   string `json:"title"`
+				Text    string `json:"text"`
+				URL     string `json:"url"`
+				Alert   struct {
+					Fingerprint string            `json:"fingerprint"`
+					Alertname   string            `json:"alertname"`
+					Severity    string            `json:"severity"`
+					Labels      map[string]string `json:"labels"`
+					StartsAt    time.Time         `json:"startsAt"`
+					EndsAt      *time.Time        `json:"endsAt"`
+				} `json:"alert"`
+			}
+			if err := json.Unmarshal(body, &payload); err != nil {
+				t.Fatalf("body %s: %v", body, err)
+			}
+			if payload.Version != "1" || payload.ID != "delivery-1" || payload.Status != tt.wantStatus ||
+				payload.Title != "title" || payload.Text != "body" || payload.URL != "https://cmdb.example.org" {
+				t.Errorf("payload = %s", body)
+			}
+			if payload.Alert.Fingerprint != "abc123" || payload.Alert.Alertname != "ServerDown" ||
+				payload.Alert.Labels["server"] != "web-1" || !payload.Alert.StartsAt.Equal(tt.alert.StartsAt) {
+				t.Errorf("payload alert = %s", body)
+			}
+			if (payload.Alert.EndsAt != nil) != (tt.alert.EndsAt != nil) {
+				t.Errorf("payload endsAt = %v, want %v", payload.Alert.EndsAt, tt.alert.EndsAt)
+			}
+		})
+	}
+}
+
+// receive starts a receiver that records the JSON body of the last request.
+func receive(t *testing.T, status int) (*httptest.Server, *map[string]interface{}) {
+	t.Helper()
+	got := new(map[string]interface{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(got); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func chatMessage(severity string, resolved bool) *Message {
+	startsAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	alert := &database.AlertState{
+		Alertname: "SSLCertificateExpiring",
+		Severity:  severity,
+		Labels:    map[string]string{"alertname": "SSLCertificateExpiring", "severity": severity},
+		StartsAt:  startsAt,
+	}
+	if resolved {
+		endsAt := startsAt.Add(time.Hour)
+		alert.EndsAt = &endsAt
+	}
+	return &Message{ID: "delivery-1", Title: "title", Body: "body", Data: newTemplateData(alert, "https://cmdb.example.org"), Alert: alert}
+}
+
+func TestSendSlack(t *testing.T) {
+	tests := []struct {
+		name      string
+		severity  string
+		resolved  bool
+		settings  database.NotificationSettings
+		wantColor string
+	}{
+		{"critical", "critical", false, database.NotificationSettings{}, "#D32F2F"},
+		{"warning", "warning", false, database.NotificationSettings{}, "#F9A825"},
+		{"resolved", "critical", true, database.NotificationSettings{}, "#2E7D32"},
+		{"channel override", "info", false, database.NotificationSettings{Channel: "#ops", Username: "cmdb"}, "#1976D2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, got := receive(t, http.StatusOK)
+			ch := &database.NotificationChannel{Type: database.ChannelSlack, Settings: tt.settings}
+			msg := chatMessage(tt.severity, tt.resolved)
+			if err := sendSlack(context.Background(), srv.Client(), ch, ChannelSecrets{WebhookURL: srv.URL}, msg); err != nil {
+				t.Fatal(err)
+			}
+
+			payload := *got
+			if payload["text"] != "title" {
+				t.Errorf("text = %v, want title", payload["text"])
+			}
+			if ch, _ := payload["channel"].(string); ch != tt.settings.Channel {
+				t.Errorf("channel = %q, want %q", ch, tt.settings.Channel)
+			}
+			if u, _ := payload["username"].(string); u != tt.settings.Username {
+				t.Errorf("username = %q, want %q", u, tt.settings.Username)
+			}
+			attachments, _ := payload["attachments"].([]interface{})
+			if len(attachments) != 1 {
+				t.Fatalf("attachments = %v, want one", payload["attachments"])
+			}
+			a := attachments[0].(map[string]interface{})
+			if a["color"] != tt.wantColor || a["text"] != "body" || a["title_link"] != "https://cmdb.example.org" {
+				t.Errorf("attachment = %v", a)
+			}
+			if ts, _ := a["ts"].(float64); int64(ts) != msg.Data.StartsAt.Unix() {
+				t.Errorf("ts = %v, want %d", a["ts"], msg.Data.StartsAt.Unix())
+			}
+		})
+	}
+}
+
+func TestSendTeams(t *testing.T) {
+	tests := []struct {
+		name      string
+		severity  string
+		resolved  bool
+		wantColor string
+		wantFacts int
+	}{
+		{"critical", "critical", false, "Attention", 3},
+		{"warning", "warning", false, "Warning", 3},
+		{"resolved", "warning", true, "Good", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, got := receive(t, http.StatusOK)
+			ch := &database.NotificationChannel{Type: database.ChannelTeams}
+			if err := sendTeams(context.Background(), srv.Client(), ch, ChannelSecrets{WebhookURL: srv.URL}, chatMessage(tt.severity, tt.resolved)); err != nil {
+				t.Fatal(err)
+			}
+
+			payload := *got
+			attachments, _ := payload["attachments"].([]interface{})
+			if payload["type"] != "message" || len(attachments) != 1 {
+				t.Fatalf("payload = %v", payload)
+			}
+			att := attachments[0].(map[string]interface{})
+			if att["contentType"] != "application/vnd.microsoft.card.adaptive" {
+				t.Errorf("contentType = %v", att["contentType"])
+			}
+			card := att["content"].(map[string]interface{})
+			if card["type"] != "AdaptiveCard" {
+				t.Errorf("card type = %v", card["type"])
+			}
+			body := card["body"].([]interface{})
+			title := body[0].(map[string]interface{})
+			if title["text"] != "title" || title["color"] != tt.wantColor {
+				t.Errorf("title block = %v, want color %s", title, tt.wantColor)
+			}
+			facts := body[2].(map[string]interface{})["facts"].([]interface{})
+			if len(facts) != tt.wantFacts {
+				t.Errorf("facts = %v, want %d", facts, tt.wantFacts)
+			}
+			actions, _ := card["actions"].([]interface{})
+			if len(actions) != 1 || actions[0].(map[string]interface{})["url"] != "https://cmdb.example.org" {
+				t.Errorf("actions = %v", card["actions"])
+			}
+		})
+	}
+}
+
+func TestSendReceiverError(t *testing.T) {
+	srv, _ := receive(t, http.StatusBadRequest)
+	ch := &database.NotificationChannel{Type: database.ChannelSlack}
+	err := sendSlack(context.Background(), srv.Client(), ch, ChannelSecrets{WebhookURL: srv.URL}, chatMessage("critical", false))
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Fatalf("sendSlack error = %v, want status 400", err)
+	}
+}
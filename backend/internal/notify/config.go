@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+type Config struct {
+	// PollInterval is how often due deliveries and retries are picked up.
+	PollInterval time.Duration
+	// Timeout bounds a single delivery attempt.
+	Timeout time.Duration
+	// MaxAttempts is how often a delivery is tried before it is marked failed.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retention is how long the delivery log is kept.
+	Retention time.Duration
+	// ExternalURL is linked from messages, e.g. the dashboard's address.
+	ExternalURL string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		PollInterval:   15 * time.Second,
+		Timeout:        15 * time.Second,
+		MaxAttempts:    8,
+		InitialBackoff: 30 * time.Second,
+		MaxBackoff:     time.Hour,
+		Retention:      30 * 24 * time.Hour,
+	}
+}
+
+// ConfigFromEnv reads NOTIFY_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Duration("NOTIFY_POLL_INTERVAL", &cfg.PollInterval)
+	envconfig.Duration("NOTIFY_TIMEOUT", &cfg.Timeout)
+	envconfig.Duration("NOTIFY_INITIAL_BACKOFF", &cfg.InitialBackoff)
+	envconfig.Duration("NOTIFY_MAX_BACKOFF", &cfg.MaxBackoff)
+	envconfig.Int("NOTIFY_MAX_ATTEMPTS", &cfg.MaxAttempts, 1)
+	envconfig.Days("NOTIFY_RETENTION_DAYS", &cfg.Retention, 1)
+	return cfg
+}
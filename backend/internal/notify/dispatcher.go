@@ -0,0 +1,278 @@
+// Package notify delivers alert notifications to email, webhook, Slack and
+// Teams channels, with per-channel routing, retries and a delivery log.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/secrets"
+)
+
+// claimLease keeps a claimed delivery from being picked up again while it is
+// being sent.
+const claimLease = 5 * time.Minute
+
+type Dispatcher struct {
+	stores  *database.Stores
+	cfg     Config
+	sealer  *secrets.Sealer
+	client  *http.Client
+	trigger chan struct{}
+}
+
+func New(stores *database.Stores, cfg Config, sealer *secrets.Sealer) *Dispatcher {
+	return &Dispatcher{
+		stores:  stores,
+		cfg:     cfg,
+		sealer:  sealer,
+		client:  &http.Client{},
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// Matches reports whether a channel's routing rules select an alert. server
+// is the alert's server, nil if it has none or it is unknown.
+func Matches(ch *database.NotificationChannel, a *database.AlertState, server *database.Server) bool {
+	if len(ch.Severities) > 0 && !contains(ch.Severities, a.Severity) {
+		return false
+	}
+	if len(ch.GroupIDs) > 0 && (server == nil || server.GroupID == nil || !contains(ch.GroupIDs, *server.GroupID)) {
+		return false
+	}
+	if len(ch.Tags) > 0 {
+		if server == nil {
+			return false
+		}
+		for _, tag := range server.Tags {
+			if contains(ch.Tags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify queues notifications for alerts that started or resolved since they
+// were last seen. It is called by the alert engine after every evaluation.
+func (d *Dispatcher) Notify(ctx context.Context, alerts []*database.AlertState) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	channels, err := d.stores.Notifications.ListChannels()
+	if err != nil {
+		return err
+	}
+	var enabled []*database.NotificationChannel
+	for _, ch := range channels {
+		if ch.Enabled {
+			enabled = append(enabled, ch)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil
+	}
+
+	servers, err := d.stores.Servers.List("", true)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*database.Server, len(servers))
+	for _, s := range servers {
+		byID[s.ID] = s
+	}
+
+	now := time.Now()
+	queued := 0
+	for _, a := range alerts {
+		status := StatusFiring
+		if a.EndsAt != nil {
+			status = StatusResolved
+		}
+		server := byID[a.Labels["server_id"]]
+		for _, ch := range enabled {
+			if status == StatusResolved && !ch.SendResolved {
+				continue
+			}
+			if !Matches(ch, a, server) {
+				continue
+			}
+			added, err := d.stores.Notifications.Enqueue(ch.ID, status, a, now)
+			if err != nil {
+				return fmt.Errorf("queueing notification for %s: %w", ch.Name, err)
+			}
+			if added {
+				queued++
+			}
+		}
+	}
+	if queued > 0 {
+		d.Trigger()
+	}
+	return nil
+}
+
+// Trigger requests a delivery run as soon as possible.
+func (d *Dispatcher) Trigger() {
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers queued notifications until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	log.Printf("Notification dispatcher started (poll=%s, max_attempts=%d)", d.cfg.PollInterval, d.cfg.MaxAttempts)
+
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		if _, err := d.Deliver(ctx); err != nil {
+			log.Println("Notification dispatcher:", err)
+		}
+		if time.Since(lastPrune) > time.Hour {
+			lastPrune = time.Now()
+			if err := d.stores.Notifications.PruneDeliveries(lastPrune.Add(-d.cfg.Retention)); err != nil {
+				log.Println("Notification dispatcher: failed to prune deliveries:", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Notification dispatcher stopped")
+			return
+		case <-ticker.C:
+		case <-d.trigger:
+		}
+	}
+}
+
+// Deliver sends every due delivery and returns how many were sent.
+func (d *Dispatcher) Deliver(ctx context.Context) (int, error) {
+	sent := 0
+	channels := make(map[string]*database.NotificationChannel)
+	for ctx.Err() == nil {
+		due, err := d.stores.Notifications.ClaimDue(time.Now(), claimLease, 50)
+		if err != nil {
+			return sent, err
+		}
+		if len(due) == 0 {
+			return sent, nil
+		}
+
+		for _, delivery := range due {
+			ch, ok := channels[delivery.ChannelID]
+			if !ok {
+				if ch, err = d.stores.Notifications.GetChannel(delivery.ChannelID); err != nil {
+					return sent, err
+				}
+				channels[delivery.ChannelID] = ch
+			}
+
+			sendErr := d.send(ctx, ch, delivery.ID, &delivery.Alert)
+			now := time.Now()
+			var next *time.Time
+			if sendErr != nil {
+				log.Printf("Notification dispatcher: %s to %s failed (attempt %d): %v",
+					delivery.Alertname, ch.Name, delivery.Attempts+1, sendErr)
+				next = d.retryAt(ch, delivery.Attempts+1, now)
+			} else {
+				sent++
+			}
+			if err := d.stores.Notifications.RecordAttempt(delivery.ID, now, sendErr, next); err != nil {
+				return sent, err
+			}
+		}
+	}
+	return sent, ctx.Err()
+}
+
+// retryAt is when a delivery is tried again after its attempts-th attempt
+// failed, or nil once it is given up: after MaxAttempts, or right away when
+// its channel is disabled.
+func (d *Dispatcher) retryAt(ch *database.NotificationChannel, attempts int, now time.Time) *time.Time {
+	if !ch.Enabled || attempts >= d.cfg.MaxAttempts {
+		return nil
+	}
+	next := now.Add(d.backoff(attempts))
+	return &next
+}
+
+// backoff doubles from InitialBackoff after each failed attempt, up to MaxBackoff.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.InitialBackoff
+	for i := 1; i < attempts && wait < d.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.cfg.MaxBackoff {
+		wait = d.cfg.MaxBackoff
+	}
+	return wait
+}
+
+func (d *Dispatcher) send(ctx context.Context, ch *database.NotificationChannel, id string, a *database.AlertState) error {
+	if !ch.Enabled {
+		return fmt.Errorf("channel %s is disabled", ch.Name)
+	}
+	s, err := OpenSecrets(d.sealer, ch)
+	if err != nil {
+		return err
+	}
+
+	data := newTemplateData(a, d.cfg.ExternalURL)
+	title, body, err := render(ch, data)
+	if err != nil {
+		return err
+	}
+	msg := &Message{ID: id, Title: title, Body: body, Data: data, Alert: a}
+
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	switch ch.Type {
+	case database.ChannelSMTP:
+		return sendSMTP(ctx, ch, s, msg)
+	case database.ChannelWebhook:
+		return sendWebhook(ctx, d.client, ch, s, msg)
+	case database.ChannelSlack:
+		return sendSlack(ctx, d.client, ch, s, msg)
+	case database.ChannelTeams:
+		return sendTeams(ctx, d.client, ch, s, msg)
+	}
+	return fmt.Errorf("unknown channel type %q", ch.Type)
+}
+
+// Test sends a sample notification to a channel right away, bypassing
+// routing, the queue and the delivery log.
+func (d *Dispatcher) Test(ctx context.Context, ch *database.NotificationChannel) error {
+	sample := sampleData()
+	a := &database.AlertState{
+		Fingerprint: "test",
+		Alertname:   "NotificationTest",
+		Severity:    "warning",
+		Labels:      map[string]string{"alertname": "NotificationTest", "severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "Test notification from the dashboard",
+			"description": fmt.Sprintf("Channel %s is configured correctly.", ch.Name),
+		},
+		StartsAt: sample.StartsAt,
+	}
+	ch.Enabled = true
+	return d.send(ctx, ch, fmt.Sprintf("test-%d", time.Now().UnixNano()), a)
+}
@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+func TestMatches(t *testing.T) {
+	group, other := "g1", "g2"
+	tagged := &database.Server{GroupID: &group, Tags: []string{"prod", "web"}}
+	ungrouped := &database.Server{Tags: []string{"staging"}}
+	critical := &database.AlertState{Severity: "critical"}
+	warning := &database.AlertState{Severity: "warning"}
+
+	tests := []struct {
+		name   string
+		ch     database.NotificationChannel
+		alert  *database.AlertState
+		server *database.Server
+		want   bool
+	}{
+		{"no rules", database.NotificationChannel{}, warning, nil, true},
+		{"severity matches", database.NotificationChannel{Severities: []string{"critical"}}, critical, tagged, true},
+		{"severity differs", database.NotificationChannel{Severities: []string{"critical"}}, warning, tagged, false},
+		{"group matches", database.NotificationChannel{GroupIDs: []string{group}}, critical, tagged, true},
+		{"group differs", database.NotificationChannel{GroupIDs: []string{other}}, critical, tagged, false},
+		{"group without server group", database.NotificationChannel{GroupIDs: []string{group}}, critical, ungrouped, false},
+		{"group without server", database.NotificationChannel{GroupIDs: []string{group}}, critical, nil, false},
+		{"any tag matches", database.NotificationChannel{Tags: []string{"db", "web"}}, critical, tagged, true},
+		{"no tag matches", database.NotificationChannel{Tags: []string{"db"}}, critical, tagged, false},
+		{"tags without server", database.NotificationChannel{Tags: []string{"web"}}, critical, nil, false},
+		{"all rules match", database.NotificationChannel{Severities: []string{"critical"}, GroupIDs: []string{group}, Tags: []string{"prod"}}, critical, tagged, true},
+		{"one rule fails", database.NotificationChannel{Severities: []string{"warning"}, GroupIDs: []string{group}, Tags: []string{"prod"}}, critical, tagged, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Matches(&tt.ch, tt.alert, tt.server); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{cfg: Config{InitialBackoff: 30 * time.Second, MaxBackoff: 5 * time.Minute}}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAt(t *testing.T) {
+	d := &Dispatcher{cfg: Config{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Hour}}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	enabled := &database.NotificationChannel{Enabled: true}
+	disabled := &database.NotificationChannel{}
+
+	tests := []struct {
+		name     string
+		ch       *database.NotificationChannel
+		attempts int
+		want     time.Duration
+		giveUp   bool
+	}{
+		{"first failure", enabled, 1, time.Minute, false},
+		{"second failure", enabled, 2, 2 * time.Minute, false},
+		{"last attempt", enabled, 3, 0, true},
+		{"channel disabled", disabled, 1, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := d.retryAt(tt.ch, tt.attempts, now)
+			if tt.giveUp {
+				if got != nil {
+					t.Errorf("retryAt() = %s, want to give up", got)
+				}
+				return
+			}
+			if got == nil || !got.Equal(now.Add(tt.want)) {
+				t.Errorf("retryAt() = %v, want %s", got, now.Add(tt.want))
+			}
+		})
+	}
+}
+
+func TestSendFailureSchedulesRetry(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	d := &Dispatcher{
+		cfg:    Config{Timeout: 5 * time.Second, MaxAttempts: 2, InitialBackoff: time.Minute, MaxBackoff: time.Hour},
+		client: srv.Client(),
+	}
+	ch := &database.NotificationChannel{Name: "hook", Type: database.ChannelWebhook, Enabled: true, Settings: database.NotificationSettings{URL: srv.URL}}
+	alert := &database.AlertState{Alertname: "ServerDown", Severity: "critical", Labels: map[string]string{}}
+	now := time.Now()
+
+	// A failed first attempt is retried, a failed second one given up.
+	if err := d.send(context.Background(), ch, "delivery-1", alert); err == nil {
+		t.Fatal("send succeeded against a failing receiver")
+	}
+	if next := d.retryAt(ch, 1, now); next == nil {
+		t.Error("first failure was not retried")
+	}
+	if next := d.retryAt(ch, 2, now); next != nil {
+		t.Errorf("retry scheduled after MaxAttempts: %s", next)
+	}
+
+	status = http.StatusOK
+	if err := d.send(context.Background(), ch, "delivery-1", alert); err != nil {
+		t.Errorf("send after the receiver recovered: %v", err)
+	}
+
+	ch.Enabled = false
+	if err := d.send(context.Background(), ch, "delivery-1", alert); err == nil {
+		t.Error("send to a disabled channel succeeded")
+	}
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+func sendSMTP(ctx context.Context, ch *database.NotificationChannel, s ChannelSecrets, msg *Message) error {
+	cfg := ch.Settings
+	mode := cfg.SMTPTLS
+	if mode == "" {
+		mode = "starttls"
+	}
+	port := cfg.SMTPPort
+	if port == 0 {
+		port = 587
+		if mode == "tls" {
+			port = 465
+		}
+	}
+	addr := net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: cfg.SMTPHost}
+	if mode == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if mode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS (set smtp_tls to none to send in clear text)")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if cfg.SMTPUsername != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.SMTPUsername, s.Password, cfg.SMTPHost)); err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return fmt.Errorf("from: %w", err)
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		rcpt, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMail(cfg, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMail formats a plain text, quoted-printable encoded message.
+func buildMail(cfg database.NotificationSettings, msg *Message) []byte {
+	domain := "localhost"
+	if i := strings.LastIndex(cfg.From, "@"); i >= 0 {
+		domain = strings.Trim(cfg.From[i+1:], "> ")
+	}
+
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", cfg.From)
+	header("To", strings.Join(cfg.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Title))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", msg.ID, domain))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(msg.Body))
+	qp.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,206 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+// smtpSession is what the stand-in SMTP server received.
+type smtpSession struct {
+	auth  string
+	from  string
+	rcpts []string
+	data  string
+}
+
+// serveSMTP accepts one connection on a local port and speaks just enough
+// SMTP for net/smtp, advertising the given EHLO extensions.
+func serveSMTP(t *testing.T, extensions ...string) (port int, done <-chan smtpSession) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan smtpSession, 1)
+	go func() {
+		var s smtpSession
+		defer func() { ch <- s }()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+		reply("220 localhost ESMTP test")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO":
+				for _, ext := range extensions {
+					reply("250-" + ext)
+				}
+				reply("250 localhost")
+			case "AUTH":
+				s.auth = line
+				reply("235 2.7.0 Authentication successful")
+			case "MAIL":
+				s.from = line
+				reply("250 OK")
+			case "RCPT":
+				s.rcpts = append(s.rcpts, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(l, "."))
+				}
+				s.data = data.String()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, ch
+}
+
+func smtpMessage() *Message {
+	alert := &database.AlertState{
+		Alertname: "ServerDown",
+		Severity:  "critical",
+		Labels:    map[string]string{"alertname": "ServerDown"},
+		StartsAt:  time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+	return &Message{
+		ID:    "delivery-1",
+		Title: "Server wëb-1 is down",
+		Body:  "web-1 stopped answering on port 22 at 12:00 and has not come back since, a line long enough to be wrapped.",
+		Data:  newTemplateData(alert, ""),
+		Alert: alert,
+	}
+}
+
+func TestSendSMTP(t *testing.T) {
+	port, done := serveSMTP(t, "AUTH PLAIN")
+	ch := &database.NotificationChannel{Type: database.ChannelSMTP, Settings: database.NotificationSettings{
+		SMTPHost:     "127.0.0.1",
+		SMTPPort:     port,
+		SMTPTLS:      "none",
+		SMTPUsername: "alerts",
+		From:         "CMDB <cmdb@example.org>",
+		To:           []string{"ops@example.org", "Jo <jo@example.org>"},
+	}}
+	msg := smtpMessage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := sendSMTP(ctx, ch, ChannelSecrets{Password: "hunter2"}, msg); err != nil {
+		t.Fatal(err)
+	}
+	s := <-done
+
+	wantAuth := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00alerts\x00hunter2"))
+	if s.auth != wantAuth {
+		t.Errorf("auth = %q, want %q", s.auth, wantAuth)
+	}
+	if s.from != "MAIL FROM:<cmdb@example.org>" && !strings.HasPrefix(s.from, "MAIL FROM:<cmdb@example.org> ") {
+		t.Errorf("from = %q", s.from)
+	}
+	if len(s.rcpts) != 2 || s.rcpts[0] != "RCPT TO:<ops@example.org>" || s.rcpts[1] != "RCPT TO:<jo@example.org>" {
+		t.Errorf("recipients = %q", s.rcpts)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(s.data))
+	if err != nil {
+		t.Fatalf("reading message: %v\n%s", err, s.data)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != msg.Title {
+		t.Errorf("subject = %q (%v), want %q", subject, err, msg.Title)
+	}
+	if got := m.Header.Get("Message-ID"); got != "<delivery-1@example.org>" {
+		t.Errorf("Message-ID = %q", got)
+	}
+	if got := m.Header.Get("To"); got != "ops@example.org, Jo <jo@example.org>" {
+		t.Errorf("To = %q", got)
+	}
+	// The DATA writer ends the message with a line break
+	body, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+	if err != nil || strings.TrimSuffix(string(body), "\r\n") != msg.Body {
+		t.Errorf("body = %q (%v), want %q", body, err, msg.Body)
+	}
+}
+
+func TestSendSMTPRequiresStartTLS(t *testing.T) {
+	port, done := serveSMTP(t)
+	ch := &database.NotificationChannel{Type: database.ChannelSMTP, Settings: database.NotificationSettings{
+		SMTPHost: "127.0.0.1",
+		SMTPPort: port,
+		From:     "cmdb@example.org",
+		To:       []string{"ops@example.org"},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := sendSMTP(ctx, ch, ChannelSecrets{}, smtpMessage())
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("sendSMTP error = %v, want a STARTTLS error", err)
+	}
+	if s := <-done; s.from != "" || s.data != "" {
+		t.Errorf("message sent in clear text: %+v", s)
+	}
+}
+
+func TestBuildMailDomain(t *testing.T) {
+	tests := []struct {
+		from string
+		want string
+	}{
+		{"cmdb@example.org", "<delivery-1@example.org>"},
+		{"CMDB <cmdb@mail.example.org>", "<delivery-1@mail.example.org>"},
+		{"cmdb", "<delivery-1@localhost>"},
+	}
+
+	for _, tt := range tests {
+		raw := buildMail(database.NotificationSettings{From: tt.from, To: []string{"ops@example.org"}}, smtpMessage())
+		m, err := mail.ReadMessage(strings.NewReader(string(raw)))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.from, err)
+		}
+		if got := m.Header.Get("Message-ID"); got != tt.want {
+			t.Errorf("from %q: Message-ID = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+}
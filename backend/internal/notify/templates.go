@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+// Alert statuses carried by a notification.
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// TemplateData is what channel title and body templates are executed with.
+type TemplateData struct {
+	Status      string
+	Alertname   string
+	Severity    string
+	Summary     string
+	Description string
+	Labels      map[string]string
+	Annotations map[string]string
+	StartsAt    time.Time
+	EndsAt      *time.Time
+	URL         string
+}
+
+const defaultTitleTemplate = `[{{ .Status | upper }}{{ if eq .Status "firing" }}:{{ .Severity | upper }}{{ end }}] {{ .Summary }}`
+
+const defaultBodyTemplate = `{{ if .Description }}{{ .Description }}
+
+{{ end }}Alert: {{ .Alertname }}
+Severity: {{ .Severity }}
+Started: {{ .StartsAt.Format "2006-01-02 15:04:05 MST" }}
+{{ if .EndsAt }}Resolved: {{ .EndsAt.Format "2006-01-02 15:04:05 MST" }}
+{{ end }}
+{{ range sortedLabels .Labels }}{{ .Name }}: {{ .Value }}
+{{ end }}{{ if .URL }}
+{{ .URL }}
+{{ end }}`
+
+type label struct {
+	Name, Value string
+}
+
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+	"sortedLabels": func(labels map[string]string) []label {
+		sorted := make([]label, 0, len(labels))
+		for k, v := range labels {
+			sorted = append(sorted, label{k, v})
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+		return sorted
+	},
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// ValidateTemplates checks custom title and body templates, nil meaning the
+// default.
+func ValidateTemplates(title, body *string) error {
+	for name, text := range map[string]*string{"title_template": title, "body_template": body} {
+		if text == nil || *text == "" {
+			continue
+		}
+		tmpl, err := parseTemplate(name, *text)
+		if err != nil {
+			return err
+		}
+		if err := tmpl.Execute(&bytes.Buffer{}, sampleData()); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func sampleData() *TemplateData {
+	return &TemplateData{
+		Status:      StatusFiring,
+		Alertname:   "SSLCertificateExpiring",
+		Severity:    "warning",
+		Summary:     "SSL certificate for example.com expires in 14 days",
+		Description: "Certificate issued by Example CA expires at 2030-01-01 00:00:00",
+		Labels:      map[string]string{"alertname": "SSLCertificateExpiring", "severity": "warning", "domain": "example.com"},
+		Annotations: map[string]string{},
+		StartsAt:    time.Now(),
+	}
+}
+
+func newTemplateData(a *database.AlertState, externalURL string) *TemplateData {
+	data := &TemplateData{
+		Status:      StatusFiring,
+		Alertname:   a.Alertname,
+		Severity:    a.Severity,
+		Summary:     a.Annotations["summary"],
+		Description: a.Annotations["description"],
+		Labels:      a.Labels,
+		Annotations: a.Annotations,
+		StartsAt:    a.StartsAt,
+		EndsAt:      a.EndsAt,
+		URL:         externalURL,
+	}
+	if a.EndsAt != nil {
+		data.Status = StatusResolved
+	}
+	if data.Summary == "" {
+		data.Summary = a.Alertname
+	}
+	return data
+}
+
+// render executes the channel's templates, or the defaults, for an alert.
+func render(ch *database.NotificationChannel, data *TemplateData) (title, body string, err error) {
+	titleText, bodyText := defaultTitleTemplate, defaultBodyTemplate
+	if ch.TitleTemplate != nil && *ch.TitleTemplate != "" {
+		titleText = *ch.TitleTemplate
+	}
+	if ch.BodyTemplate != nil && *ch.BodyTemplate != "" {
+		bodyText = *ch.BodyTemplate
+	}
+
+	var buf bytes.Buffer
+	for _, t := range []struct {
+		name, text string
+		out        *string
+	}{{"title", titleText, &title}, {"body", bodyText, &body}} {
+		tmpl, err := parseTemplate(t.name, t.text)
+		if err != nil {
+			return "", "", err
+		}
+		buf.Reset()
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", "", fmt.Errorf("rendering %s: %w", t.name, err)
+		}
+		*t.out = strings.TrimSpace(buf.String())
+	}
+	// Titles end up in mail headers and card titles.
+	title = strings.Join(strings.Fields(title), " ")
+	return title, body, nil
+}
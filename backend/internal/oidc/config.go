@@ -0,0 +1,79 @@
+package oidc
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+type Config struct {
+	Enabled bool
+	// Issuer is the provider's issuer URL; its discovery document is read
+	// from Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is this backend's callback, /api/auth/oidc/callback, as
+	// registered with the provider.
+	RedirectURL string
+	Scopes      []string
+	// GroupsClaim names the ID token claim listing the user's groups; dots
+	// address nested claims such as realm_access.roles.
+	GroupsClaim string
+	// SuccessURL is the frontend page that receives the session tokens in
+	// its URL fragment after login, or an error.
+	SuccessURL string
+	// AutoApprove approves users provisioned on their first login.
+	AutoApprove bool
+	// LinkByEmail lets an existing local account with the same, verified
+	// email address sign in through the provider.
+	LinkByEmail bool
+	// TrustMFA accepts a second factor the provider asserts, by an amr
+	// value in MFAAMR or an acr value in MFAACR, in place of the challenge
+	// of the MFA policy. Without an assertion the challenge still applies.
+	TrustMFA bool
+	MFAAMR   []string
+	MFAACR   []string
+	Timeout  time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:     false,
+		Scopes:      []string{"openid", "email", "profile"},
+		GroupsClaim: "groups",
+		SuccessURL:  "/auth/callback",
+		AutoApprove: true,
+		LinkByEmail: true,
+		MFAAMR:      []string{"mfa"},
+		Timeout:     10 * time.Second,
+	}
+}
+
+// ConfigFromEnv reads OIDC_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Bool("OIDC_ENABLED", &cfg.Enabled)
+	cfg.Issuer = strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	cfg.ClientID = os.Getenv("OIDC_CLIENT_ID")
+	cfg.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	cfg.RedirectURL = os.Getenv("OIDC_REDIRECT_URL")
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		cfg.Scopes = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	envconfig.String("OIDC_GROUPS_CLAIM", &cfg.GroupsClaim)
+	envconfig.String("OIDC_SUCCESS_URL", &cfg.SuccessURL)
+	envconfig.Bool("OIDC_AUTO_APPROVE", &cfg.AutoApprove)
+	envconfig.Bool("OIDC_LINK_BY_EMAIL", &cfg.LinkByEmail)
+	envconfig.Bool("OIDC_TRUST_MFA", &cfg.TrustMFA)
+	if v := os.Getenv("OIDC_MFA_AMR"); v != "" {
+		cfg.MFAAMR = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	if v := os.Getenv("OIDC_MFA_ACR"); v != "" {
+		cfg.MFAACR = strings.Fields(strings.ReplaceAll(v, ",", " "))
+	}
+	envconfig.Duration("OIDC_TIMEOUT", &cfg.Timeout)
+	return cfg
+}
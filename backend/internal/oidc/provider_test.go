@@ -0,0 +1,296 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "cmdb"
+	testCode     = "auth-code"
+	testVerifier = "pkce-verifier"
+	testNonce    = "expected-nonce"
+)
+
+// testIdP is an OpenID Connect provider that answers the token request for
+// testCode with an ID token built by idToken.
+type testIdP struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken func(issuer string) string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != testCode || r.PostForm.Get("code_verifier") != testVerifier {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken(idp.URL)})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *testIdP) provider(t *testing.T) *Provider {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Issuer = idp.URL
+	cfg.ClientID = testClientID
+	cfg.ClientSecret = "client-secret"
+	cfg.RedirectURL = "https://cmdb.example.org/api/auth/oidc/callback"
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func (idp *testIdP) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	raw, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func validClaims(issuer string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            issuer,
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          testNonce,
+		"email":          "alice@example.org",
+		"email_verified": true,
+		"groups":         []string{"admins"},
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		claims  func(c jwt.MapClaims)
+		sign    func(t *testing.T, idp *testIdP, c jwt.MapClaims) string
+		wantErr bool
+	}{
+		{name: "valid token"},
+		{name: "nonce mismatch", claims: func(c jwt.MapClaims) { c["nonce"] = "other-nonce" }, wantErr: true},
+		{name: "nonce missing", claims: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: true},
+		{name: "wrong issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.org" }, wantErr: true},
+		{name: "wrong audience", claims: func(c jwt.MapClaims) { c["aud"] = "another-client" }, wantErr: true},
+		{
+			name:    "several audiences without azp",
+			claims:  func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "another-client"} },
+			wantErr: true,
+		},
+		{
+			name: "several audiences with azp",
+			claims: func(c jwt.MapClaims) {
+				c["aud"] = []string{testClientID, "another-client"}
+				c["azp"] = testClientID
+			},
+		},
+		{
+			name:    "expired",
+			claims:  func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-5 * time.Minute).Unix() },
+			wantErr: true,
+		},
+		{name: "no expiry", claims: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: true},
+		{
+			name:    "issued in the future",
+			claims:  func(c jwt.MapClaims) { c["iat"] = time.Now().Add(10 * time.Minute).Unix() },
+			wantErr: true,
+		},
+		{name: "no subject", claims: func(c jwt.MapClaims) { delete(c, "sub") }, wantErr: true},
+		{
+			name: "signed with the client secret",
+			sign: func(t *testing.T, idp *testIdP, c jwt.MapClaims) string {
+				raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte("client-secret"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return raw
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed by an unknown key",
+			sign: func(t *testing.T, idp *testIdP, c jwt.MapClaims) string {
+				other, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatal(err)
+				}
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+				token.Header["kid"] = "k2"
+				raw, err := token.SignedString(other)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return raw
+			},
+			wantErr: true,
+		},
+		{
+			name: "signed by another key with a known key ID",
+			sign: func(t *testing.T, idp *testIdP, c jwt.MapClaims) string {
+				other, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatal(err)
+				}
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+				token.Header["kid"] = "k1"
+				raw, err := token.SignedString(other)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return raw
+			},
+			wantErr: true,
+		},
+		{name: "code rejected", code: "stolen-code", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newTestIdP(t)
+			idp.idToken = func(issuer string) string {
+				c := validClaims(issuer)
+				if tt.claims != nil {
+					tt.claims(c)
+				}
+				if tt.sign != nil {
+					return tt.sign(t, idp, c)
+				}
+				return idp.sign(t, c)
+			}
+			code := tt.code
+			if code == "" {
+				code = testCode
+			}
+
+			id, err := idp.provider(t).Exchange(context.Background(), code, testVerifier, testNonce)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Exchange() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			want := &Identity{
+				Subject:       "user-1",
+				Email:         "alice@example.org",
+				EmailVerified: true,
+				Groups:        []string{"admins"},
+				AMR:           []string{},
+			}
+			if !reflect.DeepEqual(id, want) {
+				t.Errorf("Exchange() = %+v, want %+v", id, want)
+			}
+		})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	idp := newTestIdP(t)
+	raw, err := idp.provider(t).AuthCodeURL(context.Background(), "the-state", testNonce, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := sha256.Sum256([]byte(testVerifier))
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"state":                 "the-state",
+		"nonce":                 testNonce,
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	q := u.Query()
+	for k, v := range want {
+		if got := q.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestMFAAsserted(t *testing.T) {
+	tests := []struct {
+		name  string
+		trust bool
+		acr   []string
+		id    Identity
+		want  bool
+	}{
+		{"not trusted", false, nil, Identity{AMR: []string{"mfa"}}, false},
+		{"amr mfa", true, nil, Identity{AMR: []string{"pwd", "mfa"}}, true},
+		{"amr password only", true, nil, Identity{AMR: []string{"pwd"}}, false},
+		{"no amr", true, nil, Identity{}, false},
+		{"acr listed", true, []string{"gold"}, Identity{ACR: "gold"}, true},
+		{"acr not listed", true, []string{"gold"}, Identity{ACR: "bronze"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.TrustMFA = tt.trust
+			cfg.MFAACR = tt.acr
+			p := &Provider{cfg: cfg}
+			if got := p.MFAAsserted(&tt.id); got != tt.want {
+				t.Errorf("MFAAsserted(%+v) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package passkey
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+// Operations that can require a fresh WebAuthn assertion.
+const (
+	OpUpdateUserRoles = "update_user_roles"
+	OpDeleteServer    = "delete_server"
+	OpSSH             = "ssh"
+)
+
+type Config struct {
+	Enabled bool
+	// RPID is the relying party ID credentials are bound to: the site's
+	// domain, without scheme or port.
+	RPID          string
+	RPDisplayName string
+	// RPOrigins are the origins of the frontend, e.g.
+	// https://cmdb.example.com, that may run ceremonies.
+	RPOrigins []string
+	// Timeout bounds how long the user may take to complete a ceremony.
+	Timeout time.Duration
+	// StepUp lists the operations that need an assertion made within
+	// StepUpTTL in the same session.
+	StepUp    []string
+	StepUpTTL time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:       false,
+		RPDisplayName: "CMDB",
+		Timeout:       2 * time.Minute,
+		StepUpTTL:     5 * time.Minute,
+	}
+}
+
+// ConfigFromEnv reads WEBAUTHN_* environment variables on top of
+// DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Bool("WEBAUTHN_ENABLED", &cfg.Enabled)
+	cfg.RPID = os.Getenv("WEBAUTHN_RP_ID")
+	envconfig.String("WEBAUTHN_RP_NAME", &cfg.RPDisplayName)
+	cfg.RPOrigins = listEnv("WEBAUTHN_RP_ORIGINS")
+	cfg.StepUp = listEnv("WEBAUTHN_STEP_UP")
+	envconfig.Duration("WEBAUTHN_TIMEOUT", &cfg.Timeout)
+	envconfig.Duration("WEBAUTHN_STEP_UP_TTL", &cfg.StepUpTTL)
+	return cfg
+}
+
+func listEnv(name string) []string {
+	return strings.Fields(strings.ReplaceAll(os.Getenv(name), ",", " "))
+}
+
+// validate checks what the library would only reject at the first ceremony.
+// Browsers only offer WebAuthn to secure contexts, so every origin must be
+// HTTPS unless it is on this machine.
+func (c Config) validate() error {
+	if c.RPID == "" || len(c.RPOrigins) == 0 {
+		return fmt.Errorf("WEBAUTHN_RP_ID and WEBAUTHN_RP_ORIGINS are required")
+	}
+	for _, origin := range c.RPOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("invalid origin %q in WEBAUTHN_RP_ORIGINS", origin)
+		}
+		if u.Scheme != "https" && !(u.Scheme == "http" && isLocalHost(u.Hostname())) {
+			return fmt.Errorf("origin %q in WEBAUTHN_RP_ORIGINS must use https", origin)
+		}
+	}
+	for _, op := range c.StepUp {
+		if op != OpUpdateUserRoles && op != OpDeleteServer && op != OpSSH {
+			return fmt.Errorf("unknown step-up operation %q", op)
+		}
+	}
+	return nil
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,73 @@
+package passkey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rpID    string
+		origins []string
+		stepUp  []string
+		wantErr string
+	}{
+		{name: "https origin", rpID: "cmdb.example.com", origins: []string{"https://cmdb.example.com"}},
+		{name: "https origin with port", rpID: "cmdb.example.com", origins: []string{"https://cmdb.example.com:8443/"}},
+		{name: "http on localhost", rpID: "localhost", origins: []string{"http://localhost:5173"}},
+		{name: "http on a localhost subdomain", rpID: "cmdb.localhost", origins: []string{"http://cmdb.localhost:8080"}},
+		{name: "http on loopback addresses", rpID: "127.0.0.1", origins: []string{"http://127.0.0.1:8080", "http://[::1]:8080"}},
+		{name: "every step-up operation", rpID: "cmdb.example.com", origins: []string{"https://cmdb.example.com"},
+			stepUp: []string{OpUpdateUserRoles, OpDeleteServer, OpSSH}},
+		{name: "no RP ID", origins: []string{"https://cmdb.example.com"}, wantErr: "are required"},
+		{name: "no origins", rpID: "cmdb.example.com", wantErr: "are required"},
+		{name: "http on a remote host", rpID: "cmdb.example.com", origins: []string{"http://cmdb.example.com"}, wantErr: "must use https"},
+		{name: "one insecure origin among others", rpID: "example.com",
+			origins: []string{"https://cmdb.example.com", "http://10.0.0.5"}, wantErr: "must use https"},
+		{name: "localhost lookalike", rpID: "localhost.example.com", origins: []string{"http://localhost.example.com"}, wantErr: "must use https"},
+		{name: "bare host", rpID: "cmdb.example.com", origins: []string{"cmdb.example.com"}, wantErr: "invalid origin"},
+		{name: "origin with a path", rpID: "cmdb.example.com", origins: []string{"https://cmdb.example.com/app"}, wantErr: "invalid origin"},
+		{name: "unknown step-up operation", rpID: "cmdb.example.com", origins: []string{"https://cmdb.example.com"},
+			stepUp: []string{"reboot"}, wantErr: "unknown step-up operation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.RPID, cfg.RPOrigins, cfg.StepUp = tt.rpID, tt.origins, tt.stepUp
+			err := cfg.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("WEBAUTHN_ENABLED", "true")
+	t.Setenv("WEBAUTHN_RP_ID", "cmdb.example.com")
+	t.Setenv("WEBAUTHN_RP_ORIGINS", "https://cmdb.example.com, https://admin.example.com")
+	t.Setenv("WEBAUTHN_STEP_UP", "ssh,delete_server")
+	t.Setenv("WEBAUTHN_TIMEOUT", "0")
+
+	cfg := ConfigFromEnv()
+	if !cfg.Enabled || cfg.RPID != "cmdb.example.com" {
+		t.Errorf("Enabled, RPID = %v, %q", cfg.Enabled, cfg.RPID)
+	}
+	if len(cfg.RPOrigins) != 2 || cfg.RPOrigins[1] != "https://admin.example.com" {
+		t.Errorf("RPOrigins = %q, want both origins", cfg.RPOrigins)
+	}
+	if len(cfg.StepUp) != 2 || cfg.StepUp[0] != OpSSH || cfg.StepUp[1] != OpDeleteServer {
+		t.Errorf("StepUp = %q, want [ssh delete_server]", cfg.StepUp)
+	}
+	if cfg.Timeout != DefaultConfig().Timeout {
+		t.Errorf("Timeout = %s, want the default for an invalid zero", cfg.Timeout)
+	}
+}
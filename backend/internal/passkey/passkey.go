@@ -0,0 +1,273 @@
+// Package passkey runs WebAuthn registration and assertion ceremonies for
+// security keys and passkeys.
+package passkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// ErrClonedAuthenticator is returned for an assertion whose signature
+// counter did not increase, a sign that the credential's private key was
+// copied.
+var ErrClonedAuthenticator = errors.New("authenticator signature counter went backwards")
+
+// Service runs ceremonies for one relying party. Ceremony state is returned
+// to the caller as opaque JSON to be stored until the browser responds.
+type Service struct {
+	cfg Config
+	wa  *webauthn.WebAuthn
+}
+
+func New(cfg Config) (*Service, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.Timeout, TimeoutUVD: cfg.Timeout}
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Service{cfg: cfg, wa: wa}, nil
+}
+
+// Timeout is how long a ceremony may take.
+func (s *Service) Timeout() time.Duration {
+	return s.cfg.Timeout
+}
+
+// StepUpTTL is how long an assertion counts for operations requiring one.
+func (s *Service) StepUpTTL() time.Duration {
+	return s.cfg.StepUpTTL
+}
+
+// RequiresStepUp reports whether op needs a fresh assertion.
+func (s *Service) RequiresStepUp(op string) bool {
+	for _, o := range s.cfg.StepUp {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginRegistration returns the options for navigator.credentials.create
+// and the ceremony state. Credentials the user already has are excluded so
+// an authenticator is not registered twice.
+func (s *Service) BeginRegistration(user *database.User, creds []*database.WebAuthnCredential) (*protocol.CredentialCreation, []byte, error) {
+	u := newUser(user, creds)
+	descriptors := make([]protocol.CredentialDescriptor, 0, len(creds))
+	for _, c := range u.creds {
+		descriptors = append(descriptors, c.Descriptor())
+	}
+	creation, session, err := s.wa.BeginRegistration(u,
+		webauthn.WithExclusions(descriptors),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		webauthn.WithConveyancePreference(protocol.PreferNoAttestation),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return creation, state, nil
+}
+
+// FinishRegistration verifies the browser's response to a registration and
+// returns the new credential, ready to be stored.
+func (s *Service) FinishRegistration(user *database.User, creds []*database.WebAuthnCredential, state, response []byte) (*database.WebAuthnCredential, error) {
+	var session webauthn.SessionData
+	if err := json.Unmarshal(state, &session); err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, describe(err)
+	}
+	cred, err := s.wa.CreateCredential(newUser(user, creds), session, parsed)
+	if err != nil {
+		return nil, describe(err)
+	}
+
+	transports := make([]string, 0, len(cred.Transport))
+	for _, t := range cred.Transport {
+		transports = append(transports, string(t))
+	}
+	return &database.WebAuthnCredential{
+		UserID:          user.ID,
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      transports,
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	}, nil
+}
+
+// BeginLogin returns the options for navigator.credentials.get limited to
+// the user's credentials, for a second factor or step-up.
+func (s *Service) BeginLogin(user *database.User, creds []*database.WebAuthnCredential) (*protocol.CredentialAssertion, []byte, error) {
+	assertion, session, err := s.wa.BeginLogin(newUser(user, creds))
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return assertion, state, nil
+}
+
+// BeginPasskeyLogin returns the options for a login without a username or
+// password. The authenticator picks a discoverable credential and must
+// verify the user, so the assertion counts as two factors.
+func (s *Service) BeginPasskeyLogin() (*protocol.CredentialAssertion, []byte, error) {
+	assertion, session, err := s.wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := json.Marshal(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	return assertion, state, nil
+}
+
+// FinishLogin verifies the browser's response to BeginLogin and returns the
+// credential used, with its new signature counter and backup state.
+func (s *Service) FinishLogin(user *database.User, creds []*database.WebAuthnCredential, state, response []byte) (*database.WebAuthnCredential, error) {
+	var session webauthn.SessionData
+	if err := json.Unmarshal(state, &session); err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, describe(err)
+	}
+	cred, err := s.wa.ValidateLogin(newUser(user, creds), session, parsed)
+	if err != nil {
+		return nil, describe(err)
+	}
+	return usedCredential(creds, cred)
+}
+
+// FinishPasskeyLogin verifies the browser's response to BeginPasskeyLogin.
+// lookup returns the user named by the assertion's user handle and their
+// credentials.
+func (s *Service) FinishPasskeyLogin(lookup func(userID string) (*database.User, []*database.WebAuthnCredential, error), state, response []byte) (*database.User, *database.WebAuthnCredential, error) {
+	var session webauthn.SessionData
+	if err := json.Unmarshal(state, &session); err != nil {
+		return nil, nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(response))
+	if err != nil {
+		return nil, nil, describe(err)
+	}
+
+	var found *user
+	cred, err := s.wa.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		u, creds, err := lookup(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		found = newUser(u, creds)
+		return found, nil
+	}, session, parsed)
+	if err != nil {
+		return nil, nil, describe(err)
+	}
+	used, err := usedCredential(found.stored, cred)
+	if err != nil {
+		return found.u, nil, err
+	}
+	return found.u, used, nil
+}
+
+func usedCredential(creds []*database.WebAuthnCredential, cred *webauthn.Credential) (*database.WebAuthnCredential, error) {
+	if cred.Authenticator.CloneWarning {
+		return nil, ErrClonedAuthenticator
+	}
+	for _, c := range creds {
+		if bytes.Equal(c.CredentialID, cred.ID) {
+			c.SignCount = cred.Authenticator.SignCount
+			c.BackupState = cred.Flags.BackupState
+			return c, nil
+		}
+	}
+	return nil, errors.New("credential not found")
+}
+
+// describe adds the details of a protocol error, which its Error method
+// leaves out.
+func describe(err error) error {
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.DevInfo != "" {
+		return fmt.Errorf("%s: %s", perr.Details, perr.DevInfo)
+	}
+	return err
+}
+
+// user adapts a database user to webauthn.User. Its handle is the user ID.
+type user struct {
+	u      *database.User
+	stored []*database.WebAuthnCredential
+	creds  []webauthn.Credential
+}
+
+func newUser(u *database.User, stored []*database.WebAuthnCredential) *user {
+	creds := make([]webauthn.Credential, 0, len(stored))
+	for _, c := range stored {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		creds = append(creds, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags:           webauthn.CredentialFlags{BackupEligible: c.BackupEligible, BackupState: c.BackupState},
+			Authenticator:   webauthn.Authenticator{AAGUID: c.AAGUID, SignCount: c.SignCount},
+		})
+	}
+	return &user{u: u, stored: stored, creds: creds}
+}
+
+func (u *user) WebAuthnID() []byte {
+	return []byte(u.u.ID)
+}
+
+func (u *user) WebAuthnName() string {
+	return u.u.Email
+}
+
+func (u *user) WebAuthnDisplayName() string {
+	if u.u.DisplayName != nil && *u.u.DisplayName != "" {
+		return *u.u.DisplayName
+	}
+	return u.u.Username
+}
+
+func (u *user) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *user) WebAuthnCredentials() []webauthn.Credential {
+	return u.creds
+}
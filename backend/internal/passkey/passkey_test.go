@@ -0,0 +1,90 @@
+package passkey
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+func TestUsedCredential(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		stored        uint32
+		asserted      uint32
+		wantSignCount uint32
+		wantErr       error
+		wantUnknown   bool
+	}{
+		{name: "counter increased", id: "key-2", stored: 5, asserted: 6, wantSignCount: 6},
+		{name: "authenticator without a counter", id: "key-2", stored: 0, asserted: 0, wantSignCount: 0},
+		{name: "counter repeated", id: "key-2", stored: 5, asserted: 5, wantErr: ErrClonedAuthenticator},
+		{name: "counter went backwards", id: "key-2", stored: 5, asserted: 2, wantErr: ErrClonedAuthenticator},
+		{name: "unknown credential", id: "key-3", stored: 5, asserted: 6, wantUnknown: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := []*database.WebAuthnCredential{
+				{ID: "1", CredentialID: []byte("key-1"), SignCount: 40},
+				{ID: "2", CredentialID: []byte("key-2"), SignCount: tt.stored, BackupEligible: true},
+			}
+			// ValidateLogin updates the counter of the asserted credential
+			// this way before returning it.
+			cred := &webauthn.Credential{
+				ID:            []byte(tt.id),
+				Flags:         webauthn.CredentialFlags{BackupEligible: true, BackupState: true},
+				Authenticator: webauthn.Authenticator{SignCount: tt.stored},
+			}
+			cred.Authenticator.UpdateCounter(tt.asserted)
+
+			got, err := usedCredential(creds, cred)
+			if tt.wantUnknown {
+				if err == nil || got != nil {
+					t.Fatalf("usedCredential() = %v, %v, want an error for an unknown credential", got, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("usedCredential() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if creds[1].SignCount != tt.stored {
+					t.Errorf("stored counter changed to %d by a rejected assertion", creds[1].SignCount)
+				}
+				return
+			}
+			if got != creds[1] {
+				t.Fatalf("usedCredential() = %+v, want the stored credential key-2", got)
+			}
+			if got.SignCount != tt.wantSignCount || !got.BackupState {
+				t.Errorf("SignCount, BackupState = %d, %v, want %d, true", got.SignCount, got.BackupState, tt.wantSignCount)
+			}
+			if creds[0].SignCount != 40 {
+				t.Errorf("counter of another credential changed to %d", creds[0].SignCount)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RPID = "cmdb.example.com"
+	cfg.RPOrigins = []string{"http://cmdb.example.com"}
+	if _, err := New(cfg); err == nil {
+		t.Fatal("New() accepted an http origin on a remote host")
+	}
+
+	cfg.RPOrigins = []string{"https://cmdb.example.com"}
+	cfg.StepUp = []string{OpSSH}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.RequiresStepUp(OpSSH) || s.RequiresStepUp(OpDeleteServer) {
+		t.Errorf("RequiresStepUp(ssh), (delete_server) = %v, %v, want true, false",
+			s.RequiresStepUp(OpSSH), s.RequiresStepUp(OpDeleteServer))
+	}
+}
@@ -0,0 +1,315 @@
+package prober
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Check types supported on declared ports. CheckTCP only verifies that the
+// port accepts connections; the others speak enough of the application
+// protocol to tell whether the service is healthy.
+const (
+	CheckTCP      = "tcp"
+	CheckHTTP     = "http"
+	CheckHTTPS    = "https"
+	CheckTLS      = "tls"
+	CheckBanner   = "banner"
+	CheckPostgres = "postgres"
+	CheckRedis    = "redis"
+	CheckSMTP     = "smtp"
+)
+
+// StateUnhealthy is reported when the port is open but the application check
+// failed.
+const StateUnhealthy = "unhealthy"
+
+// CheckConfig holds the options for all check types; each type only reads the
+// fields that apply to it.
+type CheckConfig struct {
+	// http / https
+	Method         string            `json:"method,omitempty"`
+	Path           string            `json:"path,omitempty"`
+	Host           string            `json:"host,omitempty"`
+	ExpectedStatus []int             `json:"expected_status,omitempty"`
+	BodyContains   string            `json:"body_contains,omitempty"`
+	BodyRegex      string            `json:"body_regex,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+
+	// https / tls
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+
+	// banner
+	Send        string `json:"send,omitempty"`
+	Expect      string `json:"expect,omitempty"`
+	ExpectRegex string `json:"expect_regex,omitempty"`
+
+	bodyRegex   *regexp.Regexp
+	expectRegex *regexp.Regexp
+}
+
+// ParseCheckConfig validates a check type and its JSON configuration.
+func ParseCheckConfig(checkType string, raw []byte) (*CheckConfig, error) {
+	switch checkType {
+	case CheckTCP, CheckHTTP, CheckHTTPS, CheckTLS, CheckBanner, CheckPostgres, CheckRedis, CheckSMTP:
+	default:
+		return nil, fmt.Errorf("unknown check type %q", checkType)
+	}
+
+	cfg := &CheckConfig{}
+	if len(bytes.TrimSpace(raw)) > 0 {
+		if err := json.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("invalid check_config: %w", err)
+		}
+	}
+
+	var err error
+	if cfg.BodyRegex != "" {
+		if cfg.bodyRegex, err = regexp.Compile(cfg.BodyRegex); err != nil {
+			return nil, fmt.Errorf("invalid body_regex: %w", err)
+		}
+	}
+	if cfg.ExpectRegex != "" {
+		if cfg.expectRegex, err = regexp.Compile(cfg.ExpectRegex); err != nil {
+			return nil, fmt.Errorf("invalid expect_regex: %w", err)
+		}
+	}
+	if cfg.Path != "" && !strings.HasPrefix(cfg.Path, "/") {
+		return nil, errors.New("path must start with /")
+	}
+	for _, code := range cfg.ExpectedStatus {
+		if code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid expected_status %d", code)
+		}
+	}
+	return cfg, nil
+}
+
+// RunCheck runs an application-level check against host:port. It is only
+// called once the port is known to accept TCP connections.
+func (p *Prober) RunCheck(ctx context.Context, d netDialer, host string, port int, checkType string, cfg *CheckConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	switch checkType {
+	case CheckHTTP, CheckHTTPS:
+		return p.checkHTTP(ctx, d, addr, checkType, cfg)
+	case CheckTLS:
+		return p.checkTLS(ctx, d, addr, host, cfg)
+	}
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Tunneled connections ignore deadlines, so the timeout also closes them.
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	switch checkType {
+	case CheckBanner:
+		return checkBanner(conn, cfg)
+	case CheckPostgres:
+		return checkPostgres(conn)
+	case CheckRedis:
+		return checkRedis(conn)
+	case CheckSMTP:
+		return checkSMTP(conn)
+	}
+	return nil
+}
+
+func (p *Prober) checkHTTP(ctx context.Context, d netDialer, addr, scheme string, cfg *CheckConfig) error {
+	method := cfg.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := cfg.Path
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, scheme+"://"+addr+path, nil)
+	if err != nil {
+		return err
+	}
+	if cfg.Host != "" {
+		req.Host = cfg.Host
+	}
+	req.Header.Set("User-Agent", "port-probe-dash")
+
+	serverName := cfg.ServerName
+	if serverName == "" {
+		serverName = cfg.Host
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       d.DialContext,
+			TLSClientConfig:   &tls.Config{ServerName: serverName, InsecureSkipVerify: cfg.InsecureSkipVerify},
+			DisableKeepAlives: true,
+		},
+		// Report redirects as-is so they can be asserted on.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if len(cfg.ExpectedStatus) > 0 {
+		ok := false
+		for _, code := range cfg.ExpectedStatus {
+			if resp.StatusCode == code {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+	} else if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	for name, want := range cfg.Headers {
+		if got := resp.Header.Get(name); got != want {
+			return fmt.Errorf("header %s is %q, want %q", name, got, want)
+		}
+	}
+
+	if cfg.BodyContains != "" || cfg.bodyRegex != nil {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return fmt.Errorf("reading body: %w", err)
+		}
+		if cfg.BodyContains != "" && !bytes.Contains(body, []byte(cfg.BodyContains)) {
+			return fmt.Errorf("body does not contain %q", cfg.BodyContains)
+		}
+		if cfg.bodyRegex != nil && !cfg.bodyRegex.Match(body) {
+			return fmt.Errorf("body does not match %q", cfg.BodyRegex)
+		}
+	}
+	return nil
+}
+
+func (p *Prober) checkTLS(ctx context.Context, d netDialer, addr, host string, cfg *CheckConfig) error {
+	serverName := cfg.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	raw, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("tls handshake: %w", err)
+	}
+	conn := tls.Client(raw, &tls.Config{ServerName: serverName, InsecureSkipVerify: cfg.InsecureSkipVerify})
+	defer conn.Close()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("tls handshake: %w", err)
+	}
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("tls handshake: no peer certificate")
+	}
+	if leaf := state.PeerCertificates[0]; time.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func checkBanner(conn net.Conn, cfg *CheckConfig) error {
+	if cfg.Send != "" {
+		if _, err := conn.Write([]byte(cfg.Send)); err != nil {
+			return err
+		}
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(conn, 4096)).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("reading banner: %w", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	if cfg.Expect != "" && !strings.HasPrefix(line, cfg.Expect) {
+		return fmt.Errorf("banner %q does not start with %q", line, cfg.Expect)
+	}
+	if cfg.expectRegex != nil && !cfg.expectRegex.MatchString(line) {
+		return fmt.Errorf("banner %q does not match %q", line, cfg.ExpectRegex)
+	}
+	return nil
+}
+
+// checkPostgres sends an SSLRequest, which any PostgreSQL server answers with
+// a single 'S' or 'N' byte before authentication.
+func checkPostgres(conn net.Conn) error {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint32(msg[0:4], 8)
+	binary.BigEndian.PutUint32(msg[4:8], 80877103)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("reading SSLRequest reply: %w", err)
+	}
+	if reply[0] != 'S' && reply[0] != 'N' {
+		return fmt.Errorf("unexpected SSLRequest reply %q", reply[0])
+	}
+	return nil
+}
+
+// checkRedis sends PING. A NOAUTH error still proves a live Redis server.
+func checkRedis(conn net.Conn) error {
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading PING reply: %w", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "+PONG" || strings.HasPrefix(line, "-NOAUTH") {
+		return nil
+	}
+	return fmt.Errorf("unexpected PING reply %q", line)
+}
+
+func checkSMTP(conn net.Conn) error {
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return fmt.Errorf("greeting: %w", err)
+	}
+	id, err := tp.Cmd("EHLO port-probe-dash")
+	if err != nil {
+		return err
+	}
+	tp.StartResponse(id)
+	_, _, err = tp.ReadResponse(250)
+	tp.EndResponse(id)
+	if err != nil {
+		return fmt.Errorf("EHLO: %w", err)
+	}
+	tp.Cmd("QUIT")
+	return nil
+}
@@ -0,0 +1,345 @@
+package prober
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+func TestParseCheckConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		checkType string
+		raw       string
+		wantErr   bool
+	}{
+		{"tcp without config", CheckTCP, "", false},
+		{"http with assertions", CheckHTTP, `{"path":"/healthz","expected_status":[200,204],"body_regex":"ok|up"}`, false},
+		{"banner with regex", CheckBanner, `{"expect_regex":"^SSH-2\\.0-"}`, false},
+		{"unknown type", "ftp", "", true},
+		{"invalid json", CheckHTTP, `{"path":`, true},
+		{"relative path", CheckHTTP, `{"path":"healthz"}`, true},
+		{"invalid status", CheckHTTP, `{"expected_status":[999]}`, true},
+		{"invalid body regex", CheckHTTP, `{"body_regex":"("}`, true},
+		{"invalid banner regex", CheckBanner, `{"expect_regex":"["}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCheckConfig(tt.checkType, []byte(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCheckConfig(%s, %s) error = %v, want error %v", tt.checkType, tt.raw, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// standIn accepts connections on a local port and hands each to serve.
+func standIn(t *testing.T, serve func(conn net.Conn)) (string, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// greet writes a banner and reads until the client hangs up.
+func greet(banner string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		io.WriteString(conn, banner)
+		io.Copy(io.Discard, conn)
+	}
+}
+
+// reply answers the first n bytes the client sends with answer.
+func reply(n int, answer string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		io.WriteString(conn, answer)
+	}
+}
+
+func smtpServer(greeting, ehlo string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		io.WriteString(conn, greeting)
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				io.WriteString(conn, ehlo)
+			case strings.HasPrefix(line, "QUIT"):
+				io.WriteString(conn, "221 bye\r\n")
+				return
+			}
+		}
+	}
+}
+
+func hostPort(t *testing.T, rawURL string) (string, int) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Hostname(), port
+}
+
+func TestRunCheck(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.Header().Set("X-Service", "cmdb")
+			io.WriteString(w, `{"status":"up"}`)
+		case "/moved":
+			http.Redirect(w, r, "/healthz", http.StatusFound)
+		case "/vhost":
+			if r.Host != "app.example.org" {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, "ok")
+		default:
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer web.Close()
+	webHost, webPort := hostPort(t, web.URL)
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer secure.Close()
+	tlsHost, tlsPort := hostPort(t, secure.URL)
+
+	tests := []struct {
+		name      string
+		serve     func(conn net.Conn)
+		host      string
+		port      int
+		checkType string
+		config    string
+		wantErr   bool
+	}{
+		{name: "http ok", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/healthz"}`},
+		{name: "http error status", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/down"}`, wantErr: true},
+		{name: "http expected error status", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/down","expected_status":[503]}`},
+		{name: "http unexpected status", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/healthz","expected_status":[204]}`, wantErr: true},
+		{name: "http redirect not followed", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/moved","expected_status":[302]}`},
+		{name: "http body contains", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/healthz","body_contains":"\"up\""}`},
+		{name: "http body missing text", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/healthz","body_contains":"down"}`, wantErr: true},
+		{name: "http body regex", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/healthz","body_regex":"status\":\\s*\"up"}`},
+		{name: "http header", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/healthz","headers":{"X-Service":"cmdb"}}`},
+		{name: "http header mismatch", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/healthz","headers":{"X-Service":"other"}}`, wantErr: true},
+		{name: "http host header", host: webHost, port: webPort, checkType: CheckHTTP, config: `{"path":"/vhost","host":"app.example.org"}`},
+		{name: "https untrusted certificate", host: tlsHost, port: tlsPort, checkType: CheckHTTPS, wantErr: true},
+		{name: "https skip verify", host: tlsHost, port: tlsPort, checkType: CheckHTTPS, config: `{"insecure_skip_verify":true}`},
+		{name: "tls untrusted certificate", host: tlsHost, port: tlsPort, checkType: CheckTLS, wantErr: true},
+		{name: "tls skip verify", host: tlsHost, port: tlsPort, checkType: CheckTLS, config: `{"insecure_skip_verify":true}`},
+		{name: "tls on a plain port", host: webHost, port: webPort, checkType: CheckTLS, config: `{"insecure_skip_verify":true}`, wantErr: true},
+		{name: "ssh banner", serve: greet("SSH-2.0-OpenSSH_9.6\r\n"), checkType: CheckBanner, config: `{"expect":"SSH-2.0-"}`},
+		{name: "wrong banner", serve: greet("220 ftp ready\r\n"), checkType: CheckBanner, config: `{"expect":"SSH-2.0-"}`, wantErr: true},
+		{name: "banner regex", serve: greet("SSH-2.0-OpenSSH_9.6\r\n"), checkType: CheckBanner, config: `{"expect_regex":"OpenSSH_9\\."}`},
+		{name: "banner after send", serve: reply(5, "PONG\n"), checkType: CheckBanner, config: `{"send":"PING\n","expect":"PONG"}`},
+		{name: "postgres without tls", serve: reply(8, "N"), checkType: CheckPostgres},
+		{name: "postgres with tls", serve: reply(8, "S"), checkType: CheckPostgres},
+		{name: "not postgres", serve: reply(8, "E"), checkType: CheckPostgres, wantErr: true},
+		{name: "redis", serve: reply(6, "+PONG\r\n"), checkType: CheckRedis},
+		{name: "redis requiring auth", serve: reply(6, "-NOAUTH Authentication required.\r\n"), checkType: CheckRedis},
+		{name: "redis error", serve: reply(6, "-LOADING Redis is loading\r\n"), checkType: CheckRedis, wantErr: true},
+		{name: "smtp", serve: smtpServer("220 mail ready\r\n", "250-mail\r\n250 STARTTLS\r\n"), checkType: CheckSMTP},
+		{name: "smtp refusing", serve: smtpServer("554 no service\r\n", ""), checkType: CheckSMTP, wantErr: true},
+		{name: "smtp rejecting ehlo", serve: smtpServer("220 mail ready\r\n", "502 not implemented\r\n"), checkType: CheckSMTP, wantErr: true},
+		{name: "silent service", serve: func(conn net.Conn) { io.Copy(io.Discard, conn) }, checkType: CheckRedis, wantErr: true},
+	}
+
+	p := New(nil, Config{Timeout: time.Second}, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := tt.host, tt.port
+			if tt.serve != nil {
+				host, port = standIn(t, tt.serve)
+			}
+			cfg, err := ParseCheckConfig(tt.checkType, []byte(tt.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.RunCheck(context.Background(), &p.dialer, host, port, tt.checkType, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RunCheck(%s) error = %v, want error %v", tt.checkType, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerStatus(t *testing.T) {
+	tests := []struct {
+		probed, expectOpen, answered, degraded bool
+		want                                   string
+	}{
+		{false, false, false, false, StatusOffline},
+		{false, true, true, false, StatusOffline},
+		{true, true, false, false, StatusOffline},
+		{true, true, false, true, StatusOffline},
+		{true, true, true, false, StatusOnline},
+		{true, true, true, true, StatusWarning},
+		// Every port is expected closed: nothing needs to answer.
+		{true, false, false, false, StatusOnline},
+		{true, false, false, true, StatusWarning},
+	}
+
+	for _, tt := range tests {
+		got := serverStatus(tt.probed, tt.expectOpen, tt.answered, tt.degraded)
+		if got != tt.want {
+			t.Errorf("serverStatus(probed=%v, expectOpen=%v, answered=%v, degraded=%v) = %s, want %s",
+				tt.probed, tt.expectOpen, tt.answered, tt.degraded, got, tt.want)
+		}
+	}
+}
+
+func TestIsDrift(t *testing.T) {
+	tests := []struct {
+		expected, observed string
+		want               bool
+	}{
+		{StateOpen, StateOpen, false},
+		{StateOpen, StateClosed, true},
+		{StateOpen, StateFiltered, true},
+		{StateOpen, StateOpenFiltered, false},
+		{StateOpen, StateUnhealthy, true},
+		{StateClosed, StateOpen, true},
+		{StateClosed, StateClosed, false},
+		{StateClosed, StateFiltered, false},
+		{StateClosed, StateOpenFiltered, false},
+		{StateClosed, StateUnhealthy, true},
+		{"", StateClosed, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsDrift(tt.expected, tt.observed); got != tt.want {
+			t.Errorf("IsDrift(%q, %q) = %v, want %v", tt.expected, tt.observed, got, tt.want)
+		}
+	}
+}
+
+func TestTargetsFor(t *testing.T) {
+	declared := func(transport string, port int, expected, checkType, config string) *database.ServerPort {
+		return &database.ServerPort{
+			ID: fmt.Sprintf("%s-%d", transport, port), Port: port, Transport: transport,
+			ExpectedState: expected, CheckType: checkType, CheckConfig: json.RawMessage(config),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		sshPort    int
+		extraPorts []int
+		declared   []*database.ServerPort
+		want       []string
+	}{
+		{
+			name:       "implicit ports only",
+			sshPort:    22,
+			extraPorts: []int{443},
+			want:       []string{"tcp/22 open implicit", "tcp/443 open implicit"},
+		},
+		{
+			name:     "declared port overrides the SSH check",
+			sshPort:  22,
+			declared: []*database.ServerPort{declared("tcp", 22, StateClosed, CheckTCP, "")},
+			want:     []string{"tcp/22 closed tcp declared"},
+		},
+		{
+			name:     "UDP port does not override TCP",
+			sshPort:  53,
+			declared: []*database.ServerPort{declared("udp", 53, StateOpen, CheckTCP, "")},
+			want:     []string{"udp/53 open tcp declared", "tcp/53 open implicit"},
+		},
+		{
+			name:       "extra port equal to the SSH port",
+			sshPort:    22,
+			extraPorts: []int{22, 80},
+			want:       []string{"tcp/22 open implicit", "tcp/80 open implicit"},
+		},
+		{
+			name:       "no SSH port",
+			extraPorts: []int{80},
+			want:       []string{"tcp/80 open implicit"},
+		},
+		{
+			name:     "application check",
+			sshPort:  22,
+			declared: []*database.ServerPort{declared("tcp", 8080, StateOpen, CheckHTTP, `{"path":"/healthz"}`)},
+			want:     []string{"tcp/8080 open http declared", "tcp/22 open implicit"},
+		},
+		{
+			name:     "invalid check falls back to TCP",
+			sshPort:  22,
+			declared: []*database.ServerPort{declared("tcp", 8080, StateOpen, CheckHTTP, `{"path":"healthz"}`)},
+			want:     []string{"tcp/8080 open unchecked declared", "tcp/22 open implicit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(nil, Config{ExtraPorts: tt.extraPorts}, nil)
+			server := &database.Server{Hostname: "web-1", SSHPort: tt.sshPort}
+			var got []string
+			for _, target := range p.targetsFor(server, tt.declared) {
+				desc := fmt.Sprintf("%s/%d %s", target.transport, target.port, target.expected)
+				if target.declared == nil {
+					desc += " implicit"
+				} else {
+					checkType := target.checkType
+					if checkType == "" {
+						checkType = "unchecked"
+					} else if target.check == nil {
+						t.Errorf("%s has no parsed check", desc)
+					}
+					desc += " " + checkType + " declared"
+				}
+				got = append(got, desc)
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("targetsFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package prober
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+// Config controls how often and how aggressively servers are probed.
+type Config struct {
+	Enabled     bool
+	Interval    time.Duration
+	Timeout     time.Duration
+	Jitter      time.Duration
+	Concurrency int
+	// ExtraPorts are probed on every server in addition to its SSH port.
+	ExtraPorts []int
+	// RawRetention is how long individual probe results are kept before only
+	// hourly rollups remain; RollupRetention is how long those are kept.
+	RawRetention    time.Duration
+	RollupRetention time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:     true,
+		Interval:    60 * time.Second,
+		Timeout:     5 * time.Second,
+		Jitter:      5 * time.Second,
+		Concurrency: 20,
+
+		RawRetention:    30 * 24 * time.Hour,
+		RollupRetention: 400 * 24 * time.Hour,
+	}
+}
+
+// ConfigFromEnv reads PROBE_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+
+	envconfig.Bool("PROBE_ENABLED", &cfg.Enabled)
+	envconfig.Duration("PROBE_INTERVAL", &cfg.Interval)
+	envconfig.Duration("PROBE_TIMEOUT", &cfg.Timeout)
+	envconfig.DurationOrZero("PROBE_JITTER", &cfg.Jitter)
+	envconfig.Int("PROBE_CONCURRENCY", &cfg.Concurrency, 1)
+	envconfig.Days("PROBE_RAW_RETENTION_DAYS", &cfg.RawRetention, 1)
+	envconfig.Days("PROBE_ROLLUP_RETENTION_DAYS", &cfg.RollupRetention, 1)
+
+	if v := os.Getenv("PROBE_EXTRA_PORTS"); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			port, err := strconv.Atoi(part)
+			if err != nil || port < 1 || port > 65535 {
+				log.Printf("Ignoring invalid port %q in PROBE_EXTRA_PORTS", part)
+				continue
+			}
+			cfg.ExtraPorts = append(cfg.ExtraPorts, port)
+		}
+	}
+
+	return cfg
+}
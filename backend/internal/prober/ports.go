@@ -0,0 +1,124 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"golang.org/x/crypto/ssh"
+)
+
+// Observed port states.
+const (
+	StateOpen     = "open"
+	StateClosed   = "closed"
+	StateFiltered = "filtered"
+	// StateOpenFiltered is reported for UDP ports that neither answered nor
+	// returned an ICMP port-unreachable, so they may be open or firewalled.
+	StateOpenFiltered = "open|filtered"
+)
+
+// PortResult is the outcome of probing a single port.
+type PortResult struct {
+	State   string
+	Latency time.Duration
+	Err     error
+}
+
+// LatencyMs returns the latency in milliseconds, or nil if the port was not open.
+func (r PortResult) LatencyMs() *float64 {
+	if r.State != StateOpen {
+		return nil
+	}
+	ms := float64(r.Latency) / float64(time.Millisecond)
+	return &ms
+}
+
+// IsDrift reports whether an observed state contradicts the expected one.
+// An unhealthy port is open but failing its application check, so it drifts
+// from either expectation. Ambiguous UDP results are never considered drift.
+func IsDrift(expected, observed string) bool {
+	switch expected {
+	case StateOpen:
+		return observed == StateClosed || observed == StateFiltered || observed == StateUnhealthy
+	case StateClosed:
+		return observed == StateOpen || observed == StateUnhealthy
+	}
+	return false
+}
+
+// ProbePort checks a port over the given transport ("tcp" or "udp").
+func (p *Prober) ProbePort(ctx context.Context, d netDialer, host string, port int, transport string) PortResult {
+	if transport == "udp" {
+		return p.probeUDP(ctx, d, host, port)
+	}
+
+	latency, err := p.ProbeTCP(ctx, d, host, port)
+	if err != nil {
+		return PortResult{State: classifyError(err), Err: err}
+	}
+	return PortResult{State: StateOpen, Latency: latency}
+}
+
+// probeUDP sends an empty datagram and waits for any reply. An ICMP
+// port-unreachable surfaces as ECONNREFUSED on the connected socket.
+func (p *Prober) probeUDP(ctx context.Context, d netDialer, host string, port int) PortResult {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if errors.Is(err, errUDPTunnel) {
+		return PortResult{State: StateOpenFiltered, Err: err}
+	}
+	if err != nil {
+		return PortResult{State: classifyError(err), Err: err}
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	start := time.Now()
+	if _, err := conn.Write([]byte{}); err != nil {
+		return PortResult{State: classifyError(err), Err: err}
+	}
+
+	buf := make([]byte, 512)
+	if _, err := conn.Read(buf); err != nil {
+		if state := classifyError(err); state == StateClosed {
+			return PortResult{State: StateClosed, Err: err}
+		}
+		return PortResult{State: StateOpenFiltered}
+	}
+	return PortResult{State: StateOpen, Latency: time.Since(start)}
+}
+
+func classifyError(err error) string {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return StateClosed
+	}
+	// Through a jump host, a refused connection comes back as a failed
+	// direct-tcpip channel.
+	var chanErr *ssh.OpenChannelError
+	if errors.As(err, &chanErr) && chanErr.Reason == ssh.ConnectionFailed &&
+		strings.Contains(strings.ToLower(chanErr.Message), "refused") {
+		return StateClosed
+	}
+	// Timeouts, unreachable hosts and dropped packets all look the same from here.
+	return StateFiltered
+}
+
+// portsByServer groups declared ports by server ID.
+func portsByServer(ports []*database.ServerPort) map[string][]*database.ServerPort {
+	grouped := make(map[string][]*database.ServerPort)
+	for _, port := range ports {
+		grouped[port.ServerID] = append(grouped[port.ServerID], port)
+	}
+	return grouped
+}
@@ -0,0 +1,351 @@
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+	StatusWarning = "warning"
+)
+
+// Prober periodically probes every server's SSH port, configured extra ports
+// and declared server_ports, and writes the resulting status back through the
+// ServerStore and PortStore. Servers behind jump hosts are probed through an
+// SSH tunnel to the last hop.
+type Prober struct {
+	stores *database.Stores
+	cfg    Config
+	dialer net.Dialer
+	ssh    *sshclient.Dialer
+}
+
+func New(stores *database.Stores, cfg Config, ssh *sshclient.Dialer) *Prober {
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+	return &Prober{
+		stores: stores,
+		cfg:    cfg,
+		dialer: net.Dialer{Timeout: cfg.Timeout},
+		ssh:    ssh,
+	}
+}
+
+// Run probes all servers once immediately and then on every interval until
+// ctx is cancelled.
+func (p *Prober) Run(ctx context.Context) {
+	log.Printf("Prober started (interval=%s timeout=%s concurrency=%d)", p.cfg.Interval, p.cfg.Timeout, p.cfg.Concurrency)
+
+	p.Maintain(time.Now())
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	maintenance := time.NewTicker(time.Hour)
+	defer maintenance.Stop()
+
+	p.ProbeAll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Prober stopped")
+			return
+		case now := <-maintenance.C:
+			p.Maintain(now)
+		case <-ticker.C:
+			p.ProbeAll(ctx)
+		}
+	}
+}
+
+// ProbeAll probes every server with at most cfg.Concurrency probes in flight.
+func (p *Prober) ProbeAll(ctx context.Context) {
+	servers, err := p.stores.Servers.ListProbeTargets()
+	if err != nil {
+		log.Println("Prober: failed to list servers:", err)
+		return
+	}
+
+	declared, err := p.stores.Ports.ListEnabled()
+	if err != nil {
+		log.Println("Prober: failed to list server ports:", err)
+	}
+	ports := portsByServer(declared)
+
+	sem := make(chan struct{}, p.cfg.Concurrency)
+	var wg sync.WaitGroup
+
+	for _, server := range servers {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(server *database.Server, declared []*database.ServerPort) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if !p.sleepJitter(ctx) {
+				return
+			}
+			p.probeServer(ctx, server, declared)
+		}(server, ports[server.ID])
+	}
+
+	wg.Wait()
+}
+
+// sleepJitter waits a random fraction of cfg.Jitter so probes don't all hit
+// the network at the same instant. It returns false if ctx was cancelled.
+func (p *Prober) sleepJitter(ctx context.Context) bool {
+	if p.cfg.Jitter <= 0 {
+		return true
+	}
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(p.cfg.Jitter))))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// target is a single port check on a server. declared is nil for the implicit
+// SSH and PROBE_EXTRA_PORTS checks, which are always expected to be open.
+type target struct {
+	port      int
+	transport string
+	expected  string
+	checkType string
+	check     *CheckConfig
+	declared  *database.ServerPort
+}
+
+// probe checks that the target's port is reachable and, for open TCP ports
+// with an application check, that the service behind it is healthy.
+func (p *Prober) probe(ctx context.Context, d netDialer, host string, t target) PortResult {
+	result := p.ProbePort(ctx, d, host, t.port, t.transport)
+	if result.State != StateOpen || t.transport != "tcp" || t.checkType == "" || t.checkType == CheckTCP {
+		return result
+	}
+
+	start := time.Now()
+	if err := p.RunCheck(ctx, d, host, t.port, t.checkType, t.check); err != nil {
+		return PortResult{State: StateUnhealthy, Err: fmt.Errorf("%s check on %d: %w", t.checkType, t.port, err)}
+	}
+	return PortResult{State: StateOpen, Latency: time.Since(start)}
+}
+
+func (p *Prober) probeServer(ctx context.Context, server *database.Server, declared []*database.ServerPort) {
+	var (
+		expectOpen bool // some target is expected to be open
+		answered   bool // and one of those is
+		degraded   bool
+		latency    *float64
+		lastErr    error
+		checkedAt  = time.Now()
+	)
+
+	d, closeTunnel := p.dialerFor(ctx, server)
+	defer closeTunnel()
+
+	targets := p.targetsFor(server, declared)
+	var history []*database.ProbeResult
+	for _, t := range targets {
+		result := p.probe(ctx, d, server.IPAddress, t)
+		drift := IsDrift(t.expected, result.State)
+
+		if t.expected == StateOpen {
+			expectOpen = true
+			answered = answered || result.State == StateOpen
+		}
+		if result.State == StateOpen {
+			if ms := result.LatencyMs(); latency == nil || *ms < *latency {
+				latency = ms
+			}
+		}
+		if result.Err != nil && t.expected == StateOpen {
+			lastErr = result.Err
+		}
+		if drift {
+			degraded = true
+			if t.declared != nil && !t.declared.Drift {
+				log.Printf("Prober: port drift on %s: %s/%d expected %s, observed %s",
+					server.Hostname, t.transport, t.port, t.expected, result.State)
+			}
+		}
+
+		if t.declared != nil {
+			err := p.stores.Ports.UpdateProbeResult(t.declared.ID, result.State, result.LatencyMs(), errString(result.Err), drift, checkedAt)
+			if err != nil {
+				log.Printf("Prober: failed to store port result for %s %s/%d: %v", server.Hostname, t.transport, t.port, err)
+			}
+			portID := t.declared.ID
+			history = append(history, &database.ProbeResult{
+				ServerID:  server.ID,
+				PortID:    &portID,
+				CheckedAt: checkedAt,
+				State:     result.State,
+				Up:        !drift,
+				LatencyMs: result.LatencyMs(),
+				Error:     errString(result.Err),
+			})
+		}
+	}
+
+	status := serverStatus(len(targets) > 0, expectOpen, answered, degraded)
+
+	errMsg := errString(lastErr)
+	history = append(history, &database.ProbeResult{
+		ServerID:  server.ID,
+		CheckedAt: checkedAt,
+		State:     status,
+		Up:        status != StatusOffline,
+		LatencyMs: latency,
+		Error:     errMsg,
+	})
+	if err := p.stores.Probes.Record(history); err != nil {
+		log.Printf("Prober: failed to record probe history for %s: %v", server.Hostname, err)
+	}
+
+	changed, err := p.stores.Servers.UpdateProbeResult(server.ID, status, latency, errMsg, checkedAt)
+	if err != nil {
+		log.Printf("Prober: failed to store result for %s: %v", server.Hostname, err)
+		return
+	}
+	if changed {
+		log.Printf("Prober: %s (%s) changed status %s -> %s", server.Hostname, server.IPAddress, server.Status, status)
+	}
+}
+
+// serverStatus decides a server's status against what its targets expect.
+// It is offline when none of the ports expected to be open answered, so a
+// server whose ports are all expected and found closed matches its spec and
+// is online. A server without targets cannot be seen and is offline.
+func serverStatus(probed, expectOpen, answered, degraded bool) string {
+	switch {
+	case !probed, expectOpen && !answered:
+		return StatusOffline
+	case degraded:
+		return StatusWarning
+	}
+	return StatusOnline
+}
+
+// targetsFor merges the implicit SSH/extra ports with the server's declared
+// ports. A declared port overrides the implicit check on the same port.
+func (p *Prober) targetsFor(server *database.Server, declared []*database.ServerPort) []target {
+	key := func(transport string, port int) string { return transport + "/" + strconv.Itoa(port) }
+
+	seen := map[string]bool{}
+	var targets []target
+	for _, port := range declared {
+		seen[key(port.Transport, port.Port)] = true
+
+		t := target{port: port.Port, transport: port.Transport, expected: port.ExpectedState, declared: port}
+		check, err := ParseCheckConfig(port.CheckType, port.CheckConfig)
+		if err != nil {
+			log.Printf("Prober: %s %s/%d falls back to a TCP check: %v", server.Hostname, port.Transport, port.Port, err)
+		} else {
+			t.checkType, t.check = port.CheckType, check
+		}
+		targets = append(targets, t)
+	}
+	for _, port := range append([]int{server.SSHPort}, p.cfg.ExtraPorts...) {
+		if port <= 0 || seen[key("tcp", port)] {
+			continue
+		}
+		seen[key("tcp", port)] = true
+		targets = append(targets, target{port: port, transport: "tcp", expected: StateOpen})
+	}
+	return targets
+}
+
+// ProbeTCP opens and immediately closes a TCP connection to host:port and
+// returns how long the handshake took.
+func (p *Prober) ProbeTCP(ctx context.Context, d netDialer, host string, port int) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return 0, fmt.Errorf("tcp/%d: %w", port, err)
+	}
+	elapsed := time.Since(start)
+	conn.Close()
+	return elapsed, nil
+}
+
+func errString(err error) *string {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	return &msg
+}
+
+// netDialer opens connections to a server's ports: a net.Dialer, or an SSH
+// tunnel through the server's jump hosts.
+type netDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// errUDPTunnel is returned for UDP probes of servers behind jump hosts, since
+// SSH only forwards TCP.
+var errUDPTunnel = errors.New("udp cannot be probed through jump hosts")
+
+// tunnelDialer dials through the last jump host in front of a server.
+type tunnelDialer struct {
+	client *ssh.Client
+}
+
+func (t tunnelDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, errUDPTunnel
+	}
+	return t.client.DialContext(ctx, network, addr)
+}
+
+// failedDialer fails every probe of a server whose jump hosts are unreachable.
+type failedDialer struct {
+	err error
+}
+
+func (f failedDialer) DialContext(context.Context, string, string) (net.Conn, error) {
+	return nil, f.err
+}
+
+// dialerFor returns how to reach server's ports and a function that releases
+// the tunnel, if one was opened.
+func (p *Prober) dialerFor(ctx context.Context, server *database.Server) (netDialer, func()) {
+	if p.ssh == nil {
+		return &p.dialer, func() {}
+	}
+	tunnel, err := p.ssh.Tunnel(ctx, server)
+	if err != nil {
+		return failedDialer{err: err}, func() {}
+	}
+	if tunnel == nil {
+		return &p.dialer, func() {}
+	}
+	return tunnelDialer{client: tunnel}, func() { tunnel.Close() }
+}
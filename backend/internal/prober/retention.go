@@ -0,0 +1,46 @@
+package prober
+
+import (
+	"log"
+	"time"
+)
+
+// Maintain keeps the probe history tables in shape: it makes sure upcoming
+// partitions exist, rolls raw results up into hourly buckets and enforces the
+// raw and rollup retention periods.
+func (p *Prober) Maintain(now time.Time) {
+	probes := p.stores.Probes
+
+	if err := probes.EnsurePartitions(now, 1); err != nil {
+		log.Println("Prober: failed to create probe result partitions:", err)
+	}
+
+	// Re-roll from the last bucket so a partially rolled-up hour is completed.
+	hour := now.Truncate(time.Hour)
+	from := hour.Add(-p.cfg.RawRetention)
+	last, err := probes.LastRollupBucket()
+	if err != nil {
+		log.Println("Prober: failed to read last rollup bucket:", err)
+	} else if last != nil && last.After(from) {
+		from = *last
+	}
+	if err := probes.Downsample(from, hour); err != nil {
+		log.Println("Prober: failed to downsample probe results:", err)
+		// Never drop raw data that has not been rolled up.
+		return
+	}
+
+	dropped, err := probes.DropPartitionsBefore(now.Add(-p.cfg.RawRetention))
+	if err != nil {
+		log.Println("Prober: failed to drop expired probe partitions:", err)
+	}
+	for _, name := range dropped {
+		log.Printf("Prober: dropped expired partition %s", name)
+	}
+
+	if n, err := probes.DeleteRollupsBefore(now.Add(-p.cfg.RollupRetention)); err != nil {
+		log.Println("Prober: failed to prune hourly rollups:", err)
+	} else if n > 0 {
+		log.Printf("Prober: pruned %d expired hourly rollups", n)
+	}
+}
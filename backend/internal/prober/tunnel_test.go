@@ -0,0 +1,159 @@
+package prober
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// jumpHost starts an SSH server that forwards direct-tcpip channels and
+// returns a client connected to it along with the addresses it was asked to
+// forward to.
+func jumpHost(t *testing.T) (*ssh.Client, func() []string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var mu sync.Mutex
+	var forwarded []string
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for nc := range chans {
+					var target struct {
+						Host       string
+						Port       uint32
+						OriginHost string
+						OriginPort uint32
+					}
+					if nc.ChannelType() != "direct-tcpip" || ssh.Unmarshal(nc.ExtraData(), &target) != nil {
+						nc.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					addr := net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port)))
+					mu.Lock()
+					forwarded = append(forwarded, addr)
+					mu.Unlock()
+					upstream, err := net.Dial("tcp", addr)
+					if err != nil {
+						nc.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					ch, chReqs, err := nc.Accept()
+					if err != nil {
+						upstream.Close()
+						continue
+					}
+					go ssh.DiscardRequests(chReqs)
+					go func() {
+						defer upstream.Close()
+						io.Copy(upstream, ch)
+					}()
+					go func() {
+						defer ch.Close()
+						io.Copy(ch, upstream)
+					}()
+				}
+			}()
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "probe",
+		HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), forwarded...)
+	}
+}
+
+func TestTunnelDialer(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer web.Close()
+	host, port := hostPort(t, web.URL)
+
+	client, forwarded := jumpHost(t)
+	d := tunnelDialer{client: client}
+	p := New(nil, Config{Timeout: 5 * time.Second}, nil)
+	ctx := context.Background()
+
+	if r := p.ProbePort(ctx, d, host, port, "tcp"); r.State != StateOpen {
+		t.Errorf("ProbePort(tcp) = %s (%v), want %s", r.State, r.Err, StateOpen)
+	}
+	cfg, err := ParseCheckConfig(CheckHTTP, []byte(`{"body_contains":"ok"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RunCheck(ctx, d, host, port, CheckHTTP, cfg); err != nil {
+		t.Errorf("RunCheck(http) through the tunnel: %v", err)
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	if got := forwarded(); len(got) != 2 || got[0] != addr || got[1] != addr {
+		t.Errorf("jump host forwarded to %v, want %s twice", got, addr)
+	}
+
+	if r := p.ProbePort(ctx, d, host, port, "udp"); r.State != StateOpenFiltered || !errors.Is(r.Err, errUDPTunnel) {
+		t.Errorf("ProbePort(udp) = %s (%v), want %s", r.State, r.Err, StateOpenFiltered)
+	}
+	if len(forwarded()) != 2 {
+		t.Error("UDP probe was forwarded through the jump host")
+	}
+
+	client.Close()
+	if _, err := d.DialContext(ctx, "tcp", addr); err == nil {
+		t.Error("DialContext() succeeded after the tunnel was closed")
+	}
+}
+
+func TestDialerForWithoutSSH(t *testing.T) {
+	p := New(nil, Config{Timeout: time.Second}, nil)
+	d, release := p.dialerFor(context.Background(), nil)
+	defer release()
+	if d != &p.dialer {
+		t.Errorf("dialerFor() = %T, want the direct dialer", d)
+	}
+}
@@ -0,0 +1,84 @@
+package recording
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+// Storage backends.
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+type Config struct {
+	Enabled bool
+	// RecordInput also records what the user typed, including passwords
+	// typed at prompts inside the session.
+	RecordInput bool
+	Storage     string
+	// Dir holds recordings with the local backend.
+	Dir string
+	// SpoolDir holds recordings while their session is running.
+	SpoolDir string
+	// Retention is how long recordings are kept; zero keeps them forever.
+	Retention time.Duration
+	S3        S3Config
+}
+
+// S3Config points at an S3-compatible bucket, such as MinIO.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as endpoint/bucket rather than
+	// bucket.endpoint, as MinIO expects by default.
+	PathStyle bool
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:   true,
+		Storage:   StorageLocal,
+		Dir:       "recordings",
+		Retention: 90 * 24 * time.Hour,
+		S3: S3Config{
+			Region:    "us-east-1",
+			PathStyle: true,
+		},
+	}
+}
+
+// ConfigFromEnv reads RECORDING_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Bool("RECORDING_ENABLED", &cfg.Enabled)
+	envconfig.Bool("RECORDING_INPUT", &cfg.RecordInput)
+	if v := os.Getenv("RECORDING_STORAGE"); v != "" {
+		if v == StorageLocal || v == StorageS3 {
+			cfg.Storage = v
+		} else {
+			log.Printf("Invalid RECORDING_STORAGE %q, keeping default", v)
+		}
+	}
+	envconfig.String("RECORDING_DIR", &cfg.Dir)
+	cfg.SpoolDir = filepath.Join(cfg.Dir, ".spool")
+	envconfig.String("RECORDING_SPOOL_DIR", &cfg.SpoolDir)
+	envconfig.Days("RECORDING_RETENTION_DAYS", &cfg.Retention, 0)
+
+	cfg.S3.Endpoint = os.Getenv("RECORDING_S3_ENDPOINT")
+	cfg.S3.Bucket = os.Getenv("RECORDING_S3_BUCKET")
+	cfg.S3.Prefix = os.Getenv("RECORDING_S3_PREFIX")
+	cfg.S3.AccessKey = os.Getenv("RECORDING_S3_ACCESS_KEY")
+	cfg.S3.SecretKey = os.Getenv("RECORDING_S3_SECRET_KEY")
+	envconfig.String("RECORDING_S3_REGION", &cfg.S3.Region)
+	envconfig.Bool("RECORDING_S3_PATH_STYLE", &cfg.S3.PathStyle)
+	return cfg
+}
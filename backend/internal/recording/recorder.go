@@ -0,0 +1,471 @@
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/google/uuid"
+)
+
+// Asciicast v2 event codes.
+const (
+	eventOutput = "o"
+	eventInput  = "i"
+	eventResize = "r"
+)
+
+// Session describes the terminal session being recorded.
+type Session struct {
+	UserID         string
+	UserEmail      string
+	ServerID       string
+	ServerHostname string
+	RemoteAddr     string
+	Cols           int
+	Rows           int
+}
+
+// Manager starts recordings, uploads them to storage when their session ends
+// and enforces retention.
+type Manager struct {
+	stores  *database.Stores
+	cfg     Config
+	storage Storage
+
+	mu   sync.Mutex
+	live map[string]*Recorder
+}
+
+func New(stores *database.Stores, cfg Config) (*Manager, error) {
+	storage, err := NewStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.SpoolDir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		stores:  stores,
+		cfg:     cfg,
+		storage: storage,
+		live:    make(map[string]*Recorder),
+	}, nil
+}
+
+// RecordInput reports whether terminal input should be recorded.
+func (m *Manager) RecordInput() bool {
+	return m.cfg.RecordInput
+}
+
+func (m *Manager) spoolPath(id string) string {
+	return filepath.Join(m.cfg.SpoolDir, id+".cast")
+}
+
+// Start creates the recording index entry and spool file and writes the
+// asciicast header.
+func (m *Manager) Start(s Session) (*Recorder, error) {
+	now := time.Now()
+	id := uuid.New().String()
+	rec := &database.Recording{
+		ID:             id,
+		UserID:         optional(s.UserID),
+		ServerID:       optional(s.ServerID),
+		UserEmail:      s.UserEmail,
+		ServerHostname: s.ServerHostname,
+		Storage:        m.storage.Name(),
+		StorageKey:     now.UTC().Format("2006/01/02/") + id + ".cast",
+		Status:         database.RecordingActive,
+		Cols:           s.Cols,
+		Rows:           s.Rows,
+		InputRecorded:  m.cfg.RecordInput,
+		RemoteAddr:     optional(s.RemoteAddr),
+		StartedAt:      now,
+	}
+
+	f, err := os.OpenFile(m.spoolPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{manager: m, rec: rec, file: f, buf: bufio.NewWriter(f), start: now}
+
+	r.writeLine(castHeader(s, now))
+	if r.err == nil {
+		r.err = r.buf.Flush()
+	}
+	if r.err != nil {
+		f.Close()
+		os.Remove(m.spoolPath(id))
+		return nil, r.err
+	}
+
+	if err := m.stores.Recordings.Create(rec); err != nil {
+		f.Close()
+		os.Remove(m.spoolPath(id))
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.live[id] = r
+	m.mu.Unlock()
+	return r, nil
+}
+
+// castHeader is the asciicast v2 header line of a session started at start.
+func castHeader(s Session, start time.Time) []byte {
+	line, _ := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     s.Cols,
+		"height":    s.Rows,
+		"timestamp": start.Unix(),
+		"title":     fmt.Sprintf("%s@%s", s.UserEmail, s.ServerHostname),
+		"env":       map[string]string{"TERM": "xterm-256color"},
+	})
+	return line
+}
+
+// Live returns the recorder of a session that is still running.
+func (m *Manager) Live(id string) *Recorder {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.live[id]
+}
+
+// Open returns the stored asciicast of a finished recording.
+func (m *Manager) Open(ctx context.Context, rec *database.Recording) (io.ReadCloser, error) {
+	if rec.Storage != m.storage.Name() {
+		return nil, fmt.Errorf("recording is in %s storage, but %s is configured", rec.Storage, m.storage.Name())
+	}
+	return m.storage.Open(ctx, rec.StorageKey)
+}
+
+// Delete removes a recording from storage and the index.
+func (m *Manager) Delete(ctx context.Context, rec *database.Recording) error {
+	if rec.Storage == m.storage.Name() {
+		if err := m.storage.Delete(ctx, rec.StorageKey); err != nil {
+			return err
+		}
+	}
+	return m.stores.Recordings.Delete(rec.ID)
+}
+
+// Run hourly retries recordings that could not be uploaded, including those
+// left behind by a previous run, and applies the retention period until ctx
+// is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		m.recover(ctx)
+		m.expire(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recover uploads the spool files of unfinished recordings that no session
+// is writing to. Recordings cut short by a restart are marked interrupted.
+func (m *Manager) recover(ctx context.Context) {
+	active, err := m.stores.Recordings.ListActive()
+	if err != nil {
+		log.Printf("Failed to list active recordings: %v", err)
+		return
+	}
+	for _, rec := range active {
+		if m.Live(rec.ID) != nil {
+			continue
+		}
+		now := time.Now()
+		rec.EndedAt = &now
+		rec.Status = database.RecordingInterrupted
+
+		size, err := m.upload(ctx, rec)
+		switch {
+		case os.IsNotExist(err):
+			// Another instance may still be writing it.
+			if time.Since(rec.StartedAt) < 24*time.Hour {
+				continue
+			}
+			rec.Status = database.RecordingFailed
+			msg := "spool file missing"
+			rec.Error = &msg
+		case err != nil:
+			log.Printf("Failed to upload interrupted recording %s: %v", rec.ID, err)
+			continue
+		default:
+			rec.SizeBytes = size
+			os.Remove(m.spoolPath(rec.ID))
+		}
+		if err := m.stores.Recordings.Finish(rec); err != nil {
+			log.Printf("Failed to update recording %s: %v", rec.ID, err)
+		}
+	}
+}
+
+func (m *Manager) expire(ctx context.Context) {
+	if m.cfg.Retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-m.cfg.Retention)
+	for {
+		expired, err := m.stores.Recordings.ListEndedBefore(cutoff, 100)
+		if err != nil {
+			log.Printf("Failed to list expired recordings: %v", err)
+			return
+		}
+		for _, rec := range expired {
+			if err := m.Delete(ctx, rec); err != nil {
+				log.Printf("Failed to delete expired recording %s: %v", rec.ID, err)
+				return
+			}
+		}
+		if len(expired) < 100 {
+			return
+		}
+	}
+}
+
+// upload copies a spool file to storage and returns its size.
+func (m *Manager) upload(ctx context.Context, rec *database.Recording) (int64, error) {
+	f, err := os.Open(m.spoolPath(rec.ID))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := m.storage.Put(ctx, rec.StorageKey, f, info.Size()); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Recorder writes one session as asciicast v2. Write, Input and Resize never
+// fail, so a recording problem cannot break the terminal; the first error is
+// kept and reported when the recording is closed.
+type Recorder struct {
+	manager *Manager
+	rec     *database.Recording
+	start   time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	err    error
+	closed bool
+	// pending holds a trailing partial UTF-8 sequence per event code, since
+	// asciicast event data must be valid UTF-8.
+	pending map[string][]byte
+	subs    map[chan []byte]struct{}
+}
+
+// ID is the recording ID.
+func (r *Recorder) ID() string {
+	return r.rec.ID
+}
+
+// Write records terminal output; it is meant to sit in a MultiWriter next to
+// the terminal.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.event(eventOutput, p)
+	return len(p), nil
+}
+
+// Input records terminal input.
+func (r *Recorder) Input(p []byte) {
+	r.event(eventInput, p)
+}
+
+// Resize records a terminal size change.
+func (r *Recorder) Resize(cols, rows int) {
+	r.event(eventResize, []byte(fmt.Sprintf("%dx%d", cols, rows)))
+}
+
+func (r *Recorder) event(code string, p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.err != nil {
+		return
+	}
+
+	data := p
+	if code != eventResize {
+		if prev := r.pending[code]; len(prev) > 0 {
+			data = append(append([]byte(nil), prev...), p...)
+			delete(r.pending, code)
+		}
+		if cut := incompleteSuffix(data); cut > 0 {
+			if r.pending == nil {
+				r.pending = make(map[string][]byte)
+			}
+			r.pending[code] = append([]byte(nil), data[len(data)-cut:]...)
+			data = data[:len(data)-cut]
+		}
+		if len(data) == 0 {
+			return
+		}
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+	// json.Marshal replaces invalid UTF-8 with U+FFFD.
+	text, _ := json.Marshal(string(data))
+	line := []byte("[" + strconv.FormatFloat(elapsed, 'f', 6, 64) + `,"` + code + `",`)
+	line = append(line, text...)
+	line = append(line, ']')
+	r.writeLine(line)
+	if r.err == nil {
+		r.err = r.buf.Flush()
+	}
+}
+
+// writeLine appends a line to the spool file and hands it to subscribers.
+// Callers hold mu, except for the header, which is written before the
+// recorder is shared.
+func (r *Recorder) writeLine(line []byte) {
+	line = append(line, '\n')
+	if _, err := r.buf.Write(line); err != nil && r.err == nil {
+		r.err = err
+	}
+	for ch := range r.subs {
+		select {
+		case ch <- line:
+		default:
+			// A reader that cannot keep up is dropped rather than
+			// stalling the terminal.
+			delete(r.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// incompleteSuffix returns the length of a partial UTF-8 sequence at the end
+// of p.
+func incompleteSuffix(p []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(p); i++ {
+		c := p[len(p)-i]
+		if c < 0x80 {
+			return 0
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(p[len(p)-i:]) {
+				return i
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// Subscribe returns what has been recorded so far and a channel of the lines
+// that follow. The channel is closed when the recording ends or the
+// subscriber falls behind; cancel stops the subscription early.
+func (r *Recorder) Subscribe() (snapshot []byte, lines <-chan []byte, cancel func(), err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, nil, nil, fmt.Errorf("recording %s has ended", r.rec.ID)
+	}
+	snapshot, err = os.ReadFile(r.file.Name())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ch := make(chan []byte, 256)
+	if r.subs == nil {
+		r.subs = make(map[chan []byte]struct{})
+	}
+	r.subs[ch] = struct{}{}
+	cancel = func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.subs[ch]; ok {
+			delete(r.subs, ch)
+			close(ch)
+		}
+	}
+	return snapshot, ch, cancel, nil
+}
+
+// Close finishes the recording with the session's exit code, if known,
+// uploads it and updates the index.
+func (r *Recorder) Close(exitCode *int) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	for code, rest := range r.pending {
+		text, _ := json.Marshal(string(rest))
+		elapsed := strconv.FormatFloat(time.Since(r.start).Seconds(), 'f', 6, 64)
+		r.writeLine(append([]byte("["+elapsed+`,"`+code+`",`), append(text, ']')...))
+	}
+	r.closed = true
+	if err := r.buf.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	for ch := range r.subs {
+		close(ch)
+	}
+	r.subs = nil
+	writeErr := r.err
+	r.mu.Unlock()
+
+	m := r.manager
+	rec := r.rec
+	now := time.Now()
+	rec.EndedAt = &now
+	rec.ExitCode = exitCode
+	rec.Status = database.RecordingComplete
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	size, err := m.upload(ctx, rec)
+	if err == nil {
+		rec.SizeBytes = size
+		os.Remove(m.spoolPath(rec.ID))
+		if writeErr != nil {
+			msg := "recording truncated: " + writeErr.Error()
+			rec.Error = &msg
+		}
+	} else {
+		// The spool file stays behind for recovery on the next start.
+		rec.Status = database.RecordingActive
+		log.Printf("Failed to upload recording %s: %v", rec.ID, err)
+	}
+
+	m.mu.Lock()
+	delete(m.live, rec.ID)
+	m.mu.Unlock()
+
+	if rec.Status == database.RecordingActive {
+		return err
+	}
+	if dbErr := m.stores.Recordings.Finish(rec); dbErr != nil {
+		return dbErr
+	}
+	return writeErr
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
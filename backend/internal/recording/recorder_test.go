@@ -0,0 +1,130 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCastHeader(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	line := castHeader(Session{UserEmail: "alice@example.org", ServerHostname: "web-1", Cols: 120, Rows: 40}, start)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(line, &got); err != nil {
+		t.Fatalf("header %s: %v", line, err)
+	}
+	want := map[string]interface{}{
+		"version":   float64(2),
+		"width":     float64(120),
+		"height":    float64(40),
+		"timestamp": float64(1700000000),
+		"title":     "alice@example.org@web-1",
+		"env":       map[string]interface{}{"TERM": "xterm-256color"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("header = %v, want %v", got, want)
+	}
+	if bytes.ContainsRune(line, '\n') {
+		t.Error("header spans several lines")
+	}
+}
+
+// newTestRecorder records into a buffer instead of a spool file.
+func newTestRecorder() (*Recorder, *bytes.Buffer) {
+	var out bytes.Buffer
+	return &Recorder{buf: bufio.NewWriter(&out), start: time.Now()}, &out
+}
+
+// events decodes recorded asciicast event lines into code and data.
+func events(t *testing.T, out *bytes.Buffer) [][2]string {
+	t.Helper()
+	var got [][2]string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var ev []interface{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("event %s: %v", line, err)
+		}
+		if len(ev) != 3 {
+			t.Fatalf("event %s has %d fields, want 3", line, len(ev))
+		}
+		if elapsed, ok := ev[0].(float64); !ok || elapsed < 0 {
+			t.Errorf("event %s has time %v", line, ev[0])
+		}
+		got = append(got, [2]string{ev[1].(string), ev[2].(string)})
+	}
+	return got
+}
+
+func TestRecorderEvents(t *testing.T) {
+	r, out := newTestRecorder()
+	r.Write([]byte("$ ls\r\n"))
+	r.Input([]byte("q"))
+	r.Resize(100, 30)
+	r.Write([]byte("\x1b[31m\"quoted\"\x1b[0m"))
+
+	want := [][2]string{
+		{eventOutput, "$ ls\r\n"},
+		{eventInput, "q"},
+		{eventResize, "100x30"},
+		{eventOutput, "\x1b[31m\"quoted\"\x1b[0m"},
+	}
+	if got := events(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestRecorderSplitRune(t *testing.T) {
+	r, out := newTestRecorder()
+	euro := []byte("€") // e2 82 ac
+	r.Write(append([]byte("price: "), euro[:2]...))
+	// Input keeps its own pending bytes.
+	r.Input([]byte("y"))
+	r.Write(append(euro[2:], '!'))
+	// Only a partial sequence is held back entirely.
+	r.Write(euro[:1])
+	r.Write(euro[1:])
+
+	want := [][2]string{
+		{eventOutput, "price: "},
+		{eventInput, "y"},
+		{eventOutput, "€!"},
+		{eventOutput, "€"},
+	}
+	if got := events(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestIncompleteSuffix(t *testing.T) {
+	euro := "€"          // 3 bytes
+	grin := "\U0001F600" // 4 bytes
+	tests := []struct {
+		name string
+		in   string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "abc", 0},
+		{"complete 3-byte", "a" + euro, 0},
+		{"complete 4-byte", grin, 0},
+		{"1 of 3", "a" + euro[:1], 1},
+		{"2 of 3", "a" + euro[:2], 2},
+		{"3 of 4", grin[:3], 3},
+		{"lone continuation byte", "a\x82", 0},
+		{"invalid lead byte", "a\xff", 0},
+	}
+
+	for _, tt := range tests {
+		if got := incompleteSuffix([]byte(tt.in)); got != tt.want {
+			t.Errorf("%s: incompleteSuffix(%q) = %d, want %d", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
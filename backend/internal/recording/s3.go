@@ -0,0 +1,239 @@
+package recording
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps recordings in an S3-compatible bucket. It signs requests
+// with AWS Signature Version 4 and only implements the three object
+// operations recordings need.
+type S3Storage struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("RECORDING_S3_ENDPOINT and RECORDING_S3_BUCKET are required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("RECORDING_S3_ACCESS_KEY and RECORDING_S3_SECRET_KEY are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid RECORDING_S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	return &S3Storage{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (s *S3Storage) Name() string { return StorageS3 }
+
+// objectURL addresses key in the bucket, path-style or virtual-hosted.
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	object := strings.TrimSuffix(s.cfg.Prefix, "/")
+	if object != "" {
+		object += "/"
+	}
+	object += key
+
+	base := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		u.Path = base + "/" + s.cfg.Bucket + "/" + object
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = base + "/" + object
+	}
+	u.RawPath = ""
+	return &u
+}
+
+func (s *S3Storage) do(ctx context.Context, method, key string, body io.ReadSeeker, size int64) (*http.Response, error) {
+	u := s.objectURL(key)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := emptySHA256
+	if body != nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, body); err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+		req.Header.Set("Content-Type", "application/x-asciicast")
+	}
+	signV4(req, payloadHash, s.cfg.Region, s.cfg.AccessKey, s.cfg.SecretKey, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &S3Error{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.ReadSeeker, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, key, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		var s3err *S3Error
+		if errors.As(err, &s3err) && s3err.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// S3Error is a non-2xx response from the object store.
+type S3Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: status %d: %s", e.StatusCode, e.Body)
+}
+
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 adds an AWS Signature Version 4 Authorization header covering the
+// host, x-amz-* and any headers already set on req.
+func signV4(req *http.Request, payloadHash, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalURI percent-encodes each segment of path the way S3 does, which
+// escapes characters such as $ that Go leaves alone.
+func canonicalURI(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = awsEscape(seg)
+	}
+	if uri := strings.Join(segments, "/"); uri != "" {
+		return uri
+	}
+	return "/"
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but the RFC 3986 unreserved characters.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
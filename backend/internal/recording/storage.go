@@ -0,0 +1,98 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage keeps finished recordings.
+type Storage interface {
+	// Name identifies the backend in the recording index.
+	Name() string
+	// Put stores size bytes read from r under key.
+	Put(ctx context.Context, key string, r io.ReadSeeker, size int64) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStorage builds the backend selected by cfg.
+func NewStorage(cfg Config) (Storage, error) {
+	switch cfg.Storage {
+	case StorageLocal:
+		if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+			return nil, err
+		}
+		return LocalStorage{Dir: cfg.Dir}, nil
+	case StorageS3:
+		return NewS3Storage(cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown recording storage %q", cfg.Storage)
+}
+
+// LocalStorage keeps recordings in a directory.
+type LocalStorage struct {
+	Dir string
+}
+
+func (LocalStorage) Name() string { return StorageLocal }
+
+func (s LocalStorage) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, "/") {
+		return "", errors.New("invalid recording key")
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+func (s LocalStorage) Put(ctx context.Context, key string, r io.ReadSeeker, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s LocalStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoragePath(t *testing.T) {
+	s := LocalStorage{Dir: "/var/lib/cmdb/recordings"}
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"2026/03/01/a.cast", "/var/lib/cmdb/recordings/2026/03/01/a.cast", false},
+		{"a.cast", "/var/lib/cmdb/recordings/a.cast", false},
+		{"", "", true},
+		{"/etc/passwd", "", true},
+		{"../secrets", "", true},
+		{"2026/../../etc/passwd", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := s.path(tt.key)
+		if (err != nil) != tt.wantErr || got != filepath.FromSlash(tt.want) {
+			t.Errorf("path(%q) = %q, %v, want %q, error %v", tt.key, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	s := LocalStorage{Dir: t.TempDir()}
+	ctx := context.Background()
+	data := []byte("recording")
+
+	if err := s.Put(ctx, "2026/03/01/a.cast", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir, "2026", "03", "01", "a.cast.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+	rc, err := s.Open(ctx, "2026/03/01/a.cast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("Open returned %q, want %q", got, data)
+	}
+
+	if err := s.Delete(ctx, "2026/03/01/a.cast"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "2026/03/01/a.cast"); err != nil {
+		t.Errorf("Delete of a missing recording: %v", err)
+	}
+	if err := s.Put(ctx, "../escape.cast", bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("Put accepted a key outside the directory")
+	}
+}
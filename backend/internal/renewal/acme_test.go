@@ -0,0 +1,384 @@
+package renewal
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"golang.org/x/crypto/acme"
+)
+
+// fakeCA is a minimal in-process RFC 8555 directory. It issues one
+// authorization per identifier with a single http-01 challenge, which it
+// validates by asking the test's HTTP01Server for the key authorization.
+type fakeCA struct {
+	t      *testing.T
+	srv    *httptest.Server
+	http01 http.Handler
+	// keyAuth computes the expected key authorization for a token.
+	keyAuth func(token string) string
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu      sync.Mutex
+	authzs  map[string]*fakeAuthz
+	order   *fakeOrder
+	leafPEM []byte
+	// validated lists the domains whose challenge was answered correctly.
+	validated []string
+}
+
+type fakeAuthz struct {
+	Domain string
+	Token  string
+	Status string
+}
+
+type fakeOrder struct {
+	Domains []string
+	Status  string
+}
+
+func newFakeCA(t *testing.T, http01 http.Handler) *fakeCA {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &fakeCA{t: t, http01: http01, caKey: caKey, caCert: caCert, authzs: make(map[string]*fakeAuthz)}
+	ca.srv = httptest.NewServer(http.HandlerFunc(ca.serve))
+	t.Cleanup(ca.srv.Close)
+	return ca
+}
+
+func (ca *fakeCA) url(path string) string { return ca.srv.URL + path }
+
+func (ca *fakeCA) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	if r.URL.Path == "/directory" {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   ca.url("/new-nonce"),
+			"newAccount": ca.url("/new-account"),
+			"newOrder":   ca.url("/new-order"),
+			"revokeCert": ca.url("/revoke-cert"),
+			"keyChange":  ca.url("/key-change"),
+		})
+		return
+	}
+	if r.URL.Path == "/new-nonce" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	payload := ca.payload(r)
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/new-account":
+		w.Header().Set("Location", ca.url("/account/1"))
+		writeJSON(w, http.StatusCreated, map[string]string{"status": acme.StatusValid})
+
+	case r.URL.Path == "/new-order":
+		var req struct {
+			Identifiers []struct{ Type, Value string }
+		}
+		if err := json.Unmarshal(payload, &req); err != nil {
+			ca.t.Errorf("new-order payload: %v", err)
+		}
+		ca.order = &fakeOrder{Status: acme.StatusPending}
+		for i, id := range req.Identifiers {
+			ca.order.Domains = append(ca.order.Domains, id.Value)
+			ca.authzs[fmt.Sprint(i)] = &fakeAuthz{Domain: id.Value, Token: fmt.Sprintf("token-%d", i), Status: acme.StatusPending}
+		}
+		w.Header().Set("Location", ca.url("/order/1"))
+		writeJSON(w, http.StatusCreated, ca.orderJSON())
+
+	case r.URL.Path == "/order/1":
+		writeJSON(w, http.StatusOK, ca.orderJSON())
+
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		a := ca.authzs[strings.TrimPrefix(r.URL.Path, "/authz/")]
+		writeJSON(w, http.StatusOK, ca.authzJSON(a, strings.TrimPrefix(r.URL.Path, "/authz/")))
+
+	case strings.HasPrefix(r.URL.Path, "/chall/"):
+		id := strings.TrimPrefix(r.URL.Path, "/chall/")
+		a := ca.authzs[id]
+		ca.validate(a)
+		writeJSON(w, http.StatusOK, ca.challengeJSON(a, id))
+
+	case r.URL.Path == "/finalize/1":
+		var req struct{ CSR string }
+		if err := json.Unmarshal(payload, &req); err != nil {
+			ca.t.Errorf("finalize payload: %v", err)
+		}
+		if ca.order.Status != acme.StatusReady {
+			writeJSON(w, http.StatusForbidden, map[string]string{"type": "urn:ietf:params:acme:error:orderNotReady"})
+			return
+		}
+		ca.issue(req.CSR)
+		w.Header().Set("Location", ca.url("/order/1"))
+		writeJSON(w, http.StatusOK, ca.orderJSON())
+
+	case r.URL.Path == "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.leafPEM)
+		pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// payload decodes the JWS body of a request. Signatures are not checked.
+func (ca *fakeCA) payload(r *http.Request) []byte {
+	var jws struct{ Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		ca.t.Errorf("%s: decoding JWS: %v", r.URL.Path, err)
+		return nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		ca.t.Errorf("%s: decoding payload: %v", r.URL.Path, err)
+	}
+	return b
+}
+
+// validate fetches the challenge response like a CA would and marks the
+// authorization, and the order once all are valid, accordingly.
+func (ca *fakeCA) validate(a *fakeAuthz) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://"+a.Domain+"/.well-known/acme-challenge/"+a.Token, nil)
+	ca.http01.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK || string(body) != ca.keyAuth(a.Token) {
+		a.Status = acme.StatusInvalid
+		ca.order.Status = acme.StatusInvalid
+		return
+	}
+	a.Status = acme.StatusValid
+	ca.validated = append(ca.validated, a.Domain)
+	for _, other := range ca.authzs {
+		if other.Status != acme.StatusValid {
+			return
+		}
+	}
+	ca.order.Status = acme.StatusReady
+}
+
+func (ca *fakeCA) issue(csrB64 string) {
+	der, err := base64.RawURLEncoding.DecodeString(csrB64)
+	if err != nil {
+		ca.t.Errorf("decoding CSR: %v", err)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		ca.t.Errorf("parsing CSR: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(csr.DNSNames, ca.order.Domains) {
+		ca.t.Errorf("CSR names %v, order names %v", csr.DNSNames, ca.order.Domains)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, tmpl, ca.caCert, csr.PublicKey, ca.caKey)
+	if err != nil {
+		ca.t.Errorf("issuing certificate: %v", err)
+		return
+	}
+	ca.leafPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf})
+	ca.order.Status = acme.StatusValid
+}
+
+func (ca *fakeCA) orderJSON() map[string]interface{} {
+	var ids []map[string]string
+	var authzURLs []string
+	for i, d := range ca.order.Domains {
+		ids = append(ids, map[string]string{"type": "dns", "value": d})
+		authzURLs = append(authzURLs, ca.url(fmt.Sprintf("/authz/%d", i)))
+	}
+	o := map[string]interface{}{
+		"status":         ca.order.Status,
+		"identifiers":    ids,
+		"authorizations": authzURLs,
+		"finalize":       ca.url("/finalize/1"),
+	}
+	if ca.order.Status == acme.StatusValid {
+		o["certificate"] = ca.url("/cert/1")
+	}
+	return o
+}
+
+func (ca *fakeCA) authzJSON(a *fakeAuthz, id string) map[string]interface{} {
+	return map[string]interface{}{
+		"status":     a.Status,
+		"identifier": map[string]string{"type": "dns", "value": a.Domain},
+		"challenges": []interface{}{ca.challengeJSON(a, id)},
+	}
+}
+
+func (ca *fakeCA) challengeJSON(a *fakeAuthz, id string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":   ChallengeHTTP01,
+		"url":    ca.url("/chall/" + id),
+		"token":  a.Token,
+		"status": a.Status,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+type recordingDeliverer struct {
+	chain  [][]byte
+	keyDER []byte
+	err    error
+}
+
+func (d *recordingDeliverer) Deliver(_ context.Context, _ *database.Server, _ *database.RenewalSettings, chain [][]byte, keyDER []byte) error {
+	d.chain, d.keyDER = chain, keyDER
+	return d.err
+}
+
+// newTestIssuer returns a Renewer with an http-01 responder and a registered
+// client for a fake CA.
+func newTestIssuer(t *testing.T) (*Renewer, *acme.Client, *fakeCA, *recordingDeliverer) {
+	t.Helper()
+	http01 := NewHTTP01Server()
+	deliverer := &recordingDeliverer{}
+	r := &Renewer{solvers: make(map[string]Solver), deliverer: deliverer}
+	r.RegisterSolver(SolverHTTP01, http01)
+
+	ca := newFakeCA(t, http01)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: key, DirectoryURL: ca.url("/directory"), HTTPClient: ca.srv.Client()}
+	ca.keyAuth = func(token string) string {
+		v, err := client.HTTP01ChallengeResponse(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	if _, err := client.Register(context.Background(), &acme.Account{}, acme.AcceptTOS); err != nil {
+		t.Fatalf("registering account: %v", err)
+	}
+	return r, client, ca, deliverer
+}
+
+func TestIssue(t *testing.T) {
+	r, client, ca, deliverer := newTestIssuer(t)
+	domains := []string{"example.org", "www.example.org"}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	chain, keyDER, err := r.issue(ctx, client, domains, r.Solver(SolverHTTP01), &database.Server{}, &database.RenewalSettings{})
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+
+	if !reflect.DeepEqual(ca.validated, domains) {
+		t.Errorf("validated %v, want %v", ca.validated, domains)
+	}
+	if len(chain) != 2 || chain[1].Subject.CommonName != "Fake ACME CA" {
+		t.Fatalf("chain = %d certificates, want leaf and issuer", len(chain))
+	}
+	if !reflect.DeepEqual(chain[0].DNSNames, domains) {
+		t.Errorf("leaf names %v, want %v", chain[0].DNSNames, domains)
+	}
+
+	// The delivered key must belong to the issued leaf.
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.(*ecdsa.PrivateKey).PublicKey.Equal(chain[0].PublicKey) {
+		t.Error("returned key does not match the leaf")
+	}
+	if len(deliverer.chain) != 2 || !reflect.DeepEqual(deliverer.keyDER, keyDER) {
+		t.Errorf("delivered %d certificates and key %v, want the issued chain and key", len(deliverer.chain), deliverer.keyDER != nil)
+	}
+
+	// Challenges are cleaned up once answered.
+	rec := httptest.NewRecorder()
+	r.Solver(SolverHTTP01).(*HTTP01Server).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/token-0", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("challenge still served after issue: status %d", rec.Code)
+	}
+}
+
+func TestIssueFailedChallenge(t *testing.T) {
+	r, client, ca, deliverer := newTestIssuer(t)
+	ca.keyAuth = func(string) string { return "something else" }
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, _, err := r.issue(ctx, client, []string{"example.org"}, r.Solver(SolverHTTP01), &database.Server{}, &database.RenewalSettings{})
+	if err == nil || !strings.Contains(err.Error(), "authorization failed") {
+		t.Fatalf("issue error = %v, want an authorization failure", err)
+	}
+	if deliverer.chain != nil {
+		t.Error("certificate delivered although the challenge failed")
+	}
+}
+
+func TestIssueDeliveryError(t *testing.T) {
+	r, client, _, deliverer := newTestIssuer(t)
+	deliverer.err = fmt.Errorf("permission denied")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, _, err := r.issue(ctx, client, []string{"example.org"}, r.Solver(SolverHTTP01), &database.Server{}, &database.RenewalSettings{})
+	if err == nil || !strings.Contains(err.Error(), "delivering certificate") {
+		t.Fatalf("issue error = %v, want a delivery error", err)
+	}
+}
@@ -0,0 +1,58 @@
+package renewal
+
+import (
+	"os"
+	"time"
+
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+// LetsEncryptDirectory is the production Let's Encrypt ACME directory.
+const LetsEncryptDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+
+type Config struct {
+	Enabled      bool
+	DirectoryURL string
+	Email        string
+	// RenewWindow is how long before expiry a certificate is renewed.
+	RenewWindow time.Duration
+	Interval    time.Duration
+	// CABundle is a PEM file trusted for the ACME directory, e.g. Pebble's
+	// test CA. The system pool is used when empty.
+	CABundle string
+
+	// HTTP01Addr enables the built-in http-01 responder on this address.
+	HTTP01Addr string
+	// DNS01Command enables the dns-01-exec solver. It is run as
+	// "<command> present|cleanup <fqdn> <value>".
+	DNS01Command string
+	// DNS01HookURL enables the dns-01-hook solver, which speaks the
+	// pebble-challtestsrv management API (/set-txt and /clear-txt).
+	DNS01HookURL         string
+	DNS01PropagationWait time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Enabled:      false,
+		DirectoryURL: LetsEncryptDirectory,
+		RenewWindow:  30 * 24 * time.Hour,
+		Interval:     12 * time.Hour,
+	}
+}
+
+// ConfigFromEnv reads ACME_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Bool("ACME_ENABLED", &cfg.Enabled)
+	envconfig.String("ACME_DIRECTORY_URL", &cfg.DirectoryURL)
+	cfg.Email = os.Getenv("ACME_EMAIL")
+	envconfig.Days("ACME_RENEW_WINDOW_DAYS", &cfg.RenewWindow, 1)
+	envconfig.Duration("ACME_CHECK_INTERVAL", &cfg.Interval)
+	cfg.CABundle = os.Getenv("ACME_CA_BUNDLE")
+	cfg.HTTP01Addr = os.Getenv("ACME_HTTP01_ADDR")
+	cfg.DNS01Command = os.Getenv("ACME_DNS01_COMMAND")
+	cfg.DNS01HookURL = os.Getenv("ACME_DNS01_HOOK_URL")
+	envconfig.DurationOrZero("ACME_DNS01_PROPAGATION_WAIT", &cfg.DNS01PropagationWait)
+	return cfg
+}
@@ -0,0 +1,101 @@
+package renewal
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient"
+	"golang.org/x/crypto/ssh"
+)
+
+// Deliverer installs a renewed certificate on its server.
+type Deliverer interface {
+	// Deliver installs chain (DER, leaf first) and the PKCS#8 key.
+	Deliver(ctx context.Context, server *database.Server, settings *database.RenewalSettings, chain [][]byte, keyDER []byte) error
+}
+
+// SSHDeliverer writes the files over SSH and runs the reload command.
+type SSHDeliverer struct {
+	Dialer *sshclient.Dialer
+}
+
+func (d SSHDeliverer) Deliver(ctx context.Context, server *database.Server, settings *database.RenewalSettings, chain [][]byte, keyDER []byte) error {
+	client, err := d.Dialer.Dial(ctx, server)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var files []pendingFile
+	if settings.ChainPath != nil && *settings.ChainPath != "" {
+		files = append(files,
+			pendingFile{settings.CertPath, encodeCerts(chain[:1]), "022"},
+			pendingFile{*settings.ChainPath, encodeCerts(chain[1:]), "022"})
+	} else {
+		files = append(files, pendingFile{settings.CertPath, encodeCerts(chain), "022"})
+	}
+	// The key goes last, so an install that stops halfway keeps the old key
+	files = append(files, pendingFile{settings.KeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), "077"})
+	if err := installFiles(client, files); err != nil {
+		return err
+	}
+
+	if settings.ReloadCommand != nil && *settings.ReloadCommand != "" {
+		if _, err := sshclient.Run(client, *settings.ReloadCommand, nil); err != nil {
+			return fmt.Errorf("reload: %w", err)
+		}
+	}
+	return nil
+}
+
+type pendingFile struct {
+	path  string
+	data  []byte
+	umask string
+}
+
+// installFiles uploads every file next to its target and only then moves
+// them all into place with one command, so a failed upload never leaves the
+// server with a new key and the old certificate.
+func installFiles(client *ssh.Client, files []pendingFile) error {
+	for i, f := range files {
+		tmp := f.path + ".tmp"
+		cmd := fmt.Sprintf("umask %s && cat > %s", f.umask, sshclient.Quote(tmp))
+		if _, err := sshclient.Run(client, cmd, bytes.NewReader(f.data)); err != nil {
+			removeTemps(client, files[:i+1])
+			return fmt.Errorf("writing %s: %w", f.path, err)
+		}
+	}
+
+	moves := make([]string, len(files))
+	for i, f := range files {
+		moves[i] = fmt.Sprintf("mv -f %s %s", sshclient.Quote(f.path+".tmp"), sshclient.Quote(f.path))
+	}
+	if _, err := sshclient.Run(client, strings.Join(moves, " && "), nil); err != nil {
+		removeTemps(client, files)
+		return fmt.Errorf("installing certificate files: %w", err)
+	}
+	return nil
+}
+
+// removeTemps cleans up uploads that were not installed. Failures are
+// ignored, the next renewal overwrites the files anyway.
+func removeTemps(client *ssh.Client, files []pendingFile) {
+	tmps := make([]string, len(files))
+	for i, f := range files {
+		tmps[i] = sshclient.Quote(f.path + ".tmp")
+	}
+	sshclient.Run(client, "rm -f "+strings.Join(tmps, " "), nil)
+}
+
+func encodeCerts(chain [][]byte) []byte {
+	var buf bytes.Buffer
+	for _, der := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,477 @@
+// Package renewal renews auto_renew certificates with an ACME (RFC 8555) CA
+// and delivers them to their servers.
+package renewal
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/certscan"
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/secrets"
+	"github.com/cmdb/backend/internal/sshclient"
+	"golang.org/x/crypto/acme"
+)
+
+// Solver names available out of the box, depending on configuration.
+const (
+	SolverHTTP01        = "http-01"
+	SolverHTTP01Webroot = "http-01-webroot"
+	SolverDNS01Exec     = "dns-01-exec"
+	SolverDNS01Hook     = "dns-01-hook"
+)
+
+const (
+	renewTimeout = 10 * time.Minute
+	maxBackoff   = 24 * time.Hour
+	// httpTimeout bounds a single request to the ACME directory or a DNS
+	// hook.
+	httpTimeout = 30 * time.Second
+)
+
+// ErrNotConfigured is returned when a certificate has no renewal settings.
+var ErrNotConfigured = errors.New("certificate has no renewal settings")
+
+type Renewer struct {
+	stores     *database.Stores
+	cfg        Config
+	sealer     *secrets.Sealer
+	httpClient *http.Client
+	solvers    map[string]Solver
+	deliverer  Deliverer
+	http01     *HTTP01Server
+
+	mu     sync.Mutex
+	client *acme.Client
+	// renewing guards against renewing the same certificate concurrently.
+	renewing map[string]bool
+}
+
+// New builds a Renewer with the solvers enabled by cfg. Account and
+// certificate keys are stored sealed, so a sealer is required. dialer reaches
+// servers for webroot challenges and delivery.
+func New(stores *database.Stores, cfg Config, sealer *secrets.Sealer, dialer *sshclient.Dialer) (*Renewer, error) {
+	if sealer == nil {
+		return nil, secrets.ErrNotConfigured
+	}
+
+	httpClient := &http.Client{Timeout: httpTimeout}
+	if cfg.CABundle != "" {
+		pemData, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("reading ACME_CA_BUNDLE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("ACME_CA_BUNDLE contains no certificates")
+		}
+		httpClient = &http.Client{
+			Timeout:   httpTimeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	}
+
+	r := &Renewer{
+		stores:     stores,
+		cfg:        cfg,
+		sealer:     sealer,
+		httpClient: httpClient,
+		solvers:    make(map[string]Solver),
+		deliverer:  SSHDeliverer{Dialer: dialer},
+		renewing:   make(map[string]bool),
+	}
+
+	r.RegisterSolver(SolverHTTP01Webroot, HTTP01Webroot{Dialer: dialer})
+	if cfg.HTTP01Addr != "" {
+		r.http01 = NewHTTP01Server()
+		r.RegisterSolver(SolverHTTP01, r.http01)
+	}
+	if cfg.DNS01Command != "" {
+		r.RegisterSolver(SolverDNS01Exec, DNS01Exec{Command: cfg.DNS01Command, PropagationWait: cfg.DNS01PropagationWait})
+	}
+	if cfg.DNS01HookURL != "" {
+		r.RegisterSolver(SolverDNS01Hook, DNS01Hook{BaseURL: cfg.DNS01HookURL, PropagationWait: cfg.DNS01PropagationWait})
+	}
+	return r, nil
+}
+
+// RegisterSolver makes a solver selectable by name in renewal settings.
+func (r *Renewer) RegisterSolver(name string, s Solver) {
+	r.solvers[name] = s
+}
+
+// SetDeliverer replaces the default SSH delivery.
+func (r *Renewer) SetDeliverer(d Deliverer) {
+	r.deliverer = d
+}
+
+// Solvers lists the registered solver names.
+func (r *Renewer) Solvers() []string {
+	names := make([]string, 0, len(r.solvers))
+	for name := range r.solvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Solver returns the solver registered under name, or nil.
+func (r *Renewer) Solver(name string) Solver {
+	return r.solvers[name]
+}
+
+// Run renews due certificates on each interval until ctx is cancelled.
+func (r *Renewer) Run(ctx context.Context) {
+	log.Printf("ACME renewer started (directory=%s, window=%s, solvers=%s)",
+		r.cfg.DirectoryURL, r.cfg.RenewWindow, strings.Join(r.Solvers(), ","))
+
+	if r.http01 != nil {
+		go func() {
+			if err := r.http01.ListenAndServe(ctx, r.cfg.HTTP01Addr); err != nil {
+				log.Println("ACME renewer: http-01 responder failed:", err)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.RenewDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("ACME renewer stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RenewDue renews every certificate inside the renewal window, one at a time.
+func (r *Renewer) RenewDue(ctx context.Context) {
+	now := time.Now()
+	ids, err := r.stores.Renewals.ListDue(now.Add(r.cfg.RenewWindow), now)
+	if err != nil {
+		log.Println("ACME renewer: failed to list due certificates:", err)
+		return
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := r.Renew(ctx, id); err != nil {
+			log.Printf("ACME renewer: %s: %v", id, err)
+		}
+	}
+}
+
+// Renew orders, delivers and records a new certificate for certID. The
+// outcome is stored on the renewal settings; failures back off exponentially.
+func (r *Renewer) Renew(ctx context.Context, certID string) (*database.CertScanResult, error) {
+	r.mu.Lock()
+	if r.renewing[certID] {
+		r.mu.Unlock()
+		return nil, errors.New("renewal already in progress")
+	}
+	r.renewing[certID] = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.renewing, certID)
+		r.mu.Unlock()
+	}()
+
+	settings, err := r.stores.Renewals.Get(certID)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, ErrNotConfigured
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, renewTimeout)
+	defer cancel()
+
+	result, err := r.renew(ctx, certID, settings)
+	now := time.Now()
+	if err != nil {
+		next := now.Add(backoff(settings.Failures + 1))
+		if recErr := r.stores.Renewals.RecordFailure(certID, err.Error(), now, next); recErr != nil {
+			log.Printf("ACME renewer: failed to record failure for %s: %v", certID, recErr)
+		}
+		return nil, err
+	}
+	if err := r.stores.Renewals.RecordSuccess(certID, now); err != nil {
+		log.Printf("ACME renewer: failed to record success for %s: %v", certID, err)
+	}
+	return result, nil
+}
+
+func (r *Renewer) renew(ctx context.Context, certID string, settings *database.RenewalSettings) (*database.CertScanResult, error) {
+	cert, err := r.stores.SSL.GetByID(certID)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate: %w", err)
+	}
+	server, err := r.stores.Servers.GetByID(cert.ServerID)
+	if err != nil {
+		return nil, fmt.Errorf("loading server: %w", err)
+	}
+	solver := r.solvers[settings.Solver]
+	if solver == nil {
+		return nil, fmt.Errorf("solver %q is not available", settings.Solver)
+	}
+
+	domains := Domains(cert)
+	if solver.Type() != ChallengeDNS01 {
+		for _, d := range domains {
+			if strings.HasPrefix(d, "*.") {
+				return nil, fmt.Errorf("wildcard %s requires a dns-01 solver", d)
+			}
+		}
+	}
+
+	client, err := r.acmeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chain, keyDER, err := r.issue(ctx, client, domains, solver, server, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	result := certscan.ParseChain(chain)
+	result.Findings = certscan.Analyze(cert.Domain, chain, nil, time.Now())
+	if err := r.stores.SSL.UpdateScanResult(certID, result); err != nil {
+		return nil, fmt.Errorf("storing certificate: %w", err)
+	}
+	sealed, err := r.sealer.Seal(keyDER, []byte(certID))
+	if err != nil {
+		return nil, err
+	}
+	if err := r.stores.SSL.StorePrivateKey(certID, "ECDSA", sealed); err != nil {
+		return nil, fmt.Errorf("storing private key: %w", err)
+	}
+
+	log.Printf("ACME renewer: renewed %s, now expires %s", cert.Domain, result.ExpiresAt.Format(time.RFC3339))
+	return result, nil
+}
+
+// issue orders a certificate for domains from client, answering its
+// challenges with solver, and delivers it with a new key to server. It
+// returns the issued chain and the PKCS#8 key.
+func (r *Renewer) issue(ctx context.Context, client *acme.Client, domains []string, solver Solver, server *database.Server, settings *database.RenewalSettings) ([]*x509.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating order: %w", err)
+	}
+	for _, u := range order.AuthzURLs {
+		if err := r.authorize(ctx, client, u, solver, server, settings); err != nil {
+			return nil, nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, nil, fmt.Errorf("waiting for order: %w", err)
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("finalizing order: %w", err)
+	}
+
+	chain := make([]*x509.Certificate, 0, len(der))
+	for _, b := range der {
+		c, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing issued certificate: %w", err)
+		}
+		chain = append(chain, c)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := r.deliverer.Deliver(ctx, server, settings, der, keyDER); err != nil {
+		return nil, nil, fmt.Errorf("delivering certificate: %w", err)
+	}
+	return chain, keyDER, nil
+}
+
+func (r *Renewer) authorize(ctx context.Context, client *acme.Client, url string, solver Solver, server *database.Server, settings *database.RenewalSettings) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("fetching authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == solver.Type() {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("%s: CA offered no %s challenge", authz.Identifier.Value, solver.Type())
+	}
+
+	ch := &Challenge{Domain: authz.Identifier.Value, Token: chal.Token, Server: server, Settings: settings}
+	if chal.Type == ChallengeDNS01 {
+		ch.KeyAuth, err = client.DNS01ChallengeRecord(chal.Token)
+	} else {
+		ch.KeyAuth, err = client.HTTP01ChallengeResponse(chal.Token)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := solver.Present(ctx, ch); err != nil {
+		return fmt.Errorf("%s: presenting %s challenge: %w", ch.Domain, chal.Type, err)
+	}
+	defer func() {
+		if err := solver.CleanUp(context.Background(), ch); err != nil {
+			log.Printf("ACME renewer: %s: cleaning up %s challenge: %v", ch.Domain, chal.Type, err)
+		}
+	}()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("%s: accepting challenge: %w", ch.Domain, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("%s: authorization failed: %w", ch.Domain, err)
+	}
+	return nil
+}
+
+// acmeClient returns a client for the configured directory, registering an
+// account on first use.
+func (r *Renewer) acmeClient(ctx context.Context) (*acme.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client != nil {
+		return r.client, nil
+	}
+
+	account, err := r.stores.Renewals.GetAccount(r.cfg.DirectoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.Signer
+	if account != nil {
+		der, err := r.sealer.Open(account.EncryptedKey, []byte(r.cfg.DirectoryURL))
+		if err != nil {
+			return nil, fmt.Errorf("decrypting ACME account key: %w", err)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("ACME account key is not a signer")
+		}
+		key = signer
+	} else {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: r.cfg.DirectoryURL,
+		HTTPClient:   r.httpClient,
+		UserAgent:    "port-probe-dash",
+	}
+
+	if account == nil {
+		acct := &acme.Account{}
+		if r.cfg.Email != "" {
+			acct.Contact = []string{"mailto:" + r.cfg.Email}
+		}
+		registered, err := client.Register(ctx, acct, acme.AcceptTOS)
+		if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+			return nil, fmt.Errorf("registering ACME account: %w", err)
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		sealed, err := r.sealer.Seal(der, []byte(r.cfg.DirectoryURL))
+		if err != nil {
+			return nil, err
+		}
+		account = &database.ACMEAccount{DirectoryURL: r.cfg.DirectoryURL, EncryptedKey: sealed}
+		if r.cfg.Email != "" {
+			account.Email = &r.cfg.Email
+		}
+		if registered != nil {
+			account.AccountURL = &registered.URI
+		}
+		if err := r.stores.Renewals.SaveAccount(account); err != nil {
+			return nil, fmt.Errorf("saving ACME account: %w", err)
+		}
+		log.Printf("ACME renewer: registered account with %s", r.cfg.DirectoryURL)
+	}
+
+	r.client = client
+	return client, nil
+}
+
+// Domains lists the DNS names to request: the certificate's domain first,
+// then any other DNS SANs it currently carries.
+func Domains(cert *database.SSLCertificate) []string {
+	domains := []string{cert.Domain}
+	seen := map[string]bool{cert.Domain: true}
+	for _, san := range cert.SANs {
+		if seen[san] || net.ParseIP(san) != nil {
+			continue
+		}
+		seen[san] = true
+		domains = append(domains, san)
+	}
+	return domains
+}
+
+func backoff(failures int) time.Duration {
+	d := time.Hour
+	for i := 1; i < failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,54 @@
+package renewal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+func TestDomains(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		sans   []string
+		want   []string
+	}{
+		{"no SANs", "example.org", nil, []string{"example.org"}},
+		{"domain first", "example.org", []string{"www.example.org", "example.org"}, []string{"example.org", "www.example.org"}},
+		{"duplicates dropped", "example.org", []string{"www.example.org", "www.example.org"}, []string{"example.org", "www.example.org"}},
+		{"IP SANs dropped", "example.org", []string{"192.0.2.1", "2001:db8::1", "api.example.org"}, []string{"example.org", "api.example.org"}},
+		{"wildcard kept", "example.org", []string{"*.example.org"}, []string{"example.org", "*.example.org"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Domains(&database.SSLCertificate{Domain: tt.domain, SANs: tt.sans})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Domains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, time.Hour},
+		{1, time.Hour},
+		{2, 2 * time.Hour},
+		{3, 4 * time.Hour},
+		{5, 16 * time.Hour},
+		{6, maxBackoff},
+		{50, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package renewal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/sshclient"
+)
+
+// ACME challenge types.
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+// Challenge is one pending ACME challenge.
+type Challenge struct {
+	// Domain is the identifier being validated, without any "*." prefix.
+	Domain string
+	Token  string
+	// KeyAuth is the value to publish: the key authorization for http-01,
+	// the TXT record value for dns-01.
+	KeyAuth  string
+	Server   *database.Server
+	Settings *database.RenewalSettings
+}
+
+// Solver publishes and removes the proof for one challenge type.
+type Solver interface {
+	// Type is the ACME challenge type solved, ChallengeHTTP01 or ChallengeDNS01.
+	Type() string
+	Present(ctx context.Context, ch *Challenge) error
+	CleanUp(ctx context.Context, ch *Challenge) error
+}
+
+// HTTP01Server answers http-01 challenges from memory. The domain must route
+// port 80 to it, directly or through a reverse proxy.
+type HTTP01Server struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+func NewHTTP01Server() *HTTP01Server {
+	return &HTTP01Server{tokens: make(map[string]string)}
+}
+
+func (s *HTTP01Server) Type() string { return ChallengeHTTP01 }
+
+func (s *HTTP01Server) Present(ctx context.Context, ch *Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[ch.Token] = ch.KeyAuth
+	return nil
+}
+
+func (s *HTTP01Server) CleanUp(ctx context.Context, ch *Challenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, ch.Token)
+	return nil
+}
+
+func (s *HTTP01Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")
+	s.mu.RLock()
+	keyAuth, ok := s.tokens[token]
+	s.mu.RUnlock()
+	if !ok || token == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+// ListenAndServe serves challenges on addr until ctx is cancelled.
+func (s *HTTP01Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// HTTP01Webroot writes http-01 proofs into the certificate's webroot on the
+// target server over SSH, for servers that already serve the domain.
+type HTTP01Webroot struct {
+	Dialer *sshclient.Dialer
+}
+
+func (HTTP01Webroot) Type() string { return ChallengeHTTP01 }
+
+func (HTTP01Webroot) challengePath(ch *Challenge) (string, error) {
+	if ch.Settings.Webroot == nil || *ch.Settings.Webroot == "" {
+		return "", errors.New("webroot is not configured for this certificate")
+	}
+	return path.Join(*ch.Settings.Webroot, ".well-known/acme-challenge", ch.Token), nil
+}
+
+func (w HTTP01Webroot) Present(ctx context.Context, ch *Challenge) error {
+	file, err := w.challengePath(ch)
+	if err != nil {
+		return err
+	}
+	client, err := w.Dialer.Dial(ctx, ch.Server)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	cmd := fmt.Sprintf("mkdir -p %s && cat > %s", sshclient.Quote(path.Dir(file)), sshclient.Quote(file))
+	_, err = sshclient.Run(client, cmd, strings.NewReader(ch.KeyAuth))
+	return err
+}
+
+func (w HTTP01Webroot) CleanUp(ctx context.Context, ch *Challenge) error {
+	file, err := w.challengePath(ch)
+	if err != nil {
+		return err
+	}
+	client, err := w.Dialer.Dial(ctx, ch.Server)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = sshclient.Run(client, "rm -f "+sshclient.Quote(file), nil)
+	return err
+}
+
+// DNS01Exec delegates TXT record changes to a local command, run as
+// "<command> present|cleanup <fqdn> <value>".
+type DNS01Exec struct {
+	Command         string
+	PropagationWait time.Duration
+}
+
+func (DNS01Exec) Type() string { return ChallengeDNS01 }
+
+func (d DNS01Exec) run(ctx context.Context, action string, ch *Challenge) error {
+	out, err := exec.CommandContext(ctx, d.Command, action, recordName(ch.Domain), ch.KeyAuth).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dns-01 %s hook: %w: %s", action, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (d DNS01Exec) Present(ctx context.Context, ch *Challenge) error {
+	if err := d.run(ctx, "present", ch); err != nil {
+		return err
+	}
+	return waitPropagation(ctx, d.PropagationWait)
+}
+
+func (d DNS01Exec) CleanUp(ctx context.Context, ch *Challenge) error {
+	return d.run(ctx, "cleanup", ch)
+}
+
+// DNS01Hook manages TXT records through an HTTP API compatible with
+// pebble-challtestsrv: POST /set-txt {"host","value"} and /clear-txt {"host"}.
+type DNS01Hook struct {
+	BaseURL         string
+	PropagationWait time.Duration
+	Client          *http.Client
+}
+
+func (DNS01Hook) Type() string { return ChallengeDNS01 }
+
+func (d DNS01Hook) post(ctx context.Context, endpoint string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(d.BaseURL, "/")+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := d.Client
+	if client == nil {
+		client = &http.Client{Timeout: httpTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("dns-01 hook %s returned status %d", endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+func (d DNS01Hook) Present(ctx context.Context, ch *Challenge) error {
+	err := d.post(ctx, "/set-txt", map[string]string{"host": recordName(ch.Domain) + ".", "value": ch.KeyAuth})
+	if err != nil {
+		return err
+	}
+	return waitPropagation(ctx, d.PropagationWait)
+}
+
+func (d DNS01Hook) CleanUp(ctx context.Context, ch *Challenge) error {
+	return d.post(ctx, "/clear-txt", map[string]string{"host": recordName(ch.Domain) + "."})
+}
+
+func recordName(domain string) string {
+	return "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+}
+
+func waitPropagation(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,121 @@
+package renewal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+func TestHTTP01Server(t *testing.T) {
+	s := NewHTTP01Server()
+	ch := &Challenge{Domain: "example.org", Token: "tok3n", KeyAuth: "tok3n.thumbprint"}
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		body, _ := io.ReadAll(rec.Body)
+		return rec.Code, string(body)
+	}
+
+	if code, _ := get("/.well-known/acme-challenge/tok3n"); code != http.StatusNotFound {
+		t.Errorf("before Present: status %d, want 404", code)
+	}
+	if err := s.Present(context.Background(), ch); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get("/.well-known/acme-challenge/tok3n"); code != http.StatusOK || body != ch.KeyAuth {
+		t.Errorf("after Present: %d %q, want 200 %q", code, body, ch.KeyAuth)
+	}
+	// Only the challenge path serves tokens
+	if code, _ := get("/tok3n"); code != http.StatusNotFound {
+		t.Errorf("token outside the challenge path: status %d, want 404", code)
+	}
+	if code, _ := get("/.well-known/acme-challenge/other"); code != http.StatusNotFound {
+		t.Errorf("unknown token: status %d, want 404", code)
+	}
+	if err := s.CleanUp(context.Background(), ch); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := get("/.well-known/acme-challenge/tok3n"); code != http.StatusNotFound {
+		t.Errorf("after CleanUp: status %d, want 404", code)
+	}
+}
+
+func TestHTTP01WebrootPath(t *testing.T) {
+	webroot, empty := "/var/www/html", ""
+	tests := []struct {
+		name    string
+		webroot *string
+		want    string
+		wantErr bool
+	}{
+		{"webroot", &webroot, "/var/www/html/.well-known/acme-challenge/tok3n", false},
+		{"not configured", nil, "", true},
+		{"empty", &empty, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := &Challenge{Token: "tok3n", Settings: &database.RenewalSettings{Webroot: tt.webroot}}
+			got, err := HTTP01Webroot{}.challengePath(ch)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("challengePath() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDNS01Hook(t *testing.T) {
+	type call struct {
+		Path string
+		Body map[string]string
+	}
+	var calls []call
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		calls = append(calls, call{r.URL.Path, body})
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	hook := DNS01Hook{BaseURL: srv.URL + "/", Client: srv.Client()}
+	ch := &Challenge{Domain: "*.example.org", KeyAuth: "txt-value"}
+	if err := hook.Present(context.Background(), ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := hook.CleanUp(context.Background(), ch); err != nil {
+		t.Fatal(err)
+	}
+	want := []call{
+		{"/set-txt", map[string]string{"host": "_acme-challenge.example.org.", "value": "txt-value"}},
+		{"/clear-txt", map[string]string{"host": "_acme-challenge.example.org."}},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %+v, want %+v", calls, want)
+	}
+
+	status = http.StatusInternalServerError
+	if err := hook.Present(context.Background(), ch); err == nil {
+		t.Error("Present() succeeded although the hook failed")
+	}
+}
+
+func TestRecordName(t *testing.T) {
+	tests := map[string]string{
+		"example.org":     "_acme-challenge.example.org",
+		"www.example.org": "_acme-challenge.www.example.org",
+		"*.example.org":   "_acme-challenge.example.org",
+	}
+	for domain, want := range tests {
+		if got := recordName(domain); got != want {
+			t.Errorf("recordName(%q) = %q, want %q", domain, got, want)
+		}
+	}
+}
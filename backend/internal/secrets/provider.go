@@ -0,0 +1,101 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// commandTimeout bounds SECRETS_MASTER_KEY_COMMAND.
+const commandTimeout = 30 * time.Second
+
+// KeyProvider supplies the base64 decoded master key.
+type KeyProvider interface {
+	MasterKey() ([]byte, error)
+	String() string
+}
+
+// EnvKey reads the key from an environment variable.
+type EnvKey string
+
+func (e EnvKey) MasterKey() ([]byte, error) {
+	return decodeKey(os.Getenv(string(e)))
+}
+
+func (e EnvKey) String() string { return string(e) }
+
+// FileKey reads the key from a file, such as a mounted Kubernetes or Docker
+// secret.
+type FileKey string
+
+func (f FileKey) MasterKey() ([]byte, error) {
+	data, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+	return decodeKey(string(data))
+}
+
+func (f FileKey) String() string { return "SECRETS_MASTER_KEY_FILE " + string(f) }
+
+// CommandKey runs a shell command that prints the key, so it can be fetched
+// from a KMS or secret manager (e.g. "aws kms decrypt ..." or "vault kv get
+// -field=key ...") instead of living in the environment.
+type CommandKey string
+
+func (c CommandKey) MasterKey() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", string(c))
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return decodeKey(string(out))
+}
+
+func (c CommandKey) String() string { return "SECRETS_MASTER_KEY_COMMAND" }
+
+func decodeKey(v string) ([]byte, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, errors.New("master key is empty")
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	return key, nil
+}
+
+// ProviderFromEnv picks the master key source: SECRETS_MASTER_KEY,
+// SECRETS_MASTER_KEY_FILE or SECRETS_MASTER_KEY_COMMAND, of which at most one
+// may be set. It returns nil when none is.
+func ProviderFromEnv() (KeyProvider, error) {
+	var providers []KeyProvider
+	if os.Getenv("SECRETS_MASTER_KEY") != "" {
+		providers = append(providers, EnvKey("SECRETS_MASTER_KEY"))
+	}
+	if v := os.Getenv("SECRETS_MASTER_KEY_FILE"); v != "" {
+		providers = append(providers, FileKey(v))
+	}
+	if v := os.Getenv("SECRETS_MASTER_KEY_COMMAND"); v != "" {
+		providers = append(providers, CommandKey(v))
+	}
+	switch len(providers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return providers[0], nil
+	}
+	return nil, errors.New("set only one of SECRETS_MASTER_KEY, SECRETS_MASTER_KEY_FILE and SECRETS_MASTER_KEY_COMMAND")
+}
@@ -0,0 +1,70 @@
+// Package secrets encrypts sensitive material, such as private keys, before
+// it is written to the database.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrNotConfigured is returned when no master key has been set.
+var ErrNotConfigured = errors.New("encrypted secret store is not configured (set SECRETS_MASTER_KEY)")
+
+// Sealer encrypts and decrypts values with AES-256-GCM. Sealed values are the
+// random nonce followed by the ciphertext.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer builds a Sealer from a 32 byte key.
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// SealerFromEnv builds a Sealer from the master key provider configured in
+// the environment (see ProviderFromEnv). It returns nil without error when no
+// provider is configured.
+func SealerFromEnv() (*Sealer, error) {
+	provider, err := ProviderFromEnv()
+	if err != nil || provider == nil {
+		return nil, err
+	}
+	key, err := provider.MasterKey()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", provider, err)
+	}
+	return NewSealer(key)
+}
+
+// Seal encrypts plaintext. additionalData binds the ciphertext to its owner
+// (e.g. a row ID) so it cannot be swapped onto another record.
+func (s *Sealer) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a value produced by Seal with the same additionalData.
+func (s *Sealer) Open(sealed, additionalData []byte) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("sealed value is too short")
+	}
+	return s.aead.Open(nil, sealed[:n], sealed[n:], additionalData)
+}
@@ -0,0 +1,42 @@
+package sshclient
+
+import "errors"
+
+// Errors returned by ValidateChain.
+var (
+	ErrChainTooLong     = errors.New("jump host chain is too long")
+	ErrSelfJumpHost     = errors.New("server is its own jump host")
+	ErrRepeatedJumpHost = errors.New("jump host appears more than once in the chain")
+)
+
+// ValidateChain checks a jump host chain before it is stored: it is at most
+// MaxJumpHosts long and lists each hop once. self is the server the chain is
+// for, if any, which cannot be its own jump host.
+func ValidateChain(self string, ids []string) error {
+	if len(ids) > MaxJumpHosts {
+		return ErrChainTooLong
+	}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if self != "" && id == self {
+			return ErrSelfJumpHost
+		}
+		if seen[id] {
+			return ErrRepeatedJumpHost
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// hopsBefore returns the part of a chain that leads to self. A jump host in
+// a group whose chain includes it is reached over the hops before it rather
+// than through itself, which would never connect.
+func hopsBefore(self string, ids []string) []string {
+	for i, id := range ids {
+		if id == self {
+			return ids[:i]
+		}
+	}
+	return ids
+}
@@ -0,0 +1,67 @@
+package sshclient
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestValidateChain(t *testing.T) {
+	tooLong := make([]string, MaxJumpHosts+1)
+	for i := range tooLong {
+		tooLong[i] = fmt.Sprintf("hop-%d", i)
+	}
+
+	tests := []struct {
+		name    string
+		self    string
+		ids     []string
+		wantErr error
+	}{
+		{"empty chain", "srv", nil, nil},
+		{"single hop", "srv", []string{"a"}, nil},
+		{"several hops", "srv", []string{"a", "b", "c"}, nil},
+		{"longest chain", "srv", tooLong[:MaxJumpHosts], nil},
+		{"too long", "srv", tooLong, ErrChainTooLong},
+		{"server is its own jump host", "srv", []string{"srv"}, ErrSelfJumpHost},
+		{"server later in its chain", "srv", []string{"a", "b", "srv"}, ErrSelfJumpHost},
+		{"hop repeated", "srv", []string{"a", "b", "a"}, ErrRepeatedJumpHost},
+		{"hop repeated back to back", "srv", []string{"a", "a"}, ErrRepeatedJumpHost},
+		{"group chain", "", []string{"a", "b"}, nil},
+		{"group chain with a repeated hop", "", []string{"a", "b", "a"}, ErrRepeatedJumpHost},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateChain(tt.self, tt.ids)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateChain(%q, %v) = %v, want %v", tt.self, tt.ids, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHopsBefore(t *testing.T) {
+	tests := []struct {
+		name string
+		self string
+		ids  []string
+		want []string
+	}{
+		{"no chain", "srv", nil, nil},
+		{"server not in its chain", "srv", []string{"a", "b"}, []string{"a", "b"}},
+		{"server is the first hop", "a", []string{"a", "b"}, []string{}},
+		{"server in the middle", "b", []string{"a", "b", "c"}, []string{"a"}},
+		{"server is the last hop", "c", []string{"a", "b", "c"}, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hopsBefore(tt.self, tt.ids)
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("hopsBefore(%q, %v) = %v, want %v", tt.self, tt.ids, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package sshclient
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/envconfig"
+)
+
+type Config struct {
+	// UseAgent offers the keys of the ssh-agent at AgentSocket after the
+	// server's own credential and key file.
+	UseAgent    bool
+	AgentSocket string
+	Timeout     time.Duration
+	// HostKeyMode applies to servers without their own host_key_mode.
+	HostKeyMode string
+	// SFTPMaxUpload is the largest file accepted by the file upload
+	// endpoint, in bytes.
+	SFTPMaxUpload int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		UseAgent:      false,
+		AgentSocket:   os.Getenv("SSH_AUTH_SOCK"),
+		Timeout:       15 * time.Second,
+		HostKeyMode:   database.HostKeyModeTOFU,
+		SFTPMaxUpload: 100 << 20,
+	}
+}
+
+// ConfigFromEnv reads SSH_* environment variables on top of DefaultConfig.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	envconfig.Bool("SSH_USE_AGENT", &cfg.UseAgent)
+	envconfig.Duration("SSH_TIMEOUT", &cfg.Timeout)
+	if v := os.Getenv("SSH_HOST_KEY_MODE"); v != "" {
+		if v != database.HostKeyModeTOFU && v != database.HostKeyModeStrict {
+			log.Printf("Invalid SSH_HOST_KEY_MODE %q, keeping default", v)
+		} else {
+			cfg.HostKeyMode = v
+		}
+	}
+	envconfig.Int64("SFTP_MAX_UPLOAD", &cfg.SFTPMaxUpload, 1)
+	return cfg
+}
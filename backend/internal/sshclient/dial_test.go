@@ -0,0 +1,210 @@
+package sshclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/database/dbtest"
+	"github.com/cmdb/backend/internal/sshclient/sshtest"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCloseWith(t *testing.T) {
+	signer, _ := sshtest.ClientKey(t)
+	jump := sshtest.NewServer(t, "jump", signer.PublicKey())
+	target := sshtest.NewServer(t, "target", signer.PublicKey())
+	d := &Dialer{cfg: Config{Timeout: 5 * time.Second}}
+	ctx := context.Background()
+
+	via, err := d.connect(ctx, jump.Record(t), nil, jump.ClientConfig(signer), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := d.connect(ctx, target.Record(t), via, target.ClientConfig(signer), false)
+	if err != nil {
+		via.Close()
+		t.Fatal(err)
+	}
+	closeWith(client, via)
+
+	out, err := Run(client, "hostname", nil)
+	if err != nil || string(out) != "target" {
+		t.Errorf("Run() = %q, %v, want target", out, err)
+	}
+	if got := jump.Forwards(); len(got) != 1 || got[0] != target.Addr {
+		t.Errorf("jump host forwarded to %v, want [%s]", got, target.Addr)
+	}
+
+	client.Close()
+	target.WaitIdle(t)
+	jump.WaitIdle(t)
+}
+
+func TestConnectThroughJumpHostChecksHostKey(t *testing.T) {
+	signer, _ := sshtest.ClientKey(t)
+	jump := sshtest.NewServer(t, "jump", signer.PublicKey())
+	target := sshtest.NewServer(t, "target", signer.PublicKey())
+	impostor := sshtest.NewServer(t, "impostor", signer.PublicKey())
+	d := &Dialer{cfg: Config{Timeout: 5 * time.Second}}
+	ctx := context.Background()
+
+	via, err := d.connect(ctx, jump.Record(t), nil, jump.ClientConfig(signer), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer via.Close()
+
+	// The target's address now answers with another server's key.
+	_, err = d.connect(ctx, impostor.Record(t), via, target.ClientConfig(signer), false)
+	if err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Fatalf("connect() error = %v, want a host key mismatch", err)
+	}
+	if got := jump.Forwards(); len(got) != 1 || got[0] != impostor.Addr {
+		t.Errorf("jump host forwarded to %v, want [%s]", got, impostor.Addr)
+	}
+}
+
+// chainStores returns stores backed by a test database, skipping the test
+// without one.
+func chainStores(t *testing.T) *database.Stores {
+	db := dbtest.Open(t)
+	return &database.Stores{
+		Servers:     database.NewServerStore(db),
+		Credentials: database.NewCredentialStore(db),
+		HostKeys:    database.NewHostKeyStore(db),
+		Audit:       database.NewAuditStore(db),
+		JumpHosts:   database.NewJumpHostStore(db),
+	}
+}
+
+// chainFixture stores two jump hosts and a target reached through them, all
+// authenticating with the same key file.
+func chainFixture(t *testing.T, stores *database.Stores) (hops [2]*sshtest.Server, target *sshtest.Server, records [3]*database.Server) {
+	t.Helper()
+	signer, keyPath := sshtest.ClientKey(t)
+	hops[0] = sshtest.NewServer(t, "jump-1", signer.PublicKey())
+	hops[1] = sshtest.NewServer(t, "jump-2", signer.PublicKey())
+	target = sshtest.NewServer(t, "target", signer.PublicKey())
+
+	for i, s := range []*sshtest.Server{hops[0], hops[1], target} {
+		rec := s.Record(t)
+		rec.SSHKeyPath = &keyPath
+		created, err := stores.Servers.Create(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[i] = created
+	}
+	if err := stores.JumpHosts.SetForServer(records[2].ID, []string{records[0].ID, records[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	return hops, target, records
+}
+
+func trustedFingerprints(t *testing.T, stores *database.Stores, serverID string) []string {
+	t.Helper()
+	keys, err := stores.HostKeys.ListForServer(serverID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fps []string
+	for _, k := range keys {
+		if k.Status == database.HostKeyTrusted {
+			fps = append(fps, k.Fingerprint)
+		}
+	}
+	return fps
+}
+
+func TestDialThroughChain(t *testing.T) {
+	stores := chainStores(t)
+	hops, target, records := chainFixture(t, stores)
+	d := New(stores, nil, Config{Timeout: 5 * time.Second, HostKeyMode: database.HostKeyModeTOFU})
+
+	client, err := d.Dial(context.Background(), records[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Run(client, "hostname", nil)
+	if err != nil || string(out) != "target" {
+		t.Errorf("Run() = %q, %v, want target", out, err)
+	}
+
+	// Each hop's key is trusted as the server it belongs to.
+	for i, s := range []*sshtest.Server{hops[0], hops[1], target} {
+		want := ssh.FingerprintSHA256(s.HostKey.PublicKey())
+		if got := trustedFingerprints(t, stores, records[i].ID); len(got) != 1 || got[0] != want {
+			t.Errorf("trusted keys of %s = %v, want [%s]", s.Name, got, want)
+		}
+	}
+	if got := hops[0].Forwards(); len(got) != 1 || got[0] != hops[1].Addr {
+		t.Errorf("jump-1 forwarded to %v, want [%s]", got, hops[1].Addr)
+	}
+	if got := hops[1].Forwards(); len(got) != 1 || got[0] != target.Addr {
+		t.Errorf("jump-2 forwarded to %v, want [%s]", got, target.Addr)
+	}
+
+	client.Close()
+	for _, s := range []*sshtest.Server{target, hops[1], hops[0]} {
+		s.WaitIdle(t)
+	}
+}
+
+func TestDialThroughChainUntrustedHop(t *testing.T) {
+	stores := chainStores(t)
+	hops, _, records := chainFixture(t, stores)
+	strict := database.HostKeyModeStrict
+	records[1].HostKeyMode = &strict
+	if err := stores.Servers.Update(records[1].ID, records[1]); err != nil {
+		t.Fatal(err)
+	}
+	d := New(stores, nil, Config{Timeout: 5 * time.Second, HostKeyMode: database.HostKeyModeTOFU})
+
+	_, err := d.Dial(context.Background(), records[2])
+	var hkErr *HostKeyError
+	if !errors.As(err, &hkErr) || hkErr.Fingerprint != ssh.FingerprintSHA256(hops[1].HostKey.PublicKey()) {
+		t.Fatalf("Dial() error = %v, want a HostKeyError for jump-2", err)
+	}
+	if got := hops[1].Forwards(); len(got) != 0 {
+		t.Errorf("jump-2 forwarded to %v through an untrusted connection", got)
+	}
+	// The first hop was connected and must be closed again.
+	hops[0].WaitIdle(t)
+	if got := trustedFingerprints(t, stores, records[2].ID); len(got) != 0 {
+		t.Errorf("target key trusted although it was never reached: %v", got)
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	stores := chainStores(t)
+	hops, target, records := chainFixture(t, stores)
+	d := New(stores, nil, Config{Timeout: 5 * time.Second, HostKeyMode: database.HostKeyModeTOFU})
+
+	tunnel, err := d.Tunnel(context.Background(), records[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tunnel.DialContext(context.Background(), "tcp", target.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	banner := make([]byte, 4)
+	if _, err := io.ReadFull(conn, banner); err != nil || string(banner) != "SSH-" {
+		t.Errorf("read %q, %v through the tunnel, want an SSH banner", banner, err)
+	}
+	conn.Close()
+
+	tunnel.Close()
+	hops[1].WaitIdle(t)
+	hops[0].WaitIdle(t)
+
+	direct, err := d.Tunnel(context.Background(), records[0])
+	if err != nil || direct != nil {
+		t.Errorf("Tunnel() of a server without jump hosts = %v, %v, want nil", direct, err)
+	}
+}
@@ -0,0 +1,310 @@
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Audit actions recorded for host keys.
+const (
+	AuditHostKeyTrusted  = "ssh.host_key.trusted"
+	AuditHostKeyChanged  = "ssh.host_key.changed"
+	AuditHostKeyApproved = "ssh.host_key.approved"
+	AuditHostKeyRejected = "ssh.host_key.rejected"
+	AuditHostKeyImported = "ssh.host_key.imported"
+	AuditHostKeyDeleted  = "ssh.host_key.deleted"
+)
+
+// HostKeyError is returned when a server presents a key that is not trusted.
+type HostKeyError struct {
+	Fingerprint string
+	// Changed is set when the server has trusted keys but presented another.
+	Changed  bool
+	Rejected bool
+}
+
+func (e *HostKeyError) Error() string {
+	switch {
+	case e.Rejected:
+		return fmt.Sprintf("host key %s has been rejected", e.Fingerprint)
+	case e.Changed:
+		return fmt.Sprintf("REMOTE HOST IDENTIFICATION HAS CHANGED: host key %s does not match the trusted key; "+
+			"an admin must approve it before connecting", e.Fingerprint)
+	}
+	return fmt.Sprintf("host key %s is not trusted; an admin must approve or import it before connecting", e.Fingerprint)
+}
+
+// OnHostKeyChange registers fn to be called after a changed host key is
+// recorded, e.g. to re-evaluate alerts.
+func (d *Dialer) OnHostKeyChange(fn func()) {
+	d.hostKeyChanged = fn
+}
+
+func (d *Dialer) hostKeyMode(server *database.Server) string {
+	if server.HostKeyMode != nil && *server.HostKeyMode != "" {
+		return *server.HostKeyMode
+	}
+	return d.cfg.HostKeyMode
+}
+
+// hostKeyAlgorithms makes the server present a key of a type that is already
+// trusted, as OpenSSH does, so that a server with several host keys is not
+// mistaken for a changed one.
+func hostKeyAlgorithms(known []*database.HostKey) []string {
+	var algos []string
+	seen := map[string]bool{}
+	for _, k := range known {
+		if k.Status != database.HostKeyTrusted || seen[k.KeyType] {
+			continue
+		}
+		seen[k.KeyType] = true
+		if k.KeyType == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, k.KeyType)
+	}
+	return algos
+}
+
+// judgeHostKey decides on the key with fingerprint fp. match is the known
+// key with that fingerprint, if any. refused is nil when the key is trusted,
+// or is unknown but trusted on first use: in tofu mode, when no key of the
+// server is trusted yet.
+func judgeHostKey(mode string, known []*database.HostKey, fp string) (match *database.HostKey, refused *HostKeyError) {
+	trusted := 0
+	for _, k := range known {
+		if k.Fingerprint == fp {
+			switch k.Status {
+			case database.HostKeyTrusted:
+				return k, nil
+			case database.HostKeyRejected:
+				return k, &HostKeyError{Fingerprint: fp, Rejected: true}
+			}
+			return k, &HostKeyError{Fingerprint: fp, Changed: k.Changed}
+		}
+		if k.Status == database.HostKeyTrusted {
+			trusted++
+		}
+	}
+	if trusted == 0 && mode == database.HostKeyModeTOFU {
+		return nil, nil
+	}
+	return nil, &HostKeyError{Fingerprint: fp, Changed: trusted > 0}
+}
+
+// verifyHostKey checks the presented key against the server's known keys.
+// Unknown keys are trusted on first use in tofu mode when the server has no
+// trusted key yet; anything else is recorded as pending and refused.
+func (d *Dialer) verifyHostKey(server *database.Server, known []*database.HostKey) ssh.HostKeyCallback {
+	mode := d.hostKeyMode(server)
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		now := time.Now()
+		fp := ssh.FingerprintSHA256(key)
+
+		match, refused := judgeHostKey(mode, known, fp)
+		if match != nil {
+			if refused != nil {
+				return refused
+			}
+			if err := d.stores.HostKeys.Touch(match.ID, now); err != nil {
+				log.Printf("Failed to update host key %s of %s: %v", fp, server.Hostname, err)
+			}
+			return nil
+		}
+
+		hk := &database.HostKey{
+			ServerID:    server.ID,
+			KeyType:     key.Type(),
+			PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+			Fingerprint: fp,
+			FirstSeenAt: &now,
+		}
+
+		if refused == nil {
+			hk.Source = database.HostKeySourceTOFU
+			if err := d.stores.HostKeys.Trust(hk, "", now); err != nil {
+				return fmt.Errorf("storing host key: %w", err)
+			}
+			d.audit(AuditHostKeyTrusted, server, map[string]interface{}{
+				"fingerprint": fp, "key_type": hk.KeyType, "source": hk.Source,
+			})
+			return nil
+		}
+
+		hk.Changed = refused.Changed
+		inserted, err := d.stores.HostKeys.RecordPending(hk, now)
+		if err != nil {
+			return fmt.Errorf("storing host key: %w", err)
+		}
+		if hk.Changed && inserted {
+			var previous []string
+			for _, k := range known {
+				if k.Status == database.HostKeyTrusted {
+					previous = append(previous, k.Fingerprint)
+				}
+			}
+			log.Printf("WARNING: host key of %s (%s) changed to %s", server.Hostname, server.IPAddress, fp)
+			d.audit(AuditHostKeyChanged, server, map[string]interface{}{
+				"fingerprint": fp, "key_type": hk.KeyType, "trusted_fingerprints": previous,
+				"remote_addr": remote.String(),
+			})
+			if d.hostKeyChanged != nil {
+				d.hostKeyChanged()
+			}
+		}
+		return refused
+	}
+}
+
+// ScanHostKey connects to server without authenticating to check the host
+// key it presents, trusting or recording it like a regular connection would.
+// It returns nil if the key is trusted and a *HostKeyError if it is not.
+func (d *Dialer) ScanHostKey(ctx context.Context, server *database.Server) error {
+	known, err := d.stores.HostKeys.ListForServer(server.ID)
+	if err != nil {
+		return fmt.Errorf("loading known host keys: %w", err)
+	}
+
+	verify := d.verifyHostKey(server, known)
+	var scanned bool
+	var result error
+	config := &ssh.ClientConfig{
+		User: "host-key-scan",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			scanned = true
+			result = verify(hostname, remote, key)
+			return errScanned
+		},
+		HostKeyAlgorithms: hostKeyAlgorithms(known),
+		Timeout:           d.cfg.Timeout,
+	}
+	via, err := d.Tunnel(ctx, server)
+	if err != nil {
+		return err
+	}
+	if via != nil {
+		defer via.Close()
+	}
+	client, err := d.connect(ctx, server, via, config, false)
+	if client != nil {
+		client.Close()
+	}
+	if !scanned {
+		return err
+	}
+	return result
+}
+
+// errScanned aborts the handshake once ScanHostKey has seen the key.
+var errScanned = errors.New("host key scanned")
+
+func (d *Dialer) audit(action string, server *database.Server, details map[string]interface{}) {
+	details["hostname"] = server.Hostname
+	if err := d.stores.Audit.Record("", action, "server", server.ID, details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// KnownHost is one entry of an OpenSSH known_hosts file.
+type KnownHost struct {
+	// Patterns are the host patterns of the line, possibly hashed.
+	Patterns []string
+	Key      ssh.PublicKey
+	Line     int
+}
+
+// Matches reports whether the entry lists the server's address, matching
+// hashed entries too. Wildcard patterns are not supported.
+func (k *KnownHost) Matches(server *database.Server) bool {
+	port := server.SSHPort
+	if port == 0 {
+		port = 22
+	}
+	var candidates []string
+	for _, host := range []string{server.IPAddress, server.Hostname} {
+		if host != "" {
+			candidates = append(candidates, knownhosts.Normalize(net.JoinHostPort(host, strconv.Itoa(port))))
+		}
+	}
+	for _, pattern := range k.Patterns {
+		for _, c := range candidates {
+			if matchPattern(pattern, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern, host string) bool {
+	if !strings.HasPrefix(pattern, "|1|") {
+		return pattern == host
+	}
+	parts := strings.Split(pattern[3:], "|")
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// ParseKnownHosts parses an OpenSSH known_hosts file. Markers
+// (@cert-authority, @revoked), negated and wildcard patterns cannot be mapped
+// to a single server and are reported in skipped.
+func ParseKnownHosts(data []byte) (entries []KnownHost, skipped []string) {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+		if err == io.EOF {
+			err = errors.New("no host key found")
+		}
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("line %d: %v", i+1, err))
+			continue
+		}
+		if marker != "" {
+			skipped = append(skipped, fmt.Sprintf("line %d: @%s entries are not supported", i+1, marker))
+			continue
+		}
+		var patterns []string
+		for _, h := range hosts {
+			if strings.ContainsAny(h, "*?!") {
+				skipped = append(skipped, fmt.Sprintf("line %d: pattern %q is not supported", i+1, h))
+				continue
+			}
+			patterns = append(patterns, h)
+		}
+		if len(patterns) > 0 {
+			entries = append(entries, KnownHost{Patterns: patterns, Key: key, Line: i + 1})
+		}
+	}
+	return entries, skipped
+}
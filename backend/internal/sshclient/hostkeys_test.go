@@ -0,0 +1,193 @@
+package sshclient
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cmdb/backend/internal/database"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestJudgeHostKey(t *testing.T) {
+	key := func(id, fp, status string, changed bool) *database.HostKey {
+		return &database.HostKey{ID: id, Fingerprint: fp, Status: status, Changed: changed}
+	}
+	trusted := key("k1", "SHA256:trusted", database.HostKeyTrusted, false)
+	rejected := key("k2", "SHA256:rejected", database.HostKeyRejected, false)
+	pending := key("k3", "SHA256:pending", database.HostKeyPending, false)
+	changed := key("k4", "SHA256:changed", database.HostKeyPending, true)
+
+	tests := []struct {
+		name      string
+		mode      string
+		known     []*database.HostKey
+		fp        string
+		wantMatch string
+		wantErr   *HostKeyError
+	}{
+		{"tofu, first key", database.HostKeyModeTOFU, nil, "SHA256:new", "", nil},
+		{"tofu, only pending keys", database.HostKeyModeTOFU, []*database.HostKey{pending}, "SHA256:new", "", nil},
+		{"tofu, trusted key", database.HostKeyModeTOFU, []*database.HostKey{trusted}, "SHA256:trusted", "k1", nil},
+		{"tofu, key changed", database.HostKeyModeTOFU, []*database.HostKey{trusted}, "SHA256:new", "",
+			&HostKeyError{Fingerprint: "SHA256:new", Changed: true}},
+		{"strict, unknown key", database.HostKeyModeStrict, nil, "SHA256:new", "",
+			&HostKeyError{Fingerprint: "SHA256:new"}},
+		{"strict, trusted key", database.HostKeyModeStrict, []*database.HostKey{trusted}, "SHA256:trusted", "k1", nil},
+		{"strict, key changed", database.HostKeyModeStrict, []*database.HostKey{trusted}, "SHA256:new", "",
+			&HostKeyError{Fingerprint: "SHA256:new", Changed: true}},
+		{"rejected key", database.HostKeyModeTOFU, []*database.HostKey{rejected}, "SHA256:rejected", "k2",
+			&HostKeyError{Fingerprint: "SHA256:rejected", Rejected: true}},
+		{"pending key", database.HostKeyModeTOFU, []*database.HostKey{pending}, "SHA256:pending", "k3",
+			&HostKeyError{Fingerprint: "SHA256:pending"}},
+		{"pending changed key", database.HostKeyModeTOFU, []*database.HostKey{trusted, changed}, "SHA256:changed", "k4",
+			&HostKeyError{Fingerprint: "SHA256:changed", Changed: true}},
+		{"one of several trusted keys", database.HostKeyModeStrict, []*database.HostKey{pending, trusted}, "SHA256:trusted", "k1", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, refused := judgeHostKey(tt.mode, tt.known, tt.fp)
+			gotMatch := ""
+			if match != nil {
+				gotMatch = match.ID
+			}
+			if gotMatch != tt.wantMatch {
+				t.Errorf("judgeHostKey() match = %q, want %q", gotMatch, tt.wantMatch)
+			}
+			if (refused == nil) != (tt.wantErr == nil) || (refused != nil && *refused != *tt.wantErr) {
+				t.Errorf("judgeHostKey() refused = %+v, want %+v", refused, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHostKeyMode(t *testing.T) {
+	d := &Dialer{cfg: Config{HostKeyMode: database.HostKeyModeTOFU}}
+	strict, empty := database.HostKeyModeStrict, ""
+
+	tests := []struct {
+		mode *string
+		want string
+	}{
+		{nil, database.HostKeyModeTOFU},
+		{&empty, database.HostKeyModeTOFU},
+		{&strict, database.HostKeyModeStrict},
+	}
+	for _, tt := range tests {
+		if got := d.hostKeyMode(&database.Server{HostKeyMode: tt.mode}); got != tt.want {
+			t.Errorf("hostKeyMode() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func testPublicKey(t *testing.T) (ssh.PublicKey, string) {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestParseKnownHosts(t *testing.T) {
+	_, key := testPublicKey(t)
+	hashed := knownhosts.HashHostname("10.0.0.5")
+
+	tests := []struct {
+		name         string
+		line         string
+		wantPatterns []string
+		wantSkipped  string
+	}{
+		{"plain host", "web-1 " + key, []string{"web-1"}, ""},
+		{"several hosts", "web-1,10.0.0.5 " + key, []string{"web-1", "10.0.0.5"}, ""},
+		{"non-default port", "[10.0.0.5]:2222 " + key, []string{"[10.0.0.5]:2222"}, ""},
+		{"hashed host", hashed + " " + key, []string{hashed}, ""},
+		{"comment", "# web-1 " + key, nil, ""},
+		{"cert authority", "@cert-authority *.example.org " + key, nil, "@cert-authority entries are not supported"},
+		{"revoked", "@revoked web-1 " + key, nil, "@revoked entries are not supported"},
+		{"wildcard", "*.example.org " + key, nil, `pattern "*.example.org" is not supported`},
+		{"wildcard next to a host", "web-?,10.0.0.5 " + key, []string{"10.0.0.5"}, `pattern "web-?" is not supported`},
+		{"negated", "!web-2,web-1 " + key, []string{"web-1"}, `pattern "!web-2" is not supported`},
+		{"no key", "web-1", nil, "line 1:"},
+		{"invalid key", "web-1 ssh-ed25519 AAAA", nil, "line 1:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, skipped := ParseKnownHosts([]byte(tt.line + "\n"))
+			var patterns []string
+			for _, e := range entries {
+				patterns = append(patterns, e.Patterns...)
+				if e.Line != 1 || e.Key == nil {
+					t.Errorf("entry = %+v, want line 1 with a key", e)
+				}
+			}
+			if fmt.Sprint(patterns) != fmt.Sprint(tt.wantPatterns) {
+				t.Errorf("patterns = %q, want %q", patterns, tt.wantPatterns)
+			}
+			if tt.wantSkipped == "" && len(skipped) > 0 {
+				t.Errorf("skipped = %q, want none", skipped)
+			}
+			if tt.wantSkipped != "" && (len(skipped) != 1 || !strings.Contains(skipped[0], tt.wantSkipped)) {
+				t.Errorf("skipped = %q, want %q", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestParseKnownHostsLineNumbers(t *testing.T) {
+	_, key := testPublicKey(t)
+	data := "# managed hosts\n\nweb-1 " + key + "\n*.example.org " + key + "\nweb-2 " + key + "\n"
+
+	entries, skipped := ParseKnownHosts([]byte(data))
+	if len(entries) != 2 || entries[0].Line != 3 || entries[1].Line != 5 {
+		t.Errorf("entries = %+v, want lines 3 and 5", entries)
+	}
+	if len(skipped) != 1 || !strings.HasPrefix(skipped[0], "line 4:") {
+		t.Errorf("skipped = %q, want line 4", skipped)
+	}
+}
+
+func TestKnownHostMatches(t *testing.T) {
+	server := func(hostname, ip string, port int) *database.Server {
+		return &database.Server{Hostname: hostname, IPAddress: ip, SSHPort: port}
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		server  *database.Server
+		want    bool
+	}{
+		{"address", "10.0.0.5", server("web-1", "10.0.0.5", 22), true},
+		{"hostname", "web-1", server("web-1", "10.0.0.5", 22), true},
+		{"unset port is 22", "10.0.0.5", server("web-1", "10.0.0.5", 0), true},
+		{"other host", "10.0.0.6", server("web-1", "10.0.0.5", 22), false},
+		{"port listed", "[10.0.0.5]:2222", server("web-1", "10.0.0.5", 2222), true},
+		{"port not listed", "10.0.0.5", server("web-1", "10.0.0.5", 2222), false},
+		{"other port listed", "[10.0.0.5]:2222", server("web-1", "10.0.0.5", 22), false},
+		{"hashed address", knownhosts.HashHostname("10.0.0.5"), server("web-1", "10.0.0.5", 22), true},
+		{"hashed hostname", knownhosts.HashHostname("web-1"), server("web-1", "10.0.0.5", 22), true},
+		{"hashed with port", knownhosts.HashHostname("[10.0.0.5]:2222"), server("web-1", "10.0.0.5", 2222), true},
+		{"hashed other host", knownhosts.HashHostname("10.0.0.6"), server("web-1", "10.0.0.5", 22), false},
+		{"malformed hash", "|1|not-base64|", server("web-1", "10.0.0.5", 22), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KnownHost{Patterns: []string{tt.pattern}}
+			if got := k.Matches(tt.server); got != tt.want {
+				t.Errorf("Matches(%s) with pattern %q = %v, want %v", tt.server.IPAddress, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package sshclient
+
+import (
+	"context"
+	"os"
+	"path"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTP is an SFTP session over its own SSH connection to a server.
+type SFTP struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+// OpenSFTP connects to server like Dial and starts the sftp subsystem.
+func (d *Dialer) OpenSFTP(ctx context.Context, server *database.Server) (*SFTP, error) {
+	conn, err := d.Dial(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentReads(true), sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &SFTP{Client: client, conn: conn}, nil
+}
+
+// MaxUpload is the largest file accepted for upload over SFTP.
+func (d *Dialer) MaxUpload() int64 {
+	return d.cfg.SFTPMaxUpload
+}
+
+// Close ends the SFTP session and its SSH connection.
+func (s *SFTP) Close() error {
+	s.Client.Close()
+	return s.conn.Close()
+}
+
+// RemoveAll removes p and, if it is a directory, everything below it.
+// Unlike sftp.Client.RemoveAll it never follows symbolic links.
+func (s *SFTP) RemoveAll(p string) error {
+	fi, err := s.Lstat(p)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return s.Remove(p)
+	}
+	entries, err := s.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := s.RemoveAll(path.Join(p, e.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return s.RemoveDirectory(p)
+}
@@ -0,0 +1,426 @@
+// Package sshclient opens SSH connections to managed servers for the web
+// terminal and background features such as certificate delivery.
+package sshclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/secrets"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Prompter answers keyboard-interactive challenges, typically by relaying
+// them to the user of the web terminal. It has the signature of
+// ssh.KeyboardInteractiveChallenge.
+type Prompter func(name, instruction string, questions []string, echos []bool) ([]string, error)
+
+// Dialer opens SSH connections to managed servers. Servers authenticate with,
+// in order, their vault credential (their own or their group's), the private
+// key file at SSHKeyPath and, if enabled, the keys of an ssh-agent. Host keys
+// are verified against the known-hosts store. Servers with a jump host chain
+// are reached through it, each hop authenticating and verifying host keys as
+// the server it is.
+type Dialer struct {
+	stores *database.Stores
+	sealer *secrets.Sealer
+	cfg    Config
+
+	hostKeyChanged func()
+}
+
+// MaxJumpHosts bounds the length of a jump host chain.
+const MaxJumpHosts = 8
+
+func New(stores *database.Stores, sealer *secrets.Sealer, cfg Config) *Dialer {
+	return &Dialer{stores: stores, sealer: sealer, cfg: cfg}
+}
+
+// Dial connects to server without user interaction.
+func (d *Dialer) Dial(ctx context.Context, server *database.Server) (*ssh.Client, error) {
+	return d.DialInteractive(ctx, server, nil)
+}
+
+// DialInteractive connects to server, passing keyboard-interactive
+// challenges that the stored password cannot answer to prompt. With a
+// prompt, servers without any stored secret can still be reached by typing
+// a password.
+func (d *Dialer) DialInteractive(ctx context.Context, server *database.Server, prompt Prompter) (*ssh.Client, error) {
+	hops, err := d.JumpChain(server)
+	if err != nil {
+		return nil, err
+	}
+	via, err := d.dialChain(ctx, hops, prompt)
+	if err != nil {
+		return nil, err
+	}
+	client, err := d.dialServer(ctx, server, via, prompt)
+	if err != nil {
+		if via != nil {
+			via.Close()
+		}
+		return nil, err
+	}
+	closeWith(client, via)
+	return client, nil
+}
+
+// Tunnel connects to the last jump host in front of server, through which
+// connections to server can be dialed with DialContext. It returns nil if
+// the server is reached directly. Closing the client closes the whole chain.
+func (d *Dialer) Tunnel(ctx context.Context, server *database.Server) (*ssh.Client, error) {
+	hops, err := d.JumpChain(server)
+	if err != nil {
+		return nil, err
+	}
+	return d.dialChain(ctx, hops, nil)
+}
+
+// JumpChain returns the jump hosts in front of server in dialing order: the
+// server's own chain, else its group's. A jump host that belongs to a group
+// whose chain includes it is reached over the hops before it.
+func (d *Dialer) JumpChain(server *database.Server) ([]*database.Server, error) {
+	ids, err := d.stores.JumpHosts.Chain(server.ID)
+	if err != nil {
+		return nil, fmt.Errorf("loading jump hosts: %w", err)
+	}
+	if len(ids) > MaxJumpHosts {
+		return nil, fmt.Errorf("jump host chain of %s is longer than %d hops", server.Hostname, MaxJumpHosts)
+	}
+	var hops []*database.Server
+	for _, id := range hopsBefore(server.ID, ids) {
+		hop, err := d.stores.Servers.GetByID(id)
+		if err != nil {
+			return nil, fmt.Errorf("loading jump host %s: %w", id, err)
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// dialChain connects to each hop through the previous one and returns the
+// last, or nil for an empty chain.
+func (d *Dialer) dialChain(ctx context.Context, hops []*database.Server, prompt Prompter) (*ssh.Client, error) {
+	var via *ssh.Client
+	for _, hop := range hops {
+		client, err := d.dialServer(ctx, hop, via, hopPrompter(hop, prompt))
+		if err != nil {
+			if via != nil {
+				via.Close()
+			}
+			return nil, fmt.Errorf("jump host %s: %w", hop.Hostname, err)
+		}
+		closeWith(client, via)
+		via = client
+	}
+	return via, nil
+}
+
+// closeWith closes via, and with it the hops before it, once client's
+// connection has ended.
+func closeWith(client, via *ssh.Client) {
+	if via == nil {
+		return
+	}
+	go func() {
+		client.Wait()
+		via.Close()
+	}()
+}
+
+// hopPrompter names the jump host in prompts that would otherwise not say
+// which host is asking.
+func hopPrompter(hop *database.Server, prompt Prompter) Prompter {
+	if prompt == nil {
+		return nil
+	}
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if name == "" {
+			name = "Jump host " + hop.Hostname
+		}
+		return prompt(name, instruction, questions, echos)
+	}
+}
+
+// dialServer authenticates to server, directly or through via.
+func (d *Dialer) dialServer(ctx context.Context, server *database.Server, via *ssh.Client, prompt Prompter) (*ssh.Client, error) {
+	cred, err := d.stores.Credentials.ForServer(server.ID)
+	if err != nil {
+		return nil, fmt.Errorf("loading SSH credential: %w", err)
+	}
+
+	user := ""
+	if server.SSHUsername != nil {
+		user = *server.SSHUsername
+	}
+	if user == "" && cred != nil && cred.Username != nil {
+		user = *cred.Username
+	}
+	if user == "" {
+		return nil, errors.New("SSH username not configured for this server")
+	}
+
+	auth, cleanup, err := d.authMethods(server, cred, prompt)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	if len(auth) == 0 {
+		return nil, errors.New("no SSH credential, key path or agent configured for this server")
+	}
+
+	known, err := d.stores.HostKeys.ListForServer(server.ID)
+	if err != nil {
+		return nil, fmt.Errorf("loading known host keys: %w", err)
+	}
+
+	config := &ssh.ClientConfig{
+		User:              user,
+		Auth:              auth,
+		HostKeyCallback:   d.verifyHostKey(server, known),
+		HostKeyAlgorithms: hostKeyAlgorithms(known),
+		Timeout:           d.cfg.Timeout,
+	}
+
+	client, err := d.connect(ctx, server, via, config, prompt != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if cred != nil {
+		if err := d.stores.Credentials.MarkUsed(cred.ID, time.Now()); err != nil {
+			log.Printf("Failed to record use of credential %s: %v", cred.Name, err)
+		}
+	}
+	return client, nil
+}
+
+// connect dials server, through via when it is set, and runs the SSH
+// handshake. Interactive handshakes may wait on the user, so they are only
+// bounded by ctx.
+func (d *Dialer) connect(ctx context.Context, server *database.Server, via *ssh.Client, config *ssh.ClientConfig, interactive bool) (*ssh.Client, error) {
+	port := server.SSHPort
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(server.IPAddress, strconv.Itoa(port))
+
+	dialCtx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if via != nil {
+		conn, err = via.DialContext(dialCtx, "tcp", addr)
+	} else {
+		var nd net.Dialer
+		conn, err = nd.DialContext(dialCtx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Connections tunneled through a jump host do not support deadlines, so
+	// the handshake is bounded by closing the connection instead.
+	handshakeDone := make(chan struct{})
+	var expired <-chan time.Time
+	if !interactive {
+		timer := time.NewTimer(d.cfg.Timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	aborted := make(chan error, 1)
+	go func() {
+		select {
+		case <-handshakeDone:
+			aborted <- nil
+			return
+		case <-ctx.Done():
+			aborted <- ctx.Err()
+		case <-expired:
+			aborted <- errors.New("ssh: handshake timed out")
+		}
+		conn.Close()
+	}()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	close(handshakeDone)
+	if abortErr := <-aborted; abortErr != nil {
+		if err == nil {
+			c.Close()
+		}
+		err = abortErr
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// authMethods builds the authentication methods for server. cleanup closes
+// the agent connection, which is only needed during the handshake.
+func (d *Dialer) authMethods(server *database.Server, cred *database.Credential, prompt Prompter) ([]ssh.AuthMethod, func(), error) {
+	var signers []ssh.Signer
+	password := ""
+	cleanup := func() {}
+
+	if cred != nil {
+		m, err := OpenMaterial(d.sealer, cred)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		switch cred.Kind {
+		case database.CredentialPrivateKey:
+			signer, err := ParseKey(m)
+			if err != nil {
+				return nil, cleanup, fmt.Errorf("credential %s: %w", cred.Name, err)
+			}
+			signers = append(signers, signer)
+		case database.CredentialPassword:
+			password = m.Password
+		}
+	}
+
+	if server.SSHKeyPath != nil && *server.SSHKeyPath != "" {
+		keyData, err := os.ReadFile(*server.SSHKeyPath)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("reading SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(keyData)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("parsing SSH key: %w", err)
+		}
+		signers = append(signers, signer)
+	}
+
+	var agentSigners func() ([]ssh.Signer, error)
+	if d.cfg.UseAgent && d.cfg.AgentSocket != "" {
+		conn, err := net.Dial("unix", d.cfg.AgentSocket)
+		if err != nil {
+			log.Printf("ssh-agent unavailable at %s: %v", d.cfg.AgentSocket, err)
+		} else {
+			cleanup = func() { conn.Close() }
+			agentSigners = agent.NewClient(conn).Signers
+		}
+	}
+
+	var auth []ssh.AuthMethod
+	if len(signers) > 0 || agentSigners != nil {
+		auth = append(auth, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			all := signers
+			if agentSigners != nil {
+				fromAgent, err := agentSigners()
+				if err != nil {
+					log.Printf("Listing ssh-agent keys: %v", err)
+				}
+				all = append(append([]ssh.Signer{}, signers...), fromAgent...)
+			}
+			return all, nil
+		}))
+	}
+
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	} else if prompt != nil {
+		auth = append(auth, ssh.PasswordCallback(func() (string, error) {
+			answers, err := prompt("", "", []string{"Password: "}, []bool{false})
+			if err != nil || len(answers) != 1 {
+				return "", errors.New("password prompt cancelled")
+			}
+			return answers[0], nil
+		}))
+	}
+	if password != "" || prompt != nil {
+		auth = append(auth, ssh.KeyboardInteractive(answerChallenge(password, prompt)))
+	}
+	return auth, cleanup, nil
+}
+
+// answerChallenge answers password prompts with the stored password and
+// passes anything else, such as one-time codes, to prompt.
+func answerChallenge(password string, prompt Prompter) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		var ask []int
+		for i, q := range questions {
+			if password != "" && !echos[i] && strings.Contains(strings.ToLower(q), "password") {
+				answers[i] = password
+			} else {
+				ask = append(ask, i)
+			}
+		}
+		if len(ask) == 0 {
+			return answers, nil
+		}
+		if prompt == nil {
+			return nil, errors.New("server asked for interactive input")
+		}
+		qs := make([]string, len(ask))
+		es := make([]bool, len(ask))
+		for j, i := range ask {
+			qs[j], es[j] = questions[i], echos[i]
+		}
+		replies, err := prompt(name, instruction, qs, es)
+		if err != nil {
+			return nil, err
+		}
+		if len(replies) != len(ask) {
+			return nil, errors.New("wrong number of answers")
+		}
+		for j, i := range ask {
+			answers[i] = replies[j]
+		}
+		return answers, nil
+	}
+}
+
+// Run executes cmd in a new session, feeding it stdin if given, and returns
+// the combined output. A non-zero exit status is returned as an error that
+// includes the output.
+func Run(client *ssh.Client, cmd string, stdin io.Reader) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var out lockedBuffer
+	session.Stdout = &out
+	session.Stderr = &out
+	session.Stdin = stdin
+
+	if err := session.Run(cmd); err != nil {
+		return out.buf.Bytes(), fmt.Errorf("%s: %w: %s", cmd, err, strings.TrimSpace(out.buf.String()))
+	}
+	return out.buf.Bytes(), nil
+}
+
+// lockedBuffer collects stdout and stderr, which the session copies from
+// separate goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Quote returns s quoted for a POSIX shell.
+func Quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
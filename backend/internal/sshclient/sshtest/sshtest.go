@@ -0,0 +1,271 @@
+// Package sshtest runs in-process SSH servers for tests. A server accepts one
+// client key, forwards direct-tcpip channels and answers exec requests, so it
+// can stand in for both jump hosts and targets.
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+	"golang.org/x/crypto/ssh"
+)
+
+// ExecFunc runs command for an exec request, writing its output to ch, and
+// returns the exit status. closed is closed once the client closes the
+// channel or its connection, e.g. after killing the command.
+type ExecFunc func(command string, ch ssh.Channel, closed <-chan struct{}) uint32
+
+type Server struct {
+	Name    string
+	Addr    string
+	HostKey ssh.Signer
+
+	mu        sync.Mutex
+	exec      ExecFunc
+	open      int
+	forwarded []string
+	idle      chan struct{}
+}
+
+// NewServer starts a server that authenticates clientKey only. Exec requests
+// print the server's name until SetExec installs another handler.
+func NewServer(t *testing.T, name string, clientKey ssh.PublicKey) *Server {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	s := &Server{Name: name, Addr: ln.Addr().String(), HostKey: hostKey, idle: make(chan struct{}, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+// SetExec replaces the handler of exec requests.
+func (s *Server) SetExec(fn ExecFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exec = fn
+}
+
+func (s *Server) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.open++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.open--
+		if s.open == 0 {
+			select {
+			case s.idle <- struct{}{}:
+			default:
+			}
+		}
+		s.mu.Unlock()
+	}()
+
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "direct-tcpip":
+			go s.forward(nc)
+		case "session":
+			go s.session(nc)
+		default:
+			nc.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+	sconn.Wait()
+}
+
+func (s *Server) forward(nc ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &target); err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	addr := net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port)))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	s.mu.Lock()
+	s.forwarded = append(s.forwarded, addr)
+	s.mu.Unlock()
+
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		io.Copy(conn, ch)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(ch, conn)
+	ch.Close()
+	conn.Close()
+}
+
+func (s *Server) session(nc ssh.NewChannel) {
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		// Later requests, such as signals, are refused; reqs is closed
+		// with the channel.
+		closed := make(chan struct{})
+		go func() {
+			for req := range reqs {
+				req.Reply(false, nil)
+			}
+			close(closed)
+		}()
+
+		s.mu.Lock()
+		exec := s.exec
+		s.mu.Unlock()
+		status := uint32(0)
+		if exec != nil {
+			status = exec(payload.Command, ch, closed)
+		} else {
+			io.WriteString(ch, s.Name)
+		}
+		ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+// Forwards returns the addresses the server forwarded connections to.
+func (s *Server) Forwards() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.forwarded...)
+}
+
+// WaitIdle fails the test unless every connection to s is closed soon.
+func (s *Server) WaitIdle(t *testing.T) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		s.mu.Lock()
+		open := s.open
+		s.mu.Unlock()
+		if open == 0 {
+			return
+		}
+		select {
+		case <-s.idle:
+		case <-deadline:
+			t.Fatalf("%s still has %d open connections", s.Name, open)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// Record returns a server record for s that logs in as deploy.
+func (s *Server) Record(t *testing.T) *database.Server {
+	t.Helper()
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	user := "deploy"
+	return &database.Server{Hostname: s.Name, IPAddress: host, SSHPort: p, SSHUsername: &user, Status: "unknown"}
+}
+
+// ClientConfig authenticates with signer and accepts only s's host key.
+func (s *Server) ClientConfig(signer ssh.Signer) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            "deploy",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(s.HostKey.PublicKey()),
+	}
+}
+
+// ClientKey returns a client key and the path of its OpenSSH private key
+// file.
+func ClientKey(t *testing.T) (ssh.Signer, string) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, path
+}
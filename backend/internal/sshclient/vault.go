@@ -0,0 +1,98 @@
+package sshclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cmdb/backend/internal/database"
+	"github.com/cmdb/backend/internal/secrets"
+	"golang.org/x/crypto/ssh"
+)
+
+// Material is the secret part of a vault credential, sealed as one JSON
+// object.
+type Material struct {
+	PrivateKey string `json:"private_key,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Password   string `json:"password,omitempty"`
+}
+
+func credentialAD(id string) []byte {
+	return []byte("credential:" + id)
+}
+
+// SealMaterial encrypts a credential's secret bound to its ID.
+func SealMaterial(sealer *secrets.Sealer, credentialID string, m Material) ([]byte, error) {
+	if sealer == nil {
+		return nil, secrets.ErrNotConfigured
+	}
+	plain, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return sealer.Seal(plain, credentialAD(credentialID))
+}
+
+// OpenMaterial decrypts a credential's secret.
+func OpenMaterial(sealer *secrets.Sealer, c *database.Credential) (Material, error) {
+	var m Material
+	if sealer == nil {
+		return m, secrets.ErrNotConfigured
+	}
+	plain, err := sealer.Open(c.EncryptedSecret, credentialAD(c.ID))
+	if err != nil {
+		return m, fmt.Errorf("decrypting credential %s: %w", c.Name, err)
+	}
+	err = json.Unmarshal(plain, &m)
+	return m, err
+}
+
+// ParseKey parses the private key of m, decrypting it with the passphrase if
+// it is encrypted.
+func ParseKey(m Material) (ssh.Signer, error) {
+	if m.Passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase([]byte(m.PrivateKey), []byte(m.Passphrase))
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(m.PrivateKey))
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, errors.New("private key is encrypted, a passphrase is required")
+	}
+	return signer, err
+}
+
+// Describe checks m against kind and fills in the public fields of c.
+func Describe(c *database.Credential, m Material) error {
+	c.PublicKey, c.Fingerprint = nil, nil
+	c.HasPassphrase = false
+
+	switch c.Kind {
+	case database.CredentialPrivateKey:
+		if m.PrivateKey == "" {
+			return errors.New("private_key is required")
+		}
+		if m.Password != "" {
+			return errors.New("password is not used with private_key credentials")
+		}
+		signer, err := ParseKey(m)
+		if err != nil {
+			return fmt.Errorf("invalid private key: %w", err)
+		}
+		pub := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+		fp := ssh.FingerprintSHA256(signer.PublicKey())
+		c.PublicKey, c.Fingerprint = &pub, &fp
+		c.HasPassphrase = m.Passphrase != ""
+	case database.CredentialPassword:
+		if m.Password == "" {
+			return errors.New("password is required")
+		}
+		if m.PrivateKey != "" || m.Passphrase != "" {
+			return errors.New("private_key and passphrase are not used with password credentials")
+		}
+	default:
+		return fmt.Errorf("kind must be %s or %s", database.CredentialPrivateKey, database.CredentialPassword)
+	}
+	return nil
+}
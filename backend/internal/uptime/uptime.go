@@ -0,0 +1,186 @@
+// Package uptime turns probe history into availability reports: uptime
+// percentage, outage intervals and mean time to recovery.
+package uptime
+
+import (
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+// Outage is a stretch of failed probes. For raw samples DurationSeconds is the
+// time from the first failure to the next success. Rollups only say how many
+// probes in an hour failed, so there it is that share of the hour and Start
+// and End only bound the outage to whole hours.
+type Outage struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Ongoing is true when the target was still down at the end of the range.
+	Ongoing bool `json:"ongoing"`
+}
+
+type Report struct {
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	Samples         int       `json:"samples"`
+	UpSamples       int       `json:"up_samples"`
+	UptimePercent   *float64  `json:"uptime_percent"`
+	DowntimeSeconds float64   `json:"downtime_seconds"`
+	Outages         []Outage  `json:"outages"`
+	MTTRSeconds     *float64  `json:"mttr_seconds"`
+	AvgLatencyMs    *float64  `json:"avg_latency_ms"`
+}
+
+// Compute builds a report from samples ordered by time. Uptime is the share of
+// successful probes. An outage starts at the first failing sample and ends at
+// the next fully successful one. Hourly rollups count the failed share of the
+// hour as downtime, so one failed probe out of 60 is one minute, the same
+// ratio the uptime percentage uses.
+func Compute(samples []*database.ProbeSample, from, to time.Time) Report {
+	report := Report{From: from, To: to, Outages: []Outage{}}
+
+	var (
+		open *Outage
+		// downSince is the first failed raw sample of the current outage
+		// not yet counted in open.DurationSeconds.
+		downSince    *time.Time
+		latencySum   float64
+		latencyCount int
+	)
+	countRaw := func(until time.Time) {
+		if downSince != nil {
+			open.DurationSeconds += overlap(*downSince, until, from, to)
+			downSince = nil
+		}
+	}
+	closeOutage := func(end time.Time, ongoing bool) {
+		countRaw(end)
+		if end.After(to) {
+			end = to
+		}
+		open.End, open.Ongoing = end, ongoing
+		report.Outages = append(report.Outages, *open)
+		report.DowntimeSeconds += open.DurationSeconds
+		open = nil
+	}
+
+	for _, s := range samples {
+		report.Samples += s.Total
+		report.UpSamples += s.Up
+
+		if s.LatencyMs != nil && s.Up > 0 {
+			latencySum += *s.LatencyMs * float64(s.Up)
+			latencyCount += s.Up
+		}
+
+		down := s.Up < s.Total
+		if !down {
+			if open != nil {
+				closeOutage(s.At, false)
+			}
+			continue
+		}
+		if open == nil {
+			start := s.At
+			if start.Before(from) {
+				start = from
+			}
+			open = &Outage{Start: start}
+		}
+		if s.Span > 0 {
+			countRaw(s.At)
+			failed := float64(s.Total-s.Up) / float64(s.Total)
+			open.DurationSeconds += failed * overlap(s.At, s.At.Add(s.Span), from, to)
+		} else if downSince == nil {
+			at := s.At
+			downSince = &at
+		}
+	}
+	if open != nil {
+		closeOutage(to, true)
+	}
+
+	if report.Samples > 0 {
+		pct := float64(report.UpSamples) / float64(report.Samples) * 100
+		report.UptimePercent = &pct
+	}
+	if latencyCount > 0 {
+		avg := latencySum / float64(latencyCount)
+		report.AvgLatencyMs = &avg
+	}
+
+	var recovered []float64
+	for _, o := range report.Outages {
+		if !o.Ongoing {
+			recovered = append(recovered, o.DurationSeconds)
+		}
+	}
+	if len(recovered) > 0 {
+		var sum float64
+		for _, d := range recovered {
+			sum += d
+		}
+		mttr := sum / float64(len(recovered))
+		report.MTTRSeconds = &mttr
+	}
+
+	return report
+}
+
+// Merge combines several reports covering the same range into one rollup.
+// Uptime is weighted by sample count and MTTR is averaged over all recovered
+// outages. The merged report does not list individual outages.
+func Merge(reports []Report, from, to time.Time) Report {
+	merged := Report{From: from, To: to, Outages: []Outage{}}
+
+	var (
+		recoveredSum   float64
+		recoveredCount int
+		latencySum     float64
+		latencyWeight  int
+	)
+	for _, r := range reports {
+		merged.Samples += r.Samples
+		merged.UpSamples += r.UpSamples
+		merged.DowntimeSeconds += r.DowntimeSeconds
+		if r.AvgLatencyMs != nil {
+			latencySum += *r.AvgLatencyMs * float64(r.UpSamples)
+			latencyWeight += r.UpSamples
+		}
+		for _, o := range r.Outages {
+			if !o.Ongoing {
+				recoveredSum += o.DurationSeconds
+				recoveredCount++
+			}
+		}
+	}
+
+	if merged.Samples > 0 {
+		pct := float64(merged.UpSamples) / float64(merged.Samples) * 100
+		merged.UptimePercent = &pct
+	}
+	if recoveredCount > 0 {
+		mttr := recoveredSum / float64(recoveredCount)
+		merged.MTTRSeconds = &mttr
+	}
+	if latencyWeight > 0 {
+		avg := latencySum / float64(latencyWeight)
+		merged.AvgLatencyMs = &avg
+	}
+	return merged
+}
+
+// overlap returns the seconds of [start, end) that fall inside [from, to).
+func overlap(start, end, from, to time.Time) float64 {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start).Seconds()
+}
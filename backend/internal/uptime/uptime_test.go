@@ -0,0 +1,237 @@
+package uptime
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cmdb/backend/internal/database"
+)
+
+var (
+	t10 = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	t11 = t10.Add(time.Hour)
+	t14 = t10.Add(4 * time.Hour)
+)
+
+func raw(at time.Time, up bool) *database.ProbeSample {
+	s := &database.ProbeSample{At: at, Total: 1}
+	if up {
+		s.Up = 1
+	}
+	return s
+}
+
+func hourly(at time.Time, total, up int) *database.ProbeSample {
+	return &database.ProbeSample{At: at, Span: time.Hour, Total: total, Up: up}
+}
+
+func ptr(f float64) *float64 { return &f }
+
+func approx(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return math.Abs(*a-*b) < 1e-9
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name         string
+		from         time.Time
+		samples      []*database.ProbeSample
+		wantUptime   *float64
+		wantDowntime float64
+		wantOutages  []Outage
+		wantMTTR     *float64
+	}{
+		{
+			name:        "no samples",
+			from:        t10,
+			wantOutages: []Outage{},
+		},
+		{
+			name: "raw outage",
+			from: t10,
+			samples: []*database.ProbeSample{
+				raw(t10, true),
+				raw(t10.Add(time.Minute), false),
+				raw(t10.Add(2*time.Minute), false),
+				raw(t10.Add(3*time.Minute), true),
+			},
+			wantUptime:   ptr(50),
+			wantDowntime: 120,
+			wantOutages:  []Outage{{Start: t10.Add(time.Minute), End: t10.Add(3 * time.Minute), DurationSeconds: 120}},
+			wantMTTR:     ptr(120),
+		},
+		{
+			name: "still down at the end",
+			from: t10,
+			samples: []*database.ProbeSample{
+				raw(t10, true),
+				raw(t14.Add(-time.Minute), false),
+			},
+			wantUptime:   ptr(50),
+			wantDowntime: 60,
+			wantOutages:  []Outage{{Start: t14.Add(-time.Minute), End: t14, DurationSeconds: 60, Ongoing: true}},
+		},
+		{
+			name: "one failed probe in an hourly rollup",
+			from: t10,
+			samples: []*database.ProbeSample{
+				hourly(t10, 60, 59),
+				hourly(t11, 60, 60),
+			},
+			wantUptime:   ptr(119.0 / 120 * 100),
+			wantDowntime: 60,
+			wantOutages:  []Outage{{Start: t10, End: t11, DurationSeconds: 60}},
+			wantMTTR:     ptr(60),
+		},
+		{
+			name: "outage across the rollup and raw boundary",
+			from: t10,
+			samples: []*database.ProbeSample{
+				hourly(t10, 60, 0),
+				raw(t11, false),
+				raw(t11.Add(5*time.Minute), true),
+			},
+			wantUptime:   ptr(1.0 / 62 * 100),
+			wantDowntime: 3900,
+			wantOutages:  []Outage{{Start: t10, End: t11.Add(5 * time.Minute), DurationSeconds: 3900}},
+			wantMTTR:     ptr(3900),
+		},
+		{
+			name: "rollup outage recovered by the first raw sample",
+			from: t10,
+			samples: []*database.ProbeSample{
+				hourly(t10, 60, 30),
+				raw(t11, true),
+			},
+			wantUptime:   ptr(31.0 / 61 * 100),
+			wantDowntime: 1800,
+			wantOutages:  []Outage{{Start: t10, End: t11, DurationSeconds: 1800}},
+			wantMTTR:     ptr(1800),
+		},
+		{
+			name: "window starts mid-outage",
+			from: t10.Add(30 * time.Minute),
+			samples: []*database.ProbeSample{
+				hourly(t10, 60, 0),
+				raw(t11, true),
+			},
+			wantUptime:   ptr(1.0 / 61 * 100),
+			wantDowntime: 1800,
+			wantOutages:  []Outage{{Start: t10.Add(30 * time.Minute), End: t11, DurationSeconds: 1800}},
+			wantMTTR:     ptr(1800),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compute(tt.samples, tt.from, t14)
+			if !approx(got.UptimePercent, tt.wantUptime) {
+				t.Errorf("UptimePercent = %v, want %v", got.UptimePercent, tt.wantUptime)
+			}
+			if got.DowntimeSeconds != tt.wantDowntime {
+				t.Errorf("DowntimeSeconds = %v, want %v", got.DowntimeSeconds, tt.wantDowntime)
+			}
+			if !reflect.DeepEqual(got.Outages, tt.wantOutages) {
+				t.Errorf("Outages = %+v, want %+v", got.Outages, tt.wantOutages)
+			}
+			if !approx(got.MTTRSeconds, tt.wantMTTR) {
+				t.Errorf("MTTRSeconds = %v, want %v", got.MTTRSeconds, tt.wantMTTR)
+			}
+		})
+	}
+}
+
+func TestComputeLatency(t *testing.T) {
+	fast, slow := 10.0, 40.0
+	samples := []*database.ProbeSample{
+		{At: t10, Span: time.Hour, Total: 60, Up: 30, LatencyMs: &fast},
+		{At: t11, Total: 1, Up: 1, LatencyMs: &slow},
+		{At: t11.Add(time.Minute), Total: 1, LatencyMs: &slow},
+	}
+	got := Compute(samples, t10, t14)
+	if want := ptr((10.0*30 + 40) / 31); !approx(got.AvgLatencyMs, want) {
+		t.Errorf("AvgLatencyMs = %v, want %v", *got.AvgLatencyMs, *want)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	latency := func(ms float64) *float64 { return &ms }
+	tests := []struct {
+		name         string
+		reports      []Report
+		wantSamples  int
+		wantUptime   *float64
+		wantDowntime float64
+		wantMTTR     *float64
+		wantLatency  *float64
+	}{
+		{
+			name: "no reports",
+		},
+		{
+			name:    "no samples",
+			reports: []Report{Compute(nil, t10, t14), Compute(nil, t10, t14)},
+		},
+		{
+			name: "weighted by samples",
+			reports: []Report{
+				{
+					Samples: 100, UpSamples: 90, DowntimeSeconds: 600, AvgLatencyMs: latency(10),
+					Outages: []Outage{{DurationSeconds: 600}},
+				},
+				{Samples: 300, UpSamples: 300, AvgLatencyMs: latency(20)},
+			},
+			wantSamples:  400,
+			wantUptime:   ptr(97.5),
+			wantDowntime: 600,
+			wantMTTR:     ptr(600),
+			wantLatency:  ptr((10.0*90 + 20*300) / 390),
+		},
+		{
+			name: "ongoing outages count as downtime but not for MTTR",
+			reports: []Report{
+				{
+					Samples: 10, UpSamples: 5, DowntimeSeconds: 400,
+					Outages: []Outage{{DurationSeconds: 100}, {DurationSeconds: 300, Ongoing: true}},
+				},
+				{
+					Samples: 10, UpSamples: 8, DowntimeSeconds: 200,
+					Outages: []Outage{{DurationSeconds: 200}},
+				},
+			},
+			wantSamples:  20,
+			wantUptime:   ptr(65),
+			wantDowntime: 600,
+			wantMTTR:     ptr(150),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Merge(tt.reports, t10, t14)
+			if got.Samples != tt.wantSamples {
+				t.Errorf("Samples = %d, want %d", got.Samples, tt.wantSamples)
+			}
+			if !approx(got.UptimePercent, tt.wantUptime) {
+				t.Errorf("UptimePercent = %v, want %v", got.UptimePercent, tt.wantUptime)
+			}
+			if got.DowntimeSeconds != tt.wantDowntime {
+				t.Errorf("DowntimeSeconds = %v, want %v", got.DowntimeSeconds, tt.wantDowntime)
+			}
+			if !approx(got.MTTRSeconds, tt.wantMTTR) {
+				t.Errorf("MTTRSeconds = %v, want %v", got.MTTRSeconds, tt.wantMTTR)
+			}
+			if !approx(got.AvgLatencyMs, tt.wantLatency) {
+				t.Errorf("AvgLatencyMs = %v, want %v", got.AvgLatencyMs, tt.wantLatency)
+			}
+			if got.Outages == nil || len(got.Outages) != 0 {
+				t.Errorf("Outages = %v, want an empty list", got.Outages)
+			}
+		})
+	}
+}
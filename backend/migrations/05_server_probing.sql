@@ -0,0 +1,17 @@
+-- Reachability data written by the background prober
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMP;
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS latency_ms DOUBLE PRECISION;
+
+-- History of server status transitions (online -> offline, etc.)
+CREATE TABLE IF NOT EXISTS server_status_events (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    previous_status VARCHAR(50),
+    status VARCHAR(50) NOT NULL,
+    latency_ms DOUBLE PRECISION,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_server_status_events_server_id ON server_status_events(server_id, created_at DESC);
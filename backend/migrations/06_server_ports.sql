@@ -0,0 +1,26 @@
+-- Declared ports per server with the state they are expected to be in
+CREATE TABLE IF NOT EXISTS server_ports (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    port INTEGER NOT NULL CHECK (port BETWEEN 1 AND 65535),
+    transport VARCHAR(3) NOT NULL DEFAULT 'tcp' CHECK (transport IN ('tcp', 'udp')),
+    protocol VARCHAR(50),
+    description TEXT,
+    expected_state VARCHAR(10) NOT NULL DEFAULT 'open' CHECK (expected_state IN ('open', 'closed')),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    last_state VARCHAR(20),
+    last_checked_at TIMESTAMP,
+    latency_ms DOUBLE PRECISION,
+    drift BOOLEAN NOT NULL DEFAULT FALSE,
+    drift_since TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(server_id, port, transport)
+);
+
+CREATE INDEX IF NOT EXISTS idx_server_ports_server_id ON server_ports(server_id);
+CREATE INDEX IF NOT EXISTS idx_server_ports_drift ON server_ports(drift) WHERE drift;
+
+DROP TRIGGER IF EXISTS update_server_ports_updated_at ON server_ports;
+CREATE TRIGGER update_server_ports_updated_at BEFORE UPDATE ON server_ports
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,28 @@
+-- Raw probe results, partitioned by month. Partitions are created and dropped
+-- by the backend (see ProbeResultStore.EnsurePartitions / DropPartitionsBefore).
+-- port_id is NULL for the server-level result.
+CREATE TABLE IF NOT EXISTS probe_results (
+    server_id VARCHAR(36) NOT NULL,
+    port_id VARCHAR(36),
+    checked_at TIMESTAMP NOT NULL,
+    state VARCHAR(20) NOT NULL,
+    up BOOLEAN NOT NULL,
+    latency_ms DOUBLE PRECISION,
+    error TEXT
+) PARTITION BY RANGE (checked_at);
+
+CREATE INDEX IF NOT EXISTS idx_probe_results_server_checked ON probe_results(server_id, port_id, checked_at);
+
+-- Hourly rollups kept after raw partitions have been dropped
+CREATE TABLE IF NOT EXISTS probe_results_hourly (
+    server_id VARCHAR(36) NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    port_id VARCHAR(36) REFERENCES server_ports(id) ON DELETE CASCADE,
+    bucket TIMESTAMP NOT NULL,
+    samples INTEGER NOT NULL,
+    up_samples INTEGER NOT NULL,
+    avg_latency_ms DOUBLE PRECISION,
+    max_latency_ms DOUBLE PRECISION
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_probe_results_hourly_key ON probe_results_hourly(server_id, (COALESCE(port_id, '')), bucket);
+CREATE INDEX IF NOT EXISTS idx_probe_results_hourly_bucket ON probe_results_hourly(bucket);
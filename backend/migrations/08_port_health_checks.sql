@@ -0,0 +1,4 @@
+-- Application-level health checks on declared ports
+ALTER TABLE server_ports ADD COLUMN IF NOT EXISTS check_type VARCHAR(20) NOT NULL DEFAULT 'tcp';
+ALTER TABLE server_ports ADD COLUMN IF NOT EXISTS check_config JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE server_ports ADD COLUMN IF NOT EXISTS last_error TEXT;
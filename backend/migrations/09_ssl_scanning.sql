@@ -0,0 +1,16 @@
+-- Fields populated by the live TLS certificate scanner
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS scan_host VARCHAR(255);
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS scan_port INTEGER;
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS sni VARCHAR(255);
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'manual';
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS subject TEXT;
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS serial_number TEXT;
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS sans TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS key_type VARCHAR(20);
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS key_bits INTEGER;
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS signature_algorithm VARCHAR(50);
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS fingerprint_sha256 VARCHAR(64);
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS chain JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE ssl_certificates ADD COLUMN IF NOT EXISTS last_scan_error TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_ssl_certificates_endpoint ON ssl_certificates(server_id, scan_host, scan_port);
@@ -0,0 +1,14 @@
+-- Validation findings computed for each scanned certificate
+CREATE TABLE IF NOT EXISTS ssl_certificate_findings (
+    id VARCHAR(36) PRIMARY KEY,
+    certificate_id VARCHAR(36) NOT NULL REFERENCES ssl_certificates(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    severity VARCHAR(20) NOT NULL,
+    subject TEXT NOT NULL DEFAULT '',
+    message TEXT NOT NULL,
+    first_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(certificate_id, type, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssl_certificate_findings_certificate_id ON ssl_certificate_findings(certificate_id);
@@ -0,0 +1,9 @@
+-- Private keys uploaded with a certificate, only kept when the uploader opts in.
+-- encrypted_key is sealed with AES-256-GCM using SECRETS_MASTER_KEY and is never returned by the API.
+CREATE TABLE IF NOT EXISTS ssl_certificate_keys (
+    certificate_id VARCHAR(36) PRIMARY KEY REFERENCES ssl_certificates(id) ON DELETE CASCADE,
+    key_type VARCHAR(20) NOT NULL,
+    encrypted_key BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,26 @@
+-- ACME accounts, one per directory URL. encrypted_key is sealed with SECRETS_MASTER_KEY.
+CREATE TABLE IF NOT EXISTS acme_accounts (
+    directory_url TEXT PRIMARY KEY,
+    email VARCHAR(255),
+    account_url TEXT,
+    encrypted_key BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- How an auto_renew certificate is renewed and where the result is delivered
+CREATE TABLE IF NOT EXISTS ssl_certificate_renewals (
+    certificate_id VARCHAR(36) PRIMARY KEY REFERENCES ssl_certificates(id) ON DELETE CASCADE,
+    solver VARCHAR(50) NOT NULL DEFAULT 'http-01',
+    webroot TEXT,
+    cert_path TEXT NOT NULL,
+    key_path TEXT NOT NULL,
+    chain_path TEXT,
+    reload_command TEXT,
+    last_attempt_at TIMESTAMP,
+    last_renewed_at TIMESTAMP,
+    last_error TEXT,
+    failures INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,37 @@
+-- Alertmanager instances that alerts are pushed to. Secrets are sealed with SECRETS_MASTER_KEY.
+CREATE TABLE IF NOT EXISTS alertmanager_receivers (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    url TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    auth_type VARCHAR(20) NOT NULL DEFAULT 'none',
+    username VARCHAR(255),
+    encrypted_password BYTEA,
+    encrypted_bearer_token BYTEA,
+    tls_ca_cert TEXT,
+    tls_client_cert TEXT,
+    encrypted_tls_client_key BYTEA,
+    tls_insecure_skip_verify BOOLEAN NOT NULL DEFAULT FALSE,
+    timeout_seconds INTEGER NOT NULL DEFAULT 10,
+    last_sent_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Firing and recently resolved alerts, keyed by a hash of their labels so
+-- starts_at stays stable across evaluations and restarts.
+CREATE TABLE IF NOT EXISTS alerts (
+    fingerprint VARCHAR(64) PRIMARY KEY,
+    alertname VARCHAR(100) NOT NULL,
+    severity VARCHAR(20) NOT NULL,
+    labels JSONB NOT NULL,
+    annotations JSONB NOT NULL DEFAULT '{}',
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP,
+    last_sent_at TIMESTAMP,
+    resolved_sent BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_open ON alerts(ends_at) WHERE ends_at IS NULL OR NOT resolved_sent;
@@ -0,0 +1,22 @@
+-- Certificate expiry thresholds. The row without group_id and certificate_id is
+-- the global default; a group row applies to certificates of servers in that
+-- group and a certificate row overrides both.
+CREATE TABLE IF NOT EXISTS alert_policies (
+    id VARCHAR(36) PRIMARY KEY,
+    group_id VARCHAR(36) UNIQUE REFERENCES server_groups(id) ON DELETE CASCADE,
+    certificate_id VARCHAR(36) UNIQUE REFERENCES ssl_certificates(id) ON DELETE CASCADE,
+    warning_days INTEGER NOT NULL,
+    critical_days INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (group_id IS NULL OR certificate_id IS NULL),
+    CHECK (critical_days >= 0 AND warning_days >= critical_days)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alert_policies_global
+    ON alert_policies ((group_id IS NULL AND certificate_id IS NULL))
+    WHERE group_id IS NULL AND certificate_id IS NULL;
+
+INSERT INTO alert_policies (id, warning_days, critical_days)
+SELECT 'global', 30, 7
+WHERE NOT EXISTS (SELECT 1 FROM alert_policies WHERE group_id IS NULL AND certificate_id IS NULL);
@@ -0,0 +1,43 @@
+-- Notification channels: smtp, webhook, slack or teams. settings holds the
+-- non-secret configuration; encrypted_secrets is a JSON object sealed with
+-- SECRETS_MASTER_KEY (SMTP password, webhook signing secret, incoming webhook URL).
+CREATE TABLE IF NOT EXISTS notification_channels (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    type VARCHAR(20) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    settings JSONB NOT NULL DEFAULT '{}',
+    encrypted_secrets BYTEA,
+    -- Routing: an empty list matches everything.
+    severities TEXT[] NOT NULL DEFAULT '{}',
+    group_ids TEXT[] NOT NULL DEFAULT '{}',
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    send_resolved BOOLEAN NOT NULL DEFAULT TRUE,
+    title_template TEXT,
+    body_template TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One row per notification sent or to be sent; doubles as the delivery log.
+-- The unique key makes enqueueing idempotent across evaluations.
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    id VARCHAR(36) PRIMARY KEY,
+    channel_id VARCHAR(36) NOT NULL REFERENCES notification_channels(id) ON DELETE CASCADE,
+    alert_fingerprint VARCHAR(64) NOT NULL,
+    alert_starts_at TIMESTAMP NOT NULL,
+    alertname VARCHAR(100) NOT NULL,
+    severity VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    alert JSONB NOT NULL,
+    state VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (channel_id, alert_fingerprint, status, alert_starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_due ON notification_deliveries(next_attempt_at) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_created_at ON notification_deliveries(created_at DESC);
@@ -0,0 +1,21 @@
+-- SSH credentials. encrypted_secret holds the private key, its passphrase or a
+-- password, sealed with the master key; it is never returned by the API.
+CREATE TABLE IF NOT EXISTS credentials (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT,
+    kind VARCHAR(20) NOT NULL,
+    username VARCHAR(255),
+    public_key TEXT,
+    fingerprint VARCHAR(100),
+    has_passphrase BOOLEAN NOT NULL DEFAULT FALSE,
+    encrypted_secret BYTEA NOT NULL,
+    created_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A server uses its own credential, else its group's.
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS credential_id VARCHAR(36) REFERENCES credentials(id) ON DELETE SET NULL;
+ALTER TABLE server_groups ADD COLUMN IF NOT EXISTS credential_id VARCHAR(36) REFERENCES credentials(id) ON DELETE SET NULL;
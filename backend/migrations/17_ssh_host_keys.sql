@@ -0,0 +1,39 @@
+-- Audit log of security relevant events. actor_id is NULL for events raised
+-- by the backend itself.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(100) NOT NULL,
+    target_type VARCHAR(50),
+    target_id VARCHAR(255),
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events(target_type, target_id);
+
+-- Known SSH host keys per server. status is trusted, pending (seen but not
+-- accepted) or rejected; changed marks a pending key presented instead of a
+-- trusted one, which is raised as an alert until an admin decides.
+CREATE TABLE IF NOT EXISTS ssh_host_keys (
+    id VARCHAR(36) PRIMARY KEY,
+    server_id VARCHAR(36) NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+    key_type VARCHAR(100) NOT NULL,
+    public_key TEXT NOT NULL,
+    fingerprint VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    changed BOOLEAN NOT NULL DEFAULT FALSE,
+    first_seen_at TIMESTAMP,
+    last_seen_at TIMESTAMP,
+    decided_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    decided_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (server_id, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_host_keys_status ON ssh_host_keys(status);
+
+-- NULL uses SSH_HOST_KEY_MODE.
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS host_key_mode VARCHAR(20);
@@ -0,0 +1,26 @@
+-- Recorded web terminal sessions. The asciicast v2 file lives in the
+-- recording storage backend under storage_key.
+CREATE TABLE IF NOT EXISTS ssh_recordings (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    server_id VARCHAR(36) REFERENCES servers(id) ON DELETE SET NULL,
+    -- Copied so recordings stay identifiable after the user or server is deleted.
+    user_email VARCHAR(255) NOT NULL,
+    server_hostname VARCHAR(255) NOT NULL,
+    storage VARCHAR(20) NOT NULL,
+    storage_key TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'recording',
+    cols INTEGER NOT NULL,
+    rows INTEGER NOT NULL,
+    input_recorded BOOLEAN NOT NULL DEFAULT FALSE,
+    remote_addr VARCHAR(255),
+    exit_code INTEGER,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_recordings_started_at ON ssh_recordings(started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_ssh_recordings_user ON ssh_recordings(user_id, started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_ssh_recordings_server ON ssh_recordings(server_id, started_at DESC);
@@ -0,0 +1,15 @@
+-- Jump host chains. A server or group lists, in dialing order, the servers
+-- SSH connections are tunneled through; a server's own chain replaces its
+-- group's. Servers used as jump hosts cannot be deleted while referenced.
+CREATE TABLE IF NOT EXISTS ssh_jump_hosts (
+    id BIGSERIAL PRIMARY KEY,
+    server_id VARCHAR(36) REFERENCES servers(id) ON DELETE CASCADE,
+    group_id VARCHAR(36) REFERENCES server_groups(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    jump_server_id VARCHAR(36) NOT NULL REFERENCES servers(id) ON DELETE RESTRICT,
+    CHECK ((server_id IS NULL) <> (group_id IS NULL)),
+    UNIQUE (server_id, position),
+    UNIQUE (group_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_ssh_jump_hosts_jump_server ON ssh_jump_hosts(jump_server_id);
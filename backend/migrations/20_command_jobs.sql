@@ -0,0 +1,36 @@
+-- Ad-hoc commands run over SSH on a set of servers. selector keeps the
+-- server_ids, group_ids and tags the job was started with; the servers they
+-- resolved to are the job's hosts.
+CREATE TABLE IF NOT EXISTS command_jobs (
+    id VARCHAR(36) PRIMARY KEY,
+    command TEXT NOT NULL,
+    selector JSONB NOT NULL DEFAULT '{}',
+    created_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL,
+    concurrency INTEGER NOT NULL,
+    timeout_seconds INTEGER NOT NULL,
+    total_hosts INTEGER NOT NULL DEFAULT 0,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_jobs_created_at ON command_jobs(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_command_jobs_created_by ON command_jobs(created_by, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS command_job_hosts (
+    id VARCHAR(36) PRIMARY KEY,
+    job_id VARCHAR(36) NOT NULL REFERENCES command_jobs(id) ON DELETE CASCADE,
+    server_id VARCHAR(36) REFERENCES servers(id) ON DELETE SET NULL,
+    hostname VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    exit_code INTEGER,
+    output TEXT NOT NULL DEFAULT '',
+    output_truncated BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_job_hosts_job ON command_job_hosts(job_id);
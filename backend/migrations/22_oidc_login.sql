@@ -0,0 +1,34 @@
+-- Users signed in through an external identity provider. external_id is the
+-- provider's stable identifier (the OIDC subject); their password is unusable.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_provider VARCHAR(20) NOT NULL DEFAULT 'local';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_external_id ON users(auth_provider, external_id) WHERE external_id IS NOT NULL;
+
+-- Permissions granted from identity provider groups are replaced on every
+-- login; manual grants are left alone.
+ALTER TABLE user_server_permissions ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'manual';
+
+-- Maps a group of an identity provider to a role, a server or every server
+-- of a server group.
+CREATE TABLE IF NOT EXISTS auth_group_mappings (
+    id VARCHAR(36) PRIMARY KEY,
+    provider VARCHAR(20) NOT NULL,
+    external_group VARCHAR(255) NOT NULL,
+    role app_role,
+    server_id VARCHAR(36) REFERENCES servers(id) ON DELETE CASCADE,
+    server_group_id VARCHAR(36) REFERENCES server_groups(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (role IS NOT NULL OR server_id IS NOT NULL OR server_group_id IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_group_mappings_provider ON auth_group_mappings(provider, external_group);
+
+-- Pending authorization code logins: the PKCE verifier and nonce for a state
+-- value, whose hash is the key. Each is used at most once.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash VARCHAR(64) PRIMARY KEY,
+    nonce VARCHAR(255) NOT NULL,
+    code_verifier VARCHAR(255) NOT NULL,
+    redirect_to TEXT,
+    expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,33 @@
+-- TOTP second factor. The secret is sealed with the secrets master key;
+-- enabled_at stays NULL until the user confirms enrollment with a code.
+-- last_used_step stops a code from being used twice, and repeated wrong
+-- codes lock verification until locked_until.
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id VARCHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    encrypted_secret BYTEA NOT NULL,
+    enabled_at TIMESTAMP,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One-time recovery codes; only their SHA-256 hashes are stored.
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
+
+-- Members of these roles must use a second factor to log in.
+CREATE TABLE IF NOT EXISTS mfa_required_roles (
+    role app_role PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- When the session last proved a second factor, for step-up checks before
+-- sensitive operations.
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS step_up_at TIMESTAMP;
@@ -0,0 +1,35 @@
+-- WebAuthn credentials (security keys and passkeys). A user may register
+-- several; credential_id is the authenticator's id for the key pair and
+-- public_key its COSE-encoded public key.
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    credential_id BYTEA NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,
+    attestation_type VARCHAR(64) NOT NULL DEFAULT '',
+    transports TEXT[] NOT NULL DEFAULT '{}',
+    aaguid BYTEA,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webauthn_credentials_user ON webauthn_credentials(user_id);
+
+-- Pending registration and assertion ceremonies: the challenge and options
+-- the browser was sent, keyed by the hash of the ceremony id. Each is used
+-- at most once.
+CREATE TABLE IF NOT EXISTS webauthn_ceremonies (
+    id_hash VARCHAR(64) PRIMARY KEY,
+    purpose VARCHAR(32) NOT NULL,
+    user_id VARCHAR(36) REFERENCES users(id) ON DELETE CASCADE,
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- When the session last made a WebAuthn assertion, for operations that
+-- require a fresh one.
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS webauthn_at TIMESTAMP;
@@ -0,0 +1,24 @@
+-- Login and sign-up attempts, counted per client address (scope ip) and
+-- per login name (scope account) when throttling is shared between
+-- replicas with AUTH_THROTTLE_STORE=postgres. kind is attempt, failure or
+-- signup.
+CREATE TABLE IF NOT EXISTS auth_attempts (
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(320) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    attempted_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_attempts_subject ON auth_attempts(scope, subject, kind, attempted_at);
+CREATE INDEX IF NOT EXISTS idx_auth_attempts_attempted_at ON auth_attempts(attempted_at);
+
+-- Accounts and addresses locked out after too many failed logins, until
+-- locked_until or an admin unlocks them.
+CREATE TABLE IF NOT EXISTS auth_lockouts (
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(320) NOT NULL,
+    failures INTEGER NOT NULL,
+    locked_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NOT NULL,
+    PRIMARY KEY (scope, subject)
+);
@@ -0,0 +1,30 @@
+-- Invitations to sign up, with the roles and server permissions the new
+-- user gets. The emailed token is signed and names the invite's id; an
+-- invite is accepted at most once.
+CREATE TABLE IF NOT EXISTS user_invites (
+    id VARCHAR(36) PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    roles TEXT[] NOT NULL DEFAULT '{}',
+    server_ids TEXT[] NOT NULL DEFAULT '{}',
+    invited_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    accepted_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_invites_email ON user_invites(email);
+
+-- Password resets requested by or for a user. Requesting a new one
+-- discards the user's unused ones.
+CREATE TABLE IF NOT EXISTS password_resets (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    requested_by VARCHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user ON password_resets(user_id);
@@ -0,0 +1,22 @@
+-- updated_at means "last edited". The prober writes its results to servers
+-- and server_ports every interval; changes to only those columns leave
+-- updated_at alone. The trigger arguments name the columns to ignore.
+CREATE OR REPLACE FUNCTION update_updated_at_column_except()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (to_jsonb(NEW) - TG_ARGV - 'updated_at') IS DISTINCT FROM (to_jsonb(OLD) - TG_ARGV - 'updated_at') THEN
+        NEW.updated_at = CURRENT_TIMESTAMP;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_servers_updated_at ON servers;
+CREATE TRIGGER update_servers_updated_at BEFORE UPDATE ON servers
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column_except(
+        'status', 'latency_ms', 'last_checked_at', 'last_seen_at');
+
+DROP TRIGGER IF EXISTS update_server_ports_updated_at ON server_ports;
+CREATE TRIGGER update_server_ports_updated_at BEFORE UPDATE ON server_ports
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column_except(
+        'last_state', 'latency_ms', 'last_error', 'last_checked_at', 'drift', 'drift_since');
@@ -12,6 +12,9 @@ const queryClient = new QueryClient();
 
 import { AuthProvider } from "./lib/auth";
 import Auth from "./pages/Auth";
+import AuthCallback from "./pages/AuthCallback";
+import AcceptInvite from "./pages/AcceptInvite";
+import ResetPassword from "./pages/ResetPassword";
 import AdminUsers from "./pages/AdminUsers";
 import AdminGroups from "./pages/AdminGroups";
 import AdminArea from "./pages/AdminArea";
@@ -24,6 +27,9 @@ const App = () => (
         <AuthProvider>
           <Routes>
             <Route path="/auth" element={<Auth />} />
+            <Route path="/auth/callback" element={<AuthCallback />} />
+            <Route path="/auth/invite" element={<AcceptInvite />} />
+            <Route path="/auth/reset-password" element={<ResetPassword />} />
             <Route path="/" element={<Index />} />
             <Route path="/server/:id" element={<ServerDetails />} />
             <Route path="/server/:id/ssh" element={<SSHTerminal />} />
@@ -12,6 +12,8 @@ interface SSLCertificateCardProps {
     expires_at: string;
     status: string;
     auto_renew: boolean;
+    // Computed by the backend from the certificate's alert policy
+    severity?: "ok" | "warning" | "critical" | "expired";
     server: {
       hostname: string;
       ip_address: string;
@@ -19,12 +21,14 @@ interface SSLCertificateCardProps {
   };
 }
 
-const getExpirationStatus = (expiresAt: string) => {
+const getExpirationStatus = (expiresAt: string, severity?: string) => {
   const now = new Date();
   const expiry = new Date(expiresAt);
   const daysUntilExpiry = Math.ceil((expiry.getTime() - now.getTime()) / (1000 * 60 * 60 * 24));
+  const isCritical = severity ? severity === "critical" : daysUntilExpiry <= 7;
+  const isWarning = severity ? severity === "warning" : daysUntilExpiry <= 30;
 
-  if (daysUntilExpiry < 0) {
+  if (severity === "expired" || daysUntilExpiry < 0) {
     return {
       status: "expired",
       color: "bg-destructive/10 border-destructive text-destructive",
@@ -33,7 +37,7 @@ const getExpirationStatus = (expiresAt: string) => {
       badgeVariant: "destructive" as const,
       days: daysUntilExpiry,
     };
-  } else if (daysUntilExpiry <= 7) {
+  } else if (isCritical) {
     return {
       status: "critical",
       color: "bg-destructive/10 border-destructive/50 text-destructive",
@@ -42,7 +46,7 @@ const getExpirationStatus = (expiresAt: string) => {
       badgeVariant: "destructive" as const,
       days: daysUntilExpiry,
     };
-  } else if (daysUntilExpiry <= 30) {
+  } else if (isWarning) {
     return {
       status: "warning",
       color: "bg-warning/10 border-warning/50 text-warning",
@@ -73,7 +77,7 @@ const getExpirationStatus = (expiresAt: string) => {
 };
 
 export const SSLCertificateCard = ({ certificate }: SSLCertificateCardProps) => {
-  const expirationInfo = getExpirationStatus(certificate.expires_at);
+  const expirationInfo = getExpirationStatus(certificate.expires_at, certificate.severity);
   const Icon = expirationInfo.icon;
 
   return (
@@ -4,6 +4,9 @@ import { Card, CardContent, CardHeader, CardTitle } from "@/components/ui/card";
 import { Table, TableBody, TableCell, TableHead, TableHeader, TableRow } from "@/components/ui/table";
 import { Badge } from "@/components/ui/badge";
 import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Checkbox } from "@/components/ui/checkbox";
+import { Label } from "@/components/ui/label";
 import { Users } from "lucide-react";
 import { toast } from "sonner";
 import { getSafeErrorMessage } from "@/lib/errorUtils";
@@ -16,14 +19,68 @@ interface UserWithRole {
   approved: boolean;
 }
 
+interface Invite {
+  id: string;
+  email: string;
+  roles: string[];
+  expires_at: string;
+  accepted_at: string | null;
+  revoked_at: string | null;
+}
+
 export const AdminUsersTab = () => {
   const [users, setUsers] = useState<UserWithRole[]>([]);
+  const [invites, setInvites] = useState<Invite[]>([]);
   const [isLoading, setIsLoading] = useState(true);
+  const [inviteEmail, setInviteEmail] = useState("");
+  const [inviteAdmin, setInviteAdmin] = useState(false);
 
   useEffect(() => {
     fetchUsers();
+    fetchInvites();
   }, []);
 
+  const fetchInvites = async () => {
+    try {
+      const data = await apiClient.getInvites();
+      const now = Date.now();
+      setInvites((Array.isArray(data) ? data : []).filter(
+        (inv: Invite) => !inv.accepted_at && !inv.revoked_at && new Date(inv.expires_at).getTime() > now
+      ));
+    } catch (error: any) {
+      toast.error(getSafeErrorMessage(error));
+    }
+  };
+
+  // The link is shown as well in case the email does not arrive
+  const createInvite = async (e: React.FormEvent) => {
+    e.preventDefault();
+    try {
+      const roles = inviteAdmin ? ["user", "admin"] : ["user"];
+      const { invite_url, email_sent } = await apiClient.createInvite(inviteEmail.trim(), roles, []);
+      if (email_sent) {
+        toast.success(`Invitation sent to ${inviteEmail.trim()}`);
+      } else {
+        toast.warning(`The email could not be sent. Share this link instead: ${invite_url}`, { duration: 30000 });
+      }
+      setInviteEmail("");
+      setInviteAdmin(false);
+      fetchInvites();
+    } catch (error: any) {
+      toast.error(getSafeErrorMessage(error));
+    }
+  };
+
+  const revokeInvite = async (id: string) => {
+    try {
+      await apiClient.revokeInvite(id);
+      toast.success("Invitation revoked");
+      fetchInvites();
+    } catch (error: any) {
+      toast.error(getSafeErrorMessage(error));
+    }
+  };
+
   const fetchUsers = async () => {
     try {
       const rawData = await apiClient.getUsers();
@@ -67,6 +124,25 @@ export const AdminUsersTab = () => {
     }
   };
 
+  // Lifts a lockout after too many failed logins
+  const unlockUser = async (userId: string) => {
+    try {
+      await apiClient.unlockUser(userId);
+      toast.success("User unlocked");
+    } catch (error: any) {
+      toast.error(getSafeErrorMessage(error));
+    }
+  };
+
+  const sendPasswordReset = async (userId: string) => {
+    try {
+      await apiClient.sendPasswordReset(userId);
+      toast.success("Password reset email sent");
+    } catch (error: any) {
+      toast.error(getSafeErrorMessage(error));
+    }
+  };
+
   return (
     <Card className="border-border">
       <CardHeader>
@@ -75,7 +151,54 @@ export const AdminUsersTab = () => {
           System Users
         </CardTitle>
       </CardHeader>
-      <CardContent>
+      <CardContent className="space-y-6">
+        <form onSubmit={createInvite} className="flex flex-wrap items-center gap-3">
+          <Input
+            type="email"
+            placeholder="colleague@example.com"
+            value={inviteEmail}
+            onChange={(e) => setInviteEmail(e.target.value)}
+            className="max-w-xs"
+            required
+          />
+          <div className="flex items-center gap-2">
+            <Checkbox id="invite-admin" checked={inviteAdmin} onCheckedChange={(checked) => setInviteAdmin(checked === true)} />
+            <Label htmlFor="invite-admin">Admin</Label>
+          </div>
+          <Button type="submit" size="sm">
+            Invite
+          </Button>
+        </form>
+        {invites.length > 0 && (
+          <Table>
+            <TableHeader>
+              <TableRow>
+                <TableHead>Invited</TableHead>
+                <TableHead>Role</TableHead>
+                <TableHead>Expires</TableHead>
+                <TableHead>Actions</TableHead>
+              </TableRow>
+            </TableHeader>
+            <TableBody>
+              {invites.map((inv) => (
+                <TableRow key={inv.id}>
+                  <TableCell className="font-mono">{inv.email}</TableCell>
+                  <TableCell>
+                    <Badge variant={inv.roles.includes("admin") ? "default" : "secondary"}>
+                      {inv.roles.includes("admin") ? "Admin" : "User"}
+                    </Badge>
+                  </TableCell>
+                  <TableCell>{new Date(inv.expires_at).toLocaleString()}</TableCell>
+                  <TableCell>
+                    <Button size="sm" variant="destructive" onClick={() => revokeInvite(inv.id)}>
+                      Revoke
+                    </Button>
+                  </TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        )}
         {isLoading ? (
           <p className="text-muted-foreground">Loading users...</p>
         ) : (
@@ -125,6 +248,12 @@ export const AdminUsersTab = () => {
                       >
                         {user.roles.includes("admin") ? "Remove Admin" : "Make Admin"}
                       </Button>
+                      <Button size="sm" variant="outline" onClick={() => unlockUser(user.id)}>
+                        Unlock
+                      </Button>
+                      <Button size="sm" variant="outline" onClick={() => sendPasswordReset(user.id)}>
+                        Reset Password
+                      </Button>
                     </div>
                   </TableCell>
                 </TableRow>
@@ -1,22 +1,71 @@
+import { getAssertion } from '@/lib/webauthn';
+
 const API_BASE_URL = (import.meta.env.VITE_API_URL as string | undefined) || '/api';
 
+// Single sign-on is offered when the backend has OIDC_ENABLED set
+export const oidcEnabled = import.meta.env.VITE_OIDC_ENABLED === 'true';
+export const oidcLoginUrl = `${API_BASE_URL}/auth/oidc/login`;
+
+// Security keys and passkeys are offered when the backend has
+// WEBAUTHN_ENABLED set
+export const webauthnEnabled = import.meta.env.VITE_WEBAUTHN_ENABLED === 'true';
+
 class APIClient {
   private token: string | null = null;
+  private refreshToken: string | null = null;
+  private refreshing: Promise<boolean> | null = null;
 
   constructor() {
-    // Load token from localStorage
+    // Load tokens from localStorage
     this.token = localStorage.getItem('auth_token');
+    this.refreshToken = localStorage.getItem('refresh_token');
   }
 
-  setToken(token: string | null) {
+  setToken(token: string | null, refreshToken?: string | null) {
     this.token = token;
     if (token) {
       localStorage.setItem('auth_token', token);
     } else {
       localStorage.removeItem('auth_token');
+      refreshToken = null;
+    }
+    if (refreshToken !== undefined) {
+      this.refreshToken = refreshToken;
+      if (refreshToken) {
+        localStorage.setItem('refresh_token', refreshToken);
+      } else {
+        localStorage.removeItem('refresh_token');
+      }
     }
   }
 
+  // Access tokens are short-lived; exchange the refresh token for new ones.
+  // Concurrent requests that hit an expired token share one refresh.
+  private refreshSession(): Promise<boolean> {
+    if (!this.refreshing) {
+      const refreshToken = this.refreshToken;
+      this.refreshing = fetch(`${API_BASE_URL}/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      })
+        .then(async (response) => {
+          if (!response.ok) {
+            this.setToken(null);
+            return false;
+          }
+          const data = await response.json();
+          this.setToken(data.token, data.refresh_token);
+          return true;
+        })
+        .catch(() => false)
+        .finally(() => {
+          this.refreshing = null;
+        });
+    }
+    return this.refreshing;
+  }
+
   // Preview storage helpers (used only in Lovable preview mode)
   private previewGet(key: string): any[] {
     try {
@@ -87,7 +136,8 @@ class APIClient {
 
   private async request<T>(
     endpoint: string,
-    options: RequestInit = {}
+    options: RequestInit = {},
+    retry = true
   ): Promise<T> {
     // Preview fallback: mock auth endpoints when backend is unavailable in Lovable preview
     const host = typeof window !== 'undefined' ? window.location.hostname : '';
@@ -161,6 +211,23 @@ class APIClient {
       headers,
     });
 
+    if (response.status === 401 && retry && this.refreshToken && !endpoint.startsWith('/auth/')) {
+      if (await this.refreshSession()) {
+        return this.request<T>(endpoint, options, false);
+      }
+    }
+
+    // Privileged operations may ask for a fresh security key assertion;
+    // prompt for it once and repeat the request. Adding a second factor
+    // asks for any existing one, which a security key also satisfies
+    if (response.status === 403 && retry && webauthnEnabled) {
+      const error = await response.clone().json().catch(() => null);
+      const wantsKey = error?.step_up === 'webauthn' || (error?.step_up === 'mfa' && error?.methods?.includes('webauthn'));
+      if (wantsKey && (await this.webauthnStepUp())) {
+        return this.request<T>(endpoint, options, false);
+      }
+    }
+
     if (!response.ok) {
       const error = await response.json().catch(() => ({ error: 'An error occurred' }));
       throw new Error(error.error || `HTTP error! status: ${response.status}`);
@@ -170,20 +237,90 @@ class APIClient {
   }
 
   // Auth
+  // Depending on the sign-up policy the new user may have to wait for an
+  // admin's approval, in which case no session is returned
   async signUp(email: string, password: string, username?: string) {
-    return this.request<{ user: any; token: string }>('/auth/signup', {
+    return this.request<{ user: any; token?: string; refresh_token?: string; pending_approval?: boolean }>('/auth/signup', {
       method: 'POST',
       body: JSON.stringify({ email, password, username }),
     });
   }
 
+  // Invitations and password resets, opened from emailed links
+  async getInvite(token: string) {
+    return this.request<{ email: string; roles: string[]; expires_at: string }>(`/auth/invite?token=${encodeURIComponent(token)}`);
+  }
+
+  // An invited user whose role requires MFA gets a challenge instead of a
+  // session and enrolls at their first login
+  async acceptInvite(token: string, password: string, username?: string) {
+    return this.request<{ user: any; token?: string; refresh_token?: string; mfa_required?: boolean }>('/auth/signup', {
+      method: 'POST',
+      body: JSON.stringify({ invite_token: token, password, username }),
+    });
+  }
+
+  async forgotPassword(email: string) {
+    return this.request<{ message: string }>('/auth/password/forgot', {
+      method: 'POST',
+      body: JSON.stringify({ email }),
+    });
+  }
+
+  async resetPassword(token: string, password: string) {
+    return this.request<{ message: string }>('/auth/password/reset', {
+      method: 'POST',
+      body: JSON.stringify({ token, password }),
+    });
+  }
+
+  async changePassword(currentPassword: string, newPassword: string) {
+    return this.request<{ message: string; sessions_revoked: number }>('/auth/password', {
+      method: 'PUT',
+      body: JSON.stringify({ current_password: currentPassword, new_password: newPassword }),
+    });
+  }
+
+  // Users with MFA get a challenge token instead of a session, to complete
+  // with verifyMfa, or with the enrollment calls if they have no second
+  // factor yet but their role requires one
   async login(email: string, password: string) {
-    return this.request<{ user: any; token: string; roles: string[] }>('/auth/login', {
+    return this.request<{
+      user: any;
+      token: string;
+      refresh_token: string;
+      roles: string[];
+      mfa_required?: boolean;
+      mfa_token?: string;
+      mfa_enrolled?: boolean;
+      methods?: string[];
+    }>('/auth/login', {
       method: 'POST',
       body: JSON.stringify({ email, password }),
     });
   }
 
+  async verifyMfa(mfaToken: string, code: string) {
+    return this.request<{ user: any; token: string; refresh_token: string; roles: string[] }>('/auth/mfa/verify', {
+      method: 'POST',
+      body: JSON.stringify({ mfa_token: mfaToken, code }),
+    });
+  }
+
+  async startMfaEnrollment(mfaToken: string) {
+    return this.request<{ secret: string; otpauth_uri: string }>('/auth/mfa/enroll', {
+      method: 'POST',
+      body: JSON.stringify({ mfa_token: mfaToken }),
+    });
+  }
+
+  async confirmMfaEnrollment(mfaToken: string, code: string) {
+    return this.request<{ user: any; token: string; refresh_token: string; recovery_codes: string[] }>('/auth/mfa/enroll/confirm', {
+      method: 'POST',
+      body: JSON.stringify({ mfa_token: mfaToken, code }),
+    });
+  }
+
   async getCurrentUser() {
     return this.request<{ user: any; roles: string[] }>('/auth/me');
   }
@@ -192,6 +329,146 @@ class APIClient {
     return this.request('/auth/logout', { method: 'POST' });
   }
 
+  async logoutAll() {
+    return this.request('/auth/logout-all', { method: 'POST' });
+  }
+
+  // WebAuthn
+  async beginWebAuthnLogin(mfaToken?: string) {
+    return this.request<{ ceremony_id: string; options: any }>('/auth/webauthn/login/begin', {
+      method: 'POST',
+      body: JSON.stringify(mfaToken ? { mfa_token: mfaToken } : {}),
+    });
+  }
+
+  async finishWebAuthnLogin(ceremonyId: string, credential: any) {
+    return this.request<{ user: any; token: string; refresh_token: string; roles: string[] }>('/auth/webauthn/login/finish', {
+      method: 'POST',
+      body: JSON.stringify({ ceremony_id: ceremonyId, credential }),
+    });
+  }
+
+  async getWebAuthnCredentials() {
+    return this.request<any[]>('/auth/webauthn/credentials');
+  }
+
+  async deleteWebAuthnCredential(id: string) {
+    return this.request(`/auth/webauthn/credentials/${id}`, { method: 'DELETE' });
+  }
+
+  async beginWebAuthnRegistration() {
+    return this.request<{ ceremony_id: string; options: any }>('/auth/webauthn/register/begin', { method: 'POST' });
+  }
+
+  async finishWebAuthnRegistration(ceremonyId: string, credential: any, name?: string) {
+    return this.request('/auth/webauthn/register/finish', {
+      method: 'POST',
+      body: JSON.stringify({ ceremony_id: ceremonyId, credential, name }),
+    });
+  }
+
+  private async webauthnStepUp(): Promise<boolean> {
+    try {
+      const { ceremony_id, options } = await this.request<{ ceremony_id: string; options: any }>(
+        '/auth/webauthn/step-up/begin',
+        { method: 'POST' }
+      );
+      const credential = await getAssertion(options);
+      await this.request('/auth/webauthn/step-up/finish', {
+        method: 'POST',
+        body: JSON.stringify({ ceremony_id, credential }),
+      });
+      return true;
+    } catch {
+      return false;
+    }
+  }
+
+  // Multi-factor authentication
+  async getMfaStatus() {
+    return this.request<any>('/auth/mfa');
+  }
+
+  async enrollTotp() {
+    return this.request<{ secret: string; otpauth_uri: string }>('/auth/mfa/totp', { method: 'POST' });
+  }
+
+  async confirmTotp(code: string) {
+    return this.request<{ recovery_codes: string[] }>('/auth/mfa/totp/confirm', {
+      method: 'POST',
+      body: JSON.stringify({ code }),
+    });
+  }
+
+  async disableTotp(code: string) {
+    return this.request('/auth/mfa/totp', {
+      method: 'DELETE',
+      body: JSON.stringify({ code }),
+    });
+  }
+
+  async regenerateRecoveryCodes(code: string) {
+    return this.request<{ recovery_codes: string[] }>('/auth/mfa/recovery-codes', {
+      method: 'POST',
+      body: JSON.stringify({ code }),
+    });
+  }
+
+  async stepUpMfa(code: string) {
+    return this.request<{ step_up_until: string }>('/auth/mfa/step-up', {
+      method: 'POST',
+      body: JSON.stringify({ code }),
+    });
+  }
+
+  async getMfaPolicy() {
+    return this.request<{ required_roles: string[] }>('/auth/mfa/policy');
+  }
+
+  async updateMfaPolicy(requiredRoles: string[]) {
+    return this.request('/auth/mfa/policy', {
+      method: 'PUT',
+      body: JSON.stringify({ required_roles: requiredRoles }),
+    });
+  }
+
+  async resetUserMfa(id: string) {
+    return this.request(`/users/${id}/mfa`, { method: 'DELETE' });
+  }
+
+  // Lockouts after too many failed logins
+  async getLockouts() {
+    return this.request<any[]>('/auth/lockouts');
+  }
+
+  async deleteLockout(scope: 'account' | 'ip', subject: string) {
+    return this.request(`/auth/lockouts/${scope}/${encodeURIComponent(subject)}`, { method: 'DELETE' });
+  }
+
+  async unlockUser(id: string) {
+    return this.request(`/users/${id}/unlock`, { method: 'POST' });
+  }
+
+  async sendPasswordReset(id: string) {
+    return this.request<{ message: string }>(`/users/${id}/password-reset`, { method: 'POST' });
+  }
+
+  // Invites
+  async getInvites() {
+    return this.request<any[]>('/invites');
+  }
+
+  async createInvite(email: string, roles: string[], serverIds: string[]) {
+    return this.request<{ id: string; invite_url: string; email_sent: boolean }>('/invites', {
+      method: 'POST',
+      body: JSON.stringify({ email, roles, server_ids: serverIds }),
+    });
+  }
+
+  async revokeInvite(id: string) {
+    return this.request(`/invites/${id}`, { method: 'DELETE' });
+  }
+
   // Users
   async getUsers() {
     return this.request<any[]>('/users');
@@ -1,6 +1,7 @@
 import { createContext, useContext, useEffect, useState } from "react";
 import { useNavigate } from "react-router-dom";
 import { apiClient } from "@/lib/api";
+import { getAssertion } from "@/lib/webauthn";
 
 interface User {
   id: string;
@@ -10,14 +11,27 @@ interface User {
   approved: boolean;
 }
 
+// A login that still needs a second factor. enrolled is false when the
+// user's role requires MFA and they have to set it up first.
+export interface MfaChallenge {
+  token: string;
+  enrolled: boolean;
+  methods: string[];
+}
+
 interface AuthContextType {
   user: User | null;
   session: string | null;
   isAdmin: boolean;
   isApproved: boolean;
   isLoading: boolean;
-  signIn: (email: string, password: string) => Promise<{ error: any }>;
-  signUp: (email: string, password: string, username: string) => Promise<{ error: any }>;
+  signIn: (email: string, password: string) => Promise<{ error: any; mfa?: MfaChallenge }>;
+  verifyMfa: (mfaToken: string, code: string) => Promise<{ error: any }>;
+  signInWithWebAuthn: (mfaToken?: string) => Promise<{ error: any }>;
+  confirmMfaEnrollment: (mfaToken: string, code: string) => Promise<{ error: any; recoveryCodes?: string[] }>;
+  finishSignIn: () => Promise<void>;
+  signUp: (email: string, password: string, username: string) => Promise<{ error: any; pending?: boolean }>;
+  acceptInvite: (token: string, password: string, username: string) => Promise<{ error: any; mfa?: boolean }>;
   signOut: () => Promise<void>;
 }
 
@@ -61,25 +75,78 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
     }
   };
 
+  // Fetch authoritative user + roles from backend using the stored token
+  const finishSignIn = async () => {
+    await fetchCurrentUser();
+    navigate("/");
+  };
+
   const signIn = async (email: string, password: string) => {
     try {
       const data = await apiClient.login(email, password);
+      if (data.mfa_required && data.mfa_token) {
+        return {
+          error: null,
+          mfa: { token: data.mfa_token, enrolled: data.mfa_enrolled ?? false, methods: data.methods ?? [] },
+        };
+      }
       // Persist token first
-      apiClient.setToken(data.token);
+      apiClient.setToken(data.token, data.refresh_token);
       setSession(data.token);
-      // Fetch authoritative user + roles from backend using the stored token
-      await fetchCurrentUser();
-      navigate("/");
+      await finishSignIn();
+      return { error: null };
+    } catch (error: any) {
+      return { error: { message: error.message } };
+    }
+  };
+
+  const verifyMfa = async (mfaToken: string, code: string) => {
+    try {
+      const data = await apiClient.verifyMfa(mfaToken, code);
+      apiClient.setToken(data.token, data.refresh_token);
+      setSession(data.token);
+      await finishSignIn();
       return { error: null };
     } catch (error: any) {
       return { error: { message: error.message } };
     }
   };
 
+  // Without an MFA token this is a passkey login, with the user picked by
+  // the authenticator
+  const signInWithWebAuthn = async (mfaToken?: string) => {
+    try {
+      const { ceremony_id, options } = await apiClient.beginWebAuthnLogin(mfaToken);
+      const credential = await getAssertion(options);
+      const data = await apiClient.finishWebAuthnLogin(ceremony_id, credential);
+      apiClient.setToken(data.token, data.refresh_token);
+      setSession(data.token);
+      await finishSignIn();
+      return { error: null };
+    } catch (error: any) {
+      return { error: { message: error.message } };
+    }
+  };
+
+  // The session is stored but not entered, so that the recovery codes can be
+  // shown until the user calls finishSignIn
+  const confirmMfaEnrollment = async (mfaToken: string, code: string) => {
+    try {
+      const data = await apiClient.confirmMfaEnrollment(mfaToken, code);
+      apiClient.setToken(data.token, data.refresh_token);
+      return { error: null, recoveryCodes: data.recovery_codes };
+    } catch (error: any) {
+      return { error: { message: error.message } };
+    }
+  };
+
   const signUp = async (email: string, password: string, username: string) => {
     try {
       const data = await apiClient.signUp(email, password, username);
-      apiClient.setToken(data.token);
+      if (data.pending_approval || !data.token) {
+        return { error: null, pending: true };
+      }
+      apiClient.setToken(data.token, data.refresh_token);
       setUser(data.user);
       setSession(data.token);
       setIsAdmin(false);
@@ -91,6 +158,23 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
     }
   };
 
+  // Invited users are approved, so they are signed in right away unless
+  // they still have to set up MFA, which happens at their first login
+  const acceptInvite = async (token: string, password: string, username: string) => {
+    try {
+      const data = await apiClient.acceptInvite(token, password, username);
+      if (data.mfa_required || !data.token) {
+        return { error: null, mfa: true };
+      }
+      apiClient.setToken(data.token, data.refresh_token);
+      setSession(data.token);
+      await finishSignIn();
+      return { error: null };
+    } catch (error: any) {
+      return { error: { message: error.message } };
+    }
+  };
+
   const signOut = async () => {
     try {
       await apiClient.logout();
@@ -106,7 +190,7 @@ export function AuthProvider({ children }: { children: React.ReactNode }) {
   };
 
   return (
-    <AuthContext.Provider value={{ user, session, isAdmin, isApproved, isLoading, signIn, signUp, signOut }}>
+    <AuthContext.Provider value={{ user, session, isAdmin, isApproved, isLoading, signIn, verifyMfa, signInWithWebAuthn, confirmMfaEnrollment, finishSignIn, signUp, acceptInvite, signOut }}>
       {children}
     </AuthContext.Provider>
   );
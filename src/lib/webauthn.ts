@@ -0,0 +1,80 @@
+// Bridges the backend's WebAuthn ceremony options, which encode binary
+// fields as base64url, and navigator.credentials.
+
+const fromBase64url = (value: string): ArrayBuffer => {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+  const padded = base64 + '='.repeat((4 - (base64.length % 4)) % 4);
+  const binary = atob(padded);
+  const bytes = new Uint8Array(binary.length);
+  for (let i = 0; i < binary.length; i++) {
+    bytes[i] = binary.charCodeAt(i);
+  }
+  return bytes.buffer;
+};
+
+const toBase64url = (buffer: ArrayBuffer | null): string | undefined => {
+  if (!buffer) return undefined;
+  const bytes = new Uint8Array(buffer);
+  let binary = '';
+  for (const b of bytes) {
+    binary += String.fromCharCode(b);
+  }
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+};
+
+export const webauthnSupported = () =>
+  typeof window !== 'undefined' && typeof window.PublicKeyCredential !== 'undefined';
+
+// Runs navigator.credentials.create with options from a registration
+// ceremony and returns the credential as the backend expects it
+export async function createCredential(options: any) {
+  const publicKey = options.publicKey;
+  const credential = (await navigator.credentials.create({
+    publicKey: {
+      ...publicKey,
+      challenge: fromBase64url(publicKey.challenge),
+      user: { ...publicKey.user, id: fromBase64url(publicKey.user.id) },
+      excludeCredentials: (publicKey.excludeCredentials ?? []).map((c: any) => ({ ...c, id: fromBase64url(c.id) })),
+    },
+  })) as PublicKeyCredential | null;
+  if (!credential) throw new Error('No credential was created');
+
+  const response = credential.response as AuthenticatorAttestationResponse;
+  return {
+    id: credential.id,
+    rawId: toBase64url(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64url(response.clientDataJSON),
+      attestationObject: toBase64url(response.attestationObject),
+      transports: response.getTransports?.() ?? [],
+    },
+  };
+}
+
+// Runs navigator.credentials.get with options from an assertion ceremony
+// and returns the assertion as the backend expects it
+export async function getAssertion(options: any) {
+  const publicKey = options.publicKey;
+  const credential = (await navigator.credentials.get({
+    publicKey: {
+      ...publicKey,
+      challenge: fromBase64url(publicKey.challenge),
+      allowCredentials: (publicKey.allowCredentials ?? []).map((c: any) => ({ ...c, id: fromBase64url(c.id) })),
+    },
+  })) as PublicKeyCredential | null;
+  if (!credential) throw new Error('No security key or passkey was used');
+
+  const response = credential.response as AuthenticatorAssertionResponse;
+  return {
+    id: credential.id,
+    rawId: toBase64url(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64url(response.clientDataJSON),
+      authenticatorData: toBase64url(response.authenticatorData),
+      signature: toBase64url(response.signature),
+      userHandle: toBase64url(response.userHandle),
+    },
+  };
+}
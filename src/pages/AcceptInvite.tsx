@@ -0,0 +1,142 @@
+import { useEffect, useState } from "react";
+import { useNavigate, useSearchParams } from "react-router-dom";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from "@/components/ui/card";
+import { Server } from "lucide-react";
+import { toast } from "sonner";
+import { z } from "zod";
+import { useAuth } from "@/lib/auth";
+import { apiClient } from "@/lib/api";
+import { getSafeErrorMessage } from "@/lib/errorUtils";
+
+const inviteSchema = z.object({
+  username: z.string().trim().min(3, "Username must be at least 3 characters").max(32, "Username must be less than 32 characters"),
+  password: z.string().min(8, "Password must be at least 8 characters").max(72, "Password must be at most 72 characters"),
+});
+
+// Sign-up page for the link in an invitation email. The email address and
+// roles come from the invite.
+const AcceptInvite = () => {
+  const { acceptInvite } = useAuth();
+  const navigate = useNavigate();
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") ?? "";
+
+  const [invite, setInvite] = useState<{ email: string; roles: string[] } | null>(null);
+  const [error, setError] = useState<string | null>(null);
+  const [isLoading, setIsLoading] = useState(false);
+  const [data, setData] = useState({ username: "", password: "", confirm: "" });
+
+  useEffect(() => {
+    apiClient
+      .getInvite(token)
+      .then(setInvite)
+      .catch((e) => setError(e.message || "Invalid or expired invitation"));
+  }, [token]);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (data.password !== data.confirm) {
+      toast.error("Passwords do not match");
+      return;
+    }
+    setIsLoading(true);
+    try {
+      const validated = inviteSchema.parse(data);
+      const { error, mfa } = await acceptInvite(token, validated.password, validated.username);
+      if (error) {
+        toast.error(error.message || getSafeErrorMessage(error));
+      } else if (mfa) {
+        toast.success("Account created. Sign in to set up two-factor authentication.");
+        navigate("/auth");
+      } else {
+        toast.success("Account created successfully");
+      }
+    } catch (error) {
+      if (error instanceof z.ZodError) {
+        toast.error(error.errors[0].message);
+      } else {
+        toast.error("Failed to connect to server. Please ensure the backend is running.");
+      }
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen bg-background flex items-center justify-center p-6">
+      <Card className="w-full max-w-md border-border">
+        <CardHeader className="text-center">
+          <div className="flex justify-center mb-4">
+            <div className="p-3 rounded-lg bg-code-bg">
+              <Server className="h-10 w-10 text-primary" />
+            </div>
+          </div>
+          <CardTitle className="text-3xl">
+            <span className="text-gradient">CMDB</span> Dashboard
+          </CardTitle>
+          <CardDescription>
+            {invite ? `You have been invited as ${invite.email}` : "Accept invitation"}
+          </CardDescription>
+        </CardHeader>
+        <CardContent>
+          {error ? (
+            <div className="text-center">
+              <p className="mb-4">{error}</p>
+              <a href="/auth" className="text-primary underline">
+                Back to sign in
+              </a>
+            </div>
+          ) : !invite ? (
+            <p className="text-center text-muted-foreground">Loading invitation...</p>
+          ) : (
+            <form onSubmit={handleSubmit} className="space-y-4">
+              <div className="space-y-2">
+                <Label htmlFor="invite-username">Username</Label>
+                <Input
+                  id="invite-username"
+                  type="text"
+                  autoComplete="username"
+                  value={data.username}
+                  onChange={(e) => setData({ ...data, username: e.target.value })}
+                  required
+                />
+              </div>
+              <div className="space-y-2">
+                <Label htmlFor="invite-password">Password</Label>
+                <Input
+                  id="invite-password"
+                  type="password"
+                  autoComplete="new-password"
+                  placeholder="••••••••"
+                  value={data.password}
+                  onChange={(e) => setData({ ...data, password: e.target.value })}
+                  required
+                />
+              </div>
+              <div className="space-y-2">
+                <Label htmlFor="invite-confirm">Confirm password</Label>
+                <Input
+                  id="invite-confirm"
+                  type="password"
+                  autoComplete="new-password"
+                  placeholder="••••••••"
+                  value={data.confirm}
+                  onChange={(e) => setData({ ...data, confirm: e.target.value })}
+                  required
+                />
+              </div>
+              <Button type="submit" className="w-full" disabled={isLoading}>
+                {isLoading ? "Creating account..." : "Create Account"}
+              </Button>
+            </form>
+          )}
+        </CardContent>
+      </Card>
+    </div>
+  );
+};
+
+export default AcceptInvite;
@@ -1,5 +1,5 @@
 import { useState } from "react";
-import { useAuth } from "@/lib/auth";
+import { useAuth, type MfaChallenge } from "@/lib/auth";
 import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
 import { Label } from "@/components/ui/label";
@@ -7,24 +7,27 @@ import { Card, CardContent, CardDescription, CardHeader, CardTitle } from "@/com
 import { Tabs, TabsContent, TabsList, TabsTrigger } from "@/components/ui/tabs";
 import { Server } from "lucide-react";
 import { toast } from "sonner";
-import { useNavigate } from "react-router-dom";
+import { useLocation, useNavigate } from "react-router-dom";
 import { useEffect } from "react";
 import { z } from "zod";
 import { getSafeErrorMessage } from "@/lib/errorUtils";
+import { apiClient, oidcEnabled, oidcLoginUrl, webauthnEnabled } from "@/lib/api";
+import { webauthnSupported } from "@/lib/webauthn";
 
+// Directory (LDAP) users may sign in with their username instead of an email
 const loginSchema = z.object({
-  email: z.string().trim().email("Invalid email address").max(255, "Email must be less than 255 characters"),
+  email: z.string().trim().min(1, "Email or username is required").max(255, "Email must be less than 255 characters"),
   password: z.string().min(1, "Password is required"),
 });
 
 const signupSchema = z.object({
   email: z.string().trim().email("Invalid email address").max(255, "Email must be less than 255 characters"),
-  password: z.string().min(8, "Password must be at least 8 characters").max(128, "Password must be less than 128 characters"),
+  password: z.string().min(8, "Password must be at least 8 characters").max(72, "Password must be at most 72 characters"),
   username: z.string().trim().min(3, "Username must be at least 3 characters").max(32, "Username must be less than 32 characters"),
 });
 
 const Auth = () => {
-  const { signIn, signUp, user } = useAuth();
+  const { signIn, verifyMfa, signInWithWebAuthn, confirmMfaEnrollment, finishSignIn, signUp, user } = useAuth();
   const navigate = useNavigate();
   const [isLoading, setIsLoading] = useState(false);
 
@@ -33,6 +36,14 @@ const Auth = () => {
     password: "",
   });
 
+  // Second login step for users with MFA, also reached from a single
+  // sign-on login
+  const location = useLocation();
+  const [mfa, setMfa] = useState<MfaChallenge | null>((location.state as { mfa?: MfaChallenge } | null)?.mfa ?? null);
+  const [mfaCode, setMfaCode] = useState("");
+  const [enrollment, setEnrollment] = useState<{ secret: string; otpauth_uri: string } | null>(null);
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+
   const [signupData, setSignupData] = useState({
     email: "",
     password: "",
@@ -45,18 +56,33 @@ const Auth = () => {
     }
   }, [user, navigate]);
 
+  useEffect(() => {
+    if (mfa && !mfa.enrolled && !enrollment) {
+      apiClient.startMfaEnrollment(mfa.token).then(setEnrollment).catch((error) => {
+        toast.error(error.message || getSafeErrorMessage(error));
+        setMfa(null);
+      });
+    }
+  }, []); // only for a challenge passed in from the single sign-on callback
+
   const handleLogin = async (e: React.FormEvent) => {
     e.preventDefault();
     setIsLoading(true);
     
     try {
       const validatedData = loginSchema.parse(loginData);
-      const { error } = await signIn(validatedData.email, validatedData.password);
+      const { error, mfa } = await signIn(validatedData.email, validatedData.password);
       
       if (error) {
         console.error("Login error:", error);
         const errorMessage = error.message || getSafeErrorMessage(error);
         toast.error(errorMessage);
+      } else if (mfa) {
+        setMfa(mfa);
+        setMfaCode("");
+        if (!mfa.enrolled) {
+          setEnrollment(await apiClient.startMfaEnrollment(mfa.token));
+        }
       } else {
         toast.success("Signed in successfully");
       }
@@ -72,13 +98,81 @@ const Auth = () => {
     }
   };
 
+  const handleMfa = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!mfa) return;
+    setIsLoading(true);
+
+    try {
+      if (mfa.enrolled) {
+        const { error } = await verifyMfa(mfa.token, mfaCode.trim());
+        if (error) {
+          toast.error(error.message || getSafeErrorMessage(error));
+        } else {
+          toast.success("Signed in successfully");
+        }
+      } else {
+        const { error, recoveryCodes } = await confirmMfaEnrollment(mfa.token, mfaCode.trim());
+        if (error) {
+          toast.error(error.message || getSafeErrorMessage(error));
+        } else {
+          setRecoveryCodes(recoveryCodes ?? []);
+        }
+      }
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  // Passkey login when no MFA challenge is pending, else the second factor
+  const handleWebAuthn = async () => {
+    setIsLoading(true);
+    try {
+      const { error } = await signInWithWebAuthn(mfa?.token);
+      if (error) {
+        toast.error(error.message || getSafeErrorMessage(error));
+      } else {
+        toast.success("Signed in successfully");
+      }
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  const canUseWebAuthn = webauthnEnabled && webauthnSupported();
+
+  const cancelMfa = () => {
+    setMfa(null);
+    setEnrollment(null);
+    setMfaCode("");
+  };
+
+  // Emails a reset link to the address entered in the login form. The
+  // answer is the same whether or not an account exists.
+  const handleForgotPassword = async () => {
+    const email = loginData.email.trim();
+    if (!email.includes("@")) {
+      toast.error("Enter your email address first");
+      return;
+    }
+    setIsLoading(true);
+    try {
+      const { message } = await apiClient.forgotPassword(email);
+      toast.success(message);
+    } catch (error: any) {
+      toast.error(error.message || getSafeErrorMessage(error));
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
   const handleSignup = async (e: React.FormEvent) => {
     e.preventDefault();
     setIsLoading(true);
     
     try {
       const validatedData = signupSchema.parse(signupData);
-      const { error } = await signUp(
+      const { error, pending } = await signUp(
         validatedData.email,
         validatedData.password,
         validatedData.username
@@ -88,6 +182,8 @@ const Auth = () => {
         console.error("Signup error:", error);
         const errorMessage = error.message || getSafeErrorMessage(error);
         toast.error(errorMessage);
+      } else if (pending) {
+        toast.success("Account created. An administrator must approve it before you can sign in.");
       } else {
         toast.success("Account created successfully");
       }
@@ -120,6 +216,64 @@ const Auth = () => {
           </CardDescription>
         </CardHeader>
         <CardContent>
+          {recoveryCodes ? (
+            <div className="space-y-4">
+              <p className="text-sm text-muted-foreground">
+                Two-factor authentication is enabled. Store these recovery codes somewhere safe; each
+                can be used once to sign in if you lose your authenticator. They will not be shown again.
+              </p>
+              <pre className="rounded-md bg-code-bg p-3 text-sm font-mono">{recoveryCodes.join("\n")}</pre>
+              <Button className="w-full" onClick={() => finishSignIn()}>
+                Continue
+              </Button>
+            </div>
+          ) : mfa ? (
+            <form onSubmit={handleMfa} className="space-y-4">
+              {enrollment ? (
+                <div className="space-y-2 text-sm">
+                  <p className="text-muted-foreground">
+                    Your role requires two-factor authentication. Add this key to your authenticator app,
+                    then enter the code it shows.
+                  </p>
+                  <code className="block break-all rounded-md bg-code-bg p-3 font-mono">{enrollment.secret}</code>
+                  <a href={enrollment.otpauth_uri} className="text-primary underline">
+                    Open in authenticator app
+                  </a>
+                </div>
+              ) : (
+                <p className="text-sm text-muted-foreground">
+                  {mfa.methods.includes("totp")
+                    ? "Enter the code from your authenticator app, or one of your recovery codes."
+                    : "Use your security key or passkey to continue."}
+                </p>
+              )}
+              <div className="space-y-2">
+                <Label htmlFor="mfa-code">Verification code</Label>
+                <Input
+                  id="mfa-code"
+                  type="text"
+                  inputMode={enrollment ? "numeric" : "text"}
+                  autoComplete="one-time-code"
+                  placeholder="123456"
+                  value={mfaCode}
+                  onChange={(e) => setMfaCode(e.target.value)}
+                  autoFocus
+                  required
+                />
+              </div>
+              <Button type="submit" className="w-full" disabled={isLoading}>
+                {isLoading ? "Verifying..." : "Verify"}
+              </Button>
+              {canUseWebAuthn && mfa.methods.includes("webauthn") && (
+                <Button type="button" variant="outline" className="w-full" onClick={handleWebAuthn} disabled={isLoading}>
+                  Use a security key
+                </Button>
+              )}
+              <Button type="button" variant="ghost" className="w-full" onClick={cancelMfa}>
+                Back
+              </Button>
+            </form>
+          ) : (
           <Tabs defaultValue="login" className="w-full">
             <TabsList className="grid w-full grid-cols-2">
               <TabsTrigger value="login">Login</TabsTrigger>
@@ -129,10 +283,11 @@ const Auth = () => {
             <TabsContent value="login">
               <form onSubmit={handleLogin} className="space-y-4">
                 <div className="space-y-2">
-                  <Label htmlFor="login-email">Email</Label>
+                  <Label htmlFor="login-email">Email or username</Label>
                   <Input
                     id="login-email"
-                    type="email"
+                    type="text"
+                    autoComplete="username"
                     placeholder="admin@example.com"
                     value={loginData.email}
                     onChange={(e) =>
@@ -157,6 +312,19 @@ const Auth = () => {
                 <Button type="submit" className="w-full" disabled={isLoading}>
                   {isLoading ? "Signing in..." : "Sign In"}
                 </Button>
+                {canUseWebAuthn && (
+                  <Button type="button" variant="outline" className="w-full" onClick={handleWebAuthn} disabled={isLoading}>
+                    Sign in with a passkey
+                  </Button>
+                )}
+                {oidcEnabled && (
+                  <Button asChild variant="outline" className="w-full">
+                    <a href={oidcLoginUrl}>Sign in with SSO</a>
+                  </Button>
+                )}
+                <Button type="button" variant="link" className="w-full" onClick={handleForgotPassword} disabled={isLoading}>
+                  Forgot password?
+                </Button>
               </form>
             </TabsContent>
             
@@ -207,6 +375,7 @@ const Auth = () => {
               </form>
             </TabsContent>
           </Tabs>
+          )}
         </CardContent>
       </Card>
     </div>
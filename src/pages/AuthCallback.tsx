@@ -0,0 +1,66 @@
+import { useEffect, useState } from "react";
+import { useNavigate } from "react-router-dom";
+import { apiClient } from "@/lib/api";
+
+const errorMessages: Record<string, string> = {
+  login_expired: "The sign-in took too long. Please try again.",
+  login_failed: "The identity provider did not confirm your sign-in.",
+  email_taken: "Your email address belongs to an account that cannot be linked. Contact an administrator.",
+  pending_approval: "Your account is pending approval.",
+};
+
+// Receives the outcome of a single sign-on login from the backend in the
+// URL fragment, so the tokens never reach server logs.
+const AuthCallback = () => {
+  const [error, setError] = useState<string | null>(null);
+  const navigate = useNavigate();
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    window.history.replaceState(null, "", window.location.pathname);
+
+    // Users whose role requires MFA complete the challenge on the login page
+    const mfaToken = params.get("mfa_token");
+    if (mfaToken) {
+      const methods = params.get("methods");
+      navigate("/auth", {
+        replace: true,
+        state: {
+          mfa: { token: mfaToken, enrolled: params.get("mfa_enrolled") === "true", methods: methods ? methods.split(",") : [] },
+        },
+      });
+      return;
+    }
+
+    const token = params.get("token");
+    if (!token) {
+      const code = params.get("error") ?? "";
+      setError(errorMessages[code] ?? "Sign-in failed. Please try again.");
+      return;
+    }
+
+    apiClient.setToken(token, params.get("refresh_token"));
+    const redirect = params.get("redirect");
+    // Reload so the auth provider picks up the new session
+    window.location.replace(redirect && redirect.startsWith("/") && !redirect.startsWith("//") ? redirect : "/");
+  }, [navigate]);
+
+  return (
+    <div className="min-h-screen bg-background flex items-center justify-center p-6">
+      <div className="text-center">
+        {error ? (
+          <>
+            <p className="mb-4 text-xl">{error}</p>
+            <a href="/auth" className="text-primary underline">
+              Back to sign in
+            </a>
+          </>
+        ) : (
+          <p className="text-muted-foreground">Signing in...</p>
+        )}
+      </div>
+    </div>
+  );
+};
+
+export default AuthCallback;
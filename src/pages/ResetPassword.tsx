@@ -0,0 +1,100 @@
+import { useState } from "react";
+import { useNavigate, useSearchParams } from "react-router-dom";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from "@/components/ui/card";
+import { Server } from "lucide-react";
+import { toast } from "sonner";
+import { apiClient } from "@/lib/api";
+import { getSafeErrorMessage } from "@/lib/errorUtils";
+
+// Page for the link in a password reset email. Resetting signs the user out
+// everywhere, so they sign in again afterwards.
+const ResetPassword = () => {
+  const navigate = useNavigate();
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") ?? "";
+
+  const [isLoading, setIsLoading] = useState(false);
+  const [data, setData] = useState({ password: "", confirm: "" });
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (data.password !== data.confirm) {
+      toast.error("Passwords do not match");
+      return;
+    }
+    setIsLoading(true);
+    try {
+      const { message } = await apiClient.resetPassword(token, data.password);
+      apiClient.setToken(null);
+      toast.success(message);
+      navigate("/auth");
+    } catch (error: any) {
+      toast.error(error.message || getSafeErrorMessage(error));
+    } finally {
+      setIsLoading(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen bg-background flex items-center justify-center p-6">
+      <Card className="w-full max-w-md border-border">
+        <CardHeader className="text-center">
+          <div className="flex justify-center mb-4">
+            <div className="p-3 rounded-lg bg-code-bg">
+              <Server className="h-10 w-10 text-primary" />
+            </div>
+          </div>
+          <CardTitle className="text-3xl">
+            <span className="text-gradient">CMDB</span> Dashboard
+          </CardTitle>
+          <CardDescription>Choose a new password</CardDescription>
+        </CardHeader>
+        <CardContent>
+          {!token ? (
+            <div className="text-center">
+              <p className="mb-4">This reset link is incomplete.</p>
+              <a href="/auth" className="text-primary underline">
+                Back to sign in
+              </a>
+            </div>
+          ) : (
+            <form onSubmit={handleSubmit} className="space-y-4">
+              <div className="space-y-2">
+                <Label htmlFor="reset-password">New password</Label>
+                <Input
+                  id="reset-password"
+                  type="password"
+                  autoComplete="new-password"
+                  placeholder="••••••••"
+                  value={data.password}
+                  onChange={(e) => setData({ ...data, password: e.target.value })}
+                  required
+                />
+              </div>
+              <div className="space-y-2">
+                <Label htmlFor="reset-confirm">Confirm password</Label>
+                <Input
+                  id="reset-confirm"
+                  type="password"
+                  autoComplete="new-password"
+                  placeholder="••••••••"
+                  value={data.confirm}
+                  onChange={(e) => setData({ ...data, confirm: e.target.value })}
+                  required
+                />
+              </div>
+              <Button type="submit" className="w-full" disabled={isLoading}>
+                {isLoading ? "Saving..." : "Set Password"}
+              </Button>
+            </form>
+          )}
+        </CardContent>
+      </Card>
+    </div>
+  );
+};
+
+export default ResetPassword;